@@ -1,19 +1,38 @@
 package main
 
 import (
+	"bufio"
 	"bytes"
+	"context"
+	"encoding/gob"
+	"encoding/json"
+	"encoding/xml"
 	"flag"
 	"fmt"
+	"io"
+	"log/slog"
+	"math"
+	"net/http"
+	"net/url"
 	"os"
 	"os/exec"
 	"path/filepath"
+	"regexp"
 	"sort"
+	"strconv"
 	"strings"
+	"sync"
+	"syscall"
 	"time"
+	"unicode"
+	"unicode/utf8"
 
+	"github.com/BurntSushi/toml"
+	"github.com/charmbracelet/bubbles/spinner"
 	"github.com/charmbracelet/bubbles/textinput"
 	tea "github.com/charmbracelet/bubbletea"
 	"github.com/charmbracelet/lipgloss"
+	"github.com/muesli/termenv"
 )
 
 // View modes for the TUI application
@@ -24,6 +43,7 @@ const (
 	modeInstalled
 	modeUninstall
 	modeUpdate
+	modeHistory
 )
 
 // Confirmation operation types
@@ -35,6 +55,15 @@ const (
 	confirmUpdate
 	confirmCleanCache
 	confirmRemoveOrphans
+	confirmRebuildForeign
+	confirmRemoveLock
+	confirmCleanup
+	confirmCleanCacheDir
+	confirmSyncFiles
+	confirmInstallReason
+	confirmQueue
+	confirmSyncDB
+	confirmIntegrityCheck
 )
 
 // Theme type for TUI theming
@@ -53,6 +82,11 @@ const (
 	themeSolarizedDark
 	themeTokyonightNight
 	themeTokyonightStorm
+	themeNord
+	themeGruvboxLight
+	themeSolarizedLight
+	themeCatppuccinLatte
+	themeTokyonightDay
 )
 
 // Theme holds all color definitions for the UI
@@ -93,6 +127,30 @@ type Theme struct {
 
 // Available themes
 var themes = map[themeType]Theme{
+	themeBasic: {
+		Name:             "Basic",
+		BorderColor:      lipgloss.Color("8"),  // Bright black
+		SelectedColor:    lipgloss.Color("5"),  // Magenta
+		TextColor:        lipgloss.Color("7"),  // White
+		SubtleColor:      lipgloss.Color("8"),  // Bright black
+		TitleColor:       lipgloss.Color("3"),  // Yellow
+		InstallColor:     lipgloss.Color("4"),  // Blue
+		InstalledColor:   lipgloss.Color("13"), // Bright magenta
+		UninstallColor:   lipgloss.Color("1"),  // Red
+		UpdateColor:      lipgloss.Color("2"),  // Green
+		CoreColor:        lipgloss.Color("2"),  // Green
+		ExtraColor:       lipgloss.Color("4"),  // Blue
+		MultilibColor:    lipgloss.Color("11"), // Bright yellow
+		AurColor:         lipgloss.Color("5"),  // Magenta
+		SuccessColor:     lipgloss.Color("2"),  // Green
+		WarningColor:     lipgloss.Color("3"),  // Yellow
+		ErrorColor:       lipgloss.Color("1"),  // Red
+		HighlightColor:   lipgloss.Color("3"),  // Yellow
+		DashboardLabel:   lipgloss.Color("7"),  // White
+		DashboardValue:   lipgloss.Color("6"),  // Cyan
+		DashboardWarning: lipgloss.Color("1"),  // Red
+		DashboardDesc:    lipgloss.Color("8"),  // Bright black
+	},
 	themeCatppuccinFrappe: {
 		Name:             "Catppuccin Frappe",
 		BorderColor:      lipgloss.Color("#737994"), // Overlay0
@@ -357,6 +415,126 @@ var themes = map[themeType]Theme{
 		DashboardWarning: lipgloss.Color("#f7768e"), // Red
 		DashboardDesc:    lipgloss.Color("#a9b1d6"), // Subtle
 	},
+	themeNord: {
+		Name:             "Nord",
+		BorderColor:      lipgloss.Color("#4c566a"), // nord3
+		SelectedColor:    lipgloss.Color("#b48ead"), // nord15
+		TextColor:        lipgloss.Color("#eceff4"), // nord6
+		SubtleColor:      lipgloss.Color("#4c566a"), // nord3
+		TitleColor:       lipgloss.Color("#ebcb8b"), // nord13
+		InstallColor:     lipgloss.Color("#81a1c1"), // nord9
+		InstalledColor:   lipgloss.Color("#b48ead"), // nord15
+		UninstallColor:   lipgloss.Color("#bf616a"), // nord11
+		UpdateColor:      lipgloss.Color("#a3be8c"), // nord14
+		CoreColor:        lipgloss.Color("#a3be8c"), // nord14
+		ExtraColor:       lipgloss.Color("#81a1c1"), // nord9
+		MultilibColor:    lipgloss.Color("#d08770"), // nord12
+		AurColor:         lipgloss.Color("#b48ead"), // nord15
+		SuccessColor:     lipgloss.Color("#a3be8c"), // nord14
+		WarningColor:     lipgloss.Color("#ebcb8b"), // nord13
+		ErrorColor:       lipgloss.Color("#bf616a"), // nord11
+		HighlightColor:   lipgloss.Color("#ebcb8b"), // nord13
+		DashboardLabel:   lipgloss.Color("#eceff4"), // nord6
+		DashboardValue:   lipgloss.Color("#88c0d0"), // nord8
+		DashboardWarning: lipgloss.Color("#bf616a"), // nord11
+		DashboardDesc:    lipgloss.Color("#d8dee9"), // nord4
+	},
+	themeGruvboxLight: {
+		Name:             "Gruvbox Light",
+		BorderColor:      lipgloss.Color("#928374"), // Gray
+		SelectedColor:    lipgloss.Color("#8f3f71"), // Purple
+		TextColor:        lipgloss.Color("#3c3836"), // fg1
+		SubtleColor:      lipgloss.Color("#928374"), // Gray
+		TitleColor:       lipgloss.Color("#b57614"), // Yellow
+		InstallColor:     lipgloss.Color("#076678"), // Blue
+		InstalledColor:   lipgloss.Color("#8f3f71"), // Purple
+		UninstallColor:   lipgloss.Color("#9d0006"), // Red
+		UpdateColor:      lipgloss.Color("#79740e"), // Green
+		CoreColor:        lipgloss.Color("#79740e"), // Green
+		ExtraColor:       lipgloss.Color("#076678"), // Blue
+		MultilibColor:    lipgloss.Color("#af3a03"), // Orange
+		AurColor:         lipgloss.Color("#8f3f71"), // Purple
+		SuccessColor:     lipgloss.Color("#79740e"), // Green
+		WarningColor:     lipgloss.Color("#b57614"), // Yellow
+		ErrorColor:       lipgloss.Color("#9d0006"), // Red
+		HighlightColor:   lipgloss.Color("#b57614"), // Yellow
+		DashboardLabel:   lipgloss.Color("#3c3836"), // fg1
+		DashboardValue:   lipgloss.Color("#427b58"), // Aqua
+		DashboardWarning: lipgloss.Color("#9d0006"), // Red
+		DashboardDesc:    lipgloss.Color("#7c6f64"), // fg4
+	},
+	themeSolarizedLight: {
+		Name:             "Solarized Light",
+		BorderColor:      lipgloss.Color("#93a1a1"), // base1
+		SelectedColor:    lipgloss.Color("#6c71c4"), // Violet
+		TextColor:        lipgloss.Color("#586e75"), // base01
+		SubtleColor:      lipgloss.Color("#93a1a1"), // base1
+		TitleColor:       lipgloss.Color("#cb4b16"), // Orange
+		InstallColor:     lipgloss.Color("#268bd2"), // Blue
+		InstalledColor:   lipgloss.Color("#d33682"), // Magenta
+		UninstallColor:   lipgloss.Color("#dc322f"), // Red
+		UpdateColor:      lipgloss.Color("#859900"), // Green
+		CoreColor:        lipgloss.Color("#5f7a00"), // Green, darkened for contrast on base3
+		ExtraColor:       lipgloss.Color("#268bd2"), // Blue
+		MultilibColor:    lipgloss.Color("#cb4b16"), // Orange
+		AurColor:         lipgloss.Color("#6c71c4"), // Violet
+		SuccessColor:     lipgloss.Color("#5f7a00"), // Green, darkened for contrast on base3
+		WarningColor:     lipgloss.Color("#a36a00"), // Yellow, darkened for contrast on base3
+		ErrorColor:       lipgloss.Color("#dc322f"), // Red
+		HighlightColor:   lipgloss.Color("#a36a00"), // Yellow, darkened for contrast on base3
+		DashboardLabel:   lipgloss.Color("#586e75"), // base01
+		DashboardValue:   lipgloss.Color("#1d7a73"), // Cyan, darkened for contrast on base3
+		DashboardWarning: lipgloss.Color("#dc322f"), // Red
+		DashboardDesc:    lipgloss.Color("#657b83"), // base00
+	},
+	themeCatppuccinLatte: {
+		Name:             "Catppuccin Latte",
+		BorderColor:      lipgloss.Color("#9ca0b0"), // Overlay0
+		SelectedColor:    lipgloss.Color("#8839ef"), // Mauve
+		TextColor:        lipgloss.Color("#4c4f69"), // Text
+		SubtleColor:      lipgloss.Color("#9ca0b0"), // Overlay0
+		TitleColor:       lipgloss.Color("#df8e1d"), // Yellow
+		InstallColor:     lipgloss.Color("#1e66f5"), // Blue
+		InstalledColor:   lipgloss.Color("#ea76cb"), // Pink
+		UninstallColor:   lipgloss.Color("#d20f39"), // Red
+		UpdateColor:      lipgloss.Color("#40a02b"), // Green
+		CoreColor:        lipgloss.Color("#40a02b"), // Green
+		ExtraColor:       lipgloss.Color("#1e66f5"), // Blue
+		MultilibColor:    lipgloss.Color("#fe640b"), // Peach
+		AurColor:         lipgloss.Color("#8839ef"), // Mauve
+		SuccessColor:     lipgloss.Color("#2e7d1f"), // Green, darkened for contrast on Base
+		WarningColor:     lipgloss.Color("#b35c00"), // Peach, darkened for contrast on Base
+		ErrorColor:       lipgloss.Color("#d20f39"), // Red
+		HighlightColor:   lipgloss.Color("#b35c00"), // Peach, darkened for contrast on Base
+		DashboardLabel:   lipgloss.Color("#4c4f69"), // Text
+		DashboardValue:   lipgloss.Color("#04a5e5"), // Sky
+		DashboardWarning: lipgloss.Color("#d20f39"), // Red
+		DashboardDesc:    lipgloss.Color("#6c6f85"), // Subtext0
+	},
+	themeTokyonightDay: {
+		Name:             "Tokyonight Day",
+		BorderColor:      lipgloss.Color("#848cb5"), // Comment
+		SelectedColor:    lipgloss.Color("#9854f1"), // Purple
+		TextColor:        lipgloss.Color("#3760bf"), // Foreground
+		SubtleColor:      lipgloss.Color("#848cb5"), // Comment
+		TitleColor:       lipgloss.Color("#8c6c3e"), // Yellow
+		InstallColor:     lipgloss.Color("#2e7de9"), // Blue
+		InstalledColor:   lipgloss.Color("#9854f1"), // Purple
+		UninstallColor:   lipgloss.Color("#f52a65"), // Red
+		UpdateColor:      lipgloss.Color("#587539"), // Green
+		CoreColor:        lipgloss.Color("#587539"), // Green
+		ExtraColor:       lipgloss.Color("#2e7de9"), // Blue
+		MultilibColor:    lipgloss.Color("#b15c00"), // Orange
+		AurColor:         lipgloss.Color("#9854f1"), // Purple
+		SuccessColor:     lipgloss.Color("#587539"), // Green
+		WarningColor:     lipgloss.Color("#8c6c3e"), // Yellow
+		ErrorColor:       lipgloss.Color("#f52a65"), // Red
+		HighlightColor:   lipgloss.Color("#8c6c3e"), // Yellow
+		DashboardLabel:   lipgloss.Color("#3760bf"), // Foreground
+		DashboardValue:   lipgloss.Color("#007197"), // Cyan
+		DashboardWarning: lipgloss.Color("#c4194a"), // Red, darkened for contrast on Background
+		DashboardDesc:    lipgloss.Color("#6172b0"), // Subtle
+	},
 }
 
 // Current active theme
@@ -365,6 +543,12 @@ var currentTheme = themes[themeCatppuccinMocha]
 // setTheme changes the active theme and updates all styles
 func setTheme(t themeType) {
 	if theme, ok := themes[t]; ok {
+		if ansi16Colors && !theme.fitsANSI16() {
+			theme = themes[themeBasic]
+		}
+		if quantizeColors {
+			theme = quantizeTheme(theme)
+		}
 		currentTheme = theme
 		// Update all style variables
 		defaultBorderColor = currentTheme.BorderColor
@@ -420,14 +604,17 @@ func setTheme(t themeType) {
 	}
 }
 
-// getThemeByName returns a theme type by its name (case-insensitive)
+// getThemeByName returns a theme type by its name (case-insensitive). A family
+// name on its own, such as "gruvbox", picks the family's dark variant.
 func getThemeByName(name string) (themeType, bool) {
 	nameLower := strings.ToLower(name)
-	for t, theme := range themes {
-		if strings.ToLower(theme.Name) == nameLower ||
-			strings.ToLower(strings.ReplaceAll(theme.Name, " ", "-")) == nameLower ||
-			strings.ToLower(strings.ReplaceAll(theme.Name, " ", "")) == nameLower {
-			return t, true
+	for _, candidate := range []string{nameLower, nameLower + " dark", nameLower + "-dark", nameLower + "dark"} {
+		for t, theme := range themes {
+			if strings.ToLower(theme.Name) == candidate ||
+				strings.ToLower(strings.ReplaceAll(theme.Name, " ", "-")) == candidate ||
+				strings.ToLower(strings.ReplaceAll(theme.Name, " ", "")) == candidate {
+				return t, true
+			}
 		}
 	}
 	return themeBasic, false
@@ -443,3821 +630,17961 @@ func listThemes() []string {
 	return names
 }
 
-// UI configuration constants
-const (
-	minSearchQueryLen       = 2
-	textInputCharLimit      = 100
-	textInputDefaultWidth   = 50
-	packageInfoDebounceTime = 150 * time.Millisecond
-)
+// quantizeColors is set when the terminal only supports 256 colors; setTheme
+// then maps every hex color to its nearest palette entry instead of leaving
+// the approximation to the renderer
+var quantizeColors bool
 
-// isValidPackageName checks if a package name contains only safe characters.
-// Valid package names contain only alphanumeric, @, ., _, +, and - characters.
-// This prevents command injection through malicious package names.
-func isValidPackageName(name string) bool {
-	if name == "" {
-		return false
-	}
-	for _, r := range name {
-		if !((r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z') ||
-			(r >= '0' && r <= '9') || r == '@' || r == '.' ||
-			r == '_' || r == '+' || r == '-') {
+// ansi16Colors is set when the terminal only supports the 16 ANSI colors;
+// setTheme then falls back to the Basic theme for themes that need more
+var ansi16Colors bool
+
+// fitsANSI16 reports whether every color of the theme is one of the 16 ANSI
+// palette entries
+func (t Theme) fitsANSI16() bool {
+	for _, c := range t.colors() {
+		if n, err := strconv.Atoi(string(*c)); err != nil || n < 0 || n > 15 {
 			return false
 		}
 	}
 	return true
 }
 
-// sanitizePackageNames filters a list of package names to only include valid ones.
-// Returns the filtered list and a boolean indicating if all names were valid.
-func sanitizePackageNames(names []string) ([]string, bool) {
-	var valid []string
-	allValid := true
-	for _, name := range names {
-		if isValidPackageName(name) {
-			valid = append(valid, name)
-		} else {
-			allValid = false
+// detectColorProfile reports the terminal's color support. COLORTERM and a
+// "-direct" TERM are checked first, then the terminfo RGB and Tc capabilities,
+// before falling back to lipgloss's own detection.
+func detectColorProfile() termenv.Profile {
+	switch strings.ToLower(os.Getenv("COLORTERM")) {
+	case "truecolor", "24bit":
+		return termenv.TrueColor
+	}
+	term := os.Getenv("TERM")
+	if strings.Contains(term, "direct") || strings.Contains(term, "truecolor") {
+		return termenv.TrueColor
+	}
+	if term != "" {
+		infocmp := exec.Command("infocmp", "-x", term)
+		start := time.Now()
+		out, err := infocmp.Output()
+		logExec(infocmp, start, err)
+		if err == nil {
+			for _, field := range strings.FieldsFunc(string(out), func(r rune) bool { return r == ',' || r == '\n' }) {
+				field = strings.TrimSpace(field)
+				if field == "RGB" || field == "Tc" || strings.HasPrefix(field, "RGB=") {
+					return termenv.TrueColor
+				}
+			}
 		}
 	}
-	return valid, allValid
+	return lipgloss.ColorProfile()
 }
 
-// Package represents a package with its source and name
-type Package struct {
-	Source      string // core, extra, multilib, aur
-	Name        string
-	Version     string
-	Description string
-	Installed   bool
-	Explicit    bool // Explicitly installed (not a dependency)
-	Orphan      bool // Orphan package (no longer required)
+// colors returns pointers to every color in the theme
+func (t *Theme) colors() []*lipgloss.Color {
+	return []*lipgloss.Color{
+		&t.BorderColor, &t.SelectedColor, &t.TextColor, &t.SubtleColor, &t.TitleColor,
+		&t.InstallColor, &t.InstalledColor, &t.UninstallColor, &t.UpdateColor,
+		&t.CoreColor, &t.ExtraColor, &t.MultilibColor, &t.AurColor,
+		&t.SuccessColor, &t.WarningColor, &t.ErrorColor, &t.HighlightColor,
+		&t.DashboardLabel, &t.DashboardValue, &t.DashboardWarning, &t.DashboardDesc,
+	}
 }
 
-func (p Package) String() string {
-	return fmt.Sprintf("%s/%s", p.Source, p.Name)
+// themeColorNames names the Theme colors in colors() order; custom theme
+// files set each color by its field name
+var themeColorNames = []string{
+	"BorderColor", "SelectedColor", "TextColor", "SubtleColor", "TitleColor",
+	"InstallColor", "InstalledColor", "UninstallColor", "UpdateColor",
+	"CoreColor", "ExtraColor", "MultilibColor", "AurColor",
+	"SuccessColor", "WarningColor", "ErrorColor", "HighlightColor",
+	"DashboardLabel", "DashboardValue", "DashboardWarning", "DashboardDesc",
 }
 
-// fuzzyFilter filters packages using fzf for fuzzy matching.
-// Returns filtered packages sorted by fzf's relevance ranking.
-func fuzzyFilter(packages []Package, query string) []Package {
-	if query == "" || len(packages) == 0 {
-		return packages
+// themesDir returns the directory custom themes are loaded from (~/.config/gaur/themes)
+func themesDir() string {
+	return filepath.Join(filepath.Dir(configFilePath()), "themes")
+}
+
+// parseThemeFile reads a custom theme file. Every color must be given, as a
+// #rrggbb hex string or a 256-color palette index; the theme is named after
+// the file unless it sets Name.
+func parseThemeFile(path string) (Theme, error) {
+	var values map[string]interface{}
+	if _, err := toml.DecodeFile(path, &values); err != nil {
+		return Theme{}, err
+	}
+	theme := Theme{Name: strings.TrimSuffix(filepath.Base(path), ".toml")}
+	if name, ok := values["Name"].(string); ok && name != "" {
+		theme.Name = name
+		delete(values, "Name")
 	}
 
-	// Build input for fzf: one package name per line with index
-	var input strings.Builder
-	for i, pkg := range packages {
-		input.WriteString(fmt.Sprintf("%d\t%s\n", i, pkg.Name))
+	var missing []string
+	for i, c := range theme.colors() {
+		key := themeColorNames[i]
+		value, ok := values[key]
+		if !ok {
+			missing = append(missing, key)
+			continue
+		}
+		delete(values, key)
+		color, ok := parseThemeColor(value)
+		if !ok {
+			return Theme{}, fmt.Errorf("%s: %v is not a #rrggbb color or a 0-255 palette index", key, value)
+		}
+		*c = color
 	}
 
-	// Use fzf --filter for non-interactive fuzzy filtering
-	// -d '\t' -n2: only match on second field (package name), not the index
-	// --tiebreak=begin,length: prefer matches at start and shorter names
-	cmd := exec.Command("fzf", "--filter", query, "-d", "\t", "-n2", "--tiebreak=begin,length")
-	cmd.Stdin = strings.NewReader(input.String())
-	var stdout bytes.Buffer
-	cmd.Stdout = &stdout
-	_ = cmd.Run() // fzf returns error if no matches, that's ok
+	var problems []string
+	if len(missing) > 0 {
+		problems = append(problems, "missing keys: "+strings.Join(missing, ", "))
+	}
+	if len(values) > 0 {
+		unknown := make([]string, 0, len(values))
+		for key := range values {
+			unknown = append(unknown, key)
+		}
+		sort.Strings(unknown)
+		problems = append(problems, "unknown keys: "+strings.Join(unknown, ", "))
+	}
+	if len(problems) > 0 {
+		return Theme{}, fmt.Errorf("%s", strings.Join(problems, "; "))
+	}
+	return theme, nil
+}
 
-	// Parse output and rebuild package list
-	var result []Package
-	lines := strings.Split(strings.TrimSpace(stdout.String()), "\n")
-	for _, line := range lines {
-		if line == "" {
-			continue
+// parseThemeColor accepts a #rrggbb string or a 256-color palette index,
+// given either as a number or a string
+func parseThemeColor(value interface{}) (lipgloss.Color, bool) {
+	switch v := value.(type) {
+	case string:
+		if _, _, _, ok := parseHexColor(v); ok {
+			return lipgloss.Color(v), true
 		}
-		parts := strings.SplitN(line, "\t", 2)
-		if len(parts) >= 1 {
-			var idx int
-			if _, err := fmt.Sscanf(parts[0], "%d", &idx); err == nil && idx >= 0 && idx < len(packages) {
-				result = append(result, packages[idx])
-			}
+		if n, err := strconv.Atoi(v); err == nil && n >= 0 && n <= 255 {
+			return lipgloss.Color(v), true
+		}
+	case int64:
+		if v >= 0 && v <= 255 {
+			return lipgloss.Color(strconv.FormatInt(v, 10)), true
 		}
 	}
+	return "", false
+}
 
-	// If fzf found nothing, fall back to substring match
-	if len(result) == 0 {
-		queryLower := strings.ToLower(query)
-		for _, pkg := range packages {
-			if strings.Contains(strings.ToLower(pkg.Name), queryLower) {
-				result = append(result, pkg)
-			}
+// loadCustomThemes adds every theme file in dir to the themes map. A custom
+// theme with the name of a built-in one replaces it. A missing directory is
+// not an error.
+func loadCustomThemes(dir string) error {
+	paths, err := filepath.Glob(filepath.Join(dir, "*.toml"))
+	if err != nil {
+		return err
+	}
+	next := themeType(len(themes))
+	for _, path := range paths {
+		theme, err := parseThemeFile(path)
+		if err != nil {
+			return fmt.Errorf("%s: %w", path, err)
+		}
+		if t, ok := getThemeByName(theme.Name); ok {
+			themes[t] = theme
+			continue
 		}
+		themes[next] = theme
+		next++
 	}
+	return nil
+}
 
-	return result
+// quantizeTheme returns a copy of the theme with hex colors replaced by their
+// nearest xterm 256-color palette entries
+func quantizeTheme(theme Theme) Theme {
+	for _, c := range theme.colors() {
+		if r, g, b, ok := parseHexColor(string(*c)); ok {
+			*c = lipgloss.Color(strconv.Itoa(nearestANSI256(r, g, b)))
+		}
+	}
+	return theme
 }
 
-// computeMatchIndices finds the character indices in the package string (source/name)
-// that match the query using case-insensitive fuzzy matching.
-// Returns indices relative to the full "source/name" string.
-func computeMatchIndices(pkg Package, query string) []int {
-	if query == "" {
-		return nil
+// parseHexColor parses a #rrggbb color
+func parseHexColor(s string) (r, g, b uint8, ok bool) {
+	if len(s) != 7 || s[0] != '#' {
+		return 0, 0, 0, false
 	}
+	v, err := strconv.ParseUint(s[1:], 16, 32)
+	if err != nil {
+		return 0, 0, 0, false
+	}
+	return uint8(v >> 16), uint8(v >> 8), uint8(v), true
+}
 
-	pkgStr := pkg.Source + "/" + pkg.Name
-	pkgLower := strings.ToLower(pkgStr)
-	queryLower := strings.ToLower(query)
-
-	var indices []int
-
-	// Try to find consecutive substring match first (more visually coherent)
-	if idx := strings.Index(pkgLower, queryLower); idx != -1 {
-		for i := 0; i < len(queryLower); i++ {
-			indices = append(indices, idx+i)
-		}
-		return indices
-	}
-
-	// Fall back to fuzzy matching: find each query character in order
-	pkgRunes := []rune(pkgLower)
-	queryRunes := []rune(queryLower)
-	pkgIdx := 0
-	for _, qr := range queryRunes {
-		found := false
-		for pkgIdx < len(pkgRunes) {
-			if pkgRunes[pkgIdx] == qr {
-				indices = append(indices, pkgIdx)
-				pkgIdx++
-				found = true
-				break
-			}
-			pkgIdx++
+// ansi256RGB returns the RGB value of an xterm palette entry from 16 to 255
+func ansi256RGB(index int) (r, g, b uint8) {
+	if index >= 232 {
+		level := uint8(8 + (index-232)*10)
+		return level, level, level
+	}
+	levels := [6]uint8{0, 95, 135, 175, 215, 255}
+	index -= 16
+	return levels[index/36], levels[index/6%6], levels[index%6]
+}
+
+// nearestANSI256 returns the palette entry closest to the color by CIE76
+// distance in Lab space. Entries 0-15 are skipped since terminals let users
+// redefine them.
+func nearestANSI256(r, g, b uint8) int {
+	l, a, bb := rgbToLab(r, g, b)
+	best, bestDist := 16, -1.0
+	for i := 16; i < 256; i++ {
+		pl, pa, pb := rgbToLab(ansi256RGB(i))
+		dist := (l-pl)*(l-pl) + (a-pa)*(a-pa) + (bb-pb)*(bb-pb)
+		if bestDist < 0 || dist < bestDist {
+			best, bestDist = i, dist
 		}
-		if !found {
-			// Query char not found, return partial matches
-			break
+	}
+	return best
+}
+
+// rgbToLab converts an sRGB color to CIE L*a*b* under the D65 white point
+func rgbToLab(r, g, b uint8) (l, a, bb float64) {
+	linear := func(c uint8) float64 {
+		v := float64(c) / 255
+		if v <= 0.04045 {
+			return v / 12.92
+		}
+		return math.Pow((v+0.055)/1.055, 2.4)
+	}
+	lr, lg, lb := linear(r), linear(g), linear(b)
+	x := (0.4124*lr + 0.3576*lg + 0.1805*lb) / 0.95047
+	y := 0.2126*lr + 0.7152*lg + 0.0722*lb
+	z := (0.0193*lr + 0.1192*lg + 0.9505*lb) / 1.08883
+
+	f := func(t float64) float64 {
+		if t > 216.0/24389 {
+			return math.Cbrt(t)
 		}
+		return (24389.0/27*t + 16) / 116
 	}
+	fx, fy, fz := f(x), f(y), f(z)
+	return 116*fy - 16, 500 * (fx - fy), 200 * (fy - fz)
+}
+
+// UI configuration constants
+const (
+	defaultMinSearchQueryLen       = 2
+	textInputCharLimit             = 100
+	textInputDefaultWidth          = 50
+	defaultPackageInfoDebounceTime = 150 * time.Millisecond
+	maxPackageInfoDebounceTime     = 2 * time.Second
+	maxMinSearchQueryLen           = 10
+	refreshCoalesceDelay           = 300 * time.Millisecond
+	defaultAURSearchDebounceTime   = 400 * time.Millisecond // Pause in typing before the AUR is searched
+	maxAURSearchDebounceTime       = 2 * time.Second
+	defaultDepTreeDepth            = 6
+	maxDepTreeDepth                = 20
+	defaultSyncStaleHours          = 24       // Age at which the sync databases are flagged as stale
+	maxSyncStaleHours              = 24 * 30
+	defaultTopPackages             = 10       // Biggest packages listed on the dashboard
+	maxTopPackages                 = 50
+	defaultPrefetchConcurrency     = 4 // AUR detail requests in flight at once
+	maxPrefetchConcurrency         = 16
+	upgradeWarnAge                 = 7 * 24 * time.Hour // Time since the last full upgrade flagged on the dashboard
+	pendingUpdatesWarn             = 25                 // Pending update count flagged on the dashboard
+	diskWarnPercent                = 80                 // Filesystem usage shown in the warning color
+	diskCriticalPercent            = 90                 // Filesystem usage shown in red
+	cacheFreeWarnRatio             = 0.5                // Cache size, as a share of the free space, flagged in red
+)
 
-	return indices
+// Settings holds the search and info tunables that can be adjusted at runtime
+// from the settings overlay. They are persisted to the config file.
+type Settings struct {
+	MinSearchQueryLen   int  `toml:"min_query_len"`
+	InfoDebounceMs      int  `toml:"info_debounce_ms"`
+	AURAutoSearch       bool `toml:"aur_auto_search"`
+	DepTreeDepth        int  `toml:"dep_tree_depth"`
+	AURDebounceMs       int  `toml:"aur_debounce_ms"`
+	StreamOutput        bool `toml:"stream_output"`
+	ShowDescriptions    bool `toml:"show_descriptions"`
+	SyncStaleHours      int  `toml:"sync_stale_hours"`
+	TopPackages         int  `toml:"top_packages"`
+	AutoOrphanPasses    bool `toml:"auto_orphan_passes"`
+	UsageEstimate       bool `toml:"usage_estimate"`
+	PrefetchConcurrency int  `toml:"prefetch_concurrency"`
 }
 
-// computeAllMatchIndices computes match indices for all packages in the filtered list.
-// Returns a map from package index to matched character indices.
-func computeAllMatchIndices(packages []Package, query string) map[int][]int {
-	if query == "" || len(packages) == 0 {
-		return nil
+// defaultSettings returns the settings used when no config file is present
+func defaultSettings() Settings {
+	return Settings{
+		MinSearchQueryLen:   defaultMinSearchQueryLen,
+		InfoDebounceMs:      int(defaultPackageInfoDebounceTime / time.Millisecond),
+		AURAutoSearch:       true,
+		DepTreeDepth:        defaultDepTreeDepth,
+		AURDebounceMs:       int(defaultAURSearchDebounceTime / time.Millisecond),
+		ShowDescriptions:    true,
+		SyncStaleHours:      defaultSyncStaleHours,
+		TopPackages:         defaultTopPackages,
+		PrefetchConcurrency: defaultPrefetchConcurrency,
 	}
+}
 
-	result := make(map[int][]int, len(packages))
-	for i, pkg := range packages {
-		indices := computeMatchIndices(pkg, query)
-		if len(indices) > 0 {
-			result[i] = indices
+// InfoDebounce returns the package info debounce as a duration
+func (s Settings) InfoDebounce() time.Duration {
+	return time.Duration(s.InfoDebounceMs) * time.Millisecond
+}
+
+// AURDebounce returns the pause in typing before the AUR is searched
+func (s Settings) AURDebounce() time.Duration {
+	return time.Duration(s.AURDebounceMs) * time.Millisecond
+}
+
+// validateSettings rejects nonsensical values, naming the offending key.
+// It returns a non-fatal warning for valid but risky combinations.
+func validateSettings(s Settings) (warning string, err error) {
+	if s.InfoDebounceMs < 0 {
+		return "", fmt.Errorf("info_debounce_ms cannot be negative (got %d)", s.InfoDebounceMs)
+	}
+	if s.InfoDebounce() > maxPackageInfoDebounceTime {
+		return "", fmt.Errorf("info_debounce_ms cannot exceed %d", maxPackageInfoDebounceTime/time.Millisecond)
+	}
+	if s.AURDebounceMs < 0 {
+		return "", fmt.Errorf("aur_debounce_ms cannot be negative (got %d)", s.AURDebounceMs)
+	}
+	if s.AURDebounce() > maxAURSearchDebounceTime {
+		return "", fmt.Errorf("aur_debounce_ms cannot exceed %d", maxAURSearchDebounceTime/time.Millisecond)
+	}
+	if s.MinSearchQueryLen < 0 {
+		return "", fmt.Errorf("min_query_len cannot be negative (got %d)", s.MinSearchQueryLen)
+	}
+	if s.MinSearchQueryLen > maxMinSearchQueryLen {
+		return "", fmt.Errorf("min_query_len cannot exceed %d", maxMinSearchQueryLen)
+	}
+	if s.DepTreeDepth < 1 {
+		return "", fmt.Errorf("dep_tree_depth must be at least 1 (got %d)", s.DepTreeDepth)
+	}
+	if s.DepTreeDepth > maxDepTreeDepth {
+		return "", fmt.Errorf("dep_tree_depth cannot exceed %d", maxDepTreeDepth)
+	}
+	if s.SyncStaleHours < 0 {
+		return "", fmt.Errorf("sync_stale_hours cannot be negative (got %d)", s.SyncStaleHours)
+	}
+	if s.SyncStaleHours > maxSyncStaleHours {
+		return "", fmt.Errorf("sync_stale_hours cannot exceed %d", maxSyncStaleHours)
+	}
+	if s.TopPackages < 1 {
+		return "", fmt.Errorf("top_packages must be at least 1 (got %d)", s.TopPackages)
+	}
+	if s.TopPackages > maxTopPackages {
+		return "", fmt.Errorf("top_packages cannot exceed %d", maxTopPackages)
+	}
+	if s.PrefetchConcurrency < 1 {
+		return "", fmt.Errorf("prefetch_concurrency must be at least 1 (got %d)", s.PrefetchConcurrency)
+	}
+	if s.PrefetchConcurrency > maxPrefetchConcurrency {
+		return "", fmt.Errorf("prefetch_concurrency cannot exceed %d", maxPrefetchConcurrency)
+	}
+	if s.AURAutoSearch && s.MinSearchQueryLen <= 1 {
+		return "AUR auto-search with a minimum query length below 2 sends a request for nearly every keystroke", nil
+	}
+	return "", nil
+}
+
+// configFilePath returns the path of the user config file (~/.config/gaur/config.toml)
+func configFilePath() string {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		home, _ := os.UserHomeDir()
+		dir = filepath.Join(home, ".config")
+	}
+	return filepath.Join(dir, "gaur", "config.toml")
+}
+
+// Config is the contents of the user config file
+type Config struct {
+	Settings
+	Sets            map[string][]string `toml:"sets"`             // Named package sets; "@name" references another set
+	CacheDirs       []CacheDir          `toml:"cache_dirs"`       // Cache directories known to the Storage box
+	MonitoredCaches []string            `toml:"monitored_caches"` // Labels of the cache dirs shown; all when unset
+	Holds           []string            `toml:"holds"`            // Packages passed to paru -Syu as --ignore
+	Pins            []string            `toml:"pins"`             // "name=version": packages kept at that version, passed to paru -Syu as --ignore
+	Theme           string              `toml:"theme"`            // Theme name; --theme overrides it
+	DefaultMode     string              `toml:"default_mode"`     // Mode shown at startup; install when unset
+	RemovalFlags    string              `toml:"removal_flags"`    // Initial removal mode of the remove dialog; -Rns when unset
+	AURHelper       string              `toml:"aur_helper"`       // paru, yay, pacman or another paru-compatible helper; detected when unset
+	Keys            Keymap              `toml:"keys"`             // Key bindings; unset actions keep their defaults
+}
+
+// keyBinding is the keys bound to one action. The config file may give a
+// single key as a string or several as an array; "space" means the space bar.
+type keyBinding []string
+
+// UnmarshalTOML accepts a string or an array of strings
+func (b *keyBinding) UnmarshalTOML(value interface{}) error {
+	var keys []string
+	switch v := value.(type) {
+	case string:
+		keys = []string{v}
+	case []interface{}:
+		for _, item := range v {
+			key, ok := item.(string)
+			if !ok {
+				return fmt.Errorf("expected key names, got %v", item)
+			}
+			keys = append(keys, key)
 		}
+	default:
+		return fmt.Errorf("expected a key name or an array of key names, got %v", value)
 	}
-	return result
+	for i, key := range keys {
+		if key == "" {
+			return fmt.Errorf("empty key name")
+		}
+		if key == "space" {
+			keys[i] = " "
+		}
+	}
+	*b = keys
+	return nil
 }
 
-// Messages
-type repoPackagesMsg struct {
-	packages []Package
-	err      error
+// Keymap binds the remappable actions to keys
+type Keymap struct {
+	MoveUp         keyBinding `toml:"move_up"`
+	MoveDown       keyBinding `toml:"move_down"`
+	Mark           keyBinding `toml:"mark"`
+	Confirm        keyBinding `toml:"confirm"`
+	ModeInstall    keyBinding `toml:"mode_install"`
+	ModeRemove     keyBinding `toml:"mode_remove"`
+	ModeUpdate     keyBinding `toml:"mode_update"`
+	Dashboard      keyBinding `toml:"dashboard"`
+	Search         keyBinding `toml:"search"`
+	Quit           keyBinding `toml:"quit"`
+	SelectionPanel keyBinding `toml:"selection_panel"`
 }
 
-type aurSearchMsg struct {
-	packages []Package
-	query    string
-	err      error
+// defaultKeymap returns the built-in bindings. The first key of each action
+// is the one Update handles; remapped keys are replayed as it.
+func defaultKeymap() Keymap {
+	return Keymap{
+		MoveUp:         keyBinding{"up", "k"},
+		MoveDown:       keyBinding{"down", "j"},
+		Mark:           keyBinding{"tab"},
+		Confirm:        keyBinding{"enter"},
+		ModeInstall:    keyBinding{"i"},
+		ModeRemove:     keyBinding{"r"},
+		ModeUpdate:     keyBinding{"u"},
+		Dashboard:      keyBinding{"n"},
+		Search:         keyBinding{"/"},
+		Quit:           keyBinding{"q"},
+		SelectionPanel: keyBinding{"*"},
+	}
 }
 
-type packageInfoMsg struct {
-	info        string
-	packageName string
-	err         error
+// keymapAction is one action of a Keymap with its config key and bindings
+type keymapAction struct {
+	name     string
+	keys     keyBinding
+	defaults keyBinding
 }
 
-type installedPackagesMsg struct {
-	packages []Package
-	err      error
+// actions lists the keymap's actions next to their default bindings
+func (k Keymap) actions() []keymapAction {
+	d := defaultKeymap()
+	return []keymapAction{
+		{"move_up", k.MoveUp, d.MoveUp},
+		{"move_down", k.MoveDown, d.MoveDown},
+		{"mark", k.Mark, d.Mark},
+		{"confirm", k.Confirm, d.Confirm},
+		{"mode_install", k.ModeInstall, d.ModeInstall},
+		{"mode_remove", k.ModeRemove, d.ModeRemove},
+		{"mode_update", k.ModeUpdate, d.ModeUpdate},
+		{"dashboard", k.Dashboard, d.Dashboard},
+		{"search", k.Search, d.Search},
+		{"quit", k.Quit, d.Quit},
+		{"selection_panel", k.SelectionPanel, d.SelectionPanel},
+	}
 }
 
-type actionCompleteMsg struct {
-	message string
-	err     error
+// validateKeymap rejects a key bound to two actions, naming both
+func validateKeymap(k Keymap) error {
+	boundTo := make(map[string]string)
+	for _, action := range k.actions() {
+		for _, key := range action.keys {
+			if other, ok := boundTo[key]; ok && other != action.name {
+				return fmt.Errorf("keys: %q is bound to both %s and %s", key, other, action.name)
+			}
+			boundTo[key] = action.name
+		}
+	}
+	return nil
 }
 
-type updateOutputMsg struct {
-	output string
-	done   bool
-	err    error
+// translate maps a pressed key to the default key of the action it is bound
+// to, so the handlers in Update only know the defaults. A default key that
+// was rebound away from its action is dropped (ok is false). While typing,
+// single characters are left alone so they reach the text input.
+func (k Keymap) translate(key string, typing bool) (translated string, ok bool) {
+	if typing && len([]rune(key)) == 1 {
+		return key, true
+	}
+	freed := false
+	for _, action := range k.actions() {
+		canonical := action.defaults[0]
+		for _, bound := range action.keys {
+			if bound == key {
+				if typing && len([]rune(canonical)) == 1 {
+					return key, true
+				}
+				return canonical, true
+			}
+		}
+		for _, def := range action.defaults {
+			freed = freed || def == key
+		}
+	}
+	return key, !freed
 }
 
-type updateCheckMsg struct {
-	packages []Package
-	err      error
+// keyMsgFor builds the KeyMsg of a default binding
+func keyMsgFor(key string) tea.KeyMsg {
+	switch key {
+	case "up":
+		return tea.KeyMsg{Type: tea.KeyUp}
+	case "down":
+		return tea.KeyMsg{Type: tea.KeyDown}
+	case "tab":
+		return tea.KeyMsg{Type: tea.KeyTab}
+	case "enter":
+		return tea.KeyMsg{Type: tea.KeyEnter}
+	}
+	return tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune(key)}
 }
 
-type execCompleteMsg struct {
-	operation confirmationType
-	packages  []string
-	err       error
+// startModes maps the default_mode config values to the modes they open
+var startModes = map[string]viewMode{
+	"install": modeInstall,
+	"info":    modeInstalled,
+	"remove":  modeUninstall,
+	"update":  modeUpdate,
+	"history": modeHistory,
 }
 
-type dashboardMsg struct {
-	data DashboardData
-	err  error
+// backend is the package manager gaur drives: an AUR helper, or plain
+// pacman when only the official repositories are wanted
+type backend struct {
+	name     string
+	aur      bool   // Searches, builds and updates AUR packages
+	stats    bool   // Reports installed sizes with -Ps
+	cloneDir string // Where AUR clones live under the cache directory
 }
 
-// debounceTickMsg is sent after debounce timer expires to trigger package info fetch
-type debounceTickMsg struct {
-	packageName string
+// backends are the known backends in auto-detection order. Any other
+// aur_helper is assumed to take paru's flags.
+var backends = []backend{
+	{name: "paru", aur: true, stats: true, cloneDir: "clone"},
+	{name: "yay", aur: true, stats: true},
+	{name: "pacman"},
 }
 
-// DashboardData holds system package statistics
-type DashboardData struct {
-	TotalPackages       int
-	ExplicitlyInstalled int
-	ForeignPackages     int
-	TotalSize           string
-	TotalSizeBytes      int64 // For comparison
-	CleanerSize         string
-	CleanerSizeBytes    int64 // For comparison and coloring
-	PacmanCacheSize     string
-	PacmanCacheSizeBytes int64
-	PacmanCachePath     string
-	ParuCacheSize       string
-	ParuCacheSizeBytes  int64
-	ParuCachePath       string
-	Orphans             int
-	MissingFromAUR      int
-	TopPackages         []PackageSize // Top 10 packages by size
+// helper is the backend every package manager invocation runs. Set from
+// --helper, aur_helper in the config file, or detectBackend.
+var helper = backends[0]
+
+// backendFor returns the backend run by the named binary
+func backendFor(name string) backend {
+	for _, b := range backends {
+		if b.name == name {
+			return b
+		}
+	}
+	b := backends[0]
+	b.name = name
+	return b
 }
 
-// PackageSize holds package name and its installed size
-type PackageSize struct {
-	Name string
-	Size string
+// detectBackend returns the first known backend found in PATH
+func detectBackend() backend {
+	for _, b := range backends {
+		if _, err := exec.LookPath(b.name); err == nil {
+			return b
+		}
+	}
+	return backends[len(backends)-1]
 }
 
-// Dashboard action messages
-type cleanCacheMsg struct {
-	output string
-	err    error
+// transaction returns the command line for an operation that changes the
+// system. AUR helpers ask for root themselves; plain pacman runs with sudo.
+func (b backend) transaction(args ...string) []string {
+	if b.aur {
+		return append([]string{b.name}, args...)
+	}
+	return append([]string{"sudo", b.name}, args...)
 }
 
-type removeOrphansMsg struct {
-	output string
-	err    error
+// command builds the exec.Cmd for a transaction, see transaction
+func (b backend) command(args ...string) *exec.Cmd {
+	argv := b.transaction(args...)
+	return exec.Command(argv[0], argv[1:]...)
 }
 
-// Model
-type model struct {
-	textInput             textinput.Model
-	repoPackages          []Package       // All repo packages from local cache
-	aurPackages           []Package       // AUR packages from last search
-	installedSet          map[string]bool // Quick lookup for installed packages
-	packages              []Package
-	filtered              []Package
-	installed             []Package
-	filteredInstalled     []Package
-	matchIndices          map[int][]int // Maps package index to matched character indices
-	installedMatchIndices map[int][]int
-	selectedIndex         int
-	markedPackages        map[string]bool // Packages marked for batch operation
-	selectionPanelFocused bool            // Whether selection panel is focused
-	selectionPanelIndex   int             // Selected index within selection panel
-	packageInfo           string
-	infoForPackage        string
-	pendingInfoPackage    string // Package waiting for debounce to complete
-	loadingInfo           bool
-	mode                  viewMode
-	width                 int
-	height                int
-	loading               bool
-	statusMessage         string
-	updateOutput          string
-	lastQuery             string
-	lastAURQuery          string // Last query sent to AUR search
-	searchingAUR          bool   // Whether AUR search is in progress
-	dashboard             DashboardData
-	dashboardSelected     int // Selected item in dashboard (0=foreign, 1=cache, 2=orphans)
-	// Confirmation dialog state
-	showConfirmation      bool
-	confirmType           confirmationType
-	confirmPackages       []string  // Package names to operate on
-	pendingUpdates        []Package // Updates available (for update confirmation)
-	confirmScrollOffset   int       // Scroll offset for confirmation package list
-	lastCompletedOp       string    // Description of last completed operation
-	// Error overlay state
-	showErrorOverlay      bool
-	errorTitle            string
-	errorMessage          string
-	errorDetails          string
-}
-
-// getModeColors returns the mode colors based on current theme
-func getModeColors() map[viewMode]lipgloss.Color {
-	return map[viewMode]lipgloss.Color{
-		modeInstall:   currentTheme.InstallColor,
-		modeInstalled: currentTheme.InstalledColor,
-		modeUninstall: currentTheme.UninstallColor,
-		modeUpdate:    currentTheme.UpdateColor,
+// probeTools checks for the external tools gaur runs. Missing optional tools
+// are described in notices for the header; fzf is swapped for the built-in
+// matcher. A missing pacman, which nothing works without, is returned as err.
+func probeTools() (notices []string, err error) {
+	if _, lookErr := exec.LookPath("pacman"); lookErr != nil {
+		return nil, fmt.Errorf("pacman not found in PATH")
+	}
+	if !helper.aur {
+		_, paruErr := exec.LookPath("paru")
+		_, yayErr := exec.LookPath("yay")
+		if paruErr != nil && yayErr != nil {
+			notices = append(notices, "No AUR helper (paru or yay) found - official repositories only")
+		}
 	}
+	if useFzf {
+		if _, lookErr := exec.LookPath("fzf"); lookErr != nil {
+			useFzf = false
+			notices = append(notices, "fzf not found - using built-in matcher")
+		}
+	}
+	return notices, nil
 }
 
-// getSourceColors returns the source colors based on current theme
-func getSourceColors() map[string]lipgloss.Color {
-	return map[string]lipgloss.Color{
-		"core":     currentTheme.CoreColor,
-		"extra":    currentTheme.ExtraColor,
-		"multilib": currentTheme.MultilibColor,
-		"aur":      currentTheme.AurColor,
+// validateHelperName checks that name is a plain binary name found in PATH
+func validateHelperName(name string) error {
+	if !isValidPackageName(name) {
+		return fmt.Errorf("%q is not a plain binary name", name)
+	}
+	if _, err := exec.LookPath(name); err != nil {
+		return fmt.Errorf("%q not found in PATH", name)
 	}
+	return nil
 }
 
-// Styles - initialized with theme colors
-var (
-	defaultBorderColor = currentTheme.BorderColor
-	selectedColor      = currentTheme.SelectedColor
-
-	// Mode-specific colors for active view highlighting
-	modeColors = getModeColors()
-
-	sourceColors = getSourceColors()
-
-	// Base styles (will be customized per mode in View)
-	baseTitleStyle = lipgloss.NewStyle().
-			Bold(true).
-			Foreground(currentTheme.TitleColor).
-			Padding(0, 1)
-
-	baseBorderStyle = lipgloss.NewStyle().
-			Border(lipgloss.RoundedBorder())
-
-	selectedStyle = lipgloss.NewStyle().
-			Bold(true).
-			Foreground(currentTheme.SelectedColor)
-
-	normalStyle = lipgloss.NewStyle().
-			Foreground(currentTheme.TextColor)
-
-	infoStyle = lipgloss.NewStyle().
-			Foreground(currentTheme.TextColor).
-			Padding(1)
-
-	statusStyle = lipgloss.NewStyle().
-			Foreground(currentTheme.SubtleColor)
-
-	helpStyle = lipgloss.NewStyle().
-			Foreground(currentTheme.SubtleColor).
-			Bold(true)
-
-	installedBadge = lipgloss.NewStyle().
-			Foreground(currentTheme.SuccessColor).
-			Bold(true)
-
-	matchHighlightStyle = lipgloss.NewStyle().
-				Foreground(currentTheme.HighlightColor).
-				Bold(true)
-
-	dashboardLabelStyle = lipgloss.NewStyle().
-				Foreground(currentTheme.DashboardLabel).
-				Bold(true)
-
-	dashboardValueStyle = lipgloss.NewStyle().
-				Foreground(currentTheme.DashboardValue).
-				Bold(true)
-
-	dashboardWarningStyle = lipgloss.NewStyle().
-				Foreground(currentTheme.DashboardWarning).
-				Bold(true)
-
-	dashboardDescStyle = lipgloss.NewStyle().
-				Foreground(currentTheme.DashboardDesc)
-)
-
-func initialModel() model {
-	ti := textinput.New()
-	ti.Placeholder = "Search packages..."
-	ti.CharLimit = textInputCharLimit
-	ti.Width = textInputDefaultWidth
+// aurHelperCacheDir returns the AUR helper's cache directory (~/.cache/<helper>)
+func aurHelperCacheDir(helper string) string {
+	homeDir, _ := os.UserHomeDir()
+	return filepath.Join(homeDir, ".cache", helper)
+}
 
-	return model{
-		textInput:      ti,
-		repoPackages:   []Package{},
-		installedSet:   make(map[string]bool),
-		packages:       []Package{},
-		filtered:       []Package{},
-		installed:      []Package{},
-		markedPackages: make(map[string]bool),
-		selectedIndex:  0,
-		mode:           modeInstall,
-		loading:        true,
-		statusMessage:  "Loading package database...",
+// validateConfigOptions checks the startup options of the config file,
+// naming the offending key
+func validateConfigOptions(config Config) error {
+	if config.Theme != "" {
+		if _, ok := getThemeByName(config.Theme); !ok {
+			return fmt.Errorf("theme: unknown theme %q (available: %s)", config.Theme, strings.Join(listThemes(), ", "))
+		}
+	}
+	if config.DefaultMode != "" {
+		if _, ok := startModes[config.DefaultMode]; !ok {
+			return fmt.Errorf("default_mode: unknown mode %q (expected install, info, remove, update or history)", config.DefaultMode)
+		}
+	}
+	if config.RemovalFlags != "" {
+		known := false
+		var flags []string
+		for _, mode := range removalModes {
+			known = known || mode.flags == config.RemovalFlags
+			flags = append(flags, mode.flags)
+		}
+		if !known {
+			return fmt.Errorf("removal_flags: unsupported flags %q (expected one of %s)", config.RemovalFlags, strings.Join(flags, ", "))
+		}
 	}
+	if config.AURHelper != "" {
+		if err := validateHelperName(config.AURHelper); err != nil {
+			return fmt.Errorf("aur_helper: %w", err)
+		}
+	}
+	return nil
 }
 
-func (m model) Init() tea.Cmd {
-	return tea.Batch(textinput.Blink, loadRepoPackages())
+// CacheDir is a cache directory whose size is shown in the dashboard Storage box
+type CacheDir struct {
+	Label          string `toml:"label"`
+	Path           string `toml:"path"`
+	Clean          string `toml:"clean"`           // Optional command that cleans the directory
+	PackageManager bool   `toml:"package_manager"` // Counted in the combined cache total
 }
 
-// currentPackageList returns the appropriate package list based on current mode.
-func (m model) currentPackageList() []Package {
-	switch m.mode {
-	case modeInstall:
-		return m.filtered
-	case modeUninstall:
-		return m.filteredInstalled
-	default:
-		return nil
+// defaultCacheDirs returns the pacman and AUR helper caches monitored out of the box
+func defaultCacheDirs(helper string) []CacheDir {
+	dirs := []CacheDir{{Label: "Pacman", Path: "/var/cache/pacman/pkg", PackageManager: true}}
+	if backendFor(helper).aur {
+		dirs = append(dirs, CacheDir{Label: strings.ToUpper(helper[:1]) + helper[1:], Path: aurHelperCacheDir(helper), PackageManager: true})
 	}
+	return dirs
 }
 
-// maxSelectableIndex returns the maximum valid index for the current package list.
-func (m model) maxSelectableIndex() int {
-	pkgList := m.currentPackageList()
-	if len(pkgList) == 0 {
-		return 0
+// expandHomePath expands a leading "~" to the user's home directory
+func expandHomePath(path string) string {
+	if path == "~" || strings.HasPrefix(path, "~/") {
+		homeDir, _ := os.UserHomeDir()
+		return filepath.Join(homeDir, path[1:])
 	}
-	return len(pkgList) - 1
+	return path
 }
 
-// selectedPackage returns the currently selected package, or nil if none.
-func (m model) selectedPackage() *Package {
-	pkgList := m.currentPackageList()
-	if m.selectedIndex >= 0 && m.selectedIndex < len(pkgList) {
-		return &pkgList[m.selectedIndex]
+// validateCleanPath rejects directories that must never be the target of a
+// clean command: relative paths, the filesystem root, top-level system
+// directories, and the home directory or any of its ancestors
+func validateCleanPath(path string) error {
+	if !filepath.IsAbs(path) {
+		return fmt.Errorf("path %q must be absolute", path)
+	}
+	clean := filepath.Clean(path)
+	if clean == "/" || filepath.Dir(clean) == "/" {
+		return fmt.Errorf("path %q is a top-level directory", path)
+	}
+	if homeDir, err := os.UserHomeDir(); err == nil {
+		homeDir = filepath.Clean(homeDir)
+		if clean == homeDir || strings.HasPrefix(homeDir, clean+"/") {
+			return fmt.Errorf("path %q contains the home directory", path)
+		}
 	}
 	return nil
 }
 
-// highlightMatches renders a string with matched character indices highlighted.
-// It uses a map for O(1) lookup of matched positions and handles Unicode correctly.
-func highlightMatches(s string, matchedIndices []int) string {
-	if len(matchedIndices) == 0 {
-		return s
+// validateCleanCommand checks the clean command of the cache directory at path.
+// The command runs without a shell, so shell syntax, which would reach the
+// program literally, is rejected, and absolute paths given to it must lie
+// inside the directory it cleans.
+func validateCleanCommand(command, path string) error {
+	args := strings.Fields(command)
+	if len(args) == 0 {
+		return fmt.Errorf("command is blank")
 	}
-
-	// Create a set of matched indices for O(1) lookup
-	matchSet := make(map[int]struct{}, len(matchedIndices))
-	for _, idx := range matchedIndices {
-		matchSet[idx] = struct{}{}
+	if i := strings.IndexAny(command, ";&|<>$`'\"*?~(){}\\"); i >= 0 {
+		return fmt.Errorf("%q runs without a shell, so %q is not allowed", command, command[i:i+1])
 	}
-
-	var result strings.Builder
-	result.Grow(len(s) * 2) // Pre-allocate for efficiency
-	runes := []rune(s)
-	for i, r := range runes {
-		if _, matched := matchSet[i]; matched {
-			result.WriteString(matchHighlightStyle.Render(string(r)))
-		} else {
-			result.WriteRune(r)
+	dir := filepath.Clean(path)
+	for _, arg := range args[1:] {
+		// Also catch paths given as an option value, such as --dir=/tmp
+		if _, value, ok := strings.Cut(arg, "="); ok && strings.HasPrefix(arg, "-") {
+			arg = value
+		}
+		if !filepath.IsAbs(arg) {
+			continue
+		}
+		if target := filepath.Clean(arg); target != dir && !strings.HasPrefix(target, dir+"/") {
+			return fmt.Errorf("path %q is outside %s", arg, path)
 		}
 	}
-	return result.String()
+	return nil
 }
 
-// highlightMatchesWithSourceColor renders a package string (source/name) with:
-// - Source portion colored by repository
-// - Matched characters highlighted with matchHighlightStyle
-// - Non-matched characters in normal text color
-func highlightMatchesWithSourceColor(pkg Package, matchedIndices []int) string {
-	pkgStr := pkg.Source + "/" + pkg.Name
-	
-	// Get source color
-	sourceColor, hasSourceColor := sourceColors[pkg.Source]
-	
-	// If no matches, just apply source coloring
-	if len(matchedIndices) == 0 {
-		if hasSourceColor {
-			return lipgloss.NewStyle().Foreground(sourceColor).Render(pkg.Source) + "/" + pkg.Name
+// validateCacheDirs expands and checks the configured cache directories
+func validateCacheDirs(dirs []CacheDir, monitored []string) error {
+	labels := make(map[string]bool)
+	for i := range dirs {
+		dir := &dirs[i]
+		if dir.Label == "" {
+			return fmt.Errorf("cache_dirs: entry %d has no label", i+1)
+		}
+		if labels[dir.Label] {
+			return fmt.Errorf("cache_dirs: duplicate label %q", dir.Label)
+		}
+		labels[dir.Label] = true
+		dir.Path = expandHomePath(dir.Path)
+		if !filepath.IsAbs(dir.Path) {
+			return fmt.Errorf("cache_dirs.%s: path %q must be absolute", dir.Label, dir.Path)
+		}
+		if dir.Clean != "" {
+			if err := validateCleanPath(dir.Path); err != nil {
+				return fmt.Errorf("cache_dirs.%s: clean command not allowed: %w", dir.Label, err)
+			}
+			if err := validateCleanCommand(dir.Clean, dir.Path); err != nil {
+				return fmt.Errorf("cache_dirs.%s: clean: %w", dir.Label, err)
+			}
 		}
-		return pkgStr
 	}
-
-	// Create a set of matched indices for O(1) lookup
-	matchSet := make(map[int]struct{}, len(matchedIndices))
-	for _, idx := range matchedIndices {
-		matchSet[idx] = struct{}{}
+	for _, label := range monitored {
+		if !labels[label] {
+			return fmt.Errorf("monitored_caches: unknown cache %q", label)
+		}
 	}
+	return nil
+}
 
-	// Find where the slash is (end of source)
-	slashIdx := len(pkg.Source)
+// fileValidator reports whether the contents of a persisted file are intact
+type fileValidator func(data []byte) error
 
-	var result strings.Builder
-	result.Grow(len(pkgStr) * 2)
-	runes := []rune(pkgStr)
-	
-	for i, r := range runes {
-		if _, matched := matchSet[i]; matched {
-			// Matched character - use highlight color
-			result.WriteString(matchHighlightStyle.Render(string(r)))
-		} else if i < slashIdx && hasSourceColor {
-			// Source portion (before slash) - use source color
-			result.WriteString(lipgloss.NewStyle().Foreground(sourceColor).Render(string(r)))
-		} else {
-			// Name portion or no source color - use normal text
-			result.WriteRune(r)
+// validTOML accepts any syntactically valid TOML document
+func validTOML(data []byte) error {
+	var v map[string]any
+	return toml.Unmarshal(data, &v)
+}
+
+// validPackageList accepts whitespace-separated valid package names
+func validPackageList(data []byte) error {
+	for _, name := range strings.Fields(string(data)) {
+		if !isValidPackageName(name) {
+			return fmt.Errorf("invalid package name %q", name)
 		}
 	}
-	return result.String()
+	return nil
 }
 
-// Commands
-// loadRepoPackages loads all packages from local pacman database
-func loadRepoPackages() tea.Cmd {
-	return func() tea.Msg {
-		// Get all repo packages: "repo name version"
-		cmd := exec.Command("pacman", "-Sl")
-		var stdout bytes.Buffer
-		cmd.Stdout = &stdout
-		if err := cmd.Run(); err != nil {
-			return repoPackagesMsg{err: err}
-		}
+// validCount accepts a single non-negative number
+func validCount(data []byte) error {
+	n, err := strconv.Atoi(strings.TrimSpace(string(data)))
+	if err == nil && n < 0 {
+		err = fmt.Errorf("negative count %d", n)
+	}
+	return err
+}
 
-		// Get installed packages for quick lookup
-		installedCmd := exec.Command("pacman", "-Qq")
-		var installedOut bytes.Buffer
-		installedCmd.Stdout = &installedOut
-		_ = installedCmd.Run()
-		
-		installedSet := make(map[string]bool)
-		for _, name := range strings.Split(installedOut.String(), "\n") {
-			name = strings.TrimSpace(name)
-			if name != "" {
-				installedSet[name] = true
-			}
-		}
+// writeFileAtomic replaces path with data so that a crash never leaves a
+// truncated file: data is written to a temporary file in the same directory,
+// synced and renamed over path. The previous contents are kept as path.bak
+// when they pass validate, so a later corruption can be recovered.
+func writeFileAtomic(path string, data []byte, validate fileValidator) error {
+	dir := filepath.Dir(path)
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return err
+	}
 
-		// Parse "repo name version [installed]" format
-		var packages []Package
-		for _, line := range strings.Split(stdout.String(), "\n") {
-			parts := strings.Fields(line)
-			if len(parts) < 3 {
-				continue
-			}
-			pkg := Package{
-				Source:    parts[0],
-				Name:      parts[1],
-				Version:   parts[2],
-				Installed: installedSet[parts[1]] || (len(parts) > 3 && parts[3] == "[installed]"),
-			}
-			packages = append(packages, pkg)
+	if old, err := os.ReadFile(path); err == nil && validate(old) == nil {
+		if err := writeFileSynced(path+".bak", old); err != nil {
+			return err
 		}
-
-		return repoPackagesMsg{packages: packages}
 	}
+	return writeFileSynced(path, data)
 }
 
-// Repo filter character mappings
-var repoFilterChars = map[rune]string{
-	'c': "core",
-	'e': "extra",
-	'm': "multilib",
-	'a': "aur",
-}
+// writeFileSynced writes data to a temporary file, syncs it and renames it to path
+func writeFileSynced(path string, data []byte) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".tmp-*")
+	if err != nil {
+		return err
+	}
+	tmpPath := tmp.Name()
+	defer os.Remove(tmpPath) // No-op once renamed
 
-// uninstallFilterChars maps single characters to package filter types for uninstall mode
-var uninstallFilterChars = map[rune]string{
-	't': "total",    // All packages
-	'e': "explicit", // Explicitly installed packages
-	'f': "foreign",  // Foreign/AUR packages
-	'o': "orphan",   // Orphan packages
-}
-
-// parseRepoFilter extracts repo filters and search query from input
-// Supports combined filters like "ae:", "cem:", "aem:" in any order
-// Returns (repoFilters, searchQuery) where repoFilters is empty if no filter specified
-func parseRepoFilter(input string) (map[string]bool, string) {
-	input = strings.TrimSpace(input)
-	
-	// Look for colon to identify filter prefix
-	colonIdx := strings.Index(input, ":")
-	if colonIdx == -1 {
-		return nil, input
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
 	}
-	
-	// Extract prefix before colon
-	prefix := strings.ToLower(input[:colonIdx])
-	searchQuery := strings.TrimSpace(input[colonIdx+1:])
-	
-	// Parse each character in prefix as a repo filter
-	repoFilters := make(map[string]bool)
-	for _, ch := range prefix {
-		if repo, ok := repoFilterChars[ch]; ok {
-			repoFilters[repo] = true
-		}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
 	}
-	
-	// If no valid repo chars found, treat as regular search
-	if len(repoFilters) == 0 {
-		return nil, input
+	if err := tmp.Close(); err != nil {
+		return err
 	}
-	
-	return repoFilters, searchQuery
+	if err := os.Chmod(tmpPath, 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmpPath, path)
 }
 
-// formatRepoFilters returns a human-readable string of active repo filters
-func formatRepoFilters(filters map[string]bool) string {
-	if len(filters) == 0 {
-		return ""
+// readFileWithRecovery reads path and checks it with validate. When the file
+// is corrupt and path.bak holds a valid copy, the corrupt file is moved to
+// path.corrupt, the backup is restored and restored is true. A missing file
+// is returned as an os.ErrNotExist error.
+func readFileWithRecovery(path string, validate fileValidator) (data []byte, restored bool, err error) {
+	data, err = os.ReadFile(path)
+	if err != nil {
+		return nil, false, err
 	}
-	var repos []string
-	// Order consistently
-	for _, repo := range []string{"core", "extra", "multilib", "aur"} {
-		if filters[repo] {
-			repos = append(repos, repo)
-		}
+	invalid := validate(data)
+	if invalid == nil {
+		return data, false, nil
 	}
-	return strings.Join(repos, "+")
-}
 
-// parseUninstallFilter extracts source filters and search query from input for uninstall mode
-// Supports 'a:' for AUR/foreign packages and 'l:' for local/official packages
-func parseUninstallFilter(input string) (map[string]bool, string) {
-	input = strings.TrimSpace(input)
-	
-	// Look for colon to identify filter prefix
-	colonIdx := strings.Index(input, ":")
-	if colonIdx == -1 {
-		return nil, input
+	backup, err := os.ReadFile(path + ".bak")
+	if err != nil || validate(backup) != nil {
+		return data, false, invalid
 	}
-	
-	// Extract prefix before colon
-	prefix := strings.ToLower(input[:colonIdx])
-	searchQuery := strings.TrimSpace(input[colonIdx+1:])
-	
-	// Parse each character in prefix as a source filter
-	sourceFilters := make(map[string]bool)
-	for _, ch := range prefix {
-		if source, ok := uninstallFilterChars[ch]; ok {
-			sourceFilters[source] = true
-		}
+	if err := os.Rename(path, path+".corrupt"); err != nil {
+		return data, false, invalid
 	}
-	
-	// If no valid filter chars found, treat as regular search
-	if len(sourceFilters) == 0 {
-		return nil, input
+	if err := writeFileSynced(path, backup); err != nil {
+		return data, false, invalid
 	}
-	
-	return sourceFilters, searchQuery
+	return backup, true, nil
 }
 
-// formatUninstallFilters returns a human-readable string of active uninstall filters
-func formatUninstallFilters(filters map[string]bool) string {
-	if len(filters) == 0 {
-		return ""
+// restoredNotice describes a file that was recovered from its backup
+func restoredNotice(what, path string) string {
+	return fmt.Sprintf("The %s was corrupt — restored from backup (corrupt copy kept at %s.corrupt)", what, path)
+}
+
+// loadConfig reads the config file, falling back to defaults for missing
+// keys. A missing file is not an error. restored is set when a corrupt file
+// was replaced by its backup.
+func loadConfig(path string) (config Config, restored bool, err error) {
+	config = Config{Settings: defaultSettings(), Keys: defaultKeymap()}
+	data, restored, err := readFileWithRecovery(path, validTOML)
+	if os.IsNotExist(err) {
+		config.CacheDirs = defaultCacheDirs(helper.name)
+		return config, false, nil
 	}
-	var names []string
-	if filters["total"] {
-		names = append(names, "total")
+	if err != nil {
+		return Config{Settings: defaultSettings()}, false, fmt.Errorf("%s: %w", path, err)
 	}
-	if filters["explicit"] {
-		names = append(names, "explicit")
+	meta, err := toml.Decode(string(data), &config)
+	if err != nil {
+		return Config{Settings: defaultSettings()}, restored, fmt.Errorf("%s: %w", path, err)
 	}
-	if filters["foreign"] {
-		names = append(names, "foreign")
+	if undecoded := meta.Undecoded(); len(undecoded) > 0 {
+		return Config{Settings: defaultSettings()}, restored, fmt.Errorf("%s: unknown key %q", path, undecoded[0].String())
 	}
-	if filters["orphan"] {
-		names = append(names, "orphan")
+	if _, err := validateSettings(config.Settings); err != nil {
+		return Config{Settings: defaultSettings()}, restored, fmt.Errorf("%s: %w", path, err)
 	}
-	return strings.Join(names, "+")
+	if err := validateConfigOptions(config); err != nil {
+		return Config{Settings: defaultSettings()}, restored, fmt.Errorf("%s: %w", path, err)
+	}
+	if err := validateKeymap(config.Keys); err != nil {
+		return Config{Settings: defaultSettings()}, restored, fmt.Errorf("%s: %w", path, err)
+	}
+	for name := range config.Sets {
+		if _, err := expandPackageSet(config.Sets, name); err != nil {
+			return Config{Settings: defaultSettings()}, restored, fmt.Errorf("%s: sets.%s: %w", path, name, err)
+		}
+	}
+	if config.AURHelper == "" {
+		config.AURHelper = helper.name
+	}
+	if len(config.CacheDirs) == 0 {
+		config.CacheDirs = defaultCacheDirs(config.AURHelper)
+	}
+	if err := validateCacheDirs(config.CacheDirs, config.MonitoredCaches); err != nil {
+		return Config{Settings: defaultSettings()}, restored, fmt.Errorf("%s: %w", path, err)
+	}
+	return config, restored, nil
 }
 
-// filterAllPackages combines repo and AUR packages, then fuzzy filters together
-// This ensures fzf ranks all packages by relevance to the query
-// Supports repo filtering with prefixes: c (core), e (extra), m (multilib), a (aur)
-// Filters can be combined: ae:, cem:, aem: etc.
-func (m *model) filterAllPackages(query string) {
-	if query == "" {
-		m.filtered = []Package{}
-		m.matchIndices = nil
-		return
+// addConfigTableEntry adds key = value to the given table of the config file,
+// creating the table if needed. Existing content and comments are kept as is.
+func addConfigTableEntry(path, table, key, value string) error {
+	var lines []string
+	if data, err := os.ReadFile(path); err == nil {
+		lines = strings.Split(strings.TrimRight(string(data), "\n"), "\n")
+	} else if !os.IsNotExist(err) {
+		return err
 	}
 
-	// Parse repo filter from query
-	repoFilters, searchQuery := parseRepoFilter(query)
-	
-	// Combine repo and AUR packages
-	allPackages := make([]Package, 0, len(m.repoPackages)+len(m.aurPackages))
-	allPackages = append(allPackages, m.repoPackages...)
-	allPackages = append(allPackages, m.aurPackages...)
-	
-	// Apply repo filters if specified
-	if len(repoFilters) > 0 {
-		var filtered []Package
-		for _, pkg := range allPackages {
-			if repoFilters[pkg.Source] {
-				filtered = append(filtered, pkg)
+	// Find the table and the end of its body (next header or end of file)
+	header := "[" + table + "]"
+	tableStart := -1
+	insertAt := len(lines)
+	for i, line := range lines {
+		trimmed := strings.TrimSpace(line)
+		if tableStart == -1 {
+			if trimmed == header {
+				tableStart = i
 			}
+			continue
+		}
+		// Any header ends the table, [[array]] ones included
+		if strings.HasPrefix(trimmed, "[") {
+			insertAt = i
+			break
 		}
-		allPackages = filtered
 	}
-	
-	if len(allPackages) == 0 {
-		m.filtered = []Package{}
-		m.matchIndices = nil
-		return
+
+	entry := key + " = " + value
+	if tableStart == -1 {
+		if len(lines) > 0 && strings.TrimSpace(lines[len(lines)-1]) != "" {
+			lines = append(lines, "")
+		}
+		lines = append(lines, header, entry)
+	} else {
+		// Keep blank lines that separate the table from the next header
+		for insertAt > tableStart+1 && strings.TrimSpace(lines[insertAt-1]) == "" {
+			insertAt--
+		}
+		lines = append(lines[:insertAt], append([]string{entry}, lines[insertAt:]...)...)
 	}
 
-	// If only repo filter with no search query, show all from those repos
-	if searchQuery == "" {
-		m.filtered = allPackages
-		m.matchIndices = nil
-		return
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
 	}
-	
-	// Fuzzy filter all packages together - fzf will rank by relevance
-	m.filtered = fuzzyFilter(allPackages, searchQuery)
-	
-	// Compute match indices for highlighting (use searchQuery, not full query with prefix)
-	m.matchIndices = computeAllMatchIndices(m.filtered, searchQuery)
+	return writeFileAtomic(path, []byte(strings.Join(lines, "\n")+"\n"), validTOML)
 }
 
-// searchAUR searches the AUR via paru (network call)
-func searchAUR(query string) tea.Cmd {
-	return func() tea.Msg {
-		if query == "" {
-			return aurSearchMsg{packages: []Package{}, query: query}
+// isValidSetName checks that a set name can be written as a bare TOML key
+func isValidSetName(name string) bool {
+	if name == "" {
+		return false
+	}
+	for _, r := range name {
+		if !((r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z') ||
+			(r >= '0' && r <= '9') || r == '_' || r == '-') {
+			return false
 		}
+	}
+	return true
+}
 
-		// Sanitize search query - only allow safe characters for search
-		// This prevents command injection through the search query
-		var sanitized strings.Builder
-		for _, r := range query {
-			if (r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z') ||
-				(r >= '0' && r <= '9') || r == '-' || r == '_' || r == '.' {
-				sanitized.WriteRune(r)
-			} else if r == ' ' {
-				sanitized.WriteRune('-')
+// expandPackageSet resolves a named set into its package names, following
+// "@name" references to other sets. Duplicates are dropped, order is kept,
+// and reference cycles or unknown sets are reported as errors.
+func expandPackageSet(sets map[string][]string, name string) ([]string, error) {
+	var result []string
+	seen := make(map[string]bool)
+	var visit func(name string, chain []string) error
+	visit = func(name string, chain []string) error {
+		for _, visiting := range chain {
+			if visiting == name {
+				return fmt.Errorf("set cycle: %s", strings.Join(append(chain, name), " -> "))
 			}
-			// Skip any other characters (potential injection attempts)
 		}
-		searchQuery := sanitized.String()
-		if searchQuery == "" {
-			return aurSearchMsg{packages: []Package{}, query: query}
+		members, ok := sets[name]
+		if !ok {
+			return fmt.Errorf("unknown set %q", name)
 		}
-
-		// Search AUR only with paru -Ss --aur
-		cmd := exec.Command("paru", "-Ss", "-a", searchQuery)
-		var stdout bytes.Buffer
-		cmd.Stdout = &stdout
-		_ = cmd.Run()
-
-		if stdout.Len() == 0 {
-			return aurSearchMsg{packages: []Package{}, query: query}
+		chain = append(chain, name)
+		for _, member := range members {
+			if ref, isRef := strings.CutPrefix(member, "@"); isRef {
+				if err := visit(ref, chain); err != nil {
+					return err
+				}
+				continue
+			}
+			if !seen[member] {
+				seen[member] = true
+				result = append(result, member)
+			}
 		}
+		return nil
+	}
+	if err := visit(name, nil); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
 
-		packages := parseAUROutput(stdout.String())
-		return aurSearchMsg{packages: packages, query: query}
+// formatTOMLStringArray renders names as a single-line TOML string array
+func formatTOMLStringArray(names []string) string {
+	quoted := make([]string, len(names))
+	for i, name := range names {
+		quoted[i] = fmt.Sprintf("%q", name)
 	}
+	return "[" + strings.Join(quoted, ", ") + "]"
 }
 
-// parseAUROutput parses paru -Ss output for AUR packages
-func parseAUROutput(output string) []Package {
-	var packages []Package
-	lines := strings.Split(output, "\n")
+// saveConfigValues updates top-level keys in the config file in place, keeping
+// comments, ordering and any tables intact. Missing keys are inserted before
+// the first table header so they stay top-level.
+func saveConfigValues(path string, values map[string]string) error {
+	var lines []string
+	if data, err := os.ReadFile(path); err == nil {
+		lines = strings.Split(strings.TrimRight(string(data), "\n"), "\n")
+	} else if !os.IsNotExist(err) {
+		return err
+	}
 
-	for i := 0; i < len(lines); i++ {
-		line := lines[i]
-		// Skip empty lines and description lines (indented)
-		if line == "" || strings.HasPrefix(line, " ") || strings.HasPrefix(line, "\t") {
-			continue
+	written := make(map[string]bool)
+	insertAt := len(lines)
+	for i, line := range lines {
+		trimmed := strings.TrimSpace(line)
+		if strings.HasPrefix(trimmed, "[") {
+			insertAt = i
+			break
 		}
-
-		// Format: "aur/package version [+votes ~popularity] [Installed]"
-		parts := strings.Fields(line)
-		if len(parts) < 2 {
+		eq := strings.Index(trimmed, "=")
+		if eq == -1 || strings.HasPrefix(trimmed, "#") {
 			continue
 		}
-
-		repoPkg := strings.SplitN(parts[0], "/", 2)
-		if len(repoPkg) != 2 {
-			continue
+		key := strings.TrimSpace(trimmed[:eq])
+		if value, ok := values[key]; ok {
+			lines[i] = key + " = " + value + trailingComment(trimmed[eq+1:])
+			written[key] = true
 		}
+	}
 
-		pkg := Package{
-			Source:    repoPkg[0],
-			Name:      repoPkg[1],
-			Version:   parts[1],
-			Installed: strings.Contains(line, "[Installed"),
+	// Insert keys that were not already present, in a stable order
+	var missing []string
+	for key := range values {
+		if !written[key] {
+			missing = append(missing, key)
 		}
-
-		// Get description from next line
-		if i+1 < len(lines) && (strings.HasPrefix(lines[i+1], " ") || strings.HasPrefix(lines[i+1], "\t")) {
-			pkg.Description = strings.TrimSpace(lines[i+1])
+	}
+	sort.Strings(missing)
+	var inserted []string
+	for _, key := range missing {
+		inserted = append(inserted, key+" = "+values[key])
+	}
+	if len(inserted) > 0 && insertAt < len(lines) {
+		// Keep the blank line that separates top-level keys from the first table
+		for insertAt > 0 && strings.TrimSpace(lines[insertAt-1]) == "" {
+			insertAt--
+		}
+		inserted = append(inserted, "")
+		if insertAt < len(lines) && strings.TrimSpace(lines[insertAt]) == "" {
+			inserted = inserted[:len(inserted)-1]
 		}
-
-		packages = append(packages, pkg)
 	}
+	lines = append(lines[:insertAt], append(inserted, lines[insertAt:]...)...)
 
-	return packages
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	return writeFileAtomic(path, []byte(strings.Join(lines, "\n")+"\n"), validTOML)
 }
 
-func parseSearchOutput(output string) []Package {
-	var packages []Package
-	lines := strings.Split(output, "\n")
-
-	for i := 0; i < len(lines); i++ {
-		line := strings.TrimSpace(lines[i])
-		if line == "" {
-			continue
+// trailingComment returns the inline comment (with its leading space) of a
+// TOML value, ignoring '#' characters inside quoted strings
+func trailingComment(value string) string {
+	inQuotes := false
+	for i, r := range value {
+		switch {
+		case r == '"' && (i == 0 || value[i-1] != '\\'):
+			inQuotes = !inQuotes
+		case r == '#' && !inQuotes:
+			return " " + value[i:]
 		}
+	}
+	return ""
+}
 
-		// Package lines look like: " 1 aur/yay 12.5.7-1 [+2480 ~39.12]"
-		// or without number: "aur/yay 12.5.7-1 [+2480 ~39.12]"
-		if strings.Contains(line, "/") && !strings.HasPrefix(line, " ") || (len(line) > 0 && line[0] >= '0' && line[0] <= '9') {
-			// Strip leading number if present (e.g., "1 aur/yay" -> "aur/yay")
-			fields := strings.Fields(line)
-			if len(fields) == 0 {
-				continue
-			}
-			
-			// Find the field containing "/" (source/name)
-			pkgField := ""
-			pkgFieldIdx := 0
-			for idx, f := range fields {
-				if strings.Contains(f, "/") {
-					pkgField = f
-					pkgFieldIdx = idx
-					break
-				}
-			}
-			if pkgField == "" {
-				continue
-			}
+// settingRow describes one adjustable entry in the settings overlay
+type settingRow struct {
+	key         string
+	label       string
+	description string
+}
 
-			parts := strings.SplitN(pkgField, "/", 2)
-			if len(parts) < 2 {
-				continue
-			}
+// settingRows lists the settings overlay entries in display order
+var settingRows = []settingRow{
+	{"min_query_len", "Minimum search length", "Characters typed before install mode searches"},
+	{"info_debounce_ms", "Info debounce (ms)", "Delay before fetching info for the selected package"},
+	{"aur_auto_search", "AUR auto-search", "Search the AUR while typing (off: only with the a: prefix)"},
+	{"dep_tree_depth", "Dependency tree depth", "Levels shown by the [d] dependency tree"},
+	{"aur_debounce_ms", "AUR debounce (ms)", "Pause in typing before the AUR is searched"},
+	{"stream_output", "Stream output", "Run installs and updates with --noconfirm, showing their output in gaur"},
+	{"show_descriptions", "Show descriptions", "Show each result's description beside it when the terminal is wide enough"},
+	{"sync_stale_hours", "Stale sync after (h)", "Warn when the sync databases are older than this (0: never)"},
+	{"top_packages", "Top packages", "Biggest installed packages listed on the dashboard"},
+	{"auto_orphan_passes", "Auto orphan passes", "Remove orphans left by an orphan removal without asking again"},
+	{"usage_estimate", "Usage estimate", "Offer packages whose programs went unused for 90+ days in the cleanup wizard"},
+	{"prefetch_concurrency", "Prefetch concurrency", "AUR detail requests sent at once when checking many packages"},
+}
 
-			source := parts[0]
-			name := parts[1]
+// settingValue returns the display value of a settings row
+func (s Settings) settingValue(key string) string {
+	switch key {
+	case "min_query_len":
+		return fmt.Sprintf("%d", s.MinSearchQueryLen)
+	case "info_debounce_ms":
+		return fmt.Sprintf("%d", s.InfoDebounceMs)
+	case "aur_auto_search":
+		if s.AURAutoSearch {
+			return "true"
+		}
+		return "false"
+	case "dep_tree_depth":
+		return fmt.Sprintf("%d", s.DepTreeDepth)
+	case "aur_debounce_ms":
+		return fmt.Sprintf("%d", s.AURDebounceMs)
+	case "stream_output":
+		if s.StreamOutput {
+			return "true"
+		}
+		return "false"
+	case "show_descriptions":
+		if s.ShowDescriptions {
+			return "true"
+		}
+		return "false"
+	case "sync_stale_hours":
+		return fmt.Sprintf("%d", s.SyncStaleHours)
+	case "top_packages":
+		return fmt.Sprintf("%d", s.TopPackages)
+	case "auto_orphan_passes":
+		if s.AutoOrphanPasses {
+			return "true"
+		}
+		return "false"
+	case "usage_estimate":
+		if s.UsageEstimate {
+			return "true"
+		}
+		return "false"
+	case "prefetch_concurrency":
+		return fmt.Sprintf("%d", s.PrefetchConcurrency)
+	}
+	return ""
+}
 
-			// Version is the next field after source/name
-			version := ""
-			if pkgFieldIdx+1 < len(fields) {
-				version = fields[pkgFieldIdx+1]
-			}
+// adjustSetting returns a copy of s with the given key stepped by delta.
+// Booleans are toggled regardless of the sign of delta.
+func (s Settings) adjustSetting(key string, delta int) Settings {
+	switch key {
+	case "min_query_len":
+		s.MinSearchQueryLen += delta
+	case "info_debounce_ms":
+		s.InfoDebounceMs += delta * 50
+	case "aur_auto_search":
+		s.AURAutoSearch = !s.AURAutoSearch
+	case "dep_tree_depth":
+		s.DepTreeDepth += delta
+	case "aur_debounce_ms":
+		s.AURDebounceMs += delta * 50
+	case "stream_output":
+		s.StreamOutput = !s.StreamOutput
+	case "show_descriptions":
+		s.ShowDescriptions = !s.ShowDescriptions
+	case "sync_stale_hours":
+		s.SyncStaleHours += delta * 6
+	case "top_packages":
+		s.TopPackages += delta
+	case "auto_orphan_passes":
+		s.AutoOrphanPasses = !s.AutoOrphanPasses
+	case "usage_estimate":
+		s.UsageEstimate = !s.UsageEstimate
+	case "prefetch_concurrency":
+		s.PrefetchConcurrency += delta
+	}
+	return s
+}
 
-			// Check if installed
-			installed := strings.Contains(line, "[Installed")
-
-			// Get description from next line
-			description := ""
-			if i+1 < len(lines) {
-				description = strings.TrimSpace(lines[i+1])
-				i++ // Skip description line
-			}
+// rebuildBatchSize is the number of foreign packages rebuilt per paru invocation.
+// Splitting the rebuild keeps one failing build from aborting the rest.
+// Can be overridden with --rebuild-batch-size.
+var rebuildBatchSize = 10
 
-			packages = append(packages, Package{
-				Source:      source,
-				Name:        name,
-				Version:     version,
-				Description: description,
-				Installed:   installed,
-			})
+// isValidPackageName checks if a package name contains only safe characters.
+// Valid package names contain only alphanumeric, @, ., _, +, and - characters.
+// This prevents command injection through malicious package names.
+func isValidPackageName(name string) bool {
+	if name == "" {
+		return false
+	}
+	for _, r := range name {
+		if !((r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z') ||
+			(r >= '0' && r <= '9') || r == '@' || r == '.' ||
+			r == '_' || r == '+' || r == '-') {
+			return false
 		}
 	}
+	return true
+}
 
-	return packages
+// sanitizePackageNames filters a list of package names to only include valid ones.
+// Returns the filtered list and a boolean indicating if all names were valid.
+func sanitizePackageNames(names []string) ([]string, bool) {
+	var valid []string
+	allValid := true
+	for _, name := range names {
+		if isValidPackageName(name) {
+			valid = append(valid, name)
+		} else {
+			allValid = false
+		}
+	}
+	return valid, allValid
 }
 
-// debouncePackageInfo returns a command that waits for the debounce duration
-// then sends a tick message to trigger the actual fetch
-func debouncePackageInfo(pkgName string) tea.Cmd {
-	return tea.Tick(packageInfoDebounceTime, func(t time.Time) tea.Msg {
-		return debounceTickMsg{packageName: pkgName}
-	})
+// Package represents a package with its source and name
+type Package struct {
+	Source      string    // core, extra, multilib, aur
+	Name        string
+	Version     string
+	Description string
+	Installed   bool
+	Explicit    bool      // Explicitly installed (not a dependency)
+	Orphan      bool      // Orphan package (no longer required)
+	InstallDate time.Time // Installed packages: when it was installed
+	SizeBytes   int64     // Installed packages: installed size, 0 if unknown
+	OldVersion  string    // Updates only: installed version
+	NewVersion  string    // Updates only: available version
+	Devel       bool      // Updates only: VCS rebuild found by paru --devel
+
+	// AUR metadata, filled in from the RPC
+	Votes        int
+	Popularity   float64
+	Maintainer   string
+	Orphaned     bool  // No maintainer
+	OutOfDate    int64 // Unix time the package was flagged, 0 if not flagged
+	LastModified int64
 }
 
-func getPackageInfo(pkg Package) tea.Cmd {
-	return func() tea.Msg {
-		// Validate package name to prevent command injection
-		if !isValidPackageName(pkg.Name) {
-			return packageInfoMsg{info: "Invalid package name", packageName: pkg.Name, err: fmt.Errorf("invalid package name: %s", pkg.Name)}
-		}
+func (p Package) String() string {
+	return fmt.Sprintf("%s/%s", p.Source, p.Name)
+}
 
-		cmd := exec.Command("paru", "-Si", pkg.Name)
-		var out bytes.Buffer
-		cmd.Stdout = &out
-		cmd.Stderr = &out
+// useFzf ranks search results with the external fzf binary instead of the
+// built-in matcher. Set with --use-fzf.
+var useFzf bool
 
-		err := cmd.Run()
-		if err != nil {
-			return packageInfoMsg{info: "Failed to get package info", packageName: pkg.Name, err: err}
-		}
+// Scoring for the built-in fuzzy matcher, following fzf's constants: matches
+// score, gaps cost, and matches on word boundaries or in runs earn a bonus
+const (
+	fuzzyScoreMatch        = 16
+	fuzzyScoreGapStart     = -3
+	fuzzyScoreGapExtension = -1
+	fuzzyBonusBoundary     = fuzzyScoreMatch / 2
+	fuzzyBonusCamel123     = fuzzyBonusBoundary + fuzzyScoreGapExtension
+	fuzzyBonusConsecutive  = -(fuzzyScoreGapStart + fuzzyScoreGapExtension)
+	fuzzyBonusFirstChar    = 2 // Multiplier for the bonus of the first query character
+)
 
-		return packageInfoMsg{info: out.String(), packageName: pkg.Name}
+// fuzzyBonus is the bonus for matching text[i], based on the character before it
+func fuzzyBonus(text []rune, i int) int {
+	if i == 0 {
+		return fuzzyBonusBoundary
+	}
+	prev, cur := text[i-1], text[i]
+	switch {
+	case strings.ContainsRune("-_./+@ ", prev):
+		return fuzzyBonusBoundary
+	case unicode.IsLower(prev) && unicode.IsUpper(cur),
+		!unicode.IsDigit(prev) && unicode.IsDigit(cur):
+		return fuzzyBonusCamel123
 	}
+	return 0
 }
 
-func getInstalledPackages() tea.Cmd {
-	return func() tea.Msg {
-		// Use pacman -Qi to get all installed package info including repository
-		cmd := exec.Command("pacman", "-Qi")
-		var out bytes.Buffer
-		cmd.Stdout = &out
-		cmd.Stderr = &out
-
-		err := cmd.Run()
-		if err != nil {
-			return installedPackagesMsg{err: err}
+// fuzzyMatch scores text against query and returns the rune positions of the
+// best alignment. Matching is case-insensitive unless the query contains an
+// uppercase letter. It is a Smith-Waterman style alignment with affine gap
+// penalties, so the highlighted characters are the ones that were scored.
+func fuzzyMatch(text, query string) (int, []int, bool) {
+	t := []rune(text)
+	q := []rune(query)
+	if len(q) == 0 || len(q) > len(t) {
+		return 0, nil, false
+	}
+	// Fold rune by rune so positions stay aligned with the original text
+	folded := t
+	if strings.ToLower(query) == query {
+		folded = make([]rune, len(t))
+		for i, r := range t {
+			folded[i] = unicode.ToLower(r)
 		}
-
-		packages := parseInstalledPackages(out.String())
-		return installedPackagesMsg{packages: packages}
 	}
-}
-
-func parseInstalledPackages(output string) []Package {
-	var packages []Package
-	blocks := strings.Split(output, "\n\n")
 
-	for _, block := range blocks {
-		if strings.TrimSpace(block) == "" {
-			continue
+	// Cheap subsequence check before the full alignment
+	qi := 0
+	for _, r := range folded {
+		if qi < len(q) && r == q[qi] {
+			qi++
 		}
+	}
+	if qi < len(q) {
+		return 0, nil, false
+	}
 
-		var pkg Package
-		pkg.Installed = true
-		pkg.Source = "local" // default
-
-		lines := strings.Split(block, "\n")
-		for _, line := range lines {
-			if strings.HasPrefix(line, "Name") {
-				parts := strings.SplitN(line, ":", 2)
-				if len(parts) == 2 {
-					pkg.Name = strings.TrimSpace(parts[1])
+	const none = math.MinInt32
+	n := len(t)
+	score := make([][]int, len(q)) // score[i][j]: best with q[i] matched at t[j]
+	from := make([][]int, len(q))  // from[i][j]: position of q[i-1] in that alignment
+	cells := make([]int, 2*len(q)*n) // One allocation backs both tables
+	for i := range q {
+		score[i] = cells[2*i*n : (2*i+1)*n]
+		from[i] = cells[(2*i+1)*n : (2*i+2)*n]
+		// Best alignment of q[i-1] ending before a gap up to j, with the gap cost
+		gapBest, gapFrom := none, -1
+		for j := 0; j < n; j++ {
+			score[i][j] = none
+			if i > 0 && j >= 2 {
+				if gapBest != none {
+					gapBest += fuzzyScoreGapExtension
 				}
-			} else if strings.HasPrefix(line, "Version") {
-				parts := strings.SplitN(line, ":", 2)
-				if len(parts) == 2 {
-					pkg.Version = strings.TrimSpace(parts[1])
+				if prev := score[i-1][j-2]; prev != none && prev+fuzzyScoreGapStart > gapBest {
+					gapBest, gapFrom = prev+fuzzyScoreGapStart, j-2
 				}
-			} else if strings.HasPrefix(line, "Description") {
-				parts := strings.SplitN(line, ":", 2)
-				if len(parts) == 2 {
-					pkg.Description = strings.TrimSpace(parts[1])
+			}
+			if folded[j] != q[i] {
+				continue
+			}
+			bonus := fuzzyBonus(t, j)
+			if i == 0 {
+				score[i][j] = fuzzyScoreMatch + bonus*fuzzyBonusFirstChar
+				continue
+			}
+			// Continue a run from the previous character, or jump over a gap
+			best, bestFrom := none, -1
+			if j > 0 && score[i-1][j-1] != none {
+				run := bonus
+				if run < fuzzyBonusConsecutive {
+					run = fuzzyBonusConsecutive
 				}
+				best, bestFrom = score[i-1][j-1]+run, j-1
+			}
+			if gapBest != none && gapBest+bonus > best {
+				best, bestFrom = gapBest+bonus, gapFrom
+			}
+			if best != none {
+				score[i][j] = fuzzyScoreMatch + best
+				from[i][j] = bestFrom
 			}
 		}
+	}
 
-		if pkg.Name != "" {
-			packages = append(packages, pkg)
+	last := len(q) - 1
+	best, end := none, -1
+	for j := 0; j < n; j++ {
+		if score[last][j] > best {
+			best, end = score[last][j], j
 		}
 	}
+	if end < 0 {
+		return 0, nil, false
+	}
+	positions := make([]int, len(q))
+	for i := last; i >= 0; i-- {
+		positions[i] = end
+		end = from[i][end]
+	}
+	return best, positions, true
+}
 
-	// Build a map of package name -> repository from pacman -Sl
-	// This gives us the actual repo (core, extra, multilib) for installed packages
-	repoMap := make(map[string]string)
-	cmd := exec.Command("pacman", "-Sl")
-	var repoOut bytes.Buffer
-	cmd.Stdout = &repoOut
-	if cmd.Run() == nil {
-		for _, line := range strings.Split(repoOut.String(), "\n") {
-			parts := strings.Fields(line)
-			if len(parts) >= 2 {
-				// Format: "repo name version [installed]"
-				repoMap[parts[1]] = parts[0]
+// searchTerms splits a query into the space-separated terms that must all
+// match and the !-prefixed terms that must not. A lone "!" is ignored.
+func searchTerms(query string) (include, exclude []string) {
+	for _, term := range strings.Fields(query) {
+		if strings.HasPrefix(term, "!") {
+			if term != "!" {
+				exclude = append(exclude, term[1:])
 			}
+			continue
 		}
+		include = append(include, term)
 	}
+	return include, exclude
+}
 
-	// Apply actual repository to installed packages
-	for i := range packages {
-		if repo, ok := repoMap[packages[i].Name]; ok {
-			packages[i].Source = repo
-		}
+// containsTerm reports whether text contains term, ignoring case unless the
+// term has an uppercase letter, like fuzzyMatch
+func containsTerm(text, term string) bool {
+	if strings.ToLower(term) == term {
+		text = strings.ToLower(text)
 	}
+	return strings.Contains(text, term)
+}
 
-	// Get foreign packages (AUR) to mark them
-	cmd = exec.Command("pacman", "-Qm")
-	var foreignOut bytes.Buffer
-	cmd.Stdout = &foreignOut
-	if cmd.Run() == nil {
-		foreignPkgs := make(map[string]bool)
-		for _, line := range strings.Split(foreignOut.String(), "\n") {
-			parts := strings.Fields(line)
-			if len(parts) >= 1 {
-				foreignPkgs[parts[0]] = true
-			}
+// fuzzyMatchTerms matches a query of space-separated terms, each of which must
+// match, as fzf's extended search does. A !-prefixed term rejects texts that
+// contain it, again like fzf. Scores add up and the positions of each term
+// are merged in order; a query of exclusions only matches everything else
+// with no positions.
+func fuzzyMatchTerms(text, query string) (int, []int, bool) {
+	terms, exclude := searchTerms(query)
+	for _, term := range exclude {
+		if containsTerm(text, term) {
+			return 0, nil, false
 		}
-		for i := range packages {
-			if foreignPkgs[packages[i].Name] {
-				packages[i].Source = "aur"
+	}
+	if len(terms) == 1 {
+		return fuzzyMatch(text, terms[0])
+	}
+	if len(terms) == 0 {
+		return 0, nil, len(exclude) > 0
+	}
+	total := 0
+	seen := make(map[int]bool)
+	var positions []int
+	for _, term := range terms {
+		score, termPositions, ok := fuzzyMatch(text, term)
+		if !ok {
+			return 0, nil, false
+		}
+		total += score
+		for _, p := range termPositions {
+			if !seen[p] {
+				seen[p] = true
+				positions = append(positions, p)
 			}
 		}
 	}
+	if len(positions) == 0 {
+		return 0, nil, false
+	}
+	sort.Ints(positions)
+	return total, positions, true
+}
 
-	// Get explicitly installed packages
-	cmd = exec.Command("pacman", "-Qe")
-	var explicitOut bytes.Buffer
-	cmd.Stdout = &explicitOut
-	if cmd.Run() == nil {
-		explicitPkgs := make(map[string]bool)
-		for _, line := range strings.Split(explicitOut.String(), "\n") {
-			parts := strings.Fields(line)
-			if len(parts) >= 1 {
-				explicitPkgs[parts[0]] = true
-			}
-		}
-		for i := range packages {
-			packages[i].Explicit = explicitPkgs[packages[i].Name]
-		}
+// fuzzyFilter ranks packages by how well their names match the query. Ties
+// prefer matches that start earlier, then shorter names, like fzf's
+// --tiebreak=begin,length. Match positions aren't kept: View recomputes them
+// for the rows it shows (see matchedDisplayIndices).
+func fuzzyFilter(packages []Package, query string) []Package {
+	if query == "" || len(packages) == 0 {
+		return packages
 	}
+	refs := make([]*Package, len(packages))
+	for i := range packages {
+		refs[i] = &packages[i]
+	}
+	return fuzzyFilterRefs(refs, query)
+}
 
-	// Get orphan packages
-	cmd = exec.Command("pacman", "-Qdt")
-	var orphanOut bytes.Buffer
-	cmd.Stdout = &orphanOut
-	if cmd.Run() == nil {
-		orphanPkgs := make(map[string]bool)
-		for _, line := range strings.Split(orphanOut.String(), "\n") {
-			parts := strings.Fields(line)
-			if len(parts) >= 1 {
-				orphanPkgs[parts[0]] = true
+// fuzzyFilterRefs is fuzzyFilter over package pointers. Candidates are ranked
+// by index, so only the matches are ever copied.
+func fuzzyFilterRefs(packages []*Package, query string) []Package {
+	if useFzf {
+		return fzfFilter(packages, query)
+	}
+
+	type ranked struct {
+		index int
+		score int
+		first int // Position of the first matched character
+	}
+	matches := make([]ranked, 0, len(packages)/8+1)
+	for i, pkg := range packages {
+		if score, positions, ok := fuzzyMatchTerms(pkg.Name, query); ok {
+			first := 0
+			if len(positions) > 0 {
+				first = positions[0]
 			}
+			matches = append(matches, ranked{i, score, first})
 		}
-		for i := range packages {
-			packages[i].Orphan = orphanPkgs[packages[i].Name]
+	}
+	sort.SliceStable(matches, func(a, b int) bool {
+		x, y := matches[a], matches[b]
+		if x.score != y.score {
+			return x.score > y.score
+		}
+		if x.first != y.first {
+			return x.first < y.first
 		}
+		return len(packages[x.index].Name) < len(packages[y.index].Name)
+	})
+
+	result := make([]Package, len(matches))
+	for i, match := range matches {
+		result[i] = *packages[match.index]
 	}
+	return result
+}
 
-	return packages
+// matchedDisplayIndices returns the characters of the list entry
+// "source/name" matched by query, if the name matches it
+func matchedDisplayIndices(pkg Package, query string) ([]int, bool) {
+	if query == "" {
+		return nil, false
+	}
+	_, positions, ok := fuzzyMatchTerms(pkg.Name, query)
+	if !ok {
+		return nil, false
+	}
+	return displayIndices(pkg, positions), true
 }
 
-func getDashboardData() tea.Cmd {
-	return func() tea.Msg {
-		var data DashboardData
+// displayIndices shifts name positions past the "source/" prefix of the list entry
+func displayIndices(pkg Package, positions []int) []int {
+	offset := utf8.RuneCountInString(pkg.Source) + 1
+	shifted := make([]int, len(positions))
+	for i, p := range positions {
+		shifted[i] = p + offset
+	}
+	return shifted
+}
 
-		// Total Packages: paru -Q
-		cmd := exec.Command("paru", "-Q")
-		var out bytes.Buffer
-		cmd.Stdout = &out
-		if err := cmd.Run(); err == nil {
-			data.TotalPackages = countLines(out.String())
-		}
+// fzfFilter filters packages using fzf for fuzzy matching.
+// Returns filtered packages sorted by fzf's relevance ranking; they are
+// highlighted with the built-in matcher's alignment since fzf --filter doesn't
+// report positions.
+func fzfFilter(packages []*Package, query string) []Package {
+	// Build input for fzf: one package name per line with index
+	var input strings.Builder
+	for i, pkg := range packages {
+		input.WriteString(fmt.Sprintf("%d\t%s\n", i, pkg.Name))
+	}
 
-		// Explicitly Installed: paru -Qe
-		out.Reset()
-		cmd = exec.Command("paru", "-Qe")
-		cmd.Stdout = &out
-		if err := cmd.Run(); err == nil {
-			data.ExplicitlyInstalled = countLines(out.String())
-		}
+	// Use fzf --filter for non-interactive fuzzy filtering
+	// -d '\t' -n2: only match on second field (package name), not the index
+	// --tiebreak=begin,length: prefer matches at start and shorter names
+	cmd := exec.Command("fzf", "--filter", query, "-d", "\t", "-n2", "--tiebreak=begin,length")
+	cmd.Stdin = strings.NewReader(input.String())
+	var stdout bytes.Buffer
+	cmd.Stdout = &stdout
+	start := time.Now()
+	err := cmd.Run() // fzf returns error if no matches, that's ok
+	logExec(cmd, start, err)
 
-		// Foreign Packages: paru -Qm
-		out.Reset()
-		cmd = exec.Command("paru", "-Qm")
-		cmd.Stdout = &out
-		if err := cmd.Run(); err == nil {
-			data.ForeignPackages = countLines(out.String())
+	// Parse output and rebuild package list
+	var result []Package
+	lines := strings.Split(strings.TrimSpace(stdout.String()), "\n")
+	for _, line := range lines {
+		if line == "" {
+			continue
 		}
-
-		// Orphans: paru -Qdt
-		out.Reset()
-		cmd = exec.Command("paru", "-Qdt")
-		cmd.Stdout = &out
-		if err := cmd.Run(); err == nil {
-			data.Orphans = countLines(out.String())
+		parts := strings.SplitN(line, "\t", 2)
+		if len(parts) >= 1 {
+			var idx int
+			if _, err := fmt.Sscanf(parts[0], "%d", &idx); err == nil && idx >= 0 && idx < len(packages) {
+				result = append(result, *packages[idx])
+			}
 		}
+	}
 
-		// Stats from paru -Ps (Total Size, Missing from AUR, Top 10 packages)
-		out.Reset()
-		cmd = exec.Command("paru", "-Ps")
-		cmd.Stdout = &out
-		if err := cmd.Run(); err == nil {
-			data.TotalSize, data.TotalSizeBytes, data.MissingFromAUR, data.TopPackages = parseParuStats(out.String())
+	// If fzf found nothing, fall back to substring match
+	if len(result) == 0 {
+		queryLower := strings.ToLower(query)
+		for _, pkg := range packages {
+			if strings.Contains(strings.ToLower(pkg.Name), queryLower) {
+				result = append(result, *pkg)
+			}
 		}
+	}
+	return result
+}
 
-		// Calculate Pacman Cache (System)
-		pacmanCachePath := "/var/cache/pacman/pkg"
-		pacmanCacheSize := calculateDirSize(pacmanCachePath)
+// Messages
+type repoPackagesMsg struct {
+	packages  []Package
+	groups    map[string][]string // Members of each sync database group
+	installed map[string]bool     // Every installed package, foreign ones included
+	err       error
+	ch        <-chan tea.Msg // Set when a refresh follows these cached packages
+}
 
-		// Calculate Paru Cache (User)
-		homeDir, _ := os.UserHomeDir()
-		paruCachePath := filepath.Join(homeDir, ".cache", "paru")
-		paruCacheSize := calculateDirSize(paruCachePath)
+type aurSearchMsg struct {
+	packages []Package
+	query    string
+	seq      int // Sequence number of the search, see model.aurSearchSeq
+	err      error
+}
 
-		// Store individual cache info
-		data.PacmanCachePath = pacmanCachePath
-		data.PacmanCacheSizeBytes = pacmanCacheSize
-		data.PacmanCacheSize = formatBytes(pacmanCacheSize)
-		data.ParuCachePath = paruCachePath
-		data.ParuCacheSizeBytes = paruCacheSize
-		data.ParuCacheSize = formatBytes(paruCacheSize)
+type packageInfoMsg struct {
+	info        string
+	localized   string // info in the user's locale, empty when that's English
+	packageName string
+	err         error
+}
 
-		// Combine them for total
-		totalCacheBytes := pacmanCacheSize + paruCacheSize
+// installedNamesMsg carries the names from pacman -Qq for reconciling installedSet
+type installedNamesMsg struct {
+	names map[string]bool
+	err   error
+}
 
-		data.CleanerSizeBytes = totalCacheBytes
-		data.CleanerSize = formatBytes(totalCacheBytes)
+// refreshTickMsg fires once a burst of refresh requests has settled
+type refreshTickMsg struct{}
 
-		return dashboardMsg{data: data}
-	}
+type installedPackagesMsg struct {
+	packages []Package
+	provided map[string]bool // Names provided by installed packages
+	err      error
 }
 
-func countLines(output string) int {
-	lines := strings.Split(strings.TrimSpace(output), "\n")
-	if len(lines) == 1 && lines[0] == "" {
-		return 0
-	}
-	return len(lines)
+type updateCheckMsg struct {
+	updates []classifiedUpdate
+	err     error
 }
 
-// parseParuStats extracts total installed size, missing AUR package count,
-// and top 10 biggest packages from paru -Ps output.
-func parseParuStats(output string) (totalSize string, totalSizeBytes int64, missingAUR int, topPackages []PackageSize) {
-	lines := strings.Split(output, "\n")
-	inTopPackages := false
-
-	for _, line := range lines {
-		line = strings.TrimSpace(line)
+type execCompleteMsg struct {
+	operation confirmationType
+	packages  []string
+	started   time.Time
+	logPath   string // Teed output of install and update runs, if any
+	output    []string // Last lines printed by a failed command, if captured
+	retry     func() tea.Cmd // Runs the same command again; nil when it can't be
+	freePath  string // Filesystem measured before and after cache cleaning
+	freeBefore int64 // Free bytes on freePath before the run started
+	queued    bool  // A step of a queued transaction
+	batch     string // "2/5" for one batch of a batched operation
+	err       error
+}
 
-		// Check for start of top packages section
-		if strings.Contains(line, "biggest packages") {
-			inTopPackages = true
-			continue
-		}
+// recoveryCheckMsg carries the result of the startup interrupted-transaction check
+type recoveryCheckMsg struct {
+	findings []recoveryFinding
+}
 
-		// End of top packages section (separator line or empty)
-		if inTopPackages && (strings.HasPrefix(line, "===") || line == "") {
-			inTopPackages = false
-			continue
-		}
+type dashboardMsg struct {
+	data DashboardData
+	err  error
+}
 
-		// Parse top package lines (format: "package-name: 123.45 MiB")
-		if inTopPackages {
-			parts := strings.SplitN(line, ":", 2)
-			if len(parts) == 2 {
-				topPackages = append(topPackages, PackageSize{
-					Name: strings.TrimSpace(parts[0]),
-					Size: strings.TrimSpace(parts[1]),
-				})
-			}
-			continue
-		}
+// debounceTickMsg is sent after debounce timer expires to trigger package info fetch
+type debounceTickMsg struct {
+	packageName string
+}
 
-		if strings.Contains(line, "Total Size occupied") || strings.Contains(line, "Total Installed Size") {
-			parts := strings.SplitN(line, ":", 2)
-			if len(parts) == 2 {
-				totalSize = strings.TrimSpace(parts[1])
-				totalSizeBytes = parseSizeToBytes(totalSize)
-			}
-		}
-		if strings.Contains(line, "Missing") && strings.Contains(line, "AUR") {
-			// Extract number from line like "Missing AUR Packages: 3"
-			parts := strings.SplitN(line, ":", 2)
-			if len(parts) == 2 {
-				_, _ = fmt.Sscanf(strings.TrimSpace(parts[1]), "%d", &missingAUR)
-			}
-		}
-	}
-	return
+// aurSearchTickMsg fires once typing has paused long enough to search the AUR
+type aurSearchTickMsg struct {
+	query string
+	seq   int
 }
 
-// parseSizeToBytes converts a human-readable size (e.g., "10.5 GiB") to bytes
-func parseSizeToBytes(size string) int64 {
-	size = strings.TrimSpace(size)
-	var value float64
-	var unit string
-	_, _ = fmt.Sscanf(size, "%f %s", &value, &unit)
-	
-	unit = strings.ToLower(unit)
-	switch {
-	case strings.HasPrefix(unit, "kib") || strings.HasPrefix(unit, "kb"):
-		return int64(value * 1024)
-	case strings.HasPrefix(unit, "mib") || strings.HasPrefix(unit, "mb"):
-		return int64(value * 1024 * 1024)
-	case strings.HasPrefix(unit, "gib") || strings.HasPrefix(unit, "gb"):
-		return int64(value * 1024 * 1024 * 1024)
-	case strings.HasPrefix(unit, "tib") || strings.HasPrefix(unit, "tb"):
-		return int64(value * 1024 * 1024 * 1024 * 1024)
-	default:
-		return int64(value)
-	}
+// DashboardData holds system package statistics
+type DashboardData struct {
+	TotalPackages       int
+	ExplicitlyInstalled int
+	ForeignPackages     int
+	TotalSize           string
+	TotalSizeBytes      int64 // For comparison
+	CleanerSize         string
+	CleanerSizeBytes    int64 // For comparison and coloring
+	PacmanCacheSize     string
+	PacmanCacheSizeBytes int64
+	PacmanCachePath     string
+	ParuCacheSize       string
+	ParuCacheSizeBytes  int64
+	ParuCachePath       string
+	Orphans             int
+	MissingFromAUR      int
+	TopPackages         []PackageSize // The biggest installed packages, at most maxTopPackages, biggest first
+	CacheDirSizes       []CacheDirSize // Monitored and package manager cache directories
+	ThirdPartyRepos     []RepoCount    // Installed packages per unofficial repository
+	LastUpgrade         time.Time      // Start of the last completed full system upgrade; zero if unknown
+	Filesystems         []FilesystemUsage // Root, and /var when it is a separate filesystem
+	CacheFreeBytes      int64             // Free space on the pacman cache's filesystem; 0 if unknown
 }
 
-// calculateDirSize walks a directory and returns the total size of all files in bytes.
-// It gracefully handles permission errors by skipping inaccessible files.
-func calculateDirSize(path string) int64 {
-	var size int64
-	err := filepath.WalkDir(path, func(_ string, d os.DirEntry, err error) error {
-		if err != nil {
-			// If we can't read a specific file/dir, just skip it and continue
-			return nil
-		}
-		if !d.IsDir() {
-			info, err := d.Info()
-			if err == nil {
-				size += info.Size()
-			}
-		}
-		return nil
-	})
+// FilesystemUsage is a filesystem's space as statfs reports it
+type FilesystemUsage struct {
+	Path      string
+	UsedBytes int64
+	FreeBytes int64 // Available to unprivileged users
+}
 
-	if err != nil {
+// UsedPercent is the share of the filesystem in use, computed like df: the
+// blocks reserved for root count as neither used nor free
+func (f FilesystemUsage) UsedPercent() float64 {
+	if f.UsedBytes+f.FreeBytes == 0 {
 		return 0
 	}
-	return size
+	return float64(f.UsedBytes) / float64(f.UsedBytes+f.FreeBytes) * 100
 }
 
-// formatBytes converts bytes to human-readable format
-func formatBytes(bytes int64) string {
-	const unit = 1024
-	if bytes < unit {
-		return fmt.Sprintf("%d B", bytes)
-	}
-	div, exp := int64(unit), 0
-	for n := bytes / unit; n >= unit; n /= unit {
-		div *= unit
-		exp++
-	}
-	return fmt.Sprintf("%.1f %ciB", float64(bytes)/float64(div), "KMGTPE"[exp])
+// CacheDirSize is the measured size of a monitored cache directory
+type CacheDirSize struct {
+	CacheDir
+	Bytes int64
 }
 
-// cleanCache runs paru -Sc to clean package cache
-func cleanCache() tea.Cmd {
-	return func() tea.Msg {
-		cmd := exec.Command("paru", "-Sc", "--noconfirm")
-		var out bytes.Buffer
-		cmd.Stdout = &out
-		cmd.Stderr = &out
-		err := cmd.Run()
-		return cleanCacheMsg{output: out.String(), err: err}
-	}
+// PackageSize holds package name and its installed size
+type PackageSize struct {
+	Name   string
+	Size   string
+	Bytes  int64
+	Source string // Repository, or "aur" for foreign packages; "" if unknown
 }
 
-// removeOrphans runs paru -Rns to remove orphan packages
-func removeOrphans() tea.Cmd {
-	return func() tea.Msg {
-		// First get the list of orphans
-		cmd := exec.Command("paru", "-Qdtq")
-		var orphanList bytes.Buffer
-		cmd.Stdout = &orphanList
-		if err := cmd.Run(); err != nil || orphanList.Len() == 0 {
-			return removeOrphansMsg{output: "No orphans to remove", err: nil}
-		}
-		
-		// Validate orphan names before using them (defense in depth)
-		orphans := strings.Fields(orphanList.String())
-		validOrphans, _ := sanitizePackageNames(orphans)
-		if len(validOrphans) == 0 {
-			return removeOrphansMsg{output: "No valid orphans to remove", err: nil}
-		}
-
-		// Remove them
-		args := append([]string{"-Rns", "--noconfirm"}, validOrphans...)
-		cmd = exec.Command("paru", args...)
-		var out bytes.Buffer
-		cmd.Stdout = &out
-		cmd.Stderr = &out
-		err := cmd.Run()
-		return removeOrphansMsg{output: out.String(), err: err}
-	}
+// Model
+type model struct {
+	textInput             textinput.Model
+	repoPackages          []Package       // All repo packages from local cache
+	aurPackages           []Package       // AUR packages from last search
+	installedSet          map[string]bool // Quick lookup for installed packages
+	installedProvides     map[string]bool // Names provided by installed packages, see isVirtualPackage
+	repoLoadStatus        string          // Current stage of the initial repo package load
+	repoIndex             map[string][]int // Positions of each name in repoPackages
+	repoGroups            map[string][]string // Members of each sync database group
+	refreshPending        bool            // A coalesced refresh is scheduled
+	refreshRepo           bool            // The scheduled refresh reloads the repo list
+	packages              []Package
+	filtered              []Package
+	installed             []Package
+	filteredInstalled     []Package
+	matchQuery            string     // Query the install results were fuzzy matched with, for highlighting
+	installedMatchQuery   string     // Query the remove mode results were fuzzy matched with
+	candidateBuf          []*Package // Search candidates, reused across keystrokes
+	selectedIndex         int
+	markedPackages        map[string]bool // Packages marked for batch operation
+	selectionPanelFocused bool            // Whether selection panel is focused
+	selectionPanelIndex   int             // Selected index within selection panel
+	packageInfo           string
+	packageDetails        PackageDetails // packageInfo parsed once when it is set
+	packageDetailsOK      bool           // Whether packageInfo parsed as package info
+	infoForPackage        string
+	localizedInfo         string // packageInfo in the user's locale, shown in its place
+	localizedInfoFor      string // Package localizedInfo belongs to
+	pendingInfoPackage    string // Package waiting for debounce to complete
+	loadingInfo           bool
+	mode                  viewMode
+	width                 int
+	height                int
+	loading               bool
+	statusMessage         string
+	updateOutput          string
+	lastQuery             string
+	lastAURQuery          string // Last query sent to AUR search
+	queryHistory          map[viewMode][]string // Submitted queries per mode, oldest first
+	resultSorts           map[viewMode]resultSort // Order of the results list per mode
+	unsortedResults       []Package               // The results list in relevance order
+	hideInstalled         bool                    // Install mode leaves installed packages out of the results
+	hiddenInstalled       int                     // Installed packages left out of the shown results
+	historyRecall         int                   // How far back ctrl+p has recalled, 0 when not recalling
+	searchingAUR          bool   // Whether AUR search is in progress
+	aurSearchSeq          int                // Sequence number of the live AUR search; older results are dropped
+	aurSearchCancel       context.CancelFunc // Kills the live paru -Ss
+	aurDebounceSeq        int                // Sequence number of the pending AUR search debounce
+	ownerSeq              int                // Sequence number of the live "own:" lookup
+	ownerResults          []Package          // Owners found by the last "own:" lookup
+	filesDBPrompted       bool               // Offered to download the file database this session
+	dashboard             DashboardData
+	dashboardSelected     int             // Index of the dashboard row under the cursor, see dashboardItems
+	selectAfterLoad       string          // Package selected once remove mode's list loads
+	savedSelection        *savedSelection // Put back once the list reloads after an operation
+	// Confirmation dialog state
+	showConfirmation      bool
+	confirmType           confirmationType
+	confirmPackages       []string  // Package names to operate on
+	pendingUpdates        []Package // Updates available (for update confirmation)
+	classifiedUpdates     []classifiedUpdate     // Every update from the last check, held and ignored included
+	updateCollapsed       map[updateClass]bool   // Collapsed sections of the update dialog
+	holds                 map[string]bool        // Packages gaur keeps back from updates
+	pins                  map[string]string      // Packages gaur keeps at a version, name -> version
+	develUpdates          bool                   // Check and rebuild VCS packages with paru --devel
+	news                  []archNewsItem         // Arch news since the last system upgrade
+	newsSince             time.Time              // Last system upgrade the news is compared against
+	newsLoading           bool                   // News fetch in flight
+	newsErr               error                  // News fetch failed
+	newsOffset            int                    // Scroll offset of the news in the update dialog
+	newsAcknowledged      bool                   // Manual intervention news was acknowledged with r
+	history               []historyTransaction   // Transactions from pacman.log, oldest first
+	historyErr            error                  // pacman.log could not be read
+	filteredHistory       []historyEntry         // History entries matching the filter, newest first
+	historyMatches        map[int][]int          // Matched name positions per filtered entry
+	aurDepIssues          map[string][]aurDependencyIssue // AUR dependency problems per update
+	aurDepChecking        bool                            // AUR dependency check in flight
+	aurDepCheckSeq        int                             // Sequence number of the latest AUR dependency check; older results are dropped
+	aurDepCheckErr        error                           // AUR dependency check failed
+	confirmScrollOffset   int       // Scroll offset for confirmation package list
+	txPreview             transactionPreview // Full transaction of the install or removal dialog
+	removalFlags          string             // Removal mode flags, kept for the session; "" means -Rns
+	cacheClean            cacheCleanOptions  // Strategy of the clean cache dialog, kept for the session
+	startMode             viewMode           // default_mode from the config, entered once the package database loads
+	exitAfter             bool               // --exit-after: quit once the -S or -R transaction succeeds
+	keys                  Keymap             // Key bindings from the config; Update sees remapped keys as their defaults
+	infoScroll            int                // Lines the info panel is scrolled down by the mouse wheel
+	resultsStart          int                // First list index in the results window
+	confirmTyped          string             // Typed confirmation for removals others depend on
+	confirmAsDeps         bool               // Install reason dialog marks packages as dependencies
+	markAllArmed          string             // Mode, query and count a large ctrl+a is waiting to be confirmed for
+	queuedInstalls        map[string]bool    // Packages queued with Q for installation
+	queuedRemovals        map[string]string  // Packages queued with Q for removal, to the removal flags they were queued with
+	runningQueue          []queueStep        // Steps of the queued transaction being run
+	queueStepIndex        int                // Step of runningQueue in progress
+	confirmCursor         int             // Cursor row for dialogs with per-row toggles
+	confirmExcluded       map[string]bool // Rows toggled off in the confirmation dialog
+	rebuildCandidates     []Package       // Foreign packages offered for rebuild
+	rebuildBatches        [][]string      // Pending rebuild batches, run one ExecProcess each
+	rebuildBatchIndex     int             // Index of the batch currently running
+	rebuildFailed         []string        // Packages from batches that failed to rebuild
+	rebuildOutput         []string        // Output of the last batch that failed
+	orphanPasses          int             // Orphan removal passes run until no orphans remain
+	orphansRemoved        int             // Orphans removed over those passes
+	lastOrphanPass        []string        // Orphans the last pass removed
+	orphanSizes           map[string]int64 // Installed size of each orphan in the dialog; nil while loading
+	rebuildSucceeded      int             // Number of packages rebuilt successfully
+	lastCompletedOp       string    // Description of last completed operation
+	// Session summary printed on exit
+	sessionOps      []sessionOperation
+	sessionWarnings []string
+	sessionSearches int // AUR searches sent this session
+	// AUR details from batched /info requests, keyed by package name
+	aurInfoCache map[string]*aurPackageInfo
+	aurHydrating map[string]bool // Requested from /info and not answered yet
+	// Session stats overlay
+	showSessionStats   bool
+	sessionStatsOffset int
+
+	// Operation log overlay
+	showOpLog   bool
+	opLogLines  []string
+	opLogOffset int
+	opLogErr    error
+	// Settings overlay state
+	settings              Settings
+	configPath            string
+	showSettings          bool
+	settingsIndex         int
+	// Monitored cache directories
+	cacheDirs             []CacheDir
+	monitoredCaches       []string // nil: all cacheDirs are monitored
+	showCacheDirs         bool
+	cacheDirIndex         int
+	// Cache breakdown overlay: the pacman cache and each AUR build directory
+	showBreakdown         bool
+	breakdown             cacheBreakdown
+	confirmCacheDir       CacheDir
+	// Foreign packages unmanaged by paru
+	unmanaged             []unmanagedPackage
+	unmanagedErr          error
+	localPackages         map[string]bool // Marked as intentionally local
+	showUnmanaged         bool
+	unmanagedIndex        int
+	// Held packages overlay
+	showHolds             bool
+	holdsIndex            int
+	// Focused info panel: a cursor over the dependencies and the packages
+	// navigated away from
+	infoFocused           bool
+	infoCursor            int
+	infoBackStack         []string
+	// File list of an installed package shown in place of the package info
+	fileList              fileListView
+	showFileList          bool
+	// Dependency tree shown in place of the package info
+	depTree               depTreeView
+	showDepTree           bool
+	// Build log browser for failed installs and updates
+	buildLog              buildLogBrowser
+	showBuildLog          bool
+	// Package set state
+	packageSets           map[string][]string // Named package sets from the config file
+	namingSet             bool                // Text input is collecting a name for a new set
+	queryBeforeNaming     string              // Search query to restore after naming a set
+	confirmSkipped        []string            // Set members skipped because they are installed
+	confirmUnknown        []string            // Set members not found in the sync databases
+	// Interrupted transaction recovery state
+	recoveryFindings      []recoveryFinding
+	showRecoveryBanner    bool
+	toolNotices           []string // Missing optional tools, shown in the header
+	syncDBTime            time.Time // Last sync database download; zero if unknown
+	dashboardUpdates      int       // Updates available, counted for the dashboard
+	dashboardUpdatesKnown bool      // dashboardUpdates holds a finished check
+	// .pacnew and .pacsave files overlay
+	pacnewFiles           []pacnewFile
+	pacnewChecked         bool
+	showPacnew            bool
+	pacnewIndex           int
+	// arch-audit report and its overlay
+	audit                 auditCheckMsg
+	auditChecked          bool
+	showAudit             bool
+	auditIndex            int
+	// Failed systemd units and reboot check
+	health                systemHealthMsg
+	showFailedUnits       bool
+	failedUnitsIndex      int
+	runner                Runner // Runs the commands whose output is parsed
+	displayRunner         Runner // Runs commands whose output is shown as is
+	// Streamed transaction state, see runTransaction
+	streaming             bool
+	streamTitle           string
+	streamLines           []string
+	streamStarted         time.Time
+	streamScroll          int           // Lines scrolled up from the end of the log
+	streamStop            chan struct{} // Closed by ctrl+c to interrupt the run
+	// Busy indicator, ticking while anything is loading, see busy
+	spinner               spinner.Model
+	spinning              bool
+	busySince             time.Time
+	showRecovery          bool
+	// Cleanup wizard state
+	showCleanup           bool
+	cleanup               cleanupWizard
+	cleanupSeq            int // Sequence number of the live candidate load; older results are dropped
+	// Error overlay state
+	showErrorOverlay      bool
+	errorTitle            string
+	errorMessage          string
+	errorDetails          string
+	errorOutput           []string // Last lines of the failed command's output
+	errorOutputEnd        int      // Line after the last one shown, for scrolling
+	// The failed operation, kept for [r] retry in the error overlay
+	retry                 func() tea.Cmd
+	retryOffered          bool // The overlay is for an operation, so [r] shows
+	retryOp               confirmationType
+	retryPackages         []string
 }
 
-func installPackage(pkg Package) tea.Cmd {
-	return func() tea.Msg {
-		// Validate package name to prevent command injection
-		if !isValidPackageName(pkg.Name) {
-			return actionCompleteMsg{
-				message: fmt.Sprintf("Invalid package name: %s", pkg.Name),
-				err:     fmt.Errorf("invalid package name"),
-			}
-		}
-
-		cmd := exec.Command("paru", "-S", "--noconfirm", pkg.Name)
-		var out bytes.Buffer
-		cmd.Stdout = &out
-		cmd.Stderr = &out
-
-		err := cmd.Run()
-		if err != nil {
-			return actionCompleteMsg{
-				message: fmt.Sprintf("Failed to install %s: %s", pkg.Name, out.String()),
-				err:     err,
-			}
-		}
+// getModeColors returns the mode colors based on current theme
+func getModeColors() map[viewMode]lipgloss.Color {
+	return map[viewMode]lipgloss.Color{
+		modeInstall:   currentTheme.InstallColor,
+		modeInstalled: currentTheme.InstalledColor,
+		modeUninstall: currentTheme.UninstallColor,
+		modeUpdate:    currentTheme.UpdateColor,
+		modeHistory:   currentTheme.HighlightColor,
+	}
+}
 
-		return actionCompleteMsg{
-			message: fmt.Sprintf("Successfully installed %s", pkg.Name),
-		}
+// getSourceColors returns the source colors based on current theme
+func getSourceColors() map[string]lipgloss.Color {
+	return map[string]lipgloss.Color{
+		"core":     currentTheme.CoreColor,
+		"extra":    currentTheme.ExtraColor,
+		"multilib": currentTheme.MultilibColor,
+		"aur":      currentTheme.AurColor,
 	}
 }
 
-func installMultiplePackages(pkgNames []string) tea.Cmd {
-	return func() tea.Msg {
-		// Validate all package names to prevent command injection
-		validNames, allValid := sanitizePackageNames(pkgNames)
-		if !allValid {
-			return actionCompleteMsg{
-				message: "Some package names contain invalid characters and were skipped",
-				err:     fmt.Errorf("invalid package names detected"),
-			}
-		}
-		if len(validNames) == 0 {
-			return actionCompleteMsg{
-				message: "No valid package names to install",
-				err:     fmt.Errorf("no valid packages"),
-			}
-		}
+// Styles - initialized with theme colors
+var (
+	defaultBorderColor = currentTheme.BorderColor
+	selectedColor      = currentTheme.SelectedColor
 
-		args := append([]string{"-S", "--noconfirm"}, validNames...)
-		cmd := exec.Command("paru", args...)
-		var out bytes.Buffer
-		cmd.Stdout = &out
-		cmd.Stderr = &out
+	// Mode-specific colors for active view highlighting
+	modeColors = getModeColors()
 
-		err := cmd.Run()
-		if err != nil {
-			return actionCompleteMsg{
-				message: fmt.Sprintf("Failed to install packages: %s", out.String()),
-				err:     err,
-			}
-		}
+	sourceColors = getSourceColors()
 
-		return actionCompleteMsg{
-			message: fmt.Sprintf("Successfully installed %d packages", len(validNames)),
-		}
-	}
-}
+	// Base styles (will be customized per mode in View)
+	baseTitleStyle = lipgloss.NewStyle().
+			Bold(true).
+			Foreground(currentTheme.TitleColor).
+			Padding(0, 1)
 
-func uninstallPackage(pkg Package) tea.Cmd {
-	return func() tea.Msg {
-		// Validate package name to prevent command injection
-		if !isValidPackageName(pkg.Name) {
-			return actionCompleteMsg{
-				message: fmt.Sprintf("Invalid package name: %s", pkg.Name),
-				err:     fmt.Errorf("invalid package name"),
-			}
-		}
+	baseBorderStyle = lipgloss.NewStyle().
+			Border(lipgloss.RoundedBorder())
 
-		cmd := exec.Command("paru", "-Rns", "--noconfirm", pkg.Name)
-		var out bytes.Buffer
-		cmd.Stdout = &out
-		cmd.Stderr = &out
+	selectedStyle = lipgloss.NewStyle().
+			Bold(true).
+			Foreground(currentTheme.SelectedColor)
 
-		err := cmd.Run()
-		if err != nil {
-			return actionCompleteMsg{
-				message: fmt.Sprintf("Failed to uninstall %s: %s", pkg.Name, out.String()),
-				err:     err,
-			}
-		}
+	normalStyle = lipgloss.NewStyle().
+			Foreground(currentTheme.TextColor)
 
-		return actionCompleteMsg{
-			message: fmt.Sprintf("Successfully uninstalled %s", pkg.Name),
-		}
-	}
-}
+	infoStyle = lipgloss.NewStyle().
+			Foreground(currentTheme.TextColor).
+			Padding(1)
 
-func uninstallMultiplePackages(pkgNames []string) tea.Cmd {
-	return func() tea.Msg {
-		// Validate all package names to prevent command injection
-		validNames, allValid := sanitizePackageNames(pkgNames)
-		if !allValid {
-			return actionCompleteMsg{
-				message: "Some package names contain invalid characters and were skipped",
-				err:     fmt.Errorf("invalid package names detected"),
-			}
-		}
-		if len(validNames) == 0 {
-			return actionCompleteMsg{
-				message: "No valid package names to uninstall",
-				err:     fmt.Errorf("no valid packages"),
-			}
-		}
+	statusStyle = lipgloss.NewStyle().
+			Foreground(currentTheme.SubtleColor)
 
-		args := append([]string{"-Rns", "--noconfirm"}, validNames...)
-		cmd := exec.Command("paru", args...)
-		var out bytes.Buffer
-		cmd.Stdout = &out
-		cmd.Stderr = &out
+	helpStyle = lipgloss.NewStyle().
+			Foreground(currentTheme.SubtleColor).
+			Bold(true)
 
-		err := cmd.Run()
-		if err != nil {
-			return actionCompleteMsg{
-				message: fmt.Sprintf("Failed to uninstall packages: %s", out.String()),
-				err:     err,
-			}
-		}
+	installedBadge = lipgloss.NewStyle().
+			Foreground(currentTheme.SuccessColor).
+			Bold(true)
 
-		return actionCompleteMsg{
-			message: fmt.Sprintf("Successfully uninstalled %d packages", len(validNames)),
-		}
+	matchHighlightStyle = lipgloss.NewStyle().
+				Foreground(currentTheme.HighlightColor).
+				Bold(true)
+
+	dashboardLabelStyle = lipgloss.NewStyle().
+				Foreground(currentTheme.DashboardLabel).
+				Bold(true)
+
+	dashboardValueStyle = lipgloss.NewStyle().
+				Foreground(currentTheme.DashboardValue).
+				Bold(true)
+
+	dashboardWarningStyle = lipgloss.NewStyle().
+				Foreground(currentTheme.DashboardWarning).
+				Bold(true)
+
+	dashboardDescStyle = lipgloss.NewStyle().
+				Foreground(currentTheme.DashboardDesc)
+)
+
+func initialModel() model {
+	ti := textinput.New()
+	ti.Placeholder = "Search packages..."
+	ti.CharLimit = textInputCharLimit
+	ti.Width = textInputDefaultWidth
+
+	return model{
+		textInput:      ti,
+		runner:         execRunner{},
+		displayRunner:  execRunner{userLocale: true},
+		spinner:        spinner.New(spinner.WithSpinner(spinner.MiniDot)),
+		spinning:       true,
+		busySince:      time.Now(),
+		repoPackages:   []Package{},
+		installedSet:   make(map[string]bool),
+		packages:       []Package{},
+		filtered:       []Package{},
+		installed:      []Package{},
+		markedPackages: make(map[string]bool),
+		selectedIndex:  0,
+		mode:           modeInstall,
+		settings:       defaultSettings(),
+		keys:           defaultKeymap(),
+		configPath:     configFilePath(),
+		cacheDirs:      defaultCacheDirs(helper.name),
+		localPackages:  make(map[string]bool),
+		holds:          make(map[string]bool),
+		pins:           make(map[string]string),
+		queryHistory:   make(map[viewMode][]string),
+		resultSorts:    make(map[viewMode]resultSort),
+		loading:        true,
+		statusMessage:  "Loading package database...",
 	}
 }
 
-func updateSystem() tea.Cmd {
-	return func() tea.Msg {
-		cmd := exec.Command("paru", "-Syu", "--noconfirm")
-		var out bytes.Buffer
-		cmd.Stdout = &out
-		cmd.Stderr = &out
+func (m model) Init() tea.Cmd {
+	cmds := []tea.Cmd{textinput.Blink, m.spinner.Tick, loadRepoPackages(m.runner), checkInterruptedTransaction(), checkSyncDBAge()}
+	// A --query for install mode searches the AUR while the repos load
+	if _, searchQuery := parseRepoFilter(m.textInput.Value()); m.startMode == modeInstall && searchQuery != "" {
+		tick := aurSearchTickMsg{query: aurSearchQuery(searchQuery), seq: m.aurDebounceSeq}
+		cmds = append(cmds, func() tea.Msg { return tick })
+	}
+	return tea.Batch(cmds...)
+}
 
-		err := cmd.Run()
-		output := out.String()
+// busySpinnerDelay is how long an operation runs before its elapsed time is shown
+const busySpinnerDelay = 2 * time.Second
 
-		if err != nil {
-			return updateOutputMsg{
-				output: output,
-				done:   true,
-				err:    err,
-			}
-		}
+// busy reports whether something the user is waiting for is loading
+func (m model) busy() bool {
+	return m.loading || m.searchingAUR || m.loadingInfo || m.streaming
+}
+
+// busyText prefixes text with the spinner, adding the elapsed time once the
+// wait passes busySpinnerDelay
+func (m model) busyText(text string) string {
+	text = lipgloss.NewStyle().Foreground(currentTheme.HighlightColor).Render(m.spinner.View()) + " " + text
+	if elapsed := time.Since(m.busySince); elapsed >= busySpinnerDelay {
+		text += fmt.Sprintf(" (%ds)", int(elapsed.Seconds()))
+	}
+	return text
+}
+
+// enterStartMode switches to the default_mode from the config once the package
+// database has loaded, loading the mode's data the way its key does
+func (m *model) enterStartMode() tea.Cmd {
+	m.mode = m.startMode
+	m.startMode = modeInstall
+	m.selectedIndex = 0
+	m.loading = true
+	switch m.mode {
+	case modeInstalled:
+		m.statusMessage = "Loading system statistics..."
+		return getDashboardData(m.runner, m.dashboardCacheDirs())
+	case modeUninstall:
+		m.statusMessage = "Loading installed packages..."
+		m.textInput.Placeholder = "Filter (t: total  e: explicit  f: foreign  o: orphan)..."
+		return getInstalledPackages(m.runner)
+	case modeUpdate:
+		m.statusMessage = "Checking for updates..."
+		m.newsLoading = true
+		return tea.Batch(checkUpdates(m.runner, m.holds, m.pins, m.develUpdates), checkArchNews())
+	case modeHistory:
+		m.statusMessage = "Reading " + pacmanLogPath + "..."
+		m.textInput.Placeholder = "Filter history by package..."
+		return loadHistory()
+	}
+	m.loading = false
+	return nil
+}
+
+// currentPackageList returns the appropriate package list based on current mode.
+func (m model) currentPackageList() []Package {
+	switch m.mode {
+	case modeInstall:
+		return m.filtered
+	case modeUninstall:
+		return m.filteredInstalled
+	default:
+		return nil
+	}
+}
+
+// selectableCount returns the number of rows the selection can move over in
+// the current mode
+func (m model) selectableCount() int {
+	if m.mode == modeHistory {
+		return len(m.filteredHistory)
+	}
+	return len(m.currentPackageList())
+}
+
+// selectIndex moves the selection to idx, clamped to the list, and schedules
+// the usual debounced info fetch for the package landed on
+func (m *model) selectIndex(idx int) tea.Cmd {
+	if idx >= m.selectableCount() {
+		idx = m.selectableCount() - 1
+	}
+	if idx < 0 {
+		idx = 0
+	}
+	if idx == m.selectedIndex {
+		return nil
+	}
+	m.selectedIndex = idx
+	if pkgList := m.currentPackageList(); idx < len(pkgList) {
+		m.loadingInfo = true
+		m.pendingInfoPackage = pkgList[idx].Name
+		return m.debouncePackageInfo(m.pendingInfoPackage)
+	}
+	return nil
+}
+
+// savedSelection is the selected result and the results window as an
+// operation left them
+type savedSelection struct {
+	mode  viewMode
+	name  string
+	index int
+	start int
+}
+
+// rememberSelection saves the selection for the list reload that follows an
+// operation
+func (m *model) rememberSelection() {
+	m.savedSelection = nil
+	if pkg := m.selectedPackage(); pkg != nil {
+		m.savedSelection = &savedSelection{mode: m.mode, name: pkg.Name, index: m.selectedIndex, start: m.resultsStart}
+	}
+}
 
-		return updateOutputMsg{
-			output: output,
-			done:   true,
+// restoreSelection selects the saved package again in the reloaded list, or
+// the row that took its place when it is gone, keeping it at the same height
+// in the results window. The saved selection is used up either way.
+func (m *model) restoreSelection() {
+	saved := m.savedSelection
+	m.savedSelection = nil
+	pkgList := m.currentPackageList()
+	if saved == nil || saved.mode != m.mode || len(pkgList) == 0 {
+		return
+	}
+	idx := min(saved.index, len(pkgList)-1)
+	for i, pkg := range pkgList {
+		if pkg.Name == saved.name {
+			idx = i
+			break
 		}
 	}
+	m.selectedIndex = idx
+	m.resultsStart = max(saved.start+idx-saved.index, 0)
 }
 
-// checkUpdates fetches available updates using paru -Qu
-func checkUpdates() tea.Cmd {
-	return func() tea.Msg {
-		cmd := exec.Command("paru", "-Qu")
-		var stdout bytes.Buffer
-		cmd.Stdout = &stdout
-		_ = cmd.Run() // Returns error if no updates, that's ok
+// resultSort is an order of the results list, cycled with s
+type resultSort int
 
-		var packages []Package
-		for _, line := range strings.Split(strings.TrimSpace(stdout.String()), "\n") {
-			if line == "" {
+const (
+	sortRelevance resultSort = iota // The filter's order
+	sortName
+	sortRepo
+	sortInstalledFirst
+	sortSize        // Largest first, offered in remove mode once installed sizes are known
+	sortInstallDate // Newest install first, offered in remove mode
+)
+
+// recentInstallAge is how new an install is highlighted in remove mode
+const recentInstallAge = 7 * 24 * time.Hour
+
+// resultSortNames describes each order for the status line
+var resultSortNames = map[resultSort]string{
+	sortRelevance:      "relevance",
+	sortName:           "name",
+	sortRepo:           "repository",
+	sortInstalledFirst: "installed first",
+	sortSize:           "size",
+	sortInstallDate:    "install date",
+}
+
+// repoSortRank orders sources for sortRepo: the official repositories in
+// pacman.conf order, then third-party repositories, groups and sets, then the AUR
+func repoSortRank(source string) int {
+	switch source {
+	case "core":
+		return 0
+	case "extra":
+		return 1
+	case "multilib":
+		return 2
+	case "aur":
+		return 4
+	}
+	return 3
+}
+
+// sortResults returns packages in the given order. Sorting is stable, so
+// packages that compare equal keep their relevance order.
+func sortResults(packages []Package, order resultSort) []Package {
+	if order == sortRelevance {
+		return packages
+	}
+	sorted := append([]Package(nil), packages...)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		a, b := sorted[i], sorted[j]
+		switch order {
+		case sortName:
+			return a.Name < b.Name
+		case sortRepo:
+			return repoSortRank(a.Source) < repoSortRank(b.Source)
+		case sortInstalledFirst:
+			return a.Installed && !b.Installed
+		case sortSize:
+			return a.SizeBytes > b.SizeBytes
+		case sortInstallDate:
+			return a.InstallDate.After(b.InstallDate)
+		}
+		return false
+	})
+	return sorted
+}
+
+// applyResultSort keeps the freshly filtered results as the relevance order
+// and arranges them for display. Every filter ends with it.
+func (m *model) applyResultSort() {
+	m.unsortedResults = m.currentPackageList()
+	m.arrangeResults()
+}
+
+// arrangeResults derives the shown results from the relevance order: in the
+// mode's chosen order and, in install mode, without the installed packages
+// while they are hidden
+func (m *model) arrangeResults() {
+	packages := sortResults(m.unsortedResults, m.resultSorts[m.mode])
+	m.hiddenInstalled = 0
+	if m.hideInstalled && m.mode == modeInstall {
+		shown := make([]Package, 0, len(packages))
+		for _, pkg := range packages {
+			if m.resultInstalled(pkg) {
+				m.hiddenInstalled++
 				continue
 			}
-			parts := strings.Fields(line)
-			if len(parts) >= 2 {
-				pkgName := parts[0]
-				// Validate package name (defense in depth)
-				if !isValidPackageName(pkgName) {
-					continue
-				}
-				pkg := Package{
-					Name:    pkgName,
-					Version: strings.Join(parts[1:], " "), // "oldver -> newver" format
-				}
-				// Determine source (foreign = aur)
-				checkCmd := exec.Command("pacman", "-Qq", pkgName)
-				if checkCmd.Run() == nil {
-					// Check if foreign
-					foreignCmd := exec.Command("pacman", "-Qm", pkgName)
-					if foreignCmd.Run() == nil {
-						pkg.Source = "aur"
-					} else {
-						pkg.Source = "repo"
-					}
-				}
-				packages = append(packages, pkg)
-			}
+			shown = append(shown, pkg)
 		}
-		return updateCheckMsg{packages: packages}
+		packages = shown
 	}
+	m.setResultList(packages)
 }
 
-// executeInstallInTerminal runs paru -S interactively using tea.ExecProcess
-func executeInstallInTerminal(packages []string) tea.Cmd {
-	// Validate all package names to prevent command injection
-	validNames, _ := sanitizePackageNames(packages)
-	if len(validNames) == 0 {
-		return func() tea.Msg {
-			return execCompleteMsg{operation: confirmInstall, packages: packages, err: fmt.Errorf("no valid package names")}
+// resultsArranged reports whether the shown results were arranged from
+// unsortedResults, rather than replaced without a filter since
+func (m model) resultsArranged() bool {
+	return len(m.unsortedResults) > 0 &&
+		len(m.currentPackageList())+m.hiddenInstalled == len(m.unsortedResults)
+}
+
+// rearrangeResults arranges the results again after the order or the hiding
+// changed, keeping the selected package selected while it is still shown
+func (m *model) rearrangeResults() tea.Cmd {
+	if !m.resultsArranged() {
+		return nil
+	}
+	selected := ""
+	if list := m.currentPackageList(); m.selectedIndex < len(list) {
+		selected = list[m.selectedIndex].Name
+	}
+	m.arrangeResults()
+	list := m.currentPackageList()
+	for i, pkg := range list {
+		if pkg.Name == selected {
+			m.selectedIndex = i
+			return nil
 		}
 	}
+	m.selectedIndex = 0
+	if len(list) == 0 {
+		m.setPackageInfo("")
+		m.infoForPackage = ""
+		return nil
+	}
+	m.loadingInfo = true
+	m.pendingInfoPackage = list[0].Name
+	return m.debouncePackageInfo(m.pendingInfoPackage)
+}
 
-	args := append([]string{"-S"}, validNames...)
-	c := exec.Command("paru", args...)
-	return tea.ExecProcess(c, func(err error) tea.Msg {
-		return execCompleteMsg{operation: confirmInstall, packages: validNames, err: err}
-	})
+// setResultList replaces the results list of the current mode
+func (m *model) setResultList(packages []Package) {
+	switch m.mode {
+	case modeInstall:
+		m.filtered = packages
+	case modeUninstall:
+		m.filteredInstalled = packages
+	}
 }
 
-// executeUninstallInTerminal runs paru -Rns interactively using tea.ExecProcess
-func executeUninstallInTerminal(packages []string) tea.Cmd {
-	// Validate all package names to prevent command injection
-	validNames, _ := sanitizePackageNames(packages)
-	if len(validNames) == 0 {
-		return func() tea.Msg {
-			return execCompleteMsg{operation: confirmUninstall, packages: packages, err: fmt.Errorf("no valid package names")}
-		}
+// cycleResultSort switches to the next order of the results list
+func (m *model) cycleResultSort() tea.Cmd {
+	next := m.resultSorts[m.mode] + 1
+	if next == sortSize && (m.mode != modeUninstall || !m.installedSizesKnown()) {
+		next++
+	}
+	if next == sortInstallDate && m.mode != modeUninstall {
+		next++
 	}
+	if next > sortInstallDate {
+		next = sortRelevance
+	}
+	m.resultSorts[m.mode] = next
+	return m.rearrangeResults()
+}
 
-	args := append([]string{"-Rns"}, validNames...)
-	c := exec.Command("paru", args...)
-	return tea.ExecProcess(c, func(err error) tea.Msg {
-		return execCompleteMsg{operation: confirmUninstall, packages: validNames, err: err}
-	})
+// installedSizesKnown reports whether the installed packages were read with
+// their sizes
+func (m model) installedSizesKnown() bool {
+	for _, pkg := range m.installed {
+		if pkg.SizeBytes > 0 {
+			return true
+		}
+	}
+	return false
 }
 
-// executeUpdateInTerminal runs paru -Syu interactively using tea.ExecProcess
-func executeUpdateInTerminal() tea.Cmd {
-	c := exec.Command("paru", "-Syu")
-	return tea.ExecProcess(c, func(err error) tea.Msg {
-		return execCompleteMsg{operation: confirmUpdate, err: err}
-	})
+// toggleHideInstalled shows or hides the installed packages among the
+// install mode results without searching again
+func (m *model) toggleHideInstalled() tea.Cmd {
+	m.hideInstalled = !m.hideInstalled
+	cmd := m.rearrangeResults()
+	switch {
+	case m.resultsArranged():
+		m.statusMessage = fmt.Sprintf("Found %d packages", len(m.filtered))
+	case m.hideInstalled:
+		m.statusMessage = "Installed packages will be hidden"
+	default:
+		m.statusMessage = "Installed packages will be shown"
+	}
+	return cmd
 }
 
-// executeCleanCacheInTerminal runs paru -Sc interactively using tea.ExecProcess
-func executeCleanCacheInTerminal() tea.Cmd {
-	c := exec.Command("paru", "-Sc")
-	return tea.ExecProcess(c, func(err error) tea.Msg {
-		return execCompleteMsg{operation: confirmCleanCache, err: err}
-	})
+// recordQuery adds the query in the input to the mode's search history,
+// skipping a repeat of the newest entry. Queries are kept whatever they
+// found: the AUR results of an install search may still be on their way.
+func (m *model) recordQuery() {
+	m.historyRecall = 0
+	query := strings.TrimSpace(m.textInput.Value())
+	if query == "" {
+		return
+	}
+	history := m.queryHistory[m.mode]
+	if len(history) > 0 && history[len(history)-1] == query {
+		return
+	}
+	history = append(history, query)
+	if len(history) > maxQueryHistory {
+		history = history[len(history)-maxQueryHistory:]
+	}
+	m.queryHistory[m.mode] = history
+	if err := saveQueryHistory(queryHistoryPath(), m.queryHistory); err != nil {
+		m.statusMessage = fmt.Sprintf("Could not save search history: %v", err)
+	}
 }
 
-// executeRemoveOrphansInTerminal runs paru -Rns $(paru -Qdtq) interactively using tea.ExecProcess
-func executeRemoveOrphansInTerminal(orphans []string) tea.Cmd {
-	// Validate all package names to prevent command injection
-	validNames, _ := sanitizePackageNames(orphans)
-	if len(validNames) == 0 {
-		return func() tea.Msg {
-			return execCompleteMsg{operation: confirmRemoveOrphans, packages: orphans, err: fmt.Errorf("no valid package names")}
+// recallQuery puts an older (ctrl+p) or newer (ctrl+n) query from the mode's
+// search history in the input. Recall starts from an empty input and goes
+// on while the recalled query is left unedited; stepping past the newest
+// entry empties the input again.
+func (m *model) recallQuery(older bool) {
+	history := m.queryHistory[m.mode]
+	value := m.textInput.Value()
+	recalling := m.historyRecall > 0 && m.historyRecall <= len(history) &&
+		value == history[len(history)-m.historyRecall]
+	if !recalling {
+		if value != "" {
+			return
 		}
+		m.historyRecall = 0
+	}
+	switch {
+	case older && m.historyRecall < len(history):
+		m.historyRecall++
+	case !older && m.historyRecall > 0:
+		m.historyRecall--
+	default:
+		return
+	}
+	if m.historyRecall == 0 {
+		m.textInput.SetValue("")
+		return
 	}
+	m.textInput.SetValue(history[len(history)-m.historyRecall])
+	m.textInput.CursorEnd()
+}
 
-	args := append([]string{"-Rns"}, validNames...)
-	c := exec.Command("paru", args...)
-	return tea.ExecProcess(c, func(err error) tea.Msg {
-		return execCompleteMsg{operation: confirmRemoveOrphans, packages: validNames, err: err}
-	})
+// maxSelectableIndex returns the maximum valid index for the current package list.
+func (m model) maxSelectableIndex() int {
+	pkgList := m.currentPackageList()
+	if len(pkgList) == 0 {
+		return 0
+	}
+	return len(pkgList) - 1
 }
 
-func (m model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
-	var cmds []tea.Cmd
+// selectedPackage returns the currently selected package, or nil if none.
+func (m model) selectedPackage() *Package {
+	pkgList := m.currentPackageList()
+	if m.selectedIndex >= 0 && m.selectedIndex < len(pkgList) {
+		return &pkgList[m.selectedIndex]
+	}
+	return nil
+}
 
-	switch msg := msg.(type) {
-	case tea.KeyMsg:
-		// ctrl+c always quits
-		if msg.String() == "ctrl+c" {
-			return m, tea.Quit
+// highlightMatches renders a string with matched character indices highlighted.
+// It uses a map for O(1) lookup of matched positions and handles Unicode correctly.
+func highlightMatches(s string, matchedIndices []int) string {
+	if len(matchedIndices) == 0 {
+		return s
+	}
+
+	// Create a set of matched indices for O(1) lookup
+	matchSet := make(map[int]struct{}, len(matchedIndices))
+	for _, idx := range matchedIndices {
+		matchSet[idx] = struct{}{}
+	}
+
+	return styleRuns([]rune(s), func(i int) *lipgloss.Style {
+		if _, matched := matchSet[i]; matched {
+			return &matchHighlightStyle
 		}
+		return nil
+	})
+}
 
-		// Handle error overlay dismissal
-		if m.showErrorOverlay {
-			if msg.String() == "esc" || msg.String() == "enter" || msg.String() == "q" {
-				m.showErrorOverlay = false
-				m.errorTitle = ""
-				m.errorMessage = ""
-				m.errorDetails = ""
-				return m, nil
-			}
-			return m, nil
+// styleRuns renders runes grouped into runs of the same style, so each run
+// costs one escape sequence pair rather than one per rune. styleAt returns
+// the style of rune i, nil for plain text.
+func styleRuns(runes []rune, styleAt func(i int) *lipgloss.Style) string {
+	var result strings.Builder
+	result.Grow(len(runes) * 2) // Pre-allocate for efficiency
+	start := 0
+	for i := 1; i <= len(runes); i++ {
+		if i < len(runes) && styleAt(i) == styleAt(start) {
+			continue
+		}
+		run := string(runes[start:i])
+		if style := styleAt(start); style != nil {
+			run = style.Render(run)
 		}
+		result.WriteString(run)
+		start = i
+	}
+	return result.String()
+}
 
-		// Handle confirmation dialog keys
-		if m.showConfirmation {
-			switch msg.String() {
-			case "y", "Y", "enter":
-				m.showConfirmation = false
-				m.confirmScrollOffset = 0
-				switch m.confirmType {
-				case confirmInstall:
-					m.statusMessage = fmt.Sprintf("Installing %d package(s)...", len(m.confirmPackages))
-					return m, executeInstallInTerminal(m.confirmPackages)
-				case confirmUninstall:
-					m.statusMessage = fmt.Sprintf("Removing %d package(s)...", len(m.confirmPackages))
-					return m, executeUninstallInTerminal(m.confirmPackages)
-				case confirmUpdate:
-					m.statusMessage = "Running system update..."
-					return m, executeUpdateInTerminal()
-				case confirmCleanCache:
-					m.statusMessage = "Cleaning package cache..."
-					return m, executeCleanCacheInTerminal()
-				case confirmRemoveOrphans:
-					m.statusMessage = fmt.Sprintf("Removing %d orphan package(s)...", len(m.confirmPackages))
-					orphans := m.confirmPackages
-					m.confirmPackages = nil
-					return m, executeRemoveOrphansInTerminal(orphans)
-				}
-			case "n", "N", "esc":
-				m.showConfirmation = false
-				m.confirmPackages = nil
-				m.pendingUpdates = nil
-				m.confirmScrollOffset = 0
-				m.statusMessage = "Operation cancelled"
-				return m, nil
-			case "down", "j":
-				// Scroll down in package list
-				maxScroll := len(m.confirmPackages) - 10
-				if m.confirmType == confirmUpdate {
-					maxScroll = len(m.pendingUpdates) - 10
-				}
-				if maxScroll < 0 {
-					maxScroll = 0
-				}
-				if m.confirmScrollOffset < maxScroll {
-					m.confirmScrollOffset++
-				}
-				return m, nil
-			case "up", "k":
-				// Scroll up in package list
-				if m.confirmScrollOffset > 0 {
-					m.confirmScrollOffset--
-				}
-				return m, nil
-			}
-			return m, nil
+// highlightMatchesWithSourceColor renders a package string (source/name) with:
+// - Source portion colored by repository
+// - Matched characters highlighted with matchHighlightStyle
+// - Non-matched characters in normal text color
+func highlightMatchesWithSourceColor(pkg Package, matchedIndices []int) string {
+	pkgStr := pkg.Source + "/" + pkg.Name
+	
+	// Get source color
+	sourceColor, hasSourceColor := sourceColors[pkg.Source]
+	
+	// If no matches, just apply source coloring
+	if len(matchedIndices) == 0 {
+		if hasSourceColor {
+			return lipgloss.NewStyle().Foreground(sourceColor).Render(pkg.Source) + "/" + pkg.Name
 		}
+		return pkgStr
+	}
 
-		// Handle * key to toggle selection panel focus
-		if msg.String() == "*" {
-			if len(m.markedPackages) > 0 {
-				m.selectionPanelFocused = !m.selectionPanelFocused
-				if m.selectionPanelFocused {
-					m.textInput.Blur()
-					m.selectionPanelIndex = 0
-					m.statusMessage = "Selection panel: [↑↓] navigate  [tab] deselect  [enter] install  [*] close"
-				} else {
-					m.statusMessage = fmt.Sprintf("%d packages marked", len(m.markedPackages))
-				}
-			}
-			return m, nil
+	// Create a set of matched indices for O(1) lookup
+	matchSet := make(map[int]struct{}, len(matchedIndices))
+	for _, idx := range matchedIndices {
+		matchSet[idx] = struct{}{}
+	}
+
+	// Find where the slash is (end of source), in runes like the indices
+	slashIdx := utf8.RuneCountInString(pkg.Source)
+
+	sourceStyle := lipgloss.NewStyle().Foreground(sourceColor)
+	return styleRuns([]rune(pkgStr), func(i int) *lipgloss.Style {
+		if _, matched := matchSet[i]; matched {
+			// Matched character - use highlight color
+			return &matchHighlightStyle
 		}
+		if i < slashIdx && hasSourceColor {
+			// Source portion (before slash) - use source color
+			return &sourceStyle
+		}
+		// Name portion or no source color - use normal text
+		return nil
+	})
+}
 
-		// When selection panel is focused, handle its navigation
-		if m.selectionPanelFocused {
-			// Get sorted package names (same order as displayed)
-			var pkgNames []string
-			for name := range m.markedPackages {
-				pkgNames = append(pkgNames, name)
-			}
-			sort.Strings(pkgNames)
-			maxIdx := len(pkgNames) - 1
-			if maxIdx > 9 {
-				maxIdx = 9 // Match maxDisplay limit
-			}
+// pacmanDBPath is pacman's default database directory
+const pacmanDBPath = "/var/lib/pacman"
 
-			switch msg.String() {
-			case "esc", "*":
-				m.selectionPanelFocused = false
-				m.statusMessage = fmt.Sprintf("%d packages marked", len(m.markedPackages))
-				return m, nil
-			case "up", "k":
-				if m.selectionPanelIndex > 0 {
-					m.selectionPanelIndex--
-				}
-				return m, nil
-			case "down", "j":
-				if m.selectionPanelIndex < maxIdx {
-					m.selectionPanelIndex++
-				}
-				return m, nil
-			case "tab":
-				// Deselect the highlighted package
-				if m.selectionPanelIndex < len(pkgNames) {
-					nameToRemove := pkgNames[m.selectionPanelIndex]
-					delete(m.markedPackages, nameToRemove)
-					// Adjust index if needed
-					if m.selectionPanelIndex >= len(m.markedPackages) && m.selectionPanelIndex > 0 {
-						m.selectionPanelIndex--
-					}
-					// Close panel if no more selections
-					if len(m.markedPackages) == 0 {
-						m.selectionPanelFocused = false
-						m.statusMessage = "All selections cleared"
-					} else {
-						m.statusMessage = fmt.Sprintf("%d packages marked - [tab] to deselect", len(m.markedPackages))
-					}
-				}
-				return m, nil
-			case "enter":
-				// Close panel and show confirmation dialog
-				m.selectionPanelFocused = false
-				if len(m.markedPackages) > 0 {
-					if m.mode == modeInstall {
-						var pkgsToInstall []string
-						for name := range m.markedPackages {
-							if !m.installedSet[name] {
-								pkgsToInstall = append(pkgsToInstall, name)
-							}
-						}
-						if len(pkgsToInstall) > 0 {
-							sort.Strings(pkgsToInstall)
-							m.showConfirmation = true
-							m.confirmType = confirmInstall
-							m.confirmPackages = pkgsToInstall
-							m.confirmScrollOffset = 0
-							m.markedPackages = make(map[string]bool)
-							m.statusMessage = "Confirm installation"
-						} else {
-							m.statusMessage = "All marked packages are already installed"
-						}
-					} else if m.mode == modeUninstall {
-						var pkgsToUninstall []string
-						for name := range m.markedPackages {
-							pkgsToUninstall = append(pkgsToUninstall, name)
-						}
-						sort.Strings(pkgsToUninstall)
-						m.showConfirmation = true
-						m.confirmType = confirmUninstall
-						m.confirmPackages = pkgsToUninstall
-						m.confirmScrollOffset = 0
-						m.markedPackages = make(map[string]bool)
-						m.statusMessage = "Confirm removal"
-					}
-				}
-				return m, nil
-			}
-			return m, nil
+// pacmanDBDir returns pacman's database directory: the DBPath set in
+// pacman.conf, or the default
+func pacmanDBDir() string {
+	f, err := os.Open(pacmanConfPath)
+	if err != nil {
+		return pacmanDBPath
+	}
+	defer f.Close()
+	if dir, err := parsePacmanConfDBPath(f); err == nil && dir != "" {
+		return dir
+	}
+	return pacmanDBPath
+}
+
+// parsePacmanConfDBPath returns the DBPath from pacman.conf's [options]
+// section, or "" when it isn't set
+func parsePacmanConfDBPath(r io.Reader) (string, error) {
+	dir := ""
+	section := ""
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if i := strings.Index(line, "#"); i >= 0 {
+			line = line[:i]
 		}
+		line = strings.TrimSpace(line)
+		if strings.HasPrefix(line, "[") && strings.HasSuffix(line, "]") {
+			section = strings.TrimSpace(line[1 : len(line)-1])
+			continue
+		}
+		key, value, ok := strings.Cut(line, "=")
+		if ok && section == "options" && strings.TrimSpace(key) == "DBPath" {
+			dir = strings.TrimSpace(value)
+		}
+	}
+	return dir, scanner.Err()
+}
 
-		// When input is focused, only allow esc, arrow keys, and typing
-		if m.textInput.Focused() {
-			switch msg.String() {
-			case "esc":
-				m.textInput.Blur()
-				return m, nil
-			case "down":
-				// Down moves toward more relevant (lower index, visually down)
-				if m.selectedIndex > 0 {
-					m.selectedIndex--
-					if m.mode == modeInstall && len(m.filtered) > 0 {
-						m.loadingInfo = true
-						m.pendingInfoPackage = m.filtered[m.selectedIndex].Name
-						return m, debouncePackageInfo(m.pendingInfoPackage)
-					} else if m.mode == modeUninstall && len(m.filteredInstalled) > 0 {
-						m.loadingInfo = true
-						m.pendingInfoPackage = m.filteredInstalled[m.selectedIndex].Name
-						return m, debouncePackageInfo(m.pendingInfoPackage)
-					}
-				}
-				return m, nil
-			case "up":
-				// Up moves toward less relevant (higher index, visually up)
-				maxIndex := 0
-				if m.mode == modeInstall {
-					maxIndex = len(m.filtered) - 1
-				} else if m.mode == modeUninstall {
-					maxIndex = len(m.filteredInstalled) - 1
-				}
-				if m.selectedIndex < maxIndex {
-					m.selectedIndex++
-					if m.mode == modeInstall && len(m.filtered) > 0 {
-						m.loadingInfo = true
-						m.pendingInfoPackage = m.filtered[m.selectedIndex].Name
-						return m, debouncePackageInfo(m.pendingInfoPackage)
-					} else if m.mode == modeUninstall && len(m.filteredInstalled) > 0 {
-						m.loadingInfo = true
-						m.pendingInfoPackage = m.filteredInstalled[m.selectedIndex].Name
-						return m, debouncePackageInfo(m.pendingInfoPackage)
-					}
-				}
-				return m, nil
-			case "enter":
-				if m.mode == modeInstall && len(m.filtered) > 0 {
-					// If packages are marked, show confirmation for all marked packages
-					if len(m.markedPackages) > 0 {
-						var pkgsToInstall []string
-						for name := range m.markedPackages {
-							if !m.installedSet[name] {
-								pkgsToInstall = append(pkgsToInstall, name)
-							}
-						}
-						if len(pkgsToInstall) > 0 {
-							sort.Strings(pkgsToInstall)
-							m.showConfirmation = true
-							m.confirmType = confirmInstall
-							m.confirmPackages = pkgsToInstall
-							m.confirmScrollOffset = 0
-							m.markedPackages = make(map[string]bool)
-							m.statusMessage = "Confirm installation"
-						} else {
-							m.statusMessage = "All marked packages are already installed"
-						}
-					} else {
-						// Show confirmation dialog for single package
-						pkg := m.filtered[m.selectedIndex]
-						if !pkg.Installed {
-							m.showConfirmation = true
-							m.confirmType = confirmInstall
-							m.confirmPackages = []string{pkg.Name}
-							m.confirmScrollOffset = 0
-							m.statusMessage = "Confirm installation"
-						} else {
-							m.statusMessage = fmt.Sprintf("%s is already installed", pkg.Name)
-						}
-					}
-				} else if m.mode == modeUninstall && len(m.filteredInstalled) > 0 {
-					// If packages are marked, show confirmation for all marked packages
-					if len(m.markedPackages) > 0 {
-						var pkgsToUninstall []string
-						for name := range m.markedPackages {
-							pkgsToUninstall = append(pkgsToUninstall, name)
-						}
-						sort.Strings(pkgsToUninstall)
-						m.showConfirmation = true
-						m.confirmType = confirmUninstall
-						m.confirmPackages = pkgsToUninstall
-						m.confirmScrollOffset = 0
-						m.markedPackages = make(map[string]bool)
-						m.statusMessage = "Confirm removal"
-					} else {
-						// Show confirmation dialog for single package
-						pkg := m.filteredInstalled[m.selectedIndex]
-						m.showConfirmation = true
-						m.confirmType = confirmUninstall
-						m.confirmPackages = []string{pkg.Name}
-						m.confirmScrollOffset = 0
-						m.statusMessage = "Confirm removal"
-					}
-				}
-				return m, nil
-			case "tab":
-				// Toggle mark on current package (works even while typing)
-				if m.mode == modeInstall && len(m.filtered) > 0 {
-					pkg := m.filtered[m.selectedIndex]
-					if m.markedPackages[pkg.Name] {
-						delete(m.markedPackages, pkg.Name)
-					} else {
-						m.markedPackages[pkg.Name] = true
-					}
-					markedCount := len(m.markedPackages)
-					if markedCount > 0 {
-						m.statusMessage = fmt.Sprintf("%d packages marked", markedCount)
-					} else {
-						m.statusMessage = fmt.Sprintf("Found %d packages", len(m.filtered))
-					}
-				} else if m.mode == modeUninstall && len(m.filteredInstalled) > 0 {
-					pkg := m.filteredInstalled[m.selectedIndex]
-					if m.markedPackages[pkg.Name] {
-						delete(m.markedPackages, pkg.Name)
-					} else {
-						m.markedPackages[pkg.Name] = true
-					}
-					markedCount := len(m.markedPackages)
-					if markedCount > 0 {
-						m.statusMessage = fmt.Sprintf("%d packages marked", markedCount)
-					} else {
-						m.statusMessage = fmt.Sprintf("%d installed packages", len(m.installed))
-					}
+// recoveryFinding describes one sign of an interrupted pacman transaction
+type recoveryFinding struct {
+	kind    string // "lock" for a stale db.lck, "corrupt" for a broken local entry
+	path    string
+	pkgName string
+	detail  string
+}
+
+// checkInterruptedTransaction runs the cheap startup check for leftovers of an
+// interrupted pacman run
+func checkInterruptedTransaction() tea.Cmd {
+	return func() tea.Msg {
+		return recoveryCheckMsg{findings: detectInterruptedTransaction(pacmanDBDir())}
+	}
+}
+
+// detectInterruptedTransaction looks for a stale database lock and local
+// database entries whose desc file is missing or corrupt. It is strictly
+// read-only and only reads the first bytes of each desc file so it stays fast;
+// full verification is left to pacman -Qkk, which the recovery view runs on
+// request.
+func detectInterruptedTransaction(dbPath string) []recoveryFinding {
+	var findings []recoveryFinding
+
+	// A lock file with no pacman process around means a run was interrupted
+	lockPath := filepath.Join(dbPath, "db.lck")
+	if info, err := os.Stat(lockPath); err == nil && !isProcessRunning("pacman") {
+		findings = append(findings, recoveryFinding{
+			kind:   "lock",
+			path:   lockPath,
+			detail: fmt.Sprintf("Lock left behind at %s, but no pacman process is running", info.ModTime().Format("2006-01-02 15:04")),
+		})
+	}
+
+	localPath := filepath.Join(dbPath, "local")
+	entries, err := os.ReadDir(localPath)
+	if err != nil {
+		return findings
+	}
+	header := make([]byte, len("%NAME%"))
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+		descPath := filepath.Join(localPath, entry.Name(), "desc")
+		name := localEntryPackageName(entry.Name())
+		f, err := os.Open(descPath)
+		if err != nil {
+			findings = append(findings, recoveryFinding{kind: "corrupt", path: descPath, pkgName: name, detail: "desc file is missing"})
+			continue
+		}
+		n, _ := io.ReadFull(f, header)
+		f.Close()
+		if string(header[:n]) != "%NAME%" {
+			findings = append(findings, recoveryFinding{kind: "corrupt", path: descPath, pkgName: name, detail: "desc file is empty or corrupt"})
+		}
+	}
+	return findings
+}
+
+// localEntryPackageName extracts the package name from a local database
+// directory name of the form name-pkgver-pkgrel
+func localEntryPackageName(dirName string) string {
+	parts := strings.Split(dirName, "-")
+	if len(parts) < 3 {
+		return dirName
+	}
+	return strings.Join(parts[:len(parts)-2], "-")
+}
+
+// isProcessRunning reports whether a process with the given command name exists
+func isProcessRunning(name string) bool {
+	matches, _ := filepath.Glob("/proc/[0-9]*/comm")
+	for _, path := range matches {
+		data, err := os.ReadFile(path)
+		if err == nil && strings.TrimSpace(string(data)) == name {
+			return true
+		}
+	}
+	return false
+}
+
+// recoveryPackages returns the valid package names from corrupt local entries
+func (m model) recoveryPackages() []string {
+	var names []string
+	for _, f := range m.recoveryFindings {
+		if f.kind == "corrupt" && isValidPackageName(f.pkgName) {
+			names = append(names, f.pkgName)
+		}
+	}
+	return names
+}
+
+// recoveryLockPath returns the stale lock path, or "" if none was found
+func (m model) recoveryLockPath() string {
+	for _, f := range m.recoveryFindings {
+		if f.kind == "lock" {
+			return f.path
+		}
+	}
+	return ""
+}
+
+// Commands
+
+// Runner runs the external commands whose output gaur parses. The model's
+// Runner is handed to the commands that read pacman and the AUR helper, so a
+// scripted Runner can stand in for them. Commands that change the system get
+// the terminal through tea.ExecProcess instead.
+type Runner interface {
+	// Run runs a command to completion and returns what it wrote to stdout
+	// and stderr
+	Run(name string, args ...string) (stdout, stderr string, err error)
+	// Combined runs a command to completion and returns its stdout and
+	// stderr interleaved as they were written, for output shown as is
+	Combined(name string, args ...string) (output string, err error)
+	// Stream runs a command, handing its stdout to read as it is written.
+	// Cancelling ctx kills the command.
+	Stream(ctx context.Context, read func(stdout io.Reader) error, name string, args ...string) (stderr string, err error)
+}
+
+// execRunner runs commands with os/exec, in the C locale unless userLocale
+// is set for output shown to the user as is
+type execRunner struct {
+	userLocale bool
+}
+
+// command builds the command in the runner's locale
+func (r execRunner) command(name string, args ...string) *exec.Cmd {
+	if r.userLocale {
+		return exec.Command(name, args...)
+	}
+	return parseCommand(name, args...)
+}
+
+func (r execRunner) Run(name string, args ...string) (string, string, error) {
+	cmd := r.command(name, args...)
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	err := cmd.Run()
+	return stdout.String(), stderr.String(), err
+}
+
+func (r execRunner) Combined(name string, args ...string) (string, error) {
+	cmd := r.command(name, args...)
+	var out bytes.Buffer
+	cmd.Stdout = &out
+	cmd.Stderr = &out
+	err := cmd.Run()
+	return out.String(), err
+}
+
+func (r execRunner) Stream(ctx context.Context, read func(io.Reader) error, name string, args ...string) (string, error) {
+	cmd := r.command(name, args...)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return "", err
+	}
+	if err := cmd.Start(); err != nil {
+		return "", err
+	}
+	stop := context.AfterFunc(ctx, func() { _ = cmd.Process.Kill() })
+	defer stop()
+	readErr := read(stdout)
+	if readErr != nil {
+		// Let a command the reader gave up on finish writing
+		_, _ = io.Copy(io.Discard, stdout)
+	}
+	err = cmd.Wait()
+	if readErr != nil {
+		return stderr.String(), readErr
+	}
+	return stderr.String(), err
+}
+
+// loggingRunner records every command it runs, with its duration, in the
+// operation log; it wraps the model's Runner under --log-level=debug
+type loggingRunner struct {
+	Runner
+}
+
+func (r loggingRunner) Run(name string, args ...string) (string, string, error) {
+	start := time.Now()
+	stdout, stderr, err := r.Runner.Run(name, args...)
+	logCommand(name, args, start, err)
+	return stdout, stderr, err
+}
+
+func (r loggingRunner) Combined(name string, args ...string) (string, error) {
+	start := time.Now()
+	out, err := r.Runner.Combined(name, args...)
+	logCommand(name, args, start, err)
+	return out, err
+}
+
+func (r loggingRunner) Stream(ctx context.Context, read func(io.Reader) error, name string, args ...string) (string, error) {
+	start := time.Now()
+	stderr, err := r.Runner.Stream(ctx, read, name, args...)
+	logCommand(name, args, start, err)
+	return stderr, err
+}
+
+// logCommand records a finished command in the operation log at debug level
+func logCommand(name string, args []string, start time.Time, err error) {
+	attrs := []any{"name", name, "args", strings.Join(args, " "), "duration", time.Since(start).Round(time.Millisecond)}
+	if err != nil {
+		attrs = append(attrs, "error", err)
+	}
+	opLog.Debug("command", attrs...)
+}
+
+// logExec records a finished command gaur started itself rather than through
+// a Runner
+func logExec(c *exec.Cmd, start time.Time, err error) {
+	logCommand(c.Args[0], c.Args[1:], start, err)
+}
+
+// execProcess is tea.ExecProcess with the command recorded in the operation
+// log once it exits
+func execProcess(c *exec.Cmd, fn tea.ExecCallback) tea.Cmd {
+	start := time.Now()
+	return tea.ExecProcess(c, func(err error) tea.Msg {
+		logExec(c, start, err)
+		return fn(err)
+	})
+}
+
+// parseLocale is the environment of commands whose output gaur parses, so
+// field names and messages are the English ones the parsers expect
+var parseLocale = []string{"LC_ALL=C", "LANG=C"}
+
+// localeIsEnglish reports whether commands print English in the user's
+// locale, so their output reads the same as in the C locale
+func localeIsEnglish() bool {
+	locale := ""
+	for _, key := range []string{"LC_ALL", "LC_MESSAGES", "LANG"} {
+		if locale = os.Getenv(key); locale != "" {
+			break
+		}
+	}
+	if locale == "" || locale == "C" || locale == "POSIX" || strings.HasPrefix(locale, "C.") {
+		return true
+	}
+	// gettext prefers LANGUAGE outside the C locale
+	if language := os.Getenv("LANGUAGE"); language != "" {
+		locale = language
+	}
+	return strings.HasPrefix(locale, "en")
+}
+
+// parseCommand builds a command whose output is parsed, running it in the C
+// locale. Commands handed the terminal keep the user's locale.
+func parseCommand(name string, args ...string) *exec.Cmd {
+	cmd := exec.Command(name, args...)
+	cmd.Env = append(os.Environ(), parseLocale...)
+	return cmd
+}
+
+// repoLoadProgressInterval is how many parsed lines pass between progress messages
+const repoLoadProgressInterval = 500
+
+// repoLoadProgressMsg reports a stage of the repo package load. ch delivers the
+// next message of the same load.
+type repoLoadProgressMsg struct {
+	stage string
+	done  int
+	total int // 0 when unknown
+	ch    <-chan tea.Msg
+}
+
+// status describes the stage for the status line
+func (msg repoLoadProgressMsg) status() string {
+	switch {
+	case msg.total > 0:
+		return fmt.Sprintf("%s (%s/%s)…", msg.stage, formatCount(msg.done), formatCount(msg.total))
+	case msg.done > 0:
+		return fmt.Sprintf("%s (%s)…", msg.stage, formatCount(msg.done))
+	}
+	return msg.stage + "…"
+}
+
+// formatCount formats n with thousands separators
+func formatCount(n int) string {
+	s := fmt.Sprintf("%d", n)
+	for i := len(s) - 3; i > 0; i -= 3 {
+		s = s[:i] + "," + s[i:]
+	}
+	return s
+}
+
+// repoCountCachePath returns where the line count of the last pacman -Sl run is kept
+func repoCountCachePath() string {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		home, _ := os.UserHomeDir()
+		dir = filepath.Join(home, ".cache")
+	}
+	return filepath.Join(dir, "gaur", "repo-count")
+}
+
+// repoCacheVersion is bumped when repoCache gains data, so caches written
+// without it are rebuilt
+const repoCacheVersion = 1
+
+// repoCache is the parsed sync database package list kept between runs.
+// DBMtimes holds the modification time of each sync database it was read
+// from, so a cache older than any of them is known to be stale.
+type repoCache struct {
+	Version  int
+	DBMtimes map[string]int64
+	Packages []Package
+	Groups   map[string][]string
+}
+
+// repoCachePath returns where the parsed repo package list is kept
+func repoCachePath() string {
+	return filepath.Join(filepath.Dir(repoCountCachePath()), "repos.gob")
+}
+
+// maxQueryHistory is how many queries are remembered per mode
+const maxQueryHistory = 100
+
+// queryHistoryPath returns where submitted search queries are kept
+func queryHistoryPath() string {
+	return filepath.Join(filepath.Dir(repoCountCachePath()), "history")
+}
+
+// validQueryHistory accepts "mode<TAB>query" lines
+func validQueryHistory(data []byte) error {
+	for _, line := range strings.Split(strings.TrimSuffix(string(data), "\n"), "\n") {
+		if name, query, ok := strings.Cut(line, "\t"); line != "" && (!ok || name == "" || query == "") {
+			return fmt.Errorf("malformed history line %q", line)
+		}
+	}
+	return nil
+}
+
+// loadQueryHistory reads the search history, one "mode<TAB>query" line per
+// entry, oldest first. Lines for unknown modes are skipped. restored is set
+// when a corrupt file was replaced by its backup.
+func loadQueryHistory(path string) (history map[viewMode][]string, restored bool) {
+	history = make(map[viewMode][]string)
+	data, restored, err := readFileWithRecovery(path, validQueryHistory)
+	if err != nil {
+		return history, false
+	}
+	for _, line := range strings.Split(string(data), "\n") {
+		name, query, ok := strings.Cut(line, "\t")
+		mode, known := startModes[name]
+		if !ok || !known || query == "" {
+			continue
+		}
+		history[mode] = append(history[mode], query)
+	}
+	for mode, queries := range history {
+		if len(queries) > maxQueryHistory {
+			history[mode] = queries[len(queries)-maxQueryHistory:]
+		}
+	}
+	return history, restored
+}
+
+// saveQueryHistory writes the search history in the format loadQueryHistory
+// reads, modes in order so an unchanged history writes the same file
+func saveQueryHistory(path string, history map[viewMode][]string) error {
+	var b strings.Builder
+	for _, name := range sortedKeys(startModes) {
+		mode := startModes[name]
+		for _, query := range history[mode] {
+			b.WriteString(name + "\t" + query + "\n")
+		}
+	}
+	return writeFileAtomic(path, []byte(b.String()), validQueryHistory)
+}
+
+// syncDBMtimes returns the modification time of each sync database
+func syncDBMtimes() map[string]int64 {
+	matches, _ := filepath.Glob(filepath.Join(pacmanDBDir(), "sync", "*.db"))
+	mtimes := make(map[string]int64, len(matches))
+	for _, path := range matches {
+		if info, err := os.Stat(path); err == nil {
+			mtimes[filepath.Base(path)] = info.ModTime().UnixNano()
+		}
+	}
+	return mtimes
+}
+
+// fresh reports whether the cache was built from the given sync databases
+func (c repoCache) fresh(mtimes map[string]int64) bool {
+	if c.Version != repoCacheVersion || len(mtimes) == 0 || len(c.DBMtimes) != len(mtimes) {
+		return false
+	}
+	for name, mtime := range mtimes {
+		if c.DBMtimes[name] != mtime {
+			return false
+		}
+	}
+	return true
+}
+
+// decodeRepoCache decodes a saved repo package list into cache, failing on
+// a damaged one
+func decodeRepoCache(data []byte, cache *repoCache) error {
+	*cache = repoCache{}
+	return gob.NewDecoder(bytes.NewReader(data)).Decode(cache)
+}
+
+// validRepoCache accepts a repo package list that decodes
+func validRepoCache(data []byte) error {
+	var cache repoCache
+	return decodeRepoCache(data, &cache)
+}
+
+// readRepoCache loads the repo package list saved by a previous run,
+// falling back to the one before it when the file is damaged. A cache
+// restored from its backup needs no notice: it is checked against the
+// sync databases like any other.
+func readRepoCache() (repoCache, error) {
+	// The last list decoded is the one returned, so nothing is decoded twice
+	var cache repoCache
+	_, _, err := readFileWithRecovery(repoCachePath(), func(data []byte) error {
+		return decodeRepoCache(data, &cache)
+	})
+	return cache, err
+}
+
+// writeRepoCache saves the repo package list for the next run
+func writeRepoCache(cache repoCache) error {
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(cache); err != nil {
+		return err
+	}
+	return writeFileAtomic(repoCachePath(), buf.Bytes(), validRepoCache)
+}
+
+// loadInstalledSet returns the names of all installed packages from pacman -Qq
+func loadInstalledSet(r Runner) (map[string]bool, error) {
+	out, stderr, err := r.Run("pacman", "-Qq")
+	if err != nil {
+		return nil, commandError("pacman -Qq", err, stderr)
+	}
+	installedSet := make(map[string]bool)
+	for _, name := range strings.Split(out, "\n") {
+		name = strings.TrimSpace(name)
+		if name != "" {
+			installedSet[name] = true
+		}
+	}
+	return installedSet, nil
+}
+
+// loadRepoPackages loads all sync database packages, reporting each stage
+// through repoLoadProgressMsg before the final repoPackagesMsg
+func loadRepoPackages(r Runner) tea.Cmd {
+	return func() tea.Msg {
+		ch := make(chan tea.Msg, 4)
+		go streamRepoPackages(r, ch)
+		return <-ch
+	}
+}
+
+// waitForRepoLoad delivers the next message of a running repo package load
+func waitForRepoLoad(ch <-chan tea.Msg) tea.Cmd {
+	return func() tea.Msg {
+		return <-ch
+	}
+}
+
+// commandError describes a failed command, preferring what it wrote to stderr
+func commandError(name string, err error, stderr string) error {
+	if msg := strings.TrimSpace(stderr); msg != "" {
+		return fmt.Errorf("%s failed: %s", name, msg)
+	}
+	return fmt.Errorf("%s failed: %w", name, err)
+}
+
+// streamRepoPackages runs the stages of the repo package load, parsing
+// pacman -Sl output as it streams in
+func streamRepoPackages(r Runner, ch chan tea.Msg) {
+	defer close(ch)
+	progress := func(stage string, done, total int) {
+		ch <- repoLoadProgressMsg{stage: stage, done: done, total: total, ch: ch}
+	}
+
+	// A cached package list is searchable at once. Installed state is always
+	// read live; a cache older than the sync databases is refreshed after.
+	mtimes := syncDBMtimes()
+	if cache, err := readRepoCache(); err == nil {
+		progress("Loading installed set", 0, 0)
+		if installedSet, err := loadInstalledSet(r); err == nil {
+			for i := range cache.Packages {
+				cache.Packages[i].Installed = installedSet[cache.Packages[i].Name]
+			}
+			if cache.fresh(mtimes) {
+				ch <- repoPackagesMsg{packages: cache.Packages, groups: cache.Groups, installed: installedSet}
+				return
+			}
+			ch <- repoPackagesMsg{packages: cache.Packages, groups: cache.Groups, installed: installedSet, ch: ch}
+		}
+	}
+
+	// The previous run's line count gives parsing a total to report against
+	total := 0
+	if data, _, err := readFileWithRecovery(repoCountCachePath(), validCount); err == nil {
+		total, _ = strconv.Atoi(strings.TrimSpace(string(data)))
+	}
+
+	// pacman -Sl is streamed for progress
+	progress("Spawning pacman -Sl", 0, 0)
+	var packages []Package
+	lines := 0
+	var readErr error
+	stderr, err := r.Stream(context.Background(), func(stdout io.Reader) error {
+		// Parse "repo name version [installed]" format
+		scanner := bufio.NewScanner(stdout)
+		for scanner.Scan() {
+			lines++
+			if lines%repoLoadProgressInterval == 0 {
+				if lines > total {
+					total = 0 // The cached count is stale
 				}
-				return m, nil
+				progress("Parsing package list", lines, total)
 			}
-			// All other keys go to text input
-			var cmd tea.Cmd
-			m.textInput, cmd = m.textInput.Update(msg)
-			cmds = append(cmds, cmd)
-			// Handle filtering logic
-			if m.mode == modeInstall {
-				query := m.textInput.Value()
-				if query != m.lastQuery {
-					m.lastQuery = query
-					
-					// Parse repo filter to check query length correctly
-					repoFilters, searchQuery := parseRepoFilter(query)
-					effectiveQueryLen := len(searchQuery)
-					
-					// Allow filtering with just repo prefix (e.g., "a:" shows all AUR)
-					hasRepoFilter := len(repoFilters) > 0
-					
-					if effectiveQueryLen >= minSearchQueryLen || hasRepoFilter {
-						// Fuzzy filter combined repo + AUR packages (also computes match indices)
-						m.filterAllPackages(query)
-						m.selectedIndex = 0
-						
-						// Trigger AUR search only if:
-						// 1. No repo filter OR filter includes AUR
-						// 2. Have a search query (not just "a:")
-						// 3. Haven't searched this query yet
-						includesAUR := len(repoFilters) == 0 || repoFilters["aur"]
-						shouldSearchAUR := includesAUR && 
-							effectiveQueryLen >= minSearchQueryLen &&
-							searchQuery != m.lastAURQuery
-						
-						if shouldSearchAUR {
-							m.lastAURQuery = searchQuery
-							m.searchingAUR = true
-							cmds = append(cmds, searchAUR(searchQuery))
-						}
-						
-						if len(m.filtered) > 0 {
-							status := fmt.Sprintf("Found %d packages", len(m.filtered))
-							if hasRepoFilter {
-								status = fmt.Sprintf("Found %d %s packages", len(m.filtered), formatRepoFilters(repoFilters))
-							}
-							if m.searchingAUR {
-								status += " (searching AUR...)"
-							}
-							m.statusMessage = status
-							m.loadingInfo = true
-							m.infoForPackage = m.filtered[0].Name
-							cmds = append(cmds, getPackageInfo(m.filtered[0]))
-						} else {
-							if m.searchingAUR {
-								m.statusMessage = "Searching AUR..."
-							} else if hasRepoFilter && searchQuery == "" {
-								m.statusMessage = fmt.Sprintf("No packages in %s", formatRepoFilters(repoFilters))
-							} else {
-								m.statusMessage = fmt.Sprintf("No matches for '%s'", query)
-							}
-							m.packageInfo = ""
-							m.infoForPackage = ""
-						}
-					} else {
-						m.filtered = []Package{}
-						m.aurPackages = []Package{}
-						m.lastAURQuery = ""
-						m.packageInfo = ""
-						m.infoForPackage = ""
-						m.matchIndices = nil
-						if len(m.repoPackages) > 0 {
-							m.statusMessage = fmt.Sprintf("Type at least %d chars or use  to filter (c: e: m: a:) (%d repo packages)", minSearchQueryLen, len(m.repoPackages))
-						} else {
-							m.statusMessage = "Loading package database..."
-						}
+			parts := strings.Fields(scanner.Text())
+			if len(parts) < 3 {
+				continue
+			}
+			packages = append(packages, Package{
+				Source:    parts[0],
+				Name:      parts[1],
+				Version:   parts[2],
+				Installed: len(parts) > 3 && parts[3] == "[installed]",
+			})
+		}
+		readErr = scanner.Err()
+		return readErr
+	}, "pacman", "-Sl")
+	if readErr != nil {
+		ch <- repoPackagesMsg{err: fmt.Errorf("reading pacman -Sl output failed: %w", readErr)}
+		return
+	}
+	if err != nil {
+		ch <- repoPackagesMsg{err: commandError("pacman -Sl", err, stderr)}
+		return
+	}
+	_ = writeFileAtomic(repoCountCachePath(), []byte(fmt.Sprintf("%d\n", lines)), validCount)
+
+	// Get installed packages for quick lookup
+	progress("Loading installed set", 0, 0)
+	installedSet, err := loadInstalledSet(r)
+	if err != nil {
+		ch <- repoPackagesMsg{err: err}
+		return
+	}
+
+	progress("Merging installed state", 0, 0)
+	for i := range packages {
+		if installedSet[packages[i].Name] {
+			packages[i].Installed = true
+		}
+	}
+
+	// Groups are optional; a failing pacman -Sg only leaves them out
+	progress("Loading package groups", 0, 0)
+	groups, _ := loadPackageGroups(r)
+
+	// Descriptions are optional too; pacman -Sl doesn't list them
+	progress("Loading descriptions", 0, 0)
+	if descriptions, err := loadRepoDescriptions(r); err == nil {
+		for i := range packages {
+			packages[i].Description = descriptions[packages[i].String()]
+		}
+	}
+
+	// The cache is best effort; failing to save it only slows the next start
+	_ = writeRepoCache(repoCache{Version: repoCacheVersion, DBMtimes: mtimes, Packages: packages, Groups: groups})
+
+	ch <- repoPackagesMsg{packages: packages, groups: groups, installed: installedSet}
+}
+
+// loadPackageGroups lists the members of every sync database group from
+// pacman -Sg's "group package" lines
+func loadPackageGroups(r Runner) (map[string][]string, error) {
+	out, _, err := r.Run("pacman", "-Sg")
+	if err != nil {
+		return nil, err
+	}
+	groups := make(map[string][]string)
+	for _, line := range strings.Split(out, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) != 2 {
+			continue
+		}
+		groups[fields[0]] = append(groups[fields[0]], fields[1])
+	}
+	for name := range groups {
+		sort.Strings(groups[name])
+	}
+	return groups, nil
+}
+
+// loadRepoDescriptions reads the description of every sync database package
+// from pacman -Ss, keyed by "repo/name". Each package line is followed by its
+// description indented by four spaces.
+func loadRepoDescriptions(r Runner) (map[string]string, error) {
+	out, _, err := r.Run("pacman", "-Ss")
+	if err != nil {
+		return nil, err
+	}
+	descriptions := make(map[string]string)
+	current := ""
+	for _, line := range strings.Split(out, "\n") {
+		if strings.HasPrefix(line, "    ") {
+			if current != "" {
+				descriptions[current] = strings.TrimSpace(line)
+				current = ""
+			}
+			continue
+		}
+		if fields := strings.Fields(line); len(fields) > 0 {
+			current = fields[0]
+		}
+	}
+	return descriptions, nil
+}
+
+// groupPackages returns the sync database groups as pseudo-packages with
+// Source "group", annotated with how many members are installed
+func (m model) groupPackages() []Package {
+	names := make([]string, 0, len(m.repoGroups))
+	for name := range m.repoGroups {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	packages := make([]Package, 0, len(names))
+	for _, name := range names {
+		members := m.repoGroups[name]
+		var installed, missing []string
+		for _, member := range members {
+			if m.installedSet[member] {
+				installed = append(installed, member)
+			} else {
+				missing = append(missing, member)
+			}
+		}
+		var desc strings.Builder
+		desc.WriteString(fmt.Sprintf("%d/%d installed", len(installed), len(members)))
+		if len(missing) > 0 {
+			desc.WriteString("\n\nNot installed: " + strings.Join(missing, " "))
+		}
+		if len(installed) > 0 {
+			desc.WriteString("\n\nInstalled: " + strings.Join(installed, " "))
+		}
+		packages = append(packages, Package{
+			Source:      "group",
+			Name:        name,
+			Version:     fmt.Sprintf("(%d/%d installed)", len(installed), len(members)),
+			Description: desc.String(),
+			Installed:   len(missing) == 0,
+		})
+	}
+	return packages
+}
+
+// loadInstalledNames lists installed package names with pacman -Qq
+func loadInstalledNames(r Runner) tea.Cmd {
+	return func() tea.Msg {
+		out, _, err := r.Run("pacman", "-Qq")
+		if err != nil {
+			return installedNamesMsg{err: err}
+		}
+
+		names := make(map[string]bool)
+		for _, name := range strings.Split(out, "\n") {
+			name = strings.TrimSpace(name)
+			if name != "" {
+				names[name] = true
+			}
+		}
+		return installedNamesMsg{names: names}
+	}
+}
+
+// setRepoPackages replaces the repo package list and rebuilds installedSet
+// from it and installed, the names pacman -Qq listed alongside. This is the
+// full rebuild path, used on startup and after the sync databases change.
+// Names known from the installed list are kept so foreign packages stay in
+// the set.
+func (m *model) setRepoPackages(packages []Package, installed map[string]bool) {
+	m.repoPackages = packages
+	m.repoIndex = make(map[string][]int, len(packages))
+	m.installedSet = make(map[string]bool)
+	for i, pkg := range packages {
+		m.repoIndex[pkg.Name] = append(m.repoIndex[pkg.Name], i)
+		if pkg.Installed {
+			m.installedSet[pkg.Name] = true
+		}
+	}
+	for name := range installed {
+		m.installedSet[name] = true
+	}
+	for _, pkg := range m.installed {
+		m.installedSet[pkg.Name] = true
+	}
+}
+
+// applyInstalledChanges updates installedSet and the Installed flags of only
+// the named packages, avoiding a pass over the whole repo list. Removed
+// packages leave the installed list; added ones join it with the next
+// installed package load, which needs their details.
+func (m *model) applyInstalledChanges(added, removed []string) {
+	if len(added) == 0 && len(removed) == 0 {
+		return
+	}
+
+	changed := make(map[string]bool, len(added)+len(removed))
+	for _, name := range added {
+		m.installedSet[name] = true
+		changed[name] = true
+	}
+	for _, name := range removed {
+		delete(m.installedSet, name)
+		changed[name] = false
+	}
+
+	for name, installed := range changed {
+		for _, i := range m.repoIndex[name] {
+			m.repoPackages[i].Installed = installed
+		}
+	}
+	// AUR results and the relevance order results are arranged from too
+	for _, list := range [][]Package{m.filtered, m.aurPackages, m.unsortedResults} {
+		for i := range list {
+			if installed, ok := changed[list[i].Name]; ok {
+				list[i].Installed = installed
+			}
+		}
+	}
+
+	if len(removed) > 0 {
+		gone := func(pkg Package) bool {
+			installed, ok := changed[pkg.Name]
+			return ok && !installed
+		}
+		m.installed = withoutPackages(m.installed, gone)
+		m.filteredInstalled = withoutPackages(m.filteredInstalled, gone)
+		if m.mode == modeUninstall && m.selectedIndex >= len(m.filteredInstalled) {
+			m.selectedIndex = max(len(m.filteredInstalled)-1, 0)
+		}
+	}
+}
+
+// withoutPackages returns a copy of packages leaving out those drop matches
+func withoutPackages(packages []Package, drop func(Package) bool) []Package {
+	kept := make([]Package, 0, len(packages))
+	for _, pkg := range packages {
+		if !drop(pkg) {
+			kept = append(kept, pkg)
+		}
+	}
+	return kept
+}
+
+// resultInstalled reports whether an install mode result is installed.
+// Packages from the repos and the AUR are checked against installedSet,
+// whatever their search output said; sets and groups count as installed
+// when all their members are.
+func (m model) resultInstalled(pkg Package) bool {
+	if pkg.Source == "set" || pkg.Source == "group" {
+		return pkg.Installed
+	}
+	return m.installedSet[pkg.Name]
+}
+
+// reconcileInstalled diffs installedSet against an authoritative set of
+// installed names and applies only the differences, returning the names that
+// are new
+func (m *model) reconcileInstalled(names map[string]bool) []string {
+	var added, removed []string
+	for name := range names {
+		if !m.installedSet[name] {
+			added = append(added, name)
+		}
+	}
+	for name := range m.installedSet {
+		if !names[name] {
+			removed = append(removed, name)
+		}
+	}
+	m.applyInstalledChanges(added, removed)
+	return added
+}
+
+// requestRefresh schedules a reload of installed state. Requests arriving in
+// quick succession are coalesced into a single reload after
+// refreshCoalesceDelay. repo asks for a full reload of the repo list, which is
+// only needed when the sync databases changed.
+func (m *model) requestRefresh(repo bool) tea.Cmd {
+	m.refreshRepo = m.refreshRepo || repo
+	if m.refreshPending {
+		return nil
+	}
+	m.refreshPending = true
+	return tea.Tick(refreshCoalesceDelay, func(time.Time) tea.Msg {
+		return refreshTickMsg{}
+	})
+}
+
+// ownerQueryPrefix looks up the packages owning a file in install mode
+const ownerQueryPrefix = "own:"
+
+// parseOwnerQuery reports whether input is an "own:" query and returns the
+// path after the prefix
+func parseOwnerQuery(input string) (string, bool) {
+	input = strings.TrimSpace(input)
+	if len(input) < len(ownerQueryPrefix) || !strings.EqualFold(input[:len(ownerQueryPrefix)], ownerQueryPrefix) {
+		return "", false
+	}
+	return strings.TrimSpace(input[len(ownerQueryPrefix):]), true
+}
+
+type fileOwnerTickMsg struct {
+	path string
+	seq  int
+}
+
+type fileOwnerMsg struct {
+	path      string
+	seq       int
+	packages  []Package
+	noFilesDB bool // The file isn't installed and pacman -Fy never ran
+	err       error
+}
+
+// filesDatabaseExists reports whether pacman -Fy has downloaded any file database
+func filesDatabaseExists() bool {
+	matches, _ := filepath.Glob("/var/lib/pacman/sync/*.files")
+	return len(matches) > 0
+}
+
+// findFileOwners looks a path up with pacman -Qo, falling back to the file
+// database (pacman -F) for files no installed package owns
+func findFileOwners(r Runner, path string, seq int) tea.Cmd {
+	return func() tea.Msg {
+		if out, _, err := r.Run("pacman", "-Qqo", "--", path); err == nil {
+			var packages []Package
+			for _, name := range strings.Fields(out) {
+				packages = append(packages, Package{Name: name, Installed: true})
+			}
+			return fileOwnerMsg{path: path, seq: seq, packages: packages}
+		}
+		if !filesDatabaseExists() {
+			return fileOwnerMsg{path: path, seq: seq, noFilesDB: true}
+		}
+		out, _, err := r.Run("pacman", "-Fq", "--", path)
+		if err != nil {
+			// pacman -F exits 1 when no package has the file
+			if exitErr, ok := err.(*exec.ExitError); ok && exitErr.ExitCode() == 1 {
+				return fileOwnerMsg{path: path, seq: seq}
+			}
+			return fileOwnerMsg{path: path, seq: seq, err: err}
+		}
+		var packages []Package
+		seen := make(map[string]bool)
+		for _, line := range strings.Fields(out) {
+			repo, name, ok := strings.Cut(line, "/")
+			if !ok {
+				repo, name = "", line
+			}
+			if !seen[name] {
+				seen[name] = true
+				packages = append(packages, Package{Name: name, Source: repo})
+			}
+		}
+		return fileOwnerMsg{path: path, seq: seq, packages: packages}
+	}
+}
+
+// debounceFileOwners schedules an owner lookup once typing pauses
+func (m *model) debounceFileOwners(path string) tea.Cmd {
+	m.ownerSeq++
+	seq := m.ownerSeq
+	return tea.Tick(m.settings.AURDebounce(), func(time.Time) tea.Msg {
+		return fileOwnerTickMsg{path: path, seq: seq}
+	})
+}
+
+// ownerPackages fills in owner results from the known packages so they show
+// versions, descriptions and installed state
+func (m model) ownerPackages(owners []Package) []Package {
+	packages := make([]Package, 0, len(owners))
+	for _, owner := range owners {
+		pkg := owner
+		if known, ok := m.resolvePackage(owner.Name); ok && (owner.Source == "" || known.Source == owner.Source) {
+			pkg = known
+		}
+		if pkg.Source == "" {
+			pkg.Source = "local"
+		}
+		pkg.Installed = m.installedSet[pkg.Name] || owner.Installed
+		packages = append(packages, pkg)
+	}
+	return packages
+}
+
+// executeSyncFilesInTerminal downloads the pacman file database with sudo
+// pacman -Fy using tea.ExecProcess
+func executeSyncFilesInTerminal() tea.Cmd {
+	c := exec.Command("sudo", "pacman", "-Fy")
+	started := time.Now()
+	return execProcess(c, func(err error) tea.Msg {
+		return execCompleteMsg{operation: confirmSyncFiles, started: started, retry: executeSyncFilesInTerminal, err: err}
+	})
+}
+
+// syncDBCheckMsg carries the time the sync databases were last downloaded
+type syncDBCheckMsg struct {
+	modified time.Time
+}
+
+// dashboardUpdatesMsg carries the number of updates the dashboard shows as pending
+type dashboardUpdatesMsg struct {
+	available int
+}
+
+// countPendingUpdates runs the update check for the dashboard, counting the
+// updates that would be installed. Held, pinned and ignored packages are left
+// out; devel packages are counted when the update view checks them.
+func countPendingUpdates(r Runner, held map[string]bool, pinned map[string]string, devel bool) tea.Cmd {
+	check := checkUpdates(r, held, pinned, devel)
+	return func() tea.Msg {
+		return dashboardUpdatesMsg{available: pendingUpdateCount(check)}
+	}
+}
+
+// pendingUpdateCount runs an update check and counts the updates it would install
+func pendingUpdateCount(check tea.Cmd) int {
+	var available int
+	if msg, ok := check().(updateCheckMsg); ok {
+		for _, u := range msg.updates {
+			if u.class == updateAvailable {
+				available++
+			}
+		}
+	}
+	return available
+}
+
+// checkSyncDBAge reads the modification times of the sync databases. The
+// newest one is used: pacman -Sy leaves databases that did not change on the
+// mirror untouched, so a quiet repository alone does not mean a stale sync.
+func checkSyncDBAge() tea.Cmd {
+	return func() tea.Msg {
+		matches, _ := filepath.Glob(filepath.Join(pacmanDBDir(), "sync", "*.db"))
+		var newest time.Time
+		for _, path := range matches {
+			if info, err := os.Stat(path); err == nil && info.ModTime().After(newest) {
+				newest = info.ModTime()
+			}
+		}
+		return syncDBCheckMsg{modified: newest}
+	}
+}
+
+// syncDBAge returns how long ago the sync databases were downloaded, and
+// whether that is past the sync_stale_hours threshold
+func (m model) syncDBAge() (age time.Duration, stale bool) {
+	if m.syncDBTime.IsZero() {
+		return 0, false
+	}
+	age = time.Since(m.syncDBTime)
+	threshold := time.Duration(m.settings.SyncStaleHours) * time.Hour
+	return age, threshold > 0 && age > threshold
+}
+
+// formatAge renders a duration in its largest whole unit, e.g. "3d" or "5h"
+func formatAge(d time.Duration) string {
+	switch {
+	case d >= 24*time.Hour:
+		return fmt.Sprintf("%dd", int(d/(24*time.Hour)))
+	case d >= time.Hour:
+		return fmt.Sprintf("%dh", int(d/time.Hour))
+	default:
+		return fmt.Sprintf("%dm", int(d/time.Minute))
+	}
+}
+
+// executeSyncDBInTerminal refreshes the sync databases with sudo pacman -Sy
+// using tea.ExecProcess
+func executeSyncDBInTerminal() tea.Cmd {
+	c := exec.Command("sudo", "pacman", "-Sy")
+	started := time.Now()
+	return execProcess(c, func(err error) tea.Msg {
+		return execCompleteMsg{operation: confirmSyncDB, started: started, retry: executeSyncDBInTerminal, err: err}
+	})
+}
+
+// pacnewFile is a .pacnew or .pacsave file pacman left beside a configuration file
+type pacnewFile struct {
+	path  string
+	kind  string // pacnew or pacsave
+	owner string // Package owning the original file; empty when none does
+}
+
+type pacnewCheckMsg struct {
+	files []pacnewFile
+	err   error
+}
+
+type pacdiffClosedMsg struct{ err error }
+
+// pacnewKind reports whether path is a .pacnew or .pacsave file, including
+// the numbered .pacsave.N copies, and returns the path of the original
+func pacnewKind(path string) (kind, original string) {
+	for _, k := range []string{"pacnew", "pacsave"} {
+		if i := strings.LastIndex(path, "."+k); i > 0 {
+			rest := path[i+len(k)+1:]
+			if rest == "" || (k == "pacsave" && strings.HasPrefix(rest, ".") && isDigits(rest[1:])) {
+				return k, path[:i]
+			}
+		}
+	}
+	return "", ""
+}
+
+// isDigits reports whether s is a non-empty run of ASCII digits
+func isDigits(s string) bool {
+	if s == "" {
+		return false
+	}
+	for _, r := range s {
+		if r < '0' || r > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+// pacdiffPaths splits pacdiff --output into its paths, one per line; paths
+// may contain spaces
+func pacdiffPaths(out string) []string {
+	var paths []string
+	for _, line := range strings.Split(out, "\n") {
+		if line != "" {
+			paths = append(paths, line)
+		}
+	}
+	return paths
+}
+
+// findPacnewFiles lists the .pacnew and .pacsave files waiting to be merged,
+// with the packages owning the originals. pacdiff --output is used when
+// pacman-contrib is installed; otherwise /etc is walked, skipping the
+// directories that aren't readable.
+func findPacnewFiles(r Runner) tea.Cmd {
+	return func() tea.Msg {
+		var paths []string
+		if _, err := exec.LookPath("pacdiff"); err == nil {
+			out, _, err := r.Run("pacdiff", "--output")
+			if err != nil {
+				return pacnewCheckMsg{err: err}
+			}
+			paths = pacdiffPaths(out)
+		} else {
+			filepath.WalkDir("/etc", func(path string, d os.DirEntry, err error) error {
+				if err != nil {
+					if d != nil && d.IsDir() {
+						return filepath.SkipDir
 					}
+					return nil
+				}
+				if kind, _ := pacnewKind(path); kind != "" && !d.IsDir() {
+					paths = append(paths, path)
+				}
+				return nil
+			})
+		}
+
+		var files []pacnewFile
+		for _, path := range paths {
+			kind, original := pacnewKind(path)
+			if kind == "" {
+				continue
+			}
+			file := pacnewFile{path: path, kind: kind}
+			if out, _, err := r.Run("pacman", "-Qqo", "--", original); err == nil {
+				file.owner = strings.Join(strings.Fields(out), ", ")
+			}
+			files = append(files, file)
+		}
+		sort.Slice(files, func(i, j int) bool { return files[i].path < files[j].path })
+		return pacnewCheckMsg{files: files}
+	}
+}
+
+// runPacdiff merges the .pacnew and .pacsave files with pacdiff using
+// tea.ExecProcess. --sudo keeps the user's DIFFPROG and only elevates writes.
+func runPacdiff() tea.Cmd {
+	c := exec.Command("pacdiff", "--sudo")
+	return execProcess(c, func(err error) tea.Msg {
+		return pacdiffClosedMsg{err: err}
+	})
+}
+
+// auditFinding is an installed package arch-audit reports as vulnerable
+type auditFinding struct {
+	name       string
+	severity   string   // Critical, High, Medium, Low or Unknown
+	advisories []string // AVG and CVE identifiers, when arch-audit lists them
+}
+
+type auditCheckMsg struct {
+	findings  []auditFinding
+	installed bool // arch-audit was found in PATH
+	err       error
+}
+
+// Severity words and identifiers are matched anywhere in an arch-audit line,
+// since its sentence layout has changed between releases
+var (
+	auditPackagePattern  = regexp.MustCompile(`(?i)\bpackage\s+(\S+)`)
+	auditSeverityPattern = regexp.MustCompile(`(?i)\b(critical|high|medium|low|unknown)\b`)
+	auditAdvisoryPattern = regexp.MustCompile(`(?i)\b(AVG-\d+|CVE-\d{4}-\d+)\b`)
+)
+
+// auditSeverityRank orders severities from Unknown (0) to Critical (4)
+func auditSeverityRank(severity string) int {
+	switch strings.ToLower(severity) {
+	case "critical":
+		return 4
+	case "high":
+		return 3
+	case "medium":
+		return 2
+	case "low":
+		return 1
+	}
+	return 0
+}
+
+// parseArchAudit reads arch-audit's report, one finding per package, the most
+// severe first. Lines repeating a package merge into its finding.
+func parseArchAudit(output string) []auditFinding {
+	var findings []auditFinding
+	index := make(map[string]int)
+	seen := make(map[string]bool) // Package and advisory pairs already listed
+	for _, line := range strings.Split(output, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+		name := ""
+		if match := auditPackagePattern.FindStringSubmatch(line); match != nil {
+			name = match[1]
+		} else {
+			name = strings.Fields(line)[0]
+		}
+		if !isValidPackageName(name) {
+			continue
+		}
+		severity := "Unknown"
+		for _, match := range auditSeverityPattern.FindAllStringSubmatch(line, -1) {
+			if auditSeverityRank(match[1]) > auditSeverityRank(severity) {
+				severity = strings.ToUpper(match[1][:1]) + strings.ToLower(match[1][1:])
+			}
+		}
+		i, ok := index[name]
+		if !ok {
+			i = len(findings)
+			index[name] = i
+			findings = append(findings, auditFinding{name: name, severity: severity})
+		} else if auditSeverityRank(severity) > auditSeverityRank(findings[i].severity) {
+			findings[i].severity = severity
+		}
+		for _, id := range auditAdvisoryPattern.FindAllString(line, -1) {
+			id = strings.ToUpper(id)
+			if !seen[name+" "+id] {
+				seen[name+" "+id] = true
+				findings[i].advisories = append(findings[i].advisories, id)
+			}
+		}
+	}
+	sort.SliceStable(findings, func(i, j int) bool {
+		ri, rj := auditSeverityRank(findings[i].severity), auditSeverityRank(findings[j].severity)
+		if ri != rj {
+			return ri > rj
+		}
+		return findings[i].name < findings[j].name
+	})
+	return findings
+}
+
+// runArchAudit lists the installed packages with known vulnerabilities using
+// arch-audit, when it is installed
+func runArchAudit(r Runner) tea.Cmd {
+	return func() tea.Msg {
+		if _, err := exec.LookPath("arch-audit"); err != nil {
+			return auditCheckMsg{}
+		}
+		out, stderr, err := r.Run("arch-audit")
+		if err != nil && strings.TrimSpace(out) == "" {
+			if msg := strings.TrimSpace(stderr); msg != "" {
+				err = fmt.Errorf("%s", msg)
+			}
+			return auditCheckMsg{installed: true, err: err}
+		}
+		return auditCheckMsg{findings: parseArchAudit(out), installed: true}
+	}
+}
+
+// systemHealthMsg carries the post-update health checks: failed systemd
+// units and whether the running kernel was replaced by an update
+type systemHealthMsg struct {
+	systemd        bool // systemctl answered; otherwise failedUnits is unknown
+	failedUnits    []string
+	runningKernel  string // uname -r
+	kernelPackage  string // Package the running kernel came from, e.g. linux-lts, or "unknown"
+	kernelVersion  string // Its installed version, when the package is still installed
+	rebootRequired bool
+}
+
+// checkSystemHealth lists the failed systemd units and compares the running
+// kernel with the installed one. Rather than matching uname -r against
+// version strings, which every kernel flavor formats differently, it checks
+// for the running kernel's modules: pacman removes them when the kernel
+// package is upgraded, so a missing directory means a reboot is due. The
+// pkgbase file naming the kernel package goes with them; the boot image on
+// the kernel command line names it then.
+func checkSystemHealth(r Runner) tea.Cmd {
+	return func() tea.Msg {
+		var msg systemHealthMsg
+		if out, _, err := r.Run("systemctl", "--failed", "--no-legend", "--plain"); err == nil {
+			msg.systemd = true
+			for _, line := range strings.Split(out, "\n") {
+				if fields := strings.Fields(line); len(fields) > 0 {
+					msg.failedUnits = append(msg.failedUnits, fields[0])
+				}
+			}
+		}
+
+		out, _, err := r.Run("uname", "-r")
+		release := strings.TrimSpace(out)
+		if err != nil || release == "" {
+			return msg
+		}
+		msg.runningKernel = release
+		modules := filepath.Join("/usr/lib/modules", release)
+		if _, err := os.Stat(modules); err != nil {
+			msg.rebootRequired = os.IsNotExist(err)
+			msg.kernelPackage = "unknown"
+			if cmdline, err := os.ReadFile("/proc/cmdline"); err == nil {
+				if pkgbase := bootImagePkgbase(string(cmdline)); pkgbase != "" {
+					msg.kernelPackage = pkgbase
+				}
+			}
+		} else if pkgbase, err := os.ReadFile(filepath.Join(modules, "pkgbase")); err == nil {
+			msg.kernelPackage = strings.TrimSpace(string(pkgbase))
+		}
+		if msg.kernelPackage != "" && msg.kernelPackage != "unknown" {
+			if out, _, err := r.Run("pacman", "-Q", msg.kernelPackage); err == nil {
+				if fields := strings.Fields(out); len(fields) == 2 {
+					msg.kernelVersion = fields[1]
+				}
+			}
+		}
+		return msg
+	}
+}
+
+// bootImagePkgbase returns the kernel package of the BOOT_IMAGE on a kernel
+// command line, installed by mkinitcpio as /boot/vmlinuz-<pkgbase>, or ""
+// when the boot loader passes none
+func bootImagePkgbase(cmdline string) string {
+	for _, field := range strings.Fields(cmdline) {
+		if image, ok := strings.CutPrefix(field, "BOOT_IMAGE="); ok {
+			pkgbase, ok := strings.CutPrefix(filepath.Base(image), "vmlinuz-")
+			if ok {
+				return pkgbase
+			}
+		}
+	}
+	return ""
+}
+
+// executeInstallReasonInTerminal marks packages as dependencies or as
+// explicitly installed with sudo pacman -D using tea.ExecProcess
+func executeInstallReasonInTerminal(packages []string, asDeps bool) tea.Cmd {
+	// Validate all package names to prevent command injection
+	validNames, _ := sanitizePackageNames(packages)
+	if len(validNames) == 0 {
+		return func() tea.Msg {
+			return execCompleteMsg{operation: confirmInstallReason, packages: packages, err: fmt.Errorf("no valid package names")}
+		}
+	}
+
+	flag := "--asexplicit"
+	if asDeps {
+		flag = "--asdeps"
+	}
+	args := append([]string{"pacman", "-D", flag}, validNames...)
+	c := exec.Command("sudo", args...)
+	started := time.Now()
+	return execProcess(c, func(err error) tea.Msg {
+		retry := func() tea.Cmd { return executeInstallReasonInTerminal(validNames, asDeps) }
+		return execCompleteMsg{operation: confirmInstallReason, packages: validNames, started: started, retry: retry, err: err}
+	})
+}
+
+// queueStep is one paru run of a queued transaction
+type queueStep struct {
+	operation confirmationType // confirmUninstall or confirmInstall
+	flags     string           // Removal flags; removals queued with different flags are separate steps
+	packages  []string
+}
+
+// command is the helper command line the step runs, without its packages
+func (s queueStep) command() string {
+	if s.operation == confirmUninstall {
+		return helper.name + " " + s.flags
+	}
+	return helper.name + " -S"
+}
+
+// executeQueueStepInTerminal runs one step of a queued transaction
+// interactively using tea.ExecProcess
+func executeQueueStepInTerminal(step queueStep) tea.Cmd {
+	// Validate all package names to prevent command injection
+	validNames, _ := sanitizePackageNames(step.packages)
+	if len(validNames) == 0 {
+		return func() tea.Msg {
+			return execCompleteMsg{operation: step.operation, packages: step.packages, queued: true, err: fmt.Errorf("no valid package names")}
+		}
+	}
+
+	args := append([]string{step.flags}, validNames...)
+	if step.operation == confirmInstall {
+		args = append([]string{"-S"}, validNames...)
+	}
+	c := helper.command(args...)
+	logPath := ""
+	finish := func(error) []string { return nil }
+	if step.operation == confirmInstall {
+		logPath = attachBuildLog(c, "install")
+	} else {
+		finish = captureOutput(c)
+	}
+	started := time.Now()
+	return execProcess(c, func(err error) tea.Msg {
+		return execCompleteMsg{operation: step.operation, packages: validNames, started: started, logPath: logPath, output: finish(err), queued: true, err: err}
+	})
+}
+
+// queueMarked moves the marked packages into the queued removals (remove
+// mode) or installs (install mode). Removals keep the removal flags chosen
+// now. Installed packages and package sets are not queued for installation.
+func (m *model) queueMarked() {
+	if m.queuedInstalls == nil {
+		m.queuedInstalls = make(map[string]bool)
+	}
+	if m.queuedRemovals == nil {
+		m.queuedRemovals = make(map[string]string)
+	}
+	for name := range m.markedPackages {
+		if m.mode == modeUninstall {
+			m.queuedRemovals[name] = m.uninstallFlags()
+		} else if _, isSet := m.packageSets[name]; !isSet && !m.installedSet[name] {
+			m.queuedInstalls[name] = true
+		}
+	}
+	m.markedPackages = make(map[string]bool)
+	m.selectionPanelFocused = false
+}
+
+// queueConflicts lists packages queued for both removal and installation
+func (m model) queueConflicts() []string {
+	var conflicts []string
+	for name := range m.queuedRemovals {
+		if m.queuedInstalls[name] {
+			conflicts = append(conflicts, name)
+		}
+	}
+	sort.Strings(conflicts)
+	return conflicts
+}
+
+// queueSteps orders the queued transaction: removals first, a step for
+// each set of removal flags, then installs
+func (m model) queueSteps() []queueStep {
+	var steps []queueStep
+	byFlags := make(map[string]map[string]bool)
+	for name, flags := range m.queuedRemovals {
+		if byFlags[flags] == nil {
+			byFlags[flags] = make(map[string]bool)
+		}
+		byFlags[flags][name] = true
+	}
+	for _, flags := range sortedKeys(byFlags) {
+		steps = append(steps, queueStep{operation: confirmUninstall, flags: flags, packages: sortedKeys(byFlags[flags])})
+	}
+	if len(m.queuedInstalls) > 0 {
+		steps = append(steps, queueStep{operation: confirmInstall, packages: sortedKeys(m.queuedInstalls)})
+	}
+	return steps
+}
+
+// queueVisibleRows is how many rows of the queue review show at once
+const queueVisibleRows = 10
+
+// queueRow is a row of the queue review: a step's header, or one of its
+// packages
+type queueRow struct {
+	step int    // Index into queueSteps
+	pkg  string // Empty for the header
+}
+
+// queueRows lays out the queue review's scrollable list
+func (m model) queueRows() []queueRow {
+	var rows []queueRow
+	for i, step := range m.queueSteps() {
+		rows = append(rows, queueRow{step: i})
+		for _, name := range step.packages {
+			rows = append(rows, queueRow{step: i, pkg: name})
+		}
+	}
+	return rows
+}
+
+// previewDependents looks up what depends on the packages of a removal
+// without resolving the rest of the transaction
+func previewDependents(r Runner, key string, packages []string) tea.Cmd {
+	return func() tea.Msg {
+		return transactionPreviewMsg{key: key, dependents: removalDependents(r, packages)}
+	}
+}
+
+// queueStepStatus describes a running step of the queued transaction
+func (m model) queueStepStatus() string {
+	step := m.runningQueue[m.queueStepIndex]
+	verb := "installing"
+	if step.operation == confirmUninstall {
+		verb = "removing"
+	}
+	return fmt.Sprintf("Step %d of %d: %s %d package(s)...", m.queueStepIndex+1, len(m.runningQueue), verb, len(step.packages))
+}
+
+// handleQueueStepComplete records a finished step of the queued transaction
+// and starts the next one. A failed step stops the steps after it.
+func (m model) handleQueueStepComplete(msg execCompleteMsg) (tea.Model, tea.Cmd) {
+	refresh := tea.Batch(getInstalledPackages(m.runner), m.requestRefresh(false))
+	if msg.err != nil {
+		step := m.runningQueue[m.queueStepIndex]
+		m.loading = false
+		m.showErrorOverlay = true
+		m.errorTitle = "Queued Transaction Failed"
+		m.sessionWarnings = append(m.sessionWarnings, m.errorTitle)
+		m.errorMessage = fmt.Sprintf("Step %d of %d (%s %s) failed; the steps after it were not run.",
+			m.queueStepIndex+1, len(m.runningQueue), strings.ToLower(operationName(step.operation)), strings.Join(step.packages, " "))
+		m.errorDetails = failureDetails(msg.err, msg.output)
+		m.setErrorOutput(msg.output)
+		m.retry, m.retryOffered = nil, true
+		m.statusMessage = m.errorTitle
+		m.lastCompletedOp = ""
+		if msg.logPath != "" && m.openBuildLog(msg.logPath) {
+			m.showErrorOverlay = false
+		}
+		m.runningQueue = nil
+		m.queueStepIndex = 0
+		return m, refresh
+	}
+
+	if msg.operation == confirmInstall {
+		m.applyInstalledChanges(msg.packages, nil)
+	} else {
+		m.applyInstalledChanges(nil, msg.packages)
+	}
+	m.queueStepIndex++
+	if m.queueStepIndex < len(m.runningQueue) {
+		m.statusMessage = m.queueStepStatus()
+		return m, executeQueueStepInTerminal(m.runningQueue[m.queueStepIndex])
+	}
+
+	var parts []string
+	for _, step := range m.runningQueue {
+		if step.operation == confirmUninstall {
+			parts = append(parts, fmt.Sprintf("removed %d", len(step.packages)))
+		} else {
+			parts = append(parts, fmt.Sprintf("installed %d", len(step.packages)))
+		}
+	}
+	m.loading = false
+	m.lastCompletedOp = "Queued transaction done: " + strings.Join(parts, ", ")
+	m.statusMessage = m.lastCompletedOp
+	m.runningQueue = nil
+	m.queueStepIndex = 0
+	return m, refresh
+}
+
+// markAllLimit is the most packages ctrl+a marks without asking again
+const markAllLimit = 200
+
+// bulkMark applies ctrl+a (mark all), ctrl+t (invert) or ctrl+x (clear) to
+// the filtered list. Installed packages are never marked in install mode, and
+// marking more than markAllLimit packages takes a second ctrl+a.
+func (m *model) bulkMark(key string) {
+	list := m.filtered
+	if m.mode == modeUninstall {
+		list = m.filteredInstalled
+	}
+	var candidates []string
+	skipped := 0
+	for _, pkg := range list {
+		switch {
+		case pkg.Source == "set":
+			continue
+		case m.mode == modeInstall && m.resultInstalled(pkg):
+			skipped++
+			continue
+		}
+		candidates = append(candidates, pkg.Name)
+	}
+
+	switch key {
+	case "ctrl+a":
+		armed := fmt.Sprintf("%d:%s:%d", m.mode, m.textInput.Value(), len(candidates))
+		if len(candidates) > markAllLimit && m.markAllArmed != armed {
+			m.markAllArmed = armed
+			m.statusMessage = fmt.Sprintf("Mark all %d packages? Press [ctrl+a] again to confirm", len(candidates))
+			return
+		}
+		for _, name := range candidates {
+			m.markedPackages[name] = true
+		}
+	case "ctrl+t":
+		for _, name := range candidates {
+			if m.markedPackages[name] {
+				delete(m.markedPackages, name)
+			} else {
+				m.markedPackages[name] = true
+			}
+		}
+	case "ctrl+x":
+		m.markedPackages = make(map[string]bool)
+		m.selectionPanelFocused = false
+	}
+	m.markAllArmed = ""
+
+	m.statusMessage = fmt.Sprintf("%d packages marked", len(m.markedPackages))
+	if skipped > 0 && key != "ctrl+x" {
+		m.statusMessage += fmt.Sprintf(" (%d installed skipped)", skipped)
+	}
+	if len(m.markedPackages) == 0 {
+		m.selectionPanelFocused = false
+	}
+}
+
+// confirmInstallReasonChange opens the dialog that flips the install reason of
+// the marked packages, or the selected one. When every package is explicitly
+// installed they become dependencies, otherwise they all become explicit.
+// The marks stay until the change is confirmed, so cancelling keeps them.
+func (m *model) confirmInstallReasonChange() {
+	var names []string
+	for name := range m.markedPackages {
+		names = append(names, name)
+	}
+	if len(names) == 0 && m.selectedIndex < len(m.filteredInstalled) {
+		names = []string{m.filteredInstalled[m.selectedIndex].Name}
+	}
+	if len(names) == 0 {
+		return
+	}
+	sort.Strings(names)
+
+	explicit := make(map[string]bool)
+	for _, pkg := range m.installed {
+		explicit[pkg.Name] = pkg.Explicit
+	}
+	m.confirmAsDeps = true
+	for _, name := range names {
+		if !explicit[name] {
+			m.confirmAsDeps = false
+		}
+	}
+	m.showConfirmation = true
+	m.confirmType = confirmInstallReason
+	m.confirmPackages = names
+	m.confirmScrollOffset = 0
+	m.statusMessage = "Confirm install reason change"
+}
+
+// setQueryPrefix lists named package sets instead of packages in install mode
+const setQueryPrefix = "sets:"
+
+// parseSetQuery reports whether input is a "sets:" query and returns the
+// search text after the prefix
+func parseSetQuery(input string) (string, bool) {
+	input = strings.TrimSpace(input)
+	if len(input) < len(setQueryPrefix) || !strings.EqualFold(input[:len(setQueryPrefix)], setQueryPrefix) {
+		return "", false
+	}
+	return strings.TrimSpace(input[len(setQueryPrefix):]), true
+}
+
+// setPackages returns the configured package sets as pseudo-packages with
+// Source "set", annotated with member and missing counts
+func (m model) setPackages(query string) []Package {
+	var names []string
+	for name := range m.packageSets {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var packages []Package
+	for _, name := range names {
+		members, err := expandPackageSet(m.packageSets, name)
+		pkg := Package{Source: "set", Name: name}
+		if err != nil {
+			pkg.Version = "(invalid)"
+			pkg.Description = err.Error()
+		} else {
+			missing := 0
+			for _, member := range members {
+				if !m.installedSet[member] {
+					missing++
+				}
+			}
+			pkg.Version = fmt.Sprintf("(%d members, %d missing)", len(members), missing)
+			pkg.Installed = missing == 0 && len(members) > 0
+			pkg.Description = "Members: " + strings.Join(members, " ")
+		}
+		packages = append(packages, pkg)
+	}
+	return fuzzyFilter(packages, query)
+}
+
+// confirmPackageSet expands a named set into the install confirmation dialog,
+// skipping members that are already installed and flagging names that are
+// not in any sync database
+func (m model) confirmPackageSet(name string) (tea.Model, tea.Cmd) {
+	members, err := expandPackageSet(m.packageSets, name)
+	if err != nil {
+		m.statusMessage = fmt.Sprintf("Set %s: %v", name, err)
+		return m, nil
+	}
+
+	known := make(map[string]bool, len(m.repoPackages)+len(m.aurPackages))
+	for _, pkg := range m.repoPackages {
+		known[pkg.Name] = true
+	}
+	for _, pkg := range m.aurPackages {
+		known[pkg.Name] = true
+	}
+
+	var toInstall, skipped, unknown []string
+	for _, member := range members {
+		switch {
+		case !isValidPackageName(member):
+			unknown = append(unknown, member)
+		case m.installedSet[member]:
+			skipped = append(skipped, member)
+		default:
+			if !known[member] {
+				// Not in the sync databases - may still be an AUR package
+				unknown = append(unknown, member)
+			}
+			toInstall = append(toInstall, member)
+		}
+	}
+
+	if len(toInstall) == 0 {
+		m.statusMessage = fmt.Sprintf("All members of set %s are already installed", name)
+		return m, nil
+	}
+	m.showConfirmation = true
+	m.confirmType = confirmInstall
+	m.confirmPackages = toInstall
+	m.confirmSkipped = skipped
+	m.confirmUnknown = unknown
+	m.confirmScrollOffset = 0
+	m.statusMessage = fmt.Sprintf("Confirm installation of set %s", name)
+	return m, nil
+}
+
+// Repo filter character mappings
+var repoFilterChars = map[rune]string{
+	'c': "core",
+	'e': "extra",
+	'm': "multilib",
+	'a': "aur",
+}
+
+// uninstallFilterChars maps single characters to package filter types for uninstall mode
+var uninstallFilterChars = map[rune]string{
+	't': "total",    // All packages
+	'e': "explicit", // Explicitly installed packages
+	'f': "foreign",  // Foreign/AUR packages
+	'o': "orphan",   // Orphan packages
+}
+
+// officialRepos are the Arch Linux repositories; anything else is third-party
+var officialRepos = map[string]bool{
+	"core":             true,
+	"extra":            true,
+	"multilib":         true,
+	"core-testing":     true,
+	"extra-testing":    true,
+	"multilib-testing": true,
+	"gnome-unstable":   true,
+	"kde-unstable":     true,
+}
+
+// pacmanConfPath is the pacman configuration read for repository signature levels
+const pacmanConfPath = "/etc/pacman.conf"
+
+// sigLevel is the effective signature checking of a repository. Check is
+// "Never", "Optional" or "Required"; trust is "TrustedOnly" or "TrustAll".
+type sigLevel struct {
+	pkgCheck string
+	pkgTrust string
+	dbCheck  string
+	dbTrust  string
+}
+
+// defaultSigLevel is pacman's built-in default, "Required DatabaseOptional"
+var defaultSigLevel = sigLevel{
+	pkgCheck: "Required",
+	pkgTrust: "TrustedOnly",
+	dbCheck:  "Optional",
+	dbTrust:  "TrustedOnly",
+}
+
+// weak reports why packages from a repository with this level are not
+// verified against trusted keys, or "" when they are
+func (s sigLevel) weak() string {
+	switch {
+	case s.pkgCheck == "Never":
+		return "SigLevel Never"
+	case s.pkgTrust == "TrustAll":
+		return "SigLevel TrustAll"
+	}
+	return ""
+}
+
+// applySigLevel applies the options of a SigLevel value on top of base. Each
+// option may carry a "Package" or "Database" prefix limiting it to one of the
+// two; without a prefix it applies to both.
+func applySigLevel(base sigLevel, value string) (sigLevel, error) {
+	level := base
+	for _, option := range strings.Fields(value) {
+		pkg, db := true, true
+		name := option
+		if rest, ok := strings.CutPrefix(option, "Package"); ok {
+			name, db = rest, false
+		} else if rest, ok := strings.CutPrefix(option, "Database"); ok {
+			name, pkg = rest, false
+		}
+
+		switch name {
+		case "Never", "Optional", "Required":
+			if pkg {
+				level.pkgCheck = name
+			}
+			if db {
+				level.dbCheck = name
+			}
+		case "TrustedOnly", "TrustAll":
+			if pkg {
+				level.pkgTrust = name
+			}
+			if db {
+				level.dbTrust = name
+			}
+		default:
+			return base, fmt.Errorf("unknown SigLevel option %q", option)
+		}
+	}
+	return level, nil
+}
+
+// parsePacmanConfSigLevels returns the effective signature level of every
+// repository section in a pacman.conf. Repository SigLevel options are applied
+// on top of the [options] SigLevel, which itself starts from pacman's default.
+// Include directives are not followed.
+func parsePacmanConfSigLevels(r io.Reader) (map[string]sigLevel, error) {
+	var global []string
+	repoValues := make(map[string][]string)
+	var repos []string
+	section := ""
+
+	scanner := bufio.NewScanner(r)
+	lineNum := 0
+	for scanner.Scan() {
+		lineNum++
+		line := scanner.Text()
+		if i := strings.Index(line, "#"); i >= 0 {
+			line = line[:i]
+		}
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+		if strings.HasPrefix(line, "[") && strings.HasSuffix(line, "]") {
+			section = strings.TrimSpace(line[1 : len(line)-1])
+			if section != "options" {
+				if _, seen := repoValues[section]; !seen {
+					repos = append(repos, section)
+					repoValues[section] = nil
+				}
+			}
+			continue
+		}
+
+		key, value, ok := strings.Cut(line, "=")
+		if !ok || strings.TrimSpace(key) != "SigLevel" {
+			continue
+		}
+		value = strings.TrimSpace(value)
+		switch section {
+		case "":
+			return nil, fmt.Errorf("line %d: SigLevel outside of a section", lineNum)
+		case "options":
+			global = append(global, value)
+		default:
+			repoValues[section] = append(repoValues[section], value)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	base := defaultSigLevel
+	for _, value := range global {
+		var err error
+		if base, err = applySigLevel(base, value); err != nil {
+			return nil, fmt.Errorf("[options]: %w", err)
+		}
+	}
+	levels := make(map[string]sigLevel, len(repos))
+	for _, repo := range repos {
+		level := base
+		for _, value := range repoValues[repo] {
+			var err error
+			if level, err = applySigLevel(level, value); err != nil {
+				return nil, fmt.Errorf("[%s]: %w", repo, err)
+			}
+		}
+		levels[repo] = level
+	}
+	return levels, nil
+}
+
+// parsePacmanConfRepos returns the repository sections that are enabled,
+// that is not commented out, in pacman.conf
+func parsePacmanConfRepos(r io.Reader) (map[string]bool, error) {
+	repos := make(map[string]bool)
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if i := strings.Index(line, "#"); i >= 0 {
+			line = line[:i]
+		}
+		line = strings.TrimSpace(line)
+		if strings.HasPrefix(line, "[") && strings.HasSuffix(line, "]") {
+			if section := strings.TrimSpace(line[1 : len(line)-1]); section != "options" {
+				repos[section] = true
+			}
+		}
+	}
+	return repos, scanner.Err()
+}
+
+// enabledRepos reads the enabled repositories from pacman.conf once; nil
+// means the file could not be read
+var enabledRepos = sync.OnceValue(func() map[string]bool {
+	f, err := os.Open(pacmanConfPath)
+	if err != nil {
+		return nil
+	}
+	defer f.Close()
+	repos, err := parsePacmanConfRepos(f)
+	if err != nil {
+		return nil
+	}
+	return repos
+})
+
+// toolchainRule is a prerequisite that some packages need to build or work,
+// which installing them doesn't pull in
+type toolchainRule struct {
+	prerequisite string // Package to add, or a repository to enable
+	repository   bool   // The prerequisite is a pacman.conf section
+	reason       string
+	applies      func(name string, aur bool) bool
+}
+
+// toolchainRules are checked against the packages in an install confirmation
+var toolchainRules = []toolchainRule{
+	{
+		prerequisite: "base-devel",
+		reason:       "AUR packages are built with makepkg",
+		applies:      func(_ string, aur bool) bool { return aur },
+	},
+	{
+		prerequisite: "git",
+		reason:       "-git packages clone their sources",
+		applies:      func(name string, _ bool) bool { return strings.HasSuffix(name, "-git") },
+	},
+	{
+		prerequisite: "multilib",
+		repository:   true,
+		reason:       "lib32 packages come from the multilib repository",
+		applies:      func(name string, _ bool) bool { return strings.HasPrefix(name, "lib32-") },
+	},
+}
+
+// toolchainAdvice is a missing prerequisite and the packages needing it
+type toolchainAdvice struct {
+	rule     toolchainRule
+	packages []string
+}
+
+// findToolchainAdvice applies the rules to the packages being installed.
+// satisfied reports whether a rule's prerequisite is already present.
+func findToolchainAdvice(names []string, isAUR func(string) bool, satisfied func(toolchainRule) bool) []toolchainAdvice {
+	var advice []toolchainAdvice
+	for _, rule := range toolchainRules {
+		var needing []string
+		for _, name := range names {
+			if rule.applies(name, isAUR(name)) {
+				needing = append(needing, name)
+			}
+		}
+		if len(needing) > 0 && !satisfied(rule) {
+			advice = append(advice, toolchainAdvice{rule: rule, packages: needing})
+		}
+	}
+	return advice
+}
+
+// installAdvice returns the toolchain advice for the install confirmation
+func (m model) installAdvice() []toolchainAdvice {
+	if m.confirmType != confirmInstall {
+		return nil
+	}
+	inTransaction := make(map[string]bool, len(m.confirmPackages))
+	for _, name := range m.confirmPackages {
+		inTransaction[name] = true
+	}
+	isAUR := func(name string) bool {
+		// Without the sync database loaded nothing can be called AUR-only
+		if len(m.repoPackages) == 0 {
+			return false
+		}
+		_, inRepo := m.repoIndex[name]
+		return !inRepo
+	}
+	satisfied := func(rule toolchainRule) bool {
+		if rule.repository {
+			repos := enabledRepos()
+			return repos == nil || repos[rule.prerequisite]
+		}
+		return m.installedSet[rule.prerequisite] || inTransaction[rule.prerequisite]
+	}
+	return findToolchainAdvice(m.confirmPackages, isAUR, satisfied)
+}
+
+// RepoCount is the number of installed packages from a third-party repository
+type RepoCount struct {
+	Name    string
+	Count   int
+	Warning string // Weak signature checking, from pacman.conf
+}
+
+// countThirdPartyRepos counts installed packages per third-party repository
+// from pacman -Sl output, in the order the repositories are listed
+func countThirdPartyRepos(syncList string, levels map[string]sigLevel) []RepoCount {
+	var repos []RepoCount
+	index := make(map[string]int)
+	for _, line := range strings.Split(syncList, "\n") {
+		parts := strings.Fields(line)
+		// "[installed: x]" marks an installed version that differs from the repo
+		if len(parts) < 4 || officialRepos[parts[0]] || !strings.HasPrefix(parts[3], "[installed") {
+			continue
+		}
+		i, ok := index[parts[0]]
+		if !ok {
+			i = len(repos)
+			index[parts[0]] = i
+			repos = append(repos, RepoCount{Name: parts[0], Warning: levels[parts[0]].weak()})
+		}
+		repos[i].Count++
+	}
+	return repos
+}
+
+// truncateRunes shortens s to at most n runes
+func truncateRunes(s string, n int) string {
+	if runes := []rune(s); len(runes) > n {
+		return string(runes[:n])
+	}
+	return s
+}
+
+// parseRepoScope splits an "in:REPO rest" uninstall query into the repository
+// and the remaining query
+func parseRepoScope(input string) (string, string) {
+	rest, ok := strings.CutPrefix(strings.TrimSpace(input), "in:")
+	if !ok {
+		return "", input
+	}
+	repo, query, _ := strings.Cut(rest, " ")
+	return repo, strings.TrimSpace(query)
+}
+
+// packagesFromRepo returns the packages installed from repo
+func packagesFromRepo(packages []Package, repo string) []Package {
+	var filtered []Package
+	for _, pkg := range packages {
+		if pkg.Source == repo {
+			filtered = append(filtered, pkg)
+		}
+	}
+	return filtered
+}
+
+// parseRepoFilter extracts repo filters and search query from input
+// Supports combined filters like "ae:", "cem:", "aem:" in any order
+// Returns (repoFilters, searchQuery) where repoFilters is empty if no filter specified
+func parseRepoFilter(input string) (map[string]bool, string) {
+	input = strings.TrimSpace(input)
+	
+	// Look for colon to identify filter prefix
+	colonIdx := strings.Index(input, ":")
+	if colonIdx == -1 {
+		return nil, input
+	}
+	
+	// Extract prefix before colon
+	prefix := strings.ToLower(input[:colonIdx])
+	searchQuery := strings.TrimSpace(input[colonIdx+1:])
+	
+	// Parse each character in prefix as a repo filter
+	repoFilters := make(map[string]bool)
+	for _, ch := range prefix {
+		if repo, ok := repoFilterChars[ch]; ok {
+			repoFilters[repo] = true
+		}
+	}
+	
+	// If no valid repo chars found, treat as regular search
+	if len(repoFilters) == 0 {
+		return nil, input
+	}
+	
+	return repoFilters, searchQuery
+}
+
+// formatRepoFilters returns a human-readable string of active repo filters
+func formatRepoFilters(filters map[string]bool) string {
+	if len(filters) == 0 {
+		return ""
+	}
+	var repos []string
+	// Order consistently
+	for _, repo := range []string{"core", "extra", "multilib", "aur"} {
+		if filters[repo] {
+			repos = append(repos, repo)
+		}
+	}
+	return strings.Join(repos, "+")
+}
+
+// parseUninstallFilter extracts source filters and search query from input for uninstall mode
+// Supports 'a:' for AUR/foreign packages and 'l:' for local/official packages
+func parseUninstallFilter(input string) (map[string]bool, string) {
+	input = strings.TrimSpace(input)
+	
+	// Look for colon to identify filter prefix
+	colonIdx := strings.Index(input, ":")
+	if colonIdx == -1 {
+		return nil, input
+	}
+	
+	// Extract prefix before colon
+	prefix := strings.ToLower(input[:colonIdx])
+	searchQuery := strings.TrimSpace(input[colonIdx+1:])
+	
+	// Parse each character in prefix as a source filter
+	sourceFilters := make(map[string]bool)
+	for _, ch := range prefix {
+		if source, ok := uninstallFilterChars[ch]; ok {
+			sourceFilters[source] = true
+		}
+	}
+	
+	// If no valid filter chars found, treat as regular search
+	if len(sourceFilters) == 0 {
+		return nil, input
+	}
+	
+	return sourceFilters, searchQuery
+}
+
+// formatUninstallFilters returns a human-readable string of active uninstall filters
+func formatUninstallFilters(filters map[string]bool) string {
+	if len(filters) == 0 {
+		return ""
+	}
+	var names []string
+	if filters["total"] {
+		names = append(names, "total")
+	}
+	if filters["explicit"] {
+		names = append(names, "explicit")
+	}
+	if filters["foreign"] {
+		names = append(names, "foreign")
+	}
+	if filters["orphan"] {
+		names = append(names, "orphan")
+	}
+	return strings.Join(names, "+")
+}
+
+// filterAllPackages combines repo and AUR packages, then fuzzy filters together
+// This ensures fzf ranks all packages by relevance to the query
+// Supports repo filtering with prefixes: c (core), e (extra), m (multilib), a (aur)
+// Filters can be combined: ae:, cem:, aem: etc.
+func (m *model) filterAllPackages(query string) {
+	defer m.applyResultSort()
+	if query == "" {
+		m.filtered = []Package{}
+		m.matchQuery = ""
+		return
+	}
+
+	// "sets:" lists configured package sets instead of packages
+	if setQuery, ok := parseSetQuery(query); ok {
+		m.filtered = m.setPackages(setQuery)
+		m.matchQuery = setQuery
+		return
+	}
+
+	// "own:" lists the owners of a file, found by the last lookup
+	if _, ok := parseOwnerQuery(query); ok {
+		m.filtered = m.ownerResults
+		m.matchQuery = ""
+		return
+	}
+
+	// Parse repo filter from query
+	repoFilters, searchQuery := parseRepoFilter(query)
+	
+	// Gather repo packages, groups and AUR packages in the repo filter as
+	// pointers into a buffer kept across keystrokes, so no package is copied
+	// until it is known to be a result
+	groups := m.groupPackages()
+	candidates := m.candidateBuf[:0]
+	for _, source := range [][]Package{m.repoPackages, groups, m.aurPackages} {
+		for i := range source {
+			if len(repoFilters) == 0 || repoFilters[source[i].Source] {
+				candidates = append(candidates, &source[i])
+			}
+		}
+	}
+	m.candidateBuf = candidates
+	m.matchQuery = ""
+
+	// If only repo filter with no search query, show all from those repos
+	if searchQuery == "" || len(candidates) == 0 {
+		m.filtered = make([]Package, len(candidates))
+		for i, pkg := range candidates {
+			m.filtered[i] = *pkg
+		}
+		return
+	}
+
+	// Fuzzy filter all packages together, ranked by relevance. Matched
+	// characters are highlighted with searchQuery, not the prefixed query.
+	m.filtered = fuzzyFilterRefs(candidates, searchQuery)
+	m.matchQuery = searchQuery
+}
+
+// searchAUR searches the AUR via the RPC, or paru when that fails (network call). Cancelling ctx kills
+// paru; results carry seq so superseded searches can be told apart.
+func searchAUR(ctx context.Context, r Runner, query string, seq int) tea.Cmd {
+	return func() tea.Msg {
+		if query == "" {
+			return aurSearchMsg{packages: []Package{}, query: query, seq: seq}
+		}
+
+		// Sanitize search query - only allow safe characters for search
+		// This prevents command injection through the search query
+		var sanitized strings.Builder
+		for _, r := range query {
+			if (r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z') ||
+				(r >= '0' && r <= '9') || r == '-' || r == '_' || r == '.' {
+				sanitized.WriteRune(r)
+			} else if r == ' ' {
+				sanitized.WriteRune('-')
+			}
+			// Skip any other characters (potential injection attempts)
+		}
+		searchQuery := sanitized.String()
+		if searchQuery == "" {
+			return aurSearchMsg{packages: []Package{}, query: query, seq: seq}
+		}
+
+		// Prefer the RPC, which carries votes and maintainers; paru is the fallback
+		if packages, err := aurRPC.search(ctx, searchQuery); err == nil {
+			return aurSearchMsg{packages: packages, query: query, seq: seq}
+		} else if ctx.Err() != nil {
+			return aurSearchMsg{query: query, seq: seq, err: ctx.Err()}
+		}
+
+		// Search AUR only with paru -Ss --aur
+		var stdout bytes.Buffer
+		_, _ = r.Stream(ctx, func(out io.Reader) error {
+			_, err := stdout.ReadFrom(out)
+			return err
+		}, helper.name, "-Ss", "-a", searchQuery)
+		if ctx.Err() != nil {
+			return aurSearchMsg{query: query, seq: seq, err: ctx.Err()}
+		}
+
+		if stdout.Len() == 0 {
+			return aurSearchMsg{packages: []Package{}, query: query, seq: seq}
+		}
+
+		packages := parseAUROutput(stdout.String())
+		return aurSearchMsg{packages: packages, query: query, seq: seq}
+	}
+}
+
+// aurSearchQuery returns the word of an install query the AUR is searched
+// for: its longest term that must match. The AUR matches a single keyword,
+// so the other terms and the exclusions are applied to its results locally.
+func aurSearchQuery(searchQuery string) string {
+	terms, _ := searchTerms(searchQuery)
+	longest := ""
+	for _, term := range terms {
+		if len(term) > len(longest) {
+			longest = term
+		}
+	}
+	return longest
+}
+
+// installSearchHint is the status line hint on the install search syntax,
+// formatted with the minimum query length and the repo package count
+const installSearchHint = "Type at least %d chars, c: e: m: a: to pick repos; all words must match, !word excludes (%d repo packages)"
+
+// startAURSearch kills any running AUR search and starts one for query.
+// Plain pacman can't install AUR packages, so it never searches.
+func (m *model) startAURSearch(query string) tea.Cmd {
+	m.cancelAURSearch()
+	if !helper.aur {
+		return nil
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	m.aurSearchCancel = cancel
+	m.lastAURQuery = query
+	m.searchingAUR = true
+	m.sessionSearches++
+	return searchAUR(ctx, m.runner, query, m.aurSearchSeq)
+}
+
+// debounceAURSearch schedules an AUR search for query after the aur_debounce_ms setting.
+// Typing again or leaving install mode before then drops it.
+func (m *model) debounceAURSearch(query string) tea.Cmd {
+	m.aurDebounceSeq++
+	seq := m.aurDebounceSeq
+	return tea.Tick(m.settings.AURDebounce(), func(time.Time) tea.Msg {
+		return aurSearchTickMsg{query: query, seq: seq}
+	})
+}
+
+// cancelAURSearch kills the running AUR search, if any, and invalidates its results
+func (m *model) cancelAURSearch() {
+	if m.aurSearchCancel != nil {
+		m.aurSearchCancel()
+		m.aurSearchCancel = nil
+	}
+	m.aurSearchSeq++
+	m.searchingAUR = false
+}
+
+// parseAUROutput parses paru -Ss output for AUR packages
+func parseAUROutput(output string) []Package {
+	var packages []Package
+	lines := strings.Split(output, "\n")
+
+	for i := 0; i < len(lines); i++ {
+		line := lines[i]
+		// Skip empty lines and description lines (indented)
+		if line == "" || strings.HasPrefix(line, " ") || strings.HasPrefix(line, "\t") {
+			continue
+		}
+
+		// Format: "aur/package version [+votes ~popularity] [Installed]",
+		// or "(+votes popularity) (Installed)" from yay
+		parts := strings.Fields(line)
+		if len(parts) < 2 {
+			continue
+		}
+
+		repoPkg := strings.SplitN(parts[0], "/", 2)
+		if len(repoPkg) != 2 {
+			continue
+		}
+
+		pkg := Package{
+			Source:    repoPkg[0],
+			Name:      repoPkg[1],
+			Version:   parts[1],
+			Installed: strings.Contains(line, "[Installed") || strings.Contains(line, "(Installed"),
+		}
+		parseAURSearchFlags(line, &pkg)
+
+		// Get description from next line
+		if i+1 < len(lines) && (strings.HasPrefix(lines[i+1], " ") || strings.HasPrefix(lines[i+1], "\t")) {
+			pkg.Description = strings.TrimSpace(lines[i+1])
+		}
+
+		packages = append(packages, pkg)
+	}
+
+	return packages
+}
+
+// Metadata blocks of an AUR helper's search line: paru writes
+// "[+12 ~0.34] [Orphaned] [Out-of-date: 2024-01-31]", yay the same in parentheses
+var (
+	aurVotesPattern     = regexp.MustCompile(`[\[(]\+(\d+) ~?(\d+(?:\.\d+)?)[\])]`)
+	aurOutOfDatePattern = regexp.MustCompile(`[\[(]Out-of-date: (\d{4}-\d{2}-\d{2})[\])]`)
+)
+
+// parseAURSearchFlags fills in the votes, popularity, orphan and out-of-date
+// state printed on an AUR helper's search result line
+func parseAURSearchFlags(line string, pkg *Package) {
+	if match := aurVotesPattern.FindStringSubmatch(line); match != nil {
+		pkg.Votes, _ = strconv.Atoi(match[1])
+		pkg.Popularity, _ = strconv.ParseFloat(match[2], 64)
+	}
+	if match := aurOutOfDatePattern.FindStringSubmatch(line); match != nil {
+		if flagged, err := time.Parse("2006-01-02", match[1]); err == nil {
+			pkg.OutOfDate = flagged.Unix()
+		}
+	}
+	pkg.Orphaned = strings.Contains(line, "[Orphaned]") || strings.Contains(line, "(Orphaned)")
+}
+
+func parseSearchOutput(output string) []Package {
+	var packages []Package
+	lines := strings.Split(output, "\n")
+
+	for i := 0; i < len(lines); i++ {
+		line := strings.TrimSpace(lines[i])
+		if line == "" {
+			continue
+		}
+
+		// Package lines look like: " 1 aur/yay 12.5.7-1 [+2480 ~39.12]"
+		// or without number: "aur/yay 12.5.7-1 [+2480 ~39.12]"
+		if strings.Contains(line, "/") && !strings.HasPrefix(line, " ") || (len(line) > 0 && line[0] >= '0' && line[0] <= '9') {
+			// Strip leading number if present (e.g., "1 aur/yay" -> "aur/yay")
+			fields := strings.Fields(line)
+			if len(fields) == 0 {
+				continue
+			}
+			
+			// Find the field containing "/" (source/name)
+			pkgField := ""
+			pkgFieldIdx := 0
+			for idx, f := range fields {
+				if strings.Contains(f, "/") {
+					pkgField = f
+					pkgFieldIdx = idx
+					break
+				}
+			}
+			if pkgField == "" {
+				continue
+			}
+
+			parts := strings.SplitN(pkgField, "/", 2)
+			if len(parts) < 2 {
+				continue
+			}
+
+			source := parts[0]
+			name := parts[1]
+
+			// Version is the next field after source/name
+			version := ""
+			if pkgFieldIdx+1 < len(fields) {
+				version = fields[pkgFieldIdx+1]
+			}
+
+			// Check if installed
+			installed := strings.Contains(line, "[Installed")
+
+			// Get description from next line
+			description := ""
+			if i+1 < len(lines) {
+				description = strings.TrimSpace(lines[i+1])
+				i++ // Skip description line
+			}
+
+			packages = append(packages, Package{
+				Source:      source,
+				Name:        name,
+				Version:     version,
+				Description: description,
+				Installed:   installed,
+			})
+		}
+	}
+
+	return packages
+}
+
+// debouncePackageInfo returns a command that waits for the debounce duration
+// then sends a tick message to trigger the actual fetch
+func (m model) debouncePackageInfo(pkgName string) tea.Cmd {
+	return tea.Tick(m.settings.InfoDebounce(), func(t time.Time) tea.Msg {
+		return debounceTickMsg{packageName: pkgName}
+	})
+}
+
+// PackageDetails is the parsed form of paru -Si output, or of AUR RPC info
+// rendered in the same layout
+type PackageDetails struct {
+	Repository    string
+	Name          string
+	Version       string
+	Description   string
+	URL           string
+	Licenses      []string
+	Provides      []string
+	Depends       []string
+	OptDepends    []string // "name: reason" entries
+	MakeDepends   []string
+	Conflicts     []string
+	RequiredBy    []string // Installed packages only (pacman -Qi)
+	InstalledSize string
+	DownloadSize  string
+	BuildDate     string
+	Packager      string // Packager for repo packages, Maintainer for AUR ones
+	Votes         string
+	Popularity    string
+	LastModified  string
+	OutOfDate     string
+}
+
+// setPackageInfo replaces the info panel text and parses it, so rendering and
+// key handling don't parse it again on every frame and key press
+func (m *model) setPackageInfo(info string) {
+	m.packageInfo = info
+	m.packageDetails, m.packageDetailsOK = parsePackageDetails(info)
+}
+
+// parsePackageDetails parses "Key : value" info output. Continuation lines
+// (as used by Optional Deps) add entries to the previous key. It returns false
+// when the text doesn't look like package info.
+func parsePackageDetails(raw string) (PackageDetails, bool) {
+	fields := make(map[string][]string)
+	var key string
+	for _, line := range strings.Split(raw, "\n") {
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+		if line[0] == ' ' || line[0] == '\t' {
+			if key != "" {
+				fields[key] = append(fields[key], strings.TrimSpace(line))
+			}
+			continue
+		}
+		k, v, ok := strings.Cut(line, " : ")
+		if !ok {
+			// "Key :" with an empty value
+			k, ok = strings.CutSuffix(strings.TrimRight(line, " "), " :")
+			if !ok {
+				continue
+			}
+		}
+		key = strings.TrimSpace(k)
+		if v = strings.TrimSpace(v); v != "" {
+			fields[key] = append(fields[key], v)
+		} else {
+			fields[key] = nil
+		}
+	}
+	if len(fields["Name"]) == 0 {
+		return PackageDetails{}, false
+	}
+
+	value := func(k string) string {
+		v := strings.Join(fields[k], " ")
+		if v == "None" {
+			return ""
+		}
+		return v
+	}
+	list := func(k string) []string {
+		var items []string
+		for _, v := range fields[k] {
+			if v != "None" {
+				items = append(items, strings.Fields(v)...)
+			}
+		}
+		return items
+	}
+	var optDepends []string
+	for _, v := range fields["Optional Deps"] {
+		if v != "None" {
+			optDepends = append(optDepends, v)
+		}
+	}
+	packager := value("Packager")
+	if packager == "" {
+		packager = value("Maintainer")
+	}
+
+	return PackageDetails{
+		Repository:    value("Repository"),
+		Name:          value("Name"),
+		Version:       value("Version"),
+		Description:   value("Description"),
+		URL:           value("URL"),
+		Licenses:      list("Licenses"),
+		Provides:      list("Provides"),
+		Depends:       list("Depends On"),
+		OptDepends:    optDepends,
+		MakeDepends:   list("Make Deps"),
+		Conflicts:     list("Conflicts With"),
+		RequiredBy:    list("Required By"),
+		InstalledSize: value("Installed Size"),
+		DownloadSize:  value("Download Size"),
+		BuildDate:     value("Build Date"),
+		Packager:      packager,
+		Votes:         value("Votes"),
+		Popularity:    value("Popularity"),
+		LastModified:  value("Last Modified"),
+		OutOfDate:     strings.TrimPrefix(value("Out Of Date"), "No"),
+	}, true
+}
+
+// dependencyTokens returns the names of the selectable dependencies in the
+// order renderPackageDetails shows them
+func (d PackageDetails) dependencyTokens() []string {
+	var tokens []string
+	for _, dep := range d.Depends {
+		tokens = append(tokens, dependencyName(dep))
+	}
+	for _, dep := range d.MakeDepends {
+		tokens = append(tokens, dependencyName(dep))
+	}
+	for _, opt := range d.OptDepends {
+		name, _, _ := strings.Cut(opt, ":")
+		tokens = append(tokens, dependencyName(strings.TrimSpace(name)))
+	}
+	return tokens
+}
+
+// renderPackageDetails renders package details as aligned, themed key/value
+// rows within width, cut to height lines. While the info panel is focused the
+// dependency under the cursor is highlighted and kept in view.
+func (m model) renderPackageDetails(d PackageDetails, width, height int) string {
+	const labelWidth = 14
+	valueWidth := width - labelWidth - 1
+	if valueWidth < 10 {
+		valueWidth = 10
+	}
+
+	labelStyle := lipgloss.NewStyle().
+		Foreground(currentTheme.DashboardLabel).
+		Bold(true).
+		Width(labelWidth)
+	valueStyle := lipgloss.NewStyle().
+		Foreground(currentTheme.TextColor)
+	urlStyle := lipgloss.NewStyle().
+		Foreground(currentTheme.HighlightColor).
+		Underline(true)
+	depStyle := lipgloss.NewStyle().
+		Foreground(currentTheme.SelectedColor)
+	subtleStyle := lipgloss.NewStyle().
+		Foreground(currentTheme.SubtleColor)
+	warnStyle := lipgloss.NewStyle().
+		Foreground(currentTheme.WarningColor)
+	cursorStyle := depStyle.Reverse(true)
+
+	cursor := -1
+	if m.infoFocused {
+		cursor = m.infoCursor
+	}
+	var lines []string
+	token, cursorLine := 0, 0
+	// dep styles the next dependency token, marking the cursor and virtual packages
+	dep := func(text string) string {
+		name := dependencyName(text)
+		styled := depStyle.Render(text)
+		if token == cursor {
+			styled = cursorStyle.Render(text)
+			cursorLine = len(lines)
+		}
+		if m.infoFocused && m.isVirtualPackage(name) {
+			styled += subtleStyle.Render(" (virtual)")
+		}
+		token++
+		return styled
+	}
+
+	// row wraps plain text to the value column and styles each wrapped line
+	row := func(label, text string, style lipgloss.Style) {
+		if text == "" {
+			return
+		}
+		wrapped := strings.Split(lipgloss.NewStyle().Width(valueWidth).Render(text), "\n")
+		for i, part := range wrapped {
+			l := ""
+			if i == 0 {
+				l = label
+			}
+			lines = append(lines, labelStyle.Render(l)+" "+style.Render(strings.TrimRight(part, " ")))
+		}
+	}
+	// names wraps a list of package names, keeping each name whole.
+	// Dependency lists are selectable.
+	names := func(label string, items []string, isDeps bool) {
+		if len(items) == 0 {
+			return
+		}
+		wrapped := strings.Split(lipgloss.NewStyle().Width(valueWidth).Render(strings.Join(items, "  ")), "\n")
+		for i, part := range wrapped {
+			l := ""
+			if i == 0 {
+				l = label
+			}
+			var styled []string
+			for _, name := range strings.Fields(part) {
+				if isDeps {
+					styled = append(styled, dep(name))
+				} else {
+					styled = append(styled, depStyle.Render(name))
+				}
+			}
+			lines = append(lines, labelStyle.Render(l)+" "+strings.Join(styled, "  "))
+		}
+	}
+
+	header := lipgloss.NewStyle().Bold(true).Foreground(currentTheme.TitleColor).Render(d.Name)
+	if d.Version != "" {
+		header += " " + subtleStyle.Render(d.Version)
+	}
+	if d.Repository != "" {
+		header += " " + subtleStyle.Render("("+d.Repository+")")
+	}
+	lines = append(lines, header)
+	row("Description", d.Description, valueStyle)
+	row("URL", d.URL, urlStyle)
+	row("Licenses", strings.Join(d.Licenses, ", "), valueStyle)
+	names("Depends", d.Depends, true)
+	names("Make Deps", d.MakeDepends, true)
+	for i, opt := range d.OptDepends {
+		label := ""
+		if i == 0 {
+			label = "Optional"
+		}
+		name, reason, _ := strings.Cut(opt, ":")
+		text := dep(name)
+		if reason = strings.TrimSpace(reason); reason != "" {
+			text += subtleStyle.Render(": " + truncateRunes(reason, valueWidth-lipgloss.Width(name)-2))
+		}
+		lines = append(lines, labelStyle.Render(label)+" "+text)
+	}
+	names("Provides", d.Provides, false)
+	names("Conflicts", d.Conflicts, false)
+	names("Required By", d.RequiredBy, false)
+	row("Installed", d.InstalledSize, valueStyle)
+	row("Download", d.DownloadSize, valueStyle)
+	row("Built", d.BuildDate, valueStyle)
+	row("Packager", d.Packager, valueStyle)
+	if d.Votes != "" {
+		row("Votes", d.Votes+"  (popularity "+d.Popularity+")", valueStyle)
+	}
+	row("Modified", d.LastModified, valueStyle)
+	row("Out of date", strings.TrimSpace(d.OutOfDate), warnStyle)
+
+	// Scroll so the cursor stays above the "more" line, or as far as the
+	// mouse wheel scrolled the panel
+	if height > 1 && cursorLine >= height-1 {
+		lines = lines[cursorLine-height+2:]
+	} else if !m.infoFocused && m.infoScroll > 0 && height > 1 {
+		lines = lines[min(m.infoScroll, max(len(lines)-height+1, 0)):]
+	}
+	if height > 0 && len(lines) > height {
+		lines = append(lines[:height-1], subtleStyle.Render(fmt.Sprintf("… %d more line(s)", len(lines)-height+1)))
+	}
+	return strings.Join(lines, "\n")
+}
+
+// depTreeLine is one package in a pactree listing
+type depTreeLine struct {
+	name        string
+	provides    string // Dependency this package satisfies under another name
+	depth       int
+	hasChildren bool
+	cycle       bool // Already an ancestor; pactree doesn't descend into it
+	truncated   bool // Dependencies below were cut at the depth limit
+}
+
+// depTreeView is the dependency tree shown in place of the package info
+type depTreeView struct {
+	root      string
+	reverse   bool // Reverse dependencies (what requires root)
+	loading   bool
+	err       error
+	lines     []depTreeLine
+	collapsed map[int]bool // Indices of collapsed lines
+	cursor    int          // Position within visibleLines
+}
+
+type depTreeMsg struct {
+	root    string
+	reverse bool
+	lines   []depTreeLine
+	err     error
+}
+
+// loadDepTree runs pactree for pkg. Packages that aren't installed are looked
+// up in the sync databases. One level beyond maxDepth is requested so cut
+// branches can be marked.
+func loadDepTree(r Runner, pkg string, reverse, installed bool, maxDepth int) tea.Cmd {
+	return func() tea.Msg {
+		args := []string{"-d", strconv.Itoa(maxDepth + 1)}
+		if reverse {
+			args = append(args, "-r")
+		}
+		if !installed {
+			args = append(args, "-s")
+		}
+		out, stderr, err := r.Run("pactree", append(args, pkg)...)
+		if err != nil {
+			if msg := strings.TrimSpace(stderr); msg != "" {
+				err = fmt.Errorf("%s", msg)
+			}
+			return depTreeMsg{root: pkg, reverse: reverse, err: err}
+		}
+		return depTreeMsg{root: pkg, reverse: reverse, lines: parsePactree(out, maxDepth)}
+	}
+}
+
+// parsePactree parses pactree's drawn tree. Every level adds two runes of
+// "│ ├─└" prefix. Lines deeper than maxDepth are dropped and their parent is
+// marked truncated; a package repeating one of its ancestors is marked as a cycle.
+func parsePactree(out string, maxDepth int) []depTreeLine {
+	var lines []depTreeLine
+	var ancestors []string
+	for _, raw := range strings.Split(out, "\n") {
+		text := strings.TrimLeft(raw, "│├└─|`- ")
+		if text == "" {
+			continue
+		}
+		depth := utf8.RuneCountInString(raw[:len(raw)-len(text)]) / 2
+		name, provides, _ := strings.Cut(text, " provides ")
+		name = strings.TrimSpace(name)
+
+		if depth > maxDepth {
+			for i := len(lines) - 1; i >= 0; i-- {
+				if lines[i].depth < depth {
+					lines[i].truncated = true
+					break
+				}
+			}
+			continue
+		}
+		if depth < len(ancestors) {
+			ancestors = ancestors[:depth]
+		}
+		line := depTreeLine{name: name, provides: provides, depth: depth}
+		for _, a := range ancestors {
+			if a == name {
+				line.cycle = true
+				break
+			}
+		}
+		ancestors = append(ancestors, name)
+		lines = append(lines, line)
+	}
+	for i := 0; i+1 < len(lines); i++ {
+		lines[i].hasChildren = lines[i+1].depth > lines[i].depth
+	}
+	return lines
+}
+
+// visibleLines returns the indices of lines not hidden by a collapsed ancestor
+func (t depTreeView) visibleLines() []int {
+	var visible []int
+	hideBelow := -1
+	for i, line := range t.lines {
+		if hideBelow >= 0 {
+			if line.depth > hideBelow {
+				continue
+			}
+			hideBelow = -1
+		}
+		visible = append(visible, i)
+		if t.collapsed[i] {
+			hideBelow = line.depth
+		}
+	}
+	return visible
+}
+
+// packageSource returns the repository a package name is known from, or ""
+func (m model) packageSource(name string) string {
+	if idx := m.repoIndex[name]; len(idx) > 0 {
+		return m.repoPackages[idx[0]].Source
+	}
+	if _, ok := m.aurInfoCache[name]; ok {
+		return "aur"
+	}
+	return ""
+}
+
+// toggleDepTree shows the dependency tree of the selected package, or returns
+// to the package info. Remove mode shows reverse dependencies.
+func (m *model) toggleDepTree() tea.Cmd {
+	if m.showDepTree {
+		m.showDepTree = false
+		m.depTree = depTreeView{}
+		return nil
+	}
+	var pkg Package
+	switch {
+	case m.mode == modeInstall && m.selectedIndex < len(m.filtered):
+		pkg = m.filtered[m.selectedIndex]
+	case m.mode == modeUninstall && m.selectedIndex < len(m.filteredInstalled):
+		pkg = m.filteredInstalled[m.selectedIndex]
+	default:
+		return nil
+	}
+	if pkg.Source == "set" || pkg.Source == "group" {
+		return nil
+	}
+	reverse := m.mode == modeUninstall
+	m.showDepTree = true
+	m.depTree = depTreeView{root: pkg.Name, reverse: reverse, loading: true, collapsed: make(map[int]bool)}
+	return loadDepTree(m.runner, pkg.Name, reverse, m.installedSet[pkg.Name], m.settings.DepTreeDepth)
+}
+
+// fileEntry is one path owned by a package
+type fileEntry struct {
+	path  string
+	isDir bool
+	size  int64 // Size on disk; 0 for directories and missing files
+}
+
+// fileListView is the file list of an installed package, shown in place of
+// the package info and filtered by the text input
+type fileListView struct {
+	pkg        string
+	loading    bool
+	err        error
+	entries    []fileEntry
+	totalSize  int64
+	offset     int
+	savedQuery string // Remove mode filter to restore on close
+}
+
+type fileListMsg struct {
+	pkg       string
+	entries   []fileEntry
+	totalSize int64
+	err       error
+}
+
+// loadFileList lists the files of an installed package with pacman -Ql and
+// stats them for their size
+func loadFileList(r Runner, pkg string) tea.Cmd {
+	return func() tea.Msg {
+		out, _, err := r.Run("pacman", "-Qlq", pkg)
+		if err != nil {
+			return fileListMsg{pkg: pkg, err: err}
+		}
+		var entries []fileEntry
+		var total int64
+		for _, path := range strings.Split(strings.TrimSpace(string(out)), "\n") {
+			if path == "" {
+				continue
+			}
+			entry := fileEntry{path: path, isDir: strings.HasSuffix(path, "/")}
+			if !entry.isDir {
+				if info, err := os.Lstat(path); err == nil {
+					entry.size = info.Size()
+					total += entry.size
+				}
+			}
+			entries = append(entries, entry)
+		}
+		return fileListMsg{pkg: pkg, entries: entries, totalSize: total}
+	}
+}
+
+// filteredFiles returns the entries whose path contains the text input,
+// ignoring case
+func (m model) filteredFiles() []fileEntry {
+	query := strings.ToLower(strings.TrimSpace(m.textInput.Value()))
+	if query == "" {
+		return m.fileList.entries
+	}
+	var matched []fileEntry
+	for _, entry := range m.fileList.entries {
+		if strings.Contains(strings.ToLower(entry.path), query) {
+			matched = append(matched, entry)
+		}
+	}
+	return matched
+}
+
+// openFileList shows the files of the selected installed package, taking over
+// the text input as the file filter
+func (m *model) openFileList() tea.Cmd {
+	if m.selectedIndex >= len(m.filteredInstalled) {
+		return nil
+	}
+	pkg := m.filteredInstalled[m.selectedIndex].Name
+	m.showFileList = true
+	m.fileList = fileListView{pkg: pkg, loading: true, savedQuery: m.textInput.Value()}
+	m.textInput.SetValue("")
+	m.textInput.Placeholder = "Filter files of " + pkg + "..."
+	m.textInput.Focus()
+	m.statusMessage = "Files of " + pkg + ": type to filter  [↑↓] scroll  [esc] close"
+	return loadFileList(m.runner, pkg)
+}
+
+// handleFileListKeys scrolls the file list and feeds everything else to the
+// filter input
+func (m model) handleFileListKeys(msg tea.KeyMsg) (tea.Model, tea.Cmd) {
+	f := &m.fileList
+	page := m.height/2 - 4
+	if page < 1 {
+		page = 1
+	}
+	switch msg.String() {
+	case "esc":
+		m.showFileList = false
+		m.textInput.SetValue(f.savedQuery)
+		m.textInput.Placeholder = "Filter (t: total  e: explicit  f: foreign  o: orphan)..."
+		m.textInput.Blur()
+		m.fileList = fileListView{}
+		m.statusMessage = fmt.Sprintf("%d installed packages", len(m.installed))
+		return m, nil
+	case "ctrl+c":
+		return m, tea.Quit
+	case "down":
+		f.offset++
+	case "up":
+		f.offset--
+	case "pgdown":
+		f.offset += page
+	case "pgup":
+		f.offset -= page
+	default:
+		var cmd tea.Cmd
+		m.textInput, cmd = m.textInput.Update(msg)
+		f.offset = 0
+		return m, cmd
+	}
+	if max := len(m.filteredFiles()) - 1; f.offset > max {
+		f.offset = max
+	}
+	if f.offset < 0 {
+		f.offset = 0
+	}
+	return m, nil
+}
+
+// renderFileList renders the filtered file list from the scroll offset with
+// directories dimmed and a count and size footer
+func (m model) renderFileList(width, height int) string {
+	f := m.fileList
+	titleStyle := lipgloss.NewStyle().Bold(true).Foreground(currentTheme.TitleColor)
+	subtleStyle := lipgloss.NewStyle().Foreground(currentTheme.SubtleColor)
+	fileStyle := lipgloss.NewStyle().Foreground(currentTheme.TextColor)
+
+	header := titleStyle.Render("Files of " + f.pkg)
+	switch {
+	case f.loading:
+		return header + "\n" + subtleStyle.Render("Running pacman -Ql...")
+	case f.err != nil:
+		return header + "\n" + lipgloss.NewStyle().Foreground(currentTheme.WarningColor).Render(fmt.Sprintf("pacman -Ql failed: %v", f.err))
+	}
+
+	files := m.filteredFiles()
+	fileCount := 0
+	for _, entry := range f.entries {
+		if !entry.isDir {
+			fileCount++
+		}
+	}
+	footer := fmt.Sprintf("%d files, %s", fileCount, formatBytes(f.totalSize))
+	if len(files) != len(f.entries) {
+		footer = fmt.Sprintf("%d of %d entries match · %s", len(files), len(f.entries), footer)
+	}
+
+	visible := height - 2
+	if visible < 1 {
+		visible = 1
+	}
+	end := f.offset + visible
+	if end > len(files) {
+		end = len(files)
+	}
+	var rows []string
+	for _, entry := range files[f.offset:end] {
+		path := truncateRunes(entry.path, width)
+		if entry.isDir {
+			rows = append(rows, subtleStyle.Render(path))
+		} else {
+			rows = append(rows, fileStyle.Render(path))
+		}
+	}
+	for len(rows) < visible {
+		rows = append(rows, "")
+	}
+	return header + "\n" + strings.Join(rows, "\n") + "\n" + subtleStyle.Render(footer)
+}
+
+// resolvePackage finds a package by name in the repo list, the installed
+// packages or the AUR info cache
+func (m model) resolvePackage(name string) (Package, bool) {
+	if idx := m.repoIndex[name]; len(idx) > 0 {
+		return m.repoPackages[idx[0]], true
+	}
+	for _, pkg := range m.installed {
+		if pkg.Name == name {
+			return pkg, true
+		}
+	}
+	if info := m.aurInfoCache[name]; info != nil {
+		return info.toPackage(), true
+	}
+	return Package{}, false
+}
+
+// isVirtualPackage reports whether a dependency names no package of its own,
+// i.e. it is only provided by others. Dependencies of AUR packages may be AUR
+// packages that haven't been looked up, so they are never reported virtual.
+// It runs for every dependency on every render, so it only does map lookups.
+func (m model) isVirtualPackage(name string) bool {
+	if len(m.repoIndex[name]) > 0 || m.installedSet[name] || m.aurInfoCache[name] != nil {
+		return false
+	}
+	if m.installedProvides[name] {
+		return true
+	}
+	root := m.infoForPackage
+	if len(m.repoIndex[root]) == 0 && (m.installedSet[root] || m.aurInfoCache[root] != nil) {
+		return false
+	}
+	return true
+}
+
+// showPackageInfo makes pkg the package shown in the info panel, selecting it
+// in the results list when it is listed there
+func (m *model) showPackageInfo(pkg Package) tea.Cmd {
+	list := m.filtered
+	if m.mode == modeUninstall {
+		list = m.filteredInstalled
+	}
+	for i := range list {
+		if list[i].Name == pkg.Name {
+			m.selectedIndex = i
+			break
+		}
+	}
+	m.infoForPackage = pkg.Name
+	m.pendingInfoPackage = pkg.Name
+	m.loadingInfo = true
+	m.infoCursor = 0
+	m.statusMessage = "Info: " + strings.Join(append(append([]string{}, m.infoBackStack...), pkg.Name), " → ") +
+		"  [enter] follow  [backspace] back  [esc] close"
+	return m.packageInfoCmd(pkg)
+}
+
+// handleInfoPanelKeys moves the dependency cursor of the focused info panel
+// and follows dependencies, keeping a back-stack of the packages left behind
+func (m model) handleInfoPanelKeys(msg tea.KeyMsg) (tea.Model, tea.Cmd) {
+	tokens := m.packageDetails.dependencyTokens()
+	switch msg.String() {
+	case "shift+tab", "esc":
+		m.infoFocused = false
+		m.infoBackStack = nil
+		m.statusMessage = ""
+		return m, nil
+	case "ctrl+c":
+		return m, tea.Quit
+	case "down", "j":
+		m.infoCursor++
+	case "up", "k":
+		m.infoCursor--
+	case "enter":
+		if m.loadingInfo || m.infoCursor >= len(tokens) {
+			return m, nil
+		}
+		name := tokens[m.infoCursor]
+		if m.isVirtualPackage(name) {
+			m.statusMessage = fmt.Sprintf("%s is virtual — it is provided by other packages", name)
+			return m, nil
+		}
+		pkg, ok := m.resolvePackage(name)
+		if !ok {
+			pkg = Package{Name: name, Source: "aur"}
+		}
+		m.infoBackStack = append(m.infoBackStack, m.infoForPackage)
+		cmd := m.showPackageInfo(pkg)
+		return m, cmd
+	case "backspace":
+		n := len(m.infoBackStack)
+		if n == 0 {
+			return m, nil
+		}
+		name := m.infoBackStack[n-1]
+		m.infoBackStack = m.infoBackStack[:n-1]
+		pkg, ok := m.resolvePackage(name)
+		if !ok {
+			pkg = Package{Name: name, Source: "aur"}
+		}
+		cmd := m.showPackageInfo(pkg)
+		return m, cmd
+	}
+	if m.infoCursor >= len(tokens) {
+		m.infoCursor = len(tokens) - 1
+	}
+	if m.infoCursor < 0 {
+		m.infoCursor = 0
+	}
+	return m, nil
+}
+
+// handleDepTreeKeys moves through and folds the dependency tree
+func (m model) handleDepTreeKeys(msg tea.KeyMsg) (tea.Model, tea.Cmd) {
+	t := &m.depTree
+	visible := t.visibleLines()
+	switch msg.String() {
+	case "d", "esc", "q":
+		cmd := m.toggleDepTree()
+		return m, cmd
+	case "ctrl+c":
+		return m, tea.Quit
+	case "down", "j":
+		t.cursor++
+	case "up", "k":
+		t.cursor--
+	case "g", "home":
+		t.cursor = 0
+	case "G", "end":
+		t.cursor = len(visible) - 1
+	case "enter", " ", "tab":
+		if t.cursor < len(visible) {
+			i := visible[t.cursor]
+			if t.lines[i].hasChildren {
+				t.collapsed[i] = !t.collapsed[i]
+			}
+		}
+	}
+	if n := len(t.visibleLines()); t.cursor >= n {
+		t.cursor = n - 1
+	}
+	if t.cursor < 0 {
+		t.cursor = 0
+	}
+	return m, nil
+}
+
+// renderDepTree renders the visible part of the dependency tree around the cursor
+func (m model) renderDepTree(width, height int) string {
+	t := m.depTree
+	titleStyle := lipgloss.NewStyle().Bold(true).Foreground(currentTheme.TitleColor)
+	subtleStyle := lipgloss.NewStyle().Foreground(currentTheme.SubtleColor)
+	warnStyle := lipgloss.NewStyle().Foreground(currentTheme.WarningColor)
+
+	title := "Dependencies of " + t.root
+	if t.reverse {
+		title = "Packages requiring " + t.root
+	}
+	header := titleStyle.Render(title) + subtleStyle.Render("  [enter] fold  [d] back")
+	switch {
+	case t.loading:
+		return header + "\n" + subtleStyle.Render("Running pactree...")
+	case t.err != nil:
+		return header + "\n" + warnStyle.Render(fmt.Sprintf("pactree failed: %v", t.err))
+	case len(t.lines) <= 1 && t.reverse:
+		return header + "\n" + subtleStyle.Render("No installed package requires "+t.root)
+	}
+
+	var rows []string
+	cursorRow := 0
+	for pos, i := range t.visibleLines() {
+		line := t.lines[i]
+		marker := "  "
+		if line.hasChildren {
+			marker = "▾ "
+			if t.collapsed[i] {
+				marker = "▸ "
+			}
+		}
+		name := line.name
+		if color, ok := sourceColors[m.packageSource(line.name)]; ok {
+			name = lipgloss.NewStyle().Foreground(color).Render(name)
+		}
+		row := strings.Repeat("  ", line.depth) + marker + name
+		if line.provides != "" {
+			row += subtleStyle.Render(" (provides " + line.provides + ")")
+		}
+		if m.installedSet[line.name] {
+			row += " " + lipgloss.NewStyle().Foreground(currentTheme.InstalledColor).Render("✓")
+		} else {
+			row += " " + subtleStyle.Render("not installed")
+		}
+		if line.cycle {
+			row += warnStyle.Render(" ↻ cycle")
+		}
+		if pos == t.cursor {
+			cursorRow = len(rows)
+			row = lipgloss.NewStyle().Foreground(currentTheme.SelectedColor).Render("> ") + row
+		} else {
+			row = "  " + row
+		}
+		rows = append(rows, row)
+		if line.truncated && !t.collapsed[i] {
+			rows = append(rows, "  "+strings.Repeat("  ", line.depth+1)+subtleStyle.Render("  …"))
+		}
+	}
+
+	visible := height - 1
+	if visible < 1 {
+		visible = 1
+	}
+	start := 0
+	if cursorRow >= visible {
+		start = cursorRow - visible + 1
+	}
+	end := start + visible
+	if end > len(rows) {
+		end = len(rows)
+	}
+	for i := start; i < end; i++ {
+		rows[i] = lipgloss.NewStyle().MaxWidth(width).Render(rows[i])
+	}
+	return header + "\n" + strings.Join(rows[start:end], "\n")
+}
+
+// getPackageInfo loads the info panel text of pkg. The C locale output is
+// what's parsed; outside English locales display runs the command again for
+// the text shown as is.
+func getPackageInfo(r, display Runner, pkg Package) tea.Cmd {
+	return func() tea.Msg {
+		// Package sets and groups carry their member list instead of repository info
+		if pkg.Source == "set" {
+			return packageInfoMsg{info: fmt.Sprintf("Package set: %s\n\n%s\n\nPress [enter] to install missing members", pkg.Name, pkg.Description), packageName: pkg.Name}
+		}
+		if pkg.Source == "group" {
+			return packageInfoMsg{info: fmt.Sprintf("Package group: %s\n\n%s\n\nPress [enter] to install the group", pkg.Name, pkg.Description), packageName: pkg.Name}
+		}
+
+		// Validate package name to prevent command injection
+		if !isValidPackageName(pkg.Name) {
+			return packageInfoMsg{info: "Invalid package name", packageName: pkg.Name, err: fmt.Errorf("invalid package name: %s", pkg.Name)}
+		}
+
+		out, err := r.Combined(helper.name, "-Si", pkg.Name)
+		if err != nil {
+			return packageInfoMsg{info: "Failed to get package info", packageName: pkg.Name, err: err}
+		}
+
+		msg := packageInfoMsg{info: out, packageName: pkg.Name}
+		if !localeIsEnglish() {
+			if localized, err := display.Combined(helper.name, "-Si", pkg.Name); err == nil {
+				msg.localized = localized
+			}
+		}
+		return msg
+	}
+}
+
+func getInstalledPackages(r Runner) tea.Cmd {
+	return func() tea.Msg {
+		// Read the local database directly; pacman -Qi is the fallback
+		if local, err := readLocalDB(filepath.Join(pacmanDBDir(), "local")); err == nil {
+			packages := make([]Package, len(local))
+			provided := make(map[string]bool)
+			for i, lp := range local {
+				packages[i] = lp.Package
+				for _, name := range lp.provides {
+					provided[name] = true
+				}
+			}
+			if repoMap, err := syncRepoMap(r); err == nil {
+				for i := range packages {
+					// Packages missing from every sync database are foreign, as with pacman -Qm
+					packages[i].Source = "aur"
+					if repo, ok := repoMap[packages[i].Name]; ok {
+						packages[i].Source = repo
+					}
+				}
+			}
+			return installedPackagesMsg{packages: packages, provided: provided}
+		}
+
+		// Use pacman -Qi to get all installed package info including repository
+		out, _, err := r.Run("pacman", "-Qi")
+		if err != nil {
+			return installedPackagesMsg{err: err}
+		}
+
+		packages, provided := parseInstalledPackages(r, out)
+		return installedPackagesMsg{packages: packages, provided: provided}
+	}
+}
+
+// parseInstalledPackages parses pacman -Qi output into packages, with their
+// installed sizes, and the names they provide
+func parseInstalledPackages(r Runner, output string) ([]Package, map[string]bool) {
+	var packages []Package
+	provided := make(map[string]bool)
+	blocks := strings.Split(output, "\n\n")
+
+	for _, block := range blocks {
+		if strings.TrimSpace(block) == "" {
+			continue
+		}
+
+		var pkg Package
+		pkg.Installed = true
+		pkg.Source = "local" // default
+
+		lines := strings.Split(block, "\n")
+		for _, line := range lines {
+			if strings.HasPrefix(line, "Name") {
+				parts := strings.SplitN(line, ":", 2)
+				if len(parts) == 2 {
+					pkg.Name = strings.TrimSpace(parts[1])
+				}
+			} else if strings.HasPrefix(line, "Version") {
+				parts := strings.SplitN(line, ":", 2)
+				if len(parts) == 2 {
+					pkg.Version = strings.TrimSpace(parts[1])
+				}
+			} else if strings.HasPrefix(line, "Description") {
+				parts := strings.SplitN(line, ":", 2)
+				if len(parts) == 2 {
+					pkg.Description = strings.TrimSpace(parts[1])
+				}
+			} else if strings.HasPrefix(line, "Install Date") {
+				parts := strings.SplitN(line, ":", 2)
+				if len(parts) == 2 {
+					pkg.InstallDate, _ = parsePacmanDate(parts[1])
+				}
+			} else if strings.HasPrefix(line, "Installed Size") {
+				parts := strings.SplitN(line, ":", 2)
+				if len(parts) == 2 {
+					pkg.SizeBytes = parseSizeToBytes(parts[1])
+				}
+			} else if strings.HasPrefix(line, "Provides") {
+				parts := strings.SplitN(line, ":", 2)
+				if len(parts) == 2 && strings.TrimSpace(parts[1]) != "None" {
+					for _, prov := range strings.Fields(parts[1]) {
+						provided[depName(prov)] = true
+					}
+				}
+			}
+		}
+
+		if pkg.Name != "" {
+			packages = append(packages, pkg)
+		}
+	}
+
+	// Apply actual repository to installed packages
+	repoMap, _ := syncRepoMap(r)
+	for i := range packages {
+		if repo, ok := repoMap[packages[i].Name]; ok {
+			packages[i].Source = repo
+		}
+	}
+
+	// Get foreign packages (AUR) to mark them
+	if foreignOut, _, err := r.Run("pacman", "-Qm"); err == nil {
+		foreignPkgs := make(map[string]bool)
+		for _, line := range strings.Split(foreignOut, "\n") {
+			parts := strings.Fields(line)
+			if len(parts) >= 1 {
+				foreignPkgs[parts[0]] = true
+			}
+		}
+		for i := range packages {
+			if foreignPkgs[packages[i].Name] {
+				packages[i].Source = "aur"
+			}
+		}
+	}
+
+	// Get explicitly installed packages
+	if explicitOut, _, err := r.Run("pacman", "-Qe"); err == nil {
+		explicitPkgs := make(map[string]bool)
+		for _, line := range strings.Split(explicitOut, "\n") {
+			parts := strings.Fields(line)
+			if len(parts) >= 1 {
+				explicitPkgs[parts[0]] = true
+			}
+		}
+		for i := range packages {
+			packages[i].Explicit = explicitPkgs[packages[i].Name]
+		}
+	}
+
+	// Get orphan packages
+	if orphanOut, _, err := r.Run("pacman", "-Qdt"); err == nil {
+		orphanPkgs := make(map[string]bool)
+		for _, line := range strings.Split(orphanOut, "\n") {
+			parts := strings.Fields(line)
+			if len(parts) >= 1 {
+				orphanPkgs[parts[0]] = true
+			}
+		}
+		for i := range packages {
+			packages[i].Orphan = orphanPkgs[packages[i].Name]
+		}
+	}
+
+	return packages, provided
+}
+
+// pacmanDateLayouts are the dates pacman -Qi prints in the C locale, with
+// runs of spaces collapsed, and in the formats of older pacman releases
+var pacmanDateLayouts = []string{
+	"Mon Jan 2 15:04:05 2006",
+	"Mon 02 Jan 2006 03:04:05 PM MST",
+	"Mon 02 Jan 2006 15:04:05 MST",
+}
+
+// parsePacmanDate parses a date field of pacman -Qi as local time
+func parsePacmanDate(value string) (time.Time, bool) {
+	value = strings.Join(strings.Fields(value), " ")
+	for _, layout := range pacmanDateLayouts {
+		if t, err := time.ParseInLocation(layout, value, time.Local); err == nil {
+			return t, true
+		}
+	}
+	return time.Time{}, false
+}
+
+// syncRepoMap maps every sync database package to its repository from
+// pacman -Sl. This gives the actual repo (core, extra, multilib) of
+// installed packages.
+func syncRepoMap(r Runner) (map[string]string, error) {
+	repoOut, _, err := r.Run("pacman", "-Sl")
+	if err != nil {
+		return nil, err
+	}
+	return parseRepoMap(repoOut), nil
+}
+
+// parseRepoMap maps package names to their repository in pacman -Sl output.
+// A package in several repositories maps to the first one listed, which is
+// the one pacman installs from.
+func parseRepoMap(output string) map[string]string {
+	repoMap := make(map[string]string)
+	for _, line := range strings.Split(output, "\n") {
+		parts := strings.Fields(line)
+		if len(parts) >= 2 {
+			// Format: "repo name version [installed]"
+			if _, ok := repoMap[parts[1]]; !ok {
+				repoMap[parts[1]] = parts[0]
+			}
+		}
+	}
+	return repoMap
+}
+
+// localPackage is an installed package read from pacman's local database
+type localPackage struct {
+	Package
+	provides []string // Provided names, without versions
+}
+
+// readLocalDB reads every package of pacman's local database from the desc
+// files under dir, much faster than parsing pacman -Qi. Explicit and Orphan
+// follow pacman -Qe and -Qdt: an orphan is a dependency that no installed
+// package requires or optionally requires. Packages are sorted by name.
+func readLocalDB(dir string) ([]localPackage, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	var packages []localPackage
+	required := make(map[string]bool)     // Names depended on, by name or provision
+	provides := make(map[string][]string) // Provided name -> providing packages
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+		data, err := os.ReadFile(filepath.Join(dir, entry.Name(), "desc"))
+		if err != nil {
+			continue // ALPM_DB_VERSION and other non-package entries
+		}
+		fields := parseDescFile(string(data))
+		name := descField(fields, "NAME")
+		if name == "" {
+			continue
+		}
+		lp := localPackage{Package: Package{
+			Source:      "local",
+			Name:        name,
+			Version:     descField(fields, "VERSION"),
+			Description: descField(fields, "DESC"),
+			Installed:   true,
+			Explicit:    descField(fields, "REASON") != "1",
+		}}
+		if installed, err := strconv.ParseInt(descField(fields, "INSTALLDATE"), 10, 64); err == nil {
+			lp.InstallDate = time.Unix(installed, 0)
+		}
+		lp.SizeBytes, _ = strconv.ParseInt(descField(fields, "SIZE"), 10, 64)
+		for _, prov := range fields["PROVIDES"] {
+			lp.provides = append(lp.provides, depName(prov))
+		}
+		packages = append(packages, lp)
+
+		for _, dep := range fields["DEPENDS"] {
+			required[depName(dep)] = true
+		}
+		for _, dep := range fields["OPTDEPENDS"] {
+			optName, _, _ := strings.Cut(dep, ":")
+			required[depName(optName)] = true
+		}
+		for _, prov := range fields["PROVIDES"] {
+			provides[depName(prov)] = append(provides[depName(prov)], name)
+		}
+	}
+
+	// A dependency on a provision requires its providers
+	for provided, providers := range provides {
+		if required[provided] {
+			for _, name := range providers {
+				required[name] = true
+			}
+		}
+	}
+	for i := range packages {
+		packages[i].Orphan = !packages[i].Explicit && !required[packages[i].Name]
+	}
+	sort.Slice(packages, func(i, j int) bool { return packages[i].Name < packages[j].Name })
+	return packages, nil
+}
+
+// parseDescFile splits a pacman database desc file into its %FIELD% sections
+func parseDescFile(data string) map[string][]string {
+	fields := make(map[string][]string)
+	field := ""
+	for _, line := range strings.Split(data, "\n") {
+		switch {
+		case len(line) > 2 && strings.HasPrefix(line, "%") && strings.HasSuffix(line, "%"):
+			field = line[1 : len(line)-1]
+		case line == "":
+			field = ""
+		case field != "":
+			fields[field] = append(fields[field], line)
+		}
+	}
+	return fields
+}
+
+// descField returns the first value of a desc file field
+func descField(fields map[string][]string, name string) string {
+	if values := fields[name]; len(values) > 0 {
+		return values[0]
+	}
+	return ""
+}
+
+// depName strips the version constraint from a dependency or provision
+func depName(dep string) string {
+	if i := strings.IndexAny(dep, "<>="); i >= 0 {
+		dep = dep[:i]
+	}
+	return strings.TrimSpace(dep)
+}
+
+func getDashboardData(r Runner, cacheDirs []CacheDir) tea.Cmd {
+	return func() tea.Msg {
+		data, err := collectDashboardData(r, cacheDirs)
+		return dashboardMsg{data: data, err: err}
+	}
+}
+
+// collectDashboardData gathers the dashboard's statistics. It fails only
+// when the installed packages can't be read at all.
+func collectDashboardData(r Runner, cacheDirs []CacheDir) (DashboardData, error) {
+	var data DashboardData
+
+	// Totals, explicit installs and orphans come from the local database,
+	// or paru -Q, -Qe and -Qdt when it can't be read
+	local, localErr := readLocalDB(filepath.Join(pacmanDBDir(), "local"))
+	if localErr == nil {
+		data.TotalPackages = len(local)
+		for _, lp := range local {
+			if lp.Explicit {
+				data.ExplicitlyInstalled++
+			}
+			if lp.Orphan {
+				data.Orphans++
+			}
+		}
+	} else {
+		out, _, err := r.Run(helper.name, "-Q")
+		if err != nil {
+			return data, fmt.Errorf("reading installed packages: %v", localErr)
+		}
+		data.TotalPackages = countLines(out)
+		if out, _, err := r.Run(helper.name, "-Qe"); err == nil {
+			data.ExplicitlyInstalled = countLines(out)
+		}
+		if out, _, err := r.Run(helper.name, "-Qdt"); err == nil {
+			data.Orphans = countLines(out)
+		}
+	}
+
+	// Foreign Packages: paru -Qm
+	if out, _, err := r.Run(helper.name, "-Qm"); err == nil {
+		data.ForeignPackages = countLines(out)
+	}
+
+	// Installed packages per third-party repository
+	var repoMap map[string]string
+	if out, _, err := r.Run("pacman", "-Sl"); err == nil {
+		var levels map[string]sigLevel
+		if f, err := os.Open(pacmanConfPath); err == nil {
+			levels, _ = parsePacmanConfSigLevels(f)
+			f.Close()
+		}
+		data.ThirdPartyRepos = countThirdPartyRepos(out, levels)
+		repoMap = parseRepoMap(out)
+	}
+
+	// Stats from paru -Ps (Total Size, Missing from AUR), or sizes summed
+	// from pacman -Qi when the backend has no -Ps
+	if helper.stats {
+		if out, _, err := r.Run(helper.name, "-Ps"); err == nil {
+			data.TotalSize, data.TotalSizeBytes, data.MissingFromAUR, data.TopPackages = parseParuStats(out)
+		}
+	}
+	// Packages ranked by installed size in bytes; paru -Ps only lists its
+	// ten biggest
+	var totalSize string
+	var totalSizeBytes int64
+	var ranked []PackageSize
+	if localErr == nil {
+		totalSize, totalSizeBytes, ranked = localPackageSizes(local)
+	} else if out, _, err := r.Run("pacman", "-Qi"); err == nil {
+		totalSize, totalSizeBytes, ranked = parseInstalledSizes(out)
+	}
+	if ranked != nil {
+		data.TopPackages = ranked
+	}
+	// Only as many as top_packages can list are kept, so changing the
+	// setting needs no reload
+	if len(data.TopPackages) > maxTopPackages {
+		data.TopPackages = data.TopPackages[:maxTopPackages]
+	}
+	if data.TotalSize == "" {
+		data.TotalSize, data.TotalSizeBytes = totalSize, totalSizeBytes
+	}
+	if repoMap != nil {
+		for i, pkg := range data.TopPackages {
+			data.TopPackages[i].Source = "aur"
+			if repo, ok := repoMap[pkg.Name]; ok {
+				data.TopPackages[i].Source = repo
+			}
+		}
+	}
+
+	// Size the pacman and paru caches plus every monitored directory in parallel
+	pacmanCachePath := "/var/cache/pacman/pkg"
+	paruCachePath := aurHelperCacheDir(helper.name)
+	paths := []string{pacmanCachePath, paruCachePath}
+	for _, dir := range cacheDirs {
+		paths = append(paths, dir.Path)
+	}
+	sizes := calculateDirSizes(paths)
+	pacmanCacheSize := sizes[pacmanCachePath]
+	paruCacheSize := sizes[paruCachePath]
+
+	// Store individual cache info
+	data.PacmanCachePath = pacmanCachePath
+	data.PacmanCacheSizeBytes = pacmanCacheSize
+	data.PacmanCacheSize = formatBytes(pacmanCacheSize)
+	data.ParuCachePath = paruCachePath
+	data.ParuCacheSizeBytes = paruCacheSize
+	data.ParuCacheSize = formatBytes(paruCacheSize)
+
+	// Combine the package manager caches for the total
+	var totalCacheBytes int64
+	for _, dir := range cacheDirs {
+		data.CacheDirSizes = append(data.CacheDirSizes, CacheDirSize{CacheDir: dir, Bytes: sizes[dir.Path]})
+		if dir.PackageManager {
+			totalCacheBytes += sizes[dir.Path]
+		}
+	}
+
+	data.CleanerSizeBytes = totalCacheBytes
+	data.CleanerSize = formatBytes(totalCacheBytes)
+	data.Filesystems = dashboardFilesystems()
+	data.CacheFreeBytes, _ = filesystemFreeBytes(pacmanCachePath)
+
+	if f, err := os.Open(pacmanLogPath); err == nil {
+		data.LastUpgrade, _ = lastSystemUpgrade(f)
+		f.Close()
+	}
+
+	return data, nil
+}
+
+func countLines(output string) int {
+	lines := strings.Split(strings.TrimSpace(output), "\n")
+	if len(lines) == 1 && lines[0] == "" {
+		return 0
+	}
+	return len(lines)
+}
+
+// parseParuStats extracts total installed size, missing AUR package count,
+// and top 10 biggest packages from paru -Ps output.
+func parseParuStats(output string) (totalSize string, totalSizeBytes int64, missingAUR int, topPackages []PackageSize) {
+	lines := strings.Split(output, "\n")
+	inTopPackages := false
+
+	for _, line := range lines {
+		line = strings.TrimSpace(line)
+
+		// Check for start of top packages section
+		if strings.Contains(line, "biggest packages") {
+			inTopPackages = true
+			continue
+		}
+
+		// End of top packages section (separator line or empty)
+		if inTopPackages && (strings.HasPrefix(line, "===") || line == "") {
+			inTopPackages = false
+			continue
+		}
+
+		// Parse top package lines (format: "package-name: 123.45 MiB")
+		if inTopPackages {
+			parts := strings.SplitN(line, ":", 2)
+			if len(parts) == 2 {
+				topPackages = append(topPackages, PackageSize{
+					Name:  strings.TrimSpace(parts[0]),
+					Size:  strings.TrimSpace(parts[1]),
+					Bytes: parseSizeToBytes(parts[1]),
+				})
+			}
+			continue
+		}
+
+		if strings.Contains(line, "Total Size occupied") || strings.Contains(line, "Total Installed Size") {
+			parts := strings.SplitN(line, ":", 2)
+			if len(parts) == 2 {
+				totalSize = strings.TrimSpace(parts[1])
+				totalSizeBytes = parseSizeToBytes(totalSize)
+			}
+		}
+		if strings.Contains(line, "Missing") && strings.Contains(line, "AUR") {
+			// Extract number from line like "Missing AUR Packages: 3"
+			parts := strings.SplitN(line, ":", 2)
+			if len(parts) == 2 {
+				_, _ = fmt.Sscanf(strings.TrimSpace(parts[1]), "%d", &missingAUR)
+			}
+		}
+	}
+	return
+}
+
+// parseInstalledSizes sums the Installed Size fields of pacman -Qi output
+// and picks the 10 biggest packages, standing in for paru -Ps
+func parseInstalledSizes(output string) (totalSize string, totalSizeBytes int64, ranked []PackageSize) {
+	var packages []localPackage
+	var name string
+	for _, line := range strings.Split(output, "\n") {
+		key, value, ok := strings.Cut(line, ":")
+		if !ok {
+			continue
+		}
+		switch strings.TrimSpace(key) {
+		case "Name":
+			name = strings.TrimSpace(value)
+		case "Installed Size":
+			packages = append(packages, localPackage{Package: Package{Name: name, SizeBytes: parseSizeToBytes(value)}})
+		}
+	}
+	return localPackageSizes(packages)
+}
+
+// localPackageSizes sums the installed sizes and ranks the packages, biggest first
+func localPackageSizes(packages []localPackage) (totalSize string, totalSizeBytes int64, ranked []PackageSize) {
+	sorted := append([]localPackage(nil), packages...)
+	sort.SliceStable(sorted, func(i, j int) bool { return sorted[i].SizeBytes > sorted[j].SizeBytes })
+	ranked = make([]PackageSize, 0, len(sorted))
+	for _, lp := range sorted {
+		totalSizeBytes += lp.SizeBytes
+		ranked = append(ranked, PackageSize{Name: lp.Name, Size: formatBytes(lp.SizeBytes), Bytes: lp.SizeBytes})
+	}
+	return formatBytes(totalSizeBytes), totalSizeBytes, ranked
+}
+
+// parseSizeToBytes converts a human-readable size (e.g., "10.5 GiB") to bytes
+func parseSizeToBytes(size string) int64 {
+	size = strings.TrimSpace(size)
+	var value float64
+	var unit string
+	_, _ = fmt.Sscanf(size, "%f %s", &value, &unit)
+	
+	unit = strings.ToLower(unit)
+	switch {
+	case strings.HasPrefix(unit, "kib") || strings.HasPrefix(unit, "kb"):
+		return int64(value * 1024)
+	case strings.HasPrefix(unit, "mib") || strings.HasPrefix(unit, "mb"):
+		return int64(value * 1024 * 1024)
+	case strings.HasPrefix(unit, "gib") || strings.HasPrefix(unit, "gb"):
+		return int64(value * 1024 * 1024 * 1024)
+	case strings.HasPrefix(unit, "tib") || strings.HasPrefix(unit, "tb"):
+		return int64(value * 1024 * 1024 * 1024 * 1024)
+	default:
+		return int64(value)
+	}
+}
+
+// calculateDirSizes sizes several directories concurrently. Duplicate paths
+// are only walked once.
+func calculateDirSizes(paths []string) map[string]int64 {
+	sizes := make(map[string]int64)
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for _, path := range paths {
+		mu.Lock()
+		_, seen := sizes[path]
+		sizes[path] = 0
+		mu.Unlock()
+		if seen {
+			continue
+		}
+		wg.Add(1)
+		go func(path string) {
+			defer wg.Done()
+			size := calculateDirSize(path)
+			mu.Lock()
+			sizes[path] = size
+			mu.Unlock()
+		}(path)
+	}
+	wg.Wait()
+	return sizes
+}
+
+// calculateDirSize walks a directory and returns the total size of all files in bytes.
+// It gracefully handles permission errors by skipping inaccessible files.
+func calculateDirSize(path string) int64 {
+	var size int64
+	err := filepath.WalkDir(path, func(_ string, d os.DirEntry, err error) error {
+		if err != nil {
+			// If we can't read a specific file/dir, just skip it and continue
+			return nil
+		}
+		if !d.IsDir() {
+			info, err := d.Info()
+			if err == nil {
+				size += info.Size()
+			}
+		}
+		return nil
+	})
+
+	if err != nil {
+		return 0
+	}
+	return size
+}
+
+// formatBytes converts bytes to human-readable format
+func formatBytes(bytes int64) string {
+	const unit = 1024
+	if bytes < unit {
+		return fmt.Sprintf("%d B", bytes)
+	}
+	div, exp := int64(unit), 0
+	for n := bytes / unit; n >= unit; n /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(bytes)/float64(div), "KMGTPE"[exp])
+}
+
+// updateClass says what paru -Syu will do with an available update
+type updateClass int
+
+const (
+	updateAvailable updateClass = iota // Updated by paru -Syu
+	updateHeld                         // On gaur's hold list, passed to paru as --ignore
+	updatePinned                       // Pinned by gaur at a version, passed to paru as --ignore
+	updateIgnored                      // IgnorePkg or IgnoreGroup in pacman.conf
+)
+
+// updateSectionTitles are the update dialog section headers, by class
+var updateSectionTitles = map[updateClass]string{
+	updateAvailable: "Updates",
+	updateHeld:      "Held by gaur",
+	updatePinned:    "Pinned by gaur",
+	updateIgnored:   "Ignored by pacman",
+}
+
+// updateEntry is a line of paru -Qu output
+type updateEntry struct {
+	pkg     Package
+	ignored bool // Marked [ignored] by paru
+}
+
+// classifiedUpdate is an available update and whether it will be applied
+type classifiedUpdate struct {
+	pkg   Package
+	class updateClass
+}
+
+// parseUpdateLine parses "name old -> new [ignored]" from paru -Qu
+func parseUpdateLine(line string) (updateEntry, bool) {
+	parts := strings.Fields(line)
+	if len(parts) < 2 || !isValidPackageName(parts[0]) {
+		return updateEntry{}, false
+	}
+	entry := updateEntry{pkg: Package{Name: parts[0]}}
+	version := parts[1:]
+	if last := version[len(version)-1]; last == "[ignored]" {
+		entry.ignored = true
+		version = version[:len(version)-1]
+	}
+	entry.pkg.Version = strings.Join(version, " ") // "oldver -> newver" format
+	if len(version) == 3 && version[1] == "->" {
+		entry.pkg.OldVersion, entry.pkg.NewVersion = version[0], version[2]
+	}
+	return entry, true
+}
+
+// versionBump is how much an update changes the version
+type versionBump int
+
+const (
+	bumpPkgrel versionBump = iota // Only the package release
+	bumpMinor                     // A later version component
+	bumpMajor                     // The epoch or first version component
+)
+
+var versionBumpLabels = map[versionBump]string{
+	bumpPkgrel: "pkgrel",
+	bumpMinor:  "minor",
+	bumpMajor:  "major",
+}
+
+// splitVersion splits an [epoch:]pkgver[-pkgrel] version into its parts
+func splitVersion(version string) (epoch, pkgver, pkgrel string) {
+	if i := strings.Index(version, ":"); i >= 0 {
+		epoch, version = version[:i], version[i+1:]
+	}
+	if i := strings.LastIndex(version, "-"); i >= 0 {
+		return epoch, version[:i], version[i+1:]
+	}
+	return epoch, version, ""
+}
+
+// vercmp compares two package versions as pacman's vercmp does, returning
+// -1, 0 or 1 when a is older than, the same as or newer than b
+func vercmp(a, b string) int {
+	if a == b {
+		return 0
+	}
+	aEpoch, aVer, aRel := splitVersion(a)
+	bEpoch, bVer, bRel := splitVersion(b)
+	if aEpoch == "" {
+		aEpoch = "0"
+	}
+	if bEpoch == "" {
+		bEpoch = "0"
+	}
+	if ret := rpmvercmp(aEpoch, bEpoch); ret != 0 {
+		return ret
+	}
+	if ret := rpmvercmp(aVer, bVer); ret != 0 || aRel == "" || bRel == "" {
+		return ret
+	}
+	return rpmvercmp(aRel, bRel)
+}
+
+// rpmvercmp compares version strings segment by segment: runs of digits
+// numerically, runs of letters alphabetically, with numbers newer than letters
+func rpmvercmp(a, b string) int {
+	if a == b {
+		return 0
+	}
+	isAlnum := func(c byte) bool { return isASCIIDigit(c) || isASCIILetter(c) }
+	i, j := 0, 0         // Start of the current segment
+	prevI, prevJ := 0, 0 // End of the previous segment
+	for i < len(a) && j < len(b) {
+		for i < len(a) && !isAlnum(a[i]) {
+			i++
+		}
+		for j < len(b) && !isAlnum(b[j]) {
+			j++
+		}
+		if i >= len(a) || j >= len(b) {
+			break
+		}
+		// Separators of different lengths decide it
+		if i-prevI != j-prevJ {
+			if i-prevI < j-prevJ {
+				return -1
+			}
+			return 1
+		}
+
+		endI, endJ := i, j
+		isNum := isASCIIDigit(a[i])
+		segment := isASCIILetter
+		if isNum {
+			segment = isASCIIDigit
+		}
+		for endI < len(a) && segment(a[endI]) {
+			endI++
+		}
+		for endJ < len(b) && segment(b[endJ]) {
+			endJ++
+		}
+		// Segments of different types: numbers are newer
+		if endJ == j {
+			if isNum {
+				return 1
+			}
+			return -1
+		}
+
+		segA, segB := a[i:endI], b[j:endJ]
+		if isNum {
+			segA, segB = strings.TrimLeft(segA, "0"), strings.TrimLeft(segB, "0")
+			if len(segA) != len(segB) {
+				if len(segA) > len(segB) {
+					return 1
+				}
+				return -1
+			}
+		}
+		if cmp := strings.Compare(segA, segB); cmp != 0 {
+			return cmp
+		}
+		i, j = endI, endJ
+		prevI, prevJ = endI, endJ
+	}
+
+	restA, restB := a[i:], b[j:]
+	if restA == "" && restB == "" {
+		return 0
+	}
+	// A remaining letter segment never beats running out: 1.0a < 1.0 < 1.0.1
+	if (restA == "" && (restB == "" || !isASCIILetter(restB[0]))) || (restA != "" && isASCIILetter(restA[0])) {
+		return -1
+	}
+	return 1
+}
+
+// isASCIIDigit reports whether c is 0-9
+func isASCIIDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
+
+// isASCIILetter reports whether c is an ASCII letter
+func isASCIILetter(c byte) bool {
+	return (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z')
+}
+
+// isVersionSeparator reports whether r separates version components
+func isVersionSeparator(r rune) bool {
+	return r == '.' || r == '+' || r == '_' || r == '~' || r == '-' || r == ':'
+}
+
+// classifyBump compares two versions component by component
+func classifyBump(oldVersion, newVersion string) versionBump {
+	oldEpoch, oldVer, _ := splitVersion(oldVersion)
+	newEpoch, newVer, _ := splitVersion(newVersion)
+	if oldEpoch != newEpoch {
+		return bumpMajor
+	}
+	oldParts := strings.FieldsFunc(oldVer, isVersionSeparator)
+	newParts := strings.FieldsFunc(newVer, isVersionSeparator)
+	for i := 0; i < len(oldParts) || i < len(newParts); i++ {
+		if i >= len(oldParts) || i >= len(newParts) || oldParts[i] != newParts[i] {
+			if i == 0 {
+				return bumpMajor
+			}
+			return bumpMinor
+		}
+	}
+	return bumpPkgrel
+}
+
+// changedVersionSuffix splits newVersion where it starts to differ from
+// oldVersion, at the start of the first differing component
+func changedVersionSuffix(oldVersion, newVersion string) (same, changed string) {
+	boundary := 0
+	for i, r := range newVersion {
+		if i >= len(oldVersion) || oldVersion[i] != newVersion[i] {
+			return newVersion[:boundary], newVersion[boundary:]
+		}
+		if isVersionSeparator(r) {
+			boundary = i + 1
+		}
+	}
+	return newVersion, ""
+}
+
+// sortUpdates puts AUR updates first, then the biggest version bumps, then
+// orders by name
+func sortUpdates(updates []classifiedUpdate) {
+	sort.SliceStable(updates, func(i, j int) bool {
+		a, b := updates[i].pkg, updates[j].pkg
+		if aAUR, bAUR := a.Source == "aur", b.Source == "aur"; aAUR != bAUR {
+			return aAUR
+		}
+		if ba, bb := classifyBump(a.OldVersion, a.NewVersion), classifyBump(b.OldVersion, b.NewVersion); ba != bb {
+			return ba > bb
+		}
+		return a.Name < b.Name
+	})
+}
+
+// parsePacmanConfIgnores returns the IgnorePkg patterns and IgnoreGroup
+// groups from pacman.conf's [options] section
+func parsePacmanConfIgnores(r io.Reader) (patterns, groups []string, err error) {
+	section := ""
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if i := strings.Index(line, "#"); i >= 0 {
+			line = line[:i]
+		}
+		line = strings.TrimSpace(line)
+		if strings.HasPrefix(line, "[") && strings.HasSuffix(line, "]") {
+			section = strings.TrimSpace(line[1 : len(line)-1])
+			continue
+		}
+		key, value, ok := strings.Cut(line, "=")
+		if !ok || section != "options" {
+			continue
+		}
+		switch strings.TrimSpace(key) {
+		case "IgnorePkg":
+			patterns = append(patterns, strings.Fields(value)...)
+		case "IgnoreGroup":
+			groups = append(groups, strings.Fields(value)...)
+		}
+	}
+	return patterns, groups, scanner.Err()
+}
+
+// groupMembers returns the installed members of groups from one pacman -Qg
+func groupMembers(r Runner, groups []string) map[string]bool {
+	members := make(map[string]bool)
+	valid, _ := sanitizePackageNames(groups)
+	if len(valid) == 0 {
+		return members
+	}
+	stdout, _, _ := r.Run("pacman", append([]string{"-Qg"}, valid...)...) // Fails on a group with nothing installed
+	for _, line := range strings.Split(stdout, "\n") {
+		if fields := strings.Fields(line); len(fields) == 2 {
+			members[fields[1]] = true
+		}
+	}
+	return members
+}
+
+// updateRules are the lists that keep updates back
+type updateRules struct {
+	ignorePatterns []string          // IgnorePkg globs from pacman.conf
+	ignoredGroups  map[string]bool   // Members of IgnoreGroup groups
+	held           map[string]bool   // gaur's holds
+	pinned         map[string]string // gaur's pins, name -> version
+}
+
+// classifyUpdates decides what happens to each available update. pacman's
+// ignore list wins over gaur's pins and holds, since pacman skips those
+// packages whatever gaur passes it; IgnorePkg entries may be glob patterns.
+// A pin, which keeps a version, wins over a hold.
+func classifyUpdates(entries []updateEntry, rules updateRules) []classifiedUpdate {
+	updates := make([]classifiedUpdate, 0, len(entries))
+	for _, entry := range entries {
+		class := updateAvailable
+		ignored := entry.ignored || rules.ignoredGroups[entry.pkg.Name]
+		for _, pattern := range rules.ignorePatterns {
+			if match, _ := filepath.Match(pattern, entry.pkg.Name); match {
+				ignored = true
+				break
+			}
+		}
+		_, pinned := rules.pinned[entry.pkg.Name]
+		switch {
+		case ignored:
+			class = updateIgnored
+		case pinned:
+			class = updatePinned
+		case rules.held[entry.pkg.Name]:
+			class = updateHeld
+		}
+		updates = append(updates, classifiedUpdate{pkg: entry.pkg, class: class})
+	}
+	return updates
+}
+
+// vcsSuffixes mark AUR packages built from a version control checkout
+var vcsSuffixes = []string{"-git", "-svn", "-hg", "-bzr", "-darcs", "-cvs", "-fossil"}
+
+// isVCSPackage reports whether a package name has a VCS suffix
+func isVCSPackage(name string) bool {
+	for _, suffix := range vcsSuffixes {
+		if strings.HasSuffix(name, suffix) {
+			return true
+		}
+	}
+	return false
+}
+
+// foreignVersions maps each foreign package to its installed version, from
+// a single pacman -Qm
+func foreignVersions(r Runner) map[string]string {
+	stdout, _, _ := r.Run("pacman", "-Qm") // Exits non-zero when there are none
+	versions := make(map[string]string)
+	for _, line := range strings.Split(stdout, "\n") {
+		if fields := strings.Fields(line); len(fields) == 2 {
+			versions[fields[0]] = fields[1]
+		}
+	}
+	return versions
+}
+
+// aurUpdatesMissed compares the foreign packages against the AUR in batched
+// /info requests and returns the updates not in listed, catching those a
+// stale helper cache misses. VCS packages are left to --devel.
+func aurUpdatesMissed(foreign map[string]string, listed map[string]bool) ([]updateEntry, error) {
+	var names []string
+	for name := range foreign {
+		if !listed[name] && !isVCSPackage(name) {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	resolver := &aurInfoResolver{known: make(map[string]*aurPackageInfo)}
+	if err := resolver.fetch(names); err != nil {
+		return nil, err
+	}
+	var entries []updateEntry
+	for _, name := range names {
+		info := resolver.known[name]
+		if info == nil || vercmp(foreign[name], info.Version) >= 0 {
+			continue
+		}
+		entries = append(entries, updateEntry{pkg: Package{
+			Name:       name,
+			Source:     "aur",
+			Version:    foreign[name] + " -> " + info.Version,
+			OldVersion: foreign[name],
+			NewVersion: info.Version,
+		}})
+	}
+	return entries, nil
+}
+
+// checkUpdates fetches available updates using paru -Qu and classifies them
+// against pacman.conf and the given holds and pins. With devel set, paru also
+// checks VCS packages for new upstream commits. Foreign packages are also
+// checked against the AUR directly when the helper can update them.
+func checkUpdates(r Runner, held map[string]bool, pinned map[string]string, devel bool) tea.Cmd {
+	rules := updateRules{held: make(map[string]bool, len(held)), pinned: make(map[string]string, len(pinned))}
+	for name := range held {
+		rules.held[name] = true
+	}
+	for name, version := range pinned {
+		rules.pinned[name] = version
+	}
+	return func() tea.Msg {
+		args := []string{"-Qu"}
+		if devel && helper.aur {
+			args = append(args, "--devel")
+		}
+		stdout, _, _ := r.Run(helper.name, args...) // Returns error if no updates, that's ok
+
+		// Foreign packages are the AUR ones. Sources come from this one
+		// pacman -Qm rather than a lookup per update; pacman alone never
+		// lists foreign updates, so it needs no lookup at all.
+		var foreign map[string]string
+		if helper.aur {
+			foreign = foreignVersions(r)
+		}
+		var entries []updateEntry
+		listed := make(map[string]bool)
+		for _, line := range strings.Split(strings.TrimSpace(stdout), "\n") {
+			entry, ok := parseUpdateLine(line)
+			if !ok {
+				continue
+			}
+			pkg := &entry.pkg
+			pkg.Source = "repo"
+			if _, ok := foreign[pkg.Name]; ok {
+				pkg.Source = "aur"
+			}
+			// paru reports a pending VCS rebuild as "latest-commit"
+			pkg.Devel = devel && pkg.Source == "aur" && (pkg.NewVersion == "latest-commit" || isVCSPackage(pkg.Name))
+			entries = append(entries, entry)
+			listed[pkg.Name] = true
+		}
+		if len(foreign) > 0 {
+			// Offline, the helper's own list has to do
+			if missed, err := aurUpdatesMissed(foreign, listed); err == nil {
+				entries = append(entries, missed...)
+			}
+		}
+
+		if f, err := os.Open(pacmanConfPath); err == nil {
+			var groups []string
+			rules.ignorePatterns, groups, _ = parsePacmanConfIgnores(f)
+			f.Close()
+			if len(groups) > 0 && len(entries) > 0 {
+				rules.ignoredGroups = groupMembers(r, groups)
+			}
+		}
+		updates := classifyUpdates(entries, rules)
+		sortUpdates(updates)
+		return updateCheckMsg{updates: updates}
+	}
+}
+
+// Arch Linux news feed, checked before system updates
+const (
+	archNewsURL         = "https://archlinux.org/feeds/news/"
+	archNewsPage        = "archlinux.org/news"
+	archNewsTimeout     = 5 * time.Second     // News must never hold up an update
+	archNewsFallbackAge = 30 * 24 * time.Hour // Window used when pacman.log has no upgrade
+	archNewsVisible     = 3                   // News items shown at once in the update dialog
+	pacmanLogPath       = "/var/log/pacman.log"
+)
+
+// archNewsItem is a post from the Arch Linux news feed
+type archNewsItem struct {
+	title string
+	link  string
+	date  time.Time
+}
+
+// manualIntervention reports whether the post asks users to act during the update
+func (n archNewsItem) manualIntervention() bool {
+	return strings.Contains(strings.ToLower(n.title), "manual intervention")
+}
+
+// newsNeedsAcknowledgement reports whether the update dialog shows a manual
+// intervention post not yet acknowledged; the update won't run until it is
+func (m model) newsNeedsAcknowledgement() bool {
+	if m.newsAcknowledged {
+		return false
+	}
+	for _, item := range m.news {
+		if item.manualIntervention() {
+			return true
+		}
+	}
+	return false
+}
+
+// archNewsMsg carries news posted since the last system upgrade
+type archNewsMsg struct {
+	items []archNewsItem
+	since time.Time
+	err   error
+}
+
+// parseArchNews reads the items of an RSS feed, newest first
+func parseArchNews(r io.Reader) ([]archNewsItem, error) {
+	var feed struct {
+		Items []struct {
+			Title   string `xml:"title"`
+			Link    string `xml:"link"`
+			PubDate string `xml:"pubDate"`
+		} `xml:"channel>item"`
+	}
+	if err := xml.NewDecoder(r).Decode(&feed); err != nil {
+		return nil, err
+	}
+	var items []archNewsItem
+	for _, it := range feed.Items {
+		date, err := time.Parse(time.RFC1123Z, strings.TrimSpace(it.PubDate))
+		if err != nil {
+			if date, err = time.Parse(time.RFC1123, strings.TrimSpace(it.PubDate)); err != nil {
+				continue
+			}
+		}
+		items = append(items, archNewsItem{title: strings.TrimSpace(it.Title), link: strings.TrimSpace(it.Link), date: date})
+	}
+	sort.SliceStable(items, func(i, j int) bool { return items[i].date.After(items[j].date) })
+	return items, nil
+}
+
+// pacmanLogLine is a line of pacman.log split into its parts
+type pacmanLogLine struct {
+	time time.Time
+	tag  string // ALPM, PACMAN, ALPM-SCRIPTLET; empty in logs from before pacman 4.1
+	text string
+}
+
+// parsePacmanLogLine splits "[timestamp] [TAG] text". pacman 5.2 and later log
+// ISO 8601 timestamps with a zone; older logs use minutes in local time.
+func parsePacmanLogLine(line string) (pacmanLogLine, bool) {
+	end := strings.Index(line, "]")
+	if !strings.HasPrefix(line, "[") || end < 0 {
+		return pacmanLogLine{}, false
+	}
+	stamp := line[1:end]
+	t, err := time.Parse("2006-01-02T15:04:05-0700", stamp)
+	if err != nil {
+		if t, err = time.ParseInLocation("2006-01-02 15:04", stamp, time.Local); err != nil {
+			return pacmanLogLine{}, false
+		}
+	}
+	l := pacmanLogLine{time: t, text: strings.TrimSpace(line[end+1:])}
+	if strings.HasPrefix(l.text, "[") {
+		if tagEnd := strings.Index(l.text, "]"); tagEnd > 0 {
+			l.tag = l.text[1:tagEnd]
+			l.text = strings.TrimSpace(l.text[tagEnd+1:])
+		}
+	}
+	return l, true
+}
+
+// lastSystemUpgrade finds the start of the last full system upgrade in
+// pacman.log that ran to completion. Only the first transaction after the
+// upgrade starts is its own: an upgrade declined at its prompt logs none,
+// so a later command's transaction, or a second one, no longer counts.
+func lastSystemUpgrade(r io.Reader) (time.Time, bool) {
+	var last, started time.Time
+	inTransaction := false
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		l, ok := parsePacmanLogLine(scanner.Text())
+		if !ok {
+			continue
+		}
+		switch {
+		case l.text == "starting full system upgrade":
+			started, inTransaction = l.time, false
+		case strings.HasPrefix(l.text, "Running '"):
+			started = time.Time{}
+		case l.text == "transaction started":
+			if inTransaction {
+				started = time.Time{}
+			}
+			inTransaction = !started.IsZero()
+		case l.text == "transaction completed" && inTransaction:
+			last, started, inTransaction = started, time.Time{}, false
+		}
+	}
+	return last, !last.IsZero()
+}
+
+// checkArchNews fetches the Arch news posted since the last completed system
+// upgrade, falling back to the last archNewsFallbackAge
+func checkArchNews() tea.Cmd {
+	return func() tea.Msg {
+		since := time.Now().Add(-archNewsFallbackAge)
+		if f, err := os.Open(pacmanLogPath); err == nil {
+			if t, ok := lastSystemUpgrade(f); ok {
+				since = t
+			}
+			f.Close()
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), archNewsTimeout)
+		defer cancel()
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, archNewsURL, nil)
+		if err != nil {
+			return archNewsMsg{since: since, err: err}
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			return archNewsMsg{since: since, err: err}
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			return archNewsMsg{since: since, err: fmt.Errorf("news feed: %s", resp.Status)}
+		}
+		items, err := parseArchNews(resp.Body)
+		if err != nil {
+			return archNewsMsg{since: since, err: err}
+		}
+		var recent []archNewsItem
+		for _, item := range items {
+			if item.date.After(since) {
+				recent = append(recent, item)
+			}
+		}
+		return archNewsMsg{items: recent, since: since}
+	}
+}
+
+// historyEntry is a package change recorded in pacman.log
+type historyEntry struct {
+	time       time.Time
+	action     string // installed, upgraded, downgraded, reinstalled or removed
+	pkg        string
+	oldVersion string
+	newVersion string
+	tx         int // Index of the entry's transaction
+	index      int // Position within the transaction
+}
+
+// historyTransaction is one pacman run and the package changes it made
+type historyTransaction struct {
+	start     time.Time
+	command   string // Command line logged by pacman, if any
+	operation string // gaur operation that ran it, from the operations log
+	entries   []historyEntry
+}
+
+// historyMsg carries the transactions parsed from pacman.log
+type historyMsg struct {
+	transactions []historyTransaction
+	err          error
+}
+
+// historyActionPattern matches "upgraded mesa (24.0.1-1 -> 24.0.2-1)"
+var historyActionPattern = regexp.MustCompile(`^(installed|upgraded|downgraded|reinstalled|removed) (\S+) \((.*)\)$`)
+
+// historyUnmarkedGap splits package changes into separate transactions in
+// old logs that don't mark where transactions start and end
+const historyUnmarkedGap = 5 * time.Minute
+
+// parsePacmanHistory groups the package changes in pacman.log into
+// transactions, oldest first. Runs that changed nothing are left out.
+func parsePacmanHistory(r io.Reader) ([]historyTransaction, error) {
+	var transactions []historyTransaction
+	var current *historyTransaction
+	command := ""
+	marked := false // current began with "transaction started"
+	flush := func() {
+		if current != nil && len(current.entries) > 0 {
+			transactions = append(transactions, *current)
+		}
+		current = nil
+	}
+
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		l, ok := parsePacmanLogLine(scanner.Text())
+		if !ok {
+			continue
+		}
+		switch {
+		case strings.HasPrefix(l.text, "Running '"):
+			flush()
+			command = strings.TrimSuffix(strings.TrimPrefix(l.text, "Running '"), "'")
+		case l.text == "transaction started":
+			flush()
+			current = &historyTransaction{start: l.time, command: command}
+			marked = true
+		case l.text == "transaction completed" || l.text == "transaction failed":
+			flush()
+			command = ""
+		default:
+			match := historyActionPattern.FindStringSubmatch(l.text)
+			if match == nil || (l.tag != "" && l.tag != "ALPM") {
+				continue
+			}
+			if current != nil && !marked && l.time.Sub(current.entries[len(current.entries)-1].time) > historyUnmarkedGap {
+				flush()
+				command = ""
+			}
+			if current == nil {
+				current = &historyTransaction{start: l.time, command: command}
+				marked = false
+			}
+			entry := historyEntry{time: l.time, action: match[1], pkg: match[2]}
+			if before, after, found := strings.Cut(match[3], " -> "); found {
+				entry.oldVersion, entry.newVersion = before, after
+			} else if entry.action == "removed" {
+				entry.oldVersion = match[3]
+			} else {
+				entry.newVersion = match[3]
+			}
+			current.entries = append(current.entries, entry)
+		}
+	}
+	flush()
+
+	for i := range transactions {
+		for j := range transactions[i].entries {
+			transactions[i].entries[j].tx = i
+			transactions[i].entries[j].index = j
+		}
+	}
+	return transactions, scanner.Err()
+}
+
+// loadHistory reads the transaction history from pacman.log, noting which
+// transactions gaur ran from its operations log
+func loadHistory() tea.Cmd {
+	return func() tea.Msg {
+		f, err := os.Open(pacmanLogPath)
+		if err != nil {
+			return historyMsg{err: err}
+		}
+		defer f.Close()
+		transactions, err := parsePacmanHistory(f)
+		if opLogPath != "" {
+			if logFile, logErr := os.Open(opLogPath); logErr == nil {
+				annotateHistory(transactions, parseOperationLog(logFile))
+				logFile.Close()
+			}
+		}
+		return historyMsg{transactions: transactions, err: err}
+	}
+}
+
+// loggedOperation is an operation recorded in the operations log
+type loggedOperation struct {
+	name   string // operationName of the operation
+	batch  string // "2/5" for one batch of a batched operation
+	start  time.Time
+	end    time.Time
+	failed bool
+}
+
+// parseOperationLog reads the operations recorded by logOperation. Lines of
+// other kinds, such as debug command lines, are skipped.
+func parseOperationLog(r io.Reader) []loggedOperation {
+	var ops []loggedOperation
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		fields := parseLogfmt(scanner.Text())
+		if fields["msg"] != "operation" {
+			continue
+		}
+		start, err := time.Parse(time.RFC3339, fields["start"])
+		if err != nil {
+			continue
+		}
+		end, err := time.Parse(time.RFC3339, fields["end"])
+		if err != nil {
+			continue
+		}
+		ops = append(ops, loggedOperation{
+			name:   fields["type"],
+			batch:  fields["batch"],
+			start:  start,
+			end:    end,
+			failed: fields["exit_code"] != "0",
+		})
+	}
+	return ops
+}
+
+// parseLogfmt splits a line written by slog's text handler into its
+// key=value pairs, unquoting quoted values
+func parseLogfmt(line string) map[string]string {
+	fields := make(map[string]string)
+	for line = strings.TrimSpace(line); line != ""; line = strings.TrimSpace(line) {
+		key, rest, ok := strings.Cut(line, "=")
+		if !ok {
+			break
+		}
+		value := rest
+		if strings.HasPrefix(rest, `"`) {
+			quoted, err := strconv.QuotedPrefix(rest)
+			if err != nil {
+				break
+			}
+			value, _ = strconv.Unquote(quoted)
+			rest = rest[len(quoted):]
+		} else {
+			value, rest, _ = strings.Cut(rest, " ")
+		}
+		fields[key] = value
+		line = rest
+	}
+	return fields
+}
+
+// annotateHistory labels each transaction with the logged operation that was
+// running when it started. Both lists are oldest first.
+func annotateHistory(transactions []historyTransaction, ops []loggedOperation) {
+	i := 0
+	for t := range transactions {
+		tx := &transactions[t]
+		for i < len(ops) && ops[i].end.Before(tx.start) {
+			i++
+		}
+		if i == len(ops) {
+			return
+		}
+		op := ops[i]
+		if tx.start.Before(op.start) {
+			continue
+		}
+		tx.operation = op.name
+		if op.batch != "" {
+			tx.operation += ", batch " + op.batch
+		}
+		if op.failed {
+			tx.operation += " (failed)"
+		}
+	}
+}
+
+// filterHistory lists the history entries whose package matches the query,
+// newest first
+func (m *model) filterHistory(query string) {
+	m.filteredHistory = nil
+	m.historyMatches = nil
+	if strings.TrimSpace(query) != "" {
+		m.historyMatches = make(map[int][]int)
+	}
+	for i := len(m.history) - 1; i >= 0; i-- {
+		tx := m.history[i]
+		for j := len(tx.entries) - 1; j >= 0; j-- {
+			entry := tx.entries[j]
+			if m.historyMatches != nil {
+				_, positions, ok := fuzzyMatchTerms(entry.pkg, query)
+				if !ok {
+					continue
+				}
+				m.historyMatches[len(m.filteredHistory)] = positions
+			}
+			m.filteredHistory = append(m.filteredHistory, entry)
+		}
+	}
+	if m.selectedIndex >= len(m.filteredHistory) {
+		m.selectedIndex = 0
+	}
+}
+
+// historyActionStyle colors a history action
+func historyActionStyle(action string) lipgloss.Style {
+	style := lipgloss.NewStyle()
+	switch action {
+	case "installed":
+		style = style.Foreground(currentTheme.InstallColor)
+	case "upgraded":
+		style = style.Foreground(currentTheme.SuccessColor)
+	case "downgraded":
+		style = style.Foreground(currentTheme.WarningColor)
+	case "removed":
+		style = style.Foreground(currentTheme.ErrorColor)
+	default:
+		style = style.Foreground(currentTheme.SubtleColor)
+	}
+	return style
+}
+
+// historyVersion formats the version change of a history entry
+func historyVersion(entry historyEntry) string {
+	switch {
+	case entry.oldVersion != "" && entry.newVersion != "":
+		return entry.oldVersion + " → " + entry.newVersion
+	case entry.oldVersion != "":
+		return entry.oldVersion
+	}
+	return entry.newVersion
+}
+
+// renderHistoryRows renders the filtered history fzf-style, newest entry at
+// the bottom next to the input
+func (m model) renderHistoryRows(width, height int) string {
+	if len(m.filteredHistory) == 0 {
+		if m.textInput.Value() != "" {
+			return "  No matching transactions"
+		}
+		return "  No transactions in " + pacmanLogPath
+	}
+	subtleStyle := lipgloss.NewStyle().Foreground(currentTheme.SubtleColor)
+
+	start := 0
+	if m.selectedIndex >= height {
+		start = m.selectedIndex - height + 1
+	}
+	end := start + height
+	if end > len(m.filteredHistory) {
+		end = len(m.filteredHistory)
+	}
+	var lines []string
+	for i := start; i < end; i++ {
+		entry := m.filteredHistory[i]
+		prefix := "  "
+		if i == m.selectedIndex {
+			prefix = "> "
+		}
+		// Date, action and name always fit; the version takes what is left
+		version := historyVersion(entry)
+		if room := width - 2 - 17 - 12 - utf8.RuneCountInString(entry.pkg) - 1; room < utf8.RuneCountInString(version) {
+			version = truncateRunes(version, max(room, 0))
+		}
+		line := fmt.Sprintf("%s%s %s %s %s", prefix,
+			subtleStyle.Render(entry.time.Local().Format("2006-01-02 15:04")),
+			historyActionStyle(entry.action).Render(fmt.Sprintf("%-11s", entry.action)),
+			highlightMatches(entry.pkg, m.historyMatches[i]),
+			subtleStyle.Render(version))
+		if i == m.selectedIndex {
+			line = selectedStyle.Render(line)
+		}
+		lines = append(lines, line)
+	}
+	// Reversed so the newest entry sits at the bottom, next to the input
+	for i, j := 0, len(lines)-1; i < j; i, j = i+1, j-1 {
+		lines[i], lines[j] = lines[j], lines[i]
+	}
+	return strings.Join(lines, "\n")
+}
+
+// renderHistoryTransaction renders the transaction of the selected history
+// entry with the entry highlighted
+func (m model) renderHistoryTransaction(width, height int) string {
+	if m.historyErr != nil {
+		return lipgloss.NewStyle().Foreground(currentTheme.WarningColor).Render(
+			fmt.Sprintf("Can't read %s: %v", pacmanLogPath, m.historyErr))
+	}
+	if m.selectedIndex >= len(m.filteredHistory) {
+		return "Select a transaction to see its changes"
+	}
+	selected := m.filteredHistory[m.selectedIndex]
+	tx := m.history[selected.tx]
+	titleStyle := lipgloss.NewStyle().Bold(true).Foreground(currentTheme.TitleColor)
+	subtleStyle := lipgloss.NewStyle().Foreground(currentTheme.SubtleColor)
+
+	header := titleStyle.Render(fmt.Sprintf("Transaction of %s", tx.start.Local().Format("2006-01-02 15:04")))
+	if room := width - lipgloss.Width(header) - 2; tx.command != "" && room > 0 {
+		header += "  " + subtleStyle.Render(truncateRunes(tx.command, room))
+	}
+	rows := []string{header}
+	if tx.operation != "" {
+		rows = append(rows, subtleStyle.Render(truncateRunes("Run by gaur: "+tx.operation, max(width-2, 0))))
+	}
+
+	// Keep the selected entry in view
+	visible := height - len(rows) - 1
+	if visible < 1 {
+		visible = 1
+	}
+	start := 0
+	if selected.index >= visible {
+		start = selected.index - visible + 1
+	}
+	end := start + visible
+	if end > len(tx.entries) {
+		end = len(tx.entries)
+	}
+	for _, entry := range tx.entries[start:end] {
+		version := historyVersion(entry)
+		if room := width - 2 - 12 - utf8.RuneCountInString(entry.pkg) - 1; room < utf8.RuneCountInString(version) {
+			version = truncateRunes(version, max(room, 0))
+		}
+		line := fmt.Sprintf("%s %s %s", historyActionStyle(entry.action).Render(fmt.Sprintf("%-11s", entry.action)),
+			entry.pkg, subtleStyle.Render(version))
+		if entry.index == selected.index {
+			line = selectedStyle.Render("> " + line)
+		} else {
+			line = "  " + line
+		}
+		rows = append(rows, line)
+	}
+	rows = append(rows, subtleStyle.Render(fmt.Sprintf("%d package change(s)", len(tx.entries))))
+	return strings.Join(rows, "\n")
+}
+
+// updatesOfClass returns the names of updates in a class
+func (m model) updatesOfClass(class updateClass) []string {
+	var names []string
+	for _, u := range m.classifiedUpdates {
+		if u.class == class {
+			names = append(names, u.pkg.Name)
+		}
+	}
+	return names
+}
+
+// updateSelection splits the available updates into those to upgrade and
+// those passed to paru as --ignore: held and pinned packages and the ones
+// skipped for this run
+func (m model) updateSelection() (upgrade, ignore []string) {
+	ignore = append(m.updatesOfClass(updateHeld), m.updatesOfClass(updatePinned)...)
+	for _, pkg := range m.pendingUpdates {
+		if m.confirmExcluded[pkg.Name] {
+			ignore = append(ignore, pkg.Name)
+		} else {
+			upgrade = append(upgrade, pkg.Name)
+		}
+	}
+	return upgrade, ignore
+}
+
+// skipsRepoUpdates reports whether the update leaves out repo packages, which
+// makes it a partial upgrade
+func (m model) skipsRepoUpdates() bool {
+	for _, u := range m.classifiedUpdates {
+		if u.pkg.Source == "aur" {
+			continue
+		}
+		if u.class == updateHeld || u.class == updatePinned || (u.class == updateAvailable && m.confirmExcluded[u.pkg.Name]) {
+			return true
+		}
+	}
+	return false
+}
+
+// setPendingUpdates refreshes pendingUpdates from the classified updates
+func (m *model) setPendingUpdates() {
+	m.pendingUpdates = nil
+	for _, u := range m.classifiedUpdates {
+		if u.class == updateAvailable {
+			m.pendingUpdates = append(m.pendingUpdates, u.pkg)
+		}
+	}
+}
+
+// clampConfirmCursor keeps the dialog cursor and scroll window within rowCount rows
+func (m *model) clampConfirmCursor(rowCount int) {
+	if m.confirmCursor >= rowCount {
+		m.confirmCursor = rowCount - 1
+	}
+	if m.confirmCursor < 0 {
+		m.confirmCursor = 0
+	}
+	if m.confirmScrollOffset > m.confirmCursor {
+		m.confirmScrollOffset = m.confirmCursor
+	}
+}
+
+// renderUpdateRow renders a line of the update dialog
+func (m model) renderUpdateRow(row updateRow, selected bool, keyStyle, nameStyle, versionStyle, hintStyle lipgloss.Style, sourceStyle func(string) lipgloss.Style) string {
+	cursor := "  "
+	if selected {
+		cursor = keyStyle.Render("> ")
+	}
+	if row.header {
+		arrow := "▾"
+		if m.updateCollapsed[row.class] {
+			arrow = "▸"
+		}
+		return fmt.Sprintf("%s%s %s\n", cursor, arrow, keyStyle.Render(fmt.Sprintf("%s (%d)", updateSectionTitles[row.class], row.count)))
+	}
+
+	pkg := row.pkg
+	sourceBadge := sourceStyle(pkg.Source).Render(fmt.Sprintf("[%s]", pkg.Source))
+	name := nameStyle.Render(pkg.Name)
+	skipped := row.class == updateAvailable && m.confirmExcluded[pkg.Name]
+	if row.class != updateAvailable || skipped {
+		name = hintStyle.Render(pkg.Name)
+	}
+	version := versionStyle.Render(pkg.Version)
+	if pkg.NewVersion != "" {
+		// Highlight the changed part of the new version and label the bump
+		same, changed := changedVersionSuffix(pkg.OldVersion, pkg.NewVersion)
+		bump := classifyBump(pkg.OldVersion, pkg.NewVersion)
+		label := versionBumpLabels[bump]
+		bumpStyle := hintStyle
+		switch {
+		case pkg.Devel:
+			label = "devel"
+			bumpStyle = lipgloss.NewStyle().Foreground(currentTheme.HighlightColor).Bold(true)
+		case bump == bumpMajor:
+			bumpStyle = lipgloss.NewStyle().Foreground(currentTheme.WarningColor).Bold(true)
+		case bump == bumpMinor:
+			bumpStyle = lipgloss.NewStyle().Foreground(currentTheme.TextColor)
+		}
+		version = fmt.Sprintf("%s → %s%s %s",
+			versionStyle.Render(pkg.OldVersion),
+			versionStyle.Render(same),
+			lipgloss.NewStyle().Foreground(currentTheme.SuccessColor).Render(changed),
+			bumpStyle.Render("["+label+"]"))
+	}
+	line := fmt.Sprintf("%s• %s %s %s", cursor, sourceBadge, name, version)
+	if skipped {
+		line += hintStyle.Render("  (skipped this run)")
+	}
+	if row.class == updateHeld {
+		line += lipgloss.NewStyle().Foreground(currentTheme.WarningColor).Render("  🔒")
+	}
+	if row.class == updatePinned {
+		line += lipgloss.NewStyle().Foreground(currentTheme.WarningColor).Render("  📌 " + m.pins[pkg.Name])
+	}
+	line += "\n"
+	// Dependency chains that are likely to break this AUR update
+	if row.class == updateAvailable {
+		for _, issue := range m.aurDepIssues[pkg.Name] {
+			line += lipgloss.NewStyle().Foreground(currentTheme.WarningColor).Render(
+				fmt.Sprintf("      ⚠ %s: %s", strings.Join(issue.chain, " → "), issue.problem)) + "\n"
+		}
+	}
+	return line
+}
+
+// renderArchNews renders the news section at the top of the update dialog.
+// Posts asking for manual intervention stand out in the error color.
+func (m model) renderArchNews(width int, hintStyle lipgloss.Style) string {
+	switch {
+	case m.newsLoading:
+		return hintStyle.Render("📰 Checking Arch news...") + "\n\n"
+	case m.newsErr != nil:
+		return hintStyle.Render("📰 Arch news unavailable") + "\n\n"
+	case len(m.news) == 0:
+		return hintStyle.Render("📰 No Arch news since the last upgrade") + "\n\n"
+	}
+
+	var b strings.Builder
+	warnStyle := lipgloss.NewStyle().Foreground(currentTheme.WarningColor).Bold(true)
+	b.WriteString(warnStyle.Render(fmt.Sprintf("📰 %d Arch news post(s) since %s - read before updating:",
+		len(m.news), m.newsSince.Format("2006-01-02"))))
+	b.WriteString("\n")
+	end := m.newsOffset + archNewsVisible
+	if end > len(m.news) {
+		end = len(m.news)
+	}
+	if m.newsOffset > 0 {
+		b.WriteString(hintStyle.Render(fmt.Sprintf("  ↑ %d newer", m.newsOffset)) + "\n")
+	}
+	for _, item := range m.news[m.newsOffset:end] {
+		style := lipgloss.NewStyle().Foreground(currentTheme.TextColor)
+		marker := "•"
+		if item.manualIntervention() {
+			style = lipgloss.NewStyle().Foreground(currentTheme.ErrorColor).Bold(true)
+			marker = "⚠"
+		}
+		line := fmt.Sprintf("%s %s", marker, truncateRunes(item.title, width-16))
+		b.WriteString(fmt.Sprintf("  %s %s\n", hintStyle.Render(item.date.Local().Format("2006-01-02")), style.Render(line)))
+	}
+	if rest := len(m.news) - end; rest > 0 {
+		b.WriteString(hintStyle.Render(fmt.Sprintf("  ↓ %d older", rest)) + "\n")
+	}
+	if m.newsNeedsAcknowledgement() {
+		b.WriteString(lipgloss.NewStyle().Foreground(currentTheme.ErrorColor).Render(
+			"  Read the ⚠ post(s) at "+archNewsPage+" and act on them before updating") + "\n")
+	} else if m.newsAcknowledged {
+		b.WriteString(hintStyle.Render("  ✓ Manual intervention acknowledged") + "\n")
+	}
+	b.WriteString("\n")
+	return b.String()
+}
+
+// updateRow is a section header or a package in the update dialog
+type updateRow struct {
+	header bool
+	class  updateClass
+	count  int // Packages in the section, for headers
+	pkg    Package
+}
+
+// updateDialogRows lays out the update dialog. Section headers are only shown
+// when something is held, pinned or ignored; collapsed sections show the
+// header alone.
+func (m model) updateDialogRows() []updateRow {
+	counts := make(map[updateClass]int)
+	for _, u := range m.classifiedUpdates {
+		counts[u.class]++
+	}
+	sectioned := counts[updateHeld] > 0 || counts[updatePinned] > 0 || counts[updateIgnored] > 0
+
+	var rows []updateRow
+	for _, class := range []updateClass{updateAvailable, updateHeld, updatePinned, updateIgnored} {
+		if counts[class] == 0 {
+			continue
+		}
+		if sectioned {
+			rows = append(rows, updateRow{header: true, class: class, count: counts[class]})
+			if m.updateCollapsed[class] {
+				continue
+			}
+		}
+		for _, u := range m.classifiedUpdates {
+			if u.class == class {
+				rows = append(rows, updateRow{class: class, pkg: u.pkg})
+			}
+		}
+	}
+	return rows
+}
+
+// toggleHold holds an available update or releases a held one, saving the
+// hold list to the config file
+func (m *model) toggleHold(name string) error {
+	for i, u := range m.classifiedUpdates {
+		if u.pkg.Name != name {
+			continue
+		}
+		switch u.class {
+		case updateAvailable:
+			m.holds[name] = true
+			m.classifiedUpdates[i].class = updateHeld
+		case updateHeld:
+			delete(m.holds, name)
+			m.classifiedUpdates[i].class = updateAvailable
+			delete(m.confirmExcluded, name)
+		case updatePinned:
+			return fmt.Errorf("%s is pinned at %s; unpin it with [p]", name, m.pins[name])
+		default:
+			return fmt.Errorf("%s is ignored in %s", name, pacmanConfPath)
+		}
+	}
+	m.setPendingUpdates()
+	return saveConfigValues(m.configPath, map[string]string{"holds": formatTOMLStringArray(sortedKeys(m.holds))})
+}
+
+// togglePin pins an available update at its installed version or unpins a
+// pinned one, saving the pins to the config file. An unpinned package that
+// is also held stays back as held.
+func (m *model) togglePin(name string) error {
+	for i, u := range m.classifiedUpdates {
+		if u.pkg.Name != name {
+			continue
+		}
+		switch u.class {
+		case updateAvailable, updateHeld:
+			m.pins[name] = u.pkg.OldVersion
+			m.classifiedUpdates[i].class = updatePinned
+			delete(m.confirmExcluded, name)
+		case updatePinned:
+			delete(m.pins, name)
+			m.classifiedUpdates[i].class = updateAvailable
+			if m.holds[name] {
+				m.classifiedUpdates[i].class = updateHeld
+			}
+		default:
+			return fmt.Errorf("%s is ignored in %s", name, pacmanConfPath)
+		}
+	}
+	m.setPendingUpdates()
+	return saveConfigValues(m.configPath, map[string]string{"pins": formatTOMLStringArray(formatPins(m.pins))})
+}
+
+// parsePins reads the config's "name=version" pins, skipping malformed ones
+func parsePins(entries []string) map[string]string {
+	pins := make(map[string]string, len(entries))
+	for _, entry := range entries {
+		name, version, ok := strings.Cut(entry, "=")
+		if ok && isValidPackageName(name) && version != "" {
+			pins[name] = version
+		}
+	}
+	return pins
+}
+
+// formatPins renders pins as sorted "name=version" entries for the config
+func formatPins(pins map[string]string) []string {
+	entries := make([]string, 0, len(pins))
+	for _, name := range sortedKeys(pins) {
+		entries = append(entries, name+"="+pins[name])
+	}
+	return entries
+}
+
+// AUR RPC endpoints for package details and searches
+const (
+	aurRPCInfoURL   = "https://aur.archlinux.org/rpc/v5/info"
+	aurRPCSearchURL = "https://aur.archlinux.org/rpc/v5/search/"
+)
+
+const (
+	aurDependencyMaxDepth = 5                // Longest dependency chain followed
+	aurInfoBatchSize      = 100              // Names per /info request
+	aurRPCTimeout         = 10 * time.Second // Per-request timeout
+	aurSearchCacheTTL     = 5 * time.Minute  // How long search results are reused
+)
+
+// aurPackageInfo is the subset of an AUR /info result used for dependency
+// checks and the info panel
+type aurPackageInfo struct {
+	Name           string   `json:"Name"`
+	PackageBase    string   `json:"PackageBase"`
+	Version        string   `json:"Version"`
+	Description    string   `json:"Description"`
+	URL            string   `json:"URL"`
+	Maintainer     string   `json:"Maintainer"`
+	NumVotes       int      `json:"NumVotes"`
+	Popularity     float64  `json:"Popularity"`
+	OutOfDate      *int64   `json:"OutOfDate"`
+	FirstSubmitted int64    `json:"FirstSubmitted"`
+	LastModified   int64    `json:"LastModified"`
+	Depends        []string `json:"Depends"`
+	MakeDepends    []string `json:"MakeDepends"`
+	OptDepends     []string `json:"OptDepends"`
+	Provides       []string `json:"Provides"`
+	Conflicts      []string `json:"Conflicts"`
+	License        []string `json:"License"`
+	Keywords       []string `json:"Keywords"`
+}
+
+// aurDependencyIssue is an AUR dependency that is likely to break an update,
+// or a problem with the updated package itself. chain runs from the updated
+// package to the problematic dependency.
+type aurDependencyIssue struct {
+	chain   []string
+	problem string // "out-of-date" or "missing"; "flagged out-of-date" or "orphaned" for the package itself
+}
+
+// aurDependencyCheckMsg carries dependency issues keyed by updated package name
+type aurDependencyCheckMsg struct {
+	issues map[string][]aurDependencyIssue
+	seq    int // Sequence number of the check, see model.aurDepCheckSeq
+	err    error
+}
+
+// aurClient talks to the AUR RPC interface. Search results are cached per
+// query for aurSearchCacheTTL so repeated searches don't refetch.
+type aurClient struct {
+	http        *http.Client
+	mu          sync.Mutex
+	cache       map[string]aurSearchCacheEntry
+	concurrency int // /info batches requested at once, see Settings.PrefetchConcurrency
+}
+
+// aurSearchCacheEntry is a cached search result
+type aurSearchCacheEntry struct {
+	packages []Package
+	fetched  time.Time
+}
+
+// aurRPC is the shared AUR RPC client
+var aurRPC = &aurClient{
+	http:        &http.Client{Timeout: aurRPCTimeout},
+	cache:       make(map[string]aurSearchCacheEntry),
+	concurrency: defaultPrefetchConcurrency,
+}
+
+// setConcurrency sets how many /info batches are requested at once
+func (c *aurClient) setConcurrency(n int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.concurrency = max(n, 1)
+}
+
+// batchConcurrency returns how many /info batches are requested at once
+func (c *aurClient) batchConcurrency() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return max(c.concurrency, 1)
+}
+
+// get calls an RPC endpoint and decodes its results
+func (c *aurClient) get(ctx context.Context, endpoint string) ([]aurPackageInfo, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	var result struct {
+		Results []aurPackageInfo `json:"results"`
+		Error   string           `json:"error"`
+	}
+	if resp.StatusCode != http.StatusOK {
+		// Rejected requests, such as rate limited ones, may explain
+		// themselves in a JSON body; proxies send HTML instead
+		if json.NewDecoder(io.LimitReader(resp.Body, 64*1024)).Decode(&result) == nil && result.Error != "" {
+			return nil, fmt.Errorf("aur rpc: %s: %s", resp.Status, result.Error)
+		}
+		return nil, fmt.Errorf("aur rpc: %s", resp.Status)
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return nil, err
+	}
+	if result.Error != "" {
+		return nil, fmt.Errorf("aur rpc: %s", result.Error)
+	}
+	return result.Results, nil
+}
+
+// info requests details for up to aurInfoBatchSize packages
+func (c *aurClient) info(ctx context.Context, names []string) ([]aurPackageInfo, error) {
+	params := url.Values{}
+	for _, name := range names {
+		params.Add("arg[]", name)
+	}
+	return c.get(ctx, aurRPCInfoURL+"?"+params.Encode())
+}
+
+// search finds AUR packages by name and description
+func (c *aurClient) search(ctx context.Context, query string) ([]Package, error) {
+	c.mu.Lock()
+	entry, ok := c.cache[query]
+	c.mu.Unlock()
+	if ok && time.Since(entry.fetched) < aurSearchCacheTTL {
+		return append([]Package(nil), entry.packages...), nil
+	}
+
+	results, err := c.get(ctx, aurRPCSearchURL+url.PathEscape(query)+"?by=name-desc")
+	if err != nil {
+		return nil, err
+	}
+	packages := make([]Package, len(results))
+	for i, info := range results {
+		packages[i] = info.toPackage()
+	}
+
+	c.mu.Lock()
+	for q, e := range c.cache {
+		if time.Since(e.fetched) >= aurSearchCacheTTL {
+			delete(c.cache, q)
+		}
+	}
+	c.cache[query] = aurSearchCacheEntry{packages: packages, fetched: time.Now()}
+	c.mu.Unlock()
+	return append([]Package(nil), packages...), nil
+}
+
+// toPackage converts an RPC result into a search result
+func (info aurPackageInfo) toPackage() Package {
+	pkg := Package{
+		Source:       "aur",
+		Name:         info.Name,
+		Version:      info.Version,
+		Description:  info.Description,
+		Votes:        info.NumVotes,
+		Popularity:   info.Popularity,
+		Maintainer:   info.Maintainer,
+		Orphaned:     info.Maintainer == "",
+		LastModified: info.LastModified,
+	}
+	if info.OutOfDate != nil {
+		pkg.OutOfDate = *info.OutOfDate
+	}
+	return pkg
+}
+
+// aurInfoResolver fetches AUR package details on demand and memoizes them, so
+// dependencies shared between update entries are only requested once
+type aurInfoResolver struct {
+	known map[string]*aurPackageInfo // nil entry: not in the AUR
+}
+
+// fetch requests every name not seen before from the AUR in batches, up to
+// the client's concurrency at once. Batches that succeed are kept when
+// another fails; the first error is returned.
+func (r *aurInfoResolver) fetch(names []string) error {
+	var missing []string
+	for _, name := range names {
+		if _, ok := r.known[name]; !ok {
+			missing = append(missing, name)
+		}
+	}
+
+	var (
+		wg       sync.WaitGroup
+		mu       sync.Mutex
+		firstErr error
+	)
+	slots := make(chan struct{}, aurRPC.batchConcurrency())
+	for _, batch := range splitIntoBatches(missing, aurInfoBatchSize) {
+		wg.Add(1)
+		slots <- struct{}{}
+		go func(batch []string) {
+			defer wg.Done()
+			defer func() { <-slots }()
+			results, err := aurRPC.info(context.Background(), batch)
+
+			mu.Lock()
+			defer mu.Unlock()
+			if err != nil {
+				if firstErr == nil {
+					firstErr = err
+				}
+				return
+			}
+			for _, name := range batch {
+				r.known[name] = nil
+			}
+			for i := range results {
+				info := results[i]
+				r.known[info.Name] = &info
+			}
+		}(batch)
+	}
+	wg.Wait()
+	return firstErr
+}
+
+// aurHydrateWindow is how many AUR search results get their details fetched
+// in one /info request
+const aurHydrateWindow = 50
+
+// aurInfoHydratedMsg carries AUR details for search results
+type aurInfoHydratedMsg struct {
+	names []string // Names requested
+	infos map[string]*aurPackageInfo
+	err   error
+}
+
+// hydrateAURInfo fetches details for AUR packages with a single batched
+// /info request, so the info panel doesn't need paru -Si for them
+func hydrateAURInfo(names []string) tea.Cmd {
+	return func() tea.Msg {
+		resolver := &aurInfoResolver{
+			known:  make(map[string]*aurPackageInfo),
+		}
+		if err := resolver.fetch(names); err != nil {
+			return aurInfoHydratedMsg{names: names, err: err}
+		}
+		infos := make(map[string]*aurPackageInfo, len(names))
+		for name, info := range resolver.known {
+			if info != nil {
+				infos[name] = info
+			}
+		}
+		return aurInfoHydratedMsg{names: names, infos: infos}
+	}
+}
+
+// hydrateResultWindow requests details for the AUR results from the top of
+// the results window on, once the window shows one that is neither cached
+// nor already requested. Up to aurHydrateWindow names are asked for, so the
+// results just below the window are ready when they scroll into view.
+func (m *model) hydrateResultWindow() tea.Cmd {
+	needed := func(pkg Package) bool {
+		return pkg.Source == "aur" && m.aurInfoCache[pkg.Name] == nil && !m.aurHydrating[pkg.Name] && isValidPackageName(pkg.Name)
+	}
+	_, resultsHeight := m.mainLayout()
+	start, end := resultsWindow(m.selectedIndex, len(m.filtered), resultsHeight, m.resultsStart)
+	missing := false
+	for _, pkg := range m.filtered[start:end] {
+		missing = missing || needed(pkg)
+	}
+	if !missing {
+		return nil
+	}
+
+	var names []string
+	for _, pkg := range m.filtered[start:] {
+		if len(names) == aurHydrateWindow {
+			break
+		}
+		if needed(pkg) {
+			names = append(names, pkg.Name)
+		}
+	}
+	if m.aurHydrating == nil {
+		m.aurHydrating = make(map[string]bool)
+	}
+	for _, name := range names {
+		m.aurHydrating[name] = true
+	}
+	return hydrateAURInfo(names)
+}
+
+// packageInfoCmd loads the info panel for a package, answering AUR packages
+// from the hydrated RPC details when available instead of running paru -Si
+func (m model) packageInfoCmd(pkg Package) tea.Cmd {
+	if pkg.Source == "aur" {
+		if info := m.aurInfoCache[pkg.Name]; info != nil {
+			return func() tea.Msg {
+				return packageInfoMsg{info: formatAURInfo(info), packageName: pkg.Name}
+			}
+		}
+	}
+	return getPackageInfo(m.runner, m.displayRunner, pkg)
+}
+
+// formatAURInfo renders AUR details in the same layout as paru -Si
+func formatAURInfo(info *aurPackageInfo) string {
+	list := func(values []string) string {
+		if len(values) == 0 {
+			return "None"
+		}
+		return strings.Join(values, "  ")
+	}
+	date := func(unix int64) string {
+		if unix == 0 {
+			return "None"
+		}
+		return time.Unix(unix, 0).Format("Mon 02 Jan 2006 03:04:05 PM MST")
+	}
+	or := func(value, fallback string) string {
+		if value == "" {
+			return fallback
+		}
+		return value
+	}
+	outOfDate := "No"
+	if info.OutOfDate != nil {
+		outOfDate = "Yes [" + date(*info.OutOfDate) + "]"
+	}
+
+	fields := []struct{ key, value string }{
+		{"Repository", "aur"},
+		{"Name", info.Name},
+		{"Version", info.Version},
+		{"Description", or(info.Description, "None")},
+		{"URL", or(info.URL, "None")},
+		{"AUR URL", "https://aur.archlinux.org/packages/" + info.Name},
+		{"Keywords", list(info.Keywords)},
+		{"Licenses", list(info.License)},
+		{"Provides", list(info.Provides)},
+		{"Depends On", list(info.Depends)},
+		{"Make Deps", list(info.MakeDepends)},
+		{"Optional Deps", list(info.OptDepends)},
+		{"Conflicts With", list(info.Conflicts)},
+		{"Maintainer", or(info.Maintainer, "None")},
+		{"Votes", strconv.Itoa(info.NumVotes)},
+		{"Popularity", strconv.FormatFloat(info.Popularity, 'f', 2, 64)},
+		{"First Submitted", date(info.FirstSubmitted)},
+		{"Last Modified", date(info.LastModified)},
+		{"Out Of Date", outOfDate},
+	}
+	var b strings.Builder
+	for _, f := range fields {
+		b.WriteString(fmt.Sprintf("%-16s: %s\n", f.key, f.value))
+	}
+	return b.String()
+}
+
+// dependencyName strips a version constraint such as ">=1.2" from a dependency
+func dependencyName(dep string) string {
+	if i := strings.IndexAny(dep, "<>="); i >= 0 {
+		return dep[:i]
+	}
+	return dep
+}
+
+// unsatisfiedDependencies returns the deps not satisfied by any installed
+// package, including provides, using pacman -T
+func unsatisfiedDependencies(r Runner, deps []string) map[string]bool {
+	unsatisfied := make(map[string]bool)
+	if len(deps) == 0 {
+		return unsatisfied
+	}
+	out, _, _ := r.Run("pacman", append([]string{"-T"}, deps...)...) // Exits 127 when anything is unsatisfied
+	for _, line := range strings.Split(out, "\n") {
+		if line = strings.TrimSpace(line); line != "" {
+			unsatisfied[line] = true
+		}
+	}
+	return unsatisfied
+}
+
+// syncUnavailable returns the deps no sync database package satisfies, under
+// its own name or as a provision such as java-runtime or libfoo.so. pacman -Sp
+// reports every target it can't find before giving up; any other failure
+// means the targets were found.
+func syncUnavailable(r Runner, deps []string) map[string]bool {
+	unavailable := make(map[string]bool)
+	if len(deps) == 0 {
+		return unavailable
+	}
+	_, stderr, err := r.Run("pacman", append([]string{"-Sp", "--noconfirm", "--print-format", "%n"}, deps...)...)
+	if err == nil {
+		return unavailable
+	}
+	for _, line := range strings.Split(stderr, "\n") {
+		if dep, ok := strings.CutPrefix(strings.TrimSpace(line), "error: target not found: "); ok {
+			unavailable[dep] = true
+		}
+	}
+	return unavailable
+}
+
+// checkAURDependencies walks the AUR dependency closure of each AUR update, up to
+// aurDependencyMaxDepth levels, and reports dependencies that are flagged
+// out-of-date or no longer exist. Names in repoNames come from the sync
+// databases and are not followed. seq is returned in the message.
+func checkAURDependencies(r Runner, updates []string, repoNames map[string][]int, seq int) tea.Cmd {
+	return func() tea.Msg {
+		resolver := &aurInfoResolver{
+			known: make(map[string]*aurPackageInfo),
+		}
+
+		type node struct {
+			name  string
+			chain []string
+		}
+
+		issues := make(map[string][]aurDependencyIssue)
+		var notFound []node
+		for _, root := range updates {
+			visited := map[string]bool{root: true}
+			frontier := []node{{name: root, chain: []string{root}}}
+			for depth := 0; depth < aurDependencyMaxDepth && len(frontier) > 0; depth++ {
+				names := make([]string, len(frontier))
+				for i, n := range frontier {
+					names[i] = n.name
+				}
+				if err := resolver.fetch(names); err != nil {
+					return aurDependencyCheckMsg{seq: seq, err: err}
+				}
+
+				var next []node
+				for _, n := range frontier {
+					info := resolver.known[n.name]
+					if info == nil {
+						if depth > 0 {
+							notFound = append(notFound, n)
+						}
+						continue
+					}
+					if depth > 0 && info.OutOfDate != nil {
+						issues[root] = append(issues[root], aurDependencyIssue{chain: n.chain, problem: "out-of-date"})
+					}
+					// The update itself may be what's stale
+					if depth == 0 && info.OutOfDate != nil {
+						issues[root] = append(issues[root], aurDependencyIssue{chain: n.chain, problem: "flagged out-of-date"})
+					}
+					if depth == 0 && info.Maintainer == "" {
+						issues[root] = append(issues[root], aurDependencyIssue{chain: n.chain, problem: "orphaned"})
+					}
+					deps := append(append([]string{}, info.Depends...), info.MakeDepends...)
+					for _, dep := range deps {
+						name := dependencyName(dep)
+						if visited[name] || len(repoNames[name]) > 0 {
+							continue
+						}
+						visited[name] = true
+						chain := append(append([]string{}, n.chain...), name)
+						next = append(next, node{name: name, chain: chain})
+					}
+				}
+				frontier = next
+			}
+		}
+
+		// Names absent from both the repos and the AUR may still be provided by
+		// an installed package, or by a sync database package under another
+		// name: a virtual package or a soname
+		var candidates []string
+		for _, n := range notFound {
+			candidates = append(candidates, n.name)
+		}
+		var uninstalled []string
+		for name := range unsatisfiedDependencies(r, candidates) {
+			uninstalled = append(uninstalled, name)
+		}
+		sort.Strings(uninstalled)
+		unavailable := syncUnavailable(r, uninstalled)
+		for _, n := range notFound {
+			if unavailable[n.name] {
+				issues[n.chain[0]] = append(issues[n.chain[0]], aurDependencyIssue{chain: n.chain, problem: "missing"})
+			}
+		}
+
+		return aurDependencyCheckMsg{issues: issues, seq: seq}
+	}
+}
+
+// buildLogKeep is how many build logs are kept in the log directory
+const buildLogKeep = 20
+
+// buildLogDir returns where the output of install and update runs is kept
+func buildLogDir() string {
+	return filepath.Join(stateDir(), "logs")
+}
+
+// buildFailurePattern recognizes lines that usually explain a failed build
+type buildFailurePattern struct {
+	name string
+	re   *regexp.Regexp
+}
+
+// buildFailurePatterns are checked against every log line; the browser jumps
+// to the first line matching any of them
+var buildFailurePatterns = []buildFailurePattern{
+	{"checksum mismatch", regexp.MustCompile(`(?i)(validity check|checksums? (did not match|failed|mismatch)|\.\.\. FAILED$)`)},
+	{"rust error", regexp.MustCompile(`^error(\[E\d+\])?: `)},
+	{"compiler error", regexp.MustCompile(`(?i):\d+:\d+: (fatal )?error: `)},
+	{"undefined reference", regexp.MustCompile(`undefined reference to`)},
+	{"cmake error", regexp.MustCompile(`^CMake Error`)},
+	{"make failure", regexp.MustCompile(`make(\[\d+\])?: \*\*\* `)},
+	{"test failure", regexp.MustCompile(`\bFAILED\b`)},
+	{"makepkg error", regexp.MustCompile(`^==> ERROR: `)},
+	{"error", regexp.MustCompile(`(?i)^error: |\berror: `)},
+}
+
+// ansiEscape matches terminal color and cursor sequences
+var ansiEscape = regexp.MustCompile(`\x1b\[[0-9;?]*[A-Za-z]`)
+
+// makingPackage matches makepkg's banner at the start of each package build
+var makingPackage = regexp.MustCompile(`^==> Making package: (\S+)`)
+
+// buildFailureMatch is a log line matching a failure pattern
+type buildFailureMatch struct {
+	line    int
+	pattern string
+}
+
+// findBuildFailures returns the lines matching a failure pattern, in log order.
+// A line is reported once, under the first pattern in the table that matches.
+func findBuildFailures(lines []string) []buildFailureMatch {
+	var matches []buildFailureMatch
+	for i, line := range lines {
+		for _, p := range buildFailurePatterns {
+			if p.re.MatchString(line) {
+				matches = append(matches, buildFailureMatch{line: i, pattern: p.name})
+				break
+			}
+		}
+	}
+	return matches
+}
+
+// buildLogPackageAt returns the package being built at a log line
+func buildLogPackageAt(lines []string, line int) string {
+	for i := line; i >= 0 && i < len(lines); i-- {
+		if m := makingPackage.FindStringSubmatch(lines[i]); m != nil {
+			return m[1]
+		}
+	}
+	return ""
+}
+
+// attachBuildLog records the command's output into a new log file, keeping
+// it on the terminal. It returns "" when the output can't be recorded.
+func attachBuildLog(c *exec.Cmd, name string) string {
+	f, err := createBuildLog(name)
+	if err != nil {
+		return ""
+	}
+	f.Close()
+	if !recordTerminal(c, f.Name()) {
+		os.Remove(f.Name())
+		return ""
+	}
+	return f.Name()
+}
+
+// recordTerminal rewrites c to run under script(1), which gives it a
+// pseudo-terminal of its own and copies everything written there to path.
+// The command still sees a TTY, so prompts, colors and progress bars work
+// as when it runs on the terminal directly. Without script, c is left alone
+// and false is returned.
+func recordTerminal(c *exec.Cmd, path string) bool {
+	script, err := exec.LookPath("script")
+	if err != nil || c.Err != nil {
+		return false
+	}
+	quoted := make([]string, len(c.Args))
+	for i, arg := range c.Args {
+		quoted[i] = shellQuote(arg)
+	}
+	c.Path = script
+	c.Args = []string{"script", "--quiet", "--return", "--flush", "--command", strings.Join(quoted, " "), path}
+	return true
+}
+
+// shellQuote quotes s as a single sh word
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+// scriptBanner reports whether line is the header or footer script(1)
+// writes around a recording
+func scriptBanner(line string) bool {
+	return strings.HasPrefix(line, "Script started on ") || strings.HasPrefix(line, "Script done on ")
+}
+
+// errorOutputLines is how many lines of a failed command's output the
+// error overlay keeps
+const errorOutputLines = 30
+
+// captureOutput records c's output to a temporary file, as the terminal it
+// printed to is gone once gaur takes the screen back. The returned function
+// removes the file and, when err is set, returns the last lines written.
+func captureOutput(c *exec.Cmd) func(err error) []string {
+	f, err := os.CreateTemp("", "gaur-output-*.log")
+	if err != nil {
+		return func(error) []string { return nil }
+	}
+	f.Close()
+	if !recordTerminal(c, f.Name()) {
+		os.Remove(f.Name())
+		return func(error) []string { return nil }
+	}
+	return func(err error) []string {
+		defer os.Remove(f.Name())
+		if err == nil {
+			return nil
+		}
+		return readOutputTail(f.Name(), errorOutputLines)
+	}
+}
+
+// readOutputTail returns the last n non-empty lines of a command's output
+// file, without color codes
+func readOutputTail(path string, n int) []string {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil
+	}
+	text := ansiEscape.ReplaceAllString(string(data), "")
+	var lines []string
+	for _, line := range strings.FieldsFunc(text, func(r rune) bool { return r == '\n' || r == '\r' }) {
+		if strings.TrimSpace(line) != "" && !scriptBanner(line) {
+			lines = append(lines, strings.ReplaceAll(line, "\t", "    "))
+		}
+	}
+	if len(lines) > n {
+		lines = lines[len(lines)-n:]
+	}
+	return lines
+}
+
+// setErrorOutput shows a failed command's output in the error overlay,
+// scrolled to its end
+func (m *model) setErrorOutput(output []string) {
+	m.errorOutput = output
+	m.errorOutputEnd = len(output)
+}
+
+// retryFailed runs the operation shown in the error overlay or build log
+// again, when it can be
+func (m model) retryFailed() (tea.Model, tea.Cmd) {
+	if m.retry == nil {
+		m.statusMessage = "This operation can't be retried"
+		return m, nil
+	}
+	retry := m.retry
+	m.retry = nil
+	m.retryOffered = false
+	m.showErrorOverlay = false
+	m.errorTitle = ""
+	m.errorMessage = ""
+	m.errorDetails = ""
+	m.errorOutput = nil
+	m.showBuildLog = false
+	m.statusMessage = fmt.Sprintf("Retrying %s...", strings.ToLower(operationName(m.retryOp)))
+	if len(m.retryPackages) > 0 {
+		m.statusMessage = fmt.Sprintf("Retrying %s of %d package(s)...", strings.ToLower(operationName(m.retryOp)), len(m.retryPackages))
+	}
+	return m, retry()
+}
+
+// failureDetails describes a failed command for the error overlay, pointing
+// at the terminal only when none of its output was captured
+func failureDetails(err error, output []string) string {
+	details := fmt.Sprintf("Error: %v", err)
+	if exitErr, ok := err.(*exec.ExitError); ok {
+		details = fmt.Sprintf("Exit code: %d", exitErr.ExitCode())
+	}
+	if len(output) == 0 {
+		details += "\n\nThe error output was displayed in the terminal.\nPlease check the terminal output for details."
+	}
+	return details
+}
+
+// createBuildLog creates a new timestamped log file, pruning the oldest
+func createBuildLog(name string) (*os.File, error) {
+	dir := buildLogDir()
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	pruneBuildLogs(dir, buildLogKeep-1)
+	return os.Create(filepath.Join(dir, time.Now().Format("2006-01-02T15-04-05")+"-"+name+".log"))
+}
+
+// pruneBuildLogs removes the oldest logs so at most keep remain
+func pruneBuildLogs(dir string, keep int) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return
+	}
+	var logs []string
+	for _, entry := range entries {
+		if !entry.IsDir() && strings.HasSuffix(entry.Name(), ".log") {
+			logs = append(logs, entry.Name())
+		}
+	}
+	sort.Strings(logs) // Timestamped names sort oldest first
+	for len(logs) > keep {
+		os.Remove(filepath.Join(dir, logs[0]))
+		logs = logs[1:]
+	}
+}
+
+// buildLogBrowser is the state of the build log viewer
+type buildLogBrowser struct {
+	path       string
+	lines      []string
+	matches    []buildFailureMatch
+	matchIndex int
+	cursor     int
+}
+
+// openBuildLog loads a log into the browser and jumps to the first failure.
+// It returns false when the log is missing or empty.
+func (m *model) openBuildLog(path string) bool {
+	data, err := os.ReadFile(path)
+	if err != nil || len(bytes.TrimSpace(data)) == 0 {
+		return false
+	}
+	text := ansiEscape.ReplaceAllString(string(data), "")
+	text = strings.ReplaceAll(text, "\r\n", "\n")
+	var lines []string
+	for _, line := range strings.FieldsFunc(text, func(r rune) bool { return r == '\n' || r == '\r' }) {
+		if !scriptBanner(line) {
+			lines = append(lines, line)
+		}
+	}
+
+	m.buildLog = buildLogBrowser{path: path, lines: lines, matches: findBuildFailures(lines)}
+	if len(m.buildLog.matches) > 0 {
+		m.buildLog.cursor = m.buildLog.matches[0].line
+	} else {
+		m.buildLog.cursor = len(lines) - 1
+	}
+	m.showBuildLog = true
+	return true
+}
+
+// clipboardMsg reports how copyToClipboard copied what, and the error of a
+// clipboard tool that failed
+type clipboardMsg struct {
+	what string
+	via  string
+	err  error
+}
+
+// copyToClipboard copies text with the first available clipboard tool. With
+// none, or when it fails, the terminal is asked to copy through an OSC 52
+// escape sequence, which can't report whether it worked. It runs as a command
+// so the tools don't hold up Update; what describes text in the status line.
+func copyToClipboard(text, what string) tea.Cmd {
+	return func() tea.Msg {
+		tools := [][]string{
+			{"wl-copy"},
+			{"xclip", "-selection", "clipboard"},
+			{"xsel", "--clipboard", "--input"},
+		}
+		var err error
+		for _, tool := range tools {
+			if _, lookErr := exec.LookPath(tool[0]); lookErr != nil {
+				continue
+			}
+			cmd := exec.Command(tool[0], tool[1:]...)
+			cmd.Stdin = strings.NewReader(text)
+			start := time.Now()
+			err = cmd.Run()
+			logExec(cmd, start, err)
+			if err == nil {
+				return clipboardMsg{what: what, via: tool[0]}
+			}
+			err = fmt.Errorf("%s: %w", tool[0], err)
+			break
+		}
+		termenv.Copy(text)
+		return clipboardMsg{what: what, via: "OSC 52", err: err}
+	}
+}
+
+// clipboardStatus describes the outcome of a copy for the status line. An
+// OSC 52 copy is only a request to the terminal, so it isn't called copied.
+func clipboardStatus(msg clipboardMsg) string {
+	switch {
+	case msg.err != nil:
+		return fmt.Sprintf("Copy failed (%v), asked the terminal to copy %s instead", msg.err, msg.what)
+	case msg.via == "OSC 52":
+		return "Asked the terminal to copy " + msg.what
+	}
+	return "Copied " + msg.what
+}
+
+// yankPackages copies the marked packages, or the selected one, to the
+// clipboard: their names, or with command set the command installing them
+func (m *model) yankPackages(command bool) tea.Cmd {
+	var names []string
+	for name := range m.markedPackages {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	if len(names) == 0 {
+		pkg := m.selectedPackage()
+		if pkg == nil {
+			m.statusMessage = "No package selected"
+			return nil
+		}
+		names = []string{pkg.Name}
+	}
+	text := strings.Join(names, " ")
+	if command {
+		text = strings.Join(helper.transaction(append([]string{"-S"}, names...)...), " ")
+	}
+	return copyToClipboard(text, text)
+}
+
+// browserOpenedMsg reports whether a browser could be started for url
+type browserOpenedMsg struct {
+	url string
+	err error
+}
+
+// openInBrowser hands url to xdg-open without waiting for the browser. Its
+// output is discarded so it can't draw over the interface.
+func openInBrowser(url string) tea.Cmd {
+	return func() tea.Msg {
+		cmd := exec.Command("xdg-open", url)
+		start := time.Now()
+		if err := cmd.Start(); err != nil {
+			logExec(cmd, start, err)
+			return browserOpenedMsg{url: url, err: err}
+		}
+		go func() {
+			// Reap xdg-open once it hands over
+			logExec(cmd, start, cmd.Wait())
+		}()
+		return browserOpenedMsg{url: url}
+	}
+}
+
+// packagePageURL returns the web page of a package on the AUR or
+// archlinux.org, or "" for sources that have none
+func packagePageURL(pkg Package) string {
+	switch pkg.Source {
+	case "aur":
+		return "https://aur.archlinux.org/packages/" + url.PathEscape(pkg.Name)
+	case "core", "extra", "multilib", "core-testing", "extra-testing", "multilib-testing":
+		return "https://archlinux.org/packages/?name=" + url.QueryEscape(pkg.Name)
+	}
+	return ""
+}
+
+// infoPanelPackage returns the package the info panel describes: the selected
+// one, or the dependency followed from it
+func (m model) infoPanelPackage() (Package, bool) {
+	selected := m.selectedPackage()
+	if m.infoForPackage == "" || (selected != nil && selected.Name == m.infoForPackage) {
+		if selected == nil {
+			return Package{}, false
+		}
+		return *selected, true
+	}
+	return m.resolvePackage(m.infoForPackage)
+}
+
+// openPackageURL opens the upstream URL of the package in the info panel, or
+// with page set its AUR or archlinux.org page
+func (m *model) openPackageURL(page bool) tea.Cmd {
+	pkg, ok := m.infoPanelPackage()
+	if !ok {
+		m.statusMessage = "No package selected"
+		return nil
+	}
+	if page {
+		link := packagePageURL(pkg)
+		if link == "" {
+			m.statusMessage = fmt.Sprintf("%s/%s has no package page", pkg.Source, pkg.Name)
+			return nil
+		}
+		return openInBrowser(link)
+	}
+	if m.loadingInfo {
+		m.statusMessage = fmt.Sprintf("Details for %s are still loading", pkg.Name)
+		return nil
+	}
+	if !m.packageDetailsOK || m.packageDetails.URL == "" {
+		m.statusMessage = fmt.Sprintf("%s has no upstream URL", pkg.Name)
+		return nil
+	}
+	return openInBrowser(m.packageDetails.URL)
+}
+
+type editorClosedMsg struct{ err error }
+
+// openInEditor opens path at line in $EDITOR using tea.ExecProcess
+func openInEditor(path string, line int) tea.Cmd {
+	editor := os.Getenv("EDITOR")
+	if editor == "" {
+		editor = "vi"
+	}
+	args := strings.Fields(editor)
+	args = append(args, fmt.Sprintf("+%d", line+1), path)
+	c := exec.Command(args[0], args[1:]...)
+	return execProcess(c, func(err error) tea.Msg {
+		return editorClosedMsg{err: err}
+	})
+}
+
+// handleBuildLogKeys handles scrolling, match cycling and actions in the build log browser
+func (m model) handleBuildLogKeys(msg tea.KeyMsg) (tea.Model, tea.Cmd) {
+	b := &m.buildLog
+	page := m.height - 12
+	if page < 1 {
+		page = 1
+	}
+	switch msg.String() {
+	case "esc", "q":
+		m.showBuildLog = false
+		m.buildLog = buildLogBrowser{}
+		m.retry = nil
+	case "r":
+		// Run the failed install or update again
+		if m.retry != nil {
+			m.buildLog = buildLogBrowser{}
+			return m.retryFailed()
+		}
+	case "down", "j":
+		b.cursor++
+	case "up", "k":
+		b.cursor--
+	case "pgdown", "ctrl+d":
+		b.cursor += page
+	case "pgup", "ctrl+u":
+		b.cursor -= page
+	case "g", "home":
+		b.cursor = 0
+	case "G", "end":
+		b.cursor = len(b.lines) - 1
+	case "n", "N":
+		if len(b.matches) > 0 {
+			if msg.String() == "n" {
+				b.matchIndex = (b.matchIndex + 1) % len(b.matches)
+			} else {
+				b.matchIndex = (b.matchIndex - 1 + len(b.matches)) % len(b.matches)
+			}
+			b.cursor = b.matches[b.matchIndex].line
+		}
+	case "y":
+		return m, copyToClipboard(b.path, "the log path")
+	case "e":
+		return m, openInEditor(b.path, b.cursor)
+	}
+	if b.cursor >= len(b.lines) {
+		b.cursor = len(b.lines) - 1
+	}
+	if b.cursor < 0 {
+		b.cursor = 0
+	}
+	return m, nil
+}
+
+// renderBuildLogOverlay renders the build log around the cursor with failure
+// lines highlighted
+func (m model) renderBuildLogOverlay(contentWidth, contentHeight int, activeColor lipgloss.Color) string {
+	b := m.buildLog
+	dialogWidth := contentWidth - 4
+	visible := contentHeight - 10
+	if visible < 3 {
+		visible = 3
+	}
+
+	titleStyle := lipgloss.NewStyle().
+		Bold(true).
+		Foreground(activeColor)
+	subtleStyle := lipgloss.NewStyle().
+		Foreground(currentTheme.SubtleColor)
+	matchStyle := lipgloss.NewStyle().
+		Foreground(currentTheme.WarningColor).
+		Bold(true)
+	cursorStyle := lipgloss.NewStyle().
+		Foreground(activeColor).
+		Bold(true)
+	keyStyle := lipgloss.NewStyle().
+		Foreground(activeColor).
+		Bold(true)
+	dialogBorderStyle := lipgloss.NewStyle().
+		Border(lipgloss.RoundedBorder()).
+		BorderForeground(activeColor).
+		Padding(0, 1)
+
+	var content strings.Builder
+	title := "📜 Build Log"
+	if pkg := buildLogPackageAt(b.lines, b.cursor); pkg != "" {
+		title += " — " + pkg
+	}
+	content.WriteString(titleStyle.Render(title))
+	content.WriteString("\n")
+	if len(b.matches) > 0 {
+		match := b.matches[b.matchIndex]
+		content.WriteString(subtleStyle.Render(fmt.Sprintf("Match %d of %d: %s (line %d)", b.matchIndex+1, len(b.matches), match.pattern, match.line+1)))
+	} else {
+		content.WriteString(subtleStyle.Render("No known failure patterns found - showing the end of the log"))
+	}
+	content.WriteString("\n\n")
+
+	isMatch := make(map[int]bool, len(b.matches))
+	for _, match := range b.matches {
+		isMatch[match.line] = true
+	}
+
+	// Keep the cursor line in the middle of the window
+	start := b.cursor - visible/2
+	if start > len(b.lines)-visible {
+		start = len(b.lines) - visible
+	}
+	if start < 0 {
+		start = 0
+	}
+	end := start + visible
+	if end > len(b.lines) {
+		end = len(b.lines)
+	}
+	lineWidth := dialogWidth - 12
+	if lineWidth < 10 {
+		lineWidth = 10
+	}
+	for i := start; i < end; i++ {
+		text := truncateRunes(strings.ReplaceAll(b.lines[i], "\t", "    "), lineWidth)
+		marker := "  "
+		if i == b.cursor {
+			marker = cursorStyle.Render("> ")
+		}
+		if isMatch[i] {
+			text = matchStyle.Render(text)
+		}
+		content.WriteString(fmt.Sprintf("%s%s %s\n", marker, subtleStyle.Render(fmt.Sprintf("%5d", i+1)), text))
+	}
+
+	content.WriteString("\n")
+	hints := []string{
+		keyStyle.Render("[n/N]") + " next/prev match",
+		keyStyle.Render("[j/k]") + " scroll",
+		keyStyle.Render("[y]") + " copy path",
+		keyStyle.Render("[e]") + " edit",
+	}
+	if m.retry != nil {
+		hints = append(hints, keyStyle.Render("[r]")+" retry")
+	}
+	hints = append(hints, keyStyle.Render("[esc]")+" close")
+	content.WriteString(strings.Join(hints, "  "))
+
+	dialog := dialogBorderStyle.Width(dialogWidth).Render(content.String())
+	return centerDialog(dialog, contentWidth, contentHeight)
+}
+
+// executeInstallInTerminal runs paru -S, see runTransaction
+func executeInstallInTerminal(packages []string, stop <-chan struct{}) tea.Cmd {
+	// Validate all package names to prevent command injection
+	validNames, _ := sanitizePackageNames(packages)
+	if len(validNames) == 0 {
+		return func() tea.Msg {
+			return execCompleteMsg{operation: confirmInstall, packages: packages, err: fmt.Errorf("no valid package names")}
+		}
+	}
+
+	args := append([]string{"-S"}, validNames...)
+	return runTransaction(confirmInstall, validNames, args, "install", stop)
+}
+
+// executeUninstallInTerminal runs paru -Rns interactively using tea.ExecProcess
+func executeUninstallInTerminal(packages []string, flags string) tea.Cmd {
+	// Validate all package names to prevent command injection
+	validNames, _ := sanitizePackageNames(packages)
+	if len(validNames) == 0 {
+		return func() tea.Msg {
+			return execCompleteMsg{operation: confirmUninstall, packages: packages, err: fmt.Errorf("no valid package names")}
+		}
+	}
+
+	args := append([]string{flags}, validNames...)
+	c := helper.command(args...)
+	finish := captureOutput(c)
+	started := time.Now()
+	return execProcess(c, func(err error) tea.Msg {
+		retry := func() tea.Cmd { return executeUninstallInTerminal(validNames, flags) }
+		return execCompleteMsg{operation: confirmUninstall, packages: validNames, started: started, output: finish(err), retry: retry, err: err}
+	})
+}
+
+// executeUpdateInTerminal runs paru -Syu, see runTransaction.
+// Held packages are passed as --ignore, and devel adds VCS package rebuilds.
+func executeUpdateInTerminal(pending, held []string, devel bool, stop <-chan struct{}) tea.Cmd {
+	args := []string{"-Syu"}
+	if devel && helper.aur {
+		args = append(args, "--devel")
+	}
+	if validHeld, _ := sanitizePackageNames(held); len(validHeld) > 0 {
+		args = append(args, "--ignore", strings.Join(validHeld, ","))
+	}
+	return runTransaction(confirmUpdate, pending, args, "update", stop)
+}
+
+// Streamed transactions
+const (
+	streamLogLimit      = 5000                   // Output lines kept in the live log pane
+	streamFlushInterval = 100 * time.Millisecond // How often streamed output reaches the UI
+	streamPromptIdle    = 3 * time.Second        // Silence after a partial line that means a prompt
+)
+
+// outputLineMsg carries the lines a streamed transaction printed since the
+// last one. ch delivers the next message of the same run.
+type outputLineMsg struct {
+	lines []string
+	ch    <-chan tea.Msg
+}
+
+// streamPromptMsg reports that a streamed transaction needs input, so it is
+// run again in the terminal by fallback
+type streamPromptMsg struct {
+	prompt   string
+	fallback func() tea.Cmd
+}
+
+// waitForOutput delivers the next message of a streamed transaction
+func waitForOutput(ch <-chan tea.Msg) tea.Cmd {
+	return func() tea.Msg {
+		return <-ch
+	}
+}
+
+// runTransaction runs an install or update. Without a stop channel it gets
+// the terminal through tea.ExecProcess. With one it runs with --noconfirm and
+// its output is sent line by line to the live log pane; when it needs a sudo
+// password or stops at a prompt before the transaction begins it is run in
+// the terminal instead. Closing stop interrupts it like ctrl+c in a terminal.
+// Either way a retry runs in the terminal.
+func runTransaction(op confirmationType, packages, args []string, logName string, stop <-chan struct{}) tea.Cmd {
+	var interactive func() tea.Cmd
+	interactive = func() tea.Cmd {
+		c := helper.command(args...)
+		logPath := attachBuildLog(c, logName)
+		started := time.Now()
+		return execProcess(c, func(err error) tea.Msg {
+			return execCompleteMsg{operation: op, packages: packages, started: started, logPath: logPath, retry: interactive, err: err}
+		})
+	}
+	if stop == nil {
+		return interactive()
+	}
+	return func() tea.Msg {
+		ch := make(chan tea.Msg, 16)
+		go streamTransaction(ch, stop, op, packages, args, logName, interactive)
+		return <-ch
+	}
+}
+
+// streamTransaction runs a transaction with --noconfirm, batching its
+// combined output into outputLineMsg and finishing with execCompleteMsg.
+// Input reads end of file, so a prompt is answered by its default; only
+// before the transaction begins is it worth stopping the run for one.
+func streamTransaction(ch chan tea.Msg, stop <-chan struct{}, op confirmationType, packages, args []string, logName string, interactive func() tea.Cmd) {
+	defer close(ch)
+	// sudo asks for its password on the terminal, which gaur holds
+	sudo := exec.Command("sudo", "-n", "true")
+	start := time.Now()
+	err := sudo.Run()
+	logExec(sudo, start, err)
+	if err != nil {
+		ch <- streamPromptMsg{prompt: "sudo password", fallback: interactive}
+		return
+	}
+
+	c := helper.command(append(args, "--noconfirm")...)
+	c.SysProcAttr = &syscall.SysProcAttr{Setpgid: true} // Stopped as a group at a prompt
+	pr, pw := io.Pipe()
+	var out io.Writer = pw
+	logPath := ""
+	if f, err := createBuildLog(logName); err == nil {
+		defer f.Close()
+		logPath = f.Name()
+		out = io.MultiWriter(pw, f)
+	}
+	c.Stdout = out
+	c.Stderr = out
+	started := time.Now()
+	if err := c.Start(); err != nil {
+		logExec(c, started, err)
+		ch <- execCompleteMsg{operation: op, packages: packages, started: started, logPath: logPath, retry: interactive, err: err}
+		return
+	}
+	waitErr := make(chan error, 1)
+	go func() {
+		err := c.Wait()
+		pw.Close()
+		waitErr <- err
+	}()
+	chunks := make(chan string)
+	go func() {
+		defer close(chunks)
+		buf := make([]byte, 4096)
+		for {
+			n, err := pr.Read(buf)
+			if n > 0 {
+				chunks <- string(buf[:n])
+			}
+			if err != nil {
+				return
+			}
+		}
+	}()
+
+	var pending []string
+	partial := ""
+	inTransaction := false // Past the point where stopping is harmless
+	idle := time.NewTimer(streamPromptIdle)
+	defer idle.Stop()
+	flush := time.NewTicker(streamFlushInterval)
+	defer flush.Stop()
+	for {
+		select {
+		case chunk, ok := <-chunks:
+			if !ok {
+				if partial != "" {
+					pending = append(pending, partial)
+				}
+				if len(pending) > 0 {
+					ch <- outputLineMsg{lines: pending, ch: ch}
+				}
+				err := <-waitErr
+				logExec(c, started, err)
+				ch <- execCompleteMsg{operation: op, packages: packages, started: started, logPath: logPath, retry: interactive, err: err}
+				return
+			}
+			// Progress bars redraw with \r; each redraw becomes a line
+			lines := strings.Split(partial+strings.ReplaceAll(chunk, "\r", "\n"), "\n")
+			partial = lines[len(lines)-1]
+			for _, line := range lines[:len(lines)-1] {
+				inTransaction = inTransaction || transactionBegins(line)
+			}
+			pending = append(pending, lines[:len(lines)-1]...)
+			idle.Reset(streamPromptIdle)
+		case <-stop:
+			// As ctrl+c would in a terminal; pacman itself decides whether
+			// it is safe to stop, and the run ends as usual
+			signalGroup(c, syscall.SIGINT)
+			stop = nil
+		case <-flush.C:
+			if len(pending) > 0 {
+				ch <- outputLineMsg{lines: pending, ch: ch}
+				pending = nil
+			}
+		case <-idle.C:
+			if looksLikePrompt(partial) && inTransaction {
+				// Show the question; it is answered by its default
+				pending = append(pending, partial)
+				partial = ""
+			} else if looksLikePrompt(partial) {
+				signalGroup(c, syscall.SIGTERM)
+				go func() {
+					// Let the process's output drain so it can exit
+					for range chunks {
+					}
+				}()
+				ch <- streamPromptMsg{prompt: partial, fallback: interactive}
+				return
+			}
+			idle.Reset(streamPromptIdle)
+		}
+	}
+}
+
+// promptMarkers are found in the questions of pacman, paru, makepkg and sudo,
+// lowercased
+var promptMarkers = []string{"[y/n]", "enter a number", "enter a selection", "password", "==>"}
+
+// looksLikePrompt reports whether an unterminated output line asks a question
+func looksLikePrompt(line string) bool {
+	line = strings.TrimSpace(line)
+	if line == "" || !strings.ContainsAny(line[len(line)-1:], ":?]>") {
+		return false
+	}
+	lower := strings.ToLower(line)
+	for _, marker := range promptMarkers {
+		if strings.Contains(lower, marker) {
+			return true
+		}
+	}
+	return false
+}
+
+// transactionBegins reports whether an output line shows a build or the
+// package transaction under way, which must not be killed
+func transactionBegins(line string) bool {
+	line = strings.TrimSpace(line)
+	return strings.HasPrefix(line, ":: Processing package changes") ||
+		strings.HasPrefix(line, ":: Running pre-transaction hooks") ||
+		strings.HasPrefix(line, "==> Making package")
+}
+
+// signalGroup signals the process group of c, continuing it too, since a
+// group stopped by a terminal read only acts on the signal once continued
+func signalGroup(c *exec.Cmd, sig syscall.Signal) {
+	_ = syscall.Kill(-c.Process.Pid, sig)
+	_ = syscall.Kill(-c.Process.Pid, syscall.SIGCONT)
+}
+
+// beginStream opens the live log pane for a streamed transaction and returns
+// the channel that interrupts it
+func (m *model) beginStream(title string) <-chan struct{} {
+	m.streamStop = make(chan struct{})
+	m.streaming = true
+	m.streamTitle = title
+	m.streamLines = nil
+	m.streamScroll = 0
+	m.streamStarted = time.Now()
+	return m.streamStop
+}
+
+// interruptStream interrupts the streamed transaction once
+func (m *model) interruptStream() {
+	if m.streamStop != nil {
+		close(m.streamStop)
+		m.streamStop = nil
+		m.streamTitle += " - interrupting"
+	}
+}
+
+// handleStreamKey scrolls the live log pane; nothing else applies while a
+// streamed transaction runs
+func (m model) handleStreamKey(msg tea.KeyMsg) (tea.Model, tea.Cmd) {
+	page := streamVisibleLines(m.height - 4)
+	switch msg.String() {
+	case "up", "k":
+		m.streamScroll++
+	case "down", "j":
+		m.streamScroll--
+	case "pgup", "ctrl+b":
+		m.streamScroll += page
+	case "pgdown", "ctrl+f":
+		m.streamScroll -= page
+	case "home", "g":
+		m.streamScroll = len(m.streamLines)
+	case "end", "G":
+		m.streamScroll = 0
+	}
+	m.streamScroll = max(min(m.streamScroll, len(m.streamLines)-page), 0)
+	return m, nil
+}
+
+// streamVisibleLines returns how many log lines the live log pane shows
+func streamVisibleLines(contentHeight int) int {
+	return max(contentHeight-8, 1)
+}
+
+// renderStreamPane renders the live log of a streamed transaction
+func (m model) renderStreamPane(contentWidth, contentHeight int, activeColor lipgloss.Color) string {
+	dialogWidth := contentWidth - 4
+	visible := streamVisibleLines(contentHeight)
+
+	titleStyle := lipgloss.NewStyle().
+		Bold(true).
+		Foreground(activeColor)
+	subtleStyle := lipgloss.NewStyle().
+		Foreground(currentTheme.SubtleColor)
+	keyStyle := lipgloss.NewStyle().
+		Foreground(activeColor).
+		Bold(true)
+	dialogBorderStyle := lipgloss.NewStyle().
+		Border(lipgloss.RoundedBorder()).
+		BorderForeground(activeColor).
+		Padding(0, 1)
+
+	var content strings.Builder
+	elapsed := time.Since(m.streamStarted).Truncate(time.Second)
+	content.WriteString(titleStyle.Render(m.spinner.View()+" "+m.streamTitle) + " " + subtleStyle.Render(elapsed.String()))
+	content.WriteString("\n\n")
+
+	end := len(m.streamLines) - m.streamScroll
+	start := max(end-visible, 0)
+	lineWidth := max(dialogWidth-4, 10)
+	for i := start; i < end; i++ {
+		content.WriteString(truncateRunes(strings.ReplaceAll(ansiEscape.ReplaceAllString(m.streamLines[i], ""), "\t", "    "), lineWidth))
+		content.WriteString("\n")
+	}
+	for i := end - start; i < visible; i++ {
+		content.WriteString("\n")
+	}
+
+	content.WriteString("\n")
+	hint := keyStyle.Render("[j/k]") + " scroll  " + keyStyle.Render("[g/G]") + " top/follow"
+	if m.streamScroll > 0 {
+		hint += "  " + subtleStyle.Render(fmt.Sprintf("%d lines below", m.streamScroll))
+	}
+	content.WriteString(hint)
+
+	dialog := dialogBorderStyle.Width(dialogWidth).Render(content.String())
+	return centerDialog(dialog, contentWidth, contentHeight)
+}
+
+// cacheCleanStrategy is a way of cleaning the package cache offered by the
+// clean cache dialog
+type cacheCleanStrategy int
+
+const (
+	cleanUninstalled  cacheCleanStrategy = iota // -Sc: keep only installed versions
+	cleanKeepVersions                           // paccache -rk N: keep the newest N versions
+	cleanAll                                    // -Scc: remove everything
+)
+
+const (
+	defaultCacheKeepVersions = 2
+	maxCacheKeepVersions     = 9
+)
+
+// cacheCleanOptions are the choices of the clean cache dialog. The zero
+// value is -Sc with the AUR helper's cache included.
+type cacheCleanOptions struct {
+	strategy cacheCleanStrategy
+	keep     int  // Versions kept by paccache; 0 means defaultCacheKeepVersions
+	skipAUR  bool // Leave the AUR helper's build cache alone
+	paccache bool // paccache (pacman-contrib) is installed
+	// Pacman cache contents and installed versions for the estimates; files
+	// is nil until loadCacheCleanEstimate returns
+	files     []cachedPackageFile
+	installed map[string]string
+}
+
+// keepVersions returns the number of versions paccache keeps
+func (o cacheCleanOptions) keepVersions() int {
+	if o.keep == 0 {
+		return defaultCacheKeepVersions
+	}
+	return o.keep
+}
+
+// includesAUR reports whether the AUR helper's build cache is cleaned too
+func (o cacheCleanOptions) includesAUR() bool {
+	return helper.aur && !o.skipAUR
+}
+
+// label describes a strategy for the clean cache dialog
+func (o cacheCleanOptions) label(strategy cacheCleanStrategy) string {
+	switch strategy {
+	case cleanKeepVersions:
+		return fmt.Sprintf("Keep %d versions", o.keepVersions())
+	case cleanAll:
+		return "Remove all"
+	}
+	return "Remove uninstalled"
+}
+
+// commands returns the command lines that clean the cache with the chosen
+// strategy. paccache only knows the pacman cache, so the AUR helper cleans
+// its own cache separately with -Sca.
+func (o cacheCleanOptions) commands() [][]string {
+	pacmanOnly := func(args ...string) []string {
+		return append([]string{"sudo", "pacman"}, args...)
+	}
+	switch o.strategy {
+	case cleanKeepVersions:
+		argvs := [][]string{{"sudo", "paccache", fmt.Sprintf("-rk%d", o.keepVersions())}}
+		if o.includesAUR() {
+			argvs = append(argvs, helper.transaction("-Sca"))
+		}
+		return argvs
+	case cleanAll:
+		if o.includesAUR() {
+			return [][]string{helper.transaction("-Scc")}
+		}
+		return [][]string{pacmanOnly("-Scc")}
+	}
+	if o.includesAUR() {
+		return [][]string{helper.transaction("-Sc")}
+	}
+	return [][]string{pacmanOnly("-Sc")}
+}
+
+// commandLine renders commands for the dialog
+func (o cacheCleanOptions) commandLine() string {
+	var lines []string
+	for _, argv := range o.commands() {
+		lines = append(lines, strings.Join(argv, " "))
+	}
+	return strings.Join(lines, " && ")
+}
+
+// cycleStrategy steps the strategy by delta, skipping keep-N without paccache
+func (o *cacheCleanOptions) cycleStrategy(delta int) {
+	const count = 3
+	next := o.strategy
+	for {
+		next = cacheCleanStrategy((int(next) + delta + count) % count)
+		if next != cleanKeepVersions || o.paccache {
+			break
+		}
+	}
+	o.strategy = next
+}
+
+// cachedPackageFile is a package archive in the pacman cache
+type cachedPackageFile struct {
+	name    string
+	version string
+	size    int64
+	modTime time.Time
+}
+
+// cacheCleanEstimateMsg carries what the clean cache dialog estimates from
+type cacheCleanEstimateMsg struct {
+	files     []cachedPackageFile
+	installed map[string]string
+}
+
+// parseCachedPackageFile splits a package archive name such as
+// "linux-6.9.1.arch1-1-x86_64.pkg.tar.zst" into its name and version.
+// Signatures and other files are rejected.
+func parseCachedPackageFile(filename string) (name, version string, ok bool) {
+	i := strings.Index(filename, ".pkg.tar")
+	if i < 0 || strings.HasSuffix(filename, ".sig") {
+		return "", "", false
+	}
+	parts := strings.Split(filename[:i], "-")
+	if len(parts) < 4 {
+		return "", "", false
+	}
+	n := len(parts)
+	return strings.Join(parts[:n-3], "-"), parts[n-3] + "-" + parts[n-2], true
+}
+
+// loadCacheCleanEstimate lists the package archives in dir along with the
+// installed versions, for the clean cache dialog's estimates
+func loadCacheCleanEstimate(dir string) tea.Cmd {
+	return func() tea.Msg {
+		msg := cacheCleanEstimateMsg{files: []cachedPackageFile{}, installed: make(map[string]string)}
+		entries, _ := os.ReadDir(dir)
+		for _, entry := range entries {
+			name, version, ok := parseCachedPackageFile(entry.Name())
+			if !ok {
+				continue
+			}
+			info, err := entry.Info()
+			if err != nil {
+				continue
+			}
+			msg.files = append(msg.files, cachedPackageFile{name: name, version: version, size: info.Size(), modTime: info.ModTime()})
+		}
+		if local, err := readLocalDB(filepath.Join(pacmanDBDir(), "local")); err == nil {
+			for _, pkg := range local {
+				msg.installed[pkg.Name] = pkg.Version
+			}
+		}
+		return msg
+	}
+}
+
+// estimate returns the bytes of the pacman cache a strategy frees. Versions
+// are ordered by modification time for keep-N, which matches paccache's
+// version ordering for archives downloaded by upgrades.
+func (o cacheCleanOptions) estimate(strategy cacheCleanStrategy) int64 {
+	var freed int64
+	switch strategy {
+	case cleanUninstalled:
+		for _, f := range o.files {
+			if o.installed[f.name] != f.version {
+				freed += f.size
+			}
+		}
+	case cleanKeepVersions:
+		byName := make(map[string][]cachedPackageFile)
+		for _, f := range o.files {
+			byName[f.name] = append(byName[f.name], f)
+		}
+		for _, versions := range byName {
+			sort.Slice(versions, func(i, j int) bool { return versions[i].modTime.After(versions[j].modTime) })
+			for _, f := range versions[min(o.keepVersions(), len(versions)):] {
+				freed += f.size
+			}
+		}
+	case cleanAll:
+		for _, f := range o.files {
+			freed += f.size
+		}
+	}
+	return freed
+}
+
+// chainCommands runs command lines one after another in sh, stopping at the
+// first failure. Arguments are passed as positional parameters, never
+// interpolated into the script.
+func chainCommands(argvs [][]string) *exec.Cmd {
+	if len(argvs) == 1 {
+		return exec.Command(argvs[0][0], argvs[0][1:]...)
+	}
+	args := []string{"sh"}
+	var script []string
+	for _, argv := range argvs {
+		var words []string
+		for _, arg := range argv {
+			args = append(args, arg)
+			words = append(words, fmt.Sprintf(`"${%d}"`, len(args)-1))
+		}
+		script = append(script, strings.Join(words, " "))
+	}
+	return exec.Command("sh", append([]string{"-c", strings.Join(script, " && ")}, args...)...)
+}
+
+// executeCleanCacheInTerminal cleans the package cache with the chosen
+// strategy interactively using tea.ExecProcess
+func executeCleanCacheInTerminal(options cacheCleanOptions) tea.Cmd {
+	c := chainCommands(options.commands())
+	finish := captureOutput(c)
+	freePath := "/var/cache/pacman/pkg"
+	freeBefore, _ := filesystemFreeBytes(freePath)
+	started := time.Now()
+	return execProcess(c, func(err error) tea.Msg {
+		retry := func() tea.Cmd { return executeCleanCacheInTerminal(options) }
+		return execCompleteMsg{operation: confirmCleanCache, started: started, freePath: freePath, freeBefore: freeBefore, output: finish(err), retry: retry, err: err}
+	})
+}
+
+// orphanPassMsg carries the orphans left after an orphan removal
+type orphanPassMsg struct {
+	orphans []string
+}
+
+// checkNewOrphans lists the orphans an orphan removal left behind, the
+// dependencies only the removed packages needed
+func checkNewOrphans(r Runner) tea.Cmd {
+	return func() tea.Msg {
+		stdout, _, _ := r.Run(helper.name, "-Qdtq")
+		return orphanPassMsg{orphans: strings.Fields(stdout)}
+	}
+}
+
+// orphanSizesMsg carries the installed sizes of the orphans in the removal dialog
+type orphanSizesMsg struct {
+	names []string
+	sizes map[string]int64
+}
+
+// loadOrphanSizes looks up the installed size of each orphan for the removal dialog
+func loadOrphanSizes(r Runner, names []string) tea.Cmd {
+	return func() tea.Msg {
+		return orphanSizesMsg{names: names, sizes: installedSizes(r, names)}
+	}
+}
+
+// executeRemoveOrphansInTerminal runs paru -Rns $(paru -Qdtq) interactively using tea.ExecProcess
+func executeRemoveOrphansInTerminal(orphans []string) tea.Cmd {
+	// Validate all package names to prevent command injection
+	validNames, _ := sanitizePackageNames(orphans)
+	if len(validNames) == 0 {
+		return func() tea.Msg {
+			return execCompleteMsg{operation: confirmRemoveOrphans, packages: orphans, err: fmt.Errorf("no valid package names")}
+		}
+	}
+
+	args := append([]string{"-Rns"}, validNames...)
+	c := helper.command(args...)
+	finish := captureOutput(c)
+	started := time.Now()
+	return execProcess(c, func(err error) tea.Msg {
+		retry := func() tea.Cmd { return executeRemoveOrphansInTerminal(validNames) }
+		return execCompleteMsg{operation: confirmRemoveOrphans, packages: validNames, started: started, output: finish(err), retry: retry, err: err}
+	})
+}
+
+// executeRemoveLockInTerminal removes a stale pacman database lock with sudo
+// using tea.ExecProcess
+func executeRemoveLockInTerminal(lockPath string) tea.Cmd {
+	c := exec.Command("sudo", "rm", "-f", lockPath)
+	started := time.Now()
+	return execProcess(c, func(err error) tea.Msg {
+		return execCompleteMsg{operation: confirmRemoveLock, started: started, err: err}
+	})
+}
+
+// executeIntegrityCheckInTerminal verifies every installed file against the
+// local database with sudo pacman -Qkk using tea.ExecProcess
+func executeIntegrityCheckInTerminal() tea.Cmd {
+	c := exec.Command("sudo", "pacman", "-Qkk")
+	finish := captureOutput(c)
+	started := time.Now()
+	return execProcess(c, func(err error) tea.Msg {
+		return execCompleteMsg{operation: confirmIntegrityCheck, started: started, output: finish(err), err: err}
+	})
+}
+
+// executeRebuildBatchInTerminal runs paru -S --rebuild for batch number of
+// total batches of foreign packages interactively using tea.ExecProcess
+func executeRebuildBatchInTerminal(batch []string, number, total int) tea.Cmd {
+	label := fmt.Sprintf("%d/%d", number, total)
+	// Validate all package names to prevent command injection
+	validNames, _ := sanitizePackageNames(batch)
+	if len(validNames) == 0 {
+		return func() tea.Msg {
+			return execCompleteMsg{operation: confirmRebuildForeign, packages: batch, batch: label, err: fmt.Errorf("no valid package names")}
+		}
+	}
+
+	args := append([]string{"-S", "--rebuild"}, validNames...)
+	c := helper.command(args...)
+	finish := captureOutput(c)
+	started := time.Now()
+	return execProcess(c, func(err error) tea.Msg {
+		return execCompleteMsg{operation: confirmRebuildForeign, packages: validNames, started: started, output: finish(err), batch: label, err: err}
+	})
+}
+
+// splitIntoBatches splits package names into consecutive batches of at most size entries
+func splitIntoBatches(names []string, size int) [][]string {
+	if size < 1 {
+		size = 1
+	}
+	var batches [][]string
+	for start := 0; start < len(names); start += size {
+		end := start + size
+		if end > len(names) {
+			end = len(names)
+		}
+		batches = append(batches, names[start:end])
+	}
+	return batches
+}
+
+// listForeignPackages returns all foreign packages with their installed versions (pacman -Qm)
+func listForeignPackages(r Runner) ([]Package, error) {
+	out, _, err := r.Run("pacman", "-Qm")
+	if err != nil {
+		return nil, err
+	}
+
+	var packages []Package
+	for _, line := range strings.Split(out, "\n") {
+		parts := strings.Fields(line)
+		if len(parts) < 2 || !isValidPackageName(parts[0]) {
+			continue
+		}
+		packages = append(packages, Package{
+			Source:    "aur",
+			Name:      parts[0],
+			Version:   parts[1],
+			Installed: true,
+		})
+	}
+	return packages, nil
+}
+
+// selectedRebuildPackages returns the rebuild candidates that are still toggled on
+func (m model) selectedRebuildPackages() []string {
+	var names []string
+	for _, pkg := range m.rebuildCandidates {
+		if !m.confirmExcluded[pkg.Name] {
+			names = append(names, pkg.Name)
+		}
+	}
+	return names
+}
+
+// handleOrphanPass offers the next orphan removal pass when the last one left
+// new orphans, or runs it with auto_orphan_passes. The passes end once no
+// orphans remain or one is declined.
+func (m model) handleOrphanPass(msg orphanPassMsg) (tea.Model, tea.Cmd) {
+	// Orphans the last pass should have removed would only loop
+	removed := make(map[string]bool, len(m.lastOrphanPass))
+	for _, name := range m.lastOrphanPass {
+		removed[name] = true
+	}
+	var orphans []string
+	for _, name := range msg.orphans {
+		if !removed[name] {
+			orphans = append(orphans, name)
+		}
+	}
+
+	if len(orphans) == 0 {
+		if m.orphanPasses > 1 {
+			m.lastCompletedOp = fmt.Sprintf("Removed %d orphan packages in %d passes", m.orphansRemoved, m.orphanPasses)
+		}
+		m.statusMessage = m.lastCompletedOp
+		m.orphanPasses = 0
+		m.orphansRemoved = 0
+		m.lastOrphanPass = nil
+		refresh := m.requestRefresh(false)
+		return m, tea.Batch(getDashboardData(m.runner, m.dashboardCacheDirs()), refresh)
+	}
+
+	if m.settings.AutoOrphanPasses {
+		m.statusMessage = fmt.Sprintf("Pass %d: removing %d new orphan package(s)...", m.orphanPasses+1, len(orphans))
+		return m, executeRemoveOrphansInTerminal(orphans)
+	}
+	m.confirmPackages = orphans
+	m.showConfirmation = true
+	m.confirmType = confirmRemoveOrphans
+	m.confirmScrollOffset = 0
+	m.orphanSizes = nil
+	m.statusMessage = fmt.Sprintf("The last pass left %d new orphan(s) - confirm pass %d", len(orphans), m.orphanPasses+1)
+	return m, tea.Batch(getDashboardData(m.runner, m.dashboardCacheDirs()), loadOrphanSizes(m.runner, orphans))
+}
+
+// handleRebuildBatchComplete records the result of a rebuild batch and starts the
+// next one. Failed batches are collected instead of aborting the whole rebuild.
+func (m model) handleRebuildBatchComplete(msg execCompleteMsg) (tea.Model, tea.Cmd) {
+	if msg.err != nil {
+		m.rebuildFailed = append(m.rebuildFailed, msg.packages...)
+		m.rebuildOutput = msg.output
+	} else {
+		m.rebuildSucceeded += len(msg.packages)
+	}
+
+	m.rebuildBatchIndex++
+	if m.rebuildBatchIndex < len(m.rebuildBatches) {
+		m.statusMessage = fmt.Sprintf("Rebuilding batch %d of %d...", m.rebuildBatchIndex+1, len(m.rebuildBatches))
+		return m, executeRebuildBatchInTerminal(m.rebuildBatches[m.rebuildBatchIndex], m.rebuildBatchIndex+1, len(m.rebuildBatches))
+	}
+
+	// All batches done - summarize
+	total := m.rebuildSucceeded + len(m.rebuildFailed)
+	m.loading = false
+	m.lastCompletedOp = fmt.Sprintf("Rebuilt %d of %d foreign packages", m.rebuildSucceeded, total)
+	m.statusMessage = m.lastCompletedOp
+	if len(m.rebuildFailed) > 0 {
+		m.showErrorOverlay = true
+		m.errorTitle = "Foreign Package Rebuild Incomplete"
+		m.sessionWarnings = append(m.sessionWarnings, fmt.Sprintf("%s: %s", m.errorTitle, strings.Join(m.rebuildFailed, " ")))
+		m.errorMessage = fmt.Sprintf("%d of %d packages were in batches that failed to rebuild.", len(m.rebuildFailed), total)
+		m.errorDetails = fmt.Sprintf("Failed:\n%s", strings.Join(m.rebuildFailed, " "))
+		if len(m.rebuildOutput) == 0 {
+			m.errorDetails += "\n\nThe error output was displayed in the terminal.\nPlease check the terminal output for details."
+		}
+		m.setErrorOutput(m.rebuildOutput)
+		m.retry, m.retryOffered = nil, true
+	}
+	m.rebuildBatches = nil
+	m.rebuildBatchIndex = 0
+	m.rebuildFailed = nil
+	m.rebuildOutput = nil
+	m.rebuildSucceeded = 0
+	m.rebuildCandidates = nil
+	return m, getDashboardData(m.runner, m.dashboardCacheDirs())
+}
+
+// Update handles a message, then starts the transaction preview of an install
+// or removal dialog it opened
+func (m model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
+	// Replay remapped keys as the default key of their action, so update only
+	// sees default keys and mouse handling can feed it keys too
+	if key, ok := msg.(tea.KeyMsg); ok {
+		typing := m.textInput.Focused() || (m.showConfirmation && m.removalNeedsTypedConfirm())
+		translated, bound := m.keys.translate(key.String(), typing)
+		if !bound {
+			return m, nil
+		}
+		if translated != key.String() {
+			msg = keyMsgFor(translated)
+		}
+	}
+
+	next, cmd := m.update(msg)
+	updated, ok := next.(model)
+	if !ok {
+		return next, cmd
+	}
+	// A different package or transaction shows its info from the top
+	if updated.selectedIndex != m.selectedIndex || updated.mode != m.mode || updated.infoForPackage != m.infoForPackage {
+		updated.infoScroll = 0
+	}
+	// Keep the results window in place unless the selection left it
+	_, resultsHeight := updated.mainLayout()
+	updated.resultsStart, _ = resultsWindow(updated.selectedIndex, len(updated.currentPackageList()), resultsHeight, updated.resultsStart)
+	// AUR results scrolled into view get their details
+	if updated.mode == modeInstall && updated.resultsStart != m.resultsStart {
+		cmd = tea.Batch(cmd, updated.hydrateResultWindow())
+	}
+	// Start the spinner when something starts loading; its ticks stop
+	// themselves once nothing is
+	switch busy := updated.busy(); {
+	case busy && !updated.spinning:
+		updated.spinning = true
+		updated.busySince = time.Now()
+		cmd = tea.Batch(cmd, updated.spinner.Tick)
+	case !busy:
+		updated.spinning = false
+	}
+	if preview := updated.startTransactionPreview(); preview != nil {
+		return updated, tea.Batch(cmd, preview)
+	}
+	return updated, cmd
+}
+
+func (m model) update(msg tea.Msg) (tea.Model, tea.Cmd) {
+	var cmds []tea.Cmd
+
+	switch msg := msg.(type) {
+	case tea.KeyMsg:
+		// ctrl+c quits, or interrupts a streamed transaction rather than
+		// leaving it running
+		if msg.String() == "ctrl+c" {
+			if m.streaming {
+				m.interruptStream()
+				return m, nil
+			}
+			return m, tea.Quit
+		}
+
+		// A streamed transaction's log pane takes every other key
+		if m.streaming {
+			return m.handleStreamKey(msg)
+		}
+
+		// Handle error overlay dismissal
+		if m.showErrorOverlay {
+			switch msg.String() {
+			case "esc", "enter", "q":
+				m.showErrorOverlay = false
+				m.errorTitle = ""
+				m.errorMessage = ""
+				m.errorDetails = ""
+				m.errorOutput = nil
+				m.retry = nil
+				m.retryOffered = false
+			case "r":
+				return m.retryFailed()
+			case "down", "j":
+				m.errorOutputEnd++
+			case "up", "k":
+				m.errorOutputEnd--
+			}
+			// The end never passes the output, nor the first screenful
+			visible := min(m.errorOutputVisible(errorOverlayWidth(m.width-4), m.height-4), len(m.errorOutput))
+			m.errorOutputEnd = min(max(m.errorOutputEnd, visible), len(m.errorOutput))
+			return m, nil
+		}
+
+		// Handle confirmation dialog keys
+		if m.showConfirmation {
+			// Removals wait for the dependents check, and removing packages
+			// others depend on takes typing "yes"
+			if m.confirmType == confirmUninstall {
+				switch key := msg.String(); {
+				case key == "c":
+					// Jump straight to cascade removal, or back to the default
+					if m.uninstallFlags() == "-Rc" {
+						m.removalFlags = ""
+					} else {
+						m.removalFlags = "-Rc"
+					}
+					m.cycleRemovalMode(0)
+					return m, nil
+				case key == "left" || key == "h":
+					m.cycleRemovalMode(-1)
+					return m, nil
+				case key == "right" || key == "l":
+					m.cycleRemovalMode(1)
+					return m, nil
+				}
+			}
+			if m.confirmType == confirmUninstall || m.confirmType == confirmQueue {
+				switch key := msg.String(); {
+				case m.txPreview.loading && (key == "y" || key == "Y" || key == "enter"):
+					m.statusMessage = "Checking what depends on these packages..."
+					return m, nil
+				case m.removalNeedsTypedConfirm() && (key == "y" || key == "e" || key == "s"):
+					if len(m.confirmTyped) < len("yes") {
+						m.confirmTyped += key
+					}
+					return m, nil
+				case m.removalNeedsTypedConfirm() && key == "backspace":
+					if m.confirmTyped != "" {
+						m.confirmTyped = m.confirmTyped[:len(m.confirmTyped)-1]
+					}
+					return m, nil
+				case m.removalNeedsTypedConfirm() && key == "enter" && m.confirmTyped != "yes":
+					m.statusMessage = "Other packages depend on these - type yes and press enter to remove anyway"
+					return m, nil
+				case m.removalNeedsTypedConfirm() && key == "Y":
+					return m, nil
+				}
+			}
+			// The clean cache dialog picks a strategy and whether the AUR cache is included
+			if m.confirmType == confirmCleanCache {
+				switch msg.String() {
+				case "left", "h":
+					m.cacheClean.cycleStrategy(-1)
+					return m, nil
+				case "right", "l":
+					m.cacheClean.cycleStrategy(1)
+					return m, nil
+				case "+", "=", "-":
+					if m.cacheClean.strategy == cleanKeepVersions {
+						keep := m.cacheClean.keepVersions() + 1
+						if msg.String() == "-" {
+							keep -= 2
+						}
+						m.cacheClean.keep = max(1, min(keep, maxCacheKeepVersions))
+					}
+					return m, nil
+				case "a":
+					if helper.aur {
+						m.cacheClean.skipAUR = !m.cacheClean.skipAUR
+					}
+					return m, nil
+				}
+			}
+			// The update waits for the news, and for manual intervention
+			// posts to be acknowledged
+			if m.confirmType == confirmUpdate {
+				switch key := msg.String(); {
+				case m.newsLoading && (key == "y" || key == "Y" || key == "enter"):
+					m.statusMessage = "Checking Arch news - the update can start once it is shown"
+					return m, nil
+				case key == "r" && m.newsNeedsAcknowledgement():
+					m.newsAcknowledged = true
+					m.statusMessage = "Manual intervention news acknowledged"
+					return m, nil
+				case m.newsNeedsAcknowledgement() && (key == "y" || key == "Y" || key == "enter"):
+					m.statusMessage = "A news post asks for manual intervention - read it, then press [r] to acknowledge"
+					return m, nil
+				}
+			}
+			// The queue review can drop the whole queue, and never runs with conflicts
+			if m.confirmType == confirmQueue {
+				switch msg.String() {
+				case "c":
+					m.queuedInstalls = nil
+					m.queuedRemovals = nil
+					m.showConfirmation = false
+					m.statusMessage = "Queue cleared"
+					return m, nil
+				case "y", "Y", "enter":
+					if conflicts := m.queueConflicts(); len(conflicts) > 0 {
+						m.statusMessage = "Queued for both removal and installation: " + strings.Join(conflicts, " ")
+						return m, nil
+					}
+				}
+			}
+			switch msg.String() {
+			case "y", "Y", "enter":
+				m.showConfirmation = false
+				m.confirmScrollOffset = 0
+				m.confirmSkipped = nil
+				m.confirmUnknown = nil
+				switch m.confirmType {
+				case confirmInstall:
+					m.statusMessage = fmt.Sprintf("Installing %d package(s)...", len(m.confirmPackages))
+					if m.settings.StreamOutput {
+						stop := m.beginStream(m.statusMessage)
+						return m, executeInstallInTerminal(m.confirmPackages, stop)
+					}
+					return m, executeInstallInTerminal(m.confirmPackages, nil)
+				case confirmUninstall:
+					m.statusMessage = fmt.Sprintf("Removing %d package(s)...", len(m.confirmPackages))
+					return m, executeUninstallInTerminal(m.confirmPackages, m.uninstallFlags())
+				case confirmUpdate:
+					if len(m.pendingUpdates) == 0 {
+						m.statusMessage = "Nothing to update - every update is held or ignored"
+						return m, nil
+					}
+					pending, ignored := m.updateSelection()
+					if len(pending) == 0 {
+						m.statusMessage = "Nothing to update - every update is skipped"
+						return m, nil
+					}
+					m.statusMessage = "Running system update..."
+					if m.settings.StreamOutput {
+						stop := m.beginStream(m.statusMessage)
+						return m, executeUpdateInTerminal(pending, ignored, m.develUpdates, stop)
+					}
+					return m, executeUpdateInTerminal(pending, ignored, m.develUpdates, nil)
+				case confirmCleanCache:
+					m.statusMessage = "Cleaning package cache..."
+					return m, executeCleanCacheInTerminal(m.cacheClean)
+				case confirmCleanCacheDir:
+					m.statusMessage = fmt.Sprintf("Cleaning %s cache...", m.confirmCacheDir.Label)
+					return m, executeCleanCacheDirInTerminal(m.confirmCacheDir)
+				case confirmSyncFiles:
+					m.statusMessage = "Downloading the file database..."
+					return m, executeSyncFilesInTerminal()
+				case confirmSyncDB:
+					m.statusMessage = "Syncing the package databases..."
+					return m, executeSyncDBInTerminal()
+				case confirmInstallReason:
+					m.markedPackages = make(map[string]bool)
+					m.statusMessage = fmt.Sprintf("Changing the install reason of %d package(s)...", len(m.confirmPackages))
+					return m, executeInstallReasonInTerminal(m.confirmPackages, m.confirmAsDeps)
+				case confirmQueue:
+					m.runningQueue = m.queueSteps()
+					m.queueStepIndex = 0
+					m.queuedInstalls = nil
+					m.queuedRemovals = nil
+					m.loading = true
+					m.statusMessage = m.queueStepStatus()
+					return m, executeQueueStepInTerminal(m.runningQueue[0])
+				case confirmRemoveOrphans:
+					m.statusMessage = fmt.Sprintf("Removing %d orphan package(s)...", len(m.confirmPackages))
+					orphans := m.confirmPackages
+					m.confirmPackages = nil
+					return m, executeRemoveOrphansInTerminal(orphans)
+				case confirmRebuildForeign:
+					selected := m.selectedRebuildPackages()
+					if len(selected) == 0 {
+						m.rebuildCandidates = nil
+						m.statusMessage = "No packages selected for rebuild"
+						return m, nil
+					}
+					m.rebuildBatches = splitIntoBatches(selected, rebuildBatchSize)
+					m.rebuildBatchIndex = 0
+					m.rebuildFailed = nil
+					m.rebuildSucceeded = 0
+					m.loading = true
+					m.statusMessage = fmt.Sprintf("Rebuilding batch 1 of %d...", len(m.rebuildBatches))
+					return m, executeRebuildBatchInTerminal(m.rebuildBatches[0], 1, len(m.rebuildBatches))
+				}
+			case "n", "N", "esc":
+				m.showConfirmation = false
+				m.confirmPackages = nil
+				m.orphanPasses = 0
+				m.orphansRemoved = 0
+				m.lastOrphanPass = nil
+				m.pendingUpdates = nil
+				m.classifiedUpdates = nil
+				m.rebuildCandidates = nil
+				m.confirmSkipped = nil
+				m.confirmUnknown = nil
+				m.confirmScrollOffset = 0
+				m.statusMessage = "Operation cancelled"
+				if m.exitAfter {
+					return m, tea.Quit
+				}
+				return m, nil
+			case "a":
+				// Add missing toolchain prerequisites to the install
+				var added []string
+				for _, advice := range m.installAdvice() {
+					if !advice.rule.repository {
+						added = append(added, advice.rule.prerequisite)
+					}
+				}
+				if len(added) > 0 {
+					m.confirmPackages = append(added, m.confirmPackages...)
+					m.statusMessage = fmt.Sprintf("Added %s to the install", strings.Join(added, ", "))
+				}
+				return m, nil
+			case "[", "]":
+				// Scroll the Arch news in the update dialog
+				if m.confirmType == confirmUpdate {
+					if msg.String() == "]" && m.newsOffset+archNewsVisible < len(m.news) {
+						m.newsOffset++
+					} else if msg.String() == "[" && m.newsOffset > 0 {
+						m.newsOffset--
+					}
+				}
+				return m, nil
+			case "h":
+				// Hold or release the update under the cursor
+				if m.confirmType == confirmUpdate {
+					rows := m.updateDialogRows()
+					if m.confirmCursor < len(rows) && !rows[m.confirmCursor].header {
+						name := rows[m.confirmCursor].pkg.Name
+						if err := m.toggleHold(name); err != nil {
+							m.statusMessage = err.Error()
+						} else if m.holds[name] {
+							m.statusMessage = fmt.Sprintf("Holding %s", name)
+						} else {
+							m.statusMessage = fmt.Sprintf("Released hold on %s", name)
+						}
+						m.clampConfirmCursor(len(m.updateDialogRows()))
+					}
+				}
+				return m, nil
+			case "p":
+				// Pin or unpin the update under the cursor
+				if m.confirmType == confirmUpdate {
+					rows := m.updateDialogRows()
+					if m.confirmCursor < len(rows) && !rows[m.confirmCursor].header {
+						name := rows[m.confirmCursor].pkg.Name
+						if err := m.togglePin(name); err != nil {
+							m.statusMessage = err.Error()
+						} else if version, ok := m.pins[name]; ok {
+							m.statusMessage = fmt.Sprintf("Pinned %s at %s", name, version)
+						} else {
+							m.statusMessage = fmt.Sprintf("Unpinned %s", name)
+						}
+						m.clampConfirmCursor(len(m.updateDialogRows()))
+					}
+				}
+				return m, nil
+			case "tab", " ":
+				// Collapse or expand the update dialog section under the cursor
+				if m.confirmType == confirmUpdate {
+					rows := m.updateDialogRows()
+					if m.confirmCursor < len(rows) && rows[m.confirmCursor].header {
+						class := rows[m.confirmCursor].class
+						m.updateCollapsed[class] = !m.updateCollapsed[class]
+					} else if m.confirmCursor < len(rows) && rows[m.confirmCursor].class == updateAvailable {
+						// Skip an available update for this run only
+						name := rows[m.confirmCursor].pkg.Name
+						if m.confirmExcluded[name] {
+							delete(m.confirmExcluded, name)
+						} else {
+							m.confirmExcluded[name] = true
+						}
+					}
+					return m, nil
+				}
+				// Toggle the row under the cursor in dialogs with per-row selection
+				if m.confirmType == confirmRebuildForeign && m.confirmCursor < len(m.rebuildCandidates) {
+					name := m.rebuildCandidates[m.confirmCursor].Name
+					if m.confirmExcluded[name] {
+						delete(m.confirmExcluded, name)
+					} else {
+						m.confirmExcluded[name] = true
+					}
+				}
+				return m, nil
+			case "down", "j":
+				if m.confirmType == confirmRebuildForeign || m.confirmType == confirmUpdate {
+					rowCount := len(m.rebuildCandidates)
+					if m.confirmType == confirmUpdate {
+						rowCount = len(m.updateDialogRows())
+					}
+					if m.confirmCursor < rowCount-1 {
+						m.confirmCursor++
+					}
+					if m.confirmCursor >= m.confirmScrollOffset+10 {
+						m.confirmScrollOffset = m.confirmCursor - 9
+					}
+					return m, nil
+				}
+				// Scroll down in package list
+				maxScroll := m.confirmListRows() - 10
+				if maxScroll < 0 {
+					maxScroll = 0
+				}
+				if m.confirmScrollOffset < maxScroll {
+					m.confirmScrollOffset++
+				}
+				return m, nil
+			case "up", "k":
+				if m.confirmType == confirmRebuildForeign || m.confirmType == confirmUpdate {
+					if m.confirmCursor > 0 {
+						m.confirmCursor--
+					}
+					if m.confirmCursor < m.confirmScrollOffset {
+						m.confirmScrollOffset = m.confirmCursor
+					}
+					return m, nil
+				}
+				// Scroll up in package list
+				if m.confirmScrollOffset > 0 {
+					m.confirmScrollOffset--
+				}
+				return m, nil
+			}
+			return m, nil
+		}
+
+		// Handle settings overlay keys
+		if m.showSettings {
+			return m.handleSettingsKeys(msg)
+		}
+
+		// Handle recovery view keys
+		if m.showRecovery {
+			return m.handleRecoveryKeys(msg)
+		}
+
+		// Handle cleanup wizard keys
+		if m.showCleanup {
+			return m.handleCleanupKeys(msg)
+		}
+
+		// Handle cache directories overlay keys
+		if m.showCacheDirs {
+			return m.handleCacheDirKeys(msg)
+		}
+
+		// Handle cache breakdown overlay keys
+		if m.showBreakdown {
+			return m.handleBreakdownKeys(msg)
+		}
+
+		// Handle unmanaged foreign packages overlay keys
+		if m.showUnmanaged {
+			return m.handleUnmanagedKeys(msg)
+		}
+
+		// Handle held packages overlay keys
+		if m.showHolds {
+			return m.handleHoldsKeys(msg)
+		}
+
+		// Handle failed systemd units overlay keys
+		if m.showFailedUnits {
+			return m.handleFailedUnitsKeys(msg)
+		}
+
+		// Handle security advisories overlay keys
+		if m.showAudit {
+			return m.handleAuditKeys(msg)
+		}
+
+		// Handle .pacnew files overlay keys
+		if m.showPacnew {
+			return m.handlePacnewKeys(msg)
+		}
+
+		// Handle build log browser keys
+		if m.showBuildLog {
+			return m.handleBuildLogKeys(msg)
+		}
+
+		// Handle session stats overlay keys
+		if m.showSessionStats {
+			return m.handleSessionStatsKeys(msg)
+		}
+
+		// Handle operation log overlay keys
+		if m.showOpLog {
+			return m.handleOpLogKeys(msg)
+		}
+
+		// Handle dependency tree keys
+		if m.showDepTree {
+			return m.handleDepTreeKeys(msg)
+		}
+
+		// Handle file list keys; typing filters the list
+		if m.showFileList {
+			return m.handleFileListKeys(msg)
+		}
+
+		// Handle * key to toggle selection panel focus; while typing it is
+		// part of the query
+		if msg.String() == "*" && !m.textInput.Focused() {
+			if len(m.markedPackages) > 0 {
+				m.selectionPanelFocused = !m.selectionPanelFocused
+				if m.selectionPanelFocused {
+					m.textInput.Blur()
+					m.selectionPanelIndex = 0
+					m.statusMessage = "Selection panel: [↑↓] navigate  [tab] deselect  [enter] install  [*] close"
+				} else {
+					m.statusMessage = fmt.Sprintf("%d packages marked", len(m.markedPackages))
+				}
+			}
+			return m, nil
+		}
+
+		// Handle shift+tab to focus the info panel and walk its dependencies
+		if msg.String() == "shift+tab" && !m.infoFocused && (m.mode == modeInstall || m.mode == modeUninstall) {
+			if m.packageDetailsOK && !m.loadingInfo && len(m.packageDetails.dependencyTokens()) > 0 {
+				m.infoFocused = true
+				m.infoCursor = 0
+				m.textInput.Blur()
+				m.statusMessage = "Info: " + m.infoForPackage + "  [↑↓] choose dependency  [enter] follow  [backspace] back  [esc] close"
+			}
+			return m, nil
+		}
+
+		// When the info panel is focused, handle dependency navigation
+		if m.infoFocused {
+			return m.handleInfoPanelKeys(msg)
+		}
+
+		// When selection panel is focused, handle its navigation
+		if m.selectionPanelFocused {
+			// Get sorted package names (same order as displayed)
+			var pkgNames []string
+			for name := range m.markedPackages {
+				pkgNames = append(pkgNames, name)
+			}
+			sort.Strings(pkgNames)
+			maxIdx := len(pkgNames) - 1
+
+			switch msg.String() {
+			case "esc", "*":
+				m.selectionPanelFocused = false
+				m.statusMessage = fmt.Sprintf("%d packages marked", len(m.markedPackages))
+				return m, nil
+			case "up", "k":
+				if m.selectionPanelIndex > 0 {
+					m.selectionPanelIndex--
+				}
+				return m, nil
+			case "down", "j":
+				if m.selectionPanelIndex < maxIdx {
+					m.selectionPanelIndex++
+				}
+				return m, nil
+			case "tab":
+				// Deselect the highlighted package
+				if m.selectionPanelIndex < len(pkgNames) {
+					nameToRemove := pkgNames[m.selectionPanelIndex]
+					delete(m.markedPackages, nameToRemove)
+					// Adjust index if needed
+					if m.selectionPanelIndex >= len(m.markedPackages) && m.selectionPanelIndex > 0 {
+						m.selectionPanelIndex--
+					}
+					// Close panel if no more selections
+					if len(m.markedPackages) == 0 {
+						m.selectionPanelFocused = false
+						m.statusMessage = "All selections cleared"
+					} else {
+						m.statusMessage = fmt.Sprintf("%d packages marked - [tab] to deselect", len(m.markedPackages))
+					}
+				}
+				return m, nil
+			case "enter":
+				// Close panel and show confirmation dialog
+				m.selectionPanelFocused = false
+				if len(m.markedPackages) > 0 {
+					if m.mode == modeInstall {
+						var pkgsToInstall []string
+						for name := range m.markedPackages {
+							if !m.installedSet[name] {
+								pkgsToInstall = append(pkgsToInstall, name)
+							}
+						}
+						if len(pkgsToInstall) > 0 {
+							sort.Strings(pkgsToInstall)
+							m.showConfirmation = true
+							m.confirmType = confirmInstall
+							m.confirmPackages = pkgsToInstall
+							m.confirmScrollOffset = 0
+							m.markedPackages = make(map[string]bool)
+							m.statusMessage = "Confirm installation"
+						} else {
+							m.statusMessage = "All marked packages are already installed"
+						}
+					} else if m.mode == modeUninstall {
+						var pkgsToUninstall []string
+						for name := range m.markedPackages {
+							pkgsToUninstall = append(pkgsToUninstall, name)
+						}
+						sort.Strings(pkgsToUninstall)
+						m.showConfirmation = true
+						m.confirmType = confirmUninstall
+						m.confirmPackages = pkgsToUninstall
+						m.confirmScrollOffset = 0
+						m.markedPackages = make(map[string]bool)
+						m.statusMessage = "Confirm removal"
+					}
+				}
+				return m, nil
+			}
+			return m, nil
+		}
+
+		// When naming a new package set, the input collects the name
+		if m.namingSet {
+			return m.handleSetNamingKeys(msg)
+		}
+
+		// Bulk marking works whether or not the input is focused, except
+		// ctrl+a, which moves to the start of the line while typing
+		bulkKey := msg.String() == "ctrl+t" || msg.String() == "ctrl+x" || (msg.String() == "ctrl+a" && !m.textInput.Focused())
+		if key := msg.String(); bulkKey && (m.mode == modeInstall || m.mode == modeUninstall) {
+			m.bulkMark(key)
+			return m, nil
+		}
+
+		// Page and jump through the results. Paging works while typing; the
+		// other keys are left to the input then.
+		if m.mode == modeInstall || m.mode == modeUninstall || m.mode == modeHistory {
+			_, page := m.mainLayout()
+			typing := m.textInput.Focused()
+			switch key := msg.String(); {
+			case key == "pgup" || (key == "ctrl+b" && !typing):
+				cmd := m.selectIndex(m.selectedIndex + page)
+				return m, cmd
+			case key == "pgdown" || (key == "ctrl+f" && !typing):
+				cmd := m.selectIndex(m.selectedIndex - page)
+				return m, cmd
+			case (key == "g" || key == "home") && !typing:
+				cmd := m.selectIndex(0)
+				return m, cmd
+			case (key == "G" || key == "end") && !typing:
+				cmd := m.selectIndex(m.selectableCount() - 1)
+				return m, cmd
+			}
+		}
+
+		// When input is focused, only allow esc, arrow keys, and typing
+		if m.textInput.Focused() {
+			switch msg.String() {
+			case "esc":
+				m.recordQuery()
+				m.textInput.Blur()
+				return m, nil
+			case "ctrl+p", "ctrl+n":
+				// Recall a previous query, then filter for it like typing would
+				m.recallQuery(msg.String() == "ctrl+p")
+			case "down":
+				// Down moves toward more relevant (lower index, visually down)
+				if m.selectedIndex > 0 {
+					m.selectedIndex--
+					if m.mode == modeInstall && len(m.filtered) > 0 {
+						m.loadingInfo = true
+						m.pendingInfoPackage = m.filtered[m.selectedIndex].Name
+						return m, m.debouncePackageInfo(m.pendingInfoPackage)
+					} else if m.mode == modeUninstall && len(m.filteredInstalled) > 0 {
+						m.loadingInfo = true
+						m.pendingInfoPackage = m.filteredInstalled[m.selectedIndex].Name
+						return m, m.debouncePackageInfo(m.pendingInfoPackage)
+					}
+				}
+				return m, nil
+			case "up":
+				// Up moves toward less relevant (higher index, visually up)
+				maxIndex := 0
+				if m.mode == modeInstall {
+					maxIndex = len(m.filtered) - 1
+				} else if m.mode == modeUninstall {
+					maxIndex = len(m.filteredInstalled) - 1
+				} else if m.mode == modeHistory {
+					maxIndex = len(m.filteredHistory) - 1
+				}
+				if m.selectedIndex < maxIndex {
+					m.selectedIndex++
+					if m.mode == modeInstall && len(m.filtered) > 0 {
+						m.loadingInfo = true
+						m.pendingInfoPackage = m.filtered[m.selectedIndex].Name
+						return m, m.debouncePackageInfo(m.pendingInfoPackage)
+					} else if m.mode == modeUninstall && len(m.filteredInstalled) > 0 {
+						m.loadingInfo = true
+						m.pendingInfoPackage = m.filteredInstalled[m.selectedIndex].Name
+						return m, m.debouncePackageInfo(m.pendingInfoPackage)
+					}
+				}
+				return m, nil
+			case "enter":
+				m.recordQuery()
+				if m.mode == modeInstall && len(m.filtered) > 0 {
+					// If packages are marked, show confirmation for all marked packages
+					if len(m.markedPackages) > 0 {
+						var pkgsToInstall []string
+						for name := range m.markedPackages {
+							if !m.installedSet[name] {
+								pkgsToInstall = append(pkgsToInstall, name)
+							}
+						}
+						if len(pkgsToInstall) > 0 {
+							sort.Strings(pkgsToInstall)
+							m.showConfirmation = true
+							m.confirmType = confirmInstall
+							m.confirmPackages = pkgsToInstall
+							m.confirmScrollOffset = 0
+							m.markedPackages = make(map[string]bool)
+							m.statusMessage = "Confirm installation"
+						} else {
+							m.statusMessage = "All marked packages are already installed"
+						}
+					} else {
+						// Show confirmation dialog for single package
+						pkg := m.filtered[m.selectedIndex]
+						if pkg.Source == "set" {
+							return m.confirmPackageSet(pkg.Name)
+						}
+						if !m.resultInstalled(pkg) {
+							m.showConfirmation = true
+							m.confirmType = confirmInstall
+							m.confirmPackages = []string{pkg.Name}
+							m.confirmScrollOffset = 0
+							m.statusMessage = "Confirm installation"
+						} else {
+							m.statusMessage = fmt.Sprintf("%s is already installed", pkg.Name)
+						}
+					}
+				} else if m.mode == modeUninstall && len(m.filteredInstalled) > 0 {
+					// If packages are marked, show confirmation for all marked packages
+					if len(m.markedPackages) > 0 {
+						var pkgsToUninstall []string
+						for name := range m.markedPackages {
+							pkgsToUninstall = append(pkgsToUninstall, name)
+						}
+						sort.Strings(pkgsToUninstall)
+						m.showConfirmation = true
+						m.confirmType = confirmUninstall
+						m.confirmPackages = pkgsToUninstall
+						m.confirmScrollOffset = 0
+						m.markedPackages = make(map[string]bool)
+						m.statusMessage = "Confirm removal"
+					} else {
+						// Show confirmation dialog for single package
+						pkg := m.filteredInstalled[m.selectedIndex]
+						m.showConfirmation = true
+						m.confirmType = confirmUninstall
+						m.confirmPackages = []string{pkg.Name}
+						m.confirmScrollOffset = 0
+						m.statusMessage = "Confirm removal"
+					}
+				}
+				return m, nil
+			case "tab":
+				// Toggle mark on current package (works even while typing)
+				if m.mode == modeInstall && len(m.filtered) > 0 {
+					pkg := m.filtered[m.selectedIndex]
+					if m.markedPackages[pkg.Name] {
+						delete(m.markedPackages, pkg.Name)
+					} else {
+						m.markedPackages[pkg.Name] = true
+					}
+					markedCount := len(m.markedPackages)
+					if markedCount > 0 {
+						m.statusMessage = fmt.Sprintf("%d packages marked", markedCount)
+					} else {
+						m.statusMessage = fmt.Sprintf("Found %d packages", len(m.filtered))
+					}
+				} else if m.mode == modeUninstall && len(m.filteredInstalled) > 0 {
+					pkg := m.filteredInstalled[m.selectedIndex]
+					if m.markedPackages[pkg.Name] {
+						delete(m.markedPackages, pkg.Name)
+					} else {
+						m.markedPackages[pkg.Name] = true
+					}
+					markedCount := len(m.markedPackages)
+					if markedCount > 0 {
+						m.statusMessage = fmt.Sprintf("%d packages marked", markedCount)
+					} else {
+						m.statusMessage = fmt.Sprintf("%d installed packages", len(m.installed))
+					}
+				}
+				return m, nil
+			}
+			// All other keys go to text input
+			var cmd tea.Cmd
+			m.textInput, cmd = m.textInput.Update(msg)
+			cmds = append(cmds, cmd)
+			// Handle filtering logic
+			if m.mode == modeInstall {
+				query := m.textInput.Value()
+				if query != m.lastQuery {
+					m.lastQuery = query
+					m.aurDebounceSeq++ // Any pending AUR search is for an older query
+
+					// "sets:" lists configured package sets, no AUR search needed
+					if _, isSetQuery := parseSetQuery(query); isSetQuery {
+						m.filterAllPackages(query)
+						m.selectedIndex = 0
+						if len(m.filtered) > 0 {
+							m.statusMessage = fmt.Sprintf("%d package sets - [enter] install missing members", len(m.filtered))
+							m.loadingInfo = true
+							m.infoForPackage = m.filtered[0].Name
+							cmds = append(cmds, m.packageInfoCmd(m.filtered[0]))
+						} else {
+							m.statusMessage = "No package sets defined - mark packages and press [S] to create one"
+							m.setPackageInfo("")
+							m.infoForPackage = ""
+						}
+						return m, tea.Batch(cmds...)
+					}
+
+					// "own:" looks up the packages owning a file once typing pauses
+					if path, isOwnerQuery := parseOwnerQuery(query); isOwnerQuery {
+						m.cancelAURSearch()
+						m.ownerResults = nil
+						m.filtered = []Package{}
+						m.matchQuery = ""
+						m.selectedIndex = 0
+						m.setPackageInfo("")
+						m.infoForPackage = ""
+						if path == "" {
+							m.statusMessage = "Type a file path or name after own: to find the package that owns it"
+							return m, tea.Batch(cmds...)
+						}
+						m.statusMessage = fmt.Sprintf("Looking up the owner of %s...", path)
+						cmds = append(cmds, m.debounceFileOwners(path))
+						return m, tea.Batch(cmds...)
+					}
+					
+					// Parse repo filter to check query length correctly
+					repoFilters, searchQuery := parseRepoFilter(query)
+					effectiveQueryLen := len(searchQuery)
+					
+					// Allow filtering with just repo prefix (e.g., "a:" shows all AUR)
+					hasRepoFilter := len(repoFilters) > 0
+					
+					if effectiveQueryLen >= m.settings.MinSearchQueryLen || hasRepoFilter {
+						// Fuzzy filter combined repo + AUR packages (also computes match indices)
+						m.filterAllPackages(query)
+						m.selectedIndex = 0
+						
+						// Trigger AUR search only if:
+						// 1. No repo filter OR filter includes AUR
+						//    (with auto-search off, only an explicit a: filter searches)
+						// 2. Have a search query (not just "a:")
+						// 3. Haven't searched this query yet
+						// 4. The backend can install from the AUR
+						includesAUR := helper.aur && ((len(repoFilters) == 0 && m.settings.AURAutoSearch) || repoFilters["aur"])
+						aurQuery := aurSearchQuery(searchQuery)
+						shouldSearchAUR := includesAUR && aurQuery != "" &&
+							effectiveQueryLen >= m.settings.MinSearchQueryLen &&
+							aurQuery != m.lastAURQuery
+						
+						if shouldSearchAUR {
+							// Local results are already shown; the network search
+							// waits for typing to pause
+							m.cancelAURSearch()
+							m.searchingAUR = true
+							cmds = append(cmds, m.debounceAURSearch(aurQuery))
+						}
+						
+						if len(m.filtered) > 0 {
+							status := fmt.Sprintf("Found %d packages", len(m.filtered))
+							if hasRepoFilter {
+								status = fmt.Sprintf("Found %d %s packages", len(m.filtered), formatRepoFilters(repoFilters))
+							}
+							if m.searchingAUR {
+								status += " (searching AUR...)"
+							}
+							m.statusMessage = status
+							m.loadingInfo = true
+							m.infoForPackage = m.filtered[0].Name
+							cmds = append(cmds, m.packageInfoCmd(m.filtered[0]))
+						} else {
+							if m.searchingAUR {
+								m.statusMessage = "Searching AUR..."
+							} else if hasRepoFilter && searchQuery == "" {
+								m.statusMessage = fmt.Sprintf("No packages in %s", formatRepoFilters(repoFilters))
+							} else {
+								m.statusMessage = fmt.Sprintf("No matches for '%s'", query)
+							}
+							m.setPackageInfo("")
+							m.infoForPackage = ""
+						}
+					} else {
+						m.cancelAURSearch()
+						m.filtered = []Package{}
+						m.aurPackages = []Package{}
+						m.lastAURQuery = ""
+						m.setPackageInfo("")
+						m.infoForPackage = ""
+						m.matchQuery = ""
+						if len(m.repoPackages) > 0 {
+							m.statusMessage = fmt.Sprintf(installSearchHint, m.settings.MinSearchQueryLen, len(m.repoPackages))
+						} else {
+							m.statusMessage = "Loading package database..."
+							if m.repoLoadStatus != "" {
+								m.statusMessage = m.repoLoadStatus
+							}
+						}
+					}
+				}
+			} else if m.mode == modeUninstall {
+				query := m.textInput.Value()
+				if len(m.installed) > 0 {
+					if query == "" {
+						m.filteredInstalled = m.installed
+						m.installedMatchQuery = ""
+						m.statusMessage = fmt.Sprintf("%d installed packages", len(m.installed))
+					} else {
+						// Parse repository scope and source filter from query
+						repoScope, query := parseRepoScope(query)
+						sourceFilters, searchQuery := parseUninstallFilter(query)
+						hasSourceFilter := len(sourceFilters) > 0
+						
+						// Start with all installed packages
+						basePackages := m.installed
+						if repoScope != "" {
+							basePackages = packagesFromRepo(basePackages, repoScope)
+						}
+						
+						// Apply source filters if specified
+						if hasSourceFilter {
+							var filtered []Package
+							for _, pkg := range basePackages {
+								// 't' (total) - all packages
+								if sourceFilters["total"] {
+									filtered = append(filtered, pkg)
+								} else {
+									// 'e' (explicit) - explicitly installed packages
+									if sourceFilters["explicit"] && pkg.Explicit {
+										filtered = append(filtered, pkg)
+									}
+									// 'f' (foreign) - foreign/AUR packages
+									if sourceFilters["foreign"] && pkg.Source == "aur" {
+										filtered = append(filtered, pkg)
+									}
+									// 'o' (orphan) - orphan packages
+									if sourceFilters["orphan"] && pkg.Orphan {
+										filtered = append(filtered, pkg)
+									}
+								}
+							}
+							basePackages = filtered
+						}
+						
+						// Apply fuzzy filtering if there's a search query
+						if searchQuery != "" {
+							m.filteredInstalled = fuzzyFilter(basePackages, searchQuery)
+							m.installedMatchQuery = searchQuery
+						} else {
+							m.filteredInstalled = basePackages
+							m.installedMatchQuery = ""
+						}
+						
+						// Update status message
+						if repoScope != "" {
+							m.statusMessage = fmt.Sprintf("Found %d packages from %s", len(m.filteredInstalled), repoScope)
+						} else if hasSourceFilter {
+							m.statusMessage = fmt.Sprintf("Found %d %s packages", len(m.filteredInstalled), formatUninstallFilters(sourceFilters))
+						} else {
+							m.statusMessage = fmt.Sprintf("Showing %d of %d packages", len(m.filteredInstalled), len(m.installed))
+						}
+					}
+					m.applyResultSort()
+					if m.selectedIndex >= len(m.filteredInstalled) {
+						m.selectedIndex = 0
+					}
+					if len(m.filteredInstalled) > 0 && m.filteredInstalled[m.selectedIndex].Name != m.infoForPackage {
+						m.loadingInfo = true
+						m.infoForPackage = m.filteredInstalled[m.selectedIndex].Name
+						cmds = append(cmds, getPackageInfo(m.runner, m.displayRunner, m.filteredInstalled[m.selectedIndex]))
+					}
+				}
+			} else if m.mode == modeHistory {
+				m.filterHistory(m.textInput.Value())
+				m.statusMessage = fmt.Sprintf("%d history entries", len(m.filteredHistory))
+			}
+			return m, tea.Batch(cmds...)
+		}
+
+		// Keys of the current mode come first; those it leaves fall through
+		// to the keys of every mode below
+		var handler func(tea.KeyMsg) (tea.Model, tea.Cmd, bool)
+		switch m.mode {
+		case modeInstalled:
+			handler = m.handleDashboardKeys
+		case modeUpdate:
+			handler = m.handleUpdateKeys
+		case modeInstall, modeUninstall, modeHistory:
+			handler = m.handleListKeys
+		}
+		if handler != nil {
+			if next, cmd, handled := handler(msg); handled {
+				return next, cmd
+			}
+		}
+
+		// Input not focused - keys of every mode
+		switch msg.String() {
+		case "q":
+			return m, tea.Quit
+
+		case "esc":
+			if m.textInput.Focused() {
+				m.textInput.Blur()
+				return m, nil
+			}
+			// Clear selections and reset state but stay in current mode
+			if len(m.markedPackages) > 0 {
+				m.markedPackages = make(map[string]bool)
+				m.statusMessage = "Selections cleared"
+				return m, nil
+			}
+
+		case "!":
+			// Open the recovery view when an interrupted transaction was detected
+			if len(m.recoveryFindings) > 0 {
+				m.showRecovery = true
+				m.showRecoveryBanner = false
+				return m, nil
+			}
+
+		case ".":
+			// Show what this session changed so far
+			m.showSessionStats = true
+			m.sessionStatsOffset = 0
+			return m, nil
+
+		case "L":
+			// Show the end of the operation log
+			m.openOpLog()
+			return m, nil
+
+		case ",":
+			// Open the settings overlay
+			m.showSettings = true
+			m.settingsIndex = 0
+			m.statusMessage = "Settings: [↑↓] select  [←→] adjust  [esc] close"
+			return m, nil
+
+		case "n":
+			if m.mode != modeInstalled && !m.textInput.Focused() {
+				m.mode = modeInstalled
+				m.loading = true
+				m.statusMessage = "Loading system statistics..."
+				m.markedPackages = make(map[string]bool)
+				return m, getDashboardData(m.runner, m.dashboardCacheDirs())
+			}
+
+		case "r":
+			if m.mode != modeUninstall {
+				m.mode = modeUninstall
+				m.loading = true
+				m.statusMessage = "Loading installed packages..."
+				m.selectedIndex = 0
+				m.textInput.SetValue("")
+				m.textInput.Placeholder = "Filter (t: total  e: explicit  f: foreign  o: orphan)..."
+				m.markedPackages = make(map[string]bool)
+				return m, getInstalledPackages(m.runner)
+			}
+
+		case "u":
+			if !m.textInput.Focused() {
+				if m.mode != modeUpdate {
+					// Switch to update mode
+					m.mode = modeUpdate
+					m.markedPackages = make(map[string]bool)
+				}
+				// Check for updates (works both when switching to update mode and when already in it)
+				m.loading = true
+				m.statusMessage = "Checking for updates..."
+				m.updateOutput = ""
+				m.pendingUpdates = nil
+				m.classifiedUpdates = nil
+				// Fetch Arch news alongside so it is shown in the update dialog
+				m.news = nil
+				m.newsErr = nil
+				m.newsLoading = true
+				m.newsOffset = 0
+				m.newsAcknowledged = false
+				return m, tea.Batch(checkUpdates(m.runner, m.holds, m.pins, m.develUpdates), checkArchNews())
+			}
+
+		case "T":
+			m.cycleTheme()
+			return m, nil
+
+		case "h":
+			// Browse the transaction history from pacman.log
+			if m.mode != modeHistory && !m.textInput.Focused() {
+				m.mode = modeHistory
+				m.loading = true
+				m.statusMessage = "Reading " + pacmanLogPath + "..."
+				m.selectedIndex = 0
+				m.textInput.SetValue("")
+				m.textInput.Placeholder = "Filter history by package..."
+				m.markedPackages = make(map[string]bool)
+				return m, loadHistory()
+			}
+
+		case "i":
+			if m.mode != modeInstall {
+				m.mode = modeInstall
+				m.selectedIndex = 0
+				m.filtered = []Package{}
+				m.setPackageInfo("")
+				m.statusMessage = "Press [/] to search packages"
+				m.textInput.SetValue("")
+				m.textInput.Placeholder = "Search packages..."
+				m.markedPackages = make(map[string]bool)
+				return m, nil
+			}
+		}
+
+	case tea.WindowSizeMsg:
+		m.width = msg.Width
+		m.height = msg.Height
+		m.textInput.Width = msg.Width - 6
+
+	case repoLoadProgressMsg:
+		// Only the initial load reports progress on the status line
+		if m.loading && m.mode == modeInstall {
+			m.repoLoadStatus = msg.status()
+			m.statusMessage = m.repoLoadStatus
+		}
+		return m, waitForRepoLoad(msg.ch)
+
+	case repoPackagesMsg:
+		if msg.ch != nil {
+			// Cached packages: handle them now and wait for the refresh
+			refresh := waitForRepoLoad(msg.ch)
+			msg.ch = nil
+			next, cmd := m.update(msg)
+			return next, tea.Batch(cmd, refresh)
+		}
+		m.loading = false
+		m.repoLoadStatus = ""
+		if msg.err != nil {
+			m.statusMessage = fmt.Sprintf("Failed to load packages: %v", msg.err)
+		} else {
+			m.setRepoPackages(msg.packages, msg.installed)
+			m.repoGroups = msg.groups
+			if m.startMode != modeInstall {
+				cmd := m.enterStartMode()
+				return m, cmd
+			}
+			
+			// Re-apply current search filter if there's a query
+			query := m.textInput.Value()
+			if m.mode == modeInstall && query != "" && m.searchingAUR {
+				// The AUR results in flight filter against the new repo list;
+				// filtering now would drop the AUR rows until they land. They
+				// put back the saved selection too.
+				return m, nil
+			}
+			if m.mode == modeInstall && query != "" {
+				repoFilters, searchQuery := parseRepoFilter(query)
+				hasRepoFilter := len(repoFilters) > 0
+				effectiveQueryLen := len(searchQuery)
+				
+				if effectiveQueryLen >= m.settings.MinSearchQueryLen || hasRepoFilter {
+					m.filterAllPackages(query)
+					// Reset selection to top, unless an operation left it elsewhere
+					m.selectedIndex = 0
+					m.restoreSelection()
+					
+					if len(m.filtered) > 0 {
+						status := fmt.Sprintf("Found %d packages", len(m.filtered))
+						if hasRepoFilter {
+							status = fmt.Sprintf("Found %d %s packages", len(m.filtered), formatRepoFilters(repoFilters))
+						}
+						if m.lastCompletedOp != "" {
+							status = m.lastCompletedOp + " | " + status
+						}
+						m.statusMessage = status
+						// Load info for the selected result. Details already
+						// shown stay up while they refresh.
+						pkg := m.filtered[m.selectedIndex]
+						if pkg.Name != m.infoForPackage || m.packageInfo == "" {
+							m.loadingInfo = true
+						}
+						m.infoForPackage = pkg.Name
+						return m, m.packageInfoCmd(pkg)
+					} else {
+						m.statusMessage = fmt.Sprintf("No matches for '%s'", query)
+					}
+				} else {
+					m.filtered = []Package{}
+					m.matchQuery = ""
+					if m.lastCompletedOp != "" {
+						m.statusMessage = m.lastCompletedOp
+					} else {
+						m.statusMessage = fmt.Sprintf("Loaded %d repo packages - press [/] to search", len(m.repoPackages))
+					}
+				}
+			} else {
+				if m.lastCompletedOp != "" {
+					m.statusMessage = m.lastCompletedOp
+				} else {
+					m.statusMessage = fmt.Sprintf("Loaded %d repo packages - press [/] to search", len(m.repoPackages))
+				}
+			}
+		}
+
+	case aurSearchTickMsg:
+		// Search only if the input still asks for this query
+		_, searchQuery := parseRepoFilter(m.textInput.Value())
+		if msg.seq != m.aurDebounceSeq {
+			return m, nil // Superseded by a newer keystroke
+		}
+		if m.mode == modeInstall && aurSearchQuery(searchQuery) == msg.query {
+			search := m.startAURSearch(msg.query)
+			return m, search
+		}
+		if m.aurSearchCancel == nil {
+			m.searchingAUR = false
+		}
+
+	case aurSearchMsg:
+		// Only the live search's results match the current input
+		if msg.seq != m.aurSearchSeq {
+			return m, nil
+		}
+		m.searchingAUR = false
+		if m.aurSearchCancel != nil {
+			m.aurSearchCancel()
+			m.aurSearchCancel = nil
+		}
+		// A reload that waited for these results left its selection behind;
+		// it is used here or not at all
+		saved := m.savedSelection
+		m.savedSelection = nil
+		
+		if msg.err == nil {
+			m.aurPackages = msg.packages
+			for i := range m.aurPackages {
+				m.aurPackages[i].Installed = m.installedSet[m.aurPackages[i].Name]
+			}
+			
+			// Re-filter all packages together for unified relevance ranking
+			query := m.textInput.Value()
+			if len(query) >= m.settings.MinSearchQueryLen {
+				// Remember if user was on the first (most relevant) option
+				wasOnFirst := m.selectedIndex == 0
+				prevSelected := ""
+				if !wasOnFirst && m.selectedIndex < len(m.filtered) {
+					prevSelected = m.filtered[m.selectedIndex].Name
+				}
+				
+				m.filterAllPackages(query)
+				
+				// If user was on first option, stay on first (to see new most relevant)
+				// Otherwise try to keep the same package selected
+				if saved != nil {
+					m.savedSelection = saved
+					m.restoreSelection()
+				} else if wasOnFirst {
+					m.selectedIndex = 0
+				} else if prevSelected != "" {
+					for i, pkg := range m.filtered {
+						if pkg.Name == prevSelected {
+							m.selectedIndex = i
+							break
+						}
+					}
+				}
+				if m.selectedIndex >= len(m.filtered) {
+					m.selectedIndex = 0
+				}
+				
+				if len(m.filtered) > 0 {
+					m.statusMessage = fmt.Sprintf("Found %d packages (%d from AUR)", len(m.filtered), len(msg.packages))
+					hydrate := m.hydrateResultWindow()
+					// Load info for selected result
+					selected := m.filtered[m.selectedIndex]
+					if selected.Name != m.infoForPackage {
+						m.loadingInfo = true
+						m.infoForPackage = selected.Name
+						// An uncached AUR selection is answered by the hydration batch
+						if selected.Source == "aur" && m.aurInfoCache[selected.Name] == nil && hydrate != nil {
+							return m, hydrate
+						}
+						return m, tea.Batch(hydrate, m.packageInfoCmd(selected))
+					}
+					return m, hydrate
+				} else {
+					m.statusMessage = fmt.Sprintf("No matches for '%s'", query)
+				}
+			}
+		} else if len(m.filtered) == 0 {
+			m.statusMessage = fmt.Sprintf("No matches for '%s'", m.textInput.Value())
+		}
+
+	case aurInfoHydratedMsg:
+		for _, name := range msg.names {
+			delete(m.aurHydrating, name)
+		}
+		if m.aurInfoCache == nil {
+			m.aurInfoCache = make(map[string]*aurPackageInfo)
+		}
+		for name, info := range msg.infos {
+			m.aurInfoCache[name] = info
+		}
+		// Answer the info panel if it is waiting on an AUR package
+		if m.loadingInfo && m.mode == modeInstall && m.selectedIndex < len(m.filtered) {
+			pkg := m.filtered[m.selectedIndex]
+			if pkg.Name == m.infoForPackage && pkg.Source == "aur" {
+				if info := m.aurInfoCache[pkg.Name]; info != nil {
+					m.loadingInfo = false
+					m.setPackageInfo(formatAURInfo(info))
+				} else {
+					return m, getPackageInfo(m.runner, m.displayRunner, pkg)
+				}
+			}
+		}
+
+	case tea.MouseMsg:
+		return m.handleMouse(msg)
+
+	case packageInfoMsg:
+		// Only update if this info is for the currently selected package
+		if msg.packageName == m.infoForPackage {
+			m.loadingInfo = false
+			if msg.err != nil {
+				m.setPackageInfo("Failed to load package info")
+			} else {
+				m.setPackageInfo(msg.info)
+			}
+			m.localizedInfo, m.localizedInfoFor = msg.localized, msg.packageName
+		}
+		// If it's stale info (user moved selection), just discard it
+		// and keep loadingInfo = true so we continue showing the loading screen
+
+	case transactionPreviewMsg:
+		if msg.key == m.txPreview.key {
+			m.txPreview.loading = false
+			m.txPreview.entries = msg.entries
+			m.txPreview.dependents = msg.dependents
+			m.txPreview.err = msg.err
+		}
+
+	case fileOwnerTickMsg:
+		if msg.seq == m.ownerSeq && m.mode == modeInstall {
+			return m, findFileOwners(m.runner, msg.path, msg.seq)
+		}
+
+	case fileOwnerMsg:
+		// Drop lookups for a path the input has moved on from
+		if msg.seq != m.ownerSeq || m.mode != modeInstall {
+			return m, nil
+		}
+		switch {
+		case msg.noFilesDB && !m.filesDBPrompted:
+			m.filesDBPrompted = true
+			m.showConfirmation = true
+			m.confirmType = confirmSyncFiles
+			m.confirmScrollOffset = 0
+			m.statusMessage = fmt.Sprintf("No installed package owns %s - download the file database?", msg.path)
+			return m, nil
+		case msg.noFilesDB:
+			m.statusMessage = fmt.Sprintf("No installed package owns %s (run pacman -Fy to search all packages)", msg.path)
+			return m, nil
+		case msg.err != nil:
+			m.statusMessage = fmt.Sprintf("Owner lookup failed: %v", msg.err)
+			return m, nil
+		}
+		m.ownerResults = m.ownerPackages(msg.packages)
+		m.filtered = m.ownerResults
+		m.matchQuery = ""
+		m.applyResultSort()
+		m.selectedIndex = 0
+		if len(m.filtered) == 0 {
+			m.statusMessage = fmt.Sprintf("No package owns %s", msg.path)
+			return m, nil
+		}
+		m.statusMessage = fmt.Sprintf("%d package(s) own %s", len(m.filtered), msg.path)
+		m.loadingInfo = true
+		m.infoForPackage = m.filtered[0].Name
+		return m, m.packageInfoCmd(m.filtered[0])
+
+	case fileListMsg:
+		if m.showFileList && msg.pkg == m.fileList.pkg {
+			m.fileList.loading = false
+			m.fileList.entries = msg.entries
+			m.fileList.totalSize = msg.totalSize
+			m.fileList.err = msg.err
+		}
+
+	case depTreeMsg:
+		if m.showDepTree && msg.root == m.depTree.root && msg.reverse == m.depTree.reverse {
+			m.depTree.loading = false
+			m.depTree.lines = msg.lines
+			m.depTree.err = msg.err
+		}
+
+	case debounceTickMsg:
+		// Only fetch if this is still the package the user wants info for
+		// (i.e., they haven't scrolled away since the debounce started)
+		if msg.packageName == m.pendingInfoPackage {
+			m.infoForPackage = msg.packageName
+			// Find the package and fetch its info
+			var pkg *Package
+			if m.mode == modeInstall {
+				for i := range m.filtered {
+					if m.filtered[i].Name == msg.packageName {
+						pkg = &m.filtered[i]
+						break
+					}
+				}
+			} else if m.mode == modeUninstall {
+				for i := range m.filteredInstalled {
+					if m.filteredInstalled[i].Name == msg.packageName {
+						pkg = &m.filteredInstalled[i]
+						break
+					}
+				}
+			}
+			if pkg != nil {
+				return m, m.packageInfoCmd(*pkg)
+			}
+		}
+		// If pendingInfoPackage changed, this tick is stale - ignore it
+
+	case installedPackagesMsg:
+		m.loading = false
+		if msg.err != nil {
+			m.statusMessage = fmt.Sprintf("Error loading packages: %v", msg.err)
+		} else {
+			m.installed = msg.packages
+			if msg.provided != nil {
+				m.installedProvides = msg.provided
+			}
+			
+			// Apply only what changed to installedSet and the install view flags
+			names := make(map[string]bool, len(m.installed))
+			for _, pkg := range m.installed {
+				names[pkg.Name] = true
+			}
+			m.reconcileInstalled(names)
+			
+			// Check if there's a pre-set filter (from dashboard shortcuts)
+			query := m.textInput.Value()
+			if query != "" {
+				// Apply the filter
+				repoScope, query := parseRepoScope(query)
+				sourceFilters, searchQuery := parseUninstallFilter(query)
+				hasSourceFilter := len(sourceFilters) > 0
+				
+				basePackages := m.installed
+				if repoScope != "" {
+					basePackages = packagesFromRepo(basePackages, repoScope)
+				}
+				if hasSourceFilter {
+					var filtered []Package
+					for _, pkg := range basePackages {
+						if sourceFilters["total"] {
+							filtered = append(filtered, pkg)
+						} else {
+							if sourceFilters["explicit"] && pkg.Explicit {
+								filtered = append(filtered, pkg)
+							}
+							if sourceFilters["foreign"] && pkg.Source == "aur" {
+								filtered = append(filtered, pkg)
+							}
+							if sourceFilters["orphan"] && pkg.Orphan {
+								filtered = append(filtered, pkg)
+							}
+						}
+					}
+					basePackages = filtered
+				}
+				
+				if searchQuery != "" {
+					m.filteredInstalled = fuzzyFilter(basePackages, searchQuery)
+					m.installedMatchQuery = searchQuery
+				} else {
+					m.filteredInstalled = basePackages
+					m.installedMatchQuery = ""
+				}
+				
+				// Reset selection to top
+				m.selectedIndex = 0
+				
+				if repoScope != "" {
+					status := fmt.Sprintf("Found %d packages from %s", len(m.filteredInstalled), repoScope)
+					if m.lastCompletedOp != "" {
+						status = m.lastCompletedOp + " | " + status
+					}
+					m.statusMessage = status
+				} else if hasSourceFilter {
+					status := fmt.Sprintf("Found %d %s packages", len(m.filteredInstalled), formatUninstallFilters(sourceFilters))
+					if m.lastCompletedOp != "" {
+						status = m.lastCompletedOp + " | " + status
+					}
+					m.statusMessage = status
+				} else {
+					status := fmt.Sprintf("%d packages - Press [/] to filter", len(m.filteredInstalled))
+					if m.lastCompletedOp != "" {
+						status = m.lastCompletedOp + " | " + status
+					}
+					m.statusMessage = status
+				}
+			} else {
+				m.filteredInstalled = m.installed
+				status := fmt.Sprintf("%d packages - Press [/] to filter", len(m.installed))
+				if m.lastCompletedOp != "" {
+					status = m.lastCompletedOp + " | " + status
+				}
+				m.statusMessage = status
+			}
+			m.applyResultSort()
+			// After a removal, stay where the list was left
+			m.restoreSelection()
+			
+			// A Top 10 package opened from the dashboard starts selected
+			if m.selectAfterLoad != "" {
+				for i, pkg := range m.filteredInstalled {
+					if pkg.Name == m.selectAfterLoad {
+						m.selectedIndex = i
+					}
+				}
+				m.selectAfterLoad = ""
+			}
+			if len(m.filteredInstalled) > 0 {
+				pkg := m.filteredInstalled[m.selectedIndex]
+				m.loadingInfo = true
+				m.infoForPackage = pkg.Name
+				return m, getPackageInfo(m.runner, m.displayRunner, pkg)
+			}
+		}
+
+	case installedNamesMsg:
+		if msg.err == nil {
+			added := m.reconcileInstalled(msg.names)
+			// Newly installed packages need their details for the installed
+			// list, even when as many were removed, as with a replacement
+			if len(m.installed) > 0 && len(added) > 0 {
+				return m, getInstalledPackages(m.runner)
+			}
+		}
+
+	case refreshTickMsg:
+		repo := m.refreshRepo
+		m.refreshPending = false
+		m.refreshRepo = false
+		if repo {
+			return m, tea.Batch(loadRepoPackages(m.runner), checkSyncDBAge())
+		}
+		return m, loadInstalledNames(m.runner)
+
+	case syncDBCheckMsg:
+		m.syncDBTime = msg.modified
+
+	case cacheBreakdownMsg:
+		if m.showBreakdown {
+			marked := m.breakdown.marked
+			m.breakdown = cacheBreakdown{pacmanBytes: msg.pacmanBytes, buildDirs: msg.buildDirs, err: msg.err, marked: marked}
+			m.statusMessage = "Cache breakdown: [space] mark  [d] delete marked  [c] clean pacman cache  [esc] close"
+		}
+
+	case buildDirsRemovedMsg:
+		m.lastCompletedOp = fmt.Sprintf("Deleted %d build director(ies), freed %s", len(msg.removed), formatBytes(msg.freed))
+		if msg.err != nil {
+			m.lastCompletedOp += fmt.Sprintf(" - %v", msg.err)
+		}
+		m.statusMessage = m.lastCompletedOp
+		cmds := []tea.Cmd{getDashboardData(m.runner, m.dashboardCacheDirs())}
+		if m.showBreakdown {
+			m.breakdown.loading = true
+			m.breakdown.marked = make(map[string]bool)
+			cmds = append(cmds, loadCacheBreakdown())
+		}
+		return m, tea.Batch(cmds...)
+
+	case cacheCleanEstimateMsg:
+		m.cacheClean.files = msg.files
+		m.cacheClean.installed = msg.installed
+
+	case cleanupDataMsg:
+		// Ignore results that arrive after the wizard was closed or reopened
+		if m.showCleanup && msg.seq == m.cleanupSeq && m.cleanup.items == nil {
+			m.cleanup.items = msg.items
+			m.cleanup.foreignErr = msg.foreignErr
+			m.cleanup.unusedErr = msg.unusedErr
+			m.statusMessage = "Cleanup: [space] toggle  [enter] next  [b] back  [s] skip  [esc] cancel"
+		}
+
+	case recoveryCheckMsg:
+		m.recoveryFindings = msg.findings
+		m.showRecoveryBanner = len(msg.findings) > 0
+		if len(msg.findings) == 0 {
+			m.showRecovery = false
+		}
+
+	case dashboardMsg:
+		m.loading = false
+		if msg.err != nil {
+			m.statusMessage = fmt.Sprintf("Error loading dashboard: %v", msg.err)
+		} else {
+			m.dashboard = msg.data
+			// Preserve lastCompletedOp message if set, otherwise show default
+			if m.lastCompletedOp != "" {
+				m.statusMessage = m.lastCompletedOp
+			} else {
+				m.statusMessage = "Dashboard loaded"
+			}
+			cmds := []tea.Cmd{countPendingUpdates(m.runner, m.holds, m.pins, m.develUpdates), findPacnewFiles(m.runner), runArchAudit(m.runner), checkSystemHealth(m.runner)}
+			if msg.data.ForeignPackages > 0 {
+				cmds = append(cmds, checkUnmanagedForeign(m.runner, m.localPackages))
+			} else {
+				m.unmanaged = nil
+			}
+			return m, tea.Batch(cmds...)
+		}
+
+	case pacnewCheckMsg:
+		if msg.err != nil {
+			m.statusMessage = fmt.Sprintf("Could not list .pacnew files: %v", msg.err)
+			return m, nil
+		}
+		m.pacnewFiles = msg.files
+		m.pacnewChecked = true
+		if m.pacnewIndex >= len(m.pacnewFiles) {
+			m.pacnewIndex = 0
+		}
+		if len(m.pacnewFiles) == 0 {
+			m.showPacnew = false
+		}
+
+	case systemHealthMsg:
+		m.health = msg
+		if m.failedUnitsIndex >= len(msg.failedUnits) {
+			m.failedUnitsIndex = 0
+		}
+		if len(msg.failedUnits) == 0 {
+			m.showFailedUnits = false
+		}
+
+	case auditCheckMsg:
+		m.audit = msg
+		m.auditChecked = true
+		if m.auditIndex >= len(msg.findings) {
+			m.auditIndex = 0
+		}
+		if len(msg.findings) == 0 {
+			m.showAudit = false
+		}
+
+	case pacdiffClosedMsg:
+		if msg.err != nil {
+			m.statusMessage = fmt.Sprintf("pacdiff failed: %v", msg.err)
+		} else {
+			m.statusMessage = "pacdiff finished"
+		}
+		return m, findPacnewFiles(m.runner)
+
+	case dashboardUpdatesMsg:
+		m.dashboardUpdates = msg.available
+		m.dashboardUpdatesKnown = true
+
+	case unmanagedCheckMsg:
+		m.unmanaged = msg.packages
+		m.unmanagedErr = msg.err
+		if m.unmanagedIndex >= len(m.unmanaged) {
+			m.unmanagedIndex = 0
+		}
+
+	case adoptCompleteMsg:
+		if msg.err != nil {
+			m.statusMessage = fmt.Sprintf("Adopting %s failed: %v", msg.name, msg.err)
+		} else {
+			m.removeUnmanaged(msg.name)
+			m.statusMessage = fmt.Sprintf("%s adopted - %s will now update it", msg.name, helper.name)
+		}
+
+	case updateCheckMsg:
+		m.loading = false
+		if msg.err != nil {
+			m.statusMessage = fmt.Sprintf("Error checking updates: %v", msg.err)
+		} else if len(msg.updates) == 0 {
+			m.statusMessage = "System is up to date!"
+			m.updateOutput = "No updates available."
+		} else {
+			// Show confirmation dialog with available updates
+			m.classifiedUpdates = msg.updates
+			m.setPendingUpdates()
+			m.updateCollapsed = map[updateClass]bool{updateHeld: true, updatePinned: true, updateIgnored: true}
+			m.confirmExcluded = make(map[string]bool)
+			m.showConfirmation = true
+			m.confirmType = confirmUpdate
+			m.confirmScrollOffset = 0
+			m.confirmCursor = 0
+			m.statusMessage = fmt.Sprintf("%d update(s) available", len(m.pendingUpdates))
+			if kept := len(msg.updates) - len(m.pendingUpdates); kept > 0 {
+				m.statusMessage += fmt.Sprintf(", %d held or ignored", kept)
+			}
+
+			// Look for broken AUR dependencies while the dialog is open
+			var aurUpdates []string
+			for _, pkg := range m.pendingUpdates {
+				if pkg.Source == "aur" {
+					aurUpdates = append(aurUpdates, pkg.Name)
+				}
+			}
+			m.aurDepIssues = nil
+			m.aurDepCheckErr = nil
+			m.aurDepCheckSeq++
+			m.aurDepChecking = len(aurUpdates) > 0
+			if m.aurDepChecking {
+				return m, checkAURDependencies(m.runner, aurUpdates, m.repoIndex, m.aurDepCheckSeq)
+			}
+		}
+
+	case historyMsg:
+		if m.mode == modeHistory {
+			m.loading = false
+		}
+		m.history = msg.transactions
+		m.historyErr = msg.err
+		m.filterHistory(m.textInput.Value())
+		if msg.err != nil {
+			m.statusMessage = fmt.Sprintf("Failed to read %s: %v", pacmanLogPath, msg.err)
+		} else {
+			m.statusMessage = fmt.Sprintf("%d transactions, %d package changes - [/] filter by package", len(m.history), len(m.filteredHistory))
+		}
+
+	case archNewsMsg:
+		m.newsLoading = false
+		m.news = msg.items
+		m.newsSince = msg.since
+		m.newsErr = msg.err
+		m.newsOffset = 0
+		m.newsAcknowledged = false
+
+	case aurDependencyCheckMsg:
+		// Results of a check an earlier update check started are stale
+		if msg.seq != m.aurDepCheckSeq {
+			return m, nil
+		}
+		m.aurDepChecking = false
+		m.aurDepIssues = msg.issues
+		m.aurDepCheckErr = msg.err
+
+	case browserOpenedMsg:
+		if msg.err != nil {
+			m.statusMessage = fmt.Sprintf("Could not open %s: %v", msg.url, msg.err)
+		} else {
+			m.statusMessage = "Opened " + msg.url
+		}
+
+	case clipboardMsg:
+		m.statusMessage = clipboardStatus(msg)
+
+	case editorClosedMsg:
+		if msg.err != nil {
+			m.statusMessage = fmt.Sprintf("Editor failed: %v", msg.err)
+		}
+
+	case outputLineMsg:
+		m.streamLines = append(m.streamLines, msg.lines...)
+		if over := len(m.streamLines) - streamLogLimit; over > 0 {
+			m.streamLines = m.streamLines[over:]
+		}
+		if m.streamScroll > 0 {
+			// Keep a scrolled-up view on the same lines
+			m.streamScroll = min(m.streamScroll+len(msg.lines), len(m.streamLines))
+		}
+		return m, waitForOutput(msg.ch)
+
+	case streamPromptMsg:
+		m.streaming = false
+		m.streamStop = nil
+		m.statusMessage = fmt.Sprintf("Waiting for input (%s) - continuing in the terminal", truncateRunes(msg.prompt, 40))
+		return m, msg.fallback()
+
+	case spinner.TickMsg:
+		// The tick chain ends once nothing is busy
+		if !m.spinning {
+			return m, nil
+		}
+		var cmd tea.Cmd
+		m.spinner, cmd = m.spinner.Update(msg)
+		return m, cmd
+
+	case orphanPassMsg:
+		return m.handleOrphanPass(msg)
+
+	case orphanSizesMsg:
+		// Only for the dialog that asked, listing the biggest orphans first
+		if m.showConfirmation && m.confirmType == confirmRemoveOrphans &&
+			strings.Join(msg.names, " ") == strings.Join(m.confirmPackages, " ") {
+			m.orphanSizes = msg.sizes
+			sort.SliceStable(m.confirmPackages, func(i, j int) bool {
+				return msg.sizes[m.confirmPackages[i]] > msg.sizes[m.confirmPackages[j]]
+			})
+		}
+		return m, nil
+
+	case execCompleteMsg:
+		m.streaming = false
+		m.streamStop = nil
+		logOperation(msg)
+		// The lists reload afterwards; keep the place in them
+		m.rememberSelection()
+		// Foreign package rebuilds run as a sequence of batches
+		m.sessionOps = append(m.sessionOps, newSessionOperation(msg))
+		if msg.operation == confirmRebuildForeign {
+			return m.handleRebuildBatchComplete(msg)
+		}
+		// Cleanup wizard actions also run one after another
+		if msg.operation == confirmCleanup {
+			return m.handleCleanupActionComplete(msg)
+		}
+		// So do the steps of a queued transaction
+		if msg.queued {
+			return m.handleQueueStepComplete(msg)
+		}
+		// The -S or -R transaction quits with --exit-after; a failure stays
+		// open on its error and the session carries on
+		if m.exitAfter && (msg.operation == confirmInstall || msg.operation == confirmUninstall) {
+			m.exitAfter = false
+			if msg.err == nil {
+				return m, tea.Quit
+			}
+		}
+
+		m.loading = false
+		m.confirmPackages = nil
+		m.pendingUpdates = nil
+		m.classifiedUpdates = nil
+		
+		// Check if operation failed and show error overlay
+		if msg.err != nil {
+			opName := operationName(msg.operation)
+			
+			m.showErrorOverlay = true
+			m.errorTitle = fmt.Sprintf("%s Failed", opName)
+			m.sessionWarnings = append(m.sessionWarnings, m.errorTitle)
+			m.errorMessage = "The operation exited with a non-zero exit code."
+			
+			// Get error details
+			m.errorDetails = failureDetails(msg.err, msg.output)
+			m.setErrorOutput(msg.output)
+			m.retry, m.retryOp, m.retryPackages = msg.retry, msg.operation, msg.packages
+			m.retryOffered = true
+			if opLogPath != "" {
+				m.errorDetails += fmt.Sprintf("\n\nLogged to %s ([L] to view)", opLogPath)
+			}
+			
+			m.statusMessage = fmt.Sprintf("%s failed", opName)
+			m.lastCompletedOp = ""
+
+			// Builds that left a log open in the log browser instead, which
+			// offers the retry itself
+			if msg.logPath != "" && m.openBuildLog(msg.logPath) {
+				m.showErrorOverlay = false
+				m.retryOffered = false
+			}
+			
+			// Still refresh the appropriate data
+			switch msg.operation {
+			case confirmInstall:
+				refresh := m.requestRefresh(false)
+				return m, refresh
+			case confirmUninstall, confirmInstallReason:
+				return m, getInstalledPackages(m.runner)
+			case confirmUpdate:
+				refresh := m.requestRefresh(true)
+				return m, refresh
+			case confirmCleanCache, confirmCleanCacheDir, confirmRemoveOrphans:
+				return m, getDashboardData(m.runner, m.dashboardCacheDirs())
+			case confirmRemoveLock:
+				return m, checkInterruptedTransaction()
+			case confirmSyncDB:
+				return m, checkSyncDBAge()
+			}
+			return m, nil
+		}
+		
+		// Operation succeeded
+		switch msg.operation {
+		case confirmInstall:
+			if len(msg.packages) == 1 {
+				m.lastCompletedOp = fmt.Sprintf("Installed: %s", msg.packages[0])
+			} else {
+				m.lastCompletedOp = fmt.Sprintf("Installed %d packages", len(msg.packages))
+			}
+			m.statusMessage = m.lastCompletedOp
+			// The installed names are known; dependencies are picked up by the
+			// coalesced reconcile
+			m.applyInstalledChanges(msg.packages, nil)
+			refresh := m.requestRefresh(false)
+			if len(m.recoveryFindings) > 0 {
+				// Reinstalls from the recovery view may have repaired local entries
+				return m, tea.Batch(refresh, checkInterruptedTransaction())
+			}
+			return m, refresh
+		case confirmUninstall:
+			if len(msg.packages) == 1 {
+				m.lastCompletedOp = fmt.Sprintf("Removed: %s", msg.packages[0])
+			} else {
+				m.lastCompletedOp = fmt.Sprintf("Removed %d packages", len(msg.packages))
+			}
+			m.statusMessage = m.lastCompletedOp
+			m.applyInstalledChanges(nil, msg.packages)
+			return m, getInstalledPackages(m.runner)
+		case confirmUpdate:
+			m.lastCompletedOp = "System update completed"
+			m.statusMessage = m.lastCompletedOp
+			refresh := m.requestRefresh(true)
+			return m, refresh
+		case confirmCleanCache:
+			m.lastCompletedOp = "Cache cleaned successfully"
+			m.statusMessage = m.lastCompletedOp
+			return m, getDashboardData(m.runner, m.dashboardCacheDirs())
+		case confirmCleanCacheDir:
+			m.lastCompletedOp = fmt.Sprintf("Cleaned %s cache", m.confirmCacheDir.Label)
+			m.statusMessage = m.lastCompletedOp
+			return m, getDashboardData(m.runner, m.dashboardCacheDirs())
+		case confirmRemoveOrphans:
+			if len(msg.packages) == 1 {
+				m.lastCompletedOp = fmt.Sprintf("Removed orphan: %s", msg.packages[0])
+			} else {
+				m.lastCompletedOp = fmt.Sprintf("Removed %d orphan packages", len(msg.packages))
+			}
+			m.applyInstalledChanges(nil, msg.packages)
+			// Removing orphans can orphan their dependencies in turn
+			m.orphanPasses++
+			m.orphansRemoved += len(msg.packages)
+			m.lastOrphanPass = msg.packages
+			m.statusMessage = m.lastCompletedOp + " - checking for new orphans..."
+			return m, checkNewOrphans(m.runner)
+		case confirmRemoveLock:
+			m.lastCompletedOp = "Removed stale pacman lock"
+			m.statusMessage = m.lastCompletedOp
+			return m, checkInterruptedTransaction()
+		case confirmInstallReason:
+			reason := "explicitly installed"
+			if m.confirmAsDeps {
+				reason = "installed as a dependency"
+			}
+			if len(msg.packages) == 1 {
+				m.lastCompletedOp = fmt.Sprintf("Marked %s as %s", msg.packages[0], reason)
+			} else {
+				m.lastCompletedOp = fmt.Sprintf("Marked %d packages as %s", len(msg.packages), reason)
+			}
+			m.statusMessage = m.lastCompletedOp
+			// The Explicit flags and the dashboard's explicit count change
+			return m, tea.Batch(getInstalledPackages(m.runner), getDashboardData(m.runner, m.dashboardCacheDirs()))
+		case confirmIntegrityCheck:
+			m.lastCompletedOp = "Integrity check passed"
+			m.statusMessage = m.lastCompletedOp
+			return m, nil
+		case confirmSyncDB:
+			m.lastCompletedOp = "Synced the package databases"
+			m.statusMessage = m.lastCompletedOp
+			// New versions and packages only show up once the repo list reloads
+			refresh := m.requestRefresh(true)
+			return m, refresh
+		case confirmSyncFiles:
+			m.lastCompletedOp = "Downloaded the file database"
+			m.statusMessage = m.lastCompletedOp
+			// Repeat the owner lookup that asked for it
+			if path, ok := parseOwnerQuery(m.textInput.Value()); ok && path != "" && m.mode == modeInstall {
+				m.ownerSeq++
+				return m, findFileOwners(m.runner, path, m.ownerSeq)
+			}
+			return m, nil
+		}
+	}
+
+	return m, tea.Batch(cmds...)
+}
+
+// handleDashboardKeys handles the keys of the dashboard. Keys without a case
+// here go on to the keys of every mode; the ones with a case are handled
+// here, whether or not they had anything to do.
+func (m model) handleDashboardKeys(msg tea.KeyMsg) (tea.Model, tea.Cmd, bool) {
+	switch msg.String() {
+	case "c":
+		// Clean cache
+		if !m.loading {
+			m.showConfirmation = true
+			m.confirmType = confirmCleanCache
+			m.confirmScrollOffset = 0
+			_, err := exec.LookPath("paccache")
+			m.cacheClean.paccache = err == nil
+			if !m.cacheClean.paccache && m.cacheClean.strategy == cleanKeepVersions {
+				m.cacheClean.strategy = cleanUninstalled
+			}
+			m.cacheClean.files = nil
+			m.statusMessage = "Confirm cache cleaning"
+			return m, loadCacheCleanEstimate(m.dashboard.PacmanCachePath), true
+		}
+
+	case "R":
+		// Remove orphans when there are any
+		if !m.loading && m.dashboard.Orphans > 0 {
+			// Get orphan list for confirmation
+			if orphanList, _, err := m.runner.Run(helper.name, "-Qdtq"); err == nil && orphanList != "" {
+				orphans := strings.Fields(orphanList)
+				m.confirmPackages = orphans
+				m.showConfirmation = true
+				m.confirmType = confirmRemoveOrphans
+				m.orphanPasses = 0
+				m.orphansRemoved = 0
+				m.lastOrphanPass = nil
+				m.confirmScrollOffset = 0
+				m.orphanSizes = nil
+				m.statusMessage = "Confirm orphan removal"
+				return m, loadOrphanSizes(m.runner, orphans), true
+			}
+			return m, nil, true
+		}
+
+	case "S":
+		// Refresh the sync databases
+		if !m.loading {
+			m.showConfirmation = true
+			m.confirmType = confirmSyncDB
+			m.confirmScrollOffset = 0
+			m.statusMessage = "Confirm syncing the package databases"
+			return m, nil, true
+		}
+
+	case "B":
+		// Rebuild all foreign packages when there are any
+		if !m.loading && m.dashboard.ForeignPackages > 0 {
+			if !helper.aur {
+				m.statusMessage = "Rebuilding foreign packages needs an AUR helper"
+				return m, nil, true
+			}
+			foreign, err := listForeignPackages(m.runner)
+			if err != nil || len(foreign) == 0 {
+				m.statusMessage = "No foreign packages to rebuild"
+				return m, nil, true
+			}
+			m.rebuildCandidates = foreign
+			m.confirmExcluded = make(map[string]bool)
+			m.confirmCursor = 0
+			m.showConfirmation = true
+			m.confirmType = confirmRebuildForeign
+			m.confirmScrollOffset = 0
+			m.statusMessage = "Confirm foreign package rebuild"
+			return m, nil, true
+		}
+
+	case "U":
+		// List foreign packages unmanaged by paru
+		if len(m.unmanaged) > 0 {
+			m.showUnmanaged = true
+			m.unmanagedIndex = 0
+			m.statusMessage = "Unmanaged packages: [a] adopt  [l] mark local  [esc] close"
+			return m, nil, true
+		}
+
+	case "H":
+		// List and release held packages
+		if !m.loading {
+			if len(m.holds) == 0 {
+				m.statusMessage = "No held packages - hold an update with [h] in the update dialog"
+				return m, nil, true
+			}
+			m.showHolds = true
+			m.holdsIndex = 0
+			m.statusMessage = "Held packages: [d] release  [esc] close"
+			return m, nil, true
+		}
+
+	case "A":
+		// List vulnerable packages, or offer to install arch-audit
+		if !m.loading && m.auditChecked {
+			switch {
+			case !m.audit.installed:
+				m.showConfirmation = true
+				m.confirmType = confirmInstall
+				m.confirmPackages = []string{"arch-audit"}
+				m.confirmScrollOffset = 0
+				m.statusMessage = "Confirm installation of arch-audit"
+			case m.audit.err != nil:
+				m.statusMessage = fmt.Sprintf("arch-audit failed: %v", m.audit.err)
+			case len(m.audit.findings) == 0:
+				m.statusMessage = "No installed package has a known vulnerability"
+			default:
+				m.showAudit = true
+				m.auditIndex = 0
+				m.statusMessage = "Vulnerable packages: [u]pdate  [esc] close"
+			}
+			return m, nil, true
+		}
+
+	case "P":
+		// List .pacnew and .pacsave files
+		if !m.loading {
+			if len(m.pacnewFiles) == 0 {
+				m.statusMessage = "No .pacnew or .pacsave files to merge"
+				return m, nil, true
+			}
+			m.showPacnew = true
+			m.pacnewIndex = 0
+			m.statusMessage = "Unmerged configuration files: [p] pacdiff  [esc] close"
+			return m, nil, true
+		}
+
+	case "D":
+		// Choose the cache directories shown in the Storage box
+		if !m.loading {
+			m.showCacheDirs = true
+			m.cacheDirIndex = 0
+			m.statusMessage = "Cache directories: [space] monitor  [c] clean  [esc] close"
+			return m, nil, true
+		}
+
+	case "C":
+		// Break the cache down by directory
+		if !m.loading {
+			m.showBreakdown = true
+			m.breakdown = cacheBreakdown{loading: true, marked: make(map[string]bool)}
+			m.statusMessage = "Sizing cache directories..."
+			return m, loadCacheBreakdown(), true
+		}
+
+	case "M":
+		// Open the cleanup wizard
+		if !m.loading {
+			m.showCleanup = true
+			m.cleanup = cleanupWizard{skipped: make(map[cleanupStep]bool)}
+			m.cleanupSeq++
+			m.statusMessage = "Gathering cleanup candidates..."
+			return m, loadCleanupData(m.runner, m.settings.UsageEstimate, m.cleanupSeq), true
+		}
+
+	case "t", "e", "f", "o":
+		// Open remove mode with the filter of the key
+		if !m.loading {
+			filter := msg.String()
+			cmd := m.openRemoveFiltered(filter+":", "Loading "+removeFilterNames[filter]+" packages...")
+			return m, cmd, true
+		}
+
+	case "1", "2", "3", "4", "5", "6", "7", "8", "9":
+		// Open remove mode showing one third-party repository
+		if !m.loading {
+			i := int(msg.String()[0] - '1')
+			if i >= len(m.dashboard.ThirdPartyRepos) {
+				return m, nil, true
+			}
+			repo := m.dashboard.ThirdPartyRepos[i].Name
+			cmd := m.openRemoveFiltered("in:"+repo+" ", fmt.Sprintf("Loading packages from %s...", repo))
+			return m, cmd, true
+		}
+
+	case "down", "j":
+		m.moveDashboardCursor(1)
+		return m, nil, true
+
+	case "up", "k":
+		m.moveDashboardCursor(-1)
+		return m, nil, true
+
+	case "enter":
+		// Run the action of the row under the cursor
+		if !m.loading {
+			next, cmd := m.activateDashboardItem()
+			return next, cmd, true
+		}
+
+	case "Q":
+		// Remove the selected Top packages entry, through the removal
+		// confirmation and its dependents check
+		if item, ok := m.selectedDashboardItem(); ok && !m.loading && item.pkg != "" {
+			m.showConfirmation = true
+			m.confirmType = confirmUninstall
+			m.confirmPackages = []string{item.pkg}
+			m.confirmScrollOffset = 0
+			m.statusMessage = "Confirm removal"
+			return m, nil, true
+		}
+
+	case "F":
+		// List failed systemd units
+		if !m.loading && m.health.systemd {
+			if len(m.health.failedUnits) == 0 {
+				m.statusMessage = "No failed systemd units"
+				return m, nil, true
+			}
+			m.showFailedUnits = true
+			m.failedUnitsIndex = 0
+			m.statusMessage = "Failed units: [esc] close"
+			return m, nil, true
+		}
+	default:
+		return m, nil, false
+	}
+	return m, nil, true
+}
+
+// handleUpdateKeys handles the keys of update mode. Keys without a case here
+// go on to the keys of every mode.
+func (m model) handleUpdateKeys(msg tea.KeyMsg) (tea.Model, tea.Cmd, bool) {
+	switch msg.String() {
+	case "D":
+		// Toggle VCS package checking and re-run the update check
+		if !m.loading {
+			m.develUpdates = !m.develUpdates
+			m.loading = true
+			m.statusMessage = "Checking for updates..."
+			if m.develUpdates {
+				m.statusMessage = "Checking for updates, devel packages included..."
+			}
+			m.updateOutput = ""
+			m.pendingUpdates = nil
+			m.classifiedUpdates = nil
+			return m, checkUpdates(m.runner, m.holds, m.pins, m.develUpdates), true
+		}
+
+	case "enter":
+		// Confirm the system update
+		if len(m.classifiedUpdates) > 0 {
+			m.showConfirmation = true
+			m.confirmType = confirmUpdate
+			m.confirmScrollOffset = 0
+			m.confirmCursor = 0
+			m.statusMessage = "Confirm system update"
+			return m, nil, true
+		}
+	default:
+		return m, nil, false
+	}
+	return m, nil, true
+}
+
+// handleListKeys handles the keys of the install, remove and history lists.
+// Keys without a case here go on to the keys of every mode.
+func (m model) handleListKeys(msg tea.KeyMsg) (tea.Model, tea.Cmd, bool) {
+	switch msg.String() {
+	case "S":
+		// Save marked packages as a new named set - only in install mode
+		if m.mode == modeInstall && len(m.markedPackages) > 0 {
+			m.namingSet = true
+			m.queryBeforeNaming = m.textInput.Value()
+			m.textInput.SetValue("")
+			m.textInput.Placeholder = "Name for the new package set..."
+			m.textInput.Focus()
+			m.statusMessage = fmt.Sprintf("Name a set for %d marked packages - [enter] save  [esc] cancel", len(m.markedPackages))
+			return m, nil, true
+		}
+
+	case "o", "O":
+		// Open the selected package's upstream URL, or with O its package page
+		if m.mode == modeInstall || m.mode == modeUninstall {
+			cmd := m.openPackageURL(msg.String() == "O")
+			return m, cmd, true
+		}
+
+	case "down", "j":
+		// Down/j moves toward more relevant (lower index, visually down)
+		if m.selectedIndex > 0 {
+			m.selectedIndex--
+			if m.mode == modeInstall && len(m.filtered) > 0 {
+				m.loadingInfo = true
+				m.pendingInfoPackage = m.filtered[m.selectedIndex].Name
+				return m, m.debouncePackageInfo(m.pendingInfoPackage), true
+			} else if m.mode == modeUninstall && len(m.filteredInstalled) > 0 {
+				m.loadingInfo = true
+				m.pendingInfoPackage = m.filteredInstalled[m.selectedIndex].Name
+				return m, m.debouncePackageInfo(m.pendingInfoPackage), true
+			}
+		}
+
+	case "up", "k":
+		// Up/k moves toward less relevant (higher index, visually up)
+		maxIndex := 0
+		if m.mode == modeInstall {
+			maxIndex = len(m.filtered) - 1
+		} else if m.mode == modeUninstall {
+			maxIndex = len(m.filteredInstalled) - 1
+		} else if m.mode == modeHistory {
+			maxIndex = len(m.filteredHistory) - 1
+		}
+		if m.selectedIndex < maxIndex {
+			m.selectedIndex++
+			if m.mode == modeInstall && len(m.filtered) > 0 {
+				m.loadingInfo = true
+				m.pendingInfoPackage = m.filtered[m.selectedIndex].Name
+				return m, m.debouncePackageInfo(m.pendingInfoPackage), true
+			} else if m.mode == modeUninstall && len(m.filteredInstalled) > 0 {
+				m.loadingInfo = true
+				m.pendingInfoPackage = m.filteredInstalled[m.selectedIndex].Name
+				return m, m.debouncePackageInfo(m.pendingInfoPackage), true
+			}
+		}
+
+	case "enter":
+		if m.mode == modeInstall && len(m.filtered) > 0 {
+			// If packages are marked, show confirmation for all marked packages
+			if len(m.markedPackages) > 0 {
+				var pkgsToInstall []string
+				for name := range m.markedPackages {
+					// Check if not already installed
+					if !m.installedSet[name] {
+						pkgsToInstall = append(pkgsToInstall, name)
+					}
+				}
+				if len(pkgsToInstall) > 0 {
+					sort.Strings(pkgsToInstall)
+					m.showConfirmation = true
+					m.confirmType = confirmInstall
+					m.confirmPackages = pkgsToInstall
+					m.confirmScrollOffset = 0
+					m.markedPackages = make(map[string]bool) // Clear marks
+					m.statusMessage = "Confirm installation"
+				} else {
+					m.statusMessage = "All marked packages are already installed"
+				}
+			} else {
+				// Show confirmation for single selected package
+				pkg := m.filtered[m.selectedIndex]
+				if pkg.Source == "set" {
+					next, cmd := m.confirmPackageSet(pkg.Name)
+					return next, cmd, true
+				}
+				if !m.resultInstalled(pkg) {
+					m.showConfirmation = true
+					m.confirmType = confirmInstall
+					m.confirmPackages = []string{pkg.Name}
+					m.confirmScrollOffset = 0
+					m.statusMessage = "Confirm installation"
+				} else {
+					m.statusMessage = fmt.Sprintf("%s is already installed", pkg.Name)
+				}
+			}
+		} else if m.mode == modeUninstall && len(m.filteredInstalled) > 0 {
+			// If packages are marked, show confirmation for all marked packages
+			if len(m.markedPackages) > 0 {
+				var pkgsToUninstall []string
+				for name := range m.markedPackages {
+					pkgsToUninstall = append(pkgsToUninstall, name)
+				}
+				sort.Strings(pkgsToUninstall)
+				m.showConfirmation = true
+				m.confirmType = confirmUninstall
+				m.confirmPackages = pkgsToUninstall
+				m.confirmScrollOffset = 0
+				m.markedPackages = make(map[string]bool) // Clear marks
+				m.statusMessage = "Confirm removal"
+			} else {
+				// Show confirmation for single selected package
+				pkg := m.filteredInstalled[m.selectedIndex]
+				m.showConfirmation = true
+				m.confirmType = confirmUninstall
+				m.confirmPackages = []string{pkg.Name}
+				m.confirmScrollOffset = 0
+				m.statusMessage = "Confirm removal"
+			}
+		}
+
+	case "tab":
+		// Toggle mark on current package
+		if m.mode == modeInstall && len(m.filtered) > 0 {
+			pkg := m.filtered[m.selectedIndex]
+			if m.markedPackages[pkg.Name] {
+				delete(m.markedPackages, pkg.Name)
+			} else {
+				m.markedPackages[pkg.Name] = true
+			}
+			markedCount := len(m.markedPackages)
+			if markedCount > 0 {
+				m.statusMessage = fmt.Sprintf("%d packages marked", markedCount)
+			} else {
+				m.statusMessage = fmt.Sprintf("Found %d packages", len(m.filtered))
+			}
+		} else if m.mode == modeUninstall && len(m.filteredInstalled) > 0 {
+			pkg := m.filteredInstalled[m.selectedIndex]
+			if m.markedPackages[pkg.Name] {
+				delete(m.markedPackages, pkg.Name)
+			} else {
+				m.markedPackages[pkg.Name] = true
+			}
+			markedCount := len(m.markedPackages)
+			if markedCount > 0 {
+				m.statusMessage = fmt.Sprintf("%d packages marked", markedCount)
+			} else {
+				m.statusMessage = fmt.Sprintf("%d installed packages", len(m.installed))
+			}
+		}
+
+	case "Q":
+		// Queue the marked packages and review everything queued
+		if (m.mode == modeInstall || m.mode == modeUninstall) && !m.loading {
+			m.queueMarked()
+			if len(m.queuedInstalls)+len(m.queuedRemovals) == 0 {
+				m.statusMessage = "Nothing queued - mark packages and press [Q]"
+				return m, nil, true
+			}
+			m.showConfirmation = true
+			m.confirmType = confirmQueue
+			m.confirmScrollOffset = 0
+			m.statusMessage = "Review queued transaction"
+			return m, nil, true
+		}
+
+	case "s":
+		// Cycle the order of the results
+		if m.mode == modeInstall || m.mode == modeUninstall {
+			cmd := m.cycleResultSort()
+			return m, cmd, true
+		}
+
+	case "y", "Y":
+		// Copy the marked or selected package names, or with Y an install command
+		if m.mode == modeInstall || m.mode == modeUninstall {
+			cmd := m.yankPackages(msg.String() == "Y")
+			return m, cmd, true
+		}
+
+	case "I":
+		// Hide or show the installed packages among the results
+		if m.mode == modeInstall {
+			cmd := m.toggleHideInstalled()
+			return m, cmd, true
+		}
+
+	case "x":
+		// Flip the install reason of the marked or selected packages - only in remove mode
+		if m.mode == modeUninstall && !m.loading {
+			m.confirmInstallReasonChange()
+			return m, nil, true
+		}
+
+	case "F":
+		// Show the files of the selected installed package - only in remove mode
+		if m.mode == modeUninstall && !m.loading {
+			cmd := m.openFileList()
+			return m, cmd, true
+		}
+
+	case "d":
+		// Show the dependency tree (reverse dependencies in remove mode)
+		if m.mode == modeInstall || m.mode == modeUninstall {
+			cmd := m.toggleDepTree()
+			return m, cmd, true
+		}
+
+	case "/":
+		if (m.mode == modeInstall || m.mode == modeUninstall || m.mode == modeHistory) && !m.textInput.Focused() {
+			m.textInput.Focus()
+			if m.mode == modeInstall && len(m.repoPackages) > 0 && m.textInput.Value() == "" {
+				m.statusMessage = fmt.Sprintf(installSearchHint, m.settings.MinSearchQueryLen, len(m.repoPackages))
+			} else if m.mode == modeUninstall && len(m.installed) > 0 && m.textInput.Value() == "" {
+				m.statusMessage = fmt.Sprintf("Filter: t: total  e: explicit  f: foreign  o: orphan (%d installed)", len(m.installed))
+			}
+		}
+	default:
+		return m, nil, false
+	}
+	return m, nil, true
+}
+
+// removeFilterNames names the packages each remove mode filter prefix shows
+var removeFilterNames = map[string]string{
+	"t": "all",
+	"e": "explicit",
+	"f": "foreign",
+	"o": "orphan",
+}
+
+// openRemoveFiltered switches to remove mode with query as its filter and
+// loads the installed packages
+func (m *model) openRemoveFiltered(query, status string) tea.Cmd {
+	m.mode = modeUninstall
+	m.loading = true
+	m.statusMessage = status
+	m.selectedIndex = 0
+	m.textInput.SetValue(query)
+	m.textInput.Placeholder = "Filter (t: total  e: explicit  f: foreign  o: orphan)..."
+	m.markedPackages = make(map[string]bool)
+	return getInstalledPackages(m.runner)
+}
+
+// handleSetNamingKeys collects the name for a new package set created from
+// the marked packages and writes it to the config file
+func (m model) handleSetNamingKeys(msg tea.KeyMsg) (tea.Model, tea.Cmd) {
+	finish := func(m model, status string) (tea.Model, tea.Cmd) {
+		m.namingSet = false
+		m.textInput.SetValue(m.queryBeforeNaming)
+		m.textInput.Placeholder = "Search packages..."
+		m.textInput.Blur()
+		m.queryBeforeNaming = ""
+		m.statusMessage = status
+		return m, nil
+	}
+
+	switch msg.String() {
+	case "esc":
+		return finish(m, "Set creation cancelled")
+	case "enter":
+		name := strings.TrimSpace(m.textInput.Value())
+		if !isValidSetName(name) {
+			m.statusMessage = "Set names may only contain letters, digits, - and _"
+			return m, nil
+		}
+		if _, exists := m.packageSets[name]; exists {
+			m.statusMessage = fmt.Sprintf("Set %s already exists", name)
+			return m, nil
+		}
+		var members []string
+		for pkgName := range m.markedPackages {
+			members = append(members, pkgName)
+		}
+		sort.Strings(members)
+		if err := addConfigTableEntry(m.configPath, "sets", name, formatTOMLStringArray(members)); err != nil {
+			return finish(m, fmt.Sprintf("Failed to save set %s: %v", name, err))
+		}
+		if m.packageSets == nil {
+			m.packageSets = make(map[string][]string)
+		}
+		m.packageSets[name] = members
+		m.markedPackages = make(map[string]bool)
+		return finish(m, fmt.Sprintf("Saved set %s with %d packages", name, len(members)))
+	}
+
+	var cmd tea.Cmd
+	m.textInput, cmd = m.textInput.Update(msg)
+	return m, cmd
+}
+
+// handleRecoveryKeys handles the guided actions offered by the recovery view
+func (m model) handleRecoveryKeys(msg tea.KeyMsg) (tea.Model, tea.Cmd) {
+	switch msg.String() {
+	case "esc", "q", "!":
+		m.showRecovery = false
+	case "l":
+		if lockPath := m.recoveryLockPath(); lockPath != "" {
+			m.showRecovery = false
+			m.statusMessage = "Removing stale pacman lock..."
+			return m, executeRemoveLockInTerminal(lockPath)
+		}
+	case "r":
+		if pkgs := m.recoveryPackages(); len(pkgs) > 0 {
+			m.showRecovery = false
+			m.showConfirmation = true
+			m.confirmType = confirmInstall
+			m.confirmPackages = pkgs
+			m.confirmScrollOffset = 0
+			m.statusMessage = "Confirm reinstallation"
+		}
+	case "v":
+		m.showRecovery = false
+		m.statusMessage = "Checking the integrity of installed files..."
+		return m, executeIntegrityCheckInTerminal()
+	}
+	return m, nil
+}
+
+// renderRecoveryOverlay explains interrupted-transaction findings and the
+// actions available to fix them
+func (m model) renderRecoveryOverlay(contentWidth, contentHeight int) string {
+	dialogWidth := contentWidth - 20
+	if dialogWidth < 50 {
+		dialogWidth = 50
+	}
+	if dialogWidth > 80 {
+		dialogWidth = 80
+	}
+
+	titleStyle := lipgloss.NewStyle().
+		Bold(true).
+		Foreground(currentTheme.WarningColor).
+		MarginBottom(1)
+	textStyle := lipgloss.NewStyle().
+		Foreground(currentTheme.TextColor).
+		Width(dialogWidth - 6)
+	pathStyle := lipgloss.NewStyle().
+		Foreground(currentTheme.SubtleColor)
+	keyStyle := lipgloss.NewStyle().
+		Foreground(currentTheme.WarningColor).
+		Bold(true)
+	dialogBorderStyle := lipgloss.NewStyle().
+		Border(lipgloss.RoundedBorder()).
+		BorderForeground(currentTheme.WarningColor).
+		Padding(1, 2)
+
+	var content strings.Builder
+	content.WriteString(titleStyle.Render("⚠  Interrupted Transaction Detected"))
+	content.WriteString("\n\n")
+
+	if lockPath := m.recoveryLockPath(); lockPath != "" {
+		content.WriteString(textStyle.Render("pacman's database lock is still present although pacman is not running. "+
+			"Package operations will fail until it is removed."))
+		content.WriteString("\n")
+		content.WriteString(pathStyle.Render("  " + lockPath))
+		content.WriteString("\n\n")
+	}
+
+	var corrupt []recoveryFinding
+	for _, f := range m.recoveryFindings {
+		if f.kind == "corrupt" {
+			corrupt = append(corrupt, f)
+		}
+	}
+	if len(corrupt) > 0 {
+		content.WriteString(textStyle.Render(fmt.Sprintf("%d package(s) have broken entries in the local database, "+
+			"which usually means an install or upgrade was cut short. Reinstalling them restores the entries.", len(corrupt))))
+		content.WriteString("\n")
+		maxVisible := 8
+		for i, f := range corrupt {
+			if i >= maxVisible {
+				content.WriteString(pathStyle.Render(fmt.Sprintf("  ... +%d more", len(corrupt)-maxVisible)))
+				content.WriteString("\n")
+				break
+			}
+			content.WriteString(fmt.Sprintf("  • %s %s\n", f.pkgName, pathStyle.Render("("+f.detail+")")))
+		}
+		content.WriteString("\n")
+		content.WriteString(pathStyle.Render("[v] checks every installed file with pacman -Qkk."))
+		content.WriteString("\n\n")
+	}
+
+	var actions []string
+	if m.recoveryLockPath() != "" {
+		actions = append(actions, keyStyle.Render("[l]")+" remove stale lock")
+	}
+	if len(m.recoveryPackages()) > 0 {
+		actions = append(actions, keyStyle.Render("[r]")+" reinstall affected")
+	}
+	actions = append(actions, keyStyle.Render("[v]")+" verify files")
+	actions = append(actions, keyStyle.Render("[esc]")+" close")
+	content.WriteString(strings.Join(actions, "  "))
+
+	dialog := dialogBorderStyle.Width(dialogWidth).Render(content.String())
+	return centerDialog(dialog, contentWidth, contentHeight)
+}
+
+// cleanupStep is a page of the cleanup wizard
+type cleanupStep int
+
+const (
+	cleanupStepOrphans cleanupStep = iota
+	cleanupStepOptdeps
+	cleanupStepForeign
+	cleanupStepUnused
+	cleanupStepCache
+	cleanupStepReview
+)
+
+// unusedDays is how long a package's programs must have gone unused before
+// the cleanup wizard offers to remove it
+const unusedDays = 90
+
+// cleanupStepTitles are the headings of the selectable wizard steps
+var cleanupStepTitles = map[cleanupStep]string{
+	cleanupStepOrphans: "Orphaned packages",
+	cleanupStepOptdeps: "Unused optional dependencies",
+	cleanupStepForeign: "Foreign packages missing from the AUR",
+	cleanupStepUnused:  fmt.Sprintf("Packages unused for %d+ days", unusedDays),
+	cleanupStepCache:   "Package cache",
+	cleanupStepReview:  "Review",
+}
+
+// cleanupItem is a toggleable entry on a wizard step
+type cleanupItem struct {
+	name     string
+	bytes    int64
+	detail   string // Why the item cannot be selected
+	note     string // Shown dimmed after the size
+	command  []string // Cache options only: command that performs the pruning
+	selected bool
+}
+
+// cleanupAction is one command run when the wizard is executed
+type cleanupAction struct {
+	label    string
+	packages []string // Removed with paru -Rns
+	command  []string // Run as-is when packages is empty
+	bytes    int64
+}
+
+// cleanupWizard holds the state of the cleanup wizard. Selections live on the
+// items of each step so they survive moving back and forth.
+type cleanupWizard struct {
+	step        cleanupStep
+	cursor      int
+	items       map[cleanupStep][]cleanupItem
+	skipped     map[cleanupStep]bool
+	foreignErr  error
+	unusedErr   error // Why the unused step has no candidates, when it cannot estimate usage
+	actions     []cleanupAction
+	actionIndex int
+	executed    []string
+}
+
+type cleanupDataMsg struct {
+	items      map[cleanupStep][]cleanupItem
+	foreignErr error
+	unusedErr  error
+	seq        int
+}
+
+// loadCleanupData gathers the candidates for every wizard step. Unused
+// packages are only looked for when usageEstimate is set.
+func loadCleanupData(r Runner, usageEstimate bool, seq int) tea.Cmd {
+	return func() tea.Msg {
+		items := make(map[cleanupStep][]cleanupItem)
+
+		orphans := pacmanNames(r, "-Qdtq")
+		isOrphan := make(map[string]bool)
+		for _, name := range orphans {
+			isOrphan[name] = true
+		}
+		// -Qdtt also lists dependencies only optionally required by something
+		var optdeps []string
+		for _, name := range pacmanNames(r, "-Qdttq") {
+			if !isOrphan[name] {
+				optdeps = append(optdeps, name)
+			}
+		}
+
+		// Foreign packages the AUR no longer knows about
+		var missing []string
+		resolver := &aurInfoResolver{
+			known:  make(map[string]*aurPackageInfo),
+		}
+		foreign := pacmanNames(r, "-Qmq")
+		foreignErr := resolver.fetch(foreign)
+		if foreignErr == nil {
+			for _, name := range foreign {
+				if resolver.known[name] == nil {
+					missing = append(missing, name)
+				}
+			}
+		}
+
+		// Explicitly installed packages whose programs went unused; the
+		// foreign ones missing from the AUR are already offered above
+		var unused []string
+		var lastUsed map[string]time.Time
+		unusedErr := fmt.Errorf("the usage estimate is off; turn on usage_estimate in settings [,]")
+		if usageEstimate {
+			lastUsed, unusedErr = unusedPackages(r, time.Now().AddDate(0, 0, -unusedDays))
+			isMissing := make(map[string]bool)
+			for _, name := range missing {
+				isMissing[name] = true
+			}
+			for name := range lastUsed {
+				if !isMissing[name] {
+					unused = append(unused, name)
+				}
+			}
+			sort.Strings(unused)
+		}
+
+		sizes := installedSizes(r, append(append(append(append([]string{}, orphans...), optdeps...), missing...), unused...))
+		toItems := func(names []string, selected bool) []cleanupItem {
+			list := make([]cleanupItem, 0, len(names))
+			for _, name := range names {
+				list = append(list, cleanupItem{name: name, bytes: sizes[name], selected: selected})
+			}
+			return list
+		}
+		items[cleanupStepOrphans] = toItems(orphans, true)
+		items[cleanupStepOptdeps] = toItems(optdeps, false)
+		items[cleanupStepForeign] = toItems(missing, false)
+		items[cleanupStepUnused] = toItems(unused, false)
+		for i := range items[cleanupStepUnused] {
+			item := &items[cleanupStepUnused][i]
+			item.note = "last used " + lastUsed[item.name].Format("2006-01-02")
+		}
+		items[cleanupStepCache] = cacheCleanupOptions("/var/cache/pacman/pkg", pacmanNames(r, "-Qq"))
+
+		return cleanupDataMsg{items: items, foreignErr: foreignErr, unusedErr: unusedErr, seq: seq}
+	}
+}
+
+// unusedPackages estimates which explicitly installed packages went unused
+// since cutoff, returning when each was last used. A package's use is the
+// latest access or change time of its programs in /usr/bin, so an upgrade
+// counts as use and packages without programs are never reported. Access
+// times do not move on a noatime mount, which is reported as an error.
+func unusedPackages(r Runner, cutoff time.Time) (map[string]time.Time, error) {
+	mounts, err := os.ReadFile("/proc/self/mounts")
+	if err != nil {
+		return nil, fmt.Errorf("cannot read mount options: %w", err)
+	}
+	if mountHasOption(string(mounts), "/usr/bin", "noatime") {
+		return nil, fmt.Errorf("/usr/bin is mounted noatime, so program use is not recorded")
+	}
+	names, _ := sanitizePackageNames(pacmanNames(r, "-Qeq"))
+	if len(names) == 0 {
+		return nil, nil
+	}
+	out, _, err := r.Run("pacman", append([]string{"-Ql"}, names...)...)
+	if err != nil {
+		return nil, err
+	}
+	return findUnusedPackages(out, programLastUsed, cutoff), nil
+}
+
+// findUnusedPackages reads pacman -Ql output and returns the packages whose
+// programs in /usr/bin were all last used before cutoff. lastUsed reports
+// when a file was last used, or false when it cannot tell.
+func findUnusedPackages(fileList string, lastUsed func(path string) (time.Time, bool), cutoff time.Time) map[string]time.Time {
+	latest := make(map[string]time.Time)
+	for _, line := range strings.Split(fileList, "\n") {
+		name, path, ok := strings.Cut(line, " ")
+		if !ok || !strings.HasPrefix(path, "/usr/bin/") || strings.HasSuffix(path, "/") {
+			continue
+		}
+		used, ok := lastUsed(path)
+		if !ok {
+			continue
+		}
+		if used.After(latest[name]) {
+			latest[name] = used
+		}
+	}
+	unused := make(map[string]time.Time)
+	for name, used := range latest {
+		if used.Before(cutoff) {
+			unused[name] = used
+		}
+	}
+	return unused
+}
+
+// programLastUsed returns the later of a file's access and change times.
+// The change time moves when the file is installed or upgraded.
+func programLastUsed(path string) (time.Time, bool) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return time.Time{}, false
+	}
+	stat, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return time.Time{}, false
+	}
+	used := time.Unix(stat.Atim.Unix())
+	if changed := time.Unix(stat.Ctim.Unix()); changed.After(used) {
+		used = changed
+	}
+	return used, true
+}
+
+// mountHasOption reports whether the filesystem holding path, found by the
+// longest matching mount point in /proc/self/mounts, is mounted with option
+func mountHasOption(mounts, path, option string) bool {
+	best, options := "", ""
+	for _, line := range strings.Split(mounts, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) < 4 {
+			continue
+		}
+		point := fields[1]
+		if path != point && !strings.HasPrefix(path, strings.TrimSuffix(point, "/")+"/") {
+			continue
+		}
+		if len(point) >= len(best) {
+			best, options = point, fields[3]
+		}
+	}
+	for _, o := range strings.Split(options, ",") {
+		if o == option {
+			return true
+		}
+	}
+	return false
+}
+
+// pacmanNames runs a pacman query that prints one package name per line
+func pacmanNames(r Runner, flag string) []string {
+	out, _, _ := r.Run("pacman", flag) // Exits non-zero when nothing matches
+	return strings.Fields(out)
+}
+
+// installedSizes returns the installed size of each named package from pacman -Qi
+func installedSizes(r Runner, names []string) map[string]int64 {
+	sizes := make(map[string]int64)
+	validNames, _ := sanitizePackageNames(names)
+	if len(validNames) == 0 {
+		return sizes
+	}
+	out, _, _ := r.Run("pacman", append([]string{"-Qi"}, validNames...)...)
+
+	var current string
+	for _, line := range strings.Split(out, "\n") {
+		key, value, ok := strings.Cut(line, ":")
+		if !ok {
+			continue
+		}
+		switch strings.TrimSpace(key) {
+		case "Name":
+			current = strings.TrimSpace(value)
+		case "Installed Size":
+			sizes[current] = parseSizeToBytes(value)
+		}
+	}
+	return sizes
+}
+
+// cachePackageName extracts the package name from a cache file name such as
+// "foo-bar-1.2-1-x86_64.pkg.tar.zst"
+func cachePackageName(file string) (string, bool) {
+	if !strings.Contains(file, ".pkg.tar") || strings.HasSuffix(file, ".sig") {
+		return "", false
+	}
+	name := file
+	for i := 0; i < 3; i++ {
+		idx := strings.LastIndex(name, "-")
+		if idx <= 0 {
+			return "", false
+		}
+		name = name[:idx]
+	}
+	return name, true
+}
+
+// cacheCleanupOptions estimates what each cache pruning option would reclaim.
+// Files of uninstalled packages and all but the newest file of installed
+// packages are counted separately; the newest file is judged by modification time.
+func cacheCleanupOptions(cacheDir string, installedNames []string) []cleanupItem {
+	installed := make(map[string]bool)
+	for _, name := range installedNames {
+		installed[name] = true
+	}
+
+	type cachedFile struct {
+		size    int64
+		modTime time.Time
+	}
+	byName := make(map[string][]cachedFile)
+	entries, _ := os.ReadDir(cacheDir)
+	for _, entry := range entries {
+		if entry.IsDir() {
+			continue
+		}
+		name, ok := cachePackageName(entry.Name())
+		if !ok {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			continue
+		}
+		byName[name] = append(byName[name], cachedFile{size: info.Size(), modTime: info.ModTime()})
+	}
+
+	var uninstalledBytes, oldBytes int64
+	for name, files := range byName {
+		if !installed[name] {
+			for _, f := range files {
+				uninstalledBytes += f.size
+			}
+			continue
+		}
+		newest := 0
+		for i, f := range files {
+			if f.modTime.After(files[newest].modTime) {
+				newest = i
+			}
+		}
+		for i, f := range files {
+			if i != newest {
+				oldBytes += f.size
+			}
+		}
+	}
+
+	detail := ""
+	if _, err := exec.LookPath("paccache"); err != nil {
+		detail = "requires pacman-contrib"
+	}
+	items := []cleanupItem{
+		{name: "Remove cached uninstalled packages", bytes: uninstalledBytes, detail: detail, command: []string{"sudo", "paccache", "-ruk0"}},
+		{name: "Keep only the newest cached version", bytes: oldBytes, detail: detail, command: []string{"sudo", "paccache", "-rk1"}},
+	}
+	if helper.aur {
+		items = append(items, cleanupItem{name: "Clean " + helper.name + " build cache", bytes: calculateDirSize(aurHelperCacheDir(helper.name)), command: []string{helper.name, "-Sc", "--aur"}})
+	}
+	return items
+}
+
+// buildCleanupActions turns the selections of all non-skipped steps into the
+// ordered list of actions: package removals first, then cache pruning so the
+// cache of just-removed packages is pruned too
+func (w cleanupWizard) buildCleanupActions() []cleanupAction {
+	var actions []cleanupAction
+	removals := []struct {
+		step  cleanupStep
+		label string
+	}{
+		{cleanupStepOrphans, "Remove %d orphan(s)"},
+		{cleanupStepOptdeps, "Remove %d unused optional dependency(ies)"},
+		{cleanupStepForeign, "Remove %d foreign package(s) missing from the AUR"},
+		{cleanupStepUnused, "Remove %d package(s) unused for " + strconv.Itoa(unusedDays) + "+ days"},
+	}
+	for _, r := range removals {
+		if w.skipped[r.step] {
+			continue
+		}
+		action := cleanupAction{}
+		for _, item := range w.items[r.step] {
+			if item.selected {
+				action.packages = append(action.packages, item.name)
+				action.bytes += item.bytes
+			}
+		}
+		if len(action.packages) > 0 {
+			action.label = fmt.Sprintf(r.label, len(action.packages))
+			actions = append(actions, action)
+		}
+	}
+	if !w.skipped[cleanupStepCache] {
+		for _, item := range w.items[cleanupStepCache] {
+			if item.selected && item.detail == "" {
+				actions = append(actions, cleanupAction{label: item.name, command: item.command, bytes: item.bytes})
+			}
+		}
+	}
+	return actions
+}
+
+// executeCleanupActionInTerminal runs one cleanup action interactively using tea.ExecProcess
+func executeCleanupActionInTerminal(action cleanupAction) tea.Cmd {
+	var c *exec.Cmd
+	var freePath string
+	if len(action.packages) > 0 {
+		// Validate all package names to prevent command injection
+		validNames, _ := sanitizePackageNames(action.packages)
+		if len(validNames) == 0 {
+			return func() tea.Msg {
+				return execCompleteMsg{operation: confirmCleanup, packages: action.packages, err: fmt.Errorf("no valid package names")}
+			}
+		}
+		c = helper.command(append([]string{"-Rns"}, validNames...)...)
+	} else {
+		c = exec.Command(action.command[0], action.command[1:]...)
+		// Cache pruning: paccache works on the pacman cache, paru -Sc --aur on its clones
+		freePath = "/var/cache/pacman/pkg"
+		if action.command[0] == helper.name {
+			freePath = paruCloneDir()
+		}
+	}
+	finish := captureOutput(c)
+	freeBefore, _ := filesystemFreeBytes(freePath)
+	started := time.Now()
+	return execProcess(c, func(err error) tea.Msg {
+		return execCompleteMsg{operation: confirmCleanup, packages: action.packages, started: started, freePath: freePath, freeBefore: freeBefore, output: finish(err), err: err}
+	})
+}
+
+// handleCleanupActionComplete records a finished cleanup action and runs the
+// next one. A failed or cancelled action stops the wizard; the actions that
+// already ran are reported.
+func (m model) handleCleanupActionComplete(msg execCompleteMsg) (tea.Model, tea.Cmd) {
+	w := &m.cleanup
+	action := w.actions[w.actionIndex]
+	if msg.err == nil {
+		w.executed = append(w.executed, action.label)
+		m.applyInstalledChanges(nil, msg.packages)
+		w.actionIndex++
+		if w.actionIndex < len(w.actions) {
+			m.statusMessage = fmt.Sprintf("Cleanup step %d of %d: %s...", w.actionIndex+1, len(w.actions), w.actions[w.actionIndex].label)
+			return m, executeCleanupActionInTerminal(w.actions[w.actionIndex])
+		}
+	}
+
+	m.loading = false
+	m.lastCompletedOp = fmt.Sprintf("Cleanup: %d of %d action(s) completed", len(w.executed), len(w.actions))
+	m.statusMessage = m.lastCompletedOp
+	if msg.err != nil {
+		var pending []string
+		for _, a := range w.actions[w.actionIndex:] {
+			pending = append(pending, "  • "+a.label)
+		}
+		done := "  (none)"
+		if len(w.executed) > 0 {
+			done = "  • " + strings.Join(w.executed, "\n  • ")
+		}
+		m.showErrorOverlay = true
+		m.errorTitle = "Cleanup Stopped"
+		m.errorMessage = fmt.Sprintf("%q did not complete, so the remaining actions were not run.", action.label)
+		m.errorDetails = fmt.Sprintf("Completed:\n%s\n\nNot run:\n%s", done, strings.Join(pending, "\n"))
+		m.setErrorOutput(msg.output)
+		m.retry, m.retryOffered = nil, true
+		m.sessionWarnings = append(m.sessionWarnings, m.errorTitle)
+	}
+	m.cleanup = cleanupWizard{}
+	refresh := m.requestRefresh(false)
+	return m, tea.Batch(getDashboardData(m.runner, m.dashboardCacheDirs()), refresh)
+}
+
+// cleanupStepItems returns the items shown on the current step
+func (m model) cleanupStepItems() []cleanupItem {
+	return m.cleanup.items[m.cleanup.step]
+}
+
+// handleCleanupKeys handles navigation, toggling and execution in the cleanup wizard
+func (m model) handleCleanupKeys(msg tea.KeyMsg) (tea.Model, tea.Cmd) {
+	w := &m.cleanup
+	items := m.cleanupStepItems()
+	switch msg.String() {
+	case "esc", "q":
+		// Nothing has run yet, so aborting leaves the system untouched
+		m.showCleanup = false
+		m.cleanup = cleanupWizard{}
+		m.statusMessage = "Cleanup cancelled"
+	case "up", "k":
+		if w.cursor > 0 {
+			w.cursor--
+		}
+	case "down", "j":
+		if w.cursor < len(items)-1 {
+			w.cursor++
+		}
+	case " ", "tab":
+		if w.cursor < len(items) && items[w.cursor].detail == "" {
+			items[w.cursor].selected = !items[w.cursor].selected
+		}
+	case "s":
+		if w.step != cleanupStepReview {
+			w.skipped[w.step] = !w.skipped[w.step]
+			if w.skipped[w.step] {
+				w.step++
+				w.cursor = 0
+			}
+		}
+	case "left", "h", "backspace", "b":
+		if w.step > cleanupStepOrphans {
+			w.step--
+			w.cursor = 0
+		}
+	case "right", "l", "enter", "n":
+		if w.step < cleanupStepReview {
+			w.step++
+			w.cursor = 0
+			if w.step == cleanupStepReview {
+				w.actions = w.buildCleanupActions()
+			}
+			return m, nil
+		}
+		if len(w.actions) == 0 {
+			m.showCleanup = false
+			m.cleanup = cleanupWizard{}
+			m.statusMessage = "Nothing selected to clean up"
+			return m, nil
+		}
+		m.showCleanup = false
+		m.loading = true
+		w.actionIndex = 0
+		m.statusMessage = fmt.Sprintf("Cleanup step 1 of %d: %s...", len(w.actions), w.actions[0].label)
+		return m, executeCleanupActionInTerminal(w.actions[0])
+	}
+	return m, nil
+}
+
+// renderCleanupOverlay renders the current step of the cleanup wizard
+func (m model) renderCleanupOverlay(contentWidth, contentHeight int, activeColor lipgloss.Color) string {
+	dialogWidth := contentWidth - 20
+	if dialogWidth < 50 {
+		dialogWidth = 50
+	}
+	if dialogWidth > 80 {
+		dialogWidth = 80
+	}
+
+	w := m.cleanup
+	titleStyle := lipgloss.NewStyle().
+		Bold(true).
+		Foreground(activeColor).
+		MarginBottom(1)
+	subtleStyle := lipgloss.NewStyle().
+		Foreground(currentTheme.SubtleColor)
+	nameStyle := lipgloss.NewStyle().
+		Foreground(currentTheme.TextColor)
+	countStyle := lipgloss.NewStyle().
+		Foreground(currentTheme.WarningColor).
+		Bold(true)
+	keyStyle := lipgloss.NewStyle().
+		Foreground(activeColor).
+		Bold(true)
+	dialogBorderStyle := lipgloss.NewStyle().
+		Border(lipgloss.RoundedBorder()).
+		BorderForeground(activeColor).
+		Padding(1, 2)
+
+	var content strings.Builder
+	content.WriteString(titleStyle.Render(fmt.Sprintf("🧹 Cleanup — Step %d of %d: %s",
+		int(w.step)+1, int(cleanupStepReview)+1, cleanupStepTitles[w.step])))
+	content.WriteString("\n\n")
+
+	if w.items == nil {
+		content.WriteString(subtleStyle.Render("Gathering cleanup candidates..."))
+		content.WriteString("\n\n")
+		content.WriteString(keyStyle.Render("[esc]") + " cancel")
+		return centerDialog(dialogBorderStyle.Width(dialogWidth).Render(content.String()), contentWidth, contentHeight)
+	}
+
+	if w.step == cleanupStepReview {
+		var total int64
+		if len(w.actions) == 0 {
+			content.WriteString(subtleStyle.Render("Nothing selected. Go back to pick something, or close the wizard."))
+			content.WriteString("\n")
+		}
+		for i, action := range w.actions {
+			content.WriteString(fmt.Sprintf("  %d. %s %s\n", i+1, nameStyle.Render(action.label), subtleStyle.Render(formatBytes(action.bytes))))
+			total += action.bytes
+		}
+		content.WriteString("\n")
+		content.WriteString(fmt.Sprintf("Estimated space reclaimed: %s\n", countStyle.Render(formatBytes(total))))
+		content.WriteString(subtleStyle.Render("Actions run in this order; a failed or cancelled action stops the rest."))
+		content.WriteString("\n\n")
+		content.WriteString(strings.Join([]string{
+			keyStyle.Render("[enter]") + " run",
+			keyStyle.Render("[b]") + " back",
+			keyStyle.Render("[esc]") + " cancel",
+		}, "  "))
+		return centerDialog(dialogBorderStyle.Width(dialogWidth).Render(content.String()), contentWidth, contentHeight)
+	}
+
+	items := m.cleanupStepItems()
+	switch {
+	case w.skipped[w.step]:
+		content.WriteString(countStyle.Render("Skipped — press [s] to include this step again"))
+		content.WriteString("\n\n")
+	case w.step == cleanupStepForeign && w.foreignErr != nil:
+		content.WriteString(subtleStyle.Render("AUR check unavailable: " + w.foreignErr.Error()))
+		content.WriteString("\n\n")
+	case w.step == cleanupStepUnused && w.unusedErr != nil:
+		content.WriteString(subtleStyle.Render("Usage unknown: " + w.unusedErr.Error()))
+		content.WriteString("\n\n")
+	case len(items) == 0:
+		content.WriteString(subtleStyle.Render("Nothing to clean up here."))
+		content.WriteString("\n\n")
+	}
+
+	// Scrolling list of items around the cursor
+	maxVisible := 10
+	start := 0
+	if w.cursor >= maxVisible {
+		start = w.cursor - maxVisible + 1
+	}
+	end := start + maxVisible
+	if end > len(items) {
+		end = len(items)
+	}
+	if start > 0 {
+		content.WriteString(subtleStyle.Render(fmt.Sprintf("  ↑ %d more above\n", start)))
+	}
+	var selectedBytes int64
+	for _, item := range items {
+		if item.selected && item.detail == "" {
+			selectedBytes += item.bytes
+		}
+	}
+	for i := start; i < end; i++ {
+		item := items[i]
+		cursor := "  "
+		if i == w.cursor {
+			cursor = keyStyle.Render("> ")
+		}
+		checkbox := "[ ]"
+		if item.selected {
+			checkbox = "[x]"
+		}
+		line := fmt.Sprintf("%s%s %s %s", cursor, checkbox, nameStyle.Render(item.name), subtleStyle.Render(formatBytes(item.bytes)))
+		if item.note != "" {
+			line += " " + subtleStyle.Render(item.note)
+		}
+		if item.detail != "" {
+			line += " " + countStyle.Render("("+item.detail+")")
+		}
+		content.WriteString(line + "\n")
+	}
+	if end < len(items) {
+		content.WriteString(subtleStyle.Render(fmt.Sprintf("  ↓ %d more below\n", len(items)-end)))
+	}
+
+	content.WriteString("\n")
+	content.WriteString(fmt.Sprintf("Selected: %s\n\n", countStyle.Render(formatBytes(selectedBytes))))
+	content.WriteString(strings.Join([]string{
+		keyStyle.Render("[space]") + " toggle",
+		keyStyle.Render("[enter]") + " next",
+		keyStyle.Render("[b]") + " back",
+		keyStyle.Render("[s]") + " skip",
+		keyStyle.Render("[esc]") + " cancel",
+	}, "  "))
+
+	return centerDialog(dialogBorderStyle.Width(dialogWidth).Render(content.String()), contentWidth, contentHeight)
+}
+
+// monitoredCacheDirs returns the cache directories shown in the Storage box, in
+// config order. All configured directories are shown when no selection was made.
+func (m model) monitoredCacheDirs() []CacheDir {
+	if m.monitoredCaches == nil {
+		return m.cacheDirs
+	}
+	selected := make(map[string]bool)
+	for _, label := range m.monitoredCaches {
+		selected[label] = true
+	}
+	var dirs []CacheDir
+	for _, dir := range m.cacheDirs {
+		if selected[dir.Label] {
+			dirs = append(dirs, dir)
+		}
+	}
+	return dirs
+}
+
+// dashboardCacheDirs returns the cache directories sized for the dashboard:
+// the monitored ones, plus the package manager caches that the combined
+// cache total counts even when they are not shown
+func (m model) dashboardCacheDirs() []CacheDir {
+	var dirs []CacheDir
+	for _, dir := range m.cacheDirs {
+		if dir.PackageManager || m.isCacheMonitored(dir.Label) {
+			dirs = append(dirs, dir)
+		}
+	}
+	return dirs
+}
+
+// isCacheMonitored reports whether the cache dir with label is shown in the Storage box
+func (m model) isCacheMonitored(label string) bool {
+	for _, dir := range m.monitoredCacheDirs() {
+		if dir.Label == label {
+			return true
+		}
+	}
+	return false
+}
+
+// cacheDirSize returns the last measured size of a cache dir, if it is monitored
+func (m model) cacheDirSize(label string) (int64, bool) {
+	for _, dir := range m.dashboard.CacheDirSizes {
+		if dir.Label == label {
+			return dir.Bytes, true
+		}
+	}
+	return 0, false
+}
+
+// executeCleanCacheDirInTerminal runs the configured clean command of a cache
+// directory interactively using tea.ExecProcess
+func executeCleanCacheDirInTerminal(dir CacheDir) tea.Cmd {
+	args := strings.Fields(dir.Clean)
+	err := validateCleanPath(dir.Path)
+	if err == nil {
+		err = validateCleanCommand(dir.Clean, dir.Path)
+	}
+	if err != nil {
+		return func() tea.Msg {
+			return execCompleteMsg{operation: confirmCleanCacheDir, err: err}
+		}
+	}
+	c := exec.Command(args[0], args[1:]...)
+	finish := captureOutput(c)
+	freeBefore, _ := filesystemFreeBytes(dir.Path)
+	started := time.Now()
+	return execProcess(c, func(err error) tea.Msg {
+		retry := func() tea.Cmd { return executeCleanCacheDirInTerminal(dir) }
+		return execCompleteMsg{operation: confirmCleanCacheDir, started: started, freePath: dir.Path, freeBefore: freeBefore, output: finish(err), retry: retry, err: err}
+	})
+}
+
+// handleCacheDirKeys handles toggling and cleaning in the cache directories overlay
+func (m model) handleCacheDirKeys(msg tea.KeyMsg) (tea.Model, tea.Cmd) {
+	switch msg.String() {
+	case "esc", "q", "D":
+		m.showCacheDirs = false
+	case "up", "k":
+		if m.cacheDirIndex > 0 {
+			m.cacheDirIndex--
+		}
+	case "down", "j":
+		if m.cacheDirIndex < len(m.cacheDirs)-1 {
+			m.cacheDirIndex++
+		}
+	case " ", "tab":
+		if m.cacheDirIndex >= len(m.cacheDirs) {
+			return m, nil
+		}
+		toggled := m.cacheDirs[m.cacheDirIndex].Label
+		var labels []string
+		for _, dir := range m.cacheDirs {
+			monitored := m.isCacheMonitored(dir.Label)
+			if dir.Label == toggled {
+				monitored = !monitored
+			}
+			if monitored {
+				labels = append(labels, dir.Label)
+			}
+		}
+		if labels == nil {
+			labels = []string{}
+		}
+		m.monitoredCaches = labels
+		if err := saveConfigValues(m.configPath, map[string]string{"monitored_caches": formatTOMLStringArray(labels)}); err != nil {
+			m.statusMessage = fmt.Sprintf("Could not save monitored caches: %v", err)
+		} else {
+			m.statusMessage = fmt.Sprintf("Monitoring %d cache director(ies)", len(labels))
+		}
+		return m, getDashboardData(m.runner, m.dashboardCacheDirs())
+	case "c":
+		if m.cacheDirIndex >= len(m.cacheDirs) {
+			return m, nil
+		}
+		dir := m.cacheDirs[m.cacheDirIndex]
+		if dir.Clean == "" {
+			m.statusMessage = fmt.Sprintf("No clean command configured for %s", dir.Label)
+			return m, nil
+		}
+		m.showCacheDirs = false
+		m.confirmCacheDir = dir
+		m.showConfirmation = true
+		m.confirmType = confirmCleanCacheDir
+		m.confirmScrollOffset = 0
+		m.statusMessage = fmt.Sprintf("Confirm cleaning %s", dir.Label)
+	}
+	return m, nil
+}
+
+// buildDir is a package's directory in the AUR helper's clone cache
+type buildDir struct {
+	name  string
+	path  string
+	bytes int64
+}
+
+// cacheBreakdown is the state of the cache breakdown overlay
+type cacheBreakdown struct {
+	loading     bool
+	pacmanBytes int64
+	buildDirs   []buildDir // Largest first
+	err         error      // The clone directory could not be read
+	index       int        // Cursor; 0 is the pacman cache, then buildDirs
+	marked      map[string]bool
+	armed       string // Marked paths a first [d] asked to delete
+}
+
+// cacheBreakdownMsg carries the sizes of the pacman cache and each build directory
+type cacheBreakdownMsg struct {
+	pacmanBytes int64
+	buildDirs   []buildDir
+	err         error
+}
+
+// buildDirsRemovedMsg reports the build directories deleted from the breakdown
+type buildDirsRemovedMsg struct {
+	removed []string
+	freed   int64
+	err     error
+}
+
+// cloneCacheDir returns where the AUR helper keeps its per-package build
+// directories, or "" for backends without AUR support
+func cloneCacheDir() string {
+	if !helper.aur {
+		return ""
+	}
+	return filepath.Join(aurHelperCacheDir(helper.name), helper.cloneDir)
+}
+
+// loadCacheBreakdown sizes the pacman cache and every build directory
+func loadCacheBreakdown() tea.Cmd {
+	return func() tea.Msg {
+		pacmanCachePath := "/var/cache/pacman/pkg"
+		paths := []string{pacmanCachePath}
+		var dirs []buildDir
+		var err error
+		if root := cloneCacheDir(); root != "" {
+			var entries []os.DirEntry
+			entries, err = os.ReadDir(root)
+			if os.IsNotExist(err) {
+				err = nil
+			}
+			for _, entry := range entries {
+				if entry.IsDir() {
+					path := filepath.Join(root, entry.Name())
+					dirs = append(dirs, buildDir{name: entry.Name(), path: path})
+					paths = append(paths, path)
+				}
+			}
+		}
+		sizes := calculateDirSizes(paths)
+		for i := range dirs {
+			dirs[i].bytes = sizes[dirs[i].path]
+		}
+		sort.SliceStable(dirs, func(i, j int) bool { return dirs[i].bytes > dirs[j].bytes })
+		return cacheBreakdownMsg{pacmanBytes: sizes[pacmanCachePath], buildDirs: dirs, err: err}
+	}
+}
+
+// removeBuildDirs deletes build directories from the clone cache. They are
+// owned by the user, so no root is needed; anything outside the clone cache
+// is refused.
+func removeBuildDirs(dirs []buildDir) tea.Cmd {
+	return func() tea.Msg {
+		var msg buildDirsRemovedMsg
+		root := cloneCacheDir()
+		for _, dir := range dirs {
+			if root == "" || filepath.Dir(dir.path) != root {
+				msg.err = fmt.Errorf("refusing to delete %s outside %s", dir.path, root)
+				continue
+			}
+			if err := os.RemoveAll(dir.path); err != nil {
+				msg.err = err
+				continue
+			}
+			msg.removed = append(msg.removed, dir.name)
+			msg.freed += dir.bytes
+		}
+		return msg
+	}
+}
+
+// markedBuildDirs returns the build directories marked in the breakdown
+func (b cacheBreakdown) markedBuildDirs() []buildDir {
+	var dirs []buildDir
+	for _, dir := range b.buildDirs {
+		if b.marked[dir.path] {
+			dirs = append(dirs, dir)
+		}
+	}
+	return dirs
+}
+
+// handleBreakdownKeys handles marking and deleting in the cache breakdown overlay
+func (m model) handleBreakdownKeys(msg tea.KeyMsg) (tea.Model, tea.Cmd) {
+	key := msg.String()
+	if m.breakdown.loading && key != "esc" && key != "q" && key != "C" {
+		return m, nil
+	}
+	if key != "d" && key != "x" {
+		m.breakdown.armed = ""
+	}
+	switch key {
+	case "esc", "q", "C":
+		m.showBreakdown = false
+	case "up", "k":
+		if m.breakdown.index > 0 {
+			m.breakdown.index--
+		}
+	case "down", "j":
+		if m.breakdown.index < len(m.breakdown.buildDirs) {
+			m.breakdown.index++
+		}
+	case " ", "tab":
+		if i := m.breakdown.index - 1; i >= 0 && i < len(m.breakdown.buildDirs) {
+			path := m.breakdown.buildDirs[i].path
+			if m.breakdown.marked[path] {
+				delete(m.breakdown.marked, path)
+			} else {
+				m.breakdown.marked[path] = true
+			}
+			if m.breakdown.index < len(m.breakdown.buildDirs) {
+				m.breakdown.index++
+			}
+		}
+	case "c", "enter":
+		if m.breakdown.index == 0 {
+			// The system cache is cleaned by pacman or the AUR helper
+			m.showBreakdown = false
+			return m.update(keyMsgFor("c"))
+		}
+		if key == "enter" {
+			return m.handleBreakdownKeys(tea.KeyMsg{Type: tea.KeySpace, Runes: []rune(" ")})
+		}
+	case "d", "x":
+		dirs := m.breakdown.markedBuildDirs()
+		if len(dirs) == 0 {
+			m.statusMessage = "Mark build directories with [space] first"
+			return m, nil
+		}
+		var total int64
+		var paths []string
+		for _, dir := range dirs {
+			total += dir.bytes
+			paths = append(paths, dir.path)
+		}
+		if armed := strings.Join(paths, "\x00"); m.breakdown.armed != armed {
+			m.breakdown.armed = armed
+			m.statusMessage = fmt.Sprintf("Delete %d build director(ies), %s? Press [d] again to confirm", len(dirs), formatBytes(total))
+			return m, nil
+		}
+		m.breakdown.armed = ""
+		m.breakdown.loading = true
+		m.statusMessage = fmt.Sprintf("Deleting %d build director(ies)...", len(dirs))
+		return m, removeBuildDirs(dirs)
+	}
+	return m, nil
+}
+
+// renderBreakdownOverlay lists the pacman cache and the AUR build
+// directories, largest first
+func (m model) renderBreakdownOverlay(contentWidth, contentHeight int, activeColor lipgloss.Color) string {
+	dialogWidth := contentWidth - 20
+	if dialogWidth < 50 {
+		dialogWidth = 50
+	}
+	if dialogWidth > 80 {
+		dialogWidth = 80
+	}
+
+	titleStyle := lipgloss.NewStyle().
+		Bold(true).
+		Foreground(activeColor).
+		MarginBottom(1)
+	labelStyle := lipgloss.NewStyle().
+		Foreground(currentTheme.TextColor).
+		Bold(true)
+	subtleStyle := lipgloss.NewStyle().
+		Foreground(currentTheme.SubtleColor)
+	keyStyle := lipgloss.NewStyle().
+		Foreground(activeColor).
+		Bold(true)
+	dialogBorderStyle := lipgloss.NewStyle().
+		Border(lipgloss.RoundedBorder()).
+		BorderForeground(activeColor).
+		Padding(1, 2)
+
+	b := m.breakdown
+	var content strings.Builder
+	content.WriteString(titleStyle.Render("💾 Cache Breakdown"))
+	content.WriteString("\n\n")
+	if b.loading {
+		content.WriteString(subtleStyle.Render("Sizing cache directories..."))
+		dialog := dialogBorderStyle.Width(dialogWidth).Render(content.String())
+		return centerDialog(dialog, contentWidth, contentHeight)
+	}
+
+	cursor := func(i int) string {
+		if i == b.index {
+			return keyStyle.Render("> ")
+		}
+		return "  "
+	}
+	sizeWidth := 10
+	nameWidth := dialogWidth - 6 - 2 - 4 - sizeWidth - 2
+	content.WriteString(fmt.Sprintf("%s    %-*s %*s\n", cursor(0),
+		nameWidth, "/var/cache/pacman/pkg", sizeWidth, formatBytes(b.pacmanBytes)))
+	content.WriteString(subtleStyle.Render("      system cache, cleaned through "+helper.name+" with [c]") + "\n\n")
+
+	root := cloneCacheDir()
+	switch {
+	case root == "":
+		content.WriteString(subtleStyle.Render(helper.name + " keeps no AUR build directories") + "\n")
+	case b.err != nil:
+		content.WriteString(lipgloss.NewStyle().Foreground(currentTheme.WarningColor).Render(fmt.Sprintf("Could not read %s: %v", root, b.err)) + "\n")
+	case len(b.buildDirs) == 0:
+		content.WriteString(subtleStyle.Render("No build directories in "+root) + "\n")
+	default:
+		var total int64
+		for _, dir := range b.buildDirs {
+			total += dir.bytes
+		}
+		content.WriteString(labelStyle.Render(fmt.Sprintf("%d AUR build directories, %s", len(b.buildDirs), formatBytes(total))) + "\n")
+		content.WriteString(subtleStyle.Render("  "+root) + "\n")
+		maxVisible := 10
+		start := 0
+		if b.index-1 >= maxVisible {
+			start = b.index - maxVisible
+		}
+		end := start + maxVisible
+		if end > len(b.buildDirs) {
+			end = len(b.buildDirs)
+		}
+		if start > 0 {
+			content.WriteString(subtleStyle.Render(fmt.Sprintf("  ↑ %d more above", start)) + "\n")
+		}
+		for i := start; i < end; i++ {
+			dir := b.buildDirs[i]
+			checkbox := "[ ]"
+			if b.marked[dir.path] {
+				checkbox = "[x]"
+			}
+			name := truncateRunes(dir.name, nameWidth)
+			if !m.installedSet[dir.name] {
+				name = truncateRunes(dir.name+" (not installed)", nameWidth)
+			}
+			content.WriteString(fmt.Sprintf("%s%s %-*s %*s\n", cursor(i+1), checkbox, nameWidth, name, sizeWidth, formatBytes(dir.bytes)))
+		}
+		if end < len(b.buildDirs) {
+			content.WriteString(subtleStyle.Render(fmt.Sprintf("  ↓ %d more below", len(b.buildDirs)-end)) + "\n")
+		}
+	}
+
+	if marked := b.markedBuildDirs(); len(marked) > 0 {
+		var total int64
+		for _, dir := range marked {
+			total += dir.bytes
+		}
+		content.WriteString("\n" + keyStyle.Render(fmt.Sprintf("%d marked, %s", len(marked), formatBytes(total))) + "\n")
+	}
+	content.WriteString("\n")
+	content.WriteString(strings.Join([]string{
+		keyStyle.Render("[space]") + " mark",
+		keyStyle.Render("[d]") + " delete marked",
+		keyStyle.Render("[c]") + " clean pacman cache",
+		keyStyle.Render("[esc]") + " close",
+	}, "  "))
+
+	dialog := dialogBorderStyle.Width(dialogWidth).Render(content.String())
+	return centerDialog(dialog, contentWidth, contentHeight)
+}
+
+// renderCacheDirOverlay lists the configured cache directories with their
+// monitoring state and clean commands
+func (m model) renderCacheDirOverlay(contentWidth, contentHeight int, activeColor lipgloss.Color) string {
+	dialogWidth := contentWidth - 20
+	if dialogWidth < 50 {
+		dialogWidth = 50
+	}
+	if dialogWidth > 80 {
+		dialogWidth = 80
+	}
+
+	titleStyle := lipgloss.NewStyle().
+		Bold(true).
+		Foreground(activeColor).
+		MarginBottom(1)
+	labelStyle := lipgloss.NewStyle().
+		Foreground(currentTheme.TextColor).
+		Bold(true)
+	subtleStyle := lipgloss.NewStyle().
+		Foreground(currentTheme.SubtleColor)
+	keyStyle := lipgloss.NewStyle().
+		Foreground(activeColor).
+		Bold(true)
+	dialogBorderStyle := lipgloss.NewStyle().
+		Border(lipgloss.RoundedBorder()).
+		BorderForeground(activeColor).
+		Padding(1, 2)
+
+	var content strings.Builder
+	content.WriteString(titleStyle.Render("💾 Cache Directories"))
+	content.WriteString("\n\n")
+
+	for i, dir := range m.cacheDirs {
+		cursor := "  "
+		if i == m.cacheDirIndex {
+			cursor = keyStyle.Render("> ")
+		}
+		checkbox := "[ ]"
+		if m.isCacheMonitored(dir.Label) {
+			checkbox = "[x]"
+		}
+		size := ""
+		if bytes, ok := m.cacheDirSize(dir.Label); ok {
+			size = " " + formatBytes(bytes)
+		}
+		content.WriteString(fmt.Sprintf("%s%s %s%s\n", cursor, checkbox, labelStyle.Render(dir.Label), subtleStyle.Render(size)))
+		detail := dir.Path
+		if dir.Clean != "" {
+			detail += "  clean: " + dir.Clean
+		}
+		if dir.PackageManager {
+			detail += "  (in cache total)"
+		}
+		content.WriteString(subtleStyle.Render("      "+detail) + "\n")
+	}
+
+	content.WriteString("\n")
+	content.WriteString(subtleStyle.Render("Add directories under [[cache_dirs]] in " + m.configPath))
+	content.WriteString("\n\n")
+	content.WriteString(strings.Join([]string{
+		keyStyle.Render("[space]") + " monitor",
+		keyStyle.Render("[c]") + " clean",
+		keyStyle.Render("[esc]") + " close",
+	}, "  "))
+
+	dialog := dialogBorderStyle.Width(dialogWidth).Render(content.String())
+	return centerDialog(dialog, contentWidth, contentHeight)
+}
+
+// unmanagedPackage is a foreign package without a clone in paru's clone directory
+type unmanagedPackage struct {
+	name    string
+	pkgBase string // AUR package base; the name when not in the AUR
+	inAUR   bool
+}
+
+type unmanagedCheckMsg struct {
+	packages []unmanagedPackage
+	err      error // AUR lookup failed; inAUR is unknown
+}
+
+type adoptCompleteMsg struct {
+	name string
+	err  error
+}
+
+// stateDir returns gaur's state directory ($XDG_STATE_HOME/gaur)
+func stateDir() string {
+	dir := os.Getenv("XDG_STATE_HOME")
+	if dir == "" {
+		home, _ := os.UserHomeDir()
+		dir = filepath.Join(home, ".local", "state")
+	}
+	return filepath.Join(dir, "gaur")
+}
+
+// opLogLines is how many lines of the operation log the log overlay shows
+const opLogLines = 50
+
+// opLog records every operation gaur runs; it discards everything until
+// main opens the log file
+var opLog = slog.New(slog.NewTextHandler(io.Discard, nil))
+
+// opLogPath is the file opLog appends to, empty when there is none
+var opLogPath string
+
+// operationLogPath is the default --log file
+func operationLogPath() string {
+	return filepath.Join(stateDir(), "operations.log")
+}
+
+// openOperationLog points opLog at path, appending to it; debug also logs
+// every command run through the model's Runner
+func openOperationLog(path string, debug bool) (*os.File, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return nil, err
+	}
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o644)
+	if err != nil {
+		return nil, err
+	}
+	level := slog.LevelInfo
+	if debug {
+		level = slog.LevelDebug
+	}
+	opLog = slog.New(slog.NewTextHandler(f, &slog.HandlerOptions{Level: level}))
+	opLogPath = path
+	return f, nil
+}
+
+// logOperation appends a finished operation to the operation log
+func logOperation(msg execCompleteMsg) {
+	exitCode := 0
+	if msg.err != nil {
+		exitCode = -1
+		if exitErr, ok := msg.err.(*exec.ExitError); ok {
+			exitCode = exitErr.ExitCode()
+		}
+	}
+	attrs := []any{
+		"type", operationName(msg.operation),
+		"packages", strings.Join(msg.packages, " "),
+		"start", msg.started.Format(time.RFC3339),
+		"end", time.Now().Format(time.RFC3339),
+		"exit_code", exitCode,
+	}
+	if msg.batch != "" {
+		attrs = append(attrs, "batch", msg.batch)
+	}
+	if msg.logPath != "" {
+		attrs = append(attrs, "output", msg.logPath)
+	}
+	if msg.err != nil {
+		opLog.Error("operation", append(attrs, "error", msg.err)...)
+		return
+	}
+	opLog.Info("operation", attrs...)
+}
+
+// logTailChunk is how much of the log readLogTail reads at a time
+const logTailChunk = 64 << 10
+
+// readLogTail returns the last n lines of the file at path. The log is never
+// trimmed, so it is read backwards from the end until n lines are in.
+func readLogTail(path string, n int) ([]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	offset := info.Size()
+	var data []byte
+	for offset > 0 && bytes.Count(data, []byte("\n")) <= n {
+		size := min(offset, logTailChunk)
+		offset -= size
+		chunk := make([]byte, size)
+		if _, err := f.ReadAt(chunk, offset); err != nil {
+			return nil, err
+		}
+		data = append(chunk, data...)
+	}
+	if offset > 0 {
+		// The first line read is cut off
+		data = data[bytes.IndexByte(data, '\n')+1:]
+	}
+	lines := strings.Split(strings.TrimRight(string(data), "\n"), "\n")
+	if len(lines) == 1 && lines[0] == "" {
+		return nil, nil
+	}
+	if len(lines) > n {
+		lines = lines[len(lines)-n:]
+	}
+	return lines, nil
+}
+
+// localPackagesPath is where packages marked as intentionally local are kept
+func localPackagesPath() string {
+	return filepath.Join(stateDir(), "local-packages")
+}
+
+// paruCloneDir returns the directory paru clones AUR repositories into
+func paruCloneDir() string {
+	return filepath.Join(aurHelperCacheDir(helper.name), helper.cloneDir)
+}
+
+// loadLocalPackages reads the packages marked as intentionally local.
+// restored is set when a corrupt file was replaced by its backup.
+func loadLocalPackages(path string) (local map[string]bool, restored bool) {
+	local = make(map[string]bool)
+	data, restored, err := readFileWithRecovery(path, validPackageList)
+	if err != nil {
+		return local, false
+	}
+	for _, name := range strings.Fields(string(data)) {
+		local[name] = true
+	}
+	return local, restored
+}
+
+// saveLocalPackages writes the packages marked as intentionally local, one per line
+func saveLocalPackages(path string, local map[string]bool) error {
+	names := make([]string, 0, len(local))
+	for name := range local {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return writeFileAtomic(path, []byte(strings.Join(names, "\n")+"\n"), validPackageList)
+}
+
+// classifyForeignPackages returns the foreign packages that have no clone
+// directory, skipping packages marked as local. aur holds AUR lookups by name
+// (nil: not in the AUR); clones holds the clone directory names.
+func classifyForeignPackages(foreign []string, clones map[string]bool, aur map[string]*aurPackageInfo, local map[string]bool) []unmanagedPackage {
+	var unmanaged []unmanagedPackage
+	for _, name := range foreign {
+		if local[name] {
+			continue
+		}
+		pkg := unmanagedPackage{name: name, pkgBase: name}
+		if info := aur[name]; info != nil {
+			pkg.inAUR = true
+			if info.PackageBase != "" {
+				pkg.pkgBase = info.PackageBase
+			}
+		}
+		if clones[pkg.pkgBase] || clones[name] {
+			continue
+		}
+		unmanaged = append(unmanaged, pkg)
+	}
+	return unmanaged
+}
+
+// checkUnmanagedForeign finds foreign packages that paru does not manage
+func checkUnmanagedForeign(r Runner, local map[string]bool) tea.Cmd {
+	return func() tea.Msg {
+		foreign := pacmanNames(r, "-Qmq")
+		clones := make(map[string]bool)
+		if entries, err := os.ReadDir(paruCloneDir()); err == nil {
+			for _, entry := range entries {
+				if entry.IsDir() {
+					clones[entry.Name()] = true
+				}
+			}
+		}
+
+		resolver := &aurInfoResolver{
+			known:  make(map[string]*aurPackageInfo),
+		}
+		err := resolver.fetch(foreign)
+		return unmanagedCheckMsg{packages: classifyForeignPackages(foreign, clones, resolver.known, local), err: err}
+	}
+}
+
+// adoptPackage clones the AUR repository of pkg into paru's clone directory
+// without building it, so paru's update flows pick it up
+func adoptPackage(pkg unmanagedPackage) tea.Cmd {
+	return func() tea.Msg {
+		if !isValidPackageName(pkg.pkgBase) {
+			return adoptCompleteMsg{name: pkg.name, err: fmt.Errorf("invalid package base %q", pkg.pkgBase)}
+		}
+		dest := filepath.Join(paruCloneDir(), pkg.pkgBase)
+		if err := os.MkdirAll(paruCloneDir(), 0o755); err != nil {
+			return adoptCompleteMsg{name: pkg.name, err: err}
+		}
+		cmd := exec.Command("git", "clone", "https://aur.archlinux.org/"+pkg.pkgBase+".git", dest)
+		var stderr bytes.Buffer
+		cmd.Stderr = &stderr
+		start := time.Now()
+		err := cmd.Run()
+		logExec(cmd, start, err)
+		if err != nil {
+			return adoptCompleteMsg{name: pkg.name, err: commandError("git clone", err, stderr.String())}
+		}
+		return adoptCompleteMsg{name: pkg.name}
+	}
+}
+
+// handleUnmanagedKeys handles navigation and actions in the unmanaged packages overlay
+func (m model) handleUnmanagedKeys(msg tea.KeyMsg) (tea.Model, tea.Cmd) {
+	switch msg.String() {
+	case "esc", "q", "U":
+		m.showUnmanaged = false
+	case "up", "k":
+		if m.unmanagedIndex > 0 {
+			m.unmanagedIndex--
+		}
+	case "down", "j":
+		if m.unmanagedIndex < len(m.unmanaged)-1 {
+			m.unmanagedIndex++
+		}
+	case "a":
+		if m.unmanagedIndex < len(m.unmanaged) {
+			pkg := m.unmanaged[m.unmanagedIndex]
+			if !pkg.inAUR && m.unmanagedErr == nil {
+				m.statusMessage = fmt.Sprintf("%s is not in the AUR - mark it as local with [l]", pkg.name)
+				return m, nil
+			}
+			m.statusMessage = fmt.Sprintf("Cloning %s into %s...", pkg.pkgBase, paruCloneDir())
+			return m, adoptPackage(pkg)
+		}
+	case "l":
+		if m.unmanagedIndex < len(m.unmanaged) {
+			pkg := m.unmanaged[m.unmanagedIndex]
+			m.localPackages[pkg.name] = true
+			if err := saveLocalPackages(localPackagesPath(), m.localPackages); err != nil {
+				delete(m.localPackages, pkg.name)
+				m.statusMessage = fmt.Sprintf("Could not save local packages: %v", err)
+				return m, nil
+			}
+			m.removeUnmanaged(pkg.name)
+			m.statusMessage = fmt.Sprintf("%s marked as local", pkg.name)
+		}
+	}
+	return m, nil
+}
+
+// removeUnmanaged drops a package from the unmanaged list and closes the
+// overlay once it is empty
+func (m *model) removeUnmanaged(name string) {
+	for i, pkg := range m.unmanaged {
+		if pkg.name == name {
+			m.unmanaged = append(m.unmanaged[:i:i], m.unmanaged[i+1:]...)
+			break
+		}
+	}
+	if m.unmanagedIndex >= len(m.unmanaged) && m.unmanagedIndex > 0 {
+		m.unmanagedIndex--
+	}
+	if len(m.unmanaged) == 0 {
+		m.showUnmanaged = false
+	}
+}
+
+// handleHoldsKeys handles navigation and releasing in the held packages overlay
+func (m model) handleHoldsKeys(msg tea.KeyMsg) (tea.Model, tea.Cmd) {
+	names := sortedKeys(m.holds)
+	switch msg.String() {
+	case "esc", "q", "H":
+		m.showHolds = false
+	case "up", "k":
+		if m.holdsIndex > 0 {
+			m.holdsIndex--
+		}
+	case "down", "j":
+		if m.holdsIndex < len(names)-1 {
+			m.holdsIndex++
+		}
+	case "d", "x", "delete":
+		if m.holdsIndex >= len(names) {
+			return m, nil
+		}
+		name := names[m.holdsIndex]
+		if err := m.releaseHold(name); err != nil {
+			m.statusMessage = fmt.Sprintf("Released hold on %s for this session; could not save holds: %v", name, err)
+		} else {
+			m.statusMessage = fmt.Sprintf("Released hold on %s", name)
+		}
+		if m.holdsIndex >= len(m.holds) && m.holdsIndex > 0 {
+			m.holdsIndex--
+		}
+		if len(m.holds) == 0 {
+			m.showHolds = false
+		}
+	}
+	return m, nil
+}
+
+// releaseHold drops a package from the hold list, moving a pending held
+// update back to the available ones, and saves the list to the config file
+func (m *model) releaseHold(name string) error {
+	delete(m.holds, name)
+	for i, u := range m.classifiedUpdates {
+		if u.pkg.Name == name && u.class == updateHeld {
+			m.classifiedUpdates[i].class = updateAvailable
+			delete(m.confirmExcluded, name)
+		}
+	}
+	m.setPendingUpdates()
+	return saveConfigValues(m.configPath, map[string]string{"holds": formatTOMLStringArray(sortedKeys(m.holds))})
+}
+
+// renderHoldsOverlay lists the packages held back from updates
+func (m model) renderHoldsOverlay(contentWidth, contentHeight int, activeColor lipgloss.Color) string {
+	dialogWidth := contentWidth - 20
+	if dialogWidth < 50 {
+		dialogWidth = 50
+	}
+	if dialogWidth > 80 {
+		dialogWidth = 80
+	}
+
+	titleStyle := lipgloss.NewStyle().
+		Bold(true).
+		Foreground(activeColor).
+		MarginBottom(1)
+	textStyle := lipgloss.NewStyle().
+		Foreground(currentTheme.TextColor).
+		Width(dialogWidth - 6)
+	subtleStyle := lipgloss.NewStyle().
+		Foreground(currentTheme.SubtleColor)
+	keyStyle := lipgloss.NewStyle().
+		Foreground(activeColor).
+		Bold(true)
+	dialogBorderStyle := lipgloss.NewStyle().
+		Border(lipgloss.RoundedBorder()).
+		BorderForeground(activeColor).
+		Padding(1, 2)
+
+	names := sortedKeys(m.holds)
+	var content strings.Builder
+	content.WriteString(titleStyle.Render(fmt.Sprintf("🔒 %d Held Package(s)", len(names))))
+	content.WriteString("\n\n")
+	content.WriteString(textStyle.Render("Held packages are passed to " + helper.name + " as --ignore on every update. " +
+		"Releasing one lets the next update upgrade it."))
+	content.WriteString("\n\n")
+
+	maxVisible := 10
+	start := 0
+	if m.holdsIndex >= maxVisible {
+		start = m.holdsIndex - maxVisible + 1
+	}
+	end := start + maxVisible
+	if end > len(names) {
+		end = len(names)
+	}
+	for i := start; i < end; i++ {
+		cursor := "  "
+		if i == m.holdsIndex {
+			cursor = keyStyle.Render("> ")
+		}
+		note := ""
+		if len(m.installedSet) > 0 && !m.installedSet[names[i]] {
+			note = " (not installed)"
+		}
+		content.WriteString(fmt.Sprintf("%s%s%s\n", cursor, names[i], subtleStyle.Render(note)))
+	}
+	if end < len(names) {
+		content.WriteString(subtleStyle.Render(fmt.Sprintf("  ↓ %d more below\n", len(names)-end)))
+	}
+
+	content.WriteString("\n")
+	content.WriteString(subtleStyle.Render("Stored as holds in " + m.configPath))
+	content.WriteString("\n\n")
+	content.WriteString(strings.Join([]string{
+		keyStyle.Render("[d]") + " release",
+		keyStyle.Render("[esc]") + " close",
+	}, "  "))
+
+	dialog := dialogBorderStyle.Width(dialogWidth).Render(content.String())
+	return centerDialog(dialog, contentWidth, contentHeight)
+}
+
+// handlePacnewKeys handles navigation and pacdiff in the .pacnew files overlay
+func (m model) handlePacnewKeys(msg tea.KeyMsg) (tea.Model, tea.Cmd) {
+	switch msg.String() {
+	case "esc", "q", "P":
+		m.showPacnew = false
+	case "up", "k":
+		if m.pacnewIndex > 0 {
+			m.pacnewIndex--
+		}
+	case "down", "j":
+		if m.pacnewIndex < len(m.pacnewFiles)-1 {
+			m.pacnewIndex++
+		}
+	case "p":
+		if _, err := exec.LookPath("pacdiff"); err != nil {
+			m.statusMessage = "pacdiff not found - install pacman-contrib to merge from gaur"
+			return m, nil
+		}
+		m.showPacnew = false
+		return m, runPacdiff()
+	}
+	return m, nil
+}
+
+// renderPacnewOverlay lists the .pacnew and .pacsave files with their owners
+func (m model) renderPacnewOverlay(contentWidth, contentHeight int, activeColor lipgloss.Color) string {
+	dialogWidth := contentWidth - 20
+	if dialogWidth < 50 {
+		dialogWidth = 50
+	}
+	if dialogWidth > 80 {
+		dialogWidth = 80
+	}
+
+	titleStyle := lipgloss.NewStyle().
+		Bold(true).
+		Foreground(activeColor).
+		MarginBottom(1)
+	textStyle := lipgloss.NewStyle().
+		Foreground(currentTheme.TextColor).
+		Width(dialogWidth - 6)
+	subtleStyle := lipgloss.NewStyle().
+		Foreground(currentTheme.SubtleColor)
+	keyStyle := lipgloss.NewStyle().
+		Foreground(activeColor).
+		Bold(true)
+	dialogBorderStyle := lipgloss.NewStyle().
+		Border(lipgloss.RoundedBorder()).
+		BorderForeground(activeColor).
+		Padding(1, 2)
+
+	var content strings.Builder
+	content.WriteString(titleStyle.Render(fmt.Sprintf("📝 %d Unmerged Configuration File(s)", len(m.pacnewFiles))))
+	content.WriteString("\n\n")
+	content.WriteString(textStyle.Render("pacman keeps your modified configuration and writes the packaged one beside it " +
+		"as .pacnew, or saves yours as .pacsave when the package drops it. Merge them so new defaults aren't missed."))
+	content.WriteString("\n\n")
+
+	maxVisible := 10
+	start := 0
+	if m.pacnewIndex >= maxVisible {
+		start = m.pacnewIndex - maxVisible + 1
+	}
+	end := start + maxVisible
+	if end > len(m.pacnewFiles) {
+		end = len(m.pacnewFiles)
+	}
+	pathWidth := dialogWidth - 30
+	for i := start; i < end; i++ {
+		file := m.pacnewFiles[i]
+		cursor := "  "
+		if i == m.pacnewIndex {
+			cursor = keyStyle.Render("> ")
+		}
+		owner := file.owner
+		if owner == "" {
+			owner = "no owner"
+		}
+		content.WriteString(fmt.Sprintf("%s%-*s %s\n", cursor, pathWidth, truncateRunes(file.path, pathWidth),
+			subtleStyle.Render(truncateRunes(owner, 22))))
+	}
+	if end < len(m.pacnewFiles) {
+		content.WriteString(subtleStyle.Render(fmt.Sprintf("  ↓ %d more below\n", len(m.pacnewFiles)-end)))
+	}
+
+	content.WriteString("\n")
+	content.WriteString(strings.Join([]string{
+		keyStyle.Render("[p]") + " merge with pacdiff",
+		keyStyle.Render("[esc]") + " close",
+	}, "  "))
+
+	dialog := dialogBorderStyle.Width(dialogWidth).Render(content.String())
+	return centerDialog(dialog, contentWidth, contentHeight)
+}
+
+// handleFailedUnitsKeys handles navigation in the failed systemd units overlay
+func (m model) handleFailedUnitsKeys(msg tea.KeyMsg) (tea.Model, tea.Cmd) {
+	switch msg.String() {
+	case "esc", "q", "F":
+		m.showFailedUnits = false
+	case "up", "k":
+		if m.failedUnitsIndex > 0 {
+			m.failedUnitsIndex--
+		}
+	case "down", "j":
+		if m.failedUnitsIndex < len(m.health.failedUnits)-1 {
+			m.failedUnitsIndex++
+		}
+	}
+	return m, nil
+}
+
+// renderFailedUnitsOverlay lists the systemd units that failed
+func (m model) renderFailedUnitsOverlay(contentWidth, contentHeight int, activeColor lipgloss.Color) string {
+	dialogWidth := contentWidth - 20
+	if dialogWidth < 50 {
+		dialogWidth = 50
+	}
+	if dialogWidth > 80 {
+		dialogWidth = 80
+	}
+
+	titleStyle := lipgloss.NewStyle().
+		Bold(true).
+		Foreground(activeColor).
+		MarginBottom(1)
+	textStyle := lipgloss.NewStyle().
+		Foreground(currentTheme.TextColor).
+		Width(dialogWidth - 6)
+	subtleStyle := lipgloss.NewStyle().
+		Foreground(currentTheme.SubtleColor)
+	keyStyle := lipgloss.NewStyle().
+		Foreground(activeColor).
+		Bold(true)
+	dialogBorderStyle := lipgloss.NewStyle().
+		Border(lipgloss.RoundedBorder()).
+		BorderForeground(activeColor).
+		Padding(1, 2)
+
+	units := m.health.failedUnits
+	var content strings.Builder
+	content.WriteString(titleStyle.Render(fmt.Sprintf("💥 %d Failed Unit(s)", len(units))))
+	content.WriteString("\n\n")
+	content.WriteString(textStyle.Render("Inspect a unit with systemctl status <unit> or journalctl -u <unit>, " +
+		"and clear the list with systemctl reset-failed once fixed."))
+	content.WriteString("\n\n")
+
+	maxVisible := 10
+	start := 0
+	if m.failedUnitsIndex >= maxVisible {
+		start = m.failedUnitsIndex - maxVisible + 1
+	}
+	end := start + maxVisible
+	if end > len(units) {
+		end = len(units)
+	}
+	for i := start; i < end; i++ {
+		cursor := "  "
+		if i == m.failedUnitsIndex {
+			cursor = keyStyle.Render("> ")
+		}
+		content.WriteString(cursor + truncateRunes(units[i], dialogWidth-8) + "\n")
+	}
+	if end < len(units) {
+		content.WriteString(subtleStyle.Render(fmt.Sprintf("  ↓ %d more below\n", len(units)-end)))
+	}
+
+	content.WriteString("\n")
+	content.WriteString(keyStyle.Render("[esc]") + " close")
+
+	dialog := dialogBorderStyle.Width(dialogWidth).Render(content.String())
+	return centerDialog(dialog, contentWidth, contentHeight)
+}
+
+// handleAuditKeys handles navigation in the security advisories overlay
+func (m model) handleAuditKeys(msg tea.KeyMsg) (tea.Model, tea.Cmd) {
+	switch msg.String() {
+	case "esc", "q", "A":
+		m.showAudit = false
+	case "up", "k":
+		if m.auditIndex > 0 {
+			m.auditIndex--
+		}
+	case "down", "j":
+		if m.auditIndex < len(m.audit.findings)-1 {
+			m.auditIndex++
+		}
+	case "u":
+		// Fixes arrive through updates
+		m.showAudit = false
+		return m.update(keyMsgFor("u"))
+	}
+	return m, nil
+}
+
+// auditSeverityColor is the dashboard color for an arch-audit severity
+func auditSeverityColor(severity string) lipgloss.Color {
+	switch auditSeverityRank(severity) {
+	case 4, 3:
+		return currentTheme.DashboardWarning
+	case 2:
+		return currentTheme.WarningColor
+	}
+	return currentTheme.SubtleColor
+}
+
+// renderAuditOverlay lists the vulnerable packages with their severity and advisories
+func (m model) renderAuditOverlay(contentWidth, contentHeight int, activeColor lipgloss.Color) string {
+	dialogWidth := contentWidth - 20
+	if dialogWidth < 50 {
+		dialogWidth = 50
+	}
+	if dialogWidth > 80 {
+		dialogWidth = 80
+	}
+
+	titleStyle := lipgloss.NewStyle().
+		Bold(true).
+		Foreground(activeColor).
+		MarginBottom(1)
+	textStyle := lipgloss.NewStyle().
+		Foreground(currentTheme.TextColor).
+		Width(dialogWidth - 6)
+	subtleStyle := lipgloss.NewStyle().
+		Foreground(currentTheme.SubtleColor)
+	keyStyle := lipgloss.NewStyle().
+		Foreground(activeColor).
+		Bold(true)
+	dialogBorderStyle := lipgloss.NewStyle().
+		Border(lipgloss.RoundedBorder()).
+		BorderForeground(activeColor).
+		Padding(1, 2)
+
+	findings := m.audit.findings
+	var content strings.Builder
+	content.WriteString(titleStyle.Render(fmt.Sprintf("🚨 %d Vulnerable Package(s)", len(findings))))
+	content.WriteString("\n\n")
+	content.WriteString(textStyle.Render("arch-audit matched these installed packages against the Arch Linux " +
+		"security tracker. Updating fixes those with a patched release."))
+	content.WriteString("\n\n")
+
+	maxVisible := 10
+	start := 0
+	if m.auditIndex >= maxVisible {
+		start = m.auditIndex - maxVisible + 1
+	}
+	end := start + maxVisible
+	if end > len(findings) {
+		end = len(findings)
+	}
+	advisoryWidth := dialogWidth - 6 - 2 - 24 - 10
+	for i := start; i < end; i++ {
+		f := findings[i]
+		cursor := "  "
+		if i == m.auditIndex {
+			cursor = keyStyle.Render("> ")
+		}
+		advisories := strings.Join(f.advisories, ", ")
+		if advisories == "" {
+			advisories = "see security.archlinux.org"
+		}
+		content.WriteString(fmt.Sprintf("%s%-24s%s%s\n", cursor, truncateRunes(f.name, 23),
+			lipgloss.NewStyle().Bold(true).Foreground(auditSeverityColor(f.severity)).Render(fmt.Sprintf("%-10s", f.severity)),
+			subtleStyle.Render(truncateRunes(advisories, advisoryWidth))))
+	}
+	if end < len(findings) {
+		content.WriteString(subtleStyle.Render(fmt.Sprintf("  ↓ %d more below\n", len(findings)-end)))
+	}
+
+	content.WriteString("\n")
+	content.WriteString(strings.Join([]string{
+		keyStyle.Render("[u]") + " check for updates",
+		keyStyle.Render("[esc]") + " close",
+	}, "  "))
+
+	dialog := dialogBorderStyle.Width(dialogWidth).Render(content.String())
+	return centerDialog(dialog, contentWidth, contentHeight)
+}
+
+// renderUnmanagedOverlay lists foreign packages unmanaged by paru
+func (m model) renderUnmanagedOverlay(contentWidth, contentHeight int, activeColor lipgloss.Color) string {
+	dialogWidth := contentWidth - 20
+	if dialogWidth < 50 {
+		dialogWidth = 50
+	}
+	if dialogWidth > 80 {
+		dialogWidth = 80
+	}
+
+	titleStyle := lipgloss.NewStyle().
+		Bold(true).
+		Foreground(activeColor).
+		MarginBottom(1)
+	textStyle := lipgloss.NewStyle().
+		Foreground(currentTheme.TextColor).
+		Width(dialogWidth - 6)
+	subtleStyle := lipgloss.NewStyle().
+		Foreground(currentTheme.SubtleColor)
+	keyStyle := lipgloss.NewStyle().
+		Foreground(activeColor).
+		Bold(true)
+	dialogBorderStyle := lipgloss.NewStyle().
+		Border(lipgloss.RoundedBorder()).
+		BorderForeground(activeColor).
+		Padding(1, 2)
+
+	var content strings.Builder
+	content.WriteString(titleStyle.Render(fmt.Sprintf("📦 %d Foreign Package(s) Unmanaged by %s", len(m.unmanaged), helper.name)))
+	content.WriteString("\n\n")
+	content.WriteString(textStyle.Render(fmt.Sprintf("These have no clone in %[1]s's cache, so %[1]s never updates them. ", helper.name) +
+		"Adopting clones the AUR repository without building; marking as local stops reporting the package."))
+	content.WriteString("\n\n")
+
+	maxVisible := 10
+	start := 0
+	if m.unmanagedIndex >= maxVisible {
+		start = m.unmanagedIndex - maxVisible + 1
+	}
+	end := start + maxVisible
+	if end > len(m.unmanaged) {
+		end = len(m.unmanaged)
+	}
+	for i := start; i < end; i++ {
+		pkg := m.unmanaged[i]
+		cursor := "  "
+		if i == m.unmanagedIndex {
+			cursor = keyStyle.Render("> ")
+		}
+		origin := "not in the AUR"
+		switch {
+		case m.unmanagedErr != nil:
+			origin = "AUR status unknown"
+		case pkg.inAUR:
+			origin = "in the AUR"
+		}
+		content.WriteString(fmt.Sprintf("%s%s %s\n", cursor, pkg.name, subtleStyle.Render("("+origin+")")))
+	}
+	if end < len(m.unmanaged) {
+		content.WriteString(subtleStyle.Render(fmt.Sprintf("  ↓ %d more below\n", len(m.unmanaged)-end)))
+	}
+
+	content.WriteString("\n")
+	content.WriteString(strings.Join([]string{
+		keyStyle.Render("[a]") + " adopt",
+		keyStyle.Render("[l]") + " mark local",
+		keyStyle.Render("[esc]") + " close",
+	}, "  "))
+
+	dialog := dialogBorderStyle.Width(dialogWidth).Render(content.String())
+	return centerDialog(dialog, contentWidth, contentHeight)
+}
+
+// handleSettingsKeys handles navigation and adjustment in the settings overlay
+func (m model) handleSettingsKeys(msg tea.KeyMsg) (tea.Model, tea.Cmd) {
+	switch msg.String() {
+	case "esc", "q", ",":
+		m.showSettings = false
+		m.statusMessage = "Settings closed"
+	case "up", "k":
+		if m.settingsIndex > 0 {
+			m.settingsIndex--
+		}
+	case "down", "j":
+		if m.settingsIndex < len(settingRows)-1 {
+			m.settingsIndex++
+		}
+	case "left", "h", "-":
+		return m.applySettingChange(-1)
+	case "right", "l", "+", "enter", " ":
+		return m.applySettingChange(1)
+	}
+	return m, nil
+}
+
+// applySettingChange steps the selected setting, validates it, and persists
+// it to the config file. Invalid values are rejected and left unchanged.
+func (m model) applySettingChange(delta int) (tea.Model, tea.Cmd) {
+	row := settingRows[m.settingsIndex]
+	updated := m.settings.adjustSetting(row.key, delta)
+	warning, err := validateSettings(updated)
+	if err != nil {
+		m.statusMessage = fmt.Sprintf("Invalid setting: %v", err)
+		return m, nil
+	}
+	m.settings = updated
+	aurRPC.setConcurrency(updated.PrefetchConcurrency)
+
+	value := updated.settingValue(row.key)
+	if err := saveConfigValues(m.configPath, map[string]string{row.key: value}); err != nil {
+		m.statusMessage = fmt.Sprintf("%s = %s (not saved: %v)", row.key, value, err)
+	} else if warning != "" {
+		m.statusMessage = "Warning: " + warning
+	} else {
+		m.statusMessage = fmt.Sprintf("Saved %s = %s", row.key, value)
+	}
+	return m, nil
+}
+
+// cycleTheme switches to the next theme in --list-themes order. setTheme
+// rebuilds the package-level styles and color maps, so the next render is
+// fully re-skinned. The choice is saved if a config file exists. A 16-color
+// terminal only cycles through the themes it can show.
+func (m *model) cycleTheme() {
+	names := listThemes()
+	start := 0
+	for i, name := range names {
+		if name == currentTheme.Name {
+			start = i + 1
+			break
+		}
+	}
+	next := currentTheme.Name
+	for i := range names {
+		name := names[(start+i)%len(names)]
+		if t, _ := getThemeByName(name); !ansi16Colors || themes[t].fitsANSI16() {
+			next = name
+			break
+		}
+	}
+	t, _ := getThemeByName(next)
+	setTheme(t)
+
+	m.statusMessage = "Theme: " + next
+	if _, err := os.Stat(m.configPath); err != nil {
+		return
+	}
+	if err := saveConfigValues(m.configPath, map[string]string{"theme": fmt.Sprintf("%q", next)}); err != nil {
+		m.statusMessage = fmt.Sprintf("Theme: %s (not saved: %v)", next, err)
+	}
+}
+
+// centerDialog places a rendered dialog in the middle of the content area
+func centerDialog(dialog string, contentWidth, contentHeight int) string {
+	dialogHeight := strings.Count(dialog, "\n") + 1
+	vertPadding := (contentHeight - dialogHeight) / 2
+	if vertPadding < 0 {
+		vertPadding = 0
+	}
+	horizPadding := (contentWidth - lipgloss.Width(dialog)) / 2
+	if horizPadding < 0 {
+		horizPadding = 0
+	}
+
+	var output strings.Builder
+	for i := 0; i < vertPadding; i++ {
+		output.WriteString("\n")
+	}
+	for _, line := range strings.Split(dialog, "\n") {
+		output.WriteString(strings.Repeat(" ", horizPadding))
+		output.WriteString(line)
+		output.WriteString("\n")
+	}
+	return output.String()
+}
+
+// renderSettingsOverlay renders the runtime settings dialog
+func (m model) renderSettingsOverlay(contentWidth, contentHeight int, activeColor lipgloss.Color) string {
+	dialogWidth := contentWidth - 20
+	if dialogWidth < 50 {
+		dialogWidth = 50
+	}
+	if dialogWidth > 80 {
+		dialogWidth = 80
+	}
+
+	titleStyle := lipgloss.NewStyle().
+		Bold(true).
+		Foreground(activeColor).
+		MarginBottom(1)
+	labelStyle := lipgloss.NewStyle().
+		Foreground(currentTheme.TextColor)
+	valueStyle := lipgloss.NewStyle().
+		Foreground(currentTheme.HighlightColor).
+		Bold(true)
+	descStyle := lipgloss.NewStyle().
+		Foreground(currentTheme.SubtleColor)
+	dialogBorderStyle := lipgloss.NewStyle().
+		Border(lipgloss.RoundedBorder()).
+		BorderForeground(activeColor).
+		Padding(1, 2)
+
+	var content strings.Builder
+	content.WriteString(titleStyle.Render("⚙  Settings"))
+	content.WriteString("\n\n")
+
+	for i, row := range settingRows {
+		cursor := "  "
+		label := labelStyle.Render(fmt.Sprintf("%-24s", row.label))
+		if i == m.settingsIndex {
+			cursor = selectedStyle.Render("> ")
+			label = selectedStyle.Render(fmt.Sprintf("%-24s", row.label))
+		}
+		content.WriteString(fmt.Sprintf("%s%s %s\n", cursor, label, valueStyle.Render(m.settings.settingValue(row.key))))
+		content.WriteString(descStyle.Render("    "+row.description) + "\n")
+	}
+
+	content.WriteString("\n")
+	content.WriteString(descStyle.Render("Saved to " + m.configPath))
+	content.WriteString("\n")
+	content.WriteString(descStyle.Render("[↑↓] select  [←→] adjust  [esc] close"))
+
+	dialog := dialogBorderStyle.Width(dialogWidth).Render(content.String())
+	return centerDialog(dialog, contentWidth, contentHeight)
+}
+
+// renderHelpText creates the help menu with the active mode highlighted. It
+// never exceeds width: the least important hints are dropped first (search and
+// mark, then quit), then compact labels are used, and as a last resort only
+// the active mode is shown.
+func (m model) renderHelpText(activeColor lipgloss.Color, width int) string {
+	dimStyle := helpStyle
+	activeStyle := lipgloss.NewStyle().
+		Foreground(activeColor).
+		Bold(true)
+
+	type modeHint struct {
+		mode    viewMode
+		label   string
+		compact string
+	}
+	modes := []modeHint{
+		{modeInstall, "[i]nstall", "[i]"},
+		{modeInstalled, "i[n]fo", "[n]"},
+		{modeUninstall, "[r]emove", "[r]"},
+		{modeUpdate, "[u]pdate", "[u]"},
+		{modeHistory, "[h]istory", "[h]"},
+	}
+
+	// render joins the mode hints with optional leading and trailing hints
+	render := func(compact bool, lead, tail string) string {
+		sep := "  "
+		if compact {
+			sep = " "
+		}
+		var parts []string
+		if lead != "" {
+			parts = append(parts, dimStyle.Render(lead))
+		}
+		for _, h := range modes {
+			label := h.label
+			if compact {
+				label = h.compact
+			}
+			if h.mode == m.mode {
+				parts = append(parts, activeStyle.Render(label))
+			} else {
+				parts = append(parts, dimStyle.Render(label))
+			}
+		}
+		if tail != "" {
+			parts = append(parts, dimStyle.Render(tail))
+		}
+		return strings.Join(parts, dimStyle.Render(sep))
+	}
+
+	variants := []string{
+		render(false, "[/] search  [tab] mark", "[q]uit"),
+		render(false, "", "[q]uit"),
+		render(false, "", ""),
+		render(true, "", "[q]"),
+		render(true, "", ""),
+	}
+	for _, v := range variants {
+		if lipgloss.Width(v) <= width {
+			return v
+		}
+	}
+
+	// Only the active mode is left
+	for _, h := range modes {
+		if h.mode == m.mode {
+			if lipgloss.Width(h.label) <= width {
+				return activeStyle.Render(h.label)
+			}
+			return activeStyle.Render(h.compact)
+		}
+	}
+	return ""
+}
+
+func (m model) View() string {
+	if m.width == 0 {
+		return "Loading..."
+	}
+	if m.width < minTermWidth || m.height < minTermHeight {
+		return m.renderTooSmall()
+	}
+
+	// Calculate dimensions
+	contentWidth := m.width - 4
+	contentHeight := m.height - 4
+
+	// Get mode-specific color
+	activeColor := modeColors[m.mode]
+	if activeColor == "" {
+		activeColor = defaultBorderColor
+	}
+
+	// Create mode-specific styles
+	titleStyle := baseTitleStyle.Background(activeColor)
+	borderStyle := baseBorderStyle.BorderForeground(activeColor)
+
+	// Build header with mode
+	modeText := ""
+	switch m.mode {
+	case modeInstall:
+		modeText = "INSTALL"
+	case modeInstalled:
+		modeText = "INFO"
+	case modeUninstall:
+		modeText = "UNINSTALL"
+	case modeUpdate:
+		modeText = "UPDATE"
+	case modeHistory:
+		modeText = "HISTORY"
+	}
+
+	header := titleStyle.Render(" GAUR - " + modeText + " ")
+	if m.showRecoveryBanner {
+		header += " " + lipgloss.NewStyle().Foreground(currentTheme.WarningColor).Bold(true).
+			Render("⚠ Interrupted pacman transaction detected - press [!] for recovery")
+	}
+	for _, notice := range m.toolNotices {
+		header += " " + lipgloss.NewStyle().Foreground(currentTheme.WarningColor).Render("⚠ "+notice)
+	}
+	if age, stale := m.syncDBAge(); stale {
+		header += " " + lipgloss.NewStyle().Foreground(currentTheme.WarningColor).
+			Render(fmt.Sprintf("⚠ Sync databases are %s old - results may be outdated", formatAge(age)))
+	}
+	if lipgloss.Width(header) > m.width {
+		header = truncateWithAnsi(header, m.width)
+	}
+
+	// Help text for bottom right with active item highlighted
+	helpText := m.renderHelpText(activeColor, contentWidth)
+
+	// Render confirmation dialog if active
+	if m.showConfirmation {
+		return m.renderConfirmationDialog(contentWidth, contentHeight, activeColor)
+	}
+
+	// Render error overlay if active
+	if m.showErrorOverlay {
+		return m.renderErrorOverlay(contentWidth, contentHeight)
+	}
+
+	// Render the live log of a streamed transaction if one is running
+	if m.streaming {
+		return m.renderStreamPane(contentWidth, contentHeight, activeColor)
+	}
+
+	// Render recovery view if active
+	if m.showRecovery {
+		return m.renderRecoveryOverlay(contentWidth, contentHeight)
+	}
+
+	// Render cleanup wizard if active
+	if m.showCleanup {
+		return m.renderCleanupOverlay(contentWidth, contentHeight, activeColor)
+	}
+
+	// Render cache directories overlay if active
+	if m.showCacheDirs {
+		return m.renderCacheDirOverlay(contentWidth, contentHeight, activeColor)
+	}
+
+	// Render cache breakdown overlay if active
+	if m.showBreakdown {
+		return m.renderBreakdownOverlay(contentWidth, contentHeight, activeColor)
+	}
+
+	// Render unmanaged foreign packages overlay if active
+	if m.showUnmanaged {
+		return m.renderUnmanagedOverlay(contentWidth, contentHeight, activeColor)
+	}
+
+	// Render held packages overlay if active
+	if m.showHolds {
+		return m.renderHoldsOverlay(contentWidth, contentHeight, activeColor)
+	}
+
+	// Render failed systemd units overlay if active
+	if m.showFailedUnits {
+		return m.renderFailedUnitsOverlay(contentWidth, contentHeight, activeColor)
+	}
+
+	// Render security advisories overlay if active
+	if m.showAudit {
+		return m.renderAuditOverlay(contentWidth, contentHeight, activeColor)
+	}
+
+	// Render .pacnew files overlay if active
+	if m.showPacnew {
+		return m.renderPacnewOverlay(contentWidth, contentHeight, activeColor)
+	}
+
+	// Render build log browser if active
+	if m.showBuildLog {
+		return m.renderBuildLogOverlay(contentWidth, contentHeight, activeColor)
+	}
+
+	// Render session stats overlay if active
+	if m.showSessionStats {
+		return m.renderSessionStatsOverlay(contentWidth, contentHeight, activeColor)
+	}
+
+	// Render operation log overlay if active
+	if m.showOpLog {
+		return m.renderOpLogOverlay(contentWidth, contentHeight, activeColor)
+	}
+
+	// Render settings overlay if active
+	if m.showSettings {
+		return m.renderSettingsOverlay(contentWidth, contentHeight, activeColor)
+	}
+
+	// Dashboard view
+	if m.mode == modeInstalled {
+		return m.renderDashboard(helpText, contentWidth, contentHeight)
+	}
+
+	// Top half: Package info
+	infoHeight, resultsHeight := m.mainLayout()
+	infoLines := m.infoLines(contentWidth, infoHeight)
+	if len(infoLines) > infoHeight-2 {
+		infoLines = infoLines[:infoHeight-2]
+	}
+	infoContent := strings.Join(infoLines, "\n")
+
+	infoBox := lipgloss.NewStyle().
+		Width(contentWidth-2).
+		Height(infoHeight-2).
+		Padding(0, 1).
+		Render(infoContent)
+
+	infoBorder := borderStyle
+	if m.infoFocused {
+		infoBorder = borderStyle.BorderForeground(currentTheme.SelectedColor)
+	}
+	infoPanel := infoBorder.
+		Width(contentWidth).
+		Height(infoHeight).
+		Render(infoBox)
+
+	// Bottom half: Results + Input
+	bottomHeight := contentHeight - infoHeight - 1
+
+	// Build results list
+	var results strings.Builder
+	var pkgList []Package
+	if m.mode == modeInstall {
+		pkgList = m.filtered
+	} else if m.mode == modeUninstall {
+		pkgList = m.filteredInstalled
+	}
+
+	if m.loading {
+		results.WriteString("  " + m.busyText("Loading..."))
+	} else if m.mode == modeUpdate {
+		results.WriteString("  " + m.statusMessage)
+	} else if m.mode == modeHistory {
+		results.WriteString(m.renderHistoryRows(contentWidth-4, resultsHeight))
+	} else if len(pkgList) == 0 {
+		results.WriteString("  No packages to display")
+	} else {
+		// Show packages that fit, reversed so most relevant is at bottom (near input)
+		startIdx, endIdx := resultsWindow(m.selectedIndex, len(pkgList), resultsHeight, m.resultsStart)
+
+		// Matched characters are found for the visible rows only
+		matchQuery := m.matchQuery
+		if m.mode == modeUninstall {
+			matchQuery = m.installedMatchQuery
+		}
+		sizesKnown := m.mode == modeUninstall && m.installedSizesKnown()
+
+		// Build lines in reverse order (most relevant at bottom, near input field)
+		var lines []string
+		for i := startIdx; i < endIdx; i++ {
+			pkg := pkgList[i]
+			// Show marker for marked packages
+			marker := " "
+			if m.markedPackages[pkg.Name] {
+				marker = "*"
+			}
+			prefix := " " + marker
+			if i == m.selectedIndex {
+				prefix = ">" + marker
+			}
+
+			// Color by source
+			sourceStyle := lipgloss.NewStyle()
+			if color, ok := sourceColors[pkg.Source]; ok {
+				sourceStyle = sourceStyle.Foreground(color)
+			}
+
+			// Apply highlighting with source colors
+			var displayPkgStr string
+			if indices, ok := matchedDisplayIndices(pkg, matchQuery); ok {
+				// Use combined highlighting that preserves source colors
+				displayPkgStr = highlightMatchesWithSourceColor(pkg, indices)
+			} else {
+				displayPkgStr = sourceStyle.Render(pkg.Source) + "/" + pkg.Name
+			}
+
+			// Version and badges, in the order they are dropped last to first.
+			// The compact layout has no room for the version column.
+			var extras []string
+			if !m.compact() {
+				extras = append(extras, lipgloss.NewStyle().Foreground(currentTheme.SubtleColor).Render(pkg.Version))
+			}
+			if pkg.Source == "aur" && m.mode == modeInstall && (pkg.Votes > 0 || pkg.Popularity > 0) {
+				extras = append(extras, lipgloss.NewStyle().Foreground(currentTheme.SubtleColor).Render(fmt.Sprintf("+%d ~%.2f", pkg.Votes, pkg.Popularity)))
+			}
+			if pkg.OutOfDate != 0 && m.mode == modeInstall {
+				extras = append(extras, lipgloss.NewStyle().Foreground(currentTheme.ErrorColor).Render("[out-of-date]"))
+			}
+			if pkg.Orphaned && pkg.Source == "aur" && m.mode == modeInstall {
+				extras = append(extras, lipgloss.NewStyle().Foreground(currentTheme.WarningColor).Render("[orphan]"))
+			}
+			if m.mode == modeInstall && m.resultInstalled(pkg) {
+				extras = append(extras, installedBadge.Render("[installed]"))
+			}
+			if m.holds[pkg.Name] && m.mode != modeUpdate {
+				extras = append(extras, lipgloss.NewStyle().Foreground(currentTheme.WarningColor).Render("[🔒 held]"))
+			}
+			if m.mode == modeUninstall {
+				if pkg.Explicit {
+					extras = append(extras, lipgloss.NewStyle().Foreground(currentTheme.TextColor).Render("[explicit]"))
+				} else {
+					extras = append(extras, lipgloss.NewStyle().Foreground(currentTheme.SubtleColor).Render("[dep]"))
 				}
-			} else if m.mode == modeUninstall {
-				query := m.textInput.Value()
-				if len(m.installed) > 0 {
-					if query == "" {
-						m.filteredInstalled = m.installed
-						m.installedMatchIndices = nil
-						m.statusMessage = fmt.Sprintf("%d installed packages", len(m.installed))
-					} else {
-						// Parse source filter from query
-						sourceFilters, searchQuery := parseUninstallFilter(query)
-						hasSourceFilter := len(sourceFilters) > 0
-						
-						// Start with all installed packages
-						basePackages := m.installed
-						
-						// Apply source filters if specified
-						if hasSourceFilter {
-							var filtered []Package
-							for _, pkg := range basePackages {
-								// 't' (total) - all packages
-								if sourceFilters["total"] {
-									filtered = append(filtered, pkg)
-								} else {
-									// 'e' (explicit) - explicitly installed packages
-									if sourceFilters["explicit"] && pkg.Explicit {
-										filtered = append(filtered, pkg)
-									}
-									// 'f' (foreign) - foreign/AUR packages
-									if sourceFilters["foreign"] && pkg.Source == "aur" {
-										filtered = append(filtered, pkg)
-									}
-									// 'o' (orphan) - orphan packages
-									if sourceFilters["orphan"] && pkg.Orphan {
-										filtered = append(filtered, pkg)
-									}
-								}
-							}
-							basePackages = filtered
-						}
-						
-						// Apply fuzzy filtering if there's a search query
-						if searchQuery != "" {
-							m.filteredInstalled = fuzzyFilter(basePackages, searchQuery)
-							m.installedMatchIndices = computeAllMatchIndices(m.filteredInstalled, searchQuery)
-						} else {
-							m.filteredInstalled = basePackages
-							m.installedMatchIndices = nil
-						}
-						
-						// Update status message
-						if hasSourceFilter {
-							m.statusMessage = fmt.Sprintf("Found %d %s packages", len(m.filteredInstalled), formatUninstallFilters(sourceFilters))
-						} else {
-							m.statusMessage = fmt.Sprintf("Showing %d of %d packages", len(m.filteredInstalled), len(m.installed))
-						}
-					}
-					if m.selectedIndex >= len(m.filteredInstalled) {
-						m.selectedIndex = 0
-					}
-					if len(m.filteredInstalled) > 0 && m.filteredInstalled[m.selectedIndex].Name != m.infoForPackage {
-						m.loadingInfo = true
-						m.infoForPackage = m.filteredInstalled[m.selectedIndex].Name
-						cmds = append(cmds, getPackageInfo(m.filteredInstalled[m.selectedIndex]))
+			}
+
+			// Remove mode ends each row with the install age and the
+			// installed size; recent installs stand out
+			rowWidth := contentWidth - 4
+			var columns []string
+			if m.mode == modeUninstall && !m.compact() {
+				age, ageStyle := "", lipgloss.NewStyle().Foreground(currentTheme.SubtleColor)
+				if !pkg.InstallDate.IsZero() {
+					since := time.Since(pkg.InstallDate)
+					age = formatAge(since) + " ago"
+					if since < recentInstallAge {
+						ageStyle = lipgloss.NewStyle().Foreground(currentTheme.HighlightColor)
 					}
 				}
+				columns = append(columns, ageStyle.Render(fmt.Sprintf("%9s", age)))
+				if sizesKnown {
+					columns = append(columns, lipgloss.NewStyle().Foreground(currentTheme.SubtleColor).Render(
+						fmt.Sprintf("%10s", formatBytes(pkg.SizeBytes))))
+				}
+				rowWidth -= lipgloss.Width(strings.Join(columns, " ")) + 1
 			}
-			return m, tea.Batch(cmds...)
+
+			line := fitResultLine(prefix+displayPkgStr, extras, rowWidth)
+			if m.settings.ShowDescriptions && !m.compact() {
+				line += resultDescription(pkg.Description, rowWidth-lipgloss.Width(line))
+			}
+			if len(columns) > 0 {
+				line += strings.Repeat(" ", max(0, rowWidth-lipgloss.Width(line))+1) + strings.Join(columns, " ")
+			}
+
+			if i == m.selectedIndex {
+				line = selectedStyle.Render(line)
+			}
+
+			lines = append(lines, line)
+		}
+
+		// Reverse the lines so most relevant (index 0) is at bottom
+		for i := len(lines) - 1; i >= 0; i-- {
+			results.WriteString(lines[i])
+			if i > 0 {
+				results.WriteString("\n")
+			}
+		}
+	}
+
+	resultsBox := lipgloss.NewStyle().
+		Width(contentWidth - 2).
+		Height(resultsHeight).
+		Render(results.String())
+
+	// Input field
+	inputLine := ""
+	if m.mode == modeInstall || m.mode == modeUninstall || m.mode == modeHistory {
+		// The input's own placeholder color is a 256-color gray; use the theme's
+		m.textInput.PlaceholderStyle = statusStyle
+		m.textInput.CompletionStyle = statusStyle
+		inputLine = m.textInput.View()
+	} else {
+		inputLine = statusStyle.Render("System update in progress...")
+	}
+
+	// Status line, kept to one row so the panel height never changes
+	statusLine := statusStyle.Render(m.statusMessage)
+	if m.loading || m.searchingAUR {
+		statusLine = statusStyle.Render(m.busyText(m.statusMessage))
+	}
+	if m.mode == modeInstall && m.hiddenInstalled > 0 && m.resultsArranged() {
+		statusLine += statusStyle.Render(fmt.Sprintf(" (%d installed hidden)", m.hiddenInstalled))
+	}
+	if order := m.resultSorts[m.mode]; order != sortRelevance {
+		statusLine += statusStyle.Render(" · sorted by " + resultSortNames[order])
+	}
+	if lipgloss.Width(statusLine) > contentWidth-2 {
+		statusLine = truncateWithAnsi(statusLine, contentWidth-2)
+	}
+
+	// Layout: results at top, input at bottom (fzf-style)
+	bottomContent := lipgloss.JoinVertical(
+		lipgloss.Left,
+		resultsBox,
+		"",
+		statusLine,
+		inputLine,
+	)
+
+	bottomPanel := borderStyle.
+		Width(contentWidth).
+		Height(bottomHeight).
+		Render(bottomContent)
+
+	// Footer with help text aligned to the right
+	helpWidth := lipgloss.Width(helpText)
+	padding := contentWidth - helpWidth
+	if padding < 0 {
+		padding = 0
+	}
+	footer := strings.Repeat(" ", padding) + helpText
+
+	// Combine all
+	content := lipgloss.JoinVertical(
+		lipgloss.Left,
+		header,
+		infoPanel,
+		bottomPanel,
+		footer,
+	)
+
+	// Overlay selections panel if there are marked packages
+	if len(m.markedPackages) > 0 {
+		content = m.overlaySelectionsPanel(content, contentWidth)
+	}
+
+	return content
+}
+
+// infoLines returns the lines of the info panel, scrolled by infoScroll
+func (m model) infoLines(contentWidth, infoHeight int) []string {
+	infoContent := ""
+	if m.mode == modeUpdate {
+		if m.updateOutput != "" {
+			infoContent = m.updateOutput
+		} else if m.loading {
+			infoContent = m.busyText("Checking for updates...")
+		} else if len(m.pendingUpdates) > 0 {
+			infoContent = fmt.Sprintf("%d update(s) available. Press [enter] to review and update.", len(m.pendingUpdates))
+		} else {
+			infoContent = "System is up to date. Press [u] to check again."
+		}
+		if m.updateOutput == "" {
+			if m.develUpdates {
+				infoContent += "\nDevel packages: checked for new commits. Press [D] to stop."
+			} else {
+				infoContent += "\nDevel packages: not checked. Press [D] to include -git packages."
+			}
+		}
+	} else if m.mode == modeHistory {
+		infoContent = m.renderHistoryTransaction(contentWidth-4, infoHeight-2)
+	} else if m.showFileList {
+		infoContent = m.renderFileList(contentWidth-4, infoHeight-2)
+	} else if m.showDepTree {
+		infoContent = m.renderDepTree(contentWidth-4, infoHeight-2)
+	} else if m.loadingInfo {
+		infoContent = m.busyText(fmt.Sprintf("Loading details for %s...", m.infoForPackage))
+	} else if m.localizedInfo != "" && m.localizedInfoFor == m.infoForPackage {
+		// The user's language wins over the styled view of the C output
+		infoContent = m.localizedInfo
+	} else if m.packageInfo != "" {
+		// Show parsed info as a styled view, the raw text if it doesn't parse
+		if m.packageDetailsOK {
+			infoContent = m.renderPackageDetails(m.packageDetails, contentWidth-4, infoHeight-2)
+		} else {
+			infoContent = m.packageInfo
+		}
+	} else {
+		infoContent = "Select a package to see details"
+	}
+
+	lines := strings.Split(infoContent, "\n")
+	if m.infoScroll > 0 && !m.infoFocused {
+		scroll := m.infoScroll
+		if maxScroll := len(lines) - (infoHeight - 2); scroll > maxScroll {
+			scroll = max(maxScroll, 0)
+		}
+		lines = lines[scroll:]
+	}
+	return lines
+}
+
+// handleMouse drives the main view with the mouse. The wheel moves the
+// selection, or scrolls the info panel when over it; a click selects a result
+// row and a click on the selected row toggles its mark. Overlays and dialogs
+// get the wheel as up/down, and a click on a [key] hint presses that key.
+func (m model) handleMouse(msg tea.MouseMsg) (tea.Model, tea.Cmd) {
+	if msg.Action != tea.MouseActionPress {
+		return m, nil
+	}
+	overlay := m.streaming || m.showConfirmation || m.showErrorOverlay || m.showRecovery || m.showCleanup ||
+		m.showCacheDirs || m.showBreakdown || m.showUnmanaged || m.showHolds || m.showPacnew || m.showAudit || m.showFailedUnits || m.showBuildLog || m.showSessionStats || m.showOpLog || m.showSettings
+	infoHeight, resultsHeight := m.mainLayout()
+	overInfo := !overlay && m.mode != modeInstalled && msg.Y >= 1 && msg.Y <= infoHeight+2
+
+	switch msg.Button {
+	case tea.MouseButtonWheelUp, tea.MouseButtonWheelDown:
+		if overInfo && !m.showFileList && !m.showDepTree && !m.infoFocused {
+			contentWidth := m.width - 4
+			before := strings.Join(m.infoLines(contentWidth, infoHeight), "\n")
+			if msg.Button == tea.MouseButtonWheelUp {
+				m.infoScroll = max(m.infoScroll-1, 0)
+			} else if m.infoScroll++; strings.Join(m.infoLines(contentWidth, infoHeight), "\n") == before {
+				m.infoScroll-- // Already at the end
+			}
+			return m, nil
+		}
+		if msg.Button == tea.MouseButtonWheelUp {
+			return m.update(keyMsgFor("up"))
+		}
+		return m.update(keyMsgFor("down"))
+
+	case tea.MouseButtonLeft:
+		if m.showConfirmation {
+			switch clickedKeyHint(m.View(), msg.X, msg.Y) {
+			case "y":
+				return m.update(keyMsgFor("y"))
+			case "n":
+				return m.update(keyMsgFor("n"))
+			case "enter":
+				return m.update(keyMsgFor("enter"))
+			}
+			return m, nil
+		}
+		pkgList := m.currentPackageList()
+		if overlay || m.loading || len(pkgList) == 0 {
+			return m, nil
+		}
+		// Results start below the header, the info panel and the results border
+		row := msg.Y - (infoHeight + 4)
+		if row < 0 || row >= resultsHeight {
+			return m, nil
+		}
+		// Rows are drawn most relevant last, so the top row is the window's end
+		startIdx, endIdx := resultsWindow(m.selectedIndex, len(pkgList), resultsHeight, m.resultsStart)
+		idx := endIdx - 1 - row
+		if idx < startIdx {
+			return m, nil
+		}
+		if idx == m.selectedIndex {
+			return m.update(keyMsgFor("tab"))
+		}
+		m.selectedIndex = idx
+		m.loadingInfo = true
+		m.pendingInfoPackage = pkgList[idx].Name
+		return m, m.debouncePackageInfo(m.pendingInfoPackage)
+	}
+	return m, nil
+}
+
+// clickedKeyHint returns the key of the "[key]" hint drawn at column x of
+// line y of a rendered view, or "" when there is none
+func clickedKeyHint(view string, x, y int) string {
+	lines := strings.Split(view, "\n")
+	if y < 0 || y >= len(lines) {
+		return ""
+	}
+	line := ansiEscape.ReplaceAllString(lines[y], "")
+	offset := 0
+	for {
+		open := strings.Index(line[offset:], "[")
+		if open == -1 {
+			return ""
+		}
+		open += offset
+		end := strings.Index(line[open:], "]")
+		if end == -1 {
+			return ""
+		}
+		end += open
+		startCol := lipgloss.Width(line[:open])
+		if x >= startCol && x <= startCol+lipgloss.Width(line[open:end]) {
+			return line[open+1 : end]
+		}
+		offset = end + 1
+	}
+}
+
+// Terminal size limits. Below the minimum only a resize notice is shown;
+// below the comfortable size the compact layout is used.
+const (
+	minTermWidth      = 60
+	minTermHeight     = 15
+	compactTermWidth  = 90
+	compactTermHeight = 26
+)
+
+// compactInfoHeight is the collapsed info panel height, borders included
+const compactInfoHeight = 4
+
+// compact reports whether the terminal is too small for the full layout
+func (m model) compact() bool {
+	return m.width < compactTermWidth || m.height < compactTermHeight
+}
+
+// renderTooSmall renders the notice shown while the terminal is below the
+// minimum size
+func (m model) renderTooSmall() string {
+	text := lipgloss.NewStyle().Foreground(currentTheme.WarningColor).Bold(true).Render("Terminal too small") + "\n" +
+		fmt.Sprintf("Please resize to at least %dx%d", minTermWidth, minTermHeight) + "\n" +
+		lipgloss.NewStyle().Foreground(currentTheme.SubtleColor).Render(fmt.Sprintf("(currently %dx%d)", m.width, m.height))
+	text = lipgloss.NewStyle().Width(m.width).Align(lipgloss.Center).Render(text)
+	return lipgloss.Place(m.width, m.height, lipgloss.Center, lipgloss.Center, text)
+}
+
+// mainLayout returns the info panel height and the number of result rows of
+// the main view. The compact layout collapses the info panel to a summary.
+func (m model) mainLayout() (infoHeight, resultsHeight int) {
+	contentHeight := m.height - 4
+	infoHeight = contentHeight / 2
+	if m.compact() {
+		infoHeight = compactInfoHeight
+	}
+	bottomHeight := contentHeight - infoHeight - 1
+	return infoHeight, bottomHeight - 3
+}
+
+// resultsWindow returns the range of list indexes shown in the results box.
+// The window starting at start is kept while the selection is inside it and
+// scrolled just enough to bring the selection back otherwise.
+func resultsWindow(selectedIndex, listLen, resultsHeight, start int) (startIdx, endIdx int) {
+	startIdx = start
+	if selectedIndex < startIdx {
+		startIdx = selectedIndex
+	}
+	if selectedIndex >= startIdx+resultsHeight {
+		startIdx = selectedIndex - resultsHeight + 1
+	}
+	if startIdx > listLen-resultsHeight {
+		startIdx = listLen - resultsHeight
+	}
+	if startIdx < 0 {
+		startIdx = 0
+	}
+	endIdx = startIdx + resultsHeight
+	if endIdx > listLen {
+		endIdx = listLen
+	}
+	return startIdx, endIdx
+}
+
+// markedPackageSource finds where a marked package comes from: its repo,
+// "group", or "aur"; empty when it isn't known
+func (m model) markedPackageSource(name string) string {
+	if m.mode == modeUninstall {
+		for _, pkg := range m.installed {
+			if pkg.Name == name {
+				return pkg.Source
+			}
+		}
+	}
+	if idx := m.repoIndex[name]; len(idx) > 0 {
+		return m.repoPackages[idx[0]].Source
+	}
+	if _, ok := m.repoGroups[name]; ok {
+		return "group"
+	}
+	for _, pkg := range m.aurPackages {
+		if pkg.Name == name {
+			return "aur"
 		}
+	}
+	if m.aurInfoCache[name] != nil {
+		return "aur"
+	}
+	return ""
+}
 
-		// Input not focused - handle normal keybindings
-		switch msg.String() {
-		case "q":
-			return m, tea.Quit
+// overlaySelectionsPanel renders a selection panel on the bottom right of the screen
+func (m model) overlaySelectionsPanel(content string, contentWidth int) string {
+	// Panel styling - brighter border when focused
+	borderColor := currentTheme.SelectedColor
+	if m.selectionPanelFocused {
+		borderColor = currentTheme.HighlightColor
+	}
+	panelStyle := lipgloss.NewStyle().
+		Border(lipgloss.RoundedBorder()).
+		BorderForeground(borderColor).
+		Padding(0, 1)
 
-		case "esc":
-			if m.textInput.Focused() {
-				m.textInput.Blur()
-				return m, nil
+	titleStyle := lipgloss.NewStyle().
+		Bold(true).
+		Foreground(currentTheme.SelectedColor)
+
+	itemStyle := lipgloss.NewStyle().
+		Foreground(currentTheme.TextColor)
+
+	selectedItemStyle := lipgloss.NewStyle().
+		Foreground(currentTheme.HighlightColor).
+		Bold(true)
+
+	keyHintStyle := lipgloss.NewStyle().
+		Foreground(currentTheme.SelectedColor).
+		Bold(true)
+
+	// Build the selections list with * hint in title
+	var selectionsList strings.Builder
+	// Render the title (styled)
+	titleText := titleStyle.Render(fmt.Sprintf("Selected (%d) ", len(m.markedPackages))) + keyHintStyle.Render("[*]")
+	selectionsList.WriteString(titleText)
+
+	// Collect and sort package names for consistent display
+	var pkgNames []string
+	for name := range m.markedPackages {
+		pkgNames = append(pkgNames, name)
+	}
+	sort.Strings(pkgNames)
+
+	// Determine panel width dynamically within bounds
+	maxDisplay := 20
+	minPanelWidth := 12
+	maxPanelWidth := 40
+	if fit := m.height - 8; fit >= 3 && fit < maxDisplay {
+		// Keep the focused entry on screen in short terminals
+		maxDisplay = fit
+	}
+
+	// Scroll so the focused entry stays in the window
+	start := 0
+	if m.selectionPanelFocused && m.selectionPanelIndex >= maxDisplay {
+		start = m.selectionPanelIndex - maxDisplay + 1
+	}
+	end := start + maxDisplay
+	if end > len(pkgNames) {
+		end = len(pkgNames)
+	}
+
+	// Entries are source/name with an installed badge in install mode
+	badge := func(name string) string {
+		if m.mode == modeInstall && m.installedSet[name] {
+			return " ✓"
+		}
+		return ""
+	}
+	entries := make(map[string]string, end-start)
+	for _, name := range pkgNames[start:end] {
+		entries[name] = name
+		if source := m.markedPackageSource(name); source != "" {
+			entries[name] = source + "/" + name
+		}
+	}
+	entry := func(name string) string { return entries[name] }
+
+	// Compute widest line among title, visible entries and the scroll hints
+	maxContentWidth := lipgloss.Width(titleText)
+	for _, name := range pkgNames[start:end] {
+		// account for prefix ("  " or "> ")
+		nameWidth := lipgloss.Width(entry(name)+badge(name)) + 2
+		if nameWidth > maxContentWidth {
+			maxContentWidth = nameWidth
+		}
+	}
+	if rest := len(pkgNames) - end; rest > 0 {
+		moreStr := itemStyle.Render(fmt.Sprintf("↓ %d more", rest))
+		if w := lipgloss.Width(moreStr); w > maxContentWidth {
+			maxContentWidth = w
+		}
+	}
+
+	desiredPanelWidth := maxContentWidth + 4 // add room for borders and padding
+	if desiredPanelWidth < minPanelWidth {
+		desiredPanelWidth = minPanelWidth
+	}
+	if desiredPanelWidth > maxPanelWidth {
+		desiredPanelWidth = maxPanelWidth
+	}
+	panelWidth := desiredPanelWidth
+
+	// Build the lines, truncating names that exceed available space
+	if start > 0 {
+		selectionsList.WriteString("\n")
+		selectionsList.WriteString(itemStyle.Render(fmt.Sprintf("↑ %d more", start)))
+	}
+	for i := start; i < end; i++ {
+		name := pkgNames[i]
+
+		// calculate maximum width available for the name itself
+		innerWidth := panelWidth - 4 // subtract borders and padding
+		nameMaxWidth := innerWidth - 2 - lipgloss.Width(badge(name)) // subtract prefix and badge width
+		if nameMaxWidth < 1 {
+			nameMaxWidth = 1
+		}
+
+		displayName := entry(name)
+		if lipgloss.Width(displayName) > nameMaxWidth {
+			// Truncate to fit with ellipsis - preserve runes
+			runes := []rune(displayName)
+			truncWidth := nameMaxWidth - 3
+			if truncWidth < 1 {
+				truncWidth = 1
 			}
-			// Clear selections and reset state but stay in current mode
-			if len(m.markedPackages) > 0 {
-				m.markedPackages = make(map[string]bool)
-				m.statusMessage = "Selections cleared"
-				return m, nil
+			var truncated string
+			for j := 1; j <= len(runes); j++ {
+				s := string(runes[:j])
+				if lipgloss.Width(s) > truncWidth {
+					truncated = string(runes[:j-1]) + "..."
+					break
+				}
+				if j == len(runes) {
+					truncated = s
+				}
 			}
+			displayName = truncated
+		}
 
-		case "c":
-			// Clean cache - only in dashboard mode
-			if m.mode == modeInstalled && !m.loading {
-				m.showConfirmation = true
-				m.confirmType = confirmCleanCache
-				m.confirmScrollOffset = 0
-				m.statusMessage = "Confirm cache cleaning"
-				return m, nil
+		selectionsList.WriteString("\n")
+		// Highlight selected item when panel is focused
+		if m.selectionPanelFocused && i == m.selectionPanelIndex {
+			selectionsList.WriteString(selectedItemStyle.Render("> " + displayName))
+		} else {
+			// Color the source like the main list
+			source, rest, found := strings.Cut(displayName, "/")
+			if color, ok := sourceColors[source]; ok && found {
+				selectionsList.WriteString(itemStyle.Render("  ") + lipgloss.NewStyle().Foreground(color).Render(source) + itemStyle.Render("/"+rest))
+			} else {
+				selectionsList.WriteString(itemStyle.Render("  " + displayName))
 			}
+		}
+		selectionsList.WriteString(installedBadge.Render(badge(name)))
+	}
+	if rest := len(pkgNames) - end; rest > 0 {
+		selectionsList.WriteString("\n")
+		selectionsList.WriteString(itemStyle.Render(fmt.Sprintf("↓ %d more", rest)))
+	}
 
-		case "R":
-			// Remove orphans - only in dashboard mode and when there are orphans
-			if m.mode == modeInstalled && !m.loading && m.dashboard.Orphans > 0 {
-				// Get orphan list for confirmation
-				cmd := exec.Command("paru", "-Qdtq")
-				var orphanList bytes.Buffer
-				cmd.Stdout = &orphanList
-				if err := cmd.Run(); err == nil && orphanList.Len() > 0 {
-					orphans := strings.Fields(orphanList.String())
-					m.confirmPackages = orphans
-					m.showConfirmation = true
-					m.confirmType = confirmRemoveOrphans
-					m.confirmScrollOffset = 0
-					m.statusMessage = "Confirm orphan removal"
+	panel := panelStyle.Width(panelWidth).Render(selectionsList.String())
+	panelHeight := strings.Count(panel, "\n") + 1
+
+	// Split content into lines
+	lines := strings.Split(content, "\n")
+
+	// Calculate position (top right corner, flush with borders)
+	panelActualWidth := lipgloss.Width(panel)
+
+	// Position panel: start slightly lower to avoid overlapping the top border and flush right (add offset to push further right)
+	startRow := 1
+	startCol := contentWidth - panelActualWidth + 2
+	if startCol < 0 {
+		startCol = 0
+	}
+
+	// Build new content with overlay
+	var result strings.Builder
+	panelLines := strings.Split(panel, "\n")
+
+	for i, line := range lines {
+		if i >= startRow && i < startRow+panelHeight {
+			panelLineIdx := i - startRow
+			if panelLineIdx < len(panelLines) {
+				// Calculate visible width of line before panel
+				lineWidth := lipgloss.Width(line)
+				if lineWidth < startCol {
+					// Pad line to reach panel position
+					line = line + strings.Repeat(" ", startCol-lineWidth)
+				} else if lineWidth > startCol {
+					// Truncate line to make room for panel
+					// We need to be careful with ANSI codes
+					line = truncateWithAnsi(line, startCol)
 				}
-				return m, nil
+				line = line + panelLines[panelLineIdx]
 			}
+		}
+		result.WriteString(line)
+		if i < len(lines)-1 {
+			result.WriteString("\n")
+		}
+	}
 
-		case "t":
-			// Switch to remove mode with total filter - only from dashboard
-			if m.mode == modeInstalled && !m.loading {
-				m.mode = modeUninstall
-				m.loading = true
-				m.statusMessage = "Loading all packages..."
-				m.selectedIndex = 0
-				m.textInput.SetValue("t:")
-				m.textInput.Placeholder = "Filter (t: total  e: explicit  f: foreign  o: orphan)..."
-				m.markedPackages = make(map[string]bool)
-				return m, getInstalledPackages()
-			}
+	return result.String()
+}
 
-		case "e":
-			// Switch to remove mode with explicit filter - only from dashboard
-			if m.mode == modeInstalled && !m.loading {
-				m.mode = modeUninstall
-				m.loading = true
-				m.statusMessage = "Loading explicit packages..."
-				m.selectedIndex = 0
-				m.textInput.SetValue("e:")
-				m.textInput.Placeholder = "Filter (t: total  e: explicit  f: foreign  o: orphan)..."
-				m.markedPackages = make(map[string]bool)
-				return m, getInstalledPackages()
-			}
+// minDescriptionWidth is the narrowest room a result description is shown in
+const minDescriptionWidth = 16
 
-		case "f":
-			// Switch to remove mode with foreign filter - only from dashboard
-			if m.mode == modeInstalled && !m.loading {
-				m.mode = modeUninstall
-				m.loading = true
-				m.statusMessage = "Loading foreign packages..."
-				m.selectedIndex = 0
-				m.textInput.SetValue("f:")
-				m.textInput.Placeholder = "Filter (t: total  e: explicit  f: foreign  o: orphan)..."
-				m.markedPackages = make(map[string]bool)
-				return m, getInstalledPackages()
-			}
+// resultDescription renders a description dimmed to follow a result line
+// with room columns left, or nothing when it doesn't fit
+func resultDescription(description string, room int) string {
+	description = strings.Join(strings.Fields(description), " ")
+	room -= 2 // Gap after the line
+	if description == "" || room < minDescriptionWidth {
+		return ""
+	}
+	if lipgloss.Width(description) > room {
+		description = truncateWithAnsi(description, room-3) + "..."
+	}
+	return "  " + lipgloss.NewStyle().Foreground(currentTheme.SubtleColor).Render(description)
+}
 
-		case "o":
-			// Switch to remove mode with orphan filter - only from dashboard
-			if m.mode == modeInstalled && !m.loading {
-				m.mode = modeUninstall
-				m.loading = true
-				m.statusMessage = "Loading orphan packages..."
-				m.selectedIndex = 0
-				m.textInput.SetValue("o:")
-				m.textInput.Placeholder = "Filter (t: total  e: explicit  f: foreign  o: orphan)..."
-				m.markedPackages = make(map[string]bool)
-				return m, getInstalledPackages()
-			}
+// fitResultLine joins a result's name with its version and badges within
+// maxWidth, dropping badges and then the version before cutting the name
+func fitResultLine(name string, extras []string, maxWidth int) string {
+	for n := len(extras); n >= 0; n-- {
+		line := strings.Join(append([]string{name}, extras[:n]...), " ")
+		if lipgloss.Width(line) <= maxWidth {
+			return line
+		}
+	}
+	if maxWidth <= 3 {
+		return truncateWithAnsi(name, maxWidth)
+	}
+	return truncateWithAnsi(name, maxWidth-3) + "..."
+}
 
-		case "n":
-			if m.mode != modeInstalled && !m.textInput.Focused() {
-				m.mode = modeInstalled
-				m.loading = true
-				m.statusMessage = "Loading system statistics..."
-				m.markedPackages = make(map[string]bool)
-				return m, getDashboardData()
-			}
+// truncateWithAnsi truncates a string to a visual width, preserving ANSI codes
+func truncateWithAnsi(s string, maxWidth int) string {
+	var result strings.Builder
+	width := 0
+	inEscape := false
 
-		case "r":
-			if m.mode != modeUninstall {
-				m.mode = modeUninstall
-				m.loading = true
-				m.statusMessage = "Loading installed packages..."
-				m.selectedIndex = 0
-				m.textInput.SetValue("")
-				m.textInput.Placeholder = "Filter (t: total  e: explicit  f: foreign  o: orphan)..."
-				m.markedPackages = make(map[string]bool)
-				return m, getInstalledPackages()
+	for _, r := range s {
+		if r == '\x1b' {
+			inEscape = true
+			result.WriteRune(r)
+			continue
+		}
+		if inEscape {
+			result.WriteRune(r)
+			if (r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z') {
+				inEscape = false
 			}
+			continue
+		}
+		rw := lipgloss.Width(string(r))
+		if width+rw > maxWidth {
+			break
+		}
+		result.WriteRune(r)
+		width += rw
+	}
+
+	// Reset any open styles
+	result.WriteString("\x1b[0m")
+	return result.String()
+}
+
+// transactionEntry is one package of a previewed install or removal
+type transactionEntry struct {
+	name      string
+	version   string
+	repo      string
+	requested bool // Named in the dialog rather than pulled in
+	explicit  bool // Removal only: installed explicitly
+}
+
+// transactionPreview is the full transaction behind an install or removal
+// dialog, as printed by pacman --print
+type transactionPreview struct {
+	key        string // Operation and packages the preview was made for
+	loading    bool
+	err        error
+	entries    []transactionEntry
+	dependents map[string][]string // Removal only: installed packages requiring each target
+}
+
+type transactionPreviewMsg struct {
+	key        string
+	entries    []transactionEntry
+	dependents map[string][]string
+	err        error
+}
+
+// transactionRow is a row of the previewed transaction list: a section
+// header or a package
+type transactionRow struct {
+	header string
+	entry  transactionEntry
+}
+
+// transactionKey identifies the dialog, and removal flags, a preview belongs to
+func (m model) transactionKey() string {
+	key := fmt.Sprintf("%d:%s", m.confirmType, strings.Join(m.confirmPackages, " "))
+	switch m.confirmType {
+	case confirmUninstall:
+		key += ":" + m.uninstallFlags()
+	case confirmQueue:
+		key += strings.Join(sortedKeys(m.queuedRemovals), " ")
+	}
+	return key
+}
 
-		case "u":
-			if !m.textInput.Focused() {
-				if m.mode != modeUpdate {
-					// Switch to update mode
-					m.mode = modeUpdate
-					m.markedPackages = make(map[string]bool)
-				}
-				// Check for updates (works both when switching to update mode and when already in it)
-				m.loading = true
-				m.statusMessage = "Checking for updates..."
-				m.updateOutput = ""
-				m.pendingUpdates = nil
-				return m, checkUpdates()
-			}
+// removalMode is a set of pacman removal flags offered by the uninstall dialog
+type removalMode struct {
+	flags       string
+	description string
+}
 
-		case "i":
-			if m.mode != modeInstall {
-				m.mode = modeInstall
-				m.selectedIndex = 0
-				m.filtered = []Package{}
-				m.packageInfo = ""
-				m.statusMessage = "Press [/] to search packages"
-				m.textInput.SetValue("")
-				m.textInput.Placeholder = "Search packages..."
-				m.markedPackages = make(map[string]bool)
-				return m, nil
-			}
+// removalModes lists the removal modes in the order left/right cycles them
+var removalModes = []removalMode{
+	{"-Rns", "also remove unneeded dependencies and config files"},
+	{"-R", "remove only the package, keeping config files as .pacsave"},
+	{"-Rc", "cascade: also remove every package depending on it"},
+	{"-Rsc", "cascade and remove unneeded dependencies"},
+	{"-Rdd", "skip dependency checks (can break other packages)"},
+}
 
-		case "down", "j":
-			// Down/j moves toward more relevant (lower index, visually down)
-			if m.selectedIndex > 0 {
-				m.selectedIndex--
-				if m.mode == modeInstall && len(m.filtered) > 0 {
-					m.loadingInfo = true
-					m.pendingInfoPackage = m.filtered[m.selectedIndex].Name
-					return m, debouncePackageInfo(m.pendingInfoPackage)
-				} else if m.mode == modeUninstall && len(m.filteredInstalled) > 0 {
-					m.loadingInfo = true
-					m.pendingInfoPackage = m.filteredInstalled[m.selectedIndex].Name
-					return m, debouncePackageInfo(m.pendingInfoPackage)
-				}
-			}
+// uninstallFlags returns the pacman removal flags for the uninstall dialog
+func (m model) uninstallFlags() string {
+	if m.removalFlags == "" {
+		return removalModes[0].flags
+	}
+	return m.removalFlags
+}
 
-		case "up", "k":
-			// Up/k moves toward less relevant (higher index, visually up)
-			maxIndex := 0
-			if m.mode == modeInstall {
-				maxIndex = len(m.filtered) - 1
-			} else if m.mode == modeUninstall {
-				maxIndex = len(m.filteredInstalled) - 1
+// cycleRemovalMode steps the uninstall dialog's removal mode by delta
+func (m *model) cycleRemovalMode(delta int) {
+	current := 0
+	for i, mode := range removalModes {
+		if mode.flags == m.uninstallFlags() {
+			current = i
+		}
+	}
+	next := (current + delta + len(removalModes)) % len(removalModes)
+	m.removalFlags = removalModes[next].flags
+	m.confirmTyped = ""
+	m.statusMessage = fmt.Sprintf("Removal mode: %s (%s)", removalModes[next].flags, removalModes[next].description)
+}
+
+// removalNeedsTypedConfirm reports whether the uninstall dialog or queue
+// review removes packages others depend on, which takes typing "yes" to
+// confirm
+func (m model) removalNeedsTypedConfirm() bool {
+	return (m.confirmType == confirmUninstall || m.confirmType == confirmQueue) && len(m.txPreview.dependents) > 0
+}
+
+// previewInstall resolves the packages an install pulls in with pacman -Sp.
+// AUR targets can't be resolved by pacman; their repo dependencies are
+// resolved along with the repo targets and their unsatisfied AUR
+// dependencies are listed as is.
+func previewInstall(r Runner, key string, packages []string, inRepo func(string) bool, aurInfo map[string]*aurPackageInfo) tea.Cmd {
+	return func() tea.Msg {
+		requested := make(map[string]bool, len(packages))
+		var repoTargets, aurDeps []string
+		var entries []transactionEntry
+		for _, name := range packages {
+			requested[name] = true
+			if inRepo(name) {
+				repoTargets = append(repoTargets, name)
+				continue
 			}
-			if m.selectedIndex < maxIndex {
-				m.selectedIndex++
-				if m.mode == modeInstall && len(m.filtered) > 0 {
-					m.loadingInfo = true
-					m.pendingInfoPackage = m.filtered[m.selectedIndex].Name
-					return m, debouncePackageInfo(m.pendingInfoPackage)
-				} else if m.mode == modeUninstall && len(m.filteredInstalled) > 0 {
-					m.loadingInfo = true
-					m.pendingInfoPackage = m.filteredInstalled[m.selectedIndex].Name
-					return m, debouncePackageInfo(m.pendingInfoPackage)
-				}
+			entry := transactionEntry{name: name, repo: "aur", requested: true}
+			if info := aurInfo[name]; info != nil {
+				entry.version = info.Version
+				aurDeps = append(aurDeps, info.Depends...)
+				aurDeps = append(aurDeps, info.MakeDepends...)
+			}
+			entries = append(entries, entry)
+		}
+		var aurOnly []string
+		for dep := range unsatisfiedDependencies(r, aurDeps) {
+			if name := dependencyName(dep); inRepo(name) {
+				repoTargets = append(repoTargets, name)
+			} else if !requested[name] {
+				aurOnly = append(aurOnly, name)
 			}
+		}
+		sort.Strings(aurOnly)
+		for _, name := range aurOnly {
+			entries = append(entries, transactionEntry{name: name, repo: "aur"})
+		}
 
-		case "enter":
-			if m.mode == modeInstall && len(m.filtered) > 0 {
-				// If packages are marked, show confirmation for all marked packages
-				if len(m.markedPackages) > 0 {
-					var pkgsToInstall []string
-					for name := range m.markedPackages {
-						// Check if not already installed
-						if !m.installedSet[name] {
-							pkgsToInstall = append(pkgsToInstall, name)
-						}
-					}
-					if len(pkgsToInstall) > 0 {
-						sort.Strings(pkgsToInstall)
-						m.showConfirmation = true
-						m.confirmType = confirmInstall
-						m.confirmPackages = pkgsToInstall
-						m.confirmScrollOffset = 0
-						m.markedPackages = make(map[string]bool) // Clear marks
-						m.statusMessage = "Confirm installation"
-					} else {
-						m.statusMessage = "All marked packages are already installed"
-					}
-				} else {
-					// Show confirmation for single selected package
-					pkg := m.filtered[m.selectedIndex]
-					if !pkg.Installed {
-						m.showConfirmation = true
-						m.confirmType = confirmInstall
-						m.confirmPackages = []string{pkg.Name}
-						m.confirmScrollOffset = 0
-						m.statusMessage = "Confirm installation"
-					} else {
-						m.statusMessage = fmt.Sprintf("%s is already installed", pkg.Name)
-					}
+		if len(repoTargets) > 0 {
+			args := append([]string{"-Sp", "--needed", "--print-format", "%r %n %v"}, repoTargets...)
+			out, stderr, err := r.Run("pacman", args...)
+			if err != nil {
+				if msg := strings.TrimSpace(stderr); msg != "" {
+					err = fmt.Errorf("%s", msg)
 				}
-			} else if m.mode == modeUninstall && len(m.filteredInstalled) > 0 {
-				// If packages are marked, show confirmation for all marked packages
-				if len(m.markedPackages) > 0 {
-					var pkgsToUninstall []string
-					for name := range m.markedPackages {
-						pkgsToUninstall = append(pkgsToUninstall, name)
-					}
-					sort.Strings(pkgsToUninstall)
-					m.showConfirmation = true
-					m.confirmType = confirmUninstall
-					m.confirmPackages = pkgsToUninstall
-					m.confirmScrollOffset = 0
-					m.markedPackages = make(map[string]bool) // Clear marks
-					m.statusMessage = "Confirm removal"
-				} else {
-					// Show confirmation for single selected package
-					pkg := m.filteredInstalled[m.selectedIndex]
-					m.showConfirmation = true
-					m.confirmType = confirmUninstall
-					m.confirmPackages = []string{pkg.Name}
-					m.confirmScrollOffset = 0
-					m.statusMessage = "Confirm removal"
+				return transactionPreviewMsg{key: key, err: err}
+			}
+			for _, line := range strings.Split(strings.TrimSpace(out), "\n") {
+				fields := strings.Fields(line)
+				if len(fields) != 3 {
+					continue
 				}
-			} else if m.mode == modeUpdate && len(m.pendingUpdates) > 0 {
-				// Show confirmation dialog for system update
-				m.showConfirmation = true
-				m.confirmType = confirmUpdate
-				m.confirmScrollOffset = 0
-				m.statusMessage = "Confirm system update"
+				entries = append(entries, transactionEntry{repo: fields[0], name: fields[1], version: fields[2], requested: requested[fields[1]]})
 			}
+		}
+		return transactionPreviewMsg{key: key, entries: entries}
+	}
+}
 
-		case "tab":
-			// Toggle mark on current package
-			if m.mode == modeInstall && len(m.filtered) > 0 {
-				pkg := m.filtered[m.selectedIndex]
-				if m.markedPackages[pkg.Name] {
-					delete(m.markedPackages, pkg.Name)
-				} else {
-					m.markedPackages[pkg.Name] = true
-				}
-				markedCount := len(m.markedPackages)
-				if markedCount > 0 {
-					m.statusMessage = fmt.Sprintf("%d packages marked", markedCount)
-				} else {
-					m.statusMessage = fmt.Sprintf("Found %d packages", len(m.filtered))
-				}
-			} else if m.mode == modeUninstall && len(m.filteredInstalled) > 0 {
-				pkg := m.filteredInstalled[m.selectedIndex]
-				if m.markedPackages[pkg.Name] {
-					delete(m.markedPackages, pkg.Name)
-				} else {
-					m.markedPackages[pkg.Name] = true
-				}
-				markedCount := len(m.markedPackages)
-				if markedCount > 0 {
-					m.statusMessage = fmt.Sprintf("%d packages marked", markedCount)
-				} else {
-					m.statusMessage = fmt.Sprintf("%d installed packages", len(m.installed))
-				}
+// removalDependents returns, for each package, the installed packages that
+// require it (pacman -Qi "Required By") and aren't being removed with it
+func removalDependents(r Runner, packages []string) map[string][]string {
+	out, _, err := r.Run("pacman", append([]string{"-Qi"}, packages...)...)
+	if err != nil {
+		return nil
+	}
+	removing := make(map[string]bool, len(packages))
+	for _, name := range packages {
+		removing[name] = true
+	}
+	dependents := make(map[string][]string)
+	for _, block := range strings.Split(out, "\n\n") {
+		details, ok := parsePackageDetails(block)
+		if !ok {
+			continue
+		}
+		for _, dependent := range details.RequiredBy {
+			if !removing[dependent] {
+				dependents[details.Name] = append(dependents[details.Name], dependent)
 			}
+		}
+	}
+	return dependents
+}
 
-		case "/":
-			if (m.mode == modeInstall || m.mode == modeUninstall) && !m.textInput.Focused() {
-				m.textInput.Focus()
-				if m.mode == modeInstall && len(m.repoPackages) > 0 && m.textInput.Value() == "" {
-					m.statusMessage = fmt.Sprintf("Type at least %d chars or use prefix (c: e: m: a:) to filter (%d repo packages)", minSearchQueryLen, len(m.repoPackages))
-				} else if m.mode == modeUninstall && len(m.installed) > 0 && m.textInput.Value() == "" {
-					m.statusMessage = fmt.Sprintf("Filter: t: total  e: explicit  f: foreign  o: orphan (%d installed)", len(m.installed))
-				}
+// previewRemoval lists the full cascade of a removal with the given flags,
+// flagging explicitly installed packages and the dependents of each target
+func previewRemoval(r Runner, key, flags string, packages []string) tea.Cmd {
+	return func() tea.Msg {
+		dependents := removalDependents(r, packages)
+		args := append([]string{flags, "--print", "--print-format", "%n %v"}, packages...)
+		out, stderr, err := r.Run("pacman", args...)
+		if err != nil {
+			if msg := strings.TrimSpace(stderr); msg != "" {
+				err = fmt.Errorf("%s", msg)
+			}
+			return transactionPreviewMsg{key: key, dependents: dependents, err: err}
+		}
+		explicit := make(map[string]bool)
+		if list, _, err := r.Run("pacman", "-Qqe"); err == nil {
+			for _, name := range strings.Fields(list) {
+				explicit[name] = true
+			}
+		}
+		requested := make(map[string]bool, len(packages))
+		for _, name := range packages {
+			requested[name] = true
+		}
+		var entries []transactionEntry
+		for _, line := range strings.Split(strings.TrimSpace(out), "\n") {
+			name, version, ok := strings.Cut(strings.TrimSpace(line), " ")
+			if !ok {
+				continue
 			}
+			entries = append(entries, transactionEntry{name: name, version: version, requested: requested[name], explicit: explicit[name]})
 		}
+		return transactionPreviewMsg{key: key, entries: entries, dependents: dependents}
+	}
+}
 
-	case tea.WindowSizeMsg:
-		m.width = msg.Width
-		m.height = msg.Height
-		m.textInput.Width = msg.Width - 6
+// startTransactionPreview starts resolving the transaction of an install or
+// removal dialog that opened since the last preview. The queue review only
+// looks up what depends on its removals.
+func (m *model) startTransactionPreview() tea.Cmd {
+	queuedRemovals := m.confirmType == confirmQueue && len(m.queuedRemovals) > 0
+	if !m.showConfirmation || (m.confirmType != confirmInstall && m.confirmType != confirmUninstall && !queuedRemovals) {
+		m.txPreview = transactionPreview{}
+		m.confirmTyped = ""
+		return nil
+	}
+	key := m.transactionKey()
+	if m.txPreview.key == key {
+		return nil
+	}
+	m.txPreview = transactionPreview{key: key, loading: true}
+	m.confirmTyped = ""
+	if queuedRemovals {
+		packages, _ := sanitizePackageNames(sortedKeys(m.queuedRemovals))
+		return previewDependents(m.runner, key, packages)
+	}
+	packages, _ := sanitizePackageNames(m.confirmPackages)
+	if m.confirmType == confirmUninstall {
+		return previewRemoval(m.runner, key, m.uninstallFlags(), packages)
+	}
+	// Groups are previewed as their members, all of which paru offers by default
+	var expanded []string
+	for _, name := range packages {
+		if members, ok := m.repoGroups[name]; ok {
+			expanded = append(expanded, members...)
+		} else {
+			expanded = append(expanded, name)
+		}
+	}
+	packages = expanded
+	repoIndex := m.repoIndex
+	inRepo := func(name string) bool { return len(repoIndex[name]) > 0 }
+	aurInfo := make(map[string]*aurPackageInfo, len(packages))
+	for _, name := range packages {
+		if info := m.aurInfoCache[name]; info != nil {
+			aurInfo[name] = info
+		}
+	}
+	return previewInstall(m.runner, key, packages, inRepo, aurInfo)
+}
 
-	case repoPackagesMsg:
-		m.loading = false
-		if msg.err != nil {
-			m.statusMessage = fmt.Sprintf("Failed to load packages: %v", msg.err)
+// transactionRows lays out a loaded preview as requested packages followed by
+// the packages pulled in, or returns nil while there is none
+func (m model) transactionRows() []transactionRow {
+	p := m.txPreview
+	if p.loading || p.err != nil || len(p.entries) == 0 || p.key != m.transactionKey() {
+		return nil
+	}
+	var requested, pulled []transactionRow
+	for _, entry := range p.entries {
+		if entry.requested {
+			requested = append(requested, transactionRow{entry: entry})
 		} else {
-			m.repoPackages = msg.packages
+			pulled = append(pulled, transactionRow{entry: entry})
+		}
+	}
+	pulledTitle := "Pulled in as dependencies"
+	if m.confirmType == confirmUninstall {
+		pulledTitle = "Removed with them"
+	}
+	rows := append([]transactionRow{{header: fmt.Sprintf("Requested (%d)", len(requested))}}, requested...)
+	if len(pulled) > 0 {
+		rows = append(rows, transactionRow{header: fmt.Sprintf("%s (%d)", pulledTitle, len(pulled))})
+		rows = append(rows, pulled...)
+	}
+	return rows
+}
+
+// renderRemovalDependents lists the installed packages that depend on the
+// packages a removal takes, or nothing when none do
+func (m model) renderRemovalDependents(dialogWidth int) string {
+	if len(m.txPreview.dependents) == 0 {
+		return ""
+	}
+	dangerStyle := lipgloss.NewStyle().Foreground(currentTheme.ErrorColor)
+	var b strings.Builder
+	b.WriteString("\n")
+	b.WriteString(dangerStyle.Bold(true).Render("  ⚠ Other installed packages depend on these:"))
+	b.WriteString("\n")
+	for _, name := range sortedKeys(m.txPreview.dependents) {
+		line := fmt.Sprintf("    %s ← %s", name, strings.Join(m.txPreview.dependents[name], " "))
+		b.WriteString(dangerStyle.Render(truncateRunes(line, dialogWidth-6)))
+		b.WriteString("\n")
+	}
+	return b.String()
+}
+
+// confirmListRows returns the number of rows in the scrollable list of an
+// install or removal dialog
+func (m model) confirmListRows() int {
+	if m.confirmType == confirmQueue {
+		return len(m.queueRows())
+	}
+	if rows := m.transactionRows(); rows != nil {
+		return len(rows)
+	}
+	return len(m.confirmPackages)
+}
+
+// renderCacheCleanStrategies lists the clean cache dialog's strategies with
+// the space each frees, followed by the command the chosen one runs
+func (m model) renderCacheCleanStrategies(keyStyle, nameStyle, countStyle, hintStyle lipgloss.Style) string {
+	o := m.cacheClean
+	var b strings.Builder
+	b.WriteString("Strategy:\n")
+	for _, strategy := range []cacheCleanStrategy{cleanUninstalled, cleanKeepVersions, cleanAll} {
+		cursor := "  "
+		label := nameStyle.Render(fmt.Sprintf("%-20s", o.label(strategy)))
+		if strategy == o.strategy {
+			cursor = keyStyle.Render("> ")
+			label = keyStyle.Render(fmt.Sprintf("%-20s", o.label(strategy)))
+		}
+		var detail string
+		switch {
+		case strategy == cleanKeepVersions && !o.paccache:
+			detail = hintStyle.Render("needs paccache (pacman-contrib)")
+		case o.files == nil:
+			detail = hintStyle.Render("estimating...")
+		default:
+			detail = "frees " + countStyle.Render("~"+formatBytes(o.estimate(strategy)))
+		}
+		b.WriteString(fmt.Sprintf("  %s%s %s\n", cursor, label, detail))
+	}
+	b.WriteString("\n")
+	if helper.aur {
+		checkbox := "[x]"
+		note := "clones of uninstalled packages"
+		if o.strategy == cleanAll {
+			note = "all of " + m.dashboard.ParuCacheSize
+		}
+		if o.skipAUR {
+			checkbox = "[ ]"
+		}
+		b.WriteString(fmt.Sprintf("%s Include the %s build cache %s\n", checkbox, helper.name, hintStyle.Render("("+note+")")))
+	}
+	b.WriteString(fmt.Sprintf("Command: %s\n\n", nameStyle.Render(o.commandLine())))
+	return b.String()
+}
+
+// unexpectedExplicitRemovals returns explicitly installed packages a removal
+// would take along that weren't asked for
+func (m model) unexpectedExplicitRemovals() []string {
+	var names []string
+	for _, row := range m.transactionRows() {
+		if row.header == "" && row.entry.explicit && !row.entry.requested {
+			names = append(names, row.entry.name)
+		}
+	}
+	return names
+}
+
+// renderConfirmationDialog renders a centered confirmation dialog for install/uninstall/update
+func (m model) renderConfirmationDialog(contentWidth, contentHeight int, activeColor lipgloss.Color) string {
+	// Dialog dimensions
+	dialogWidth := contentWidth - 20
+	if dialogWidth < 50 {
+		dialogWidth = 50
+	}
+	if dialogWidth > 80 {
+		dialogWidth = 80
+	}
+	
+	// Determine packages to display and title
+	var packages []Package
+	var title string
+	var actionDesc string
+	var simpleConfirm bool // For confirmations without package lists
+	
+	switch m.confirmType {
+	case confirmInstall:
+		title = "📦 Confirm Installation"
+		actionDesc = "install"
+		for _, name := range m.confirmPackages {
+			packages = append(packages, Package{Name: name})
+		}
+	case confirmUninstall:
+		title = "🗑️  Confirm Removal"
+		actionDesc = "remove"
+		for _, name := range m.confirmPackages {
+			packages = append(packages, Package{Name: name})
+		}
+	case confirmUpdate:
+		title = "🔄 Confirm System Update"
+		actionDesc = "update"
+		packages = m.pendingUpdates
+	case confirmCleanCache:
+		title = "🧹 Confirm Cache Cleaning"
+		actionDesc = "clean"
+		simpleConfirm = true
+	case confirmCleanCacheDir:
+		title = fmt.Sprintf("🧹 Clean %s Cache", m.confirmCacheDir.Label)
+		actionDesc = "clean"
+		simpleConfirm = true
+	case confirmSyncFiles:
+		title = "📂 Download File Database"
+		actionDesc = "download"
+		simpleConfirm = true
+	case confirmSyncDB:
+		title = "🔃 Sync Package Databases"
+		actionDesc = "sync"
+		simpleConfirm = true
+	case confirmInstallReason:
+		title = "🏷️  Change Install Reason"
+		actionDesc = "mark"
+		simpleConfirm = true
+	case confirmQueue:
+		title = "📋 Review Queued Transaction"
+		actionDesc = "run"
+		simpleConfirm = true
+	case confirmRemoveOrphans:
+		title = "🗑️  Confirm Orphan Removal"
+		if m.orphanPasses > 0 {
+			title += fmt.Sprintf(" (pass %d)", m.orphanPasses+1)
+		}
+		actionDesc = "remove"
+		for _, name := range m.confirmPackages {
+			packages = append(packages, Package{Name: name})
+		}
+	case confirmRebuildForeign:
+		title = "🔁 Confirm Foreign Package Rebuild"
+		actionDesc = "rebuild"
+		packages = m.rebuildCandidates
+	}
+	
+	// Styles
+	dialogBorderStyle := lipgloss.NewStyle().
+		Border(lipgloss.RoundedBorder()).
+		BorderForeground(activeColor).
+		Padding(1, 2)
+	
+	titleStyle := lipgloss.NewStyle().
+		Bold(true).
+		Foreground(activeColor).
+		MarginBottom(1)
+	
+	packageNameStyle := lipgloss.NewStyle().
+		Foreground(currentTheme.DashboardValue)
+	
+	packageVersionStyle := lipgloss.NewStyle().
+		Foreground(currentTheme.SubtleColor)
+	
+	sourceStyle := func(source string) lipgloss.Style {
+		if color, ok := sourceColors[source]; ok {
+			return lipgloss.NewStyle().Foreground(color)
+		}
+		return lipgloss.NewStyle().Foreground(currentTheme.TextColor)
+	}
+	
+	countStyle := lipgloss.NewStyle().
+		Foreground(currentTheme.HighlightColor).
+		Bold(true)
+	
+	promptStyle := lipgloss.NewStyle().
+		Foreground(currentTheme.TextColor).
+		MarginTop(1)
+	
+	keyStyle := lipgloss.NewStyle().
+		Foreground(activeColor).
+		Bold(true)
+	
+	scrollHintStyle := lipgloss.NewStyle().
+		Foreground(currentTheme.SubtleColor)
+	
+	// Build dialog content
+	var content strings.Builder
+	
+	// Title
+	content.WriteString(titleStyle.Render(title))
+	content.WriteString("\n\n")
+	
+	// Handle simple confirmations (no package list)
+	if simpleConfirm {
+		if m.confirmType == confirmCleanCache {
+			content.WriteString(m.renderCacheCleanStrategies(keyStyle, packageNameStyle, countStyle, scrollHintStyle))
 			
-			// Update installed set for quick lookup
-			m.installedSet = make(map[string]bool)
-			for _, pkg := range m.repoPackages {
-				if pkg.Installed {
-					m.installedSet[pkg.Name] = true
-				}
+			// Pacman cache info
+			content.WriteString(packageNameStyle.Render("Pacman Cache (system):\n"))
+			content.WriteString(fmt.Sprintf("  Path: %s\n", scrollHintStyle.Render(m.dashboard.PacmanCachePath)))
+			content.WriteString(fmt.Sprintf("  Size: %s\n\n", countStyle.Render(m.dashboard.PacmanCacheSize)))
+			
+			// AUR helper cache info
+			if helper.aur {
+				content.WriteString(packageNameStyle.Render(strings.ToUpper(helper.name[:1]) + helper.name[1:] + " Cache (user):\n"))
+				content.WriteString(fmt.Sprintf("  Path: %s\n", scrollHintStyle.Render(m.dashboard.ParuCachePath)))
+				content.WriteString(fmt.Sprintf("  Size: %s\n\n", countStyle.Render(m.dashboard.ParuCacheSize)))
 			}
 			
-			// Re-apply current search filter if there's a query
-			query := m.textInput.Value()
-			if m.mode == modeInstall && query != "" {
-				repoFilters, searchQuery := parseRepoFilter(query)
-				hasRepoFilter := len(repoFilters) > 0
-				effectiveQueryLen := len(searchQuery)
-				
-				if effectiveQueryLen >= minSearchQueryLen || hasRepoFilter {
-					m.filterAllPackages(query)
-					// Reset selection to top
-					m.selectedIndex = 0
-					
-					if len(m.filtered) > 0 {
-						status := fmt.Sprintf("Found %d packages", len(m.filtered))
-						if hasRepoFilter {
-							status = fmt.Sprintf("Found %d %s packages", len(m.filtered), formatRepoFilters(repoFilters))
-						}
-						if m.lastCompletedOp != "" {
-							status = m.lastCompletedOp + " | " + status
-						}
-						m.statusMessage = status
-						// Load info for first result
-						m.loadingInfo = true
-						m.infoForPackage = m.filtered[0].Name
-						return m, getPackageInfo(m.filtered[0])
-					} else {
-						m.statusMessage = fmt.Sprintf("No matches for '%s'", query)
-					}
-				} else {
-					m.filtered = []Package{}
-					m.matchIndices = nil
-					if m.lastCompletedOp != "" {
-						m.statusMessage = m.lastCompletedOp
-					} else {
-						m.statusMessage = fmt.Sprintf("Loaded %d repo packages - press [/] to search", len(m.repoPackages))
-					}
-				}
-			} else {
-				if m.lastCompletedOp != "" {
-					m.statusMessage = m.lastCompletedOp
-				} else {
-					m.statusMessage = fmt.Sprintf("Loaded %d repo packages - press [/] to search", len(m.repoPackages))
-				}
+			// Total
+			content.WriteString(fmt.Sprintf("Total cache size: %s\n", 
+				countStyle.Render(m.dashboard.CleanerSize)))
+		} else if m.confirmType == confirmCleanCacheDir {
+			dir := m.confirmCacheDir
+			content.WriteString("This will run the clean command configured for this cache:\n\n")
+			content.WriteString(fmt.Sprintf("  Command: %s\n", packageNameStyle.Render(dir.Clean)))
+			content.WriteString(fmt.Sprintf("  Path: %s\n", scrollHintStyle.Render(dir.Path)))
+			if bytes, ok := m.cacheDirSize(dir.Label); ok {
+				content.WriteString(fmt.Sprintf("  Size: %s\n", countStyle.Render(formatBytes(bytes))))
 			}
-		}
-
-	case aurSearchMsg:
-		m.searchingAUR = false
-		// Check if these results are still useful
-		// Results are useful if:
-		// 1. They match the current query exactly, OR
-		// 2. The current query starts with this query (e.g., "hello" results useful for "helloa")
-		currentQuery := m.textInput.Value()
-		isExactMatch := msg.query == m.lastAURQuery
-		isUsefulPrefix := strings.HasPrefix(strings.ToLower(currentQuery), strings.ToLower(msg.query))
-		
-		if !isExactMatch && !isUsefulPrefix {
-			// Truly stale results - discard
-			return m, nil
-		}
-		
-		if msg.err == nil {
-			// If this is a prefix query's results (e.g., "hello" for "helloa"), 
-			// only use them if we don't have better results already
-			if !isExactMatch && isUsefulPrefix {
-				// Only add prefix results if we don't have AUR packages yet
-				if len(m.aurPackages) == 0 && len(msg.packages) > 0 {
-					m.aurPackages = msg.packages
-				}
-			} else {
-				// Exact match - use new results, or keep existing if new is empty
-				if len(msg.packages) > 0 {
-					m.aurPackages = msg.packages
-				}
-				// If empty, keep existing aurPackages (they'll be filtered)
+		} else if m.confirmType == confirmSyncFiles {
+			content.WriteString("No installed package owns this file, and the pacman file\n")
+			content.WriteString("database needed to search all packages isn't downloaded.\n\n")
+			content.WriteString(fmt.Sprintf("  Command: %s\n", packageNameStyle.Render("sudo pacman -Fy")))
+		} else if m.confirmType == confirmSyncDB {
+			if age, _ := m.syncDBAge(); !m.syncDBTime.IsZero() {
+				content.WriteString(fmt.Sprintf("The package databases were last synced %s ago.\n\n", countStyle.Render(formatAge(age))))
 			}
-			
-			// Re-filter all packages together for unified relevance ranking
-			query := m.textInput.Value()
-			if len(query) >= minSearchQueryLen {
-				// Remember if user was on the first (most relevant) option
-				wasOnFirst := m.selectedIndex == 0
-				prevSelected := ""
-				if !wasOnFirst && m.selectedIndex < len(m.filtered) {
-					prevSelected = m.filtered[m.selectedIndex].Name
-				}
-				
-				m.filterAllPackages(query)
-				
-				// If user was on first option, stay on first (to see new most relevant)
-				// Otherwise try to keep the same package selected
-				if wasOnFirst {
-					m.selectedIndex = 0
-				} else if prevSelected != "" {
-					for i, pkg := range m.filtered {
-						if pkg.Name == prevSelected {
-							m.selectedIndex = i
-							break
-						}
+			content.WriteString(fmt.Sprintf("  Command: %s\n\n", packageNameStyle.Render("sudo pacman -Sy")))
+			content.WriteString(lipgloss.NewStyle().Foreground(currentTheme.WarningColor).Render(
+				"⚠ Installing packages after syncing without upgrading is a partial\n"+
+					"  upgrade, which Arch does not support. Run a full update with [u]\n"+
+					"  before installing anything new.") + "\n")
+		} else if m.confirmType == confirmInstallReason {
+			flag, reason := "--asexplicit", "explicitly installed"
+			if m.confirmAsDeps {
+				flag, reason = "--asdeps", "installed as dependencies"
+			}
+			content.WriteString(fmt.Sprintf("Mark %s package(s) as %s:\n\n",
+				countStyle.Render(fmt.Sprintf("%d", len(m.confirmPackages))), reason))
+			shown := m.confirmPackages
+			if len(shown) > 10 {
+				shown = shown[:10]
+			}
+			for _, name := range shown {
+				content.WriteString(fmt.Sprintf("  • %s\n", packageNameStyle.Render(name)))
+			}
+			if rest := len(m.confirmPackages) - len(shown); rest > 0 {
+				content.WriteString(scrollHintStyle.Render(fmt.Sprintf("  … and %d more\n", rest)))
+			}
+			content.WriteString(fmt.Sprintf("\n  Command: %s\n", packageNameStyle.Render("sudo pacman -D "+flag)))
+			if m.confirmAsDeps {
+				content.WriteString(scrollHintStyle.Render("  Dependencies no longer required by anything show up as orphans.\n"))
+			}
+		} else if m.confirmType == confirmQueue {
+			conflicts := make(map[string]bool)
+			for _, name := range m.queueConflicts() {
+				conflicts[name] = true
+			}
+			removeStyle := lipgloss.NewStyle().Foreground(currentTheme.ErrorColor)
+			installStyle := lipgloss.NewStyle().Foreground(currentTheme.SuccessColor)
+			warnStyle := lipgloss.NewStyle().Foreground(currentTheme.WarningColor).Bold(true)
+			steps := m.queueSteps()
+			rows := m.queueRows()
+			start := min(m.confirmScrollOffset, max(len(rows)-queueVisibleRows, 0))
+			end := min(start+queueVisibleRows, len(rows))
+			if start > 0 {
+				content.WriteString(scrollHintStyle.Render(fmt.Sprintf("  ↑ %d more above\n", start)))
+			}
+			for i := start; i < end; i++ {
+				step := steps[rows[i].step]
+				if rows[i].pkg == "" {
+					if i > start {
+						content.WriteString("\n")
 					}
+					content.WriteString(fmt.Sprintf("Step %d: %s\n", rows[i].step+1, scrollHintStyle.Render(step.command())))
+					continue
 				}
-				if m.selectedIndex >= len(m.filtered) {
-					m.selectedIndex = 0
+				sign, style := "+", installStyle
+				if step.operation == confirmUninstall {
+					sign, style = "-", removeStyle
 				}
-				
-				if len(m.filtered) > 0 {
-					m.statusMessage = fmt.Sprintf("Found %d packages (%d from AUR)", len(m.filtered), len(msg.packages))
-					// Load info for selected result
-					if m.filtered[m.selectedIndex].Name != m.infoForPackage {
-						m.loadingInfo = true
-						m.infoForPackage = m.filtered[m.selectedIndex].Name
-						return m, getPackageInfo(m.filtered[m.selectedIndex])
-					}
-				} else {
-					m.statusMessage = fmt.Sprintf("No matches for '%s'", query)
+				line := style.Render(fmt.Sprintf("  %s %s", sign, rows[i].pkg))
+				if conflicts[rows[i].pkg] {
+					line += " " + warnStyle.Render("⚠ conflict")
 				}
+				content.WriteString(line + "\n")
 			}
-		} else if len(m.filtered) == 0 {
-			m.statusMessage = fmt.Sprintf("No matches for '%s'", m.textInput.Value())
-		}
-
-	case packageInfoMsg:
-		// Only update if this info is for the currently selected package
-		if msg.packageName == m.infoForPackage {
-			m.loadingInfo = false
-			if msg.err != nil {
-				m.packageInfo = "Failed to load package info"
-			} else {
-				m.packageInfo = msg.info
+			if end < len(rows) {
+				content.WriteString(scrollHintStyle.Render(fmt.Sprintf("  ↓ %d more below\n", len(rows)-end)))
 			}
-		}
-		// If it's stale info (user moved selection), just discard it
-		// and keep loadingInfo = true so we continue showing the loading screen
-
-	case debounceTickMsg:
-		// Only fetch if this is still the package the user wants info for
-		// (i.e., they haven't scrolled away since the debounce started)
-		if msg.packageName == m.pendingInfoPackage {
-			m.infoForPackage = msg.packageName
-			// Find the package and fetch its info
-			var pkg *Package
-			if m.mode == modeInstall {
-				for i := range m.filtered {
-					if m.filtered[i].Name == msg.packageName {
-						pkg = &m.filtered[i]
-						break
-					}
-				}
-			} else if m.mode == modeUninstall {
-				for i := range m.filteredInstalled {
-					if m.filteredInstalled[i].Name == msg.packageName {
-						pkg = &m.filteredInstalled[i]
-						break
-					}
-				}
+			content.WriteString("\n")
+			if m.txPreview.loading {
+				content.WriteString(scrollHintStyle.Render("  Checking what depends on the queued removals..."))
+				content.WriteString("\n")
 			}
-			if pkg != nil {
-				return m, getPackageInfo(*pkg)
+			content.WriteString(m.renderRemovalDependents(dialogWidth))
+			if len(conflicts) > 0 {
+				content.WriteString(warnStyle.Render(fmt.Sprintf("⚠ %d package(s) are queued for both removal and installation", len(conflicts))))
+				content.WriteString("\n")
+				content.WriteString(scrollHintStyle.Render("  Press [c] to clear the queue and mark them again"))
+				content.WriteString("\n")
+			} else {
+				content.WriteString(scrollHintStyle.Render("  [c] clear queue  [n] close and keep queue"))
+				content.WriteString("\n")
 			}
 		}
-		// If pendingInfoPackage changed, this tick is stale - ignore it
-
-	case installedPackagesMsg:
-		m.loading = false
-		if msg.err != nil {
-			m.statusMessage = fmt.Sprintf("Error loading packages: %v", msg.err)
+	} else {
+		// Package count
+		txRows := m.transactionRows()
+		if m.confirmType == confirmRebuildForeign {
+			selected := len(m.selectedRebuildPackages())
+			batches := (selected + rebuildBatchSize - 1) / rebuildBatchSize
+			content.WriteString(fmt.Sprintf("%s of %d foreign packages selected for rebuild (%d batch(es) of up to %d):\n\n",
+				countStyle.Render(fmt.Sprintf("%d", selected)), len(packages), batches, rebuildBatchSize))
+		} else if m.confirmType == confirmUpdate {
+			content.WriteString(m.renderArchNews(dialogWidth-4, scrollHintStyle))
+			upgrade, ignore := m.updateSelection()
+			content.WriteString(fmt.Sprintf("Upgrading %s, ignoring %d:\n\n",
+				countStyle.Render(fmt.Sprintf("%d", len(upgrade))), len(ignore)))
+		} else if txRows != nil {
+			content.WriteString(fmt.Sprintf("The transaction will %s %s package(s):\n\n",
+				actionDesc, countStyle.Render(fmt.Sprintf("%d", len(m.txPreview.entries)))))
+		} else if len(packages) == 1 {
+			content.WriteString(fmt.Sprintf("The following package will be %sd:\n\n", actionDesc))
 		} else {
-			m.installed = msg.packages
-			
-			// Update installedSet for quick lookup (used by install view)
-			m.installedSet = make(map[string]bool)
-			for _, pkg := range m.installed {
-				m.installedSet[pkg.Name] = true
-			}
-			
-			// Also update the Installed flag on repo packages for install view
-			for i := range m.repoPackages {
-				m.repoPackages[i].Installed = m.installedSet[m.repoPackages[i].Name]
+			content.WriteString(fmt.Sprintf("The following %s packages will be %sd:\n\n", 
+				countStyle.Render(fmt.Sprintf("%d", len(packages))), actionDesc))
+		}
+		
+		// Package list with scrolling; updates are laid out in sections
+		var updateRows []updateRow
+		rowCount := len(packages)
+		if m.confirmType == confirmUpdate {
+			updateRows = m.updateDialogRows()
+			rowCount = len(updateRows)
+		} else if txRows != nil {
+			rowCount = len(txRows)
+		}
+		maxVisible := 10
+		startIdx := m.confirmScrollOffset
+		endIdx := startIdx + maxVisible
+		if endIdx > rowCount {
+			endIdx = rowCount
+		}
+		// Orphan sizes line up after the longest name
+		orphanNameWidth := 0
+		if m.confirmType == confirmRemoveOrphans {
+			for _, pkg := range packages {
+				orphanNameWidth = max(orphanNameWidth, len(pkg.Name))
 			}
-			// Update filtered list as well
-			for i := range m.filtered {
-				m.filtered[i].Installed = m.installedSet[m.filtered[i].Name]
+			orphanNameWidth = min(orphanNameWidth, dialogWidth-24)
+		}
+		
+		// Show scroll indicator at top if needed
+		if startIdx > 0 {
+			content.WriteString(scrollHintStyle.Render(fmt.Sprintf("  ↑ %d more above\n", startIdx)))
+		}
+		
+		// List packages
+		for i := startIdx; i < endIdx; i++ {
+			if m.confirmType == confirmUpdate {
+				content.WriteString(m.renderUpdateRow(updateRows[i], i == m.confirmCursor, keyStyle, packageNameStyle, packageVersionStyle, scrollHintStyle, sourceStyle))
+				continue
 			}
-			
-			// Check if there's a pre-set filter (from dashboard shortcuts)
-			query := m.textInput.Value()
-			if query != "" {
-				// Apply the filter
-				sourceFilters, searchQuery := parseUninstallFilter(query)
-				hasSourceFilter := len(sourceFilters) > 0
-				
-				basePackages := m.installed
-				if hasSourceFilter {
-					var filtered []Package
-					for _, pkg := range basePackages {
-						if sourceFilters["total"] {
-							filtered = append(filtered, pkg)
-						} else {
-							if sourceFilters["explicit"] && pkg.Explicit {
-								filtered = append(filtered, pkg)
-							}
-							if sourceFilters["foreign"] && pkg.Source == "aur" {
-								filtered = append(filtered, pkg)
-							}
-							if sourceFilters["orphan"] && pkg.Orphan {
-								filtered = append(filtered, pkg)
-							}
-						}
-					}
-					basePackages = filtered
-				}
-				
-				if searchQuery != "" {
-					m.filteredInstalled = fuzzyFilter(basePackages, searchQuery)
-					m.installedMatchIndices = computeAllMatchIndices(m.filteredInstalled, searchQuery)
-				} else {
-					m.filteredInstalled = basePackages
-					m.installedMatchIndices = nil
+			if txRows != nil {
+				row := txRows[i]
+				if row.header != "" {
+					content.WriteString(scrollHintStyle.Bold(true).Render(row.header) + "\n")
+					continue
 				}
-				
-				// Reset selection to top
-				m.selectedIndex = 0
-				
-				if hasSourceFilter {
-					status := fmt.Sprintf("Found %d %s packages", len(m.filteredInstalled), formatUninstallFilters(sourceFilters))
-					if m.lastCompletedOp != "" {
-						status = m.lastCompletedOp + " | " + status
-					}
-					m.statusMessage = status
-				} else {
-					status := fmt.Sprintf("%d packages - Press [/] to filter", len(m.filteredInstalled))
-					if m.lastCompletedOp != "" {
-						status = m.lastCompletedOp + " | " + status
-					}
-					m.statusMessage = status
+				name := packageNameStyle.Render(row.entry.name)
+				if row.entry.repo != "" {
+					name = sourceStyle(row.entry.repo).Render(row.entry.repo) + "/" + name
 				}
-			} else {
-				m.filteredInstalled = m.installed
-				status := fmt.Sprintf("%d packages - Press [/] to filter", len(m.installed))
-				if m.lastCompletedOp != "" {
-					status = m.lastCompletedOp + " | " + status
+				line := fmt.Sprintf("  • %s %s", name, packageVersionStyle.Render(row.entry.version))
+				if row.entry.explicit && !row.entry.requested {
+					line += lipgloss.NewStyle().Foreground(currentTheme.ErrorColor).Render("  ⚠ explicitly installed")
 				}
-				m.statusMessage = status
+				content.WriteString(line + "\n")
+				continue
 			}
-			
-			if len(m.filteredInstalled) > 0 {
-				m.loadingInfo = true
-				m.infoForPackage = m.filteredInstalled[0].Name
-				return m, getPackageInfo(m.filteredInstalled[0])
+			pkg := packages[i]
+			if m.confirmType == confirmRebuildForeign {
+				// Show a toggle and version for each foreign package
+				checkbox := "[x]"
+				if m.confirmExcluded[pkg.Name] {
+					checkbox = "[ ]"
+				}
+				cursor := "  "
+				if i == m.confirmCursor {
+					cursor = keyStyle.Render("> ")
+				}
+				content.WriteString(fmt.Sprintf("%s%s %s %s\n",
+					cursor,
+					checkbox,
+					packageNameStyle.Render(pkg.Name),
+					packageVersionStyle.Render(pkg.Version)))
+			} else if m.confirmType == confirmRemoveOrphans && m.orphanSizes != nil {
+				// Orphans show their installed size
+				content.WriteString(fmt.Sprintf("  • %s %s\n",
+					packageNameStyle.Render(fmt.Sprintf("%-*s", orphanNameWidth, pkg.Name)),
+					packageVersionStyle.Render(fmt.Sprintf("%10s", formatBytes(m.orphanSizes[pkg.Name])))))
+			} else {
+				// Just show package name for install/uninstall
+				content.WriteString(fmt.Sprintf("  • %s\n", packageNameStyle.Render(pkg.Name)))
 			}
 		}
-
-	case dashboardMsg:
-		m.loading = false
-		if msg.err != nil {
-			m.statusMessage = fmt.Sprintf("Error loading dashboard: %v", msg.err)
-		} else {
-			m.dashboard = msg.data
-			// Preserve lastCompletedOp message if set, otherwise show default
-			if m.lastCompletedOp != "" {
-				m.statusMessage = m.lastCompletedOp
+		
+		// Show scroll indicator at bottom if needed
+		remaining := rowCount - endIdx
+		if remaining > 0 {
+			content.WriteString(scrollHintStyle.Render(fmt.Sprintf("  ↓ %d more below\n", remaining)))
+		}
+
+		// The space the orphan removal frees
+		if m.confirmType == confirmRemoveOrphans {
+			content.WriteString("\n")
+			if m.orphanSizes == nil {
+				content.WriteString(scrollHintStyle.Render("  Measuring installed sizes..."))
 			} else {
-				m.statusMessage = "Dashboard loaded"
+				var total int64
+				for _, name := range m.confirmPackages {
+					total += m.orphanSizes[name]
+				}
+				content.WriteString(fmt.Sprintf("  Frees %s", countStyle.Render(formatBytes(total))))
 			}
+			content.WriteString("\n")
 		}
-
-	case actionCompleteMsg:
-		m.loading = false
-		if msg.err != nil {
-			m.statusMessage = msg.message
-		} else {
-			m.statusMessage = msg.message
-			// Refresh the list
-			if m.mode == modeInstall {
-				// Reload packages to update installed status
-				return m, loadRepoPackages()
-			} else if m.mode == modeUninstall {
-				return m, getInstalledPackages()
+		
+		// Transaction preview status for installs and removals
+		if m.confirmType == confirmInstall || m.confirmType == confirmUninstall {
+			switch {
+			case m.txPreview.loading:
+				content.WriteString("\n")
+				content.WriteString(scrollHintStyle.Render("  Resolving the full transaction..."))
+				content.WriteString("\n")
+			case m.txPreview.err != nil && len(m.txPreview.dependents) == 0:
+				// A removal pacman refuses because of dependents is explained below
+				content.WriteString("\n")
+				content.WriteString(lipgloss.NewStyle().Foreground(currentTheme.WarningColor).Render(
+					fmt.Sprintf("  ⚠ Transaction preview unavailable: %v", m.txPreview.err)))
+				content.WriteString("\n")
+			}
+			if m.confirmType == confirmUninstall {
+				content.WriteString(m.renderRemovalDependents(dialogWidth))
+			}
+			if extra := m.unexpectedExplicitRemovals(); len(extra) > 0 {
+				content.WriteString("\n")
+				content.WriteString(lipgloss.NewStyle().Foreground(currentTheme.ErrorColor).Bold(true).Render(
+					fmt.Sprintf("  ⚠ Also removes %d explicitly installed package(s): %s", len(extra), strings.Join(extra, " "))))
+				content.WriteString("\n")
 			}
 		}
 
-	case cleanCacheMsg:
-		m.loading = false
-		if msg.err != nil {
-			m.statusMessage = fmt.Sprintf("Cache clean failed: %v", msg.err)
-		} else {
-			m.statusMessage = "Cache cleaned successfully!"
-			// Refresh dashboard to show updated cache size
-			return m, getDashboardData()
+		// Leaving repo packages behind risks a partial upgrade
+		if m.confirmType == confirmUpdate && m.skipsRepoUpdates() {
+			content.WriteString("\n")
+			content.WriteString(lipgloss.NewStyle().Foreground(currentTheme.WarningColor).Render(
+				"  ⚠ Skipping repo packages is a partial upgrade: packages that depend on them may break"))
+			content.WriteString("\n")
 		}
 
-	case removeOrphansMsg:
-		m.loading = false
-		if msg.err != nil {
-			m.statusMessage = fmt.Sprintf("Orphan removal failed: %v", msg.err)
-		} else {
-			m.statusMessage = "Orphans removed successfully!"
-			// Refresh dashboard to show updated orphan count
-			return m, getDashboardData()
+		// AUR dependency check status for updates
+		if m.confirmType == confirmUpdate {
+			switch {
+			case m.aurDepChecking:
+				content.WriteString("\n")
+				content.WriteString(scrollHintStyle.Render("  Checking AUR dependencies..."))
+				content.WriteString("\n")
+			case m.aurDepCheckErr != nil:
+				content.WriteString("\n")
+				content.WriteString(scrollHintStyle.Render("  AUR dependency check unavailable"))
+				content.WriteString("\n")
+			case len(m.aurDepIssues) > 0:
+				content.WriteString("\n")
+				content.WriteString(lipgloss.NewStyle().Foreground(currentTheme.WarningColor).Render(
+					fmt.Sprintf("  ⚠ %d AUR update(s) are flagged out-of-date, orphaned, or depend on out-of-date or missing AUR packages", len(m.aurDepIssues))))
+				content.WriteString("\n")
+			}
 		}
 
-	case updateOutputMsg:
-		m.loading = false
-		m.updateOutput = msg.output
-		if msg.err != nil {
-			m.statusMessage = "Update failed"
-		} else {
-			m.statusMessage = "Update complete!"
+		// Notes for expanded package sets
+		if len(m.confirmSkipped) > 0 {
+			content.WriteString("\n")
+			content.WriteString(scrollHintStyle.Render(fmt.Sprintf("  Skipped (already installed): %s", strings.Join(m.confirmSkipped, " "))))
+			content.WriteString("\n")
 		}
-
-	case updateCheckMsg:
-		m.loading = false
-		if msg.err != nil {
-			m.statusMessage = fmt.Sprintf("Error checking updates: %v", msg.err)
-		} else if len(msg.packages) == 0 {
-			m.statusMessage = "System is up to date!"
-			m.updateOutput = "No updates available."
-		} else {
-			// Show confirmation dialog with available updates
-			m.pendingUpdates = msg.packages
-			m.showConfirmation = true
-			m.confirmType = confirmUpdate
-			m.confirmScrollOffset = 0
-			m.statusMessage = fmt.Sprintf("%d update(s) available", len(msg.packages))
+		if len(m.confirmUnknown) > 0 {
+			content.WriteString("\n")
+			content.WriteString(lipgloss.NewStyle().Foreground(currentTheme.WarningColor).Render(
+				fmt.Sprintf("  ? Not in sync databases (AUR or typo): %s", strings.Join(m.confirmUnknown, " "))))
+			content.WriteString("\n")
 		}
 
-	case execCompleteMsg:
-		m.loading = false
-		m.confirmPackages = nil
-		m.pendingUpdates = nil
-		
-		// Check if operation failed and show error overlay
-		if msg.err != nil {
-			opName := ""
-			switch msg.operation {
-			case confirmInstall:
-				opName = "Installation"
-			case confirmUninstall:
-				opName = "Removal"
-			case confirmUpdate:
-				opName = "System Update"
-			case confirmCleanCache:
-				opName = "Cache Cleaning"
-			case confirmRemoveOrphans:
-				opName = "Orphan Removal"
-			}
-			
-			m.showErrorOverlay = true
-			m.errorTitle = fmt.Sprintf("%s Failed", opName)
-			m.errorMessage = "The operation exited with a non-zero exit code."
-			
-			// Get error details
-			if exitErr, ok := msg.err.(*exec.ExitError); ok {
-				m.errorDetails = fmt.Sprintf("Exit code: %d\n\nThe error output was displayed in the terminal.\nPlease check the terminal output for details.", exitErr.ExitCode())
-			} else {
-				m.errorDetails = fmt.Sprintf("Error: %v\n\nThe error output was displayed in the terminal.\nPlease check the terminal output for details.", msg.err)
+		// Prerequisites the install set needs but doesn't pull in
+		if advice := m.installAdvice(); len(advice) > 0 {
+			adviceStyle := lipgloss.NewStyle().Foreground(currentTheme.WarningColor)
+			addable := false
+			content.WriteString("\n")
+			for _, a := range advice {
+				missing := fmt.Sprintf("%s is not installed", a.rule.prerequisite)
+				if a.rule.repository {
+					missing = fmt.Sprintf("[%s] is not enabled in %s", a.rule.prerequisite, pacmanConfPath)
+				} else {
+					addable = true
+				}
+				content.WriteString(adviceStyle.Render(fmt.Sprintf("  💡 %s: %s (%s)", missing, a.rule.reason, strings.Join(a.packages, " "))))
+				content.WriteString("\n")
 			}
-			
-			m.statusMessage = fmt.Sprintf("%s failed", opName)
-			m.lastCompletedOp = ""
-			
-			// Still refresh the appropriate data
-			switch msg.operation {
-			case confirmInstall:
-				return m, loadRepoPackages()
-			case confirmUninstall:
-				return m, getInstalledPackages()
-			case confirmUpdate:
-				return m, loadRepoPackages()
-			case confirmCleanCache, confirmRemoveOrphans:
-				return m, getDashboardData()
+			if addable {
+				content.WriteString(scrollHintStyle.Render("  [a] add missing prerequisites"))
+				content.WriteString("\n")
 			}
-			return m, nil
 		}
-		
-		// Operation succeeded
-		switch msg.operation {
-		case confirmInstall:
-			if len(msg.packages) == 1 {
-				m.lastCompletedOp = fmt.Sprintf("Installed: %s", msg.packages[0])
-			} else {
-				m.lastCompletedOp = fmt.Sprintf("Installed %d packages", len(msg.packages))
-			}
-			m.statusMessage = m.lastCompletedOp
-			return m, loadRepoPackages()
-		case confirmUninstall:
-			if len(msg.packages) == 1 {
-				m.lastCompletedOp = fmt.Sprintf("Removed: %s", msg.packages[0])
-			} else {
-				m.lastCompletedOp = fmt.Sprintf("Removed %d packages", len(msg.packages))
+
+		// Toggle hint for dialogs with per-row selection
+		if m.confirmType == confirmRebuildForeign {
+			content.WriteString("\n")
+			content.WriteString(scrollHintStyle.Render("  [↑/↓] move  [tab/space] toggle"))
+		} else if m.confirmType == confirmUpdate {
+			content.WriteString("\n")
+			hint := "  [↑/↓] move  [h] hold/release  [p] pin/unpin  [tab/space] skip this run / expand section"
+			if len(m.news) > archNewsVisible {
+				hint += "  [[/]] scroll news"
 			}
-			m.statusMessage = m.lastCompletedOp
-			return m, getInstalledPackages()
-		case confirmUpdate:
-			m.lastCompletedOp = "System update completed"
-			m.statusMessage = m.lastCompletedOp
-			return m, loadRepoPackages()
-		case confirmCleanCache:
-			m.lastCompletedOp = "Cache cleaned successfully"
-			m.statusMessage = m.lastCompletedOp
-			return m, getDashboardData()
-		case confirmRemoveOrphans:
-			if len(msg.packages) == 1 {
-				m.lastCompletedOp = fmt.Sprintf("Removed orphan: %s", msg.packages[0])
-			} else {
-				m.lastCompletedOp = fmt.Sprintf("Removed %d orphan packages", len(msg.packages))
+			content.WriteString(scrollHintStyle.Render(hint))
+		} else if len(packages) > maxVisible {
+			// Scroll hint if list is scrollable
+			content.WriteString("\n")
+			content.WriteString(scrollHintStyle.Render("  Use [↑/↓] or [j/k] to scroll"))
+		}
+	}
+	
+	// Prompt - build as single line to prevent wrapping issues
+	content.WriteString("\n\n")
+	promptLine := fmt.Sprintf("Proceed? %ses  %so",
+		keyStyle.Render("[y]"),
+		keyStyle.Render("[n]"))
+	if m.confirmType == confirmUpdate && m.newsNeedsAcknowledgement() {
+		promptLine = fmt.Sprintf("Acknowledge the manual intervention news first: %s  %s cancel",
+			keyStyle.Render("[r]"),
+			keyStyle.Render("[n]"))
+	}
+	if m.removalNeedsTypedConfirm() {
+		promptLine = fmt.Sprintf("Type yes to remove anyway: %s  %s  %s cancel",
+			keyStyle.Render("> "+m.confirmTyped+"_"),
+			keyStyle.Render("[enter]"),
+			keyStyle.Render("[n]"))
+	}
+	if m.confirmType == confirmCleanCache {
+		hint := "[←/→] strategy"
+		if m.cacheClean.strategy == cleanKeepVersions {
+			hint += "  [+/-] versions"
+		}
+		if helper.aur {
+			hint += "  [a] " + helper.name + " cache"
+		}
+		promptLine += "  " + scrollHintStyle.Render(hint)
+	}
+	if m.confirmType == confirmUninstall {
+		// Removal mode row, cycled with left/right
+		for _, mode := range removalModes {
+			if mode.flags == m.uninstallFlags() {
+				content.WriteString(fmt.Sprintf("Removal mode: %s %s %s  %s\n",
+					keyStyle.Render("‹"), countStyle.Render(mode.flags), keyStyle.Render("›"),
+					scrollHintStyle.Render(mode.description)))
 			}
-			m.statusMessage = m.lastCompletedOp
-			return m, getDashboardData()
 		}
+		cascade := "[←/→] mode  [c] cascade remove (-Rc)"
+		if m.uninstallFlags() == "-Rc" {
+			cascade = "[←/→] mode  [c] back to -Rns"
+		}
+		promptLine += "  " + scrollHintStyle.Render(cascade)
+	}
+	content.WriteString(promptStyle.Render(promptLine))
+	
+	// Render dialog box
+	dialogContent := content.String()
+	dialog := dialogBorderStyle.Width(dialogWidth).Render(dialogContent)
+	
+	// Center the dialog on screen
+	dialogHeight := strings.Count(dialog, "\n") + 1
+	
+	// Calculate vertical and horizontal padding
+	vertPadding := (contentHeight - dialogHeight) / 2
+	if vertPadding < 0 {
+		vertPadding = 0
+	}
+	horizPadding := (contentWidth - lipgloss.Width(dialog)) / 2
+	if horizPadding < 0 {
+		horizPadding = 0
+	}
+	
+	// Build final output with centering
+	var output strings.Builder
+	
+	// Add top padding
+	for i := 0; i < vertPadding; i++ {
+		output.WriteString("\n")
+	}
+	
+	// Add dialog with horizontal padding
+	for _, line := range strings.Split(dialog, "\n") {
+		output.WriteString(strings.Repeat(" ", horizPadding))
+		output.WriteString(line)
+		output.WriteString("\n")
 	}
+	
+	return output.String()
+}
 
-	return m, tea.Batch(cmds...)
+// errorOverlayWidth is the width of the error overlay's dialog
+func errorOverlayWidth(contentWidth int) int {
+	return min(max(contentWidth-20, 50), 80)
 }
 
-// renderHelpText creates the help menu with the active mode highlighted
-func (m model) renderHelpText(activeColor lipgloss.Color) string {
-	dimStyle := helpStyle
-	activeStyle := lipgloss.NewStyle().
-		Foreground(activeColor).
-		Bold(true)
+// errorOverlayHead renders the error overlay's title, message and details
+func (m model) errorOverlayHead(dialogWidth int) string {
+	titleStyle := lipgloss.NewStyle().
+		Bold(true).
+		Foreground(currentTheme.ErrorColor).
+		Width(dialogWidth - 4).
+		Align(lipgloss.Center)
+	messageStyle := lipgloss.NewStyle().
+		Foreground(currentTheme.TextColor).
+		Width(dialogWidth - 4).
+		Align(lipgloss.Center)
+	detailsStyle := lipgloss.NewStyle().
+		Foreground(currentTheme.DashboardDesc).
+		Width(dialogWidth - 4).
+		Padding(1, 0)
 
-	var parts []string
+	var head strings.Builder
+	head.WriteString(titleStyle.Render("⚠  " + m.errorTitle + "  ⚠"))
+	head.WriteString("\n\n")
+	head.WriteString(messageStyle.Render(m.errorMessage))
+	head.WriteString("\n")
+	if m.errorDetails != "" {
+		head.WriteString(detailsStyle.Render(m.errorDetails))
+		head.WriteString("\n")
+	}
+	return head.String()
+}
 
-	// Common items (always dim)
-	parts = append(parts, dimStyle.Render("[/] search  [tab] mark  "))
+// errorOutputVisible is how many lines of the failed command's output fit
+// in the error overlay below its head
+func (m model) errorOutputVisible(dialogWidth, contentHeight int) int {
+	visible := contentHeight - lipgloss.Height(m.errorOverlayHead(dialogWidth)) - 8
+	return min(max(visible, 3), errorOutputLines)
+}
 
-	// [i]nstall
-	if m.mode == modeInstall {
-		parts = append(parts, activeStyle.Render("[i]nstall"))
+// renderErrorOverlay renders a centered error overlay dialog
+func (m model) renderErrorOverlay(contentWidth, contentHeight int) string {
+	errorColor := currentTheme.ErrorColor
+	dialogWidth := errorOverlayWidth(contentWidth)
+	
+	// Styles
+	hintStyle := lipgloss.NewStyle().
+		Foreground(currentTheme.SubtleColor).
+		Width(dialogWidth - 4).
+		Align(lipgloss.Center)
+	
+	dialogBorderStyle := lipgloss.NewStyle().
+		Border(lipgloss.RoundedBorder()).
+		BorderForeground(errorColor).
+		Padding(1, 2)
+	
+	// Build content
+	var content strings.Builder
+	content.WriteString(m.errorOverlayHead(dialogWidth))
+
+	// The end of the failed command's output, in what space is left
+	hint := "Press [esc], [enter], or [q] to dismiss"
+	if len(m.errorOutput) > 0 {
+		visible := m.errorOutputVisible(dialogWidth, contentHeight)
+		end := m.errorOutputEnd
+		if end < visible {
+			end = visible
+		}
+		if end > len(m.errorOutput) {
+			end = len(m.errorOutput)
+		}
+		start := end - visible
+		if start < 0 {
+			start = 0
+		}
+		outputStyle := lipgloss.NewStyle().
+			Foreground(currentTheme.TextColor)
+		label := "Output:"
+		if len(m.errorOutput) > 1 {
+			label = fmt.Sprintf("Output (last %d lines):", len(m.errorOutput))
+		}
+		if start > 0 || end < len(m.errorOutput) {
+			label = fmt.Sprintf("Output, last %d lines (showing %d-%d):", len(m.errorOutput), start+1, end)
+			hint = "[j/k] scroll  " + hint
+		}
+		content.WriteString(hintStyle.Align(lipgloss.Left).Render(label))
+		content.WriteString("\n")
+		for _, line := range m.errorOutput[start:end] {
+			content.WriteString(outputStyle.Render(truncateRunes(line, dialogWidth-6)))
+			content.WriteString("\n")
+		}
+		content.WriteString("\n")
+	}
+
+	// Dismiss hint, offering a retry after a failed operation; it is dimmed
+	// when the operation can't be run again
+	if m.retryOffered {
+		retryStyle := lipgloss.NewStyle().
+			Foreground(errorColor).
+			Bold(true)
+		if m.retry == nil {
+			retryStyle = lipgloss.NewStyle().
+				Foreground(currentTheme.SubtleColor).
+				Faint(true)
+		}
+		hint = retryStyle.Render("[r] retry") + "  " + lipgloss.NewStyle().Foreground(currentTheme.SubtleColor).Render(hint)
+		content.WriteString(lipgloss.PlaceHorizontal(dialogWidth-4, lipgloss.Center, hint))
 	} else {
-		parts = append(parts, dimStyle.Render("[i]nstall"))
+		content.WriteString(hintStyle.Render(hint))
+	}
+	
+	// Render dialog box
+	dialogContent := content.String()
+	dialog := dialogBorderStyle.Width(dialogWidth).Render(dialogContent)
+	
+	// Center the dialog on screen
+	dialogHeight := strings.Count(dialog, "\n") + 1
+	
+	// Calculate vertical and horizontal padding
+	vertPadding := (contentHeight - dialogHeight) / 2
+	if vertPadding < 0 {
+		vertPadding = 0
+	}
+	horizPadding := (contentWidth - lipgloss.Width(dialog)) / 2
+	if horizPadding < 0 {
+		horizPadding = 0
+	}
+	
+	// Build final output with centering
+	var output strings.Builder
+	
+	// Add top padding
+	for i := 0; i < vertPadding; i++ {
+		output.WriteString("\n")
+	}
+	
+	// Add dialog with horizontal padding
+	for _, line := range strings.Split(dialog, "\n") {
+		output.WriteString(strings.Repeat(" ", horizPadding))
+		output.WriteString(line)
+		output.WriteString("\n")
+	}
+	
+	return output.String()
+}
+
+func (m model) renderDashboard(helpText string, contentWidth, contentHeight int) string {
+	// Get mode-specific border style
+	activeColor := modeColors[m.mode]
+	if activeColor == "" {
+		activeColor = defaultBorderColor
+	}
+	borderStyle := baseBorderStyle.BorderForeground(activeColor)
+
+	// Footer with help text aligned to the right
+	helpWidth := lipgloss.Width(helpText)
+	padding := contentWidth - helpWidth
+	if padding < 0 {
+		padding = 0
 	}
-	parts = append(parts, dimStyle.Render("  "))
+	footerLine := strings.Repeat(" ", padding) + helpText
 
-	// i[n]fo
-	if m.mode == modeInstalled {
-		parts = append(parts, activeStyle.Render("i[n]fo"))
-	} else {
-		parts = append(parts, dimStyle.Render("i[n]fo"))
+	if m.loading {
+		loadingBox := borderStyle.
+			Width(contentWidth).
+			Height(contentHeight - 1).
+			Render(lipgloss.Place(contentWidth-2, contentHeight-3, lipgloss.Center, lipgloss.Center, m.busyText("Loading system statistics...")))
+		return lipgloss.JoinVertical(lipgloss.Left, loadingBox, footerLine)
 	}
-	parts = append(parts, dimStyle.Render("  "))
 
-	// [r]emove
-	if m.mode == modeUninstall {
-		parts = append(parts, activeStyle.Render("[r]emove"))
-	} else {
-		parts = append(parts, dimStyle.Render("[r]emove"))
-	}
-	parts = append(parts, dimStyle.Render("  "))
+	var dashboard strings.Builder
 
-	// [u]pdate
-	if m.mode == modeUpdate {
-		parts = append(parts, activeStyle.Render("[u]pdate"))
-	} else {
-		parts = append(parts, dimStyle.Render("[u]pdate"))
-	}
-	parts = append(parts, dimStyle.Render("  "))
+	// Color definitions, from the current theme
+	goodColor := currentTheme.SuccessColor
+	badColor := currentTheme.DashboardWarning
+	accentColor := currentTheme.HighlightColor
+	warnColor := currentTheme.WarningColor
+	valueColor := currentTheme.DashboardValue
+	dimColor := currentTheme.SubtleColor
 
-	// [q]uit (always dim)
-	parts = append(parts, dimStyle.Render("[q]uit"))
+	// Box styles
+	boxTitleStyle := lipgloss.NewStyle().
+		Bold(true).
+		Foreground(currentTheme.TitleColor)
 
-	return strings.Join(parts, "")
-}
+	// Shortcut hint style
+	shortcutStyle := lipgloss.NewStyle().Foreground(dimColor)
 
-func (m model) View() string {
-	if m.width == 0 {
-		return "Loading..."
+	// The row under the cursor replaces its leading space with a marker
+	selected, _ := m.selectedDashboardItem()
+	cursorLine := func(item dashboardItem, line string) string {
+		if item != selected {
+			return line
+		}
+		return lipgloss.NewStyle().Foreground(activeColor).Bold(true).Render(">") + line[1:]
 	}
 
-	// Calculate dimensions
-	contentWidth := m.width - 4
-	contentHeight := m.height - 4
-
-	// Get mode-specific color
-	activeColor := modeColors[m.mode]
-	if activeColor == "" {
-		activeColor = defaultBorderColor
+	// ═══════════════════════════════════════════════════════
+	// GROUP 1: Package Counts (with shortcuts to filter in remove mode)
+	// ═══════════════════════════════════════════════════════
+	
+	// Build package counts content as simple lines
+	countsLines := []string{
+		cursorLine(dashboardItem{key: "t"}, fmt.Sprintf(" %s Total    │ %s",
+			shortcutStyle.Render("[t]"),
+			lipgloss.NewStyle().Bold(true).Foreground(valueColor).Render(fmt.Sprintf("%d", m.dashboard.TotalPackages)))),
+		cursorLine(dashboardItem{key: "e"}, fmt.Sprintf(" %s Explicit │ %s",
+			shortcutStyle.Render("[e]"),
+			lipgloss.NewStyle().Bold(true).Foreground(goodColor).Render(fmt.Sprintf("%d", m.dashboard.ExplicitlyInstalled)))),
 	}
 
-	// Create mode-specific styles
-	titleStyle := baseTitleStyle.Background(activeColor)
-	borderStyle := baseBorderStyle.BorderForeground(activeColor)
+	// Foreign line with optional rebuild hint
+	foreignLine := fmt.Sprintf(" %s Foreign  │ %s",
+		shortcutStyle.Render("[f]"),
+		lipgloss.NewStyle().Bold(true).Foreground(accentColor).Render(fmt.Sprintf("%d", m.dashboard.ForeignPackages)))
+	if m.dashboard.ForeignPackages > 0 {
+		foreignLine += shortcutStyle.Render(" [B]rebuild")
+	}
+	countsLines = append(countsLines, cursorLine(dashboardItem{key: "f"}, foreignLine))
 
-	// Build header with mode
-	modeText := ""
-	switch m.mode {
-	case modeInstall:
-		modeText = "INSTALL"
-	case modeInstalled:
-		modeText = "INFO"
-	case modeUninstall:
-		modeText = "UNINSTALL"
-	case modeUpdate:
-		modeText = "UPDATE"
+	// Third-party repositories, numbered for jumping to their packages
+	for i, repo := range m.dashboard.ThirdPartyRepos {
+		if i >= 9 {
+			break
+		}
+		line := fmt.Sprintf(" %s %-8s │ %s",
+			shortcutStyle.Render(fmt.Sprintf("[%d]", i+1)),
+			truncateRunes(repo.Name, 8),
+			lipgloss.NewStyle().Bold(true).Foreground(accentColor).Render(fmt.Sprintf("%d", repo.Count)))
+		if repo.Warning != "" {
+			line += " " + lipgloss.NewStyle().Foreground(badColor).Render("⚠ "+repo.Warning)
+		}
+		countsLines = append(countsLines, cursorLine(dashboardItem{key: fmt.Sprintf("%d", i+1)}, line))
+	}
+	if len(m.unmanaged) > 0 {
+		countsLines = append(countsLines, cursorLine(dashboardItem{key: "U"}, fmt.Sprintf("     ↳ %s %s",
+			lipgloss.NewStyle().Foreground(accentColor).Render(fmt.Sprintf("%d unmanaged by %s", len(m.unmanaged), helper.name)),
+			shortcutStyle.Render("[U]"))))
+	}
+	
+	// Orphan line with optional remove hint
+	orphanStyle := lipgloss.NewStyle().Bold(true).Foreground(goodColor)
+	if m.dashboard.Orphans > 0 {
+		orphanStyle = lipgloss.NewStyle().Bold(true).Foreground(badColor)
+	}
+	orphanLine := fmt.Sprintf(" %s Orphans  │ %s",
+		shortcutStyle.Render("[o]"),
+		orphanStyle.Render(fmt.Sprintf("%d", m.dashboard.Orphans)))
+	if m.dashboard.Orphans > 0 {
+		orphanLine += shortcutStyle.Render(" [R]rm")
+	}
+	countsLines = append(countsLines, cursorLine(dashboardItem{key: m.orphansAction()}, orphanLine))
+	if len(m.holds) > 0 {
+		countsLines = append(countsLines, cursorLine(dashboardItem{key: "H"}, fmt.Sprintf(" %s Held     │ %s",
+			shortcutStyle.Render("[H]"),
+			lipgloss.NewStyle().Bold(true).Foreground(warnColor).Render(fmt.Sprintf("%d", len(m.holds))))))
 	}
 
-	header := titleStyle.Render(" GAUR - " + modeText + " ")
+	// Pending updates, counted in the background once the dashboard loads
+	pending := shortcutStyle.Render("checking...")
+	if m.dashboardUpdatesKnown {
+		pendingStyle := lipgloss.NewStyle().Bold(true).Foreground(goodColor)
+		if m.dashboardUpdates > pendingUpdatesWarn {
+			pendingStyle = lipgloss.NewStyle().Bold(true).Foreground(warnColor)
+		}
+		pending = pendingStyle.Render(fmt.Sprintf("%d", m.dashboardUpdates))
+	}
+	if m.develUpdates {
+		pending += shortcutStyle.Render(" incl. devel")
+	}
+	countsLines = append(countsLines, cursorLine(dashboardItem{key: "u"}, fmt.Sprintf(" %s Updates  │ %s",
+		shortcutStyle.Render("[u]"), pending)))
+
+	// Security advisories from arch-audit, or a hint to install it
+	if m.auditChecked {
+		var audit string
+		switch findings := m.audit.findings; {
+		case !m.audit.installed:
+			audit = shortcutStyle.Render("not installed, [A] install")
+		case m.audit.err != nil:
+			audit = shortcutStyle.Render("arch-audit failed")
+		case len(findings) == 0:
+			audit = lipgloss.NewStyle().Bold(true).Foreground(goodColor).Render("no known CVEs")
+		default:
+			noun := "packages"
+			if len(findings) == 1 {
+				noun = "package"
+			}
+			// The first finding is the most severe
+			audit = lipgloss.NewStyle().Bold(true).Foreground(auditSeverityColor(findings[0].severity)).
+				Render(fmt.Sprintf("%d vulnerable %s", len(findings), noun))
+		}
+		countsLines = append(countsLines, cursorLine(dashboardItem{key: "A"}, fmt.Sprintf(" %s Audit    │ %s",
+			shortcutStyle.Render("[A]"), audit)))
+	}
 
-	// Help text for bottom right with active item highlighted
-	helpText := m.renderHelpText(activeColor)
+	// Post-update health: failed units and a replaced kernel
+	if m.health.systemd {
+		failedStyle := lipgloss.NewStyle().Bold(true).Foreground(goodColor)
+		if len(m.health.failedUnits) > 0 {
+			failedStyle = lipgloss.NewStyle().Bold(true).Foreground(badColor)
+		}
+		countsLines = append(countsLines, cursorLine(dashboardItem{key: "F"}, fmt.Sprintf(" %s Failed   │ %s",
+			shortcutStyle.Render("[F]"),
+			failedStyle.Render(fmt.Sprintf("%d", len(m.health.failedUnits))))))
+	}
+	if m.health.rebootRequired {
+		// The running kernel's modules are gone; show the version replacing it
+		installed := ""
+		if m.health.kernelVersion != "" {
+			installed = shortcutStyle.Render(" → " + truncateRunes(m.health.kernelVersion, 16))
+		} else if m.health.kernelPackage == "unknown" {
+			installed = shortcutStyle.Render(" (kernel package unknown)")
+		}
+		countsLines = append(countsLines, fmt.Sprintf("  ⚠  Reboot   │ %s%s",
+			lipgloss.NewStyle().Bold(true).Foreground(badColor).Render("required"), installed))
+	}
 
-	// Render confirmation dialog if active
-	if m.showConfirmation {
-		return m.renderConfirmationDialog(contentWidth, contentHeight, activeColor)
+	// ═══════════════════════════════════════════════════════
+	// GROUP 2: Storage Info
+	// ═══════════════════════════════════════════════════════
+	
+	// Cache coloring: warm colors if > 10 GiB
+	cacheStyle := lipgloss.NewStyle().Bold(true).Foreground(goodColor)
+	const tenGiB = 10 * 1024 * 1024 * 1024
+	if m.dashboard.CleanerSizeBytes > tenGiB {
+		cacheStyle = lipgloss.NewStyle().Bold(true).Foreground(warnColor)
+	}
+	if m.dashboard.CleanerSizeBytes > tenGiB*2 {
+		cacheStyle = lipgloss.NewStyle().Bold(true).Foreground(badColor)
+	}
+	// Whatever its size, a cache taking up much of the remaining space is a problem
+	if free := m.dashboard.CacheFreeBytes; free > 0 && float64(m.dashboard.CleanerSizeBytes) > float64(free)*cacheFreeWarnRatio {
+		cacheStyle = lipgloss.NewStyle().Bold(true).Foreground(badColor)
+	}
+	
+	// Missing from AUR style
+	missingStyle := lipgloss.NewStyle().Bold(true).Foreground(goodColor)
+	if m.dashboard.MissingFromAUR > 0 {
+		missingStyle = lipgloss.NewStyle().Bold(true).Foreground(badColor)
 	}
 
-	// Render error overlay if active
-	if m.showErrorOverlay {
-		return m.renderErrorOverlay(contentWidth, contentHeight)
+	storageLines := []string{
+		fmt.Sprintf("  System  │ %s",
+			lipgloss.NewStyle().Bold(true).Foreground(valueColor).Render(m.dashboard.TotalSize)),
+		cursorLine(dashboardItem{key: "c"}, fmt.Sprintf("  Cache   │ %s %s %s %s",
+			cacheStyle.Render(m.dashboard.CleanerSize),
+			shortcutStyle.Render("[c]lean"),
+			shortcutStyle.Render("[C]ontents"),
+			shortcutStyle.Render("[D]irs"))),
+	}
+	// One line per monitored cache directory
+	for _, dir := range m.dashboard.CacheDirSizes {
+		if !m.isCacheMonitored(dir.Label) {
+			continue
+		}
+		storageLines = append(storageLines, fmt.Sprintf("   %-6s │ %s",
+			truncateRunes(dir.Label, 6),
+			lipgloss.NewStyle().Foreground(valueColor).Render(formatBytes(dir.Bytes))))
+	}
+	storageLines = append(storageLines, cursorLine(dashboardItem{key: "M"}, fmt.Sprintf("  Missing │ %s %s",
+		missingStyle.Render(fmt.Sprintf("%d AUR", m.dashboard.MissingFromAUR)),
+		shortcutStyle.Render("[M]cleanup"))))
+	if !m.syncDBTime.IsZero() {
+		age, stale := m.syncDBAge()
+		syncStyle := lipgloss.NewStyle().Bold(true).Foreground(goodColor)
+		if stale {
+			syncStyle = lipgloss.NewStyle().Bold(true).Foreground(warnColor)
+		}
+		storageLines = append(storageLines, cursorLine(dashboardItem{key: "S"}, fmt.Sprintf("  Synced  │ %s %s",
+			syncStyle.Render(formatAge(age)+" ago"),
+			shortcutStyle.Render("[S]ync"))))
+	}
+	if !m.dashboard.LastUpgrade.IsZero() {
+		age := time.Since(m.dashboard.LastUpgrade)
+		upgradeStyle := lipgloss.NewStyle().Bold(true).Foreground(goodColor)
+		if age > upgradeWarnAge {
+			upgradeStyle = lipgloss.NewStyle().Bold(true).Foreground(warnColor)
+		}
+		storageLines = append(storageLines, fmt.Sprintf("  Updated │ %s",
+			upgradeStyle.Render(formatAge(age)+" ago")))
+	}
+	if m.pacnewChecked {
+		pacnewLine := "  Pacnew  │ " + lipgloss.NewStyle().Bold(true).Foreground(goodColor).Render("0")
+		if n := len(m.pacnewFiles); n > 0 {
+			pacnewLine = fmt.Sprintf("  Pacnew  │ %s %s",
+				lipgloss.NewStyle().Bold(true).Foreground(badColor).Render(fmt.Sprintf("⚠ %d to merge", n)),
+				shortcutStyle.Render("[P]"))
+		}
+		storageLines = append(storageLines, cursorLine(dashboardItem{key: "P"}, pacnewLine))
 	}
 
-	// Dashboard view
-	if m.mode == modeInstalled {
-		return m.renderDashboard(helpText, contentWidth, contentHeight)
+	// Pad both boxes to the same height unless they are stacked
+	compact := m.compact()
+	for len(storageLines) < len(countsLines) && !compact {
+		storageLines = append(storageLines, "")
+	}
+	for len(countsLines) < len(storageLines) && !compact {
+		countsLines = append(countsLines, "")
 	}
 
-	// Top half: Package info
-	infoHeight := contentHeight / 2
-	infoContent := ""
-	if m.mode == modeUpdate {
-		if m.updateOutput != "" {
-			infoContent = m.updateOutput
-		} else if m.loading {
-			infoContent = "Checking for updates..."
-		} else if len(m.pendingUpdates) > 0 {
-			infoContent = fmt.Sprintf("%d update(s) available. Press [enter] to review and update.", len(m.pendingUpdates))
-		} else {
-			infoContent = "System is up to date. Press [u] to check again."
+	// Render boxes manually with Unicode box drawing
+	borderColor := lipgloss.NewStyle().Foreground(activeColor)
+	
+	// Helper to render a box with title
+	renderBox := func(title string, lines []string, width int) string {
+		var b strings.Builder
+		
+		// Ensure minimum content width
+		innerWidth := width - 4 // Account for border chars and padding
+		if innerWidth < 20 {
+			innerWidth = 20
 		}
-	} else if m.loadingInfo {
-		infoContent = fmt.Sprintf("Loading details for %s...", m.infoForPackage)
-	} else if m.packageInfo != "" {
-		infoContent = m.packageInfo
-	} else {
-		infoContent = "Select a package to see details"
+		
+		// Top border with title
+		titleLen := lipgloss.Width(title)
+		topLeft := borderColor.Render("╭─")
+		topRight := borderColor.Render("─╮")
+		topPadding := innerWidth - titleLen
+		if topPadding < 0 {
+			topPadding = 0
+		}
+		b.WriteString(topLeft + title + borderColor.Render(strings.Repeat("─", topPadding)) + topRight + "\n")
+		
+		// Content lines
+		leftBorder := borderColor.Render("│ ")
+		rightBorder := borderColor.Render(" │")
+		for _, line := range lines {
+			// Pad line to fill width
+			lineWidth := lipgloss.Width(line)
+			padding := innerWidth - lineWidth
+			if padding < 0 {
+				padding = 0
+			}
+			b.WriteString(leftBorder + line + strings.Repeat(" ", padding) + rightBorder + "\n")
+		}
+		
+		// Bottom border
+		b.WriteString(borderColor.Render("╰" + strings.Repeat("─", innerWidth+2) + "╯"))
+		
+		return b.String()
 	}
 
-	// Wrap and truncate info
-	infoLines := strings.Split(infoContent, "\n")
-	if len(infoLines) > infoHeight-2 {
-		infoLines = infoLines[:infoHeight-2]
+	// Calculate box width
+	boxWidth := (contentWidth - 6) / 2
+	if boxWidth < 30 {
+		boxWidth = 30
+	}
+	if compact {
+		// Stacked boxes take the full panel width
+		boxWidth = contentWidth - 4
 	}
-	infoContent = strings.Join(infoLines, "\n")
 
-	infoBox := lipgloss.NewStyle().
-		Width(contentWidth-2).
-		Height(infoHeight-2).
-		Padding(0, 1).
-		Render(infoContent)
+	countsBox := renderBox(boxTitleStyle.Render(" 📦 Package Counts "), countsLines, boxWidth)
+	storageBox := renderBox(boxTitleStyle.Render(" 💾 Storage "), storageLines, boxWidth)
 
-	infoPanel := borderStyle.
-		Width(contentWidth).
-		Height(infoHeight).
-		Render(infoBox)
+	// Layout boxes side by side, or stacked in the compact layout
+	if compact {
+		dashboard.WriteString(countsBox + "\n" + storageBox + "\n")
+	}
+	countsBoxLines := strings.Split(countsBox, "\n")
+	storageBoxLines := strings.Split(storageBox, "\n")
+	
+	// Ensure same number of lines
+	maxLines := len(countsBoxLines)
+	if len(storageBoxLines) > maxLines {
+		maxLines = len(storageBoxLines)
+	}
+	for len(countsBoxLines) < maxLines {
+		countsBoxLines = append(countsBoxLines, strings.Repeat(" ", boxWidth))
+	}
+	for len(storageBoxLines) < maxLines {
+		storageBoxLines = append(storageBoxLines, strings.Repeat(" ", boxWidth))
+	}
+	
+	// Join boxes horizontally
+	for i := 0; i < maxLines && !compact; i++ {
+		dashboard.WriteString(countsBoxLines[i] + "  " + storageBoxLines[i] + "\n")
+	}
+	dashboard.WriteString("\n")
 
-	// Bottom half: Results + Input
-	bottomHeight := contentHeight - infoHeight - 1
-	resultsHeight := bottomHeight - 3
+	// ═══════════════════════════════════════════════════════
+	// Bar Layout Constants - ensures all bars align perfectly
+	// ═══════════════════════════════════════════════════════
+	const barLeftMargin = 2                    // Spaces before label
+	const barLabelWidth = 8                    // Fixed width for labels (e.g., "System", "Cache")
+	const barSeparator = "│"                   // Separator between label and bar
+	const barSuffixReserve = 30                // Reserve space for suffix text (e.g., "1234/5678 (100% explicit)")
+	barStartCol := barLeftMargin + barLabelWidth + len(barSeparator)
+	availableBarWidth := contentWidth - barStartCol - barSuffixReserve
+	minBarWidth := 20
+	if compact {
+		minBarWidth = 5
+	}
+	if availableBarWidth < minBarWidth {
+		availableBarWidth = minBarWidth
+	}
 
-	// Build results list
-	var results strings.Builder
-	var pkgList []Package
-	if m.mode == modeInstall {
-		pkgList = m.filtered
-	} else if m.mode == modeUninstall {
-		pkgList = m.filteredInstalled
+	// Helper to create aligned bar line
+	renderBarLine := func(label string, bar string, suffix string) string {
+		paddedLabel := fmt.Sprintf("%*s%-*s%s", barLeftMargin, "", barLabelWidth, label, barSeparator)
+		return paddedLabel + bar + " " + suffix
+	}
+
+	// ═══════════════════════════════════════════════════════
+	// Progress Bar: Explicit vs Dependency Ratio
+	// ═══════════════════════════════════════════════════════
+	dependencies := m.dashboard.TotalPackages - m.dashboard.ExplicitlyInstalled
+	explicitRatio := float64(m.dashboard.ExplicitlyInstalled) / float64(m.dashboard.TotalPackages)
+	if m.dashboard.TotalPackages == 0 {
+		explicitRatio = 0
+	}
+	
+	filledWidth := int(explicitRatio * float64(availableBarWidth))
+	if filledWidth > availableBarWidth {
+		filledWidth = availableBarWidth
 	}
+	
+	filledBar := lipgloss.NewStyle().Background(goodColor).
+		Render(strings.Repeat(" ", filledWidth))
+	emptyBar := lipgloss.NewStyle().Background(currentTheme.BorderColor).
+		Render(strings.Repeat(" ", availableBarWidth-filledWidth))
+	
+	ratioTitle := lipgloss.NewStyle().Bold(true).Foreground(currentTheme.TitleColor).
+		Render("📊 Explicit vs Dependencies")
+	ratioSuffix := fmt.Sprintf("%d/%d (%.0f%% explicit)", m.dashboard.ExplicitlyInstalled, dependencies, explicitRatio*100)
+	ratioBar := renderBarLine("", filledBar+emptyBar, ratioSuffix)
+	
+	dashboard.WriteString(ratioTitle + "\n")
+	dashboard.WriteString(ratioBar + "\n\n")
 
-	if m.loading {
-		results.WriteString("  Loading...")
-	} else if m.mode == modeUpdate {
-		results.WriteString("  " + m.statusMessage)
-	} else if len(pkgList) == 0 {
-		results.WriteString("  No packages to display")
-	} else {
-		// Show packages that fit, reversed so most relevant is at bottom (near input)
-		startIdx := 0
-		if m.selectedIndex >= resultsHeight {
-			startIdx = m.selectedIndex - resultsHeight + 1
+	// ═══════════════════════════════════════════════════════
+	// Bar Chart: System Size vs Cache Size
+	// ═══════════════════════════════════════════════════════
+	chartTitle := lipgloss.NewStyle().Bold(true).Foreground(currentTheme.TitleColor).
+		Render("📈 Size Comparison")
+	dashboard.WriteString(chartTitle + "\n")
+	
+	maxSize := m.dashboard.TotalSizeBytes
+	if m.dashboard.CleanerSizeBytes > maxSize {
+		maxSize = m.dashboard.CleanerSizeBytes
+	}
+	if maxSize == 0 {
+		maxSize = 1
+	}
+	
+	systemBarWidth := int(float64(m.dashboard.TotalSizeBytes) / float64(maxSize) * float64(availableBarWidth))
+	cacheBarWidth := int(float64(m.dashboard.CleanerSizeBytes) / float64(maxSize) * float64(availableBarWidth))
+	if systemBarWidth < 1 {
+		systemBarWidth = 1
+	}
+	if cacheBarWidth < 1 && m.dashboard.CleanerSizeBytes > 0 {
+		cacheBarWidth = 1
+	}
+	
+	systemBar := lipgloss.NewStyle().Background(valueColor).Render(strings.Repeat(" ", systemBarWidth))
+	cacheBar := lipgloss.NewStyle().Background(warnColor).Render(strings.Repeat(" ", cacheBarWidth))
+	
+	dashboard.WriteString(renderBarLine("System", systemBar, m.dashboard.TotalSize) + "\n")
+	dashboard.WriteString(renderBarLine("Cache", cacheBar, m.dashboard.CleanerSize) + "\n")
+
+	// Filesystem usage, on its own scale
+	for _, fs := range m.dashboard.Filesystems {
+		percent := fs.UsedPercent()
+		usedColor := goodColor
+		switch {
+		case percent >= diskCriticalPercent:
+			usedColor = badColor
+		case percent >= diskWarnPercent:
+			usedColor = warnColor
 		}
-		endIdx := startIdx + resultsHeight
-		if endIdx > len(pkgList) {
-			endIdx = len(pkgList)
+		usedWidth := int(percent / 100 * float64(availableBarWidth))
+		if usedWidth > availableBarWidth {
+			usedWidth = availableBarWidth
 		}
-
-		// Get the appropriate match indices map
-		var matchIndicesMap map[int][]int
-		if m.mode == modeInstall {
-			matchIndicesMap = m.matchIndices
-		} else if m.mode == modeUninstall {
-			matchIndicesMap = m.installedMatchIndices
+		usedBar := lipgloss.NewStyle().Background(usedColor).Render(strings.Repeat(" ", usedWidth))
+		freeBar := lipgloss.NewStyle().Background(currentTheme.BorderColor).Render(strings.Repeat(" ", availableBarWidth-usedWidth))
+		suffix := fmt.Sprintf("%s free (%.0f%% used)", formatBytes(fs.FreeBytes), percent)
+		if percent >= diskCriticalPercent {
+			suffix = lipgloss.NewStyle().Bold(true).Foreground(badColor).Render("⚠ " + suffix)
 		}
+		label := fs.Path
+		if label == "/" {
+			label = "Root"
+		}
+		dashboard.WriteString(renderBarLine(label, usedBar+freeBar, suffix) + "\n")
+	}
+	dashboard.WriteString("\n")
 
-		// Build lines in reverse order (most relevant at bottom, near input field)
-		var lines []string
-		for i := startIdx; i < endIdx; i++ {
-			pkg := pkgList[i]
-			// Show marker for marked packages
-			marker := " "
-			if m.markedPackages[pkg.Name] {
-				marker = "*"
-			}
-			prefix := " " + marker
-			if i == m.selectedIndex {
-				prefix = ">" + marker
+	// ═══════════════════════════════════════════════════════
+	// Top 10 Packages by Size
+	// ═══════════════════════════════════════════════════════
+	if top := m.topPackages(); len(top) > 0 {
+		topTitle := lipgloss.NewStyle().Bold(true).Foreground(currentTheme.TitleColor).
+			Render(fmt.Sprintf("🏆 Top %d Packages by Size", len(top)))
+		if selected.pkg != "" {
+			topTitle += shortcutStyle.Render("  [enter] info  [Q] remove")
+		}
+		dashboard.WriteString(topTitle + "\n")
+		
+		nameWidth := 30
+		if compact {
+			nameWidth = 20
+		}
+		// Show the window of the list that fits, following the cursor
+		room := contentHeight - 1 - strings.Count(dashboard.String(), "\n")
+		start := 0
+		if room > 0 && len(top) > room {
+			for i, pkg := range top {
+				if selected.pkg == pkg.Name && i >= room {
+					start = i - room + 1
+				}
 			}
-
-			// Color by source
-			sourceStyle := lipgloss.NewStyle()
+			top = top[start : start+room]
+		}
+		for i, pkg := range top {
+			rankStyle := lipgloss.NewStyle().Foreground(dimColor)
+			nameStyle := lipgloss.NewStyle().Foreground(valueColor)
+			sizeStyle := lipgloss.NewStyle().Foreground(accentColor)
+			sourceStyle := lipgloss.NewStyle().Foreground(dimColor)
 			if color, ok := sourceColors[pkg.Source]; ok {
 				sourceStyle = sourceStyle.Foreground(color)
 			}
-
-			// Apply highlighting with source colors
-			var displayPkgStr string
-			if matchIndicesMap != nil {
-				if indices, ok := matchIndicesMap[i]; ok {
-					// Use combined highlighting that preserves source colors
-					displayPkgStr = highlightMatchesWithSourceColor(pkg, indices)
-				} else {
-					displayPkgStr = sourceStyle.Render(pkg.Source) + "/" + pkg.Name
-				}
-			} else {
-				displayPkgStr = sourceStyle.Render(pkg.Source) + "/" + pkg.Name
-			}
-
-			line := fmt.Sprintf("%s%s %s",
-				prefix,
-				displayPkgStr,
-				lipgloss.NewStyle().Foreground(lipgloss.Color("241")).Render(pkg.Version),
-			)
-
-			if pkg.Installed && m.mode == modeInstall {
-				line += " " + installedBadge.Render("[installed]")
-			}
-
-			// Truncate if too long
-			if lipgloss.Width(line) > contentWidth-4 {
-				line = line[:contentWidth-7] + "..."
-			}
-
-			if i == m.selectedIndex {
-				line = selectedStyle.Render(line)
-			}
-
-			lines = append(lines, line)
-		}
-
-		// Reverse the lines so most relevant (index 0) is at bottom
-		for i := len(lines) - 1; i >= 0; i-- {
-			results.WriteString(lines[i])
-			if i > 0 {
-				results.WriteString("\n")
+			source := ""
+			if pkg.Source != "" && !compact {
+				source = " " + sourceStyle.Render(pkg.Source)
 			}
+			
+			dashboard.WriteString(cursorLine(dashboardItem{pkg: pkg.Name}, fmt.Sprintf("  %s %s %s%s",
+				rankStyle.Render(fmt.Sprintf("%2d.", start+i+1)),
+				nameStyle.Render(fmt.Sprintf("%-*s", nameWidth, truncateRunes(pkg.Name, nameWidth))),
+				sizeStyle.Render(fmt.Sprintf("%10s", pkg.Size)), source)) + "\n")
 		}
+		dashboard.WriteString("\n")
 	}
 
-	resultsBox := lipgloss.NewStyle().
-		Width(contentWidth - 2).
-		Height(resultsHeight).
-		Render(results.String())
-
-	// Input field
-	inputLine := ""
-	if m.mode == modeInstall || m.mode == modeUninstall {
-		inputLine = m.textInput.View()
-	} else {
-		inputLine = statusStyle.Render("System update in progress...")
-	}
-
-	// Status line
-	statusLine := statusStyle.Render(m.statusMessage)
+	// ═══════════════════════════════════════════════════════
+	// Interactive Actions Footer
+	// ═══════════════════════════════════════════════════════
+	// actionsStyle := lipgloss.NewStyle().Foreground(dimColor)
+	// var actions []string
+	// actions = append(actions, "[c] Clean cache")
+	// if m.dashboard.Orphans > 0 {
+	// 	actions = append(actions, lipgloss.NewStyle().Foreground(badColor).Render("[R] Remove orphans"))
+	// }
+	// actions = append(actions, "[esc] back")
+	// actions = append(actions, "[q] quit")
+	
+	// actionsText := actionsStyle.Render("  " + strings.Join(actions, " │ "))
+	// dashboard.WriteString(actionsText)
 
-	// Layout: results at top, input at bottom (fzf-style)
-	bottomContent := lipgloss.JoinVertical(
-		lipgloss.Left,
-		resultsBox,
-		"",
-		statusLine,
-		inputLine,
-	)
+	dashContent := lipgloss.NewStyle().
+		Width(contentWidth-2).
+		Height(contentHeight-3).
+		MaxHeight(contentHeight-1).
+		Padding(0, 1).
+		Render(dashboard.String())
 
-	bottomPanel := borderStyle.
+	dashPanel := borderStyle.
 		Width(contentWidth).
-		Height(bottomHeight).
-		Render(bottomContent)
-
-	// Footer with help text aligned to the right
-	helpWidth := lipgloss.Width(helpText)
-	padding := contentWidth - helpWidth
-	if padding < 0 {
-		padding = 0
-	}
-	footer := strings.Repeat(" ", padding) + helpText
-
-	// Combine all
-	content := lipgloss.JoinVertical(
-		lipgloss.Left,
-		header,
-		infoPanel,
-		bottomPanel,
-		footer,
-	)
+		Height(contentHeight - 1).
+		Render(dashContent)
 
-	// Overlay selections panel if there are marked packages
-	if len(m.markedPackages) > 0 {
-		content = m.overlaySelectionsPanel(content, contentWidth)
-	}
+	return lipgloss.JoinVertical(lipgloss.Left, dashPanel, footerLine)
+}
 
-	return content
+// dashboardItem is a dashboard row the cursor can select. Enter replays the
+// row's shortcut key, or for a Top 10 package opens it in remove mode.
+type dashboardItem struct {
+	key string
+	pkg string
 }
 
-// overlaySelectionsPanel renders a selection panel on the bottom right of the screen
-func (m model) overlaySelectionsPanel(content string, contentWidth int) string {
-	// Panel styling - brighter border when focused
-	borderColor := lipgloss.Color("205")
-	if m.selectionPanelFocused {
-		borderColor = lipgloss.Color("213")
+// dashboardItems lists the selectable dashboard rows in display order
+func (m model) dashboardItems() []dashboardItem {
+	items := []dashboardItem{{key: "t"}, {key: "e"}, {key: "f"}}
+	for i := range m.dashboard.ThirdPartyRepos {
+		if i >= 9 {
+			break
+		}
+		items = append(items, dashboardItem{key: fmt.Sprintf("%d", i+1)})
 	}
-	panelStyle := lipgloss.NewStyle().
-		Border(lipgloss.RoundedBorder()).
-		BorderForeground(borderColor).
-		Padding(0, 1)
-
-	titleStyle := lipgloss.NewStyle().
-		Bold(true).
-		Foreground(lipgloss.Color("205"))
-
-	itemStyle := lipgloss.NewStyle().
-		Foreground(lipgloss.Color("252"))
-
-	selectedItemStyle := lipgloss.NewStyle().
-		Foreground(lipgloss.Color("213")).
-		Bold(true)
-
-	keyHintStyle := lipgloss.NewStyle().
-		Foreground(lipgloss.Color("205")).
-		Bold(true)
-
-	// Build the selections list with * hint in title
-	var selectionsList strings.Builder
-	// Render the title (styled)
-	titleText := titleStyle.Render(fmt.Sprintf("Selected (%d) ", len(m.markedPackages))) + keyHintStyle.Render("[*]")
-	selectionsList.WriteString(titleText)
-
-	// Collect and sort package names for consistent display
-	var pkgNames []string
-	for name := range m.markedPackages {
-		pkgNames = append(pkgNames, name)
+	if len(m.unmanaged) > 0 {
+		items = append(items, dashboardItem{key: "U"})
 	}
-	sort.Strings(pkgNames)
-
-	// Determine panel width dynamically within bounds
-	maxDisplay := 20
-	minPanelWidth := 12
-	maxPanelWidth := 32
-
-	// Compute widest line among title, package names (up to maxDisplay), and the "... +N more" line
-	maxContentWidth := lipgloss.Width(titleText)
-	visibleCount := maxDisplay
-	if len(pkgNames) < visibleCount {
-		visibleCount = len(pkgNames)
+	items = append(items, dashboardItem{key: m.orphansAction()})
+	if len(m.holds) > 0 {
+		items = append(items, dashboardItem{key: "H"})
 	}
-	for i := 0; i < visibleCount; i++ {
-		// account for prefix ("  " or "> ")
-		nameWidth := lipgloss.Width(pkgNames[i]) + 2
-		if nameWidth > maxContentWidth {
-			maxContentWidth = nameWidth
-		}
+	items = append(items, dashboardItem{key: "u"})
+	if m.auditChecked {
+		items = append(items, dashboardItem{key: "A"})
 	}
-	if len(pkgNames) > maxDisplay {
-		moreStr := itemStyle.Render(fmt.Sprintf("... +%d more", len(pkgNames)-maxDisplay))
-		if w := lipgloss.Width(moreStr); w > maxContentWidth {
-			maxContentWidth = w
-		}
+	if m.health.systemd {
+		items = append(items, dashboardItem{key: "F"})
 	}
-
-	desiredPanelWidth := maxContentWidth + 4 // add room for borders and padding
-	if desiredPanelWidth < minPanelWidth {
-		desiredPanelWidth = minPanelWidth
+	items = append(items, dashboardItem{key: "c"}, dashboardItem{key: "M"})
+	if !m.syncDBTime.IsZero() {
+		items = append(items, dashboardItem{key: "S"})
 	}
-	if desiredPanelWidth > maxPanelWidth {
-		desiredPanelWidth = maxPanelWidth
+	if m.pacnewChecked {
+		items = append(items, dashboardItem{key: "P"})
 	}
-	panelWidth := desiredPanelWidth
-
-	// Build the lines, truncating names that exceed available space
-	for i, name := range pkgNames {
-		if i >= maxDisplay {
-			selectionsList.WriteString("\n")
-			selectionsList.WriteString(itemStyle.Render(fmt.Sprintf("... +%d more", len(pkgNames)-maxDisplay)))
-			break
-		}
-
-		// calculate maximum width available for the name itself
-		innerWidth := panelWidth - 4 // subtract borders and padding
-		nameMaxWidth := innerWidth - 2 // subtract prefix width
-		if nameMaxWidth < 1 {
-			nameMaxWidth = 1
-		}
-
-		displayName := name
-		if lipgloss.Width(displayName) > nameMaxWidth {
-			// Truncate to fit with ellipsis - preserve runes
-			runes := []rune(displayName)
-			truncWidth := nameMaxWidth - 3
-			if truncWidth < 1 {
-				truncWidth = 1
-			}
-			var truncated string
-			for j := 1; j <= len(runes); j++ {
-				s := string(runes[:j])
-				if lipgloss.Width(s) > truncWidth {
-					truncated = string(runes[:j-1]) + "..."
-					break
-				}
-				if j == len(runes) {
-					truncated = s
-				}
-			}
-			displayName = truncated
-		}
+	for _, pkg := range m.topPackages() {
+		items = append(items, dashboardItem{pkg: pkg.Name})
+	}
+	return items
+}
 
-		selectionsList.WriteString("\n")
-		// Highlight selected item when panel is focused
-		if m.selectionPanelFocused && i == m.selectionPanelIndex {
-			selectionsList.WriteString(selectedItemStyle.Render("> " + displayName))
-		} else {
-			selectionsList.WriteString(itemStyle.Render("  " + displayName))
-		}
+// topPackages returns the biggest packages the dashboard lists, top_packages of them
+func (m model) topPackages() []PackageSize {
+	if len(m.dashboard.TopPackages) > m.settings.TopPackages {
+		return m.dashboard.TopPackages[:m.settings.TopPackages]
 	}
+	return m.dashboard.TopPackages
+}
 
-	panel := panelStyle.Width(panelWidth).Render(selectionsList.String())
-	panelHeight := strings.Count(panel, "\n") + 1
+// orphansAction is the key the Orphans row runs: removing the orphans when
+// there are any, otherwise listing them
+func (m model) orphansAction() string {
+	if m.dashboard.Orphans > 0 {
+		return "R"
+	}
+	return "o"
+}
 
-	// Split content into lines
-	lines := strings.Split(content, "\n")
+// selectedDashboardItem returns the row under the dashboard cursor
+func (m model) selectedDashboardItem() (dashboardItem, bool) {
+	items := m.dashboardItems()
+	if len(items) == 0 {
+		return dashboardItem{}, false
+	}
+	return items[max(0, min(m.dashboardSelected, len(items)-1))], true
+}
 
-	// Calculate position (top right corner, flush with borders)
-	panelActualWidth := lipgloss.Width(panel)
+// moveDashboardCursor moves the dashboard cursor by delta rows, stopping at the ends
+func (m *model) moveDashboardCursor(delta int) {
+	m.dashboardSelected = max(0, min(m.dashboardSelected+delta, len(m.dashboardItems())-1))
+}
 
-	// Position panel: start slightly lower to avoid overlapping the top border and flush right (add offset to push further right)
-	startRow := 1
-	startCol := contentWidth - panelActualWidth + 2
-	if startCol < 0 {
-		startCol = 0
+// activateDashboardItem runs the action of the row under the dashboard cursor
+func (m model) activateDashboardItem() (tea.Model, tea.Cmd) {
+	item, ok := m.selectedDashboardItem()
+	if !ok {
+		return m, nil
 	}
+	if item.pkg != "" {
+		m.selectAfterLoad = item.pkg
+		return m.update(keyMsgFor("t"))
+	}
+	return m.update(keyMsgFor(item.key))
+}
 
-	// Build new content with overlay
-	var result strings.Builder
-	panelLines := strings.Split(panel, "\n")
+// sessionOperation records one paru run performed during this session
+type sessionOperation struct {
+	operation confirmationType
+	packages  []string
+	duration  time.Duration
+	reclaimed int64 // Bytes freed by cache cleaning
+	failed    bool
+}
 
-	for i, line := range lines {
-		if i >= startRow && i < startRow+panelHeight {
-			panelLineIdx := i - startRow
-			if panelLineIdx < len(panelLines) {
-				// Calculate visible width of line before panel
-				lineWidth := lipgloss.Width(line)
-				if lineWidth < startCol {
-					// Pad line to reach panel position
-					line = line + strings.Repeat(" ", startCol-lineWidth)
-				} else if lineWidth > startCol {
-					// Truncate line to make room for panel
-					// We need to be careful with ANSI codes
-					line = truncateWithAnsi(line, startCol)
-				}
-				line = line + panelLines[panelLineIdx]
-			}
-		}
-		result.WriteString(line)
-		if i < len(lines)-1 {
-			result.WriteString("\n")
+// newSessionOperation builds a session record from a completed operation
+func newSessionOperation(msg execCompleteMsg) sessionOperation {
+	op := sessionOperation{
+		operation: msg.operation,
+		packages:  msg.packages,
+		failed:    msg.err != nil,
+	}
+	if !msg.started.IsZero() {
+		op.duration = time.Since(msg.started)
+	}
+	if msg.freePath != "" && msg.err == nil {
+		if freeAfter, ok := filesystemFreeBytes(msg.freePath); ok && freeAfter > msg.freeBefore {
+			op.reclaimed = freeAfter - msg.freeBefore
 		}
 	}
-
-	return result.String()
+	return op
 }
 
-// truncateWithAnsi truncates a string to a visual width, preserving ANSI codes
-func truncateWithAnsi(s string, maxWidth int) string {
-	var result strings.Builder
-	width := 0
-	inEscape := false
+// sessionStats summarizes what this process changed, built from the
+// operation records shared with the exit summary
+type sessionStats struct {
+	installed      []string
+	removed        []string
+	updated        []string
+	orphansRemoved int
+	reclaimed      int64
+	subprocessTime time.Duration
+	runs           int
+	failed         int
+}
 
-	for _, r := range s {
-		if r == '\x1b' {
-			inEscape = true
-			result.WriteRune(r)
+// collectSessionStats aggregates the session's operations. Packages from
+// failed runs are not counted as changed.
+func collectSessionStats(ops []sessionOperation) sessionStats {
+	var stats sessionStats
+	installed := make(map[string]bool)
+	removed := make(map[string]bool)
+	updated := make(map[string]bool)
+	for _, op := range ops {
+		stats.runs++
+		stats.subprocessTime += op.duration
+		stats.reclaimed += op.reclaimed
+		if op.failed {
+			stats.failed++
 			continue
 		}
-		if inEscape {
-			result.WriteRune(r)
-			if (r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z') {
-				inEscape = false
+		switch op.operation {
+		case confirmInstall:
+			for _, name := range op.packages {
+				installed[name] = true
+			}
+		case confirmUninstall, confirmCleanup:
+			for _, name := range op.packages {
+				removed[name] = true
+			}
+		case confirmRemoveOrphans:
+			stats.orphansRemoved += len(op.packages)
+			for _, name := range op.packages {
+				removed[name] = true
+			}
+		case confirmUpdate, confirmRebuildForeign:
+			for _, name := range op.packages {
+				updated[name] = true
 			}
-			continue
 		}
-		if width >= maxWidth {
-			break
+	}
+	stats.installed = sortedKeys(installed)
+	stats.removed = sortedKeys(removed)
+	stats.updated = sortedKeys(updated)
+	return stats
+}
+
+// sortedKeys returns the keys of a map in order
+func sortedKeys[V any](set map[string]V) []string {
+	keys := make([]string, 0, len(set))
+	for k := range set {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// handleSessionStatsKeys handles scrolling and closing the session stats overlay
+func (m model) handleSessionStatsKeys(msg tea.KeyMsg) (tea.Model, tea.Cmd) {
+	switch msg.String() {
+	case "esc", "q", ".":
+		m.showSessionStats = false
+		m.sessionStatsOffset = 0
+	case "down", "j":
+		// Stop once the last line is in view
+		lines, visible := m.sessionStatsLines(m.width-4, m.height-4)
+		if m.sessionStatsOffset < len(lines)-visible {
+			m.sessionStatsOffset++
+		}
+	case "up", "k":
+		if m.sessionStatsOffset > 0 {
+			m.sessionStatsOffset--
 		}
-		result.WriteRune(r)
-		width++
 	}
+	return m, nil
+}
 
-	// Reset any open styles
-	result.WriteString("\x1b[0m")
-	return result.String()
+// sessionStatsDialogWidth is the width of the session stats overlay
+func sessionStatsDialogWidth(contentWidth int) int {
+	dialogWidth := 70
+	if dialogWidth > contentWidth-4 {
+		dialogWidth = contentWidth - 4
+	}
+	return dialogWidth
 }
 
-// renderConfirmationDialog renders a centered confirmation dialog for install/uninstall/update
-func (m model) renderConfirmationDialog(contentWidth, contentHeight int, activeColor lipgloss.Color) string {
-	// Dialog dimensions
-	dialogWidth := contentWidth - 20
-	if dialogWidth < 50 {
-		dialogWidth = 50
+// sessionStatsLines returns the lines of the session stats overlay and how
+// many of them fit at once
+func (m model) sessionStatsLines(contentWidth, contentHeight int) ([]string, int) {
+	stats := collectSessionStats(m.sessionOps)
+
+	innerWidth := sessionStatsDialogWidth(contentWidth) - 4
+	if innerWidth < 10 {
+		innerWidth = 10
 	}
-	if dialogWidth > 80 {
-		dialogWidth = 80
+	labelWidth := 18
+	if innerWidth < 40 {
+		labelWidth = 12
 	}
-	
-	// Determine packages to display and title
-	var packages []Package
-	var title string
-	var actionDesc string
-	var simpleConfirm bool // For confirmations without package lists
-	
-	switch m.confirmType {
-	case confirmInstall:
-		title = "📦 Confirm Installation"
-		actionDesc = "install"
-		for _, name := range m.confirmPackages {
-			packages = append(packages, Package{Name: name})
-		}
-	case confirmUninstall:
-		title = "🗑️  Confirm Removal"
-		actionDesc = "remove"
-		for _, name := range m.confirmPackages {
-			packages = append(packages, Package{Name: name})
-		}
-	case confirmUpdate:
-		title = "🔄 Confirm System Update"
-		actionDesc = "update"
-		packages = m.pendingUpdates
-	case confirmCleanCache:
-		title = "🧹 Confirm Cache Cleaning"
-		actionDesc = "clean"
-		simpleConfirm = true
-	case confirmRemoveOrphans:
-		title = "🗑️  Confirm Orphan Removal"
-		actionDesc = "remove"
-		for _, name := range m.confirmPackages {
-			packages = append(packages, Package{Name: name})
+	wrapStyle := lipgloss.NewStyle().
+		Foreground(currentTheme.TextColor).
+		Width(innerWidth - 2)
+
+	var lines []string
+	row := func(label, value string) {
+		lines = append(lines, dashboardLabelStyle.Render(fmt.Sprintf("%-*s", labelWidth, truncateRunes(label, labelWidth-1)))+dashboardValueStyle.Render(value))
+	}
+	packageList := func(label string, names []string) {
+		row(label, fmt.Sprintf("%d", len(names)))
+		if len(names) > 0 {
+			for _, line := range strings.Split(wrapStyle.Render(strings.Join(names, ", ")), "\n") {
+				lines = append(lines, "  "+line)
+			}
 		}
 	}
-	
-	// Styles
-	dialogBorderStyle := lipgloss.NewStyle().
-		Border(lipgloss.RoundedBorder()).
-		BorderForeground(activeColor).
-		Padding(1, 2)
-	
+
+	packageList("Installed", stats.installed)
+	packageList("Removed", stats.removed)
+	packageList("Updated", stats.updated)
+	lines = append(lines, "")
+	row("Orphans removed", fmt.Sprintf("%d", stats.orphansRemoved))
+	row("Cache reclaimed", formatBytes(stats.reclaimed))
+	row("Time in "+helper.name, stats.subprocessTime.Round(time.Second).String())
+	runs := fmt.Sprintf("%d", stats.runs)
+	if stats.failed > 0 {
+		runs += fmt.Sprintf(" (%d failed)", stats.failed)
+	}
+	row("Operations", runs)
+	row("AUR searches", fmt.Sprintf("%d", m.sessionSearches))
+
+	// Scroll when the lists don't fit
+	visible := contentHeight - 8
+	if visible < 3 {
+		visible = 3
+	}
+	return lines, visible
+}
+
+// renderSessionStatsOverlay renders what gaur did during this run
+func (m model) renderSessionStatsOverlay(contentWidth, contentHeight int, activeColor lipgloss.Color) string {
+	dialogWidth := sessionStatsDialogWidth(contentWidth)
 	titleStyle := lipgloss.NewStyle().
 		Bold(true).
-		Foreground(activeColor).
-		MarginBottom(1)
-	
-	packageNameStyle := lipgloss.NewStyle().
-		Foreground(lipgloss.Color("39"))
-	
-	packageVersionStyle := lipgloss.NewStyle().
-		Foreground(lipgloss.Color("241"))
-	
-	sourceStyle := func(source string) lipgloss.Style {
-		if color, ok := sourceColors[source]; ok {
-			return lipgloss.NewStyle().Foreground(color)
-		}
-		return lipgloss.NewStyle().Foreground(lipgloss.Color("252"))
-	}
-	
-	countStyle := lipgloss.NewStyle().
-		Foreground(lipgloss.Color("214")).
-		Bold(true)
-	
-	promptStyle := lipgloss.NewStyle().
-		Foreground(lipgloss.Color("252")).
-		MarginTop(1)
-	
+		Foreground(activeColor)
+	subtleStyle := lipgloss.NewStyle().
+		Foreground(currentTheme.SubtleColor)
 	keyStyle := lipgloss.NewStyle().
 		Foreground(activeColor).
 		Bold(true)
-	
-	scrollHintStyle := lipgloss.NewStyle().
-		Foreground(lipgloss.Color("241"))
-	
-	// Build dialog content
+	dialogBorderStyle := lipgloss.NewStyle().
+		Border(lipgloss.RoundedBorder()).
+		BorderForeground(activeColor).
+		Padding(0, 1)
+
+	lines, visible := m.sessionStatsLines(contentWidth, contentHeight)
+	offset := m.sessionStatsOffset
+	if offset > len(lines)-visible {
+		offset = len(lines) - visible
+	}
+	if offset < 0 {
+		offset = 0
+	}
+	end := offset + visible
+	if end > len(lines) {
+		end = len(lines)
+	}
+
 	var content strings.Builder
-	
-	// Title
-	content.WriteString(titleStyle.Render(title))
+	content.WriteString(titleStyle.Render("📊 This Session"))
 	content.WriteString("\n\n")
-	
-	// Handle simple confirmations (no package list)
-	if simpleConfirm {
-		if m.confirmType == confirmCleanCache {
-			content.WriteString("This will remove cached packages that are no longer installed.\n\n")
-			
-			// Pacman cache info
-			content.WriteString(packageNameStyle.Render("Pacman Cache (system):\n"))
-			content.WriteString(fmt.Sprintf("  Path: %s\n", scrollHintStyle.Render(m.dashboard.PacmanCachePath)))
-			content.WriteString(fmt.Sprintf("  Size: %s\n\n", countStyle.Render(m.dashboard.PacmanCacheSize)))
-			
-			// Paru cache info
-			content.WriteString(packageNameStyle.Render("Paru Cache (user):\n"))
-			content.WriteString(fmt.Sprintf("  Path: %s\n", scrollHintStyle.Render(m.dashboard.ParuCachePath)))
-			content.WriteString(fmt.Sprintf("  Size: %s\n\n", countStyle.Render(m.dashboard.ParuCacheSize)))
-			
-			// Total
-			content.WriteString(fmt.Sprintf("Total cache size: %s\n", 
-				lipgloss.NewStyle().Bold(true).Foreground(lipgloss.Color("214")).Render(m.dashboard.CleanerSize)))
-		}
-	} else {
-		// Package count
-		if len(packages) == 1 {
-			content.WriteString(fmt.Sprintf("The following package will be %sd:\n\n", actionDesc))
-		} else {
-			content.WriteString(fmt.Sprintf("The following %s packages will be %sd:\n\n", 
-				countStyle.Render(fmt.Sprintf("%d", len(packages))), actionDesc))
-		}
-		
-		// Package list with scrolling
-		maxVisible := 10
-		startIdx := m.confirmScrollOffset
-		endIdx := startIdx + maxVisible
-		if endIdx > len(packages) {
-			endIdx = len(packages)
-		}
-		
-		// Show scroll indicator at top if needed
-		if startIdx > 0 {
-			content.WriteString(scrollHintStyle.Render(fmt.Sprintf("  ↑ %d more above\n", startIdx)))
-		}
-		
-		// List packages
-		for i := startIdx; i < endIdx; i++ {
-			pkg := packages[i]
-			if m.confirmType == confirmUpdate {
-				// Show source and version info for updates
-				sourceBadge := sourceStyle(pkg.Source).Render(fmt.Sprintf("[%s]", pkg.Source))
-				content.WriteString(fmt.Sprintf("  • %s %s %s\n",
-					sourceBadge,
-					packageNameStyle.Render(pkg.Name),
-					packageVersionStyle.Render(pkg.Version)))
-			} else {
-				// Just show package name for install/uninstall
-				content.WriteString(fmt.Sprintf("  • %s\n", packageNameStyle.Render(pkg.Name)))
-			}
-		}
-		
-		// Show scroll indicator at bottom if needed
-		remaining := len(packages) - endIdx
-		if remaining > 0 {
-			content.WriteString(scrollHintStyle.Render(fmt.Sprintf("  ↓ %d more below\n", remaining)))
-		}
-		
-		// Scroll hint if list is scrollable
-		if len(packages) > maxVisible {
-			content.WriteString("\n")
-			content.WriteString(scrollHintStyle.Render("  Use [↑/↓] or [j/k] to scroll"))
-		}
+	if len(m.sessionOps) == 0 {
+		content.WriteString(subtleStyle.Render("Nothing has run yet."))
+		content.WriteString("\n")
 	}
-	
-	// Prompt - build as single line to prevent wrapping issues
+	content.WriteString(strings.Join(lines[offset:end], "\n"))
 	content.WriteString("\n\n")
-	promptLine := fmt.Sprintf("Proceed? %ses  %so",
-		keyStyle.Render("[y]"),
-		keyStyle.Render("[n]"))
-	content.WriteString(promptStyle.Render(promptLine))
-	
-	// Render dialog box
-	dialogContent := content.String()
-	dialog := dialogBorderStyle.Width(dialogWidth).Render(dialogContent)
-	
-	// Center the dialog on screen
-	dialogHeight := strings.Count(dialog, "\n") + 1
-	
-	// Calculate vertical and horizontal padding
-	vertPadding := (contentHeight - dialogHeight) / 2
-	if vertPadding < 0 {
-		vertPadding = 0
-	}
-	horizPadding := (contentWidth - lipgloss.Width(dialog)) / 2
-	if horizPadding < 0 {
-		horizPadding = 0
-	}
-	
-	// Build final output with centering
-	var output strings.Builder
-	
-	// Add top padding
-	for i := 0; i < vertPadding; i++ {
-		output.WriteString("\n")
+	if len(lines) > visible {
+		content.WriteString(keyStyle.Render("[j/k]") + " scroll  ")
 	}
-	
-	// Add dialog with horizontal padding
-	for _, line := range strings.Split(dialog, "\n") {
-		output.WriteString(strings.Repeat(" ", horizPadding))
-		output.WriteString(line)
-		output.WriteString("\n")
+	content.WriteString(keyStyle.Render("[esc]") + " close")
+
+	dialog := dialogBorderStyle.Width(dialogWidth).Render(content.String())
+	return centerDialog(dialog, contentWidth, contentHeight)
+}
+
+// openOpLog loads the end of the operation log into its overlay
+func (m *model) openOpLog() {
+	m.showOpLog = true
+	m.opLogLines = nil
+	m.opLogErr = nil
+	if opLogPath != "" {
+		m.opLogLines, m.opLogErr = readLogTail(opLogPath, opLogLines)
 	}
-	
-	return output.String()
+	// Start at the newest entries
+	m.opLogOffset = len(m.opLogLines)
 }
 
-// renderErrorOverlay renders a centered error overlay dialog
-func (m model) renderErrorOverlay(contentWidth, contentHeight int) string {
-	// Error color (red)
-	errorColor := lipgloss.Color("#FF5555")
-	
-	// Dialog dimensions
-	dialogWidth := contentWidth - 20
-	if dialogWidth < 50 {
-		dialogWidth = 50
+// handleOpLogKeys handles key presses in the operation log overlay
+func (m model) handleOpLogKeys(msg tea.KeyMsg) (tea.Model, tea.Cmd) {
+	switch msg.String() {
+	case "esc", "q", "L":
+		m.showOpLog = false
+		m.opLogLines = nil
+	case "down", "j":
+		if m.opLogOffset < len(m.opLogLines) {
+			m.opLogOffset++
+		}
+	case "up", "k":
+		if m.opLogOffset > 0 {
+			m.opLogOffset--
+		}
+	case "g", "home":
+		m.opLogOffset = 0
+	case "G", "end":
+		m.opLogOffset = len(m.opLogLines)
 	}
-	if dialogWidth > 80 {
-		dialogWidth = 80
+	return m, nil
+}
+
+// renderOpLogOverlay renders the last lines of the operation log
+func (m model) renderOpLogOverlay(contentWidth, contentHeight int, activeColor lipgloss.Color) string {
+	dialogWidth := contentWidth - 4
+	innerWidth := dialogWidth - 4
+	if innerWidth < 10 {
+		innerWidth = 10
 	}
-	
-	// Styles
+
 	titleStyle := lipgloss.NewStyle().
 		Bold(true).
-		Foreground(errorColor).
-		Width(dialogWidth - 4).
-		Align(lipgloss.Center)
-	
-	messageStyle := lipgloss.NewStyle().
-		Foreground(lipgloss.Color("#FFFFFF")).
-		Width(dialogWidth - 4).
-		Align(lipgloss.Center)
-	
-	detailsStyle := lipgloss.NewStyle().
-		Foreground(lipgloss.Color("#999999")).
-		Width(dialogWidth - 4).
-		Padding(1, 0)
-	
-	hintStyle := lipgloss.NewStyle().
-		Foreground(lipgloss.Color("#666666")).
-		Width(dialogWidth - 4).
-		Align(lipgloss.Center)
-	
+		Foreground(activeColor)
+	textStyle := lipgloss.NewStyle().
+		Foreground(currentTheme.TextColor)
+	errorStyle := lipgloss.NewStyle().
+		Foreground(currentTheme.ErrorColor)
+	subtleStyle := lipgloss.NewStyle().
+		Foreground(currentTheme.SubtleColor)
+	keyStyle := lipgloss.NewStyle().
+		Foreground(activeColor).
+		Bold(true)
 	dialogBorderStyle := lipgloss.NewStyle().
 		Border(lipgloss.RoundedBorder()).
-		BorderForeground(errorColor).
-		Padding(1, 2)
-	
-	// Build content
+		BorderForeground(activeColor).
+		Padding(0, 1)
+
 	var content strings.Builder
-	
-	// Error icon and title
-	content.WriteString(titleStyle.Render("⚠  " + m.errorTitle + "  ⚠"))
-	content.WriteString("\n\n")
-	
-	// Error message
-	content.WriteString(messageStyle.Render(m.errorMessage))
+	content.WriteString(titleStyle.Render("📋 Operation Log"))
 	content.WriteString("\n")
-	
-	// Error details
-	if m.errorDetails != "" {
-		content.WriteString(detailsStyle.Render(m.errorDetails))
+	switch {
+	case opLogPath == "":
+		content.WriteString(subtleStyle.Render("No operation log is open."))
+		content.WriteString("\n")
+	case m.opLogErr != nil && os.IsNotExist(m.opLogErr):
+		content.WriteString(subtleStyle.Render(opLogPath))
+		content.WriteString("\n\n")
+		content.WriteString(subtleStyle.Render("Nothing has been logged yet."))
+		content.WriteString("\n")
+	case m.opLogErr != nil:
+		content.WriteString(errorStyle.Render(fmt.Sprintf("Could not read %s: %v", opLogPath, m.opLogErr)))
 		content.WriteString("\n")
+	default:
+		content.WriteString(subtleStyle.Render(fmt.Sprintf("Last %d lines of %s", len(m.opLogLines), opLogPath)))
+		content.WriteString("\n\n")
 	}
-	
-	// Dismiss hint
-	content.WriteString(hintStyle.Render("Press [esc], [enter], or [q] to dismiss"))
-	
-	// Render dialog box
-	dialogContent := content.String()
-	dialog := dialogBorderStyle.Width(dialogWidth).Render(dialogContent)
-	
-	// Center the dialog on screen
-	dialogHeight := strings.Count(dialog, "\n") + 1
-	
-	// Calculate vertical and horizontal padding
-	vertPadding := (contentHeight - dialogHeight) / 2
-	if vertPadding < 0 {
-		vertPadding = 0
+
+	// Keep the newest lines in view unless scrolled back
+	visible := contentHeight - 10
+	if visible < 3 {
+		visible = 3
 	}
-	horizPadding := (contentWidth - lipgloss.Width(dialog)) / 2
-	if horizPadding < 0 {
-		horizPadding = 0
+	end := m.opLogOffset
+	if end < visible {
+		end = visible
 	}
-	
-	// Build final output with centering
-	var output strings.Builder
-	
-	// Add top padding
-	for i := 0; i < vertPadding; i++ {
-		output.WriteString("\n")
+	if end > len(m.opLogLines) {
+		end = len(m.opLogLines)
 	}
-	
-	// Add dialog with horizontal padding
-	for _, line := range strings.Split(dialog, "\n") {
-		output.WriteString(strings.Repeat(" ", horizPadding))
-		output.WriteString(line)
-		output.WriteString("\n")
+	start := end - visible
+	if start < 0 {
+		start = 0
 	}
-	
-	return output.String()
+	if start > 0 {
+		content.WriteString(subtleStyle.Render(fmt.Sprintf("↑ %d more above", start)))
+		content.WriteString("\n")
+	}
+	for _, line := range m.opLogLines[start:end] {
+		style := textStyle
+		if strings.Contains(line, "level=ERROR") {
+			style = errorStyle
+		}
+		content.WriteString(style.Render(truncateRunes(line, innerWidth)))
+		content.WriteString("\n")
+	}
+	if end < len(m.opLogLines) {
+		content.WriteString(subtleStyle.Render(fmt.Sprintf("↓ %d more below", len(m.opLogLines)-end)))
+		content.WriteString("\n")
+	}
+
+	content.WriteString("\n")
+	if len(m.opLogLines) > visible {
+		content.WriteString(keyStyle.Render("[j/k]") + " scroll  ")
+	}
+	content.WriteString(keyStyle.Render("[esc]") + " close")
+
+	dialog := dialogBorderStyle.Width(dialogWidth).Render(content.String())
+	return centerDialog(dialog, contentWidth, contentHeight)
 }
 
-func (m model) renderDashboard(helpText string, contentWidth, contentHeight int) string {
-	// Get mode-specific border style
-	activeColor := modeColors[m.mode]
-	if activeColor == "" {
-		activeColor = defaultBorderColor
+// operationName returns the display name of an operation
+func operationName(op confirmationType) string {
+	switch op {
+	case confirmInstall:
+		return "Installation"
+	case confirmUninstall:
+		return "Removal"
+	case confirmUpdate:
+		return "System Update"
+	case confirmCleanCache:
+		return "Cache Cleaning"
+	case confirmRemoveOrphans:
+		return "Orphan Removal"
+	case confirmRemoveLock:
+		return "Lock Removal"
+	case confirmRebuildForeign:
+		return "Foreign Rebuild"
+	case confirmCleanup:
+		return "Cleanup"
+	case confirmCleanCacheDir:
+		return "Cache Directory Cleaning"
+	case confirmSyncFiles:
+		return "File Database Sync"
+	case confirmInstallReason:
+		return "Install Reason Change"
+	case confirmQueue:
+		return "Queued Transaction"
+	case confirmSyncDB:
+		return "Database Sync"
+	case confirmIntegrityCheck:
+		return "Integrity Check"
 	}
-	borderStyle := baseBorderStyle.BorderForeground(activeColor)
+	return ""
+}
 
-	// Footer with help text aligned to the right
-	helpWidth := lipgloss.Width(helpText)
-	padding := contentWidth - helpWidth
-	if padding < 0 {
-		padding = 0
+// rebootPackages are packages whose upgrade is only fully applied after a reboot
+var rebootPackages = map[string]bool{
+	"linux":          true,
+	"linux-lts":      true,
+	"linux-zen":      true,
+	"linux-hardened": true,
+	"linux-rt":       true,
+	"systemd":        true,
+	"glibc":          true,
+	"amd-ucode":      true,
+	"intel-ucode":    true,
+	"nvidia":         true,
+	"nvidia-dkms":    true,
+	"nvidia-open":    true,
+	"nvidia-lts":     true,
+}
+
+// rebootReasons returns the reboot-relevant packages installed or updated successfully
+func rebootReasons(ops []sessionOperation) []string {
+	seen := make(map[string]bool)
+	var reasons []string
+	for _, op := range ops {
+		if op.failed || (op.operation != confirmInstall && op.operation != confirmUpdate && op.operation != confirmRebuildForeign) {
+			continue
+		}
+		for _, name := range op.packages {
+			if rebootPackages[name] && !seen[name] {
+				seen[name] = true
+				reasons = append(reasons, name)
+			}
+		}
 	}
-	footerLine := strings.Repeat(" ", padding) + helpText
+	sort.Strings(reasons)
+	return reasons
+}
 
-	if m.loading {
-		loadingBox := borderStyle.
-			Width(contentWidth).
-			Height(contentHeight - 1).
-			Render(lipgloss.Place(contentWidth-2, contentHeight-3, lipgloss.Center, lipgloss.Center, "Loading system statistics..."))
-		return lipgloss.JoinVertical(lipgloss.Left, loadingBox, footerLine)
+// filesystemFreeBytes returns the free space available on the filesystem holding path
+func filesystemFreeBytes(path string) (int64, bool) {
+	var stat syscall.Statfs_t
+	if err := syscall.Statfs(path, &stat); err != nil {
+		return 0, false
 	}
+	return int64(stat.Bavail) * int64(stat.Bsize), true
+}
 
-	var dashboard strings.Builder
+// filesystemUsage measures the filesystem holding path, returning the statfs
+// result it came from as well
+func filesystemUsage(path string) (FilesystemUsage, syscall.Statfs_t, bool) {
+	var stat syscall.Statfs_t
+	if err := syscall.Statfs(path, &stat); err != nil || stat.Blocks == 0 {
+		return FilesystemUsage{}, stat, false
+	}
+	return FilesystemUsage{
+		Path:      path,
+		UsedBytes: int64(stat.Blocks-stat.Bfree) * int64(stat.Bsize),
+		FreeBytes: int64(stat.Bavail) * int64(stat.Bsize),
+	}, stat, true
+}
 
-	// Color definitions
-	greenColor := lipgloss.Color("42")
-	redColor := lipgloss.Color("196")
-	yellowColor := lipgloss.Color("214")
-	orangeColor := lipgloss.Color("208")
-	cyanColor := lipgloss.Color("51")
-	dimColor := lipgloss.Color("240")
+// btrfsMagic is the statfs type of btrfs
+const btrfsMagic = 0x9123683e
 
-	// Box styles
-	boxTitleStyle := lipgloss.NewStyle().
-		Bold(true).
-		Foreground(lipgloss.Color("229"))
+// sameFilesystem reports whether two statfs results describe one filesystem.
+// btrfs mixes the subvolume into the filesystem id, so its subvolumes are
+// matched by the size of the pool they share instead.
+func sameFilesystem(a, b syscall.Statfs_t) bool {
+	if a.Fsid == b.Fsid {
+		return true
+	}
+	return uint32(a.Type) == btrfsMagic && uint32(b.Type) == btrfsMagic && a.Blocks == b.Blocks && a.Bsize == b.Bsize
+}
 
-	// Shortcut hint style
-	shortcutStyle := lipgloss.NewStyle().Foreground(dimColor)
+// dashboardFilesystems measures the root filesystem, and /var when it is
+// mounted separately. A /var on the same filesystem as /, such as a btrfs
+// subvolume or a bind mount, is not listed twice.
+func dashboardFilesystems() []FilesystemUsage {
+	root, rootStat, ok := filesystemUsage("/")
+	if !ok {
+		return nil
+	}
+	filesystems := []FilesystemUsage{root}
+	if v, varStat, ok := filesystemUsage("/var"); ok && !sameFilesystem(rootStat, varStat) {
+		filesystems = append(filesystems, v)
+	}
+	return filesystems
+}
 
-	// ═══════════════════════════════════════════════════════
-	// GROUP 1: Package Counts (with shortcuts to filter in remove mode)
-	// ═══════════════════════════════════════════════════════
-	
-	// Build package counts content as simple lines
-	countsLines := []string{
-		fmt.Sprintf(" %s Total    │ %s",
-			shortcutStyle.Render("[t]"),
-			lipgloss.NewStyle().Bold(true).Foreground(cyanColor).Render(fmt.Sprintf("%d", m.dashboard.TotalPackages))),
-		fmt.Sprintf(" %s Explicit │ %s",
-			shortcutStyle.Render("[e]"),
-			lipgloss.NewStyle().Bold(true).Foreground(greenColor).Render(fmt.Sprintf("%d", m.dashboard.ExplicitlyInstalled))),
-		fmt.Sprintf(" %s Foreign  │ %s",
-			shortcutStyle.Render("[f]"),
-			lipgloss.NewStyle().Bold(true).Foreground(yellowColor).Render(fmt.Sprintf("%d", m.dashboard.ForeignPackages))),
+// isTerminal reports whether f is attached to a terminal
+func isTerminal(f *os.File) bool {
+	info, err := f.Stat()
+	if err != nil {
+		return false
 	}
-	
-	// Orphan line with optional remove hint
-	orphanStyle := lipgloss.NewStyle().Bold(true).Foreground(greenColor)
-	if m.dashboard.Orphans > 0 {
-		orphanStyle = lipgloss.NewStyle().Bold(true).Foreground(redColor)
+	return info.Mode()&os.ModeCharDevice != 0
+}
+
+// renderSessionSummary formats the operations of a session for printing after
+// the alt screen is closed. freedBytes is the change in free disk space, positive
+// when space was freed. Styling is dropped when styled is false so piped output
+// stays plain text. Returns "" when no operation ran, even with warnings, as
+// those were already shown in the TUI.
+func renderSessionSummary(ops []sessionOperation, warnings []string, freedBytes int64, styled bool) string {
+	if len(ops) == 0 {
+		return ""
 	}
-	orphanLine := fmt.Sprintf(" %s Orphans  │ %s",
-		shortcutStyle.Render("[o]"),
-		orphanStyle.Render(fmt.Sprintf("%d", m.dashboard.Orphans)))
-	if m.dashboard.Orphans > 0 {
-		orphanLine += shortcutStyle.Render(" [R]rm")
+
+	render := func(style lipgloss.Style, s string) string {
+		if styled {
+			return style.Render(s)
+		}
+		return s
 	}
-	countsLines = append(countsLines, orphanLine)
 
-	// ═══════════════════════════════════════════════════════
-	// GROUP 2: Storage Info
-	// ═══════════════════════════════════════════════════════
-	
-	// Cache coloring: warm colors if > 10 GiB
-	cacheStyle := lipgloss.NewStyle().Bold(true).Foreground(greenColor)
-	const tenGiB = 10 * 1024 * 1024 * 1024
-	if m.dashboard.CleanerSizeBytes > tenGiB {
-		cacheStyle = lipgloss.NewStyle().Bold(true).Foreground(orangeColor)
+	// Aggregate runs per operation type, keeping first-seen order
+	type opTotals struct {
+		runs     int
+		failed   int
+		packages int
+		duration time.Duration
 	}
-	if m.dashboard.CleanerSizeBytes > tenGiB*2 {
-		cacheStyle = lipgloss.NewStyle().Bold(true).Foreground(redColor)
+	var order []confirmationType
+	totals := make(map[confirmationType]*opTotals)
+	for _, op := range ops {
+		t, ok := totals[op.operation]
+		if !ok {
+			t = &opTotals{}
+			totals[op.operation] = t
+			order = append(order, op.operation)
+		}
+		t.runs++
+		if op.failed {
+			t.failed++
+		} else {
+			t.packages += len(op.packages)
+		}
+		t.duration += op.duration
 	}
-	
-	// Missing from AUR style
-	missingStyle := lipgloss.NewStyle().Bold(true).Foreground(greenColor)
-	if m.dashboard.MissingFromAUR > 0 {
-		missingStyle = lipgloss.NewStyle().Bold(true).Foreground(redColor)
+
+	var b strings.Builder
+	b.WriteString(render(dashboardLabelStyle, "gaur session summary"))
+	b.WriteString("\n")
+	for _, op := range order {
+		t := totals[op]
+		detail := fmt.Sprintf("%d run(s)", t.runs)
+		if t.packages > 0 {
+			detail += fmt.Sprintf(", %d package(s)", t.packages)
+		}
+		if t.failed > 0 {
+			detail += fmt.Sprintf(", %d failed", t.failed)
+		}
+		detail += fmt.Sprintf(", %s", t.duration.Round(time.Second))
+		b.WriteString(fmt.Sprintf("  %s %s\n", render(dashboardLabelStyle, fmt.Sprintf("%-16s", operationName(op))), render(dashboardValueStyle, detail)))
+	}
+
+	if freedBytes != 0 {
+		space := fmt.Sprintf("%s freed", formatBytes(freedBytes))
+		if freedBytes < 0 {
+			space = fmt.Sprintf("%s used", formatBytes(-freedBytes))
+		}
+		b.WriteString(fmt.Sprintf("  %s %s\n", render(dashboardLabelStyle, fmt.Sprintf("%-16s", "Disk Space")), render(dashboardValueStyle, space)))
+	}
+
+	if reclaimed := collectSessionStats(ops).reclaimed; reclaimed > 0 {
+		b.WriteString(fmt.Sprintf("  %s %s\n", render(dashboardLabelStyle, fmt.Sprintf("%-16s", "Cache Reclaimed")), render(dashboardValueStyle, formatBytes(reclaimed))))
+	}
+
+	if reasons := rebootReasons(ops); len(reasons) > 0 {
+		b.WriteString(render(dashboardWarningStyle, fmt.Sprintf("  Reboot recommended: %s changed", strings.Join(reasons, ", "))))
+		b.WriteString("\n")
+	}
+
+	if len(warnings) > 0 {
+		b.WriteString(render(dashboardWarningStyle, "  Warnings:"))
+		b.WriteString("\n")
+		for _, w := range warnings {
+			b.WriteString(fmt.Sprintf("    - %s\n", w))
+		}
+	}
+
+	return b.String()
+}
+
+// dashboardStats is the --stats output: the dashboard's data along with the
+// pending update count, which the dashboard loads separately
+type dashboardStats struct {
+	DashboardData
+	PendingUpdates int
+}
+
+// printStats writes the dashboard statistics to w as JSON, or only the value
+// of field, matched case-insensitively, for status bars
+func printStats(w io.Writer, m model, field string) error {
+	data, err := collectDashboardData(m.runner, m.dashboardCacheDirs())
+	if err != nil {
+		return err
+	}
+	m.dashboard = data
+	data.TopPackages = m.topPackages()
+	stats := dashboardStats{DashboardData: data, PendingUpdates: pendingUpdateCount(checkUpdates(m.runner, m.holds, m.pins, false))}
+	out, err := json.MarshalIndent(stats, "", "  ")
+	if err != nil {
+		return err
+	}
+	if field == "" {
+		_, err = fmt.Fprintf(w, "%s\n", out)
+		return err
+	}
+
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(out, &fields); err != nil {
+		return err
+	}
+	for name, value := range fields {
+		if !strings.EqualFold(name, field) {
+			continue
+		}
+		// Strings print bare; numbers, lists and objects as JSON
+		var s string
+		if json.Unmarshal(value, &s) == nil {
+			_, err = fmt.Fprintln(w, s)
+		} else {
+			_, err = fmt.Fprintf(w, "%s\n", value)
+		}
+		return err
+	}
+	return fmt.Errorf("unknown stats field %q, see gaur --stats for the fields", field)
+}
+
+// runSubcommand runs one of the headless subcommands, search and list,
+// writing its results to w
+func runSubcommand(r Runner, w io.Writer, args []string) error {
+	var err error
+	switch args[0] {
+	case "search":
+		err = searchCommand(r, w, args[1:])
+	case "list":
+		err = listCommand(r, w, args[1:])
+	default:
+		return fmt.Errorf("unknown command %q (commands: search, list)", args[0])
+	}
+	if err == flag.ErrHelp {
+		return nil
+	}
+	return err
+}
+
+// parseCommandArgs parses fs's flags wherever they appear among args,
+// returning the other arguments in order
+func parseCommandArgs(fs *flag.FlagSet, args []string) ([]string, error) {
+	var positional []string
+	for {
+		if err := fs.Parse(args); err != nil {
+			return nil, err
+		}
+		if fs.NArg() == 0 {
+			return positional, nil
+		}
+		positional = append(positional, fs.Arg(0))
+		args = fs.Args()[1:]
+	}
+}
+
+// searchCommand prints the repository and AUR packages matching a query,
+// ranked like the install search and taking its repo prefixes (a:, ce: ...)
+func searchCommand(r Runner, w io.Writer, args []string) error {
+	fs := flag.NewFlagSet("gaur search", flag.ContinueOnError)
+	jsonOut := fs.Bool("json", false, "Print the results as JSON")
+	words, err := parseCommandArgs(fs, args)
+	if err != nil {
+		return err
+	}
+	query := strings.Join(words, " ")
+	if query == "" {
+		return fmt.Errorf("usage: gaur search [--json] <query>")
+	}
+	repoFilters, searchQuery := parseRepoFilter(query)
+
+	packages, _, err := loadRepoPackagesNow(r)
+	if err != nil {
+		return err
+	}
+
+	if helper.aur && (len(repoFilters) == 0 || repoFilters["aur"]) {
+		if aurQuery := aurSearchQuery(searchQuery); aurQuery != "" {
+			msg, _ := searchAUR(context.Background(), r, aurQuery, 0)().(aurSearchMsg)
+			if msg.err != nil {
+				fmt.Fprintf(os.Stderr, "gaur: AUR search failed: %v\n", msg.err)
+			}
+			installed, _ := loadInstalledSet(r)
+			for _, pkg := range msg.packages {
+				pkg.Installed = installed[pkg.Name]
+				packages = append(packages, pkg)
+			}
+		}
+	}
+
+	candidates := make([]Package, 0, len(packages))
+	for _, pkg := range packages {
+		if len(repoFilters) == 0 || repoFilters[pkg.Source] {
+			candidates = append(candidates, pkg)
+		}
+	}
+	results := fuzzyFilter(candidates, searchQuery)
+	if *jsonOut {
+		return writePackagesJSON(w, results)
+	}
+
+	nameWidth, versionWidth := packageColumnWidths(results, true)
+	f, ok := w.(*os.File)
+	tty := ok && isTerminal(f)
+	for _, pkg := range results {
+		name := fmt.Sprintf("%-*s", nameWidth, pkg.Source+"/"+pkg.Name)
+		if color, ok := sourceColors[pkg.Source]; ok && tty {
+			name = lipgloss.NewStyle().Foreground(color).Render(pkg.Source+"/") + fmt.Sprintf("%-*s", nameWidth-len(pkg.Source)-1, pkg.Name)
+		}
+		installed := "           "
+		if pkg.Installed {
+			installed = "[installed]"
+		}
+		line := fmt.Sprintf("%s  %-*s  %s  %s", name, versionWidth, pkg.Version, installed, pkg.Description)
+		fmt.Fprintln(w, strings.TrimRight(line, " "))
+	}
+	return nil
+}
+
+// loadRepoPackagesNow runs the whole repo package load, refresh included,
+// for use outside the TUI
+func loadRepoPackagesNow(r Runner) ([]Package, map[string][]string, error) {
+	ch := make(chan tea.Msg, 4)
+	go streamRepoPackages(r, ch)
+	var packages []Package
+	var groups map[string][]string
+	var err error
+	for msg := range ch {
+		if msg, ok := msg.(repoPackagesMsg); ok {
+			packages, groups, err = msg.packages, msg.groups, msg.err
+		}
 	}
+	return packages, groups, err
+}
 
-	storageLines := []string{
-		fmt.Sprintf("  System  │ %s",
-			lipgloss.NewStyle().Bold(true).Foreground(cyanColor).Render(m.dashboard.TotalSize)),
-		fmt.Sprintf("  Cache   │ %s %s",
-			cacheStyle.Render(m.dashboard.CleanerSize),
-			shortcutStyle.Render("[c]lean")),
-		fmt.Sprintf("  Missing │ %s",
-			missingStyle.Render(fmt.Sprintf("%d AUR", m.dashboard.MissingFromAUR))),
-		"", // Empty line to match height
+// openOneShot checks the packages named with -S or -R and opens their install
+// or removal confirmation for the TUI to start on. Installs take repository
+// packages, groups and AUR packages; removals take installed packages. Unknown
+// names fail with the closest matches.
+func (m *model) openOneShot(install bool, names []string) error {
+	if len(names) == 0 {
+		return fmt.Errorf("name the packages to install or remove")
+	}
+	var candidates []Package
+	known := make(map[string]bool)
+	if install {
+		packages, groups, err := loadRepoPackagesNow(m.runner)
+		if err != nil {
+			return err
+		}
+		candidates = packages
+		for _, pkg := range packages {
+			known[pkg.Name] = true
+		}
+		for name := range groups {
+			known[name] = true
+		}
+	} else {
+		installed, err := loadInstalledSet(m.runner)
+		if err != nil {
+			return err
+		}
+		for _, name := range sortedKeys(installed) {
+			candidates = append(candidates, Package{Name: name, Installed: true})
+			known[name] = true
+		}
 	}
 
-	// Render boxes manually with Unicode box drawing
-	borderColor := lipgloss.NewStyle().Foreground(activeColor)
-	
-	// Helper to render a box with title
-	renderBox := func(title string, lines []string, width int) string {
-		var b strings.Builder
-		
-		// Ensure minimum content width
-		innerWidth := width - 4 // Account for border chars and padding
-		if innerWidth < 20 {
-			innerWidth = 20
+	var packages, missing []string
+	seen := make(map[string]bool)
+	for _, name := range names {
+		if seen[name] {
+			continue
 		}
-		
-		// Top border with title
-		titleLen := lipgloss.Width(title)
-		topLeft := borderColor.Render("╭─")
-		topRight := borderColor.Render("─╮")
-		topPadding := innerWidth - titleLen
-		if topPadding < 0 {
-			topPadding = 0
+		seen[name] = true
+		packages = append(packages, name)
+		if !known[name] {
+			missing = append(missing, name)
 		}
-		b.WriteString(topLeft + title + borderColor.Render(strings.Repeat("─", topPadding)) + topRight + "\n")
-		
-		// Content lines
-		leftBorder := borderColor.Render("│ ")
-		rightBorder := borderColor.Render(" │")
-		for _, line := range lines {
-			// Pad line to fill width
-			lineWidth := lipgloss.Width(line)
-			padding := innerWidth - lineWidth
-			if padding < 0 {
-				padding = 0
+	}
+	// Names outside the sync databases may still be AUR packages
+	if install && helper.aur && len(missing) > 0 {
+		if valid, _ := sanitizePackageNames(missing); len(valid) > 0 {
+			if infos, err := aurRPC.info(context.Background(), valid); err == nil {
+				for _, info := range infos {
+					known[info.Name] = true
+				}
 			}
-			b.WriteString(leftBorder + line + strings.Repeat(" ", padding) + rightBorder + "\n")
 		}
-		
-		// Bottom border
-		b.WriteString(borderColor.Render("╰" + strings.Repeat("─", innerWidth+2) + "╯"))
-		
-		return b.String()
 	}
 
-	// Calculate box width
-	boxWidth := (contentWidth - 6) / 2
-	if boxWidth < 30 {
-		boxWidth = 30
+	var problems []string
+	for _, name := range missing {
+		if known[name] {
+			continue
+		}
+		problem := fmt.Sprintf("unknown package %q", name)
+		if !install {
+			problem = fmt.Sprintf("%q is not installed", name)
+		}
+		var suggestions []string
+		for _, pkg := range fuzzyFilter(candidates, name) {
+			if len(suggestions) == 3 {
+				break
+			}
+			suggestions = append(suggestions, pkg.Name)
+		}
+		if len(suggestions) > 0 {
+			problem += " - did you mean " + strings.Join(suggestions, ", ") + "?"
+		}
+		problems = append(problems, problem)
+	}
+	if len(problems) > 0 {
+		return fmt.Errorf("%s", strings.Join(problems, "\n"))
 	}
 
-	countsBox := renderBox(boxTitleStyle.Render(" 📦 Package Counts "), countsLines, boxWidth)
-	storageBox := renderBox(boxTitleStyle.Render(" 💾 Storage "), storageLines, boxWidth)
+	m.showConfirmation = true
+	m.confirmPackages = packages
+	m.confirmScrollOffset = 0
+	if install {
+		m.confirmType = confirmInstall
+		m.statusMessage = "Confirm installation"
+	} else {
+		m.confirmType = confirmUninstall
+		m.statusMessage = "Confirm removal"
+	}
+	return nil
+}
 
-	// Layout boxes side by side
-	countsBoxLines := strings.Split(countsBox, "\n")
-	storageBoxLines := strings.Split(storageBox, "\n")
-	
-	// Ensure same number of lines
-	maxLines := len(countsBoxLines)
-	if len(storageBoxLines) > maxLines {
-		maxLines = len(storageBoxLines)
+// listCommand prints the installed packages, optionally only the explicit,
+// foreign or orphaned ones and those matching a query
+func listCommand(r Runner, w io.Writer, args []string) error {
+	fs := flag.NewFlagSet("gaur list", flag.ContinueOnError)
+	explicit := fs.Bool("explicit", false, "Only explicitly installed packages")
+	foreign := fs.Bool("foreign", false, "Only foreign (AUR) packages")
+	orphan := fs.Bool("orphan", false, "Only orphaned packages")
+	jsonOut := fs.Bool("json", false, "Print the packages as JSON")
+	words, err := parseCommandArgs(fs, args)
+	if err != nil {
+		return err
 	}
-	for len(countsBoxLines) < maxLines {
-		countsBoxLines = append(countsBoxLines, strings.Repeat(" ", boxWidth))
+
+	msg, _ := getInstalledPackages(r)().(installedPackagesMsg)
+	if msg.err != nil {
+		return msg.err
 	}
-	for len(storageBoxLines) < maxLines {
-		storageBoxLines = append(storageBoxLines, strings.Repeat(" ", boxWidth))
+	// Like the remove mode filters, several flags list their union
+	packages := msg.packages
+	if *explicit || *foreign || *orphan {
+		var filtered []Package
+		for _, pkg := range packages {
+			if (*explicit && pkg.Explicit) || (*foreign && pkg.Source == "aur") || (*orphan && pkg.Orphan) {
+				filtered = append(filtered, pkg)
+			}
+		}
+		packages = filtered
 	}
-	
-	// Join boxes horizontally
-	for i := 0; i < maxLines; i++ {
-		dashboard.WriteString(countsBoxLines[i] + "  " + storageBoxLines[i] + "\n")
+	packages = fuzzyFilter(packages, strings.Join(words, " "))
+	if *jsonOut {
+		return writePackagesJSON(w, packages)
 	}
-	dashboard.WriteString("\n")
 
-	// ═══════════════════════════════════════════════════════
-	// Bar Layout Constants - ensures all bars align perfectly
-	// ═══════════════════════════════════════════════════════
-	const barLeftMargin = 2                    // Spaces before label
-	const barLabelWidth = 8                    // Fixed width for labels (e.g., "System", "Cache")
-	const barSeparator = "│"                   // Separator between label and bar
-	const barSuffixReserve = 30                // Reserve space for suffix text (e.g., "1234/5678 (100% explicit)")
-	barStartCol := barLeftMargin + barLabelWidth + len(barSeparator)
-	availableBarWidth := contentWidth - barStartCol - barSuffixReserve
-	if availableBarWidth < 20 {
-		availableBarWidth = 20
+	nameWidth, _ := packageColumnWidths(packages, false)
+	for _, pkg := range packages {
+		fmt.Fprintf(w, "%-*s  %s\n", nameWidth, pkg.Name, pkg.Version)
 	}
+	return nil
+}
 
-	// Helper to create aligned bar line
-	renderBarLine := func(label string, bar string, suffix string) string {
-		paddedLabel := fmt.Sprintf("%*s%-*s%s", barLeftMargin, "", barLabelWidth, label, barSeparator)
-		return paddedLabel + bar + " " + suffix
+// packageColumnWidths returns the widths of the name, prefixed with the
+// repository when withSource is set, and version columns
+func packageColumnWidths(packages []Package, withSource bool) (nameWidth, versionWidth int) {
+	for _, pkg := range packages {
+		name := len(pkg.Name)
+		if withSource {
+			name += len(pkg.Source) + 1
+		}
+		nameWidth = max(nameWidth, name)
+		versionWidth = max(versionWidth, len(pkg.Version))
 	}
+	return nameWidth, versionWidth
+}
 
-	// ═══════════════════════════════════════════════════════
-	// Progress Bar: Explicit vs Dependency Ratio
-	// ═══════════════════════════════════════════════════════
-	dependencies := m.dashboard.TotalPackages - m.dashboard.ExplicitlyInstalled
-	explicitRatio := float64(m.dashboard.ExplicitlyInstalled) / float64(m.dashboard.TotalPackages)
-	if m.dashboard.TotalPackages == 0 {
-		explicitRatio = 0
+// writePackagesJSON prints packages as a JSON array, empty rather than null
+func writePackagesJSON(w io.Writer, packages []Package) error {
+	if packages == nil {
+		packages = []Package{}
 	}
-	
-	filledWidth := int(explicitRatio * float64(availableBarWidth))
-	if filledWidth > availableBarWidth {
-		filledWidth = availableBarWidth
+	out, err := json.MarshalIndent(packages, "", "  ")
+	if err != nil {
+		return err
 	}
-	
-	filledBar := lipgloss.NewStyle().Background(greenColor).Foreground(lipgloss.Color("0")).
-		Render(strings.Repeat(" ", filledWidth))
-	emptyBar := lipgloss.NewStyle().Background(lipgloss.Color("238")).
-		Render(strings.Repeat(" ", availableBarWidth-filledWidth))
-	
-	ratioTitle := lipgloss.NewStyle().Bold(true).Foreground(lipgloss.Color("229")).
-		Render("📊 Explicit vs Dependencies")
-	ratioSuffix := fmt.Sprintf("%d/%d (%.0f%% explicit)", m.dashboard.ExplicitlyInstalled, dependencies, explicitRatio*100)
-	ratioBar := renderBarLine("", filledBar+emptyBar, ratioSuffix)
-	
-	dashboard.WriteString(ratioTitle + "\n")
-	dashboard.WriteString(ratioBar + "\n\n")
+	_, err = fmt.Fprintf(w, "%s\n", out)
+	return err
+}
 
-	// ═══════════════════════════════════════════════════════
-	// Bar Chart: System Size vs Cache Size
-	// ═══════════════════════════════════════════════════════
-	chartTitle := lipgloss.NewStyle().Bold(true).Foreground(lipgloss.Color("229")).
-		Render("📈 Size Comparison")
-	dashboard.WriteString(chartTitle + "\n")
-	
-	maxSize := m.dashboard.TotalSizeBytes
-	if m.dashboard.CleanerSizeBytes > maxSize {
-		maxSize = m.dashboard.CleanerSizeBytes
+func main() {
+	themeFlag := flag.String("theme", "", "Color theme (use --list-themes to see options)")
+	listThemesFlag := flag.Bool("list-themes", false, "List available themes and exit")
+	rebuildBatchFlag := flag.Int("rebuild-batch-size", rebuildBatchSize, "Number of foreign packages rebuilt per paru run")
+	noSummaryFlag := flag.Bool("no-summary", false, "Do not print a session summary on exit")
+	useFzfFlag := flag.Bool("use-fzf", false, "Rank search results with the external fzf binary")
+	forceTruecolorFlag := flag.Bool("force-truecolor", false, "Use theme colors as-is even when the terminal reports no truecolor support")
+	helperFlag := flag.String("helper", "", "Package manager to run: paru, yay or pacman (default: first found, overrides aur_helper)")
+	statsFlag := flag.Bool("stats", false, "Print the dashboard statistics as JSON and exit")
+	statsFieldFlag := flag.String("stats-field", "", "Print a single dashboard statistic, e.g. orphans, and exit")
+	installFlag := flag.Bool("S", false, "Open the install confirmation for the named packages")
+	removeFlag := flag.Bool("R", false, "Open the removal confirmation for the named packages")
+	exitAfterFlag := flag.Bool("exit-after", false, "With -S or -R, quit once the transaction succeeds")
+	modeFlag := flag.String("mode", "", "Mode to start in: install, remove, update, dashboard or history (overrides default_mode)")
+	queryFlag := flag.String("query", "", "Initial search or filter, e.g. \"o:\" for orphans in remove mode")
+	logFlag := flag.String("log", operationLogPath(), "File every operation is appended to")
+	logLevelFlag := flag.String("log-level", "info", "Operation log detail: info, or debug to also log every command run")
+	flag.Parse()
+
+	// Open the operation log; gaur still runs when it can't be written
+	if *logLevelFlag != "info" && *logLevelFlag != "debug" {
+		fmt.Printf("--log-level: unknown level %q (expected info or debug)\n", *logLevelFlag)
+		os.Exit(1)
 	}
-	if maxSize == 0 {
-		maxSize = 1
+	var run, display Runner = execRunner{}, execRunner{userLocale: true}
+	if *logFlag != "" {
+		logFile, err := openOperationLog(*logFlag, *logLevelFlag == "debug")
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "gaur: operation log: %v\n", err)
+		} else {
+			defer logFile.Close()
+			if *logLevelFlag == "debug" {
+				run, display = loggingRunner{run}, loggingRunner{display}
+			}
+		}
 	}
-	
-	systemBarWidth := int(float64(m.dashboard.TotalSizeBytes) / float64(maxSize) * float64(availableBarWidth))
-	cacheBarWidth := int(float64(m.dashboard.CleanerSizeBytes) / float64(maxSize) * float64(availableBarWidth))
-	if systemBarWidth < 1 {
-		systemBarWidth = 1
+
+	// The backend is chosen before the config loads, whose default cache
+	// directories depend on it; aur_helper applies unless --helper is given
+	helper = detectBackend()
+	if *helperFlag != "" {
+		if err := validateHelperName(*helperFlag); err != nil {
+			fmt.Printf("--helper: %v\n", err)
+			os.Exit(1)
+		}
+		helper = backendFor(*helperFlag)
 	}
-	if cacheBarWidth < 1 && m.dashboard.CleanerSizeBytes > 0 {
-		cacheBarWidth = 1
+
+	if *rebuildBatchFlag < 1 {
+		fmt.Println("--rebuild-batch-size must be at least 1")
+		os.Exit(1)
 	}
-	
-	systemBar := lipgloss.NewStyle().Background(cyanColor).Render(strings.Repeat(" ", systemBarWidth))
-	cacheBar := lipgloss.NewStyle().Background(orangeColor).Render(strings.Repeat(" ", cacheBarWidth))
-	
-	dashboard.WriteString(renderBarLine("System", systemBar, m.dashboard.TotalSize) + "\n")
-	dashboard.WriteString(renderBarLine("Cache", cacheBar, m.dashboard.CleanerSize) + "\n\n")
+	rebuildBatchSize = *rebuildBatchFlag
+	useFzf = *useFzfFlag
 
-	// ═══════════════════════════════════════════════════════
-	// Top 10 Packages by Size
-	// ═══════════════════════════════════════════════════════
-	if len(m.dashboard.TopPackages) > 0 {
-		topTitle := lipgloss.NewStyle().Bold(true).Foreground(lipgloss.Color("229")).
-			Render("🏆 Top 10 Packages by Size")
-		dashboard.WriteString(topTitle + "\n")
-		
-		for i, pkg := range m.dashboard.TopPackages {
-			rankStyle := lipgloss.NewStyle().Foreground(lipgloss.Color("240"))
-			nameStyle := lipgloss.NewStyle().Foreground(cyanColor)
-			sizeStyle := lipgloss.NewStyle().Foreground(yellowColor)
-			
-			dashboard.WriteString(fmt.Sprintf("  %s %s %s\n",
-				rankStyle.Render(fmt.Sprintf("%2d.", i+1)),
-				nameStyle.Render(fmt.Sprintf("%-30s", pkg.Name)),
-				sizeStyle.Render(pkg.Size)))
-		}
-		dashboard.WriteString("\n")
+	// Custom themes load first so the config file and --theme can name them
+	if err := loadCustomThemes(themesDir()); err != nil {
+		fmt.Printf("Invalid theme: %v\n", err)
+		os.Exit(1)
 	}
 
-	// ═══════════════════════════════════════════════════════
-	// Interactive Actions Footer
-	// ═══════════════════════════════════════════════════════
-	// actionsStyle := lipgloss.NewStyle().Foreground(dimColor)
-	// var actions []string
-	// actions = append(actions, "[c] Clean cache")
-	// if m.dashboard.Orphans > 0 {
-	// 	actions = append(actions, lipgloss.NewStyle().Foreground(redColor).Render("[R] Remove orphans"))
-	// }
-	// actions = append(actions, "[esc] back")
-	// actions = append(actions, "[q] quit")
-	
-	// actionsText := actionsStyle.Render("  " + strings.Join(actions, " │ "))
-	// dashboard.WriteString(actionsText)
+	// Load persisted settings
+	m := initialModel()
+	m.runner, m.displayRunner = run, display
+	config, configRestored, err := loadConfig(m.configPath)
+	if err != nil {
+		fmt.Printf("Invalid config: %v\n", err)
+		os.Exit(1)
+	}
+	m.settings = config.Settings
+	aurRPC.setConcurrency(config.Settings.PrefetchConcurrency)
+	m.packageSets = config.Sets
+	m.cacheDirs = config.CacheDirs
+	m.monitoredCaches = config.MonitoredCaches
+	m.removalFlags = config.RemovalFlags
+	m.keys = config.Keys
+	m.startMode = startModes[config.DefaultMode]
+	if *modeFlag != "" {
+		name := *modeFlag
+		if name == "dashboard" {
+			name = "info"
+		}
+		mode, ok := startModes[name]
+		if !ok {
+			fmt.Printf("--mode: unknown mode %q (expected install, remove, update, dashboard or history)\n", *modeFlag)
+			os.Exit(1)
+		}
+		m.startMode = mode
+	}
+	// The query is applied once the start mode's packages load
+	if *queryFlag != "" {
+		m.textInput.SetValue(*queryFlag)
+		if m.startMode == modeInstall || m.startMode == modeUninstall || m.startMode == modeHistory {
+			m.textInput.Focus()
+		}
+	}
+	if *helperFlag == "" {
+		helper = backendFor(config.AURHelper)
+	}
+	for _, name := range config.Holds {
+		m.holds[name] = true
+	}
+	m.pins = parsePins(config.Pins)
 
-	dashContent := lipgloss.NewStyle().
-		Width(contentWidth-2).
-		Height(contentHeight-3).
-		Padding(0, 1).
-		Render(dashboard.String())
+	// Load persisted state, noting files recovered from their backups
+	var notices []string
+	if configRestored {
+		notices = append(notices, restoredNotice("config file", m.configPath))
+	}
+	var localRestored bool
+	m.localPackages, localRestored = loadLocalPackages(localPackagesPath())
+	if localRestored {
+		notices = append(notices, restoredNotice("local packages file", localPackagesPath()))
+	}
+	var historyRestored bool
+	m.queryHistory, historyRestored = loadQueryHistory(queryHistoryPath())
+	if historyRestored {
+		notices = append(notices, restoredNotice("search history", queryHistoryPath()))
+	}
+	if len(notices) > 0 {
+		m.showErrorOverlay = true
+		m.errorTitle = "Storage Recovered"
+		m.errorMessage = "A file was damaged, probably by an interrupted write, and was replaced by its last good version."
+		m.errorDetails = strings.Join(notices, "\n\n")
+		m.sessionWarnings = append(m.sessionWarnings, notices...)
+	}
 
-	dashPanel := borderStyle.
-		Width(contentWidth).
-		Height(contentHeight - 1).
-		Render(dashContent)
+	// Check for the external tools, after the config has picked the backend
+	m.toolNotices, err = probeTools()
+	if err != nil {
+		m.showErrorOverlay = true
+		m.errorTitle = "Missing Dependency"
+		m.errorMessage = "gaur manages packages through pacman, which was not found in PATH. Nothing can be searched or installed without it."
+		m.errorDetails = fmt.Sprintf("%v\n\ngaur runs on Arch Linux and Arch-based distributions, where pacman is part of the base system. If it is installed outside PATH, add its directory to PATH.\n\nFor AUR packages, also install paru or yay:\n\n    https://github.com/Morganamilo/paru\n    https://github.com/Jguer/yay", err)
+		m.sessionWarnings = append(m.sessionWarnings, m.errorTitle)
+	}
 
-	return lipgloss.JoinVertical(lipgloss.Left, dashPanel, footerLine)
-}
+	// Handle --stats and --stats-field, which print without starting the TUI
+	if *statsFlag || *statsFieldFlag != "" {
+		if err == nil {
+			err = printStats(os.Stdout, m, *statsFieldFlag)
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "gaur: %v\n", err)
+			os.Exit(1)
+		}
+		return
+	}
 
-func main() {
-	themeFlag := flag.String("theme", "", "Color theme (use --list-themes to see options)")
-	listThemesFlag := flag.Bool("list-themes", false, "List available themes and exit")
-	flag.Parse()
+	// -S and -R start on the confirmation of the named packages; other
+	// subcommands print their results without starting the TUI
+	if *installFlag || *removeFlag {
+		if *installFlag && *removeFlag {
+			err = fmt.Errorf("-S and -R can't be combined")
+		}
+		if err == nil {
+			err = m.openOneShot(*installFlag, flag.Args())
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "gaur: %v\n", err)
+			os.Exit(1)
+		}
+		m.exitAfter = *exitAfterFlag
+	} else if flag.NArg() > 0 {
+		if err == nil {
+			err = runSubcommand(run, os.Stdout, flag.Args())
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "gaur: %v\n", err)
+			os.Exit(1)
+		}
+		return
+	}
 
 	// Handle --list-themes
 	if *listThemesFlag {
@@ -4268,10 +18595,28 @@ func main() {
 		return
 	}
 
-	// Apply theme if specified
+	// Themes use hex colors; approximate them on 256-color terminals and
+	// fall back to the Basic theme on 16-color ones
+	if *forceTruecolorFlag {
+		lipgloss.SetColorProfile(termenv.TrueColor)
+	} else {
+		switch detectColorProfile() {
+		case termenv.ANSI256:
+			quantizeColors = true
+			m.statusMessage = "256-color terminal: theme colors approximated (--force-truecolor to disable)"
+		case termenv.ANSI:
+			ansi16Colors = true
+		}
+	}
+
+	// Apply the theme from --theme, else from the config file
+	selectedTheme := themeCatppuccinMocha
+	if config.Theme != "" {
+		selectedTheme, _ = getThemeByName(config.Theme)
+	}
 	if *themeFlag != "" {
 		if t, ok := getThemeByName(*themeFlag); ok {
-			setTheme(t)
+			selectedTheme = t
 		} else {
 			fmt.Printf("Unknown theme: %s\nAvailable themes:\n", *themeFlag)
 			for _, name := range listThemes() {
@@ -4280,10 +18625,30 @@ func main() {
 			os.Exit(1)
 		}
 	}
+	if ansi16Colors && !themes[selectedTheme].fitsANSI16() {
+		m.statusMessage = fmt.Sprintf("16-color terminal: using Basic instead of %s (--force-truecolor to disable)", themes[selectedTheme].Name)
+	}
+	setTheme(selectedTheme)
 
-	p := tea.NewProgram(initialModel(), tea.WithAltScreen())
-	if _, err := p.Run(); err != nil {
+	freeBefore, haveFreeBefore := filesystemFreeBytes("/")
+
+	p := tea.NewProgram(m, tea.WithAltScreen(), tea.WithMouseCellMotion())
+	finalModel, err := p.Run()
+	if err != nil {
 		fmt.Printf("Error running program: %v\n", err)
 		os.Exit(1)
 	}
+
+	// Print what happened once the alt screen is gone
+	if fm, ok := finalModel.(model); ok && !*noSummaryFlag {
+		warnings := fm.sessionWarnings
+		if len(fm.recoveryFindings) > 0 {
+			warnings = append(warnings, "An interrupted pacman transaction is still unresolved")
+		}
+		var freed int64
+		if freeAfter, ok := filesystemFreeBytes("/"); ok && haveFreeBefore {
+			freed = freeAfter - freeBefore
+		}
+		fmt.Print(renderSessionSummary(fm.sessionOps, warnings, freed, isTerminal(os.Stdout)))
+	}
 }
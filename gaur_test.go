@@ -0,0 +1,3561 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+	"unicode/utf8"
+
+	"github.com/BurntSushi/toml"
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/lipgloss"
+	"github.com/muesli/termenv"
+)
+
+// fakeOutput is what a scripted command writes and how it exits
+type fakeOutput struct {
+	stdout, stderr string
+	err            error
+}
+
+// fakeRunner replays scripted output keyed by the full command line and
+// records every command it is asked to run. Unscripted commands fail.
+type fakeRunner struct {
+	mu      sync.Mutex
+	outputs map[string]fakeOutput
+	calls   []string
+}
+
+func (f *fakeRunner) output(name string, args []string) fakeOutput {
+	line := strings.Join(append([]string{name}, args...), " ")
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.calls = append(f.calls, line)
+	out, ok := f.outputs[line]
+	if !ok {
+		out.err = fmt.Errorf("unscripted command %q", line)
+	}
+	return out
+}
+
+func (f *fakeRunner) Run(name string, args ...string) (string, string, error) {
+	out := f.output(name, args)
+	return out.stdout, out.stderr, out.err
+}
+
+func (f *fakeRunner) Combined(name string, args ...string) (string, error) {
+	out := f.output(name, args)
+	return out.stdout + out.stderr, out.err
+}
+
+func (f *fakeRunner) Stream(ctx context.Context, read func(io.Reader) error, name string, args ...string) (string, error) {
+	out := f.output(name, args)
+	if err := read(strings.NewReader(out.stdout)); err != nil {
+		return out.stderr, err
+	}
+	return out.stderr, out.err
+}
+
+// ran reports whether the runner was asked to run line
+func (f *fakeRunner) ran(line string) bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for _, call := range f.calls {
+		if call == line {
+			return true
+		}
+	}
+	return false
+}
+
+// useHelper runs the test against the named backend
+func useHelper(t *testing.T, name string) {
+	t.Helper()
+	saved := helper
+	helper = backendFor(name)
+	t.Cleanup(func() { helper = saved })
+}
+
+// roundTripFunc serves HTTP requests from a function
+type roundTripFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripFunc) RoundTrip(req *http.Request) (*http.Response, error) { return f(req) }
+
+// offlineAUR makes every AUR RPC request fail for the test
+func offlineAUR(t *testing.T) {
+	t.Helper()
+	saved := aurRPC
+	aurRPC = &aurClient{
+		http: &http.Client{Transport: roundTripFunc(func(*http.Request) (*http.Response, error) {
+			return nil, errors.New("offline")
+		})},
+		cache: make(map[string]aurSearchCacheEntry),
+	}
+	t.Cleanup(func() { aurRPC = saved })
+}
+
+// testModel returns a loaded model in mode with a few results in every list
+func testModel(mode viewMode) model {
+	m := initialModel()
+	m.width, m.height = 120, 40
+	m.loading = false
+	m.mode = mode
+	var packages []Package
+	for i := 0; i < 5; i++ {
+		packages = append(packages, Package{Name: fmt.Sprintf("pkg%d", i), Source: "extra"})
+	}
+	m.setRepoPackages(packages, nil)
+	m.filtered = append([]Package(nil), packages...)
+	m.installed = append([]Package(nil), packages...)
+	m.filteredInstalled = append([]Package(nil), packages...)
+	for i := 0; i < 5; i++ {
+		m.filteredHistory = append(m.filteredHistory, historyEntry{action: "installed", pkg: fmt.Sprintf("pkg%d", i)})
+	}
+	m.classifiedUpdates = []classifiedUpdate{{pkg: Package{Name: "pkg0", Source: "extra"}}}
+	return m
+}
+
+// press feeds keys to Update one after another
+func press(t *testing.T, m model, keys ...string) model {
+	t.Helper()
+	for _, key := range keys {
+		next, _ := m.Update(keyMsgFor(key))
+		var ok bool
+		if m, ok = next.(model); !ok {
+			t.Fatalf("Update(%q) returned %T", key, next)
+		}
+	}
+	return m
+}
+
+// modeKeyFixture gives the model dashboard state under which every dashboard
+// key acts, in every mode, so the list modes show they ignore those keys
+func modeKeyFixture(m *model) {
+	r := &fakeRunner{outputs: map[string]fakeOutput{
+		"paru -Qdtq": {stdout: "orphan1\norphan2\n"},
+		"pacman -Qm": {stdout: "foreign1 1.0-1\n"},
+	}}
+	m.runner, m.displayRunner = r, r
+	m.dashboard.Orphans = 2
+	m.dashboard.ForeignPackages = 1
+	m.dashboard.TopPackages = []PackageSize{{Name: "pkg3", Size: "1.0 MiB", Bytes: 1 << 20, Source: "extra"}}
+	m.unmanaged = []unmanagedPackage{{name: "foreign1", pkgBase: "foreign1"}}
+	m.holds = map[string]bool{"pkg1": true}
+	m.auditChecked = true
+	m.audit = auditCheckMsg{installed: true, findings: []auditFinding{{name: "pkg2", severity: "High"}}}
+	m.health = systemHealthMsg{systemd: true, failedUnits: []string{"foo.service"}}
+}
+
+// keyIgnored checks that a key left a fixture model of mode as it was
+func keyIgnored(mode viewMode) func(model) error {
+	return func(m model) error {
+		want := testModel(mode)
+		overlays := []bool{m.showConfirmation, m.namingSet, m.showUnmanaged, m.showHolds, m.showAudit, m.showPacnew,
+			m.showCacheDirs, m.showBreakdown, m.showCleanup, m.showFailedUnits, m.showFileList}
+		for i, shown := range overlays {
+			if shown {
+				return fmt.Errorf("overlay %d shown", i)
+			}
+		}
+		if m.mode != mode || m.loading || m.textInput.Focused() || m.textInput.Value() != "" {
+			return fmt.Errorf("mode %v loading %v focused %v query %q", m.mode, m.loading, m.textInput.Focused(), m.textInput.Value())
+		}
+		if m.selectedIndex != 0 || m.dashboardSelected != 0 || len(m.markedPackages) > 0 {
+			return fmt.Errorf("selected %d dashboard %d marks %v", m.selectedIndex, m.dashboardSelected, m.markedPackages)
+		}
+		if m.hideInstalled || m.resultSorts[mode] != want.resultSorts[mode] || m.statusMessage != want.statusMessage {
+			return fmt.Errorf("hide %v sort %v status %q", m.hideInstalled, m.resultSorts[mode], m.statusMessage)
+		}
+		if calls := m.runner.(*fakeRunner).calls; len(calls) > 0 {
+			return fmt.Errorf("ran %v", calls)
+		}
+		return nil
+	}
+}
+
+func TestModeKeys(t *testing.T) {
+	type modeKeyTest struct {
+		name  string
+		mode  viewMode
+		focus bool
+		keys  []string
+		check func(model) error
+	}
+	toTopPackage := strings.Fields(strings.Repeat("j ", 11))
+	tests := []modeKeyTest{
+		{"install enter confirms", modeInstall, false, []string{"enter"}, func(m model) error {
+			if !m.showConfirmation || m.confirmType != confirmInstall || m.statusMessage != "Confirm installation" {
+				return fmt.Errorf("confirmation %v type %v status %q", m.showConfirmation, m.confirmType, m.statusMessage)
+			}
+			return nil
+		}},
+		{"install tab marks", modeInstall, false, []string{"tab"}, func(m model) error {
+			if !m.markedPackages["pkg0"] || m.statusMessage != "1 packages marked" {
+				return fmt.Errorf("marks %v status %q", m.markedPackages, m.statusMessage)
+			}
+			return nil
+		}},
+		{"install slash focuses", modeInstall, false, []string{"/"}, func(m model) error {
+			if !m.textInput.Focused() {
+				return fmt.Errorf("input not focused")
+			}
+			return nil
+		}},
+		{"install ignores dashboard keys", modeInstall, false, []string{"t", "e", "f", "1"}, func(m model) error {
+			if m.mode != modeInstall || m.textInput.Value() != "" {
+				return fmt.Errorf("mode %v query %q", m.mode, m.textInput.Value())
+			}
+			return nil
+		}},
+		{"install typing keeps mode keys", modeInstall, true, []string{"r", "e", "*", "n", "u"}, func(m model) error {
+			if m.mode != modeInstall || m.textInput.Value() != "re*nu" {
+				return fmt.Errorf("mode %v query %q", m.mode, m.textInput.Value())
+			}
+			return nil
+		}},
+		{"remove enter confirms", modeUninstall, false, []string{"enter"}, func(m model) error {
+			if !m.showConfirmation || m.confirmType != confirmUninstall {
+				return fmt.Errorf("confirmation %v type %v", m.showConfirmation, m.confirmType)
+			}
+			return nil
+		}},
+		{"remove ignores dashboard keys", modeUninstall, false, []string{"e", "t", "3"}, func(m model) error {
+			if m.mode != modeUninstall || m.textInput.Value() != "" || m.loading {
+				return fmt.Errorf("mode %v query %q loading %v", m.mode, m.textInput.Value(), m.loading)
+			}
+			return nil
+		}},
+		{"remove i switches to install", modeUninstall, false, []string{"i"}, func(m model) error {
+			if m.mode != modeInstall {
+				return fmt.Errorf("mode %v", m.mode)
+			}
+			return nil
+		}},
+		{"history k moves up", modeHistory, false, []string{"k"}, func(m model) error {
+			if m.selectedIndex != 1 {
+				return fmt.Errorf("selected %d", m.selectedIndex)
+			}
+			return nil
+		}},
+		{"history k then j moves back", modeHistory, false, []string{"k", "k", "j"}, func(m model) error {
+			if m.selectedIndex != 1 {
+				return fmt.Errorf("selected %d", m.selectedIndex)
+			}
+			return nil
+		}},
+		{"dashboard j moves the cursor", modeInstalled, false, []string{"j"}, func(m model) error {
+			if m.dashboardSelected != 1 || m.selectedIndex != 0 {
+				return fmt.Errorf("dashboard %d selected %d", m.dashboardSelected, m.selectedIndex)
+			}
+			return nil
+		}},
+		{"dashboard e filters remove mode", modeInstalled, false, []string{"e"}, func(m model) error {
+			if m.mode != modeUninstall || m.textInput.Value() != "e:" {
+				return fmt.Errorf("mode %v query %q", m.mode, m.textInput.Value())
+			}
+			return nil
+		}},
+		{"dashboard ignores list keys", modeInstalled, false, []string{"tab", "/", "s"}, func(m model) error {
+			if m.mode != modeInstalled || len(m.markedPackages) > 0 || m.textInput.Focused() {
+				return fmt.Errorf("mode %v marks %v focused %v", m.mode, m.markedPackages, m.textInput.Focused())
+			}
+			return nil
+		}},
+		{"update enter confirms", modeUpdate, false, []string{"enter"}, func(m model) error {
+			if !m.showConfirmation || m.confirmType != confirmUpdate {
+				return fmt.Errorf("confirmation %v type %v", m.showConfirmation, m.confirmType)
+			}
+			return nil
+		}},
+		{"update D toggles devel", modeUpdate, false, []string{"D"}, func(m model) error {
+			if !m.develUpdates || !m.loading {
+				return fmt.Errorf("devel %v loading %v", m.develUpdates, m.loading)
+			}
+			return nil
+		}},
+		{"update ignores list keys", modeUpdate, false, []string{"tab", "/", "e"}, func(m model) error {
+			if m.mode != modeUpdate || len(m.markedPackages) > 0 || m.textInput.Focused() {
+				return fmt.Errorf("mode %v marks %v focused %v", m.mode, m.markedPackages, m.textInput.Focused())
+			}
+			return nil
+		}},
+		{"global n opens the dashboard", modeUpdate, false, []string{"n"}, func(m model) error {
+			if m.mode != modeInstalled {
+				return fmt.Errorf("mode %v", m.mode)
+			}
+			return nil
+		}},
+		{"global h opens history", modeInstalled, false, []string{"h"}, func(m model) error {
+			if m.mode != modeHistory {
+				return fmt.Errorf("mode %v", m.mode)
+			}
+			return nil
+		}},
+		{"dashboard c confirms cache cleaning", modeInstalled, false, []string{"c"}, func(m model) error {
+			if !m.showConfirmation || m.confirmType != confirmCleanCache {
+				return fmt.Errorf("confirmation %v type %v", m.showConfirmation, m.confirmType)
+			}
+			return nil
+		}},
+		{"dashboard R confirms orphan removal", modeInstalled, false, []string{"R"}, func(m model) error {
+			if !m.showConfirmation || m.confirmType != confirmRemoveOrphans || fmt.Sprint(m.confirmPackages) != "[orphan1 orphan2]" {
+				return fmt.Errorf("confirmation %v type %v packages %v", m.showConfirmation, m.confirmType, m.confirmPackages)
+			}
+			return nil
+		}},
+		{"dashboard S confirms a database sync", modeInstalled, false, []string{"S"}, func(m model) error {
+			if !m.showConfirmation || m.confirmType != confirmSyncDB {
+				return fmt.Errorf("confirmation %v type %v", m.showConfirmation, m.confirmType)
+			}
+			return nil
+		}},
+		{"dashboard B confirms a foreign rebuild", modeInstalled, false, []string{"B"}, func(m model) error {
+			if !m.showConfirmation || m.confirmType != confirmRebuildForeign || len(m.rebuildCandidates) != 1 {
+				return fmt.Errorf("confirmation %v type %v candidates %v", m.showConfirmation, m.confirmType, m.rebuildCandidates)
+			}
+			return nil
+		}},
+		{"dashboard U lists unmanaged packages", modeInstalled, false, []string{"U"}, func(m model) error {
+			if !m.showUnmanaged {
+				return fmt.Errorf("unmanaged not shown")
+			}
+			return nil
+		}},
+		{"dashboard H lists holds", modeInstalled, false, []string{"H"}, func(m model) error {
+			if !m.showHolds {
+				return fmt.Errorf("holds not shown")
+			}
+			return nil
+		}},
+		{"dashboard A lists vulnerabilities", modeInstalled, false, []string{"A"}, func(m model) error {
+			if !m.showAudit {
+				return fmt.Errorf("audit not shown")
+			}
+			return nil
+		}},
+		{"dashboard P reports no pacnew files", modeInstalled, false, []string{"P"}, func(m model) error {
+			if m.showPacnew || m.statusMessage != "No .pacnew or .pacsave files to merge" {
+				return fmt.Errorf("pacnew %v status %q", m.showPacnew, m.statusMessage)
+			}
+			return nil
+		}},
+		{"dashboard D picks cache directories", modeInstalled, false, []string{"D"}, func(m model) error {
+			if !m.showCacheDirs || m.develUpdates {
+				return fmt.Errorf("cache dirs %v devel %v", m.showCacheDirs, m.develUpdates)
+			}
+			return nil
+		}},
+		{"dashboard C breaks down the cache", modeInstalled, false, []string{"C"}, func(m model) error {
+			if !m.showBreakdown {
+				return fmt.Errorf("breakdown not shown")
+			}
+			return nil
+		}},
+		{"dashboard M opens the cleanup wizard", modeInstalled, false, []string{"M"}, func(m model) error {
+			if !m.showCleanup {
+				return fmt.Errorf("cleanup not shown")
+			}
+			return nil
+		}},
+		{"dashboard F lists failed units", modeInstalled, false, []string{"F"}, func(m model) error {
+			if !m.showFailedUnits || m.showFileList {
+				return fmt.Errorf("failed units %v file list %v", m.showFailedUnits, m.showFileList)
+			}
+			return nil
+		}},
+		{"dashboard o filters orphans", modeInstalled, false, []string{"o"}, func(m model) error {
+			if m.mode != modeUninstall || m.textInput.Value() != "o:" {
+				return fmt.Errorf("mode %v query %q", m.mode, m.textInput.Value())
+			}
+			return nil
+		}},
+		{"dashboard enter opens the first row", modeInstalled, false, []string{"enter"}, func(m model) error {
+			if m.mode != modeUninstall || m.textInput.Value() != "t:" {
+				return fmt.Errorf("mode %v query %q", m.mode, m.textInput.Value())
+			}
+			return nil
+		}},
+		{"dashboard enter on orphans confirms removal", modeInstalled, false, []string{"j", "j", "j", "j", "enter"}, func(m model) error {
+			if !m.showConfirmation || m.confirmType != confirmRemoveOrphans {
+				return fmt.Errorf("confirmation %v type %v", m.showConfirmation, m.confirmType)
+			}
+			return nil
+		}},
+		{"dashboard enter on a top package selects it", modeInstalled, false, append(toTopPackage, "enter"), func(m model) error {
+			if m.mode != modeUninstall || m.selectAfterLoad != "pkg3" {
+				return fmt.Errorf("mode %v select %q", m.mode, m.selectAfterLoad)
+			}
+			return nil
+		}},
+		{"dashboard Q removes a top package", modeInstalled, false, append(toTopPackage, "Q"), func(m model) error {
+			if !m.showConfirmation || m.confirmType != confirmUninstall || fmt.Sprint(m.confirmPackages) != "[pkg3]" {
+				return fmt.Errorf("confirmation %v type %v packages %v", m.showConfirmation, m.confirmType, m.confirmPackages)
+			}
+			return nil
+		}},
+		{"update u checks again", modeUpdate, false, []string{"u"}, func(m model) error {
+			if m.mode != modeUpdate || !m.loading || !m.newsLoading || m.classifiedUpdates != nil {
+				return fmt.Errorf("mode %v loading %v news %v updates %v", m.mode, m.loading, m.newsLoading, m.classifiedUpdates)
+			}
+			return nil
+		}},
+		{"update r switches to remove", modeUpdate, false, []string{"r"}, func(m model) error {
+			if m.mode != modeUninstall || !m.loading {
+				return fmt.Errorf("mode %v loading %v", m.mode, m.loading)
+			}
+			return nil
+		}},
+		{"update i switches to install", modeUpdate, false, []string{"i"}, func(m model) error {
+			if m.mode != modeInstall {
+				return fmt.Errorf("mode %v", m.mode)
+			}
+			return nil
+		}},
+		{"update h opens history", modeUpdate, false, []string{"h"}, func(m model) error {
+			if m.mode != modeHistory {
+				return fmt.Errorf("mode %v", m.mode)
+			}
+			return nil
+		}},
+		{"update D waits for the running check", modeUpdate, false, []string{"D", "D"}, func(m model) error {
+			if !m.develUpdates || !m.loading {
+				return fmt.Errorf("devel %v loading %v", m.develUpdates, m.loading)
+			}
+			return nil
+		}},
+		{"install s cycles the order", modeInstall, false, []string{"s"}, func(m model) error {
+			if m.resultSorts[modeInstall] == sortRelevance {
+				return fmt.Errorf("sort %v", m.resultSorts[modeInstall])
+			}
+			return nil
+		}},
+		{"remove s cycles the order", modeUninstall, false, []string{"s"}, func(m model) error {
+			if m.resultSorts[modeUninstall] == sortRelevance {
+				return fmt.Errorf("sort %v", m.resultSorts[modeUninstall])
+			}
+			return nil
+		}},
+		{"install I hides installed packages", modeInstall, false, []string{"I"}, func(m model) error {
+			if !m.hideInstalled {
+				return fmt.Errorf("installed shown")
+			}
+			return nil
+		}},
+		{"remove I does nothing", modeUninstall, false, []string{"I"}, keyIgnored(modeUninstall)},
+		{"install S names a set of the marks", modeInstall, false, []string{"tab", "S"}, func(m model) error {
+			if !m.namingSet || !m.textInput.Focused() {
+				return fmt.Errorf("naming %v focused %v", m.namingSet, m.textInput.Focused())
+			}
+			return nil
+		}},
+		{"remove y copies the selection", modeUninstall, false, []string{"/", "z", "z", "z", "esc", "y"}, func(m model) error {
+			if m.statusMessage != "No package selected" {
+				return fmt.Errorf("status %q", m.statusMessage)
+			}
+			return nil
+		}},
+		{"remove Y copies an install command", modeUninstall, false, []string{"/", "z", "z", "z", "esc", "Y"}, func(m model) error {
+			if m.statusMessage != "No package selected" {
+				return fmt.Errorf("status %q", m.statusMessage)
+			}
+			return nil
+		}},
+		{"install o opens the upstream URL", modeInstall, false, []string{"o"}, func(m model) error {
+			if m.statusMessage != "pkg0 has no upstream URL" {
+				return fmt.Errorf("status %q", m.statusMessage)
+			}
+			return nil
+		}},
+		{"remove O opens the package page", modeUninstall, false, []string{"/", "z", "z", "z", "esc", "O"}, func(m model) error {
+			if m.statusMessage != "No package selected" {
+				return fmt.Errorf("status %q", m.statusMessage)
+			}
+			return nil
+		}},
+		{"remove F lists files", modeUninstall, false, []string{"F"}, func(m model) error {
+			if !m.showFileList || m.fileList.pkg != "pkg0" || m.showFailedUnits {
+				return fmt.Errorf("file list %v pkg %q failed units %v", m.showFileList, m.fileList.pkg, m.showFailedUnits)
+			}
+			return nil
+		}},
+		{"install G jumps to the last result", modeInstall, false, []string{"G"}, func(m model) error {
+			if m.selectedIndex != 4 {
+				return fmt.Errorf("selected %d", m.selectedIndex)
+			}
+			return nil
+		}},
+		{"install g jumps back to the first", modeInstall, false, []string{"G", "g"}, func(m model) error {
+			if m.selectedIndex != 0 {
+				return fmt.Errorf("selected %d", m.selectedIndex)
+			}
+			return nil
+		}},
+		{"history G jumps to the last entry", modeHistory, false, []string{"G"}, func(m model) error {
+			if m.selectedIndex != 4 {
+				return fmt.Errorf("selected %d", m.selectedIndex)
+			}
+			return nil
+		}},
+		{"install pgup pages up", modeInstall, false, []string{"pgup"}, func(m model) error {
+			if m.selectedIndex != 4 {
+				return fmt.Errorf("selected %d", m.selectedIndex)
+			}
+			return nil
+		}},
+		{"remove pgdown pages down", modeUninstall, false, []string{"G", "pgdown"}, func(m model) error {
+			if m.selectedIndex != 0 {
+				return fmt.Errorf("selected %d", m.selectedIndex)
+			}
+			return nil
+		}},
+		{"install pgup pages while typing", modeInstall, true, []string{"pgup"}, func(m model) error {
+			if m.selectedIndex != 4 || !m.textInput.Focused() {
+				return fmt.Errorf("selected %d focused %v", m.selectedIndex, m.textInput.Focused())
+			}
+			return nil
+		}},
+	}
+
+	// Every dashboard key is ignored by the modes without it, and every
+	// list key is ignored by the dashboard and update mode, and typed while
+	// the input is focused
+	dashboardKeys := []string{"c", "R", "S", "B", "U", "H", "A", "P", "D", "C", "M", "F"}
+	listKeys := []string{"s", "I", "y", "Y", "o", "O", "F", "g", "G", "pgup", "pgdown"}
+	modeNames := map[viewMode]string{
+		modeInstall:   "install",
+		modeUninstall: "remove",
+		modeHistory:   "history",
+		modeUpdate:    "update",
+		modeInstalled: "dashboard",
+	}
+	handles := map[viewMode]map[string]bool{
+		modeInstalled: {"o": true, "F": true},
+		modeUninstall: {"F": true},
+		modeUpdate:    {"D": true},
+	}
+	for _, mode := range []viewMode{modeInstall, modeUninstall, modeHistory, modeUpdate} {
+		for _, key := range dashboardKeys {
+			if !handles[mode][key] {
+				tests = append(tests, modeKeyTest{fmt.Sprintf("%s ignores dashboard %s", modeNames[mode], key), mode, false, []string{key}, keyIgnored(mode)})
+			}
+		}
+	}
+	for _, mode := range []viewMode{modeInstalled, modeUpdate} {
+		for _, key := range listKeys {
+			if !handles[mode][key] {
+				tests = append(tests, modeKeyTest{fmt.Sprintf("%s ignores list %s", modeNames[mode], key), mode, false, []string{key}, keyIgnored(mode)})
+			}
+		}
+	}
+	for _, mode := range []viewMode{modeInstall, modeUninstall} {
+		for _, key := range listKeys {
+			if key == "pgup" || key == "pgdown" {
+				continue
+			}
+			want := key
+			tests = append(tests, modeKeyTest{fmt.Sprintf("%s typing takes %s", modeNames[mode], key), mode, true, []string{key}, func(m model) error {
+				if m.mode != mode || m.textInput.Value() != want || m.showFileList || m.hideInstalled {
+					return fmt.Errorf("mode %v query %q file list %v hide %v", m.mode, m.textInput.Value(), m.showFileList, m.hideInstalled)
+				}
+				return nil
+			}})
+		}
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := testModel(tt.mode)
+			modeKeyFixture(&m)
+			if tt.focus {
+				m.textInput.Focus()
+			}
+			if err := tt.check(press(t, m, tt.keys...)); err != nil {
+				t.Error(err)
+			}
+		})
+	}
+}
+
+func TestLooksLikePrompt(t *testing.T) {
+	tests := []struct {
+		line string
+		want bool
+	}{
+		{":: Proceed with installation? [Y/n] ", true},
+		{":: Replace foo with extra/bar? [y/N]", true},
+		{"Enter a number (default=1): ", true},
+		{"[sudo] password for user: ", true},
+		{"==> ", true},
+		{"  compiling src/main.c:", false},
+		{"checking dependencies...", false},
+		{"(3/10) installing foo", false},
+		{"", false},
+	}
+	for _, tt := range tests {
+		if got := looksLikePrompt(tt.line); got != tt.want {
+			t.Errorf("looksLikePrompt(%q) = %v, want %v", tt.line, got, tt.want)
+		}
+	}
+}
+
+func TestTransactionBegins(t *testing.T) {
+	for line, want := range map[string]bool{
+		":: Processing package changes...":    true,
+		":: Running pre-transaction hooks...": true,
+		"==> Making package: foo 1.0-1":       true,
+		":: Proceed with installation? [Y/n]": false,
+		"resolving dependencies...":           false,
+		"==> Retrieving sources...":           false,
+	} {
+		if got := transactionBegins(line); got != want {
+			t.Errorf("transactionBegins(%q) = %v, want %v", line, got, want)
+		}
+	}
+}
+
+func TestCtrlCInterruptsStream(t *testing.T) {
+	m := testModel(modeInstall)
+	stop := m.beginStream("Installing")
+	m = press(t, m, "ctrl+c")
+	select {
+	case <-stop:
+	default:
+		t.Fatal("stream not interrupted")
+	}
+	if !m.streaming {
+		t.Error("ctrl+c left the log pane while the run is still ending")
+	}
+	// A second ctrl+c must not close the channel again
+	press(t, m, "ctrl+c")
+}
+
+func TestLoadRepoPackagesNow(t *testing.T) {
+	t.Setenv("XDG_CACHE_HOME", t.TempDir())
+	r := &fakeRunner{outputs: map[string]fakeOutput{
+		"pacman -Sl": {stdout: "core bash 5.2-1 [installed]\nextra vim 9.1-1\nextra git 2.45-1\n"},
+		"pacman -Qq": {stdout: "bash\ngit\n"},
+		"pacman -Sg": {stdout: "base-devel git\n"},
+		"pacman -Ss": {stdout: "extra/vim 9.1-1\n    Vi Improved\n"},
+	}}
+	packages, groups, err := loadRepoPackagesNow(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(packages) != 3 {
+		t.Fatalf("got %d packages, want 3", len(packages))
+	}
+	installed := map[string]bool{}
+	for _, pkg := range packages {
+		installed[pkg.Name] = pkg.Installed
+	}
+	if !installed["bash"] || !installed["git"] || installed["vim"] {
+		t.Errorf("installed state %v", installed)
+	}
+	if packages[1].Description != "Vi Improved" {
+		t.Errorf("vim description %q", packages[1].Description)
+	}
+	if fmt.Sprint(groups["base-devel"]) != "[git]" {
+		t.Errorf("groups %v", groups)
+	}
+}
+
+func TestLoadRepoPackagesNowReportsStderr(t *testing.T) {
+	t.Setenv("XDG_CACHE_HOME", t.TempDir())
+	r := &fakeRunner{outputs: map[string]fakeOutput{
+		"pacman -Sl": {stderr: "error: failed to init transaction\n", err: errors.New("exit status 1")},
+	}}
+	_, _, err := loadRepoPackagesNow(r)
+	if err == nil || err.Error() != "pacman -Sl failed: error: failed to init transaction" {
+		t.Errorf("err = %v", err)
+	}
+}
+
+func TestCheckUpdatesClassifiesSources(t *testing.T) {
+	useHelper(t, "paru")
+	offlineAUR(t)
+	r := &fakeRunner{outputs: map[string]fakeOutput{
+		"paru -Qu":   {stdout: "linux 6.9.1-1 -> 6.9.2-1\nfoo-git r10-1 -> latest-commit\n"},
+		"pacman -Qm": {stdout: "foo-git r10-1\n"},
+	}}
+	msg := checkUpdates(r, map[string]bool{"linux": true}, nil, false)().(updateCheckMsg)
+	sources := map[string]string{}
+	for _, u := range msg.updates {
+		sources[u.pkg.Name] = u.pkg.Source
+	}
+	if sources["linux"] != "repo" || sources["foo-git"] != "aur" {
+		t.Errorf("sources %v", sources)
+	}
+	for _, u := range msg.updates {
+		if u.pkg.Name == "linux" && u.class != updateHeld {
+			t.Errorf("held linux classified %v", u.class)
+		}
+	}
+}
+
+func TestClassifyUpdates(t *testing.T) {
+	entry := func(name string, ignored bool) updateEntry {
+		return updateEntry{pkg: Package{Name: name, OldVersion: "1-1", NewVersion: "2-1"}, ignored: ignored}
+	}
+	for _, tc := range []struct {
+		name  string
+		entry updateEntry
+		rules updateRules
+		want  updateClass
+	}{
+		{"nothing applies", entry("vim", false), updateRules{}, updateAvailable},
+		{"marked ignored by paru", entry("vim", true), updateRules{}, updateIgnored},
+		{"IgnorePkg name", entry("vim", false), updateRules{ignorePatterns: []string{"vim"}}, updateIgnored},
+		{"IgnorePkg glob", entry("linux-headers", false), updateRules{ignorePatterns: []string{"linux*"}}, updateIgnored},
+		{"IgnorePkg glob misses", entry("vim", false), updateRules{ignorePatterns: []string{"linux*"}}, updateAvailable},
+		{"IgnoreGroup member", entry("xorg-server", false), updateRules{ignoredGroups: map[string]bool{"xorg-server": true}}, updateIgnored},
+		{"held", entry("vim", false), updateRules{held: map[string]bool{"vim": true}}, updateHeld},
+		{"held other", entry("vim", false), updateRules{held: map[string]bool{"emacs": true}}, updateAvailable},
+		{"pinned", entry("vim", false), updateRules{pinned: map[string]string{"vim": "1-1"}}, updatePinned},
+		{"pinned at another version", entry("vim", false), updateRules{pinned: map[string]string{"vim": "0.9-1"}}, updatePinned},
+		{"pin wins over hold", entry("vim", false), updateRules{held: map[string]bool{"vim": true}, pinned: map[string]string{"vim": "1-1"}}, updatePinned},
+		{"ignore wins over hold", entry("vim", false), updateRules{ignorePatterns: []string{"vim"}, held: map[string]bool{"vim": true}}, updateIgnored},
+		{"ignore wins over pin", entry("vim", true), updateRules{pinned: map[string]string{"vim": "1-1"}}, updateIgnored},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			got := classifyUpdates([]updateEntry{tc.entry}, tc.rules)
+			if len(got) != 1 || got[0].class != tc.want || got[0].pkg.Name != tc.entry.pkg.Name {
+				t.Errorf("got %+v, want class %v", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestParsePacmanConfIgnores(t *testing.T) {
+	conf := "[options]\nIgnorePkg = linux linux-headers # kernels\n#IgnorePkg = vim\nIgnorePkg=mesa\nIgnoreGroup = xorg\n\n[core]\nIgnorePkg = not-options\n"
+	patterns, groups, err := parsePacmanConfIgnores(strings.NewReader(conf))
+	if err != nil || fmt.Sprint(patterns) != "[linux linux-headers mesa]" || fmt.Sprint(groups) != "[xorg]" {
+		t.Errorf("patterns %v, groups %v, err %v", patterns, groups, err)
+	}
+}
+
+func TestGroupMembers(t *testing.T) {
+	r := &fakeRunner{outputs: map[string]fakeOutput{
+		"pacman -Qg xorg gnome": {stdout: "xorg xorg-server\nxorg xorg-xrandr\n", err: errors.New("group 'gnome' was not found")},
+	}}
+	if got := groupMembers(r, []string{"xorg", "gnome", "bad;name"}); fmt.Sprint(sortedKeys(got)) != "[xorg-server xorg-xrandr]" {
+		t.Errorf("members %v", got)
+	}
+}
+
+func TestTogglePin(t *testing.T) {
+	m := testModel(modeUpdate)
+	m.configPath = filepath.Join(t.TempDir(), "config.toml")
+	m.holds["held"] = true
+	m.classifiedUpdates = []classifiedUpdate{
+		{pkg: Package{Name: "vim", OldVersion: "9.0-1", NewVersion: "9.1-1"}},
+		{pkg: Package{Name: "held", OldVersion: "1-1", NewVersion: "2-1"}, class: updateHeld},
+		{pkg: Package{Name: "linux", OldVersion: "6.8-1", NewVersion: "6.9-1"}, class: updateIgnored},
+	}
+	m.setPendingUpdates()
+
+	if err := m.togglePin("vim"); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.togglePin("held"); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.togglePin("linux"); err == nil {
+		t.Error("pinned a package pacman ignores")
+	}
+	if m.classifiedUpdates[0].class != updatePinned || len(m.pendingUpdates) != 0 {
+		t.Errorf("classes %+v, pending %v", m.classifiedUpdates, m.pendingUpdates)
+	}
+	if _, ignore := m.updateSelection(); fmt.Sprint(ignore) != "[vim held]" {
+		t.Errorf("ignored %v", ignore)
+	}
+	data, err := os.ReadFile(m.configPath)
+	if err != nil || !strings.Contains(string(data), `pins = ["held=1-1", "vim=9.0-1"]`) {
+		t.Errorf("config %q, %v", data, err)
+	}
+	var config Config
+	if _, err := toml.Decode(string(data), &config); err != nil || fmt.Sprint(parsePins(config.Pins)) != fmt.Sprint(m.pins) {
+		t.Errorf("pins read back as %v, %v", config.Pins, err)
+	}
+
+	// Unpinning a held package leaves it held
+	if err := m.togglePin("held"); err != nil || m.classifiedUpdates[1].class != updateHeld {
+		t.Errorf("unpinned held as %v, %v", m.classifiedUpdates[1].class, err)
+	}
+	if err := m.togglePin("vim"); err != nil || m.classifiedUpdates[0].class != updateAvailable || len(m.pendingUpdates) != 1 {
+		t.Errorf("unpinned vim as %v, pending %v, %v", m.classifiedUpdates[0].class, m.pendingUpdates, err)
+	}
+}
+
+func TestParsePins(t *testing.T) {
+	pins := parsePins([]string{"vim=9.0-1", "mesa=1:24.0-1", "no-version=", "=1-1", "bad name=1", "plain"})
+	if fmt.Sprint(pins) != "map[mesa:1:24.0-1 vim:9.0-1]" {
+		t.Errorf("pins %v", pins)
+	}
+	if got := fmt.Sprint(formatPins(pins)); got != "[mesa=1:24.0-1 vim=9.0-1]" {
+		t.Errorf("formatted %s", got)
+	}
+}
+
+func TestSearchAURFallsBackToHelper(t *testing.T) {
+	useHelper(t, "paru")
+	offlineAUR(t)
+	r := &fakeRunner{outputs: map[string]fakeOutput{
+		"paru -Ss -a foo": {stdout: "aur/foo 1.0-1 (+5 0.10)\n    The foo tool\naur/foo-bin 1.0-1 (+1 0.01)\n    Prebuilt foo\n"},
+	}}
+	msg := searchAUR(context.Background(), r, "foo", 7)().(aurSearchMsg)
+	if msg.err != nil || msg.seq != 7 {
+		t.Fatalf("err %v seq %d", msg.err, msg.seq)
+	}
+	var names []string
+	for _, pkg := range msg.packages {
+		names = append(names, pkg.Name)
+	}
+	if fmt.Sprint(names) != "[foo foo-bin]" {
+		t.Errorf("names %v", names)
+	}
+}
+
+func TestGetPackageInfoKeepsStderr(t *testing.T) {
+	r := &fakeRunner{outputs: map[string]fakeOutput{
+		"paru -Si vim": {stdout: "Name : vim\n", stderr: "warning: vim is out of date\n"},
+	}}
+	useHelper(t, "paru")
+	msg := getPackageInfo(r, r, Package{Name: "vim"})().(packageInfoMsg)
+	if !strings.Contains(msg.info, "Name : vim") || !strings.Contains(msg.info, "out of date") {
+		t.Errorf("info %q", msg.info)
+	}
+}
+
+// localizedCommand puts a command on PATH that prints english in the C
+// locale and german otherwise, as pacman and paru do
+func localizedCommand(t *testing.T, name, english, german string) {
+	t.Helper()
+	dir := t.TempDir()
+	for file, text := range map[string]string{"en": english, "de": german} {
+		if err := os.WriteFile(filepath.Join(dir, file), []byte(text), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	script := fmt.Sprintf("#!/bin/sh\nif [ \"$LC_ALL\" = C ]; then cat %s/en; else cat %s/de; fi\n", dir, dir)
+	if err := os.WriteFile(filepath.Join(dir, name), []byte(script), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("PATH", dir+string(os.PathListSeparator)+os.Getenv("PATH"))
+}
+
+func TestParseCommandsRunInCLocale(t *testing.T) {
+	t.Setenv("LANG", "de_DE.UTF-8")
+	t.Setenv("LC_ALL", "de_DE.UTF-8")
+	localizedCommand(t, "pacman",
+		"Name            : vim\nVersion         : 9.1-1\nDescription     : Vi Improved\nInstalled Size  : 4.00 MiB\n",
+		"Name            : vim\nVersion         : 9.1-1\nBeschreibung    : Vi Improved\nInstallationsgröße : 4,00 MiB\n")
+	localizedCommand(t, "paru",
+		"Total Size occupied by packages: 1.50 GiB\n",
+		"Gesamtgröße der Pakete: 1,50 GiB\n")
+
+	var run, display Runner = execRunner{}, execRunner{userLocale: true}
+	out, _, err := run.Run("pacman", "-Qi")
+	if err != nil {
+		t.Fatal(err)
+	}
+	packages, _ := parseInstalledPackages(run, out)
+	if len(packages) != 1 || packages[0].Description != "Vi Improved" || packages[0].SizeBytes != 4<<20 {
+		t.Errorf("parsed %+v", packages)
+	}
+	if out, err = run.Combined("paru", "-Ps"); err != nil {
+		t.Fatal(err)
+	}
+	if _, total, _, _ := parseParuStats(out); total != 1536<<20 {
+		t.Errorf("total size %d from %q", total, out)
+	}
+	if _, err = run.Stream(context.Background(), func(r io.Reader) error {
+		data, err := io.ReadAll(r)
+		out = string(data)
+		return err
+	}, "paru", "-Ps"); err != nil || !strings.HasPrefix(out, "Total Size") {
+		t.Errorf("streamed %q, %v", out, err)
+	}
+
+	// Output shown as is keeps the user's language
+	if out, err = display.Combined("paru", "-Ps"); err != nil || !strings.HasPrefix(out, "Gesamtgröße") {
+		t.Errorf("user locale printed %q, %v", out, err)
+	}
+}
+
+func TestPackageInfoShowsUserLocale(t *testing.T) {
+	useHelper(t, "paru")
+	english := "Name            : vim\nDescription     : Vi Improved\n"
+	german := "Name            : vim\nBeschreibung    : Vi Improved\n"
+	r := &fakeRunner{outputs: map[string]fakeOutput{"paru -Si vim": {stdout: english}}}
+	display := &fakeRunner{outputs: map[string]fakeOutput{"paru -Si vim": {stdout: german}}}
+
+	t.Setenv("LC_ALL", "")
+	t.Setenv("LANG", "en_US.UTF-8")
+	if msg := getPackageInfo(r, display, Package{Name: "vim"})().(packageInfoMsg); msg.localized != "" || len(display.calls) != 0 {
+		t.Errorf("english locale ran %v for %q", display.calls, msg.localized)
+	}
+
+	t.Setenv("LANG", "de_DE.UTF-8")
+	msg := getPackageInfo(r, display, Package{Name: "vim"})().(packageInfoMsg)
+	if msg.info != english || msg.localized != german {
+		t.Fatalf("info %q, localized %q", msg.info, msg.localized)
+	}
+	m := testModel(modeInstall)
+	m.infoForPackage, m.loadingInfo = "vim", true
+	next, _ := m.Update(msg)
+	m = next.(model)
+	if !m.packageDetailsOK || m.packageDetails.Description != "Vi Improved" {
+		t.Errorf("parsed %+v from %q", m.packageDetails, m.packageInfo)
+	}
+	if shown := strings.Join(m.infoLines(80, 20), "\n"); !strings.Contains(shown, "Beschreibung") {
+		t.Errorf("info panel shows %q", shown)
+	}
+}
+
+func TestPreviewRemovalMarksExplicitAndDependents(t *testing.T) {
+	r := &fakeRunner{outputs: map[string]fakeOutput{
+		"pacman -Qi foo": {stdout: "Name            : foo\nVersion         : 1-1\nRequired By     : bar  foo-docs\n"},
+		"pacman -Rs --print --print-format %n %v foo": {stdout: "foo 1-1\nlibfoo 2-1\n"},
+		"pacman -Qqe": {stdout: "foo\nbar\n"},
+	}}
+	msg := previewRemoval(r, "key", "-Rs", []string{"foo"})().(transactionPreviewMsg)
+	if msg.err != nil {
+		t.Fatal(msg.err)
+	}
+	if len(msg.entries) != 2 || !msg.entries[0].requested || !msg.entries[0].explicit || msg.entries[1].explicit {
+		t.Errorf("entries %+v", msg.entries)
+	}
+	dependents := msg.dependents["foo"]
+	sort.Strings(dependents)
+	if fmt.Sprint(dependents) != "[bar foo-docs]" {
+		t.Errorf("dependents %v", msg.dependents)
+	}
+}
+
+func TestParseCommandsDoNotMutate(t *testing.T) {
+	// The model's runner only ever sees the read-only commands it parses
+	m := testModel(modeInstalled)
+	r := &fakeRunner{}
+	m.runner = r
+	m.dashboard.Orphans = 1
+	press(t, m, "R")
+	for _, call := range r.calls {
+		if strings.HasPrefix(call, "sudo") || strings.Contains(call, " -R") || strings.Contains(call, " -S ") {
+			t.Errorf("runner ran %q", call)
+		}
+	}
+	if !r.ran(helper.name + " -Qdtq") {
+		t.Errorf("calls %v", r.calls)
+	}
+}
+
+func TestRecordTerminalKeepsTTY(t *testing.T) {
+	if _, err := exec.LookPath("script"); err != nil {
+		t.Skip("script(1) not installed")
+	}
+	path := filepath.Join(t.TempDir(), "out.log")
+	c := exec.Command("sh", "-c", "test -t 1 && echo 'on a tty'; exit 3")
+	if !recordTerminal(c, path) {
+		t.Fatal("not recorded")
+	}
+	err := c.Run()
+	if exitErr, ok := err.(*exec.ExitError); !ok || exitErr.ExitCode() != 3 {
+		t.Errorf("err = %v, want exit status 3", err)
+	}
+	if lines := readOutputTail(path, 10); fmt.Sprint(lines) != "[on a tty]" {
+		t.Errorf("recorded %q", lines)
+	}
+}
+
+func TestShellQuote(t *testing.T) {
+	for in, want := range map[string]string{
+		"pacman":    "'pacman'",
+		"it's":      `'it'\''s'`,
+		"$(reboot)": "'$(reboot)'",
+	} {
+		if got := shellQuote(in); got != want {
+			t.Errorf("shellQuote(%q) = %s, want %s", in, got, want)
+		}
+	}
+}
+
+func TestErrorOverlayScrollStaysInRange(t *testing.T) {
+	m := testModel(modeInstall)
+	m.showErrorOverlay = true
+	m.errorTitle = "Removal failed"
+	var output []string
+	for i := 0; i < errorOutputLines; i++ {
+		output = append(output, fmt.Sprintf("line %d", i))
+	}
+	m.setErrorOutput(output)
+	visible := min(m.errorOutputVisible(errorOverlayWidth(m.width-4), m.height-4), len(output))
+
+	m = press(t, m, "j")
+	if m.errorOutputEnd != len(output) {
+		t.Errorf("j past the end: end %d", m.errorOutputEnd)
+	}
+	for i := 0; i < 2*errorOutputLines; i++ {
+		m = press(t, m, "k")
+	}
+	if m.errorOutputEnd != visible {
+		t.Errorf("k past the top: end %d, want %d", m.errorOutputEnd, visible)
+	}
+	// The first j after reaching the top scrolls at once
+	if m = press(t, m, "j"); visible < len(output) && m.errorOutputEnd != visible+1 {
+		t.Errorf("j from the top: end %d, want %d", m.errorOutputEnd, visible+1)
+	}
+}
+
+func TestCaptureOutputReturnsTailOnFailure(t *testing.T) {
+	if _, err := exec.LookPath("script"); err != nil {
+		t.Skip("script(1) not installed")
+	}
+	c := exec.Command("sh", "-c", "echo 'error: target not found: foo' >&2; exit 1")
+	finish := captureOutput(c)
+	lines := finish(c.Run())
+	if fmt.Sprint(lines) != "[error: target not found: foo]" {
+		t.Errorf("output %q", lines)
+	}
+}
+
+func TestQueueFreezesRemovalFlags(t *testing.T) {
+	m := testModel(modeUninstall)
+	m.markedPackages = map[string]bool{"pkg0": true}
+	m.queueMarked()
+	m.removalFlags = "-Rc"
+	m.markedPackages = map[string]bool{"pkg1": true}
+	m.queueMarked()
+	m.mode = modeInstall
+	m.markedPackages = map[string]bool{"vim": true}
+	m.queueMarked()
+
+	var got []string
+	for _, step := range m.queueSteps() {
+		got = append(got, fmt.Sprintf("%s %s", step.command(), strings.Join(step.packages, " ")))
+	}
+	want := []string{helper.name + " -Rc pkg1", helper.name + " -Rns pkg0", helper.name + " -S vim"}
+	if fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("steps %q, want %q", got, want)
+	}
+}
+
+func TestQueueRemovalOthersDependOnTakesYes(t *testing.T) {
+	m := testModel(modeUninstall)
+	m.runner = &fakeRunner{outputs: map[string]fakeOutput{
+		"pacman -Qi pkg0": {stdout: "Name            : pkg0\nRequired By     : pkg9\n"},
+	}}
+	m.markedPackages = map[string]bool{"pkg0": true}
+	m = press(t, m, "Q")
+	if m.confirmType != confirmQueue || !m.txPreview.loading {
+		t.Fatalf("type %v loading %v", m.confirmType, m.txPreview.loading)
+	}
+	if m = press(t, m, "enter"); m.runningQueue != nil {
+		t.Fatal("queue ran before the dependents check finished")
+	}
+	preview := previewDependents(m.runner, m.txPreview.key, []string{"pkg0"})()
+	next, _ := m.Update(preview)
+	m = next.(model)
+	if !m.removalNeedsTypedConfirm() {
+		t.Fatalf("dependents %v", m.txPreview.dependents)
+	}
+	if m = press(t, m, "enter"); m.runningQueue != nil || !m.showConfirmation {
+		t.Fatal("queue ran without typing yes")
+	}
+	m = press(t, m, "y", "e", "s")
+	if m.confirmTyped != "yes" {
+		t.Fatalf("typed %q", m.confirmTyped)
+	}
+	if m = press(t, m, "enter"); len(m.runningQueue) != 1 {
+		t.Errorf("queue not run after typing yes: %v", m.runningQueue)
+	}
+}
+
+func TestQueueReviewScrolls(t *testing.T) {
+	m := testModel(modeUninstall)
+	m.queuedRemovals = make(map[string]string)
+	for i := 0; i < 25; i++ {
+		m.queuedRemovals[fmt.Sprintf("lib%02d", i)] = "-Rns"
+	}
+	m.showConfirmation = true
+	m.confirmType = confirmQueue
+	for i := 0; i < 40; i++ {
+		m = press(t, m, "j")
+	}
+	if want := len(m.queueRows()) - queueVisibleRows; m.confirmScrollOffset != want {
+		t.Fatalf("offset %d, want %d", m.confirmScrollOffset, want)
+	}
+	view := m.View()
+	if !strings.Contains(view, "lib24") || strings.Contains(view, "lib05") {
+		t.Error("scrolled review doesn't show the end of the queue")
+	}
+}
+
+func TestDashboardShowsDevelUpdates(t *testing.T) {
+	m := testModel(modeInstalled)
+	m.dashboardUpdates, m.dashboardUpdatesKnown = 3, true
+	if strings.Contains(m.View(), "incl. devel") {
+		t.Error("devel shown while devel packages aren't checked")
+	}
+	m.develUpdates = true
+	if !strings.Contains(m.View(), "incl. devel") {
+		t.Error("devel checking not shown on the dashboard")
+	}
+}
+
+func TestInstallReasonCancelKeepsMarks(t *testing.T) {
+	m := testModel(modeUninstall)
+	m.textInput.Blur()
+	m.markedPackages = map[string]bool{"pkg1": true, "pkg2": true}
+
+	m = press(t, m, "x")
+	if !m.showConfirmation || m.confirmType != confirmInstallReason {
+		t.Fatalf("x didn't open the install reason dialog: %q", m.statusMessage)
+	}
+	m = press(t, m, "esc")
+	if m.showConfirmation || len(m.markedPackages) != 2 {
+		t.Errorf("after cancelling: dialog %v, marks %v", m.showConfirmation, m.markedPackages)
+	}
+
+	m = press(t, m, "x", "y")
+	if len(m.markedPackages) != 0 {
+		t.Errorf("marks %v kept after confirming", m.markedPackages)
+	}
+}
+
+func TestCtrlAWhileTyping(t *testing.T) {
+	ctrlA := func(m model) model {
+		next, _ := m.Update(tea.KeyMsg{Type: tea.KeyCtrlA})
+		return next.(model)
+	}
+
+	m := testModel(modeUninstall)
+	m.textInput.Focus()
+	m.textInput.SetValue("pkg")
+	m = press(t, ctrlA(m), "x")
+	if got := m.textInput.Value(); got != "xpkg" || len(m.markedPackages) != 0 {
+		t.Errorf("typing: query %q, marks %v", got, m.markedPackages)
+	}
+
+	m = testModel(modeUninstall)
+	m.textInput.Blur()
+	m = ctrlA(m)
+	if len(m.markedPackages) != len(m.filteredInstalled) {
+		t.Error("ctrl+a didn't mark the filtered list with the input unfocused")
+	}
+}
+
+func TestClipboardStatus(t *testing.T) {
+	for _, tc := range []struct {
+		msg  clipboardMsg
+		want string
+	}{
+		{clipboardMsg{what: "yay", via: "wl-copy"}, "Copied yay"},
+		{clipboardMsg{what: "yay", via: "OSC 52"}, "Asked the terminal to copy yay"},
+		{clipboardMsg{what: "yay", via: "OSC 52", err: errors.New("xclip: exit status 1")}, "Copy failed (xclip: exit status 1), asked the terminal to copy yay instead"},
+	} {
+		if got := clipboardStatus(tc.msg); got != tc.want {
+			t.Errorf("%+v: got %q, want %q", tc.msg, got, tc.want)
+		}
+	}
+
+	// The copy itself runs as a command, outside Update
+	m := testModel(modeInstall)
+	m.textInput.Blur()
+	next, cmd := m.Update(keyMsgFor("y"))
+	if cmd == nil || strings.HasPrefix(next.(model).statusMessage, "Copied") {
+		t.Errorf("y copied inside Update: %q", next.(model).statusMessage)
+	}
+	next, _ = next.Update(clipboardMsg{what: "pkg0", via: "xsel"})
+	if got := next.(model).statusMessage; got != "Copied pkg0" {
+		t.Errorf("status after the copy = %q", got)
+	}
+}
+
+func TestBootImagePkgbase(t *testing.T) {
+	for cmdline, want := range map[string]string{
+		"BOOT_IMAGE=/vmlinuz-linux-lts root=UUID=1234 rw quiet\n": "linux-lts",
+		"BOOT_IMAGE=(hd0,gpt2)/boot/vmlinuz-linux-zen rw":         "linux-zen",
+		"initrd=\\initramfs-linux.img root=/dev/sda2 rw":          "",
+		"BOOT_IMAGE=/boot/bzImage rw":                             "",
+		"":                                                        "",
+	} {
+		if got := bootImagePkgbase(cmdline); got != want {
+			t.Errorf("%q: got %q, want %q", cmdline, got, want)
+		}
+	}
+}
+
+func TestSameFilesystem(t *testing.T) {
+	root := syscall.Statfs_t{Type: 0xef53, Fsid: syscall.Fsid{X__val: [2]int32{1, 2}}, Blocks: 1000, Bfree: 400, Bsize: 4096}
+	bind := root
+	bind.Bfree = 399 // Measured a moment later
+	other := root
+	other.Fsid.X__val[1] = 3 // Same space, different filesystem
+	subvol := syscall.Statfs_t{Type: btrfsMagic, Fsid: syscall.Fsid{X__val: [2]int32{7, 8}}, Blocks: 5000, Bsize: 4096}
+	sibling := subvol
+	sibling.Fsid.X__val[0] = 9
+	pool := sibling
+	pool.Blocks = 6000
+
+	for _, tc := range []struct {
+		name string
+		a, b syscall.Statfs_t
+		want bool
+	}{
+		{"bind mount", root, bind, true},
+		{"same usage", root, other, false},
+		{"btrfs subvolumes", subvol, sibling, true},
+		{"btrfs pools", subvol, pool, false},
+	} {
+		if got := sameFilesystem(tc.a, tc.b); got != tc.want {
+			t.Errorf("%s: got %v, want %v", tc.name, got, tc.want)
+		}
+	}
+}
+
+func TestSizeSortUsesPackageSizes(t *testing.T) {
+	m := testModel(modeUninstall)
+	m.textInput.Blur()
+	for i := range m.installed {
+		m.installed[i].SizeBytes = int64(i+1) << 20
+	}
+	m.filteredInstalled = append([]Package(nil), m.installed...)
+	m.applyResultSort()
+
+	m = press(t, m, "s", "s", "s", "s")
+	if m.resultSorts[modeUninstall] != sortSize {
+		t.Fatalf("order %s, want size", resultSortNames[m.resultSorts[modeUninstall]])
+	}
+	if first := m.filteredInstalled[0]; first.Name != "pkg4" {
+		t.Errorf("biggest package %s first, want pkg4", first.Name)
+	}
+	if !strings.Contains(m.View(), "5.0 MiB") {
+		t.Error("size column missing from the remove list")
+	}
+
+	// Install mode has no installed sizes to order by
+	m = testModel(modeInstall)
+	m.textInput.Blur()
+	m = press(t, m, "s", "s", "s", "s")
+	if m.resultSorts[modeInstall] == sortSize {
+		t.Error("install mode offered the size order")
+	}
+}
+
+func TestSavedSelectionWaitsForAURResults(t *testing.T) {
+	reload := func(aurErr error) model {
+		m := testModel(modeInstall)
+		m.startMode = modeInstall
+		m.textInput.SetValue("pkg")
+		m.searchingAUR, m.aurSearchSeq = true, 1
+		m.savedSelection = &savedSelection{mode: modeInstall, name: "pkg3", index: 3}
+		next, _ := m.Update(repoPackagesMsg{packages: append([]Package(nil), m.repoPackages...)})
+		next, _ = next.Update(aurSearchMsg{seq: 1, query: "pkg", err: aurErr})
+		return next.(model)
+	}
+
+	m := reload(nil)
+	if m.savedSelection != nil {
+		t.Error("selection still saved after the AUR results landed")
+	}
+	if pkg := m.selectedPackage(); pkg == nil || pkg.Name != "pkg3" {
+		t.Errorf("selected %v, want pkg3", pkg)
+	}
+	if m = reload(errors.New("offline")); m.savedSelection != nil {
+		t.Error("failed AUR search left the selection for a later reload")
+	}
+}
+
+func TestPacdiffPaths(t *testing.T) {
+	out := "/etc/pacman.conf.pacnew\n/etc/NetworkManager/system connections/home.pacsave\n\n"
+	want := []string{"/etc/pacman.conf.pacnew", "/etc/NetworkManager/system connections/home.pacsave"}
+	if got := pacdiffPaths(out); strings.Join(got, "|") != strings.Join(want, "|") {
+		t.Errorf("got %q, want %q", got, want)
+	}
+	if got := pacdiffPaths(""); got != nil {
+		t.Errorf("empty output: got %q", got)
+	}
+}
+
+func TestDashboardRemoveChecksDependents(t *testing.T) {
+	m := testModel(modeInstalled)
+	m.runner = &fakeRunner{}
+	m.dashboard.TopPackages = []PackageSize{{Name: "texlive-core", Bytes: 1 << 30}}
+	m.dashboardSelected = len(m.dashboardItems()) - 1
+	m = press(t, m, "Q")
+	if !m.showConfirmation || m.confirmType != confirmUninstall || fmt.Sprint(m.confirmPackages) != "[texlive-core]" {
+		t.Fatalf("confirmation %v type %v packages %v", m.showConfirmation, m.confirmType, m.confirmPackages)
+	}
+	if !m.txPreview.loading {
+		t.Error("removal opened without checking its dependents")
+	}
+	if len(m.queuedRemovals) > 0 {
+		t.Errorf("queued %v", m.queuedRemovals)
+	}
+}
+
+// tearFile simulates a write cut short by a crash: the file keeps only its
+// first keep bytes, and the rest reads as zeroes as after a power loss
+func tearFile(t *testing.T, path string, keep int) {
+	t.Helper()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	torn := make([]byte, len(data))
+	copy(torn, data[:keep])
+	if err := os.WriteFile(path, torn, 0o644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestQueryHistoryRecoversTornWrite(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "history")
+	first := map[viewMode][]string{modeInstall: {"firefox"}}
+	second := map[viewMode][]string{modeInstall: {"firefox", "vlc"}}
+	if err := saveQueryHistory(path, first); err != nil {
+		t.Fatal(err)
+	}
+	if err := saveQueryHistory(path, second); err != nil {
+		t.Fatal(err)
+	}
+	tearFile(t, path, 5)
+
+	history, restored := loadQueryHistory(path)
+	if !restored || fmt.Sprint(history[modeInstall]) != "[firefox]" {
+		t.Errorf("restored %v history %v", restored, history)
+	}
+	if _, err := os.Stat(path + ".corrupt"); err != nil {
+		t.Error("corrupt copy not kept")
+	}
+	// The restored file loads cleanly from then on
+	if _, restored := loadQueryHistory(path); restored {
+		t.Error("restored twice")
+	}
+}
+
+func TestRepoCacheRecoversTornWrite(t *testing.T) {
+	t.Setenv("XDG_CACHE_HOME", t.TempDir())
+	older := repoCache{Version: repoCacheVersion, Packages: []Package{{Name: "bash", Source: "core"}}}
+	newer := repoCache{Version: repoCacheVersion, Packages: []Package{{Name: "bash", Source: "core"}, {Name: "vim", Source: "extra"}}}
+	if err := writeRepoCache(older); err != nil {
+		t.Fatal(err)
+	}
+	if err := writeRepoCache(newer); err != nil {
+		t.Fatal(err)
+	}
+	info, err := os.Stat(repoCachePath())
+	if err != nil {
+		t.Fatal(err)
+	}
+	tearFile(t, repoCachePath(), int(info.Size()/2))
+
+	cache, err := readRepoCache()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(cache.Packages) != 1 || cache.Packages[0].Name != "bash" {
+		t.Errorf("packages %v", cache.Packages)
+	}
+}
+
+func TestRepoCacheWithoutBackupFails(t *testing.T) {
+	t.Setenv("XDG_CACHE_HOME", t.TempDir())
+	if err := writeRepoCache(repoCache{Version: repoCacheVersion, Packages: []Package{{Name: "bash"}}}); err != nil {
+		t.Fatal(err)
+	}
+	tearFile(t, repoCachePath(), 3)
+	if _, err := readRepoCache(); err == nil {
+		t.Error("torn cache read without error")
+	}
+}
+
+func TestRepoCountRecoversTornWrite(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "repo-count")
+	for _, n := range []string{"100\n", "120\n"} {
+		if err := writeFileAtomic(path, []byte(n), validCount); err != nil {
+			t.Fatal(err)
+		}
+	}
+	tearFile(t, path, 1)
+	data, restored, err := readFileWithRecovery(path, validCount)
+	if err != nil || !restored || string(data) != "100\n" {
+		t.Errorf("data %q restored %v err %v", data, restored, err)
+	}
+}
+
+func TestInterruptedWriteLeavesFileIntact(t *testing.T) {
+	// A crash before the rename leaves only a stray temporary file behind
+	dir := t.TempDir()
+	path := filepath.Join(dir, "history")
+	if err := saveQueryHistory(path, map[viewMode][]string{modeUninstall: {"pulseaudio"}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, ".history.tmp-123"), []byte("rem"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	history, restored := loadQueryHistory(path)
+	if restored || fmt.Sprint(history[modeUninstall]) != "[pulseaudio]" {
+		t.Errorf("restored %v history %v", restored, history)
+	}
+}
+
+func TestSaveQueryHistoryIsStable(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "history")
+	history := map[viewMode][]string{
+		modeInstall:   {"firefox"},
+		modeUninstall: {"pulseaudio"},
+		modeHistory:   {"linux"},
+		modeUpdate:    {"mesa"},
+	}
+	var first []byte
+	for i := 0; i < 10; i++ {
+		if err := saveQueryHistory(path, history); err != nil {
+			t.Fatal(err)
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if first == nil {
+			first = data
+		} else if string(data) != string(first) {
+			t.Fatalf("history written as\n%s\nthen as\n%s", first, data)
+		}
+	}
+}
+
+func TestRecordQueryWithoutResults(t *testing.T) {
+	t.Setenv("XDG_CACHE_HOME", t.TempDir())
+	for _, key := range []string{"enter", "esc"} {
+		m := testModel(modeInstall)
+		m.textInput.Focus()
+		m = press(t, m, "z", "z", "z", "q")
+		if m.selectableCount() != 0 {
+			t.Fatalf("query matched %d results", m.selectableCount())
+		}
+		m = press(t, m, key)
+		if fmt.Sprint(m.queryHistory[modeInstall]) != "[zzzq]" {
+			t.Errorf("%s: history %v", key, m.queryHistory[modeInstall])
+		}
+	}
+}
+
+func TestLastSystemUpgrade(t *testing.T) {
+	tests := []struct {
+		name string
+		log  string
+		want string
+	}{
+		{"completed upgrade", `[2024-05-01T10:00:00+0000] [PACMAN] Running 'pacman -Syu'
+[2024-05-01T10:00:01+0000] [PACMAN] starting full system upgrade
+[2024-05-01T10:00:05+0000] [ALPM] transaction started
+[2024-05-01T10:01:00+0000] [ALPM] transaction completed
+`, "2024-05-01T10:00:01Z"},
+		{"declined upgrade then install", `[2024-05-01T10:00:01+0000] [PACMAN] starting full system upgrade
+[2024-05-02T09:00:00+0000] [PACMAN] Running 'pacman -S vim'
+[2024-05-02T09:00:01+0000] [ALPM] transaction started
+[2024-05-02T09:00:02+0000] [ALPM] transaction completed
+`, ""},
+		{"declined upgrade then upgrade", `[2024-05-01T10:00:01+0000] [PACMAN] starting full system upgrade
+[2024-05-03T10:00:00+0000] [PACMAN] Running 'pacman -Syu'
+[2024-05-03T10:00:01+0000] [PACMAN] starting full system upgrade
+[2024-05-03T10:00:05+0000] [ALPM] transaction started
+[2024-05-03T10:01:00+0000] [ALPM] transaction completed
+`, "2024-05-03T10:00:01Z"},
+		{"failed upgrade then another transaction", `[2024-05-01T10:00:01+0000] [PACMAN] starting full system upgrade
+[2024-05-01T10:00:05+0000] [ALPM] transaction started
+[2024-05-01T10:00:06+0000] [ALPM] transaction failed
+[2024-05-01T11:00:00+0000] [ALPM] transaction started
+[2024-05-01T11:00:01+0000] [ALPM] transaction completed
+`, ""},
+		{"upgrade before a later install", `[2024-05-01T10:00:01+0000] [PACMAN] starting full system upgrade
+[2024-05-01T10:00:05+0000] [ALPM] transaction started
+[2024-05-01T10:01:00+0000] [ALPM] transaction completed
+[2024-05-02T09:00:00+0000] [PACMAN] Running 'pacman -S vim'
+[2024-05-02T09:00:01+0000] [ALPM] transaction started
+[2024-05-02T09:00:02+0000] [ALPM] transaction completed
+`, "2024-05-01T10:00:01Z"},
+	}
+	for _, tt := range tests {
+		last, ok := lastSystemUpgrade(strings.NewReader(tt.log))
+		got := ""
+		if ok {
+			got = last.UTC().Format(time.RFC3339)
+		}
+		if got != tt.want {
+			t.Errorf("%s: got %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestUpdateWaitsForNews(t *testing.T) {
+	m := testModel(modeUpdate)
+	m.pendingUpdates = []Package{{Name: "pkg0"}}
+	m.showConfirmation = true
+	m.confirmType = confirmUpdate
+	m.newsLoading = true
+	if m = press(t, m, "y"); !m.showConfirmation {
+		t.Fatal("update ran while the news was loading")
+	}
+
+	next, _ := m.Update(archNewsMsg{items: []archNewsItem{{title: "grub 2:2.12 requires manual intervention"}}})
+	m = next.(model)
+	if m = press(t, m, "enter"); !m.showConfirmation {
+		t.Fatal("update ran before the manual intervention was acknowledged")
+	}
+	m = press(t, m, "r")
+	if !m.newsAcknowledged {
+		t.Fatal("r did not acknowledge the news")
+	}
+	if m = press(t, m, "enter"); m.showConfirmation {
+		t.Error("update did not run after acknowledging")
+	}
+}
+
+func TestOverlaysNameTheHelper(t *testing.T) {
+	useHelper(t, "yay")
+	m := testModel(modeInstalled)
+	m.holds = map[string]bool{"linux": true}
+	m.unmanaged = []unmanagedPackage{{name: "foo"}}
+	for name, text := range map[string]string{
+		"holds":     m.renderHoldsOverlay(100, 30, defaultBorderColor),
+		"unmanaged": m.renderUnmanagedOverlay(100, 30, defaultBorderColor),
+	} {
+		if strings.Contains(text, "paru") || !strings.Contains(text, "yay") {
+			t.Errorf("%s overlay doesn't name yay:\n%s", name, text)
+		}
+	}
+}
+
+// pendingUpdatesRunner scripts n pending updates, every other one foreign
+func pendingUpdatesRunner(n int) *fakeRunner {
+	var updates, foreign strings.Builder
+	for i := 0; i < n; i++ {
+		fmt.Fprintf(&updates, "pkg%d 1.0-1 -> 1.1-1\n", i)
+		if i%2 == 1 {
+			fmt.Fprintf(&foreign, "pkg%d 1.0-1\n", i)
+		}
+	}
+	return &fakeRunner{outputs: map[string]fakeOutput{
+		"paru -Qu":   {stdout: updates.String()},
+		"pacman -Qm": {stdout: foreign.String()},
+	}}
+}
+
+func TestCheckUpdatesSpawnsConstantProcesses(t *testing.T) {
+	useHelper(t, "paru")
+	offlineAUR(t)
+	r := pendingUpdatesRunner(200)
+	msg := checkUpdates(r, nil, nil, false)().(updateCheckMsg)
+	if len(msg.updates) != 200 {
+		t.Fatalf("got %d updates, want 200", len(msg.updates))
+	}
+	if len(r.calls) > 3 {
+		t.Errorf("checking 200 updates ran %d processes: %v", len(r.calls), r.calls)
+	}
+	aur := 0
+	for _, u := range msg.updates {
+		if u.pkg.Source == "aur" {
+			aur++
+		}
+	}
+	if aur != 100 {
+		t.Errorf("%d AUR updates, want 100", aur)
+	}
+}
+
+func BenchmarkCheckUpdates(b *testing.B) {
+	saved, savedRPC := helper, aurRPC
+	defer func() { helper, aurRPC = saved, savedRPC }()
+	helper = backendFor("paru")
+	aurRPC = &aurClient{
+		http: &http.Client{Transport: roundTripFunc(func(*http.Request) (*http.Response, error) {
+			return nil, errors.New("offline")
+		})},
+		cache: make(map[string]aurSearchCacheEntry),
+	}
+	for _, n := range []int{10, 200, 2000} {
+		b.Run(fmt.Sprintf("%d updates", n), func(b *testing.B) {
+			var calls int
+			for i := 0; i < b.N; i++ {
+				r := pendingUpdatesRunner(n)
+				checkUpdates(r, nil, nil, false)()
+				calls = len(r.calls)
+			}
+			b.ReportMetric(float64(calls), "processes/op")
+			if calls > 3 {
+				b.Fatalf("%d updates ran %d processes", n, calls)
+			}
+		})
+	}
+}
+
+func TestTypeAsteriskWhileMarked(t *testing.T) {
+	for _, mode := range []viewMode{modeInstall, modeUninstall} {
+		m := testModel(mode)
+		m.markedPackages = map[string]bool{"pkg1": true}
+		m.textInput.Focus()
+		m = press(t, m, "*", "-", "g", "i", "t")
+		if m.textInput.Value() != "*-git" {
+			t.Errorf("mode %v: query %q, want %q", mode, m.textInput.Value(), "*-git")
+		}
+		if m.selectionPanelFocused {
+			t.Errorf("mode %v: * focused the selection panel while typing", mode)
+		}
+		if !m.markedPackages["pkg1"] {
+			t.Errorf("mode %v: marks lost: %v", mode, m.markedPackages)
+		}
+	}
+
+	// Outside the input * still toggles the panel
+	m := testModel(modeInstall)
+	m.markedPackages = map[string]bool{"pkg1": true}
+	if m = press(t, m, "*"); !m.selectionPanelFocused {
+		t.Error("* didn't focus the selection panel")
+	}
+}
+
+func BenchmarkFilterAllPackages(b *testing.B) {
+	m := testModel(modeInstall)
+	repos := []string{"core", "extra", "multilib"}
+	words := []string{"lib", "python", "qt", "gtk", "rust", "font", "perl", "x11"}
+	packages := make([]Package, 20000)
+	for i := range packages {
+		packages[i] = Package{
+			Name:   fmt.Sprintf("%s-%s%d", words[i%len(words)], words[(i/7)%len(words)], i),
+			Source: repos[i%len(repos)],
+		}
+	}
+	m.setRepoPackages(packages, nil)
+	for _, query := range []string{"", "e:", "lib", "e:pyqt", "python-gtk"} {
+		b.Run(fmt.Sprintf("%q", query), func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				m.filterAllPackages(query)
+			}
+		})
+	}
+}
+
+// useColor renders styles with ANSI colors for the rest of the test
+func useColor(t *testing.T) {
+	t.Helper()
+	saved := lipgloss.ColorProfile()
+	lipgloss.SetColorProfile(termenv.ANSI256)
+	t.Cleanup(func() { lipgloss.SetColorProfile(saved) })
+}
+
+func TestHighlightMatchesRenders(t *testing.T) {
+	useColor(t)
+	source := lipgloss.NewStyle().Foreground(sourceColors["extra"])
+	hl := matchHighlightStyle.Render
+	for _, tc := range []struct {
+		name    string
+		pkg     Package
+		indices []int
+		want    string
+	}{
+		{"no match", Package{Source: "extra", Name: "vim"}, nil, source.Render("extra") + "/vim"},
+		{"run across the slash", Package{Source: "extra", Name: "vim"}, []int{3, 4, 5, 6}, source.Render("ext") + hl("ra/v") + "im"},
+		{"runs split by plain text", Package{Source: "extra", Name: "vim"}, []int{6, 8}, source.Render("extra") + "/" + hl("v") + "i" + hl("m")},
+		{"unicode name", Package{Source: "extra", Name: "ñandú-lib"}, []int{6, 7, 8, 9, 10}, source.Render("extra") + "/" + hl("ñandú") + "-lib"},
+		{"unknown source", Package{Source: "local", Name: "vim"}, []int{0, 6}, hl("l") + "ocal/" + hl("v") + "im"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			got := highlightMatchesWithSourceColor(tc.pkg, tc.indices)
+			if got != tc.want {
+				t.Errorf("got %q, want %q", got, tc.want)
+			}
+			if plain := ansiEscape.ReplaceAllString(got, ""); plain != tc.pkg.String() {
+				t.Errorf("stripped to %q, want %q", plain, tc.pkg.String())
+			}
+		})
+	}
+
+	if got, want := highlightMatches("žluťoučký", []int{0, 1, 4}), hl("žl")+"uť"+hl("o")+"učký"; got != want {
+		t.Errorf("highlightMatches = %q, want %q", got, want)
+	}
+}
+
+func TestHighlightFullMatchIsOneRun(t *testing.T) {
+	useColor(t)
+	name := strings.Repeat("abcdefghij", 3)
+	indices := make([]int, len(name))
+	for i := range indices {
+		indices[i] = i
+	}
+	got := highlightMatches(name, indices)
+	if n := len(ansiEscape.FindAllString(got, -1)); n != 2 {
+		t.Errorf("fully matched %d-char name has %d escape sequences, want 2: %q", len(name), n, got)
+	}
+	if plain := ansiEscape.ReplaceAllString(got, ""); plain != name {
+		t.Errorf("stripped to %q, want %q", plain, name)
+	}
+}
+
+// checkTruncated fails unless out is a clean cut of in at most width wide:
+// whole runes, whole escape sequences and colors reset at the end
+func checkTruncated(t *testing.T, in, out string, width int) {
+	t.Helper()
+	if w := lipgloss.Width(out); w > width {
+		t.Errorf("width %d: %q is %d columns", width, out, w)
+	}
+	if !utf8.ValidString(out) {
+		t.Errorf("width %d: %q splits a rune", width, out)
+	}
+	plain := ansiEscape.ReplaceAllString(out, "")
+	if strings.ContainsRune(plain, '\x1b') {
+		t.Errorf("width %d: %q splits an escape sequence", width, out)
+	}
+	if !strings.HasPrefix(ansiEscape.ReplaceAllString(in, ""), strings.TrimSuffix(plain, "...")) {
+		t.Errorf("width %d: %q isn't a prefix of %q", width, plain, in)
+	}
+	if escapes := ansiEscape.FindAllString(out, -1); len(escapes) > 0 && escapes[len(escapes)-1] != "\x1b[0m" {
+		t.Errorf("width %d: %q leaves a color open", width, out)
+	}
+}
+
+func TestTruncateColoredUnicodeLines(t *testing.T) {
+	useColor(t)
+	pkg := Package{Source: "extra", Name: "ñandú-日本語-fonts"}
+	name := highlightMatchesWithSourceColor(pkg, []int{3, 4, 5, 6, 12, 13})
+	extras := []string{
+		lipgloss.NewStyle().Foreground(currentTheme.SubtleColor).Render("1:2.3-ü1"),
+		lipgloss.NewStyle().Foreground(currentTheme.SuccessColor).Render("[installed]"),
+	}
+	full := strings.Join(append([]string{name}, extras...), " ")
+	for width := 0; width <= lipgloss.Width(full)+1; width++ {
+		checkTruncated(t, name, truncateWithAnsi(name, width), width)
+		checkTruncated(t, full, fitResultLine(name, extras, width), width)
+	}
+
+	// Badges go before the version, and the version before the name is cut
+	nameWidth := lipgloss.Width(name)
+	for _, tc := range []struct {
+		width int
+		want  string
+	}{
+		{lipgloss.Width(full), full},
+		{lipgloss.Width(full) - 1, name + " " + extras[0]},
+		{nameWidth, name},
+	} {
+		if got := fitResultLine(name, extras, tc.width); got != tc.want {
+			t.Errorf("width %d: got %q, want %q", tc.width, got, tc.want)
+		}
+	}
+	if got := ansiEscape.ReplaceAllString(fitResultLine(name, extras, nameWidth-1), ""); !strings.HasSuffix(got, "...") {
+		t.Errorf("width %d: cut name %q has no ellipsis", nameWidth-1, got)
+	}
+}
+
+func TestNarrowViewHasNoColorBleed(t *testing.T) {
+	useColor(t)
+	m := testModel(modeInstall)
+	packages := []Package{
+		{Name: "ñandú-日本語-fonts-with-a-rather-long-name", Source: "extra", Version: "1:2.3-ü1", Installed: true},
+		{Name: "žluťoučký-kůň", Source: "core", Version: "10.0-1"},
+		{Name: "python-ünïcödé-nörmälïzätïön-tööls", Source: "aur", Version: "0.1-1"},
+	}
+	m.setRepoPackages(packages, nil)
+	m.filterAllPackages("o")
+	if len(m.filtered) != len(packages) {
+		t.Fatalf("%d of %d packages match", len(m.filtered), len(packages))
+	}
+	for _, width := range []int{60, 70, 100} {
+		m.width = width
+		view := m.View()
+		if !strings.Contains(ansiEscape.ReplaceAllString(view, ""), "/žluť") {
+			t.Errorf("width %d: results missing from view", width)
+		}
+		for i, line := range strings.Split(view, "\n") {
+			if w := lipgloss.Width(line); w > width {
+				t.Errorf("width %d: line %d is %d columns: %q", width, i, w, line)
+			}
+			if !utf8.ValidString(line) || strings.ContainsRune(ansiEscape.ReplaceAllString(line, ""), '\x1b') {
+				t.Errorf("width %d: line %d is mangled: %q", width, i, line)
+			}
+		}
+	}
+}
+
+func TestParseDescFile(t *testing.T) {
+	fields := parseDescFile("%NAME%\nfoo\n\n%DEPENDS%\nbar>=1\nbaz\n\n%DESC%\nA: colon %and% percents\n")
+	for field, want := range map[string]string{
+		"NAME":    "[foo]",
+		"DEPENDS": "[bar>=1 baz]",
+		"DESC":    "[A: colon %and% percents]",
+		"SIZE":    "[]",
+	} {
+		if got := fmt.Sprint(fields[field]); got != want {
+			t.Errorf("%s = %s, want %s", field, got, want)
+		}
+	}
+	if got := descField(fields, "DEPENDS"); got != "bar>=1" {
+		t.Errorf("descField = %q", got)
+	}
+}
+
+func TestParseAUROutput(t *testing.T) {
+	tests := []struct {
+		name   string
+		helper string
+		output string
+		want   []string
+	}{
+		{
+			name:   "paru",
+			helper: "paru",
+			output: `aur/yay 12.4.2-1 [+2480 ~39.12] [Installed]
+    Yet another yogurt. Pacman wrapper and AUR helper written in go.
+aur/yay-bin 12.4.2-1 [+346 ~8.77] [Installed: 12.3.5-1]
+    Yet another yogurt. Pacman wrapper and AUR helper written in go. Pre-compiled.
+aur/yay-git 12.4.2.r2.g0b3b1b0-1 [+84 ~0.00] [Orphaned] [Out-of-date: 2024-01-31]
+    Yet another yogurt. Pacman wrapper and AUR helper written in go. (development version)
+`,
+			want: []string{
+				"aur/yay 12.4.2-1 installed=true votes=2480 pop=39.12 orphaned=false outofdate=false desc=Yet another yogurt. Pacman wrapper and AUR helper written in go.",
+				"aur/yay-bin 12.4.2-1 installed=true votes=346 pop=8.77 orphaned=false outofdate=false desc=Yet another yogurt. Pacman wrapper and AUR helper written in go. Pre-compiled.",
+				"aur/yay-git 12.4.2.r2.g0b3b1b0-1 installed=false votes=84 pop=0.00 orphaned=true outofdate=true desc=Yet another yogurt. Pacman wrapper and AUR helper written in go. (development version)",
+			},
+		},
+		{
+			name:   "yay",
+			helper: "yay",
+			output: `aur/paru 2.0.4-1 (+651 11.23) (Installed)
+    Feature packed AUR helper
+aur/paru-bin 2.0.4-1 (+178 3.02) (Out-of-date: 2024-01-31)
+    Feature packed AUR helper
+`,
+			want: []string{
+				"aur/paru 2.0.4-1 installed=true votes=651 pop=11.23 orphaned=false outofdate=false desc=Feature packed AUR helper",
+				"aur/paru-bin 2.0.4-1 installed=false votes=178 pop=3.02 orphaned=false outofdate=true desc=Feature packed AUR helper",
+			},
+		},
+		{
+			name:   "no results",
+			helper: "paru",
+			output: "",
+			want:   nil,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			useHelper(t, tt.helper)
+			offlineAUR(t)
+			r := &fakeRunner{outputs: map[string]fakeOutput{
+				tt.helper + " -Ss -a yay": {stdout: tt.output},
+			}}
+			msg := searchAUR(context.Background(), r, "yay", 1)().(aurSearchMsg)
+			if msg.err != nil {
+				t.Fatal(msg.err)
+			}
+			var got []string
+			for _, pkg := range msg.packages {
+				got = append(got, fmt.Sprintf("%s %s installed=%v votes=%d pop=%.2f orphaned=%v outofdate=%v desc=%s",
+					pkg, pkg.Version, pkg.Installed, pkg.Votes, pkg.Popularity, pkg.Orphaned, pkg.OutOfDate != 0, pkg.Description))
+			}
+			if strings.Join(got, "\n") != strings.Join(tt.want, "\n") {
+				t.Errorf("got\n%s\nwant\n%s", strings.Join(got, "\n"), strings.Join(tt.want, "\n"))
+			}
+		})
+	}
+}
+
+func TestParseSearchOutput(t *testing.T) {
+	tests := []struct {
+		name   string
+		output string
+		want   string
+	}{
+		{
+			name:   "numbered",
+			output: "2 aur/yay-bin 12.4.2-1 [+346 ~8.77]\n    Yet another yogurt. Pre-compiled.\n1 aur/yay 12.4.2-1 [+2480 ~39.12] [Installed]\n    Yet another yogurt.\n",
+			want:   "[aur/yay-bin 12.4.2-1 false Yet another yogurt. Pre-compiled.] [aur/yay 12.4.2-1 true Yet another yogurt.]",
+		},
+		{
+			name:   "repository",
+			output: "extra/firefox 126.0-1 [Installed]\n    Fast, Private & Safe Web Browser\nextra/firefox-i18n-de 126.0-1\n    German language pack for Firefox\n",
+			want:   "[extra/firefox 126.0-1 true Fast, Private & Safe Web Browser] [extra/firefox-i18n-de 126.0-1 false German language pack for Firefox]",
+		},
+		{
+			name:   "empty",
+			output: "\n",
+			want:   "",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got []string
+			for _, pkg := range parseSearchOutput(tt.output) {
+				got = append(got, fmt.Sprintf("[%s %s %v %s]", pkg, pkg.Version, pkg.Installed, pkg.Description))
+			}
+			if strings.Join(got, " ") != tt.want {
+				t.Errorf("got %s, want %s", strings.Join(got, " "), tt.want)
+			}
+		})
+	}
+}
+
+func TestParseRepoMap(t *testing.T) {
+	r := &fakeRunner{outputs: map[string]fakeOutput{
+		"pacman -Sl": {stdout: `core-testing linux 6.9.3.arch1-1 [installed: 6.9.2.arch1-1]
+core acl 2.3.2-1 [installed]
+core linux 6.9.2.arch1-1 [installed]
+extra firefox 126.0-1
+multilib lib32-glibc 2.39+r52+gf8e4623421-1 [installed]
+chaotic-aur paru-git 2.0.3.r12.g3bfc6a5-1
+`},
+	}}
+	repoMap, err := syncRepoMap(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, want := range map[string]string{
+		"acl":         "core",
+		"linux":       "core-testing",
+		"firefox":     "extra",
+		"lib32-glibc": "multilib",
+		"paru-git":    "chaotic-aur",
+		"yay":         "",
+	} {
+		if got := repoMap[name]; got != want {
+			t.Errorf("%s = %q, want %q", name, got, want)
+		}
+	}
+	if len(repoMap) != 5 {
+		t.Errorf("mapped %v", repoMap)
+	}
+}
+
+func TestParsePackageDetails(t *testing.T) {
+	tests := []struct {
+		name   string
+		helper string
+		pkg    string
+		output string
+		want   []string
+	}{
+		{
+			name:   "pacman -Si",
+			helper: "pacman",
+			pkg:    "linux",
+			output: `Repository      : core
+Name            : linux
+Version         : 6.9.2.arch1-1
+Description     : The Linux kernel and modules
+Architecture    : x86_64
+URL             : https://github.com/archlinux/linux
+Licenses        : GPL-2.0-only
+Groups          : None
+Provides        : KSMBD-MODULE  VIRTUALBOX-GUEST-MODULES  WIREGUARD-MODULE
+Depends On      : coreutils  kmod  initramfs
+Optional Deps   : wireless-regdb: to set the correct wireless channels of your country [installed]
+                  linux-firmware: firmware images needed for some devices [installed]
+Conflicts With  : None
+Replaces        : virtualbox-guest-modules-arch  wireguard-arch
+Download Size   : 135.77 MiB
+Installed Size  : 135.14 MiB
+Packager        : Jan Alexander Steffens (heftig) <heftig@archlinux.org>
+Build Date      : Sun 26 May 2024 10:26:58 PM CEST
+Validated By    : SHA-256 Sum  Signature
+
+`,
+			want: []string{
+				"core/linux 6.9.2.arch1-1",
+				"description: The Linux kernel and modules",
+				"url: https://github.com/archlinux/linux",
+				"licenses: [GPL-2.0-only]",
+				"provides: [KSMBD-MODULE VIRTUALBOX-GUEST-MODULES WIREGUARD-MODULE]",
+				"depends: [coreutils kmod initramfs]",
+				"optdepends: [wireless-regdb: to set the correct wireless channels of your country [installed]|linux-firmware: firmware images needed for some devices [installed]]",
+				"makedepends: []",
+				"conflicts: []",
+				"sizes: 135.14 MiB 135.77 MiB",
+				"packager: Jan Alexander Steffens (heftig) <heftig@archlinux.org>",
+				"aur:    ",
+			},
+		},
+		{
+			name:   "paru -Si",
+			helper: "paru",
+			pkg:    "yay",
+			output: `Repository      : aur
+Name            : yay
+Version         : 12.4.2-1
+Description     : Yet another yogurt. Pacman wrapper and AUR helper written in go.
+Groups          : None
+URL             : https://github.com/Jguer/yay
+Licenses        : GPL-3.0-or-later
+Provides        : None
+Depends On      : pacman>6.1  git
+Make Deps       : go>=1.21
+Check Deps      : None
+Optional Deps   : sudo
+                  doas
+Conflicts With  : None
+Maintainer      : jguer
+Votes           : 2480
+Popularity      : 39.12
+First Submitted : Thu 05 Oct 2016 05:33:37 PM CEST
+Last Modified   : Mon 18 Nov 2024 10:42:14 AM CET
+Out Of Date     : No
+ID              : 1115556
+Package Base ID : 115973
+Keywords        : arm  AUR  go  helper  pacman  wrapper  x86
+Snapshot URL    : https://aur.archlinux.org/cgit/aur.git/snapshot/yay.tar.gz
+
+`,
+			want: []string{
+				"aur/yay 12.4.2-1",
+				"description: Yet another yogurt. Pacman wrapper and AUR helper written in go.",
+				"url: https://github.com/Jguer/yay",
+				"licenses: [GPL-3.0-or-later]",
+				"provides: []",
+				"depends: [pacman>6.1 git]",
+				"optdepends: [sudo|doas]",
+				"makedepends: [go>=1.21]",
+				"conflicts: []",
+				"sizes:  ",
+				"packager: jguer",
+				"aur: 2480 39.12 Mon 18 Nov 2024 10:42:14 AM CET ",
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			useHelper(t, tt.helper)
+			r := &fakeRunner{outputs: map[string]fakeOutput{
+				tt.helper + " -Si " + tt.pkg: {stdout: tt.output},
+			}}
+			msg := getPackageInfo(r, r, Package{Name: tt.pkg})().(packageInfoMsg)
+			if msg.err != nil {
+				t.Fatal(msg.err)
+			}
+			d, ok := parsePackageDetails(msg.info)
+			if !ok {
+				t.Fatalf("not parsed: %q", msg.info)
+			}
+			got := []string{
+				fmt.Sprintf("%s/%s %s", d.Repository, d.Name, d.Version),
+				"description: " + d.Description,
+				"url: " + d.URL,
+				fmt.Sprintf("licenses: %v", d.Licenses),
+				fmt.Sprintf("provides: %v", d.Provides),
+				fmt.Sprintf("depends: %v", d.Depends),
+				fmt.Sprintf("optdepends: [%s]", strings.Join(d.OptDepends, "|")),
+				fmt.Sprintf("makedepends: %v", d.MakeDepends),
+				fmt.Sprintf("conflicts: %v", d.Conflicts),
+				fmt.Sprintf("sizes: %s %s", d.InstalledSize, d.DownloadSize),
+				"packager: " + d.Packager,
+				fmt.Sprintf("aur: %s %s %s %s", d.Votes, d.Popularity, d.LastModified, d.OutOfDate),
+			}
+			for i := range tt.want {
+				if got[i] != tt.want[i] {
+					t.Errorf("got %q, want %q", got[i], tt.want[i])
+				}
+			}
+		})
+	}
+	if _, ok := parsePackageDetails("error: package 'nope' was not found\n"); ok {
+		t.Error("parsed an error as package info")
+	}
+}
+
+func TestParseUpdateLine(t *testing.T) {
+	tests := []struct {
+		name string
+		line string
+		want string // "name old -> new ignored", empty when the line is skipped
+	}{
+		{"checkupdates", "linux 6.9.1.arch1-1 -> 6.9.2.arch1-1", "linux 6.9.1.arch1-1 -> 6.9.2.arch1-1 false"},
+		{"checkupdates epoch", "mesa 1:24.0.7-1 -> 1:24.1.0-1", "mesa 1:24.0.7-1 -> 1:24.1.0-1 false"},
+		{"paru -Qua", "yay 12.3.5-1 -> 12.4.2-1", "yay 12.3.5-1 -> 12.4.2-1 false"},
+		{"paru -Qua devel", "paru-git 2.0.3.r12.g3bfc6a5-1 -> latest-commit", "paru-git 2.0.3.r12.g3bfc6a5-1 -> latest-commit false"},
+		{"pacman -Qu ignored", "nvidia-dkms 550.78-1 -> 550.90.07-1 [ignored]", "nvidia-dkms 550.78-1 -> 550.90.07-1 true"},
+		{"plus in name", "libsigc++ 2.12.1-1 -> 2.12.1-2", "libsigc++ 2.12.1-1 -> 2.12.1-2 false"},
+		{"paru header", ":: Looking for devel upgrades...", ""},
+		{"pacman warning", "warning: linux: ignoring package upgrade (6.9.1.arch1-1 => 6.9.2.arch1-1)", ""},
+		{"blank", "", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			entry, ok := parseUpdateLine(tt.line)
+			got := ""
+			if ok {
+				got = fmt.Sprintf("%s %s -> %s %v", entry.pkg.Name, entry.pkg.OldVersion, entry.pkg.NewVersion, entry.ignored)
+			}
+			if got != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParsePactree(t *testing.T) {
+	tests := []struct {
+		name     string
+		args     string
+		reverse  bool
+		maxDepth int
+		output   string
+		want     []string // "depth name [provides] flags"
+	}{
+		{
+			name:     "depends",
+			args:     "-d 3 freetype2",
+			maxDepth: 2,
+			output: `freetype2
+├─brotli
+│ └─glibc
+├─bzip2
+│ ├─glibc
+│ └─bash provides sh
+├─harfbuzz
+│ ├─freetype2
+│ └─glib2
+│   └─pcre2
+└─zlib
+`,
+			want: []string{
+				"0 freetype2 children",
+				"1 brotli children",
+				"2 glibc",
+				"1 bzip2 children",
+				"2 glibc",
+				"2 bash (sh)",
+				"1 harfbuzz children",
+				"2 freetype2 cycle",
+				"2 glib2 truncated",
+				"1 zlib",
+			},
+		},
+		{
+			name:     "required by",
+			args:     "-d 2 -r glib2",
+			reverse:  true,
+			maxDepth: 1,
+			output: `glib2
+├─gtk3
+│ └─firefox
+└─harfbuzz
+`,
+			want: []string{
+				"0 glib2 children",
+				"1 gtk3 truncated",
+				"1 harfbuzz",
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fields := strings.Fields(tt.args)
+			r := &fakeRunner{outputs: map[string]fakeOutput{
+				"pactree " + tt.args: {stdout: tt.output},
+			}}
+			msg := loadDepTree(r, fields[len(fields)-1], tt.reverse, true, tt.maxDepth)().(depTreeMsg)
+			if msg.err != nil {
+				t.Fatal(msg.err)
+			}
+			var got []string
+			for _, line := range msg.lines {
+				s := fmt.Sprintf("%d %s", line.depth, line.name)
+				if line.provides != "" {
+					s += " (" + line.provides + ")"
+				}
+				if line.hasChildren {
+					s += " children"
+				}
+				if line.cycle {
+					s += " cycle"
+				}
+				if line.truncated {
+					s += " truncated"
+				}
+				got = append(got, s)
+			}
+			if strings.Join(got, "\n") != strings.Join(tt.want, "\n") {
+				t.Errorf("got\n%s\nwant\n%s", strings.Join(got, "\n"), strings.Join(tt.want, "\n"))
+			}
+		})
+	}
+}
+
+// writeLocalDB lays out a pacman local database with a desc file per package
+func writeLocalDB(tb testing.TB, descs map[string]string) string {
+	tb.Helper()
+	dir := tb.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "ALPM_DB_VERSION"), []byte("9\n"), 0o644); err != nil {
+		tb.Fatal(err)
+	}
+	for entry, desc := range descs {
+		if err := os.Mkdir(filepath.Join(dir, entry), 0o755); err != nil {
+			tb.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(dir, entry, "desc"), []byte(desc), 0o644); err != nil {
+			tb.Fatal(err)
+		}
+	}
+	return dir
+}
+
+func TestReadLocalDB(t *testing.T) {
+	dir := writeLocalDB(t, map[string]string{
+		"foo-1.0-1": "%NAME%\nfoo\n\n%VERSION%\n1.0-1\n\n%DESC%\nThe foo tool\n\n%INSTALLDATE%\n1700000000\n\n%SIZE%\n2048\n\n%DEPENDS%\nbar>=1\nvirt\n\n%OPTDEPENDS%\nbaz: extra formats\n",
+		"bar-2-1":   "%NAME%\nbar\n\n%VERSION%\n2-1\n\n%REASON%\n1\n",
+		"baz-3-1":   "%NAME%\nbaz\n\n%VERSION%\n3-1\n\n%REASON%\n1\n",
+		"prov-1-1":  "%NAME%\nprov\n\n%VERSION%\n1-1\n\n%REASON%\n1\n\n%PROVIDES%\nvirt=1\n",
+		"lone-1-1":  "%NAME%\nlone\n\n%VERSION%\n1-1\n\n%REASON%\n1\n",
+	})
+	if err := os.Mkdir(filepath.Join(dir, "broken-1-1"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+
+	packages, err := readLocalDB(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, lp := range packages {
+		got = append(got, fmt.Sprintf("%s %s explicit=%v orphan=%v", lp.Name, lp.Version, lp.Explicit, lp.Orphan))
+	}
+	want := []string{
+		"bar 2-1 explicit=false orphan=false",
+		"baz 3-1 explicit=false orphan=false",
+		"foo 1.0-1 explicit=true orphan=false",
+		"lone 1-1 explicit=false orphan=true",
+		"prov 1-1 explicit=false orphan=false",
+	}
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("got\n%s\nwant\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+	foo := packages[2]
+	if foo.Description != "The foo tool" || foo.SizeBytes != 2048 || !foo.InstallDate.Equal(time.Unix(1700000000, 0)) || !foo.Installed {
+		t.Errorf("foo = %+v", foo)
+	}
+	if prov := packages[4]; len(prov.provides) != 1 || prov.provides[0] != "virt" {
+		t.Errorf("prov provides %v, want [virt]", prov.provides)
+	}
+
+	if _, err := readLocalDB(filepath.Join(dir, "missing")); err == nil {
+		t.Error("missing database read without error")
+	}
+}
+
+func TestIsVirtualPackage(t *testing.T) {
+	m := testModel(modeInstall)
+	m.setRepoPackages([]Package{{Name: "glibc", Source: "core", Installed: true}}, map[string]bool{"yay": true})
+	m.installedProvides = map[string]bool{"libc.so": true}
+
+	m.infoForPackage = "glibc"
+	for name, want := range map[string]bool{"glibc": false, "yay": false, "libc.so": true, "sh": true} {
+		if got := m.isVirtualPackage(name); got != want {
+			t.Errorf("isVirtualPackage(%q) = %v, want %v", name, got, want)
+		}
+	}
+
+	// Unknown dependencies of AUR packages may be unresolved AUR packages
+	m.infoForPackage = "yay"
+	if m.isVirtualPackage("go-bin") {
+		t.Error("unknown dependency of an AUR package reported virtual")
+	}
+	if !m.isVirtualPackage("libc.so") {
+		t.Error("installed provide of an AUR package's dependency not reported virtual")
+	}
+}
+
+func TestFindFileOwnersEndsOptions(t *testing.T) {
+	r := &fakeRunner{outputs: map[string]fakeOutput{
+		"pacman -Qqo -- -Syu": {stdout: "pacman\n"},
+	}}
+	msg := findFileOwners(r, "-Syu", 1)().(fileOwnerMsg)
+	if msg.err != nil || len(msg.packages) != 1 || msg.packages[0].Name != "pacman" {
+		t.Errorf("got %+v, calls %v", msg, r.calls)
+	}
+}
+
+func TestParsePacmanConfDBPath(t *testing.T) {
+	for conf, want := range map[string]string{
+		"[options]\nHoldPkg = pacman\n":                      "",
+		"[options]\nDBPath = /srv/pacman/db/  # moved\n":     "/srv/pacman/db/",
+		"[options]\n#DBPath = /commented\n":                  "",
+		"[core]\nDBPath = /not/options\n":                    "",
+		"[options]\nDBPath=/a\n[extra]\nServer = http://x\n": "/a",
+	} {
+		got, err := parsePacmanConfDBPath(strings.NewReader(conf))
+		if err != nil || got != want {
+			t.Errorf("%q: got %q, %v, want %q", conf, got, err, want)
+		}
+	}
+}
+
+// BenchmarkInstalledPackages compares reading the local database with
+// parsing the same packages from pacman -Qi. The fixture runs leave out the
+// time pacman itself takes to print them; on a system with pacman the
+// system runs compare the whole of both paths.
+func BenchmarkInstalledPackages(b *testing.B) {
+	const n = 2000
+	descs := make(map[string]string, n)
+	var qi strings.Builder
+	for i := 0; i < n; i++ {
+		name := fmt.Sprintf("pkg%d", i)
+		descs[name+"-1-1"] = fmt.Sprintf("%%NAME%%\n%s\n\n%%VERSION%%\n1-1\n\n%%DESC%%\nPackage %d\n\n%%INSTALLDATE%%\n1700000000\n\n%%SIZE%%\n4096\n\n%%REASON%%\n1\n\n%%DEPENDS%%\npkg%d\n", name, i, (i+1)%n)
+		fmt.Fprintf(&qi, "Name            : %s\nVersion         : 1-1\nDescription     : Package %d\nDepends On      : pkg%d\nInstalled Size  : 4.00 KiB\nInstall Reason  : Installed as a dependency for another package\nInstall Date    : Tue 14 Nov 2023 10:13:20 PM UTC\n\n", name, i, (i+1)%n)
+	}
+	dir := writeLocalDB(b, descs)
+	r := &fakeRunner{outputs: map[string]fakeOutput{"pacman -Sl": {}, "pacman -Qm": {}}}
+
+	b.Run("local db", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			if packages, err := readLocalDB(dir); err != nil || len(packages) != n {
+				b.Fatalf("read %d packages: %v", len(packages), err)
+			}
+		}
+	})
+	b.Run("pacman -Qi", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			if packages, _ := parseInstalledPackages(r, qi.String()); len(packages) != n {
+				b.Fatalf("parsed %d packages", len(packages))
+			}
+		}
+	})
+
+	b.Run("system local db", func(b *testing.B) {
+		if _, err := exec.LookPath("pacman"); err != nil {
+			b.Skip("no pacman")
+		}
+		for i := 0; i < b.N; i++ {
+			if _, err := readLocalDB(filepath.Join(pacmanDBDir(), "local")); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+	b.Run("system pacman -Qi", func(b *testing.B) {
+		if _, err := exec.LookPath("pacman"); err != nil {
+			b.Skip("no pacman")
+		}
+		for i := 0; i < b.N; i++ {
+			out, _, err := execRunner{}.Run("pacman", "-Qi")
+			if err != nil {
+				b.Fatal(err)
+			}
+			parseInstalledPackages(execRunner{}, out)
+		}
+	})
+}
+
+func TestParsePacmanConfRepos(t *testing.T) {
+	conf := `[options]
+HoldPkg = pacman glibc
+
+[core]
+Include = /etc/pacman.d/mirrorlist
+
+  [extra]  # indented
+Include = /etc/pacman.d/mirrorlist
+
+#[multilib]
+#Include = /etc/pacman.d/mirrorlist
+
+[chaotic-aur]
+Include = /etc/pacman.d/chaotic-mirrorlist
+`
+	repos, err := parsePacmanConfRepos(strings.NewReader(conf))
+	if err != nil || fmt.Sprint(sortedKeys(repos)) != "[chaotic-aur core extra]" {
+		t.Errorf("repos %v, %v", repos, err)
+	}
+}
+
+func TestApplySigLevel(t *testing.T) {
+	for _, tc := range []struct {
+		value string
+		want  sigLevel
+		err   bool
+	}{
+		{"", defaultSigLevel, false},
+		{"Never", sigLevel{"Never", "TrustedOnly", "Never", "TrustedOnly"}, false},
+		{"Optional TrustAll", sigLevel{"Optional", "TrustAll", "Optional", "TrustAll"}, false},
+		{"PackageNever", sigLevel{"Never", "TrustedOnly", "Optional", "TrustedOnly"}, false},
+		{"DatabaseRequired PackageTrustAll", sigLevel{"Required", "TrustAll", "Required", "TrustedOnly"}, false},
+		{"Required  DatabaseOptional", defaultSigLevel, false},
+		{"Required Sometimes", defaultSigLevel, true},
+		{"PackageTrustMaybe", defaultSigLevel, true},
+	} {
+		got, err := applySigLevel(defaultSigLevel, tc.value)
+		if (err != nil) != tc.err || got != tc.want {
+			t.Errorf("%q: got %+v, %v; want %+v, error %v", tc.value, got, err, tc.want, tc.err)
+		}
+	}
+}
+
+func TestParsePacmanConfSigLevels(t *testing.T) {
+	conf := `[options]
+SigLevel    = Required DatabaseOptional # stock
+LocalFileSigLevel = Optional
+SigLevel = PackageTrustAll
+
+[core]
+Include = /etc/pacman.d/mirrorlist
+
+[chaotic-aur]
+SigLevel = PackageTrustedOnly
+Include = /etc/pacman.d/chaotic-mirrorlist
+
+[home]
+SigLevel = Optional TrustAll
+Server = file:///srv/repo
+
+[ugly]
+SigLevel = Never
+#SigLevel = Required
+`
+	levels, err := parsePacmanConfSigLevels(strings.NewReader(conf))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fmt.Sprint(sortedKeys(levels)) != "[chaotic-aur core home ugly]" {
+		t.Fatalf("repos %v", sortedKeys(levels))
+	}
+	for repo, want := range map[string]string{
+		"core":        "SigLevel TrustAll", // Inherits the [options] override
+		"chaotic-aur": "",
+		"home":        "SigLevel TrustAll",
+		"ugly":        "SigLevel Never",
+	} {
+		if got := levels[repo].weak(); got != want {
+			t.Errorf("%s: weak %q, want %q (%+v)", repo, got, want, levels[repo])
+		}
+	}
+	if levels["ugly"].pkgTrust != "TrustAll" || levels["home"].dbCheck != "Optional" {
+		t.Errorf("levels %+v", levels)
+	}
+
+	for _, bad := range []string{
+		"SigLevel = Never\n[core]\n",
+		"[options]\nSigLevel = Requried\n",
+		"[extra]\nSigLevel = PackageNever DatabaseSometimes\n",
+	} {
+		if _, err := parsePacmanConfSigLevels(strings.NewReader(bad)); err == nil {
+			t.Errorf("%q parsed without error", bad)
+		}
+	}
+}
+
+func TestCountThirdPartyRepos(t *testing.T) {
+	syncList := `core pacman 7.0.0-1 [installed]
+extra vim 9.1-1 [installed: 9.0-1]
+extra emacs 29.4-1
+chaotic-aur paru-bin 2.0.4-1 [installed]
+chaotic-aur yay-bin 12.4-1
+home mytool 1.0-1 [installed]
+chaotic-aur brave-bin 1.70-1 [installed: 1.69-1]
+multilib lib32-glibc 2.40-1 [installed]
+`
+	levels := map[string]sigLevel{
+		"chaotic-aur": defaultSigLevel,
+		"home":        {pkgCheck: "Never", pkgTrust: "TrustedOnly", dbCheck: "Never", dbTrust: "TrustedOnly"},
+	}
+	got := countThirdPartyRepos(syncList, levels)
+	want := []RepoCount{{Name: "chaotic-aur", Count: 2}, {Name: "home", Count: 1, Warning: "SigLevel Never"}}
+	if fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("got %+v, want %+v", got, want)
+	}
+	if got := countThirdPartyRepos("core pacman 7.0.0-1 [installed]\n", nil); len(got) != 0 {
+		t.Errorf("official only: %+v", got)
+	}
+}
+
+func TestFindToolchainAdvice(t *testing.T) {
+	aur := map[string]bool{"foo-git": true, "bar-bin": true}
+	isAUR := func(name string) bool { return aur[name] }
+	for _, tc := range []struct {
+		name    string
+		install []string
+		present map[string]bool // Prerequisites already installed or enabled
+		want    []string        // "prerequisite: packages"
+	}{
+		{"repo packages need nothing", []string{"vim", "python"}, nil, nil},
+		{"aur package needs base-devel", []string{"bar-bin"}, nil, []string{"base-devel: [bar-bin]"}},
+		{"-git aur package needs both", []string{"foo-git", "bar-bin"}, nil, []string{"base-devel: [foo-git bar-bin]", "git: [foo-git]"}},
+		{"lib32 needs multilib", []string{"lib32-glibc"}, nil, []string{"multilib: [lib32-glibc]"}},
+		{"present prerequisites are quiet", []string{"foo-git", "lib32-glibc"}, map[string]bool{"base-devel": true, "git": true, "multilib": true}, nil},
+		{"only the missing one", []string{"foo-git"}, map[string]bool{"base-devel": true}, []string{"git: [foo-git]"}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			advice := findToolchainAdvice(tc.install, isAUR, func(rule toolchainRule) bool { return tc.present[rule.prerequisite] })
+			var got []string
+			for _, a := range advice {
+				got = append(got, fmt.Sprintf("%s: %v", a.rule.prerequisite, a.packages))
+			}
+			if fmt.Sprint(got) != fmt.Sprint(tc.want) {
+				t.Errorf("got %v, want %v", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestInstallAdviceAddsPrerequisites(t *testing.T) {
+	m := testModel(modeInstall)
+	m.installedSet = map[string]bool{"git": true}
+	m.showConfirmation = true
+	m.confirmType = confirmInstall
+	m.confirmPackages = []string{"pkg0", "foo-git", "bar-bin"}
+
+	var got []string
+	for _, a := range m.installAdvice() {
+		got = append(got, fmt.Sprintf("%s: %v", a.rule.prerequisite, a.packages))
+	}
+	if fmt.Sprint(got) != "[base-devel: [foo-git bar-bin]]" {
+		t.Fatalf("advice %v", got)
+	}
+	m = press(t, m, "a")
+	if fmt.Sprint(m.confirmPackages) != "[base-devel pkg0 foo-git bar-bin]" || len(m.installAdvice()) != 0 {
+		t.Errorf("packages %v, advice %v", m.confirmPackages, m.installAdvice())
+	}
+}
+
+// labDistance is the CIE76 distance between two colors
+func labDistance(r1, g1, b1, r2, g2, b2 uint8) float64 {
+	l1, a1, bb1 := rgbToLab(r1, g1, b1)
+	l2, a2, bb2 := rgbToLab(r2, g2, b2)
+	return math.Sqrt((l1-l2)*(l1-l2) + (a1-a2)*(a1-a2) + (bb1-bb2)*(bb1-bb2))
+}
+
+func TestNearestANSI256(t *testing.T) {
+	// Every palette color maps back to itself
+	for i := 16; i < 256; i++ {
+		if got := nearestANSI256(ansi256RGB(i)); got != i {
+			t.Errorf("palette %d maps to %d", i, got)
+		}
+	}
+	for hex, want := range map[string]int{"#000000": 16, "#ffffff": 231, "#ff0000": 196, "#808080": 244, "#1e1e2e": 234} {
+		r, g, b, ok := parseHexColor(hex)
+		if !ok {
+			t.Fatalf("%s didn't parse", hex)
+		}
+		if got := nearestANSI256(r, g, b); got != want {
+			t.Errorf("%s maps to %d, want %d", hex, got, want)
+		}
+	}
+}
+
+func TestQuantizeShippedThemes(t *testing.T) {
+	for _, theme := range themes {
+		quantized := quantizeTheme(theme)
+		colors := quantized.colors()
+		for i, c := range theme.colors() {
+			got := string(*colors[i])
+			r, g, b, ok := parseHexColor(string(*c))
+			if !ok {
+				if got != string(*c) {
+					t.Errorf("%s %s: palette color %s became %s", theme.Name, themeColorNames[i], *c, got)
+				}
+				continue
+			}
+			n, err := strconv.Atoi(got)
+			if err != nil || n < 16 || n > 255 {
+				t.Errorf("%s %s: %s became %q", theme.Name, themeColorNames[i], *c, got)
+				continue
+			}
+			pr, pg, pb := ansi256RGB(n)
+			if d := labDistance(r, g, b, pr, pg, pb); d > 20 {
+				t.Errorf("%s %s: %s became %d, %.1f away", theme.Name, themeColorNames[i], *c, n, d)
+			}
+		}
+	}
+	// The shipped themes themselves are left alone
+	if c := themes[themeCatppuccinMocha].BorderColor; !strings.HasPrefix(string(c), "#") {
+		t.Errorf("quantizing changed the shipped theme: %s", c)
+	}
+}
+
+func TestANSI16FallsBackToBasic(t *testing.T) {
+	saved := currentTheme
+	defer func() {
+		ansi16Colors = false
+		theme, _ := getThemeByName(saved.Name)
+		setTheme(theme)
+	}()
+	ansi16Colors = true
+	if themes[themeCatppuccinMocha].fitsANSI16() || !themes[themeBasic].fitsANSI16() {
+		t.Fatal("fitsANSI16 misjudges the shipped themes")
+	}
+	setTheme(themeCatppuccinMocha)
+	if currentTheme.Name != "Basic" {
+		t.Errorf("16-color terminal got %s", currentTheme.Name)
+	}
+	m := testModel(modeInstall)
+	m.configPath = filepath.Join(t.TempDir(), "config.toml")
+	for range themes {
+		m.cycleTheme()
+		if !currentTheme.fitsANSI16() {
+			t.Errorf("cycled to %s", currentTheme.Name)
+		}
+	}
+}
+
+// sgrColors returns the colors set by the SGR sequences in s: "rgb" for a
+// truecolor one, otherwise the palette index
+func sgrColors(s string) []string {
+	var colors []string
+	for _, match := range regexp.MustCompile(`\x1b\[([0-9;]*)m`).FindAllStringSubmatch(s, -1) {
+		params := strings.Split(match[1], ";")
+		for i := 0; i < len(params); i++ {
+			n, _ := strconv.Atoi(params[i])
+			switch {
+			case (n == 38 || n == 48) && i+1 < len(params) && params[i+1] == "2":
+				colors = append(colors, "rgb")
+				i += 4
+			case (n == 38 || n == 48) && i+2 < len(params) && params[i+1] == "5":
+				colors = append(colors, params[i+2])
+				i += 2
+			case n >= 30 && n <= 37, n >= 40 && n <= 47:
+				colors = append(colors, strconv.Itoa(n%10))
+			case n >= 90 && n <= 97, n >= 100 && n <= 107:
+				colors = append(colors, strconv.Itoa(n%10+8))
+			}
+		}
+	}
+	return colors
+}
+
+func TestBasicThemeStaysInPalette(t *testing.T) {
+	savedProfile, savedTheme := lipgloss.ColorProfile(), currentTheme
+	t.Cleanup(func() {
+		lipgloss.SetColorProfile(savedProfile)
+		theme, _ := getThemeByName(savedTheme.Name)
+		setTheme(theme)
+	})
+	lipgloss.SetColorProfile(termenv.TrueColor)
+	setTheme(themeBasic)
+
+	basic := themes[themeBasic]
+	palette := make(map[string]bool)
+	for _, c := range basic.colors() {
+		palette[string(*c)] = true
+	}
+
+	dashboard := testModel(modeInstalled)
+	modeKeyFixture(&dashboard)
+	errorOverlay := testModel(modeUpdate)
+	errorOverlay.showErrorOverlay = true
+	errorOverlay.errorTitle = "Update Failed"
+	errorOverlay.errorMessage = "paru exited with status 1"
+	errorOverlay.errorOutput = []string{"error: failed to commit transaction (conflicting files)", "pkg0: /usr/bin/pkg0 exists in filesystem"}
+	views := map[string]model{
+		"dashboard":       dashboard,
+		"confirmation":    press(t, testModel(modeInstall), "enter"),
+		"error overlay":   errorOverlay,
+		"selection panel": press(t, testModel(modeInstall), "tab", "down", "tab", "*"),
+	}
+	for name, m := range views {
+		view := m.View()
+		if !strings.Contains(view, "\x1b[") {
+			t.Fatalf("%s rendered without color", name)
+		}
+		for _, color := range sgrColors(view) {
+			if !palette[color] {
+				t.Errorf("%s uses color %s outside the Basic palette", name, color)
+			}
+		}
+	}
+}
+
+func TestDetectColorProfile(t *testing.T) {
+	t.Setenv("COLORTERM", "truecolor")
+	if got := detectColorProfile(); got != termenv.TrueColor {
+		t.Errorf("COLORTERM=truecolor detected %v", got)
+	}
+	t.Setenv("COLORTERM", "")
+	t.Setenv("TERM", "xterm-direct")
+	if got := detectColorProfile(); got != termenv.TrueColor {
+		t.Errorf("TERM=xterm-direct detected %v", got)
+	}
+}
+
+func TestReconcileInstalledUpdatesEveryList(t *testing.T) {
+	m := testModel(modeUninstall)
+	m.setRepoPackages([]Package{{Name: "pkg0", Source: "extra"}, {Name: "pkg1", Source: "extra", Installed: true}}, nil)
+	m.aurPackages = []Package{{Name: "foo-aur", Source: "aur", Installed: true}, {Name: "bar-aur", Source: "aur"}}
+	m.filtered = append([]Package{{Name: "pkg1", Source: "extra", Installed: true}}, m.aurPackages...)
+	m.installed = []Package{{Name: "pkg1", Installed: true}, {Name: "foo-aur", Installed: true}, {Name: "kept", Installed: true}}
+	m.filteredInstalled = append([]Package(nil), m.installed...)
+	m.installedSet = map[string]bool{"pkg1": true, "foo-aur": true, "kept": true}
+	m.selectedIndex = 2
+
+	next, cmd := m.Update(installedNamesMsg{names: map[string]bool{"pkg0": true, "bar-aur": true, "kept": true}})
+	m = next.(model)
+	if fmt.Sprint(sortedKeys(m.installedSet)) != "[bar-aur kept pkg0]" {
+		t.Errorf("installedSet %v", m.installedSet)
+	}
+	if !m.repoPackages[0].Installed || m.repoPackages[1].Installed {
+		t.Errorf("repo packages %+v", m.repoPackages)
+	}
+	for _, list := range [][]Package{m.aurPackages, m.filtered} {
+		for _, pkg := range list {
+			if pkg.Installed != m.installedSet[pkg.Name] {
+				t.Errorf("%s left Installed=%v", pkg.Name, pkg.Installed)
+			}
+		}
+	}
+	for _, list := range [][]Package{m.installed, m.filteredInstalled} {
+		if len(list) != 1 || list[0].Name != "kept" {
+			t.Errorf("installed list %+v", list)
+		}
+	}
+	if m.selectedIndex != 0 {
+		t.Errorf("selection %d past the list", m.selectedIndex)
+	}
+	// pkg0 and bar-aur have no details yet
+	if cmd == nil {
+		t.Error("new packages didn't reload the installed list")
+	}
+}
+
+func TestReplacementReloadsInstalled(t *testing.T) {
+	installed := func() model {
+		m := testModel(modeUninstall)
+		m.installed = []Package{{Name: "pulseaudio", Installed: true}, {Name: "kept", Installed: true}}
+		m.filteredInstalled = append([]Package(nil), m.installed...)
+		m.installedSet = map[string]bool{"pulseaudio": true, "kept": true}
+		return m
+	}
+
+	// pacman replaced pulseaudio, so the count stays the same
+	if _, cmd := installed().Update(installedNamesMsg{names: map[string]bool{"pipewire-pulse": true, "kept": true}}); cmd == nil {
+		t.Error("replacement didn't reload the installed list")
+	}
+	if _, cmd := installed().Update(installedNamesMsg{names: map[string]bool{"kept": true}}); cmd != nil {
+		t.Error("removal alone reloaded the installed list")
+	}
+}
+
+// BenchmarkInstalledChanges compares applying a transaction's result to a
+// 15k-package model with rebuilding the installed state from scratch
+func BenchmarkInstalledChanges(b *testing.B) {
+	const n = 15000
+	packages := make([]Package, n)
+	names := make(map[string]bool)
+	for i := range packages {
+		packages[i] = Package{Name: fmt.Sprintf("pkg%d", i), Source: "extra", Installed: i%8 == 0}
+		if packages[i].Installed {
+			names[packages[i].Name] = true
+		}
+	}
+	m := testModel(modeInstall)
+	m.setRepoPackages(packages, nil)
+	m.filtered = m.filtered[:0]
+	m.installed = nil
+
+	b.Run("incremental", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			m.applyInstalledChanges([]string{"pkg1"}, nil)
+			m.applyInstalledChanges(nil, []string{"pkg1"})
+		}
+	})
+	b.Run("reconcile", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			m.reconcileInstalled(names)
+		}
+	})
+	b.Run("full rebuild", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			m.setRepoPackages(packages, names)
+		}
+	})
+}
+
+func TestExpandPackageSet(t *testing.T) {
+	sets := map[string][]string{
+		"rust":    {"rustup", "cargo-edit", "@tools"},
+		"tools":   {"git", "ripgrep"},
+		"dev":     {"@rust", "@tools", "git", "neovim"},
+		"loop-a":  {"a", "@loop-b"},
+		"loop-b":  {"b", "@loop-a"},
+		"self":    {"@self"},
+		"dangles": {"x", "@missing"},
+	}
+	for _, tc := range []struct {
+		set  string
+		want string
+		err  string
+	}{
+		{set: "tools", want: "[git ripgrep]"},
+		{set: "rust", want: "[rustup cargo-edit git ripgrep]"},
+		{set: "dev", want: "[rustup cargo-edit git ripgrep neovim]"}, // tools reached twice isn't a cycle
+		{set: "loop-a", err: "set cycle: loop-a -> loop-b -> loop-a"},
+		{set: "self", err: "set cycle: self -> self"},
+		{set: "dangles", err: `unknown set "missing"`},
+		{set: "nope", err: `unknown set "nope"`},
+	} {
+		got, err := expandPackageSet(sets, tc.set)
+		switch {
+		case tc.err != "" && (err == nil || err.Error() != tc.err):
+			t.Errorf("%s: error %v, want %q", tc.set, err, tc.err)
+		case tc.err == "" && (err != nil || fmt.Sprint(got) != tc.want):
+			t.Errorf("%s: got %v, %v, want %s", tc.set, got, err, tc.want)
+		}
+	}
+}
+
+func TestPackageSetConfigRoundTrip(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.toml")
+	original := `# gaur config
+holds = ["linux"] # kernel stays
+
+[sets]
+# bundles
+tools = ["git", "ripgrep"]
+
+[[cache_dirs]]
+label = "Cargo"
+path = "~/.cargo/registry"
+`
+	if err := os.WriteFile(path, []byte(original), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := addConfigTableEntry(path, "sets", "rust", formatTOMLStringArray([]string{"cargo-edit", "rustup"})); err != nil {
+		t.Fatal(err)
+	}
+	if err := saveConfigValues(path, map[string]string{"holds": formatTOMLStringArray([]string{"linux", "mesa"})}); err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, kept := range []string{"# gaur config", "# kernel stays", "# bundles"} {
+		if !strings.Contains(string(data), kept) {
+			t.Errorf("lost %q:\n%s", kept, data)
+		}
+	}
+	config, _, err := loadConfig(path)
+	if err != nil {
+		t.Fatalf("%v:\n%s", err, data)
+	}
+	if fmt.Sprint(config.Sets) != "map[rust:[cargo-edit rustup] tools:[git ripgrep]]" ||
+		fmt.Sprint(config.Holds) != "[linux mesa]" || len(config.CacheDirs) != 1 || config.CacheDirs[0].Label != "Cargo" {
+		t.Errorf("read back sets %v, holds %v, cache dirs %+v:\n%s", config.Sets, config.Holds, config.CacheDirs, data)
+	}
+
+	// A config without a sets table gets one
+	fresh := filepath.Join(t.TempDir(), "config.toml")
+	if err := addConfigTableEntry(fresh, "sets", "tools", formatTOMLStringArray([]string{"git"})); err != nil {
+		t.Fatal(err)
+	}
+	if config, _, err := loadConfig(fresh); err != nil || fmt.Sprint(config.Sets) != "map[tools:[git]]" {
+		t.Errorf("fresh config sets %v, %v", config.Sets, err)
+	}
+}
+
+func TestConfirmPackageSet(t *testing.T) {
+	m := testModel(modeInstall)
+	m.installedSet = map[string]bool{"pkg0": true}
+	m.packageSets = map[string][]string{"mix": {"pkg0", "pkg1", "not-in-repos", "bad name"}}
+	next, _ := m.confirmPackageSet("mix")
+	m = next.(model)
+	if !m.showConfirmation || fmt.Sprint(m.confirmPackages) != "[pkg1 not-in-repos]" ||
+		fmt.Sprint(m.confirmSkipped) != "[pkg0]" || fmt.Sprint(m.confirmUnknown) != "[not-in-repos bad name]" {
+		t.Errorf("install %v, skipped %v, unknown %v", m.confirmPackages, m.confirmSkipped, m.confirmUnknown)
+	}
+}
+
+// fakeAUR answers AUR RPC info requests with the given packages
+func fakeAUR(t *testing.T, packages ...aurPackageInfo) {
+	t.Helper()
+	saved := aurRPC
+	aurRPC = &aurClient{
+		http: &http.Client{Transport: roundTripFunc(func(req *http.Request) (*http.Response, error) {
+			asked := make(map[string]bool)
+			for _, name := range req.URL.Query()["arg[]"] {
+				asked[name] = true
+			}
+			var results []string
+			for _, pkg := range packages {
+				if asked[pkg.Name] {
+					result, err := json.Marshal(pkg)
+					if err != nil {
+						return nil, err
+					}
+					results = append(results, string(result))
+				}
+			}
+			body := `{"results":[` + strings.Join(results, ",") + `]}`
+			return &http.Response{StatusCode: http.StatusOK, Body: io.NopCloser(strings.NewReader(body)), Header: make(http.Header)}, nil
+		})},
+		cache: make(map[string]aurSearchCacheEntry),
+	}
+	t.Cleanup(func() { aurRPC = saved })
+}
+
+func TestCheckAURDependenciesResolvesProvides(t *testing.T) {
+	fakeAUR(t,
+		aurPackageInfo{Name: "foo", PackageBase: "foo", Maintainer: "someone",
+			Depends: []string{"java-runtime>=17", "libfoo.so=1-64", "gone-dep", "installed-virt", "bar"}},
+		aurPackageInfo{Name: "bar", PackageBase: "bar", Maintainer: "someone"},
+	)
+	r := &fakeRunner{outputs: map[string]fakeOutput{
+		// pacman -T prints the deps no installed package satisfies
+		"pacman -T java-runtime libfoo.so gone-dep installed-virt": {stdout: "java-runtime\nlibfoo.so\ngone-dep\n", err: errors.New("exit status 127")},
+		"pacman -Sp --noconfirm --print-format %n gone-dep java-runtime libfoo.so": {
+			stderr: "error: target not found: gone-dep\n",
+			err:    errors.New("exit status 1"),
+		},
+	}}
+	msg := checkAURDependencies(r, []string{"foo"}, nil, 3)().(aurDependencyCheckMsg)
+	if msg.err != nil || msg.seq != 3 {
+		t.Fatalf("err %v seq %d", msg.err, msg.seq)
+	}
+	var got []string
+	for _, issue := range msg.issues["foo"] {
+		got = append(got, strings.Join(issue.chain, " -> ")+" "+issue.problem)
+	}
+	if fmt.Sprint(got) != "[foo -> gone-dep missing]" || len(msg.issues) != 1 {
+		t.Errorf("issues %v (calls %v)", msg.issues, r.calls)
+	}
+}
+
+func TestStaleAURDependencyCheckDropped(t *testing.T) {
+	m := testModel(modeUpdate)
+	m.aurDepChecking = true
+	m.aurDepCheckSeq = 2
+	stale := map[string][]aurDependencyIssue{"foo": {{chain: []string{"foo"}, problem: "orphaned"}}}
+	next, _ := m.Update(aurDependencyCheckMsg{issues: stale, seq: 1})
+	m = next.(model)
+	if !m.aurDepChecking || m.aurDepIssues != nil {
+		t.Fatalf("stale check applied: checking %v issues %v", m.aurDepChecking, m.aurDepIssues)
+	}
+	next, _ = m.Update(aurDependencyCheckMsg{issues: stale, seq: 2})
+	m = next.(model)
+	if m.aurDepChecking || len(m.aurDepIssues) != 1 {
+		t.Errorf("current check dropped: checking %v issues %v", m.aurDepChecking, m.aurDepIssues)
+	}
+}
+
+func TestCheckUnmanagedForeign(t *testing.T) {
+	useHelper(t, "paru")
+	t.Setenv("HOME", t.TempDir())
+	for _, clone := range []string{"has-clone", "split-base", "cloned-local"} {
+		if err := os.MkdirAll(filepath.Join(paruCloneDir(), clone), 0o755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	fakeAUR(t,
+		aurPackageInfo{Name: "has-clone", PackageBase: "has-clone"},
+		aurPackageInfo{Name: "split-a", PackageBase: "split-base"},
+		aurPackageInfo{Name: "in-aur", PackageBase: "in-aur"},
+		aurPackageInfo{Name: "split-b", PackageBase: "split-base-2"},
+	)
+	r := &fakeRunner{outputs: map[string]fakeOutput{
+		"pacman -Qmq": {stdout: "has-clone\nsplit-a\nin-aur\nsplit-b\nlocal-only\ncloned-local\nmarked\n"},
+	}}
+
+	msg := checkUnmanagedForeign(r, map[string]bool{"marked": true})().(unmanagedCheckMsg)
+	if msg.err != nil {
+		t.Fatal(msg.err)
+	}
+	// Clone by name or package base: managed. In the AUR without a clone:
+	// adoptable. Neither: local, unless already marked.
+	var got []string
+	for _, pkg := range msg.packages {
+		got = append(got, fmt.Sprintf("%s base=%s aur=%v", pkg.name, pkg.pkgBase, pkg.inAUR))
+	}
+	want := "[in-aur base=in-aur aur=true split-b base=split-base-2 aur=true local-only base=local-only aur=false]"
+	if fmt.Sprint(got) != want {
+		t.Errorf("got %v\nwant %s", got, want)
+	}
+}
+
+func TestLocalPackagesRoundTrip(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "local-packages")
+	if err := saveLocalPackages(path, map[string]bool{"zeta": true, "alpha": true}); err != nil {
+		t.Fatal(err)
+	}
+	if data, _ := os.ReadFile(path); string(data) != "alpha\nzeta\n" {
+		t.Errorf("saved %q", data)
+	}
+	if local, restored := loadLocalPackages(path); restored || fmt.Sprint(sortedKeys(local)) != "[alpha zeta]" {
+		t.Errorf("loaded %v, restored %v", local, restored)
+	}
+	if local, _ := loadLocalPackages(filepath.Join(t.TempDir(), "missing")); len(local) != 0 {
+		t.Errorf("missing file loaded %v", local)
+	}
+}
+
+func TestMarkUnmanagedAsLocal(t *testing.T) {
+	t.Setenv("XDG_STATE_HOME", t.TempDir())
+	m := testModel(modeInstalled)
+	m.showUnmanaged = true
+	m.localPackages = make(map[string]bool)
+	m.unmanaged = []unmanagedPackage{{name: "mine", pkgBase: "mine"}, {name: "theirs", pkgBase: "theirs", inAUR: true}}
+
+	// Adopting needs the package in the AUR
+	m = press(t, m, "a")
+	if !strings.Contains(m.statusMessage, "not in the AUR") {
+		t.Errorf("adopting a local package: %q", m.statusMessage)
+	}
+	m = press(t, m, "l")
+	if len(m.unmanaged) != 1 || m.unmanaged[0].name != "theirs" || !m.localPackages["mine"] {
+		t.Errorf("unmanaged %+v, local %v", m.unmanaged, m.localPackages)
+	}
+	if local, _ := loadLocalPackages(localPackagesPath()); !local["mine"] {
+		t.Errorf("saved local packages %v", local)
+	}
+}
+
+func TestHelpTextFitsWidth(t *testing.T) {
+	useColor(t)
+	active := map[viewMode][]string{
+		modeInstall:   {"[i]nstall", "[i]"},
+		modeInstalled: {"i[n]fo", "[n]"},
+		modeUninstall: {"[r]emove", "[r]"},
+		modeUpdate:    {"[u]pdate", "[u]"},
+		modeHistory:   {"[h]istory", "[h]"},
+	}
+	for _, width := range []int{40, 60, 80, 120} {
+		for mode, labels := range active {
+			m := testModel(mode)
+			help := m.renderHelpText(modeColors[mode], width)
+			plain := ansiEscape.ReplaceAllString(help, "")
+			if strings.Contains(help, "\n") || lipgloss.Width(help) > width {
+				t.Errorf("width %d, mode %v: %q is %d columns", width, mode, plain, lipgloss.Width(help))
+			}
+			if !strings.Contains(plain, labels[0]) && !strings.Contains(plain, labels[1]) {
+				t.Errorf("width %d: active mode %s missing from %q", width, labels[0], plain)
+			}
+			// The active label is the highlighted one
+			if !strings.Contains(help, lipgloss.NewStyle().Foreground(modeColors[mode]).Bold(true).Render(labels[0])) &&
+				!strings.Contains(help, lipgloss.NewStyle().Foreground(modeColors[mode]).Bold(true).Render(labels[1])) {
+				t.Errorf("width %d: %s isn't highlighted in %q", width, labels[0], help)
+			}
+		}
+	}
+	// Wide enough for everything, nothing is dropped
+	if plain := ansiEscape.ReplaceAllString(testModel(modeInstall).renderHelpText(modeColors[modeInstall], 120), ""); !strings.Contains(plain, "[/] search") || !strings.Contains(plain, "[q]uit") {
+		t.Errorf("120 columns dropped hints: %q", plain)
+	}
+}
+
+func TestFooterStaysOneLine(t *testing.T) {
+	for _, width := range []int{60, 80, 120} {
+		for _, mode := range []viewMode{modeInstall, modeInstalled, modeUninstall, modeUpdate, modeHistory} {
+			m := testModel(mode)
+			m.width = width
+			m.filteredHistory = nil // testModel has no transactions behind them
+			lines := strings.Split(m.View(), "\n")
+			// A wrapped footer would push the panel's bottom border up a line
+			if border := ansiEscape.ReplaceAllString(lines[len(lines)-2], ""); !strings.HasPrefix(strings.TrimSpace(border), "╰") {
+				t.Errorf("width %d, mode %v: line above the footer is %q, not a panel border", width, mode, border)
+			}
+			if footer := lines[len(lines)-1]; lipgloss.Width(footer) > width {
+				t.Errorf("width %d, mode %v: footer %q is %d columns", width, mode, footer, lipgloss.Width(footer))
+			}
+		}
+	}
+}
+
+func TestFindUnusedPackages(t *testing.T) {
+	now := time.Date(2026, 6, 1, 0, 0, 0, 0, time.UTC)
+	used := map[string]time.Time{
+		"/usr/bin/old":      now.AddDate(0, -6, 0),
+		"/usr/bin/old-help": now.AddDate(0, -5, 0),
+		"/usr/bin/mixed":    now.AddDate(-1, 0, 0),
+		"/usr/bin/mixed2":   now.AddDate(0, 0, -1),
+		"/usr/bin/fresh":    now,
+	}
+	fileList := `old /usr/bin/
+old /usr/bin/old
+old /usr/bin/old-help
+old /usr/share/doc/old/README
+mixed /usr/bin/mixed
+mixed /usr/bin/mixed2
+fresh /usr/bin/fresh
+libonly /usr/lib/libonly.so
+gone /usr/bin/gone
+`
+	lastUsed := func(path string) (time.Time, bool) {
+		t, ok := used[path]
+		return t, ok
+	}
+	got := findUnusedPackages(fileList, lastUsed, now.AddDate(0, 0, -unusedDays))
+	if len(got) != 1 || !got["old"].Equal(now.AddDate(0, -5, 0)) {
+		t.Errorf("unused %v", got)
+	}
+}
+
+func TestMountHasOption(t *testing.T) {
+	mounts := `/dev/nvme0n1p2 / btrfs rw,noatime,compress=zstd 0 0
+/dev/nvme0n1p3 /usr ext4 rw,relatime 0 0
+/dev/nvme0n1p4 /usrx ext4 rw,noatime 0 0
+`
+	for path, want := range map[string]bool{"/usr/bin": false, "/usr": false, "/home": true, "/usrx/bin": true} {
+		if got := mountHasOption(mounts, path, "noatime"); got != want {
+			t.Errorf("%s: noatime %v, want %v", path, got, want)
+		}
+	}
+}
+
+func TestStaleCleanupDataIgnored(t *testing.T) {
+	m := testModel(modeInstalled)
+	m = press(t, m, "M")
+	stale := m.cleanupSeq
+	m = press(t, m, "esc")
+	m = press(t, m, "M")
+	if !m.showCleanup || m.cleanupSeq == stale {
+		t.Fatalf("wizard shown %v, seq %d", m.showCleanup, m.cleanupSeq)
+	}
+
+	items := map[cleanupStep][]cleanupItem{cleanupStepOrphans: {{name: "stale"}}}
+	updated, _ := m.Update(cleanupDataMsg{items: items, seq: stale})
+	m = updated.(model)
+	if m.cleanup.items != nil {
+		t.Fatalf("stale load filled the wizard: %v", m.cleanup.items)
+	}
+	items = map[cleanupStep][]cleanupItem{cleanupStepOrphans: {{name: "fresh"}}}
+	updated, _ = m.Update(cleanupDataMsg{items: items, seq: m.cleanupSeq})
+	m = updated.(model)
+	if got := m.cleanupStepItems(); len(got) != 1 || got[0].name != "fresh" {
+		t.Errorf("items %v", got)
+	}
+}
+
+func TestValidateCleanCommand(t *testing.T) {
+	for _, tc := range []struct {
+		command string
+		ok      bool
+	}{
+		{"cargo cache -a", true},
+		{"rm -rf /home/u/.cache/thing/old", true},
+		{"find /home/u/.cache/thing -delete", true},
+		{"tool --dir=/home/u/.cache/thing", true},
+		{"  ", false},
+		{"rm -rf /home/u/.cache/thing/*", false},
+		{"cargo cache -a; rm -rf ~", false},
+		{"echo $HOME", false},
+		{"rm -rf /home/u", false},
+		{"rm -rf /home/u/.cache/thing/../..", false},
+		{"tool --dir=/tmp", false},
+		{`sh -c "rm -rf /"`, false},
+	} {
+		err := validateCleanCommand(tc.command, "/home/u/.cache/thing")
+		if (err == nil) != tc.ok {
+			t.Errorf("%q: error %v", tc.command, err)
+		}
+	}
+}
+
+func TestUnmonitoredPackageCacheCounted(t *testing.T) {
+	m := testModel(modeInstalled)
+	m.cacheDirs = []CacheDir{
+		{Label: "Pacman", Path: "/var/cache/pacman/pkg", PackageManager: true},
+		{Label: "Paru", Path: "/home/u/.cache/paru", PackageManager: true},
+		{Label: "Cargo", Path: "/home/u/.cargo/registry"},
+		{Label: "Pip", Path: "/home/u/.cache/pip"},
+	}
+	m.monitoredCaches = []string{"Pacman", "Cargo"}
+	var labels []string
+	for _, dir := range m.dashboardCacheDirs() {
+		labels = append(labels, dir.Label)
+	}
+	if fmt.Sprint(labels) != "[Pacman Paru Cargo]" {
+		t.Errorf("sized %v", labels)
+	}
+}
+
+func TestAURInfoFetchConcurrency(t *testing.T) {
+	var mu sync.Mutex
+	inFlight, peak, requests := 0, 0, 0
+	saved := aurRPC
+	aurRPC = &aurClient{
+		http: &http.Client{Transport: roundTripFunc(func(req *http.Request) (*http.Response, error) {
+			mu.Lock()
+			inFlight++
+			requests++
+			peak = max(peak, inFlight)
+			mu.Unlock()
+			time.Sleep(20 * time.Millisecond)
+			mu.Lock()
+			inFlight--
+			mu.Unlock()
+			var results []string
+			for _, name := range req.URL.Query()["arg[]"] {
+				if !strings.HasSuffix(name, "-gone") {
+					results = append(results, fmt.Sprintf(`{"Name":%q}`, name))
+				}
+			}
+			body := `{"results":[` + strings.Join(results, ",") + `]}`
+			return &http.Response{StatusCode: http.StatusOK, Body: io.NopCloser(strings.NewReader(body)), Header: make(http.Header)}, nil
+		})},
+		cache: make(map[string]aurSearchCacheEntry),
+	}
+	t.Cleanup(func() { aurRPC = saved })
+	aurRPC.setConcurrency(3)
+
+	var names []string
+	for i := 0; i < aurInfoBatchSize*7; i++ {
+		names = append(names, fmt.Sprintf("pkg%d", i))
+	}
+	names = append(names, "old-gone")
+	resolver := &aurInfoResolver{known: make(map[string]*aurPackageInfo)}
+	if err := resolver.fetch(names); err != nil {
+		t.Fatal(err)
+	}
+	if requests != 8 || peak != 3 {
+		t.Errorf("%d requests, %d at once; want 8 and 3", requests, peak)
+	}
+	if len(resolver.known) != len(names) || resolver.known["pkg0"] == nil || resolver.known["old-gone"] != nil {
+		t.Errorf("known %d of %d", len(resolver.known), len(names))
+	}
+}
+
+func TestPrefetchConcurrencySetting(t *testing.T) {
+	s := defaultSettings()
+	for _, tc := range []struct {
+		value int
+		ok    bool
+	}{{0, false}, {1, true}, {maxPrefetchConcurrency, true}, {maxPrefetchConcurrency + 1, false}} {
+		s.PrefetchConcurrency = tc.value
+		if _, err := validateSettings(s); (err == nil) != tc.ok {
+			t.Errorf("%d: error %v", tc.value, err)
+		}
+	}
+	if got := defaultSettings().adjustSetting("prefetch_concurrency", 1).settingValue("prefetch_concurrency"); got != "5" {
+		t.Errorf("stepped to %s", got)
+	}
+}
+
+func TestSessionSummaryNeedsAnOperation(t *testing.T) {
+	warnings := []string{"An interrupted pacman transaction is still unresolved"}
+	if got := renderSessionSummary(nil, warnings, 1<<20, false); got != "" {
+		t.Errorf("summary without operations:\n%s", got)
+	}
+	ops := []sessionOperation{{operation: confirmInstall, packages: []string{"vim"}, duration: 3 * time.Second}}
+	got := renderSessionSummary(ops, warnings, 1<<20, false)
+	for _, want := range []string{"gaur session summary", "1 run(s), 1 package(s), 3s", "1.0 MiB freed", warnings[0]} {
+		if !strings.Contains(got, want) {
+			t.Errorf("summary lacks %q:\n%s", want, got)
+		}
+	}
+}
+
+func TestReadLogTail(t *testing.T) {
+	dir := t.TempDir()
+	var log strings.Builder
+	for i := 0; i < 20000; i++ { // Several chunks
+		fmt.Fprintf(&log, "line %d\n", i)
+	}
+	for _, tc := range []struct {
+		content string
+		n       int
+		want    []string
+	}{
+		{log.String(), 3, []string{"line 19997", "line 19998", "line 19999"}},
+		{"a\nb\n", 5, []string{"a", "b"}},
+		{"a\nb", 1, []string{"b"}},
+		{"", 5, nil},
+	} {
+		path := filepath.Join(dir, "operations.log")
+		if err := os.WriteFile(path, []byte(tc.content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		got, err := readLogTail(path, tc.n)
+		if err != nil || strings.Join(got, "|") != strings.Join(tc.want, "|") {
+			t.Errorf("%d bytes, n=%d: got %q, %v, want %q", len(tc.content), tc.n, got, err, tc.want)
+		}
+	}
+	if _, err := readLogTail(filepath.Join(dir, "missing"), 5); err == nil {
+		t.Error("missing log read without error")
+	}
+}
+
+func TestExecProcessIsLogged(t *testing.T) {
+	logPath := filepath.Join(t.TempDir(), "operations.log")
+	f, err := openOperationLog(logPath, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	savedLog, savedPath := opLog, opLogPath
+	t.Cleanup(func() { opLog, opLogPath = savedLog, savedPath })
+
+	logExec(exec.Command("sudo", "pacman", "-Sy"), time.Now(), errors.New("exit status 1"))
+	f.Close()
+	data, err := os.ReadFile(logPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{"msg=command", "name=sudo", `args="pacman -Sy"`, `error="exit status 1"`} {
+		if !strings.Contains(string(data), want) {
+			t.Errorf("log lacks %s:\n%s", want, data)
+		}
+	}
+}
+
+func TestHistoryLabelsRebuildBatches(t *testing.T) {
+	logPath := filepath.Join(t.TempDir(), "operations.log")
+	f, err := openOperationLog(logPath, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	savedLog, savedPath := opLog, opLogPath
+	t.Cleanup(func() { opLog, opLogPath = savedLog, savedPath })
+
+	at := func(clock string) time.Time {
+		stamp, err := time.Parse(time.RFC3339, "2026-05-01T"+clock+"Z")
+		if err != nil {
+			t.Fatal(err)
+		}
+		return stamp
+	}
+	// logOperation stamps the end with the current time
+	for _, batch := range []struct {
+		label   string
+		started time.Time
+		err     error
+	}{
+		{"1/2", at("10:00:00"), nil},
+		{"2/2", at("10:05:00"), errors.New("exit status 1")},
+	} {
+		logOperation(execCompleteMsg{operation: confirmRebuildForeign, packages: []string{"foo-git", "bar bin"}, started: batch.started, batch: batch.label, err: batch.err})
+	}
+	f.Close()
+
+	logFile, err := os.Open(logPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer logFile.Close()
+	ops := parseOperationLog(logFile)
+	if len(ops) != 2 || ops[0].name != "Foreign Rebuild" || ops[0].batch != "1/2" || ops[0].failed || !ops[1].failed {
+		t.Fatalf("ops %+v", ops)
+	}
+	// Pin the ends so the windows don't depend on when the test ran
+	ops[0].end, ops[1].end = at("10:04:00"), at("10:09:00")
+
+	pacmanLog := `[2026-05-01T09:00:00+0000] [ALPM] transaction started
+[2026-05-01T09:00:01+0000] [ALPM] upgraded vim (9.0-1 -> 9.1-1)
+[2026-05-01T09:00:02+0000] [ALPM] transaction completed
+[2026-05-01T10:03:00+0000] [ALPM] transaction started
+[2026-05-01T10:03:01+0000] [ALPM] reinstalled foo-git (r10-1)
+[2026-05-01T10:03:02+0000] [ALPM] transaction completed
+[2026-05-01T10:08:00+0000] [ALPM] transaction started
+[2026-05-01T10:08:01+0000] [ALPM] reinstalled baz-bin (2-1)
+[2026-05-01T10:08:02+0000] [ALPM] transaction failed
+[2026-05-01T11:00:00+0000] [ALPM] transaction started
+[2026-05-01T11:00:01+0000] [ALPM] installed htop (3.3-1)
+[2026-05-01T11:00:02+0000] [ALPM] transaction completed
+`
+	transactions, err := parsePacmanHistory(strings.NewReader(pacmanLog))
+	if err != nil {
+		t.Fatal(err)
+	}
+	annotateHistory(transactions, ops)
+	var got []string
+	for _, tx := range transactions {
+		got = append(got, tx.operation)
+	}
+	want := []string{"", "Foreign Rebuild, batch 1/2", "Foreign Rebuild, batch 2/2 (failed)", ""}
+	if fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("labels %q, want %q", got, want)
+	}
+}
+
+func TestParseLogfmt(t *testing.T) {
+	fields := parseLogfmt(`time=2026-05-01T10:00:00.000Z level=INFO msg=operation type="Foreign Rebuild" packages="a \"b\"" exit_code=0`)
+	if fields["type"] != "Foreign Rebuild" || fields["packages"] != `a "b"` || fields["exit_code"] != "0" || fields["level"] != "INFO" {
+		t.Errorf("fields %q", fields)
+	}
+}
+
+func TestSessionStatsScrollStops(t *testing.T) {
+	useHelper(t, "yay")
+	m := testModel(modeInstall)
+	m.height = 24
+	var names []string
+	for i := 0; i < 300; i++ {
+		names = append(names, fmt.Sprintf("package-%d", i))
+	}
+	m.sessionOps = []sessionOperation{{operation: confirmInstall, packages: names}}
+	m = press(t, m, ".")
+	lines, visible := m.sessionStatsLines(m.width-4, m.height-4)
+	if len(lines) <= visible {
+		t.Fatalf("%d lines all fit in %d", len(lines), visible)
+	}
+	for i := 0; i < len(lines)+10; i++ {
+		m = press(t, m, "j")
+	}
+	if m.sessionStatsOffset != len(lines)-visible {
+		t.Errorf("offset %d, want %d", m.sessionStatsOffset, len(lines)-visible)
+	}
+	m = press(t, m, "k")
+	if m.sessionStatsOffset != len(lines)-visible-1 {
+		t.Errorf("one up: offset %d", m.sessionStatsOffset)
+	}
+	if view := m.View(); !strings.Contains(view, "Time in yay") {
+		t.Errorf("helper time label missing:\n%s", view)
+	}
+}
+
+func TestHydrateFollowsResultsWindow(t *testing.T) {
+	m := testModel(modeInstall)
+	m.filtered = nil
+	for i := 0; i < 200; i++ {
+		m.filtered = append(m.filtered, Package{Name: fmt.Sprintf("aur%d", i), Source: "aur"})
+	}
+	m.selectedIndex, m.resultsStart = 0, 0
+	if m.hydrateResultWindow() == nil || len(m.aurHydrating) != aurHydrateWindow || !m.aurHydrating["aur0"] {
+		t.Fatalf("first window: requested %d", len(m.aurHydrating))
+	}
+	if m.hydrateResultWindow() != nil {
+		t.Error("requested the pending window again")
+	}
+
+	_, resultsHeight := m.mainLayout()
+	m.selectedIndex = 120
+	m.resultsStart, _ = resultsWindow(m.selectedIndex, len(m.filtered), resultsHeight, m.resultsStart)
+	if m.hydrateResultWindow() == nil || !m.aurHydrating[m.filtered[m.resultsStart].Name] || len(m.aurHydrating) != 2*aurHydrateWindow {
+		t.Fatalf("scrolled window from %d: requested %d", m.resultsStart, len(m.aurHydrating))
+	}
+
+	// An answer clears the pending names, found or not
+	var names []string
+	for name := range m.aurHydrating {
+		names = append(names, name)
+	}
+	updated, _ := m.Update(aurInfoHydratedMsg{names: names, infos: map[string]*aurPackageInfo{"aur0": {Name: "aur0"}}})
+	m = updated.(model)
+	if len(m.aurHydrating) != 0 || m.aurInfoCache["aur0"] == nil {
+		t.Errorf("pending %d after the answer", len(m.aurHydrating))
+	}
+}
+
+func TestAURClientRejectsErrorStatus(t *testing.T) {
+	for _, tc := range []struct {
+		status int
+		body   string
+		want   string
+	}{
+		{http.StatusTooManyRequests, `{"error":"Too many package info requests"}`, "aur rpc: 429 Too Many Requests: Too many package info requests"},
+		{http.StatusBadGateway, `<html>bad gateway</html>`, "aur rpc: 502 Bad Gateway"},
+		{http.StatusServiceUnavailable, `{"results":[{"Name":"stale"}]}`, "aur rpc: 503 Service Unavailable"},
+	} {
+		client := &aurClient{http: &http.Client{Transport: roundTripFunc(func(*http.Request) (*http.Response, error) {
+			return &http.Response{StatusCode: tc.status, Status: fmt.Sprintf("%d %s", tc.status, http.StatusText(tc.status)), Body: io.NopCloser(strings.NewReader(tc.body)), Header: make(http.Header)}, nil
+		})}}
+		results, err := client.info(context.Background(), []string{"stale"})
+		if err == nil || err.Error() != tc.want || results != nil {
+			t.Errorf("%d: got %v, %v; want %q", tc.status, results, err, tc.want)
+		}
+	}
+}
+
+func TestPackageDetailsFollowInfo(t *testing.T) {
+	m := testModel(modeInstall)
+	m.setPackageInfo("Name            : vim\nURL             : https://www.vim.org\nDepends On      : glibc  gpm\n")
+	if !m.packageDetailsOK || m.packageDetails.URL != "https://www.vim.org" || len(m.packageDetails.dependencyTokens()) != 2 {
+		t.Fatalf("details %+v", m.packageDetails)
+	}
+	m.setPackageInfo("Failed to load package info")
+	if m.packageDetailsOK || m.packageDetails.Name != "" {
+		t.Errorf("stale details %+v", m.packageDetails)
+	}
+}
+
+func TestFindBuildFailures(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		log     string
+		line    int
+		pattern string
+	}{
+		{"gcc", `==> Making package: foo 1.2-1 (Sat 10 May 2026 10:00:00 AM UTC)
+==> Starting build()...
+checking for gcc... gcc
+checking whether the C compiler works... yes
+make[1]: Entering directory '/home/user/.cache/paru/clone/foo/src/foo-1.2/src'
+gcc -DHAVE_CONFIG_H -I. -march=x86-64 -O2 -Werror=format-security -c -o file.o file.c
+file.c: In function 'parse_args':
+file.c:12:5: error: 'count' undeclared (first use in this function)
+   12 |     count++;
+      |     ^~~~~
+make[1]: *** [Makefile:412: file.o] Error 1
+make[1]: Leaving directory '/home/user/.cache/paru/clone/foo/src/foo-1.2/src'
+make: *** [Makefile:360: all-recursive] Error 1
+==> ERROR: A failure occurred in build().
+    Aborting...`, 7, "compiler error"},
+		{"rustc", `==> Starting build()...
+   Compiling libc v0.2.155
+   Compiling bar v0.3.0 (/home/user/.cache/paru/clone/bar/src/bar-0.3.0)
+warning: unused import: ` + "`std::io`" + `
+error[E0425]: cannot find value ` + "`config`" + ` in this scope
+  --> src/main.rs:41:9
+   |
+41 |         config.load()?;
+   |         ^^^^^^ not found in this scope
+
+For more information about this error, try ` + "`rustc --explain E0425`" + `.
+error: could not compile ` + "`bar`" + ` (bin "bar") due to 1 previous error
+==> ERROR: A failure occurred in build().`, 4, "rust error"},
+		{"cmake", `==> Starting build()...
+-- The C compiler identification is GNU 14.1.1
+-- Detecting C compiler ABI info - done
+-- Could NOT find PkgConfig (missing: PKG_CONFIG_EXECUTABLE)
+CMake Error at CMakeLists.txt:42 (find_package):
+  By not providing "FindQt6.cmake" in CMAKE_MODULE_PATH this project has
+  asked CMake to find a package configuration file provided by "Qt6", but
+  CMake did not find one.
+-- Configuring incomplete, errors occurred!
+==> ERROR: A failure occurred in build().`, 4, "cmake error"},
+		{"checksum", `==> Making package: baz 2.0-1 (Sat 10 May 2026 10:00:00 AM UTC)
+==> Retrieving sources...
+  -> Downloading baz-2.0.tar.gz...
+==> Validating source files with sha256sums...
+    baz-2.0.tar.gz ... FAILED
+    baz.service ... Passed
+==> ERROR: One or more files did not pass the validity check!`, 4, "checksum mismatch"},
+	} {
+		matches := findBuildFailures(strings.Split(tc.log, "\n"))
+		if len(matches) == 0 {
+			t.Errorf("%s: no failure found", tc.name)
+			continue
+		}
+		if got := matches[0]; got.line != tc.line || got.pattern != tc.pattern {
+			t.Errorf("%s: first match line %d (%s), want line %d (%s)", tc.name, got.line, got.pattern, tc.line, tc.pattern)
+		}
+	}
+}
+
+func TestRecoveryVerifiesFiles(t *testing.T) {
+	m := testModel(modeInstall)
+	m.recoveryFindings = []recoveryFinding{{kind: "corrupt", pkgName: "glibc", detail: "desc missing"}}
+	m.showRecovery = true
+	if view := ansiEscape.ReplaceAllString(m.View(), ""); !strings.Contains(view, "[v] verify files") {
+		t.Errorf("recovery actions lack the integrity check:\n%s", view)
+	}
+	next, cmd := m.Update(keyMsgFor("v"))
+	if m = next.(model); m.showRecovery || cmd == nil {
+		t.Errorf("v didn't start the integrity check: open %v, cmd %v", m.showRecovery, cmd != nil)
+	}
+
+	next, _ = m.Update(execCompleteMsg{operation: confirmIntegrityCheck})
+	if got := next.(model).statusMessage; got != "Integrity check passed" {
+		t.Errorf("status after a clean check = %q", got)
+	}
+}